@@ -0,0 +1,231 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package devcontainer brings devcontainers up and down from Go code,
+// going through the same parse, build, create, and lifecycle pipeline
+// as the brig CLI.
+package devcontainer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+
+	"github.com/nlsantos/brig/internal/brig"
+	"github.com/nlsantos/brig/trill"
+	"github.com/nlsantos/brig/writ"
+)
+
+// AppName is the name the pipeline goes by; it determines, e.g., the
+// cache directory features are downloaded into, which is shared with
+// the brig CLI.
+const AppName = "brig"
+
+// Options holds the settings for bringing a devcontainer up.
+//
+// Most of them mirror one of the brig CLI's flags.
+type Options struct {
+	ConfigPath                string         // Path to the devcontainer.json to use; required
+	OverrideConfigPath        string         // Path to a JSON/JSONC file to deep-merge over the devcontainer.json; see writ.Parser.ApplyOverlay
+	WorkspaceFolder           string         // The workspace on the host; defaults to the working directory
+	SocketAddr                string         // URI to the Podman/Docker socket; searched for if empty
+	Platform                  trill.Platform // Target platform for the container; defaults to linux/amd64
+	PortOffset                uint16         // Number to offset privileged ports by; defaults to brig.PrivilegedPortOffset
+	CloneInVolume             bool           // If true, the workspace is copied into a named volume instead of being bind-mounted
+	SyncBack                  bool           // If true, Down copies the workspace volume's contents back to the host (with CloneInVolume)
+	IgnoreUpdateRemoteUserUID bool           // If true, updateRemoteUserUID is always treated as false
+	CACertificates            string         // Path to a PEM bundle of CA certificates to trust in the devcontainer (e.g., that of a TLS-intercepting proxy)
+	IgnoreProxy               bool           // If true, the proxy settings in the environment (e.g., HTTPS_PROXY) aren't propagated into image builds and containers
+	Userns                    string         // User namespace mode for the devcontainer (e.g., keep-id or auto); if empty, the host user is mapped onto remoteUser. See trill.ParseUsernsMode
+	SELinuxRelabel            string         // Whether the workspace bind mount is relabeled for SELinux (auto, shared, private, or off); defaults to auto. See trill.ParseWorkspaceRelabel
+	PropagateLocale           bool           // If true, the host's locale settings (LANG, LANGUAGE, and LC_*) are passed on to the devcontainer, unless customizations.brig says otherwise
+	PropagateTimezone         bool           // If true, the host's timezone is passed on to the devcontainer as TZ, unless customizations.brig says otherwise
+	KeepOnFailure             bool           // If true, resources are left in place if a Compose deployment fails partway through
+	Rebuild                   bool           // If true, images are rebuilt even if their build inputs haven't changed
+	SkipBuild                 bool           // If true, images are only built if they don't exist
+	SkipPull                  bool           // If true, images are only pulled if they don't exist
+	QuietLifecycle            bool           // If true, the output of lifecycle commands is only shown if they fail
+	SuppressOutput            bool           // If true, image build/pull progress isn't printed out
+	Stdout                    io.Writer      // Receives the output of lifecycle commands; defaults to os.Stdout
+	Stderr                    io.Writer      // Receives the error output of lifecycle commands; defaults to os.Stderr
+
+	// Handlers for tool-specific customizations (e.g.,
+	// customizations.vscode), keyed by tool name; they're run once
+	// Features have been resolved. brig's own (customizations.brig)
+	// can't be replaced.
+	CustomizationsHandlers map[string]writ.CustomizationsHandler
+}
+
+// Devcontainer is a devcontainer brought up by Up.
+type Devcontainer struct {
+	ID                 string                   // The devcontainer's stable ID (i.e., ${devcontainerId}); unlike ContainerID, it stays the same across runs
+	ContainerID        string                   // ID of the devcontainer (in a Compose project, of the container named in the service field)
+	ContainerName      string                   // Name of the devcontainer, unless it's part of a Compose project
+	ImageTag           string                   // Tag of the image the devcontainer was created from, unless it's part of a Compose project
+	ComposeProjectName string                   // Name of the Compose project, if the devcontainer is part of one
+	Config             *writ.DevcontainerConfig // The parsed devcontainer.json
+
+	cmd    *brig.Command
+	parser *writ.DevcontainerParser
+}
+
+// ErrNoConfigPath is returned by Up if Options.ConfigPath is empty.
+var ErrNoConfigPath = errors.New("no devcontainer.json specified")
+
+// Up brings the devcontainer described by opts.ConfigPath up: it
+// builds or pulls its image(s), creates its container(s), and runs
+// its lifecycle hooks, leaving it running.
+//
+// If Up fails, whatever it did manage to bring up is torn down before
+// it returns. Otherwise, call Down once done with the devcontainer.
+//
+// Errors wrap the sentinel errors of the package they originate from
+// (e.g., writ.ErrSchemaValidation or trill.ErrImageBuild), so they can
+// be told apart with errors.Is.
+func Up(ctx context.Context, opts Options) (*Devcontainer, error) {
+	if len(opts.ConfigPath) == 0 {
+		return nil, ErrNoConfigPath
+	}
+	if _, err := trill.ParseUsernsMode(opts.Userns); err != nil {
+		return nil, err
+	}
+	if _, err := trill.ParseWorkspaceRelabel(opts.SELinuxRelabel); err != nil {
+		return nil, err
+	}
+
+	parser, err := writ.NewDevcontainerParser(opts.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	parser.LocalWorkspaceFolder = opts.WorkspaceFolder
+	if len(opts.OverrideConfigPath) > 0 {
+		if err = parser.ApplyOverlay(opts.OverrideConfigPath); err != nil {
+			return nil, err
+		}
+	}
+	if err = parser.Validate(); err != nil {
+		return nil, err
+	}
+	if err = parser.Parse(); err != nil {
+		return nil, err
+	}
+	for tool, handler := range opts.CustomizationsHandlers {
+		parser.RegisterCustomizationsHandler(tool, handler)
+	}
+
+	cmd := newCommand(opts)
+	if err = cmd.Connect(opts.SocketAddr); err != nil {
+		return nil, errors.Join(err, cmd.Close())
+	}
+
+	d := &Devcontainer{
+		ID:     *parser.DevcontainerID,
+		Config: &parser.Config,
+		cmd:    cmd,
+		parser: parser,
+	}
+	if err = cmd.Up(ctx, parser); err != nil {
+		slog.Error("encountered an error bringing up the devcontainer; tearing it down", "error", err)
+		return nil, errors.Join(err, d.Down(ctx))
+	}
+
+	summary := cmd.Summary()
+	d.ContainerID = cmd.Client().ContainerID
+	d.ContainerName = summary.ContainerName
+	d.ImageTag = summary.ImageTag
+	d.ComposeProjectName = summary.ComposeProjectName
+	return d, nil
+}
+
+// Exec runs a command in the devcontainer, returning once it exits.
+//
+// Unless set in opts, the command runs as the devcontainer's
+// remoteUser. The devcontainer's remoteEnv is always applied, with the
+// variables in opts.Env layered on top of it. A non-zero exit code is
+// reported as a *trill.ExecExitError.
+func (d *Devcontainer) Exec(ctx context.Context, opts trill.ExecOptions, args ...string) error {
+	if len(opts.User) == 0 {
+		opts.User = *d.Config.RemoteUser
+	}
+	env := maps.Clone(d.Config.RemoteEnv)
+	if env == nil {
+		env = writ.EnvVarMap{}
+	}
+	if opts.Env != nil {
+		maps.Copy(env, *opts.Env)
+	}
+	opts.Env = &env
+	return d.cmd.Client().StreamExecInDevcontainer(ctx, opts, args...)
+}
+
+// ForwardedPorts returns the ports the devcontainer has published on
+// the host.
+func (d *Devcontainer) ForwardedPorts(ctx context.Context) ([]trill.PublishedPort, error) {
+	return d.cmd.Client().InspectPublishedPorts(ctx, d.ContainerID)
+}
+
+// Down tears the devcontainer (or the Compose project it's a part
+// of) down and releases the connection to Podman/Docker.
+//
+// The Devcontainer can't be used afterwards.
+func (d *Devcontainer) Down(ctx context.Context) error {
+	return errors.Join(d.cmd.Down(ctx, d.parser), d.cmd.Close())
+}
+
+// newCommand maps opts onto the options of the pipeline the brig CLI
+// runs.
+func newCommand(opts Options) *brig.Command {
+	cmd := brig.New(AppName, "")
+	cmd.Options.CACertificates = opts.CACertificates
+	cmd.Options.CloneInVolume = opts.CloneInVolume
+	cmd.Options.IgnoreProxy = opts.IgnoreProxy
+	cmd.Options.IgnoreUpdateRemoteUserUID = opts.IgnoreUpdateRemoteUserUID
+	cmd.Options.KeepOnFailure = opts.KeepOnFailure
+	cmd.Options.PlatformArch = opts.Platform.Architecture
+	cmd.Options.PlatformOS = opts.Platform.OS
+	cmd.Options.PortOffset = opts.PortOffset
+	cmd.Options.PropagateLocale = opts.PropagateLocale
+	cmd.Options.PropagateTimezone = opts.PropagateTimezone
+	cmd.Options.QuietLifecycle = opts.QuietLifecycle
+	cmd.Options.Rebuild = opts.Rebuild
+	cmd.Options.SELinuxRelabel = opts.SELinuxRelabel
+	cmd.Options.SkipBuild = opts.SkipBuild
+	cmd.Options.SkipPull = opts.SkipPull
+	cmd.Options.SyncBack = opts.SyncBack
+	cmd.Options.Userns = opts.Userns
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	cmd.SuppressOutput = opts.SuppressOutput
+
+	// There's no terminal to attach; Up returns once the lifecycle
+	// hooks have run
+	cmd.Options.NoAttach = true
+	// Nor is there anyone to ask which port to use in place of one
+	// that's in use
+	cmd.Options.PortConflict = brig.PortConflictNext
+
+	if len(cmd.Options.PlatformArch) == 0 {
+		cmd.Options.PlatformArch = "amd64"
+	}
+	if len(cmd.Options.PlatformOS) == 0 {
+		cmd.Options.PlatformOS = "linux"
+	}
+	if cmd.Options.PortOffset == 0 {
+		cmd.Options.PortOffset = brig.PrivilegedPortOffset
+	}
+	return cmd
+}
@@ -0,0 +1,47 @@
+package devcontainer
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/nlsantos/brig/internal/brig"
+	"github.com/nlsantos/brig/trill"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUpRejectsBadConfig checks that Up fails before reaching out to
+// Podman/Docker if there's no usable devcontainer.json.
+func TestUpRejectsBadConfig(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	_, err := Up(context.Background(), Options{})
+	assert.ErrorIs(t, err, ErrNoConfigPath)
+
+	invalidConfig := filepath.Join("..", "writ", "testdata", "validate", "invalid-forward-ports.json")
+	_, err = Up(context.Background(), Options{ConfigPath: invalidConfig})
+	assert.ErrorIs(t, err, writ.ErrSchemaValidation)
+}
+
+// TestNewCommand checks that Options are carried over to the
+// pipeline, with the same defaults as the CLI.
+func TestNewCommand(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cmd := newCommand(Options{SkipPull: true, SuppressOutput: true})
+	assert.True(t, cmd.Options.NoAttach)
+	assert.True(t, cmd.Options.SkipPull)
+	assert.True(t, cmd.SuppressOutput)
+	assert.Equal(t, "amd64", cmd.Options.PlatformArch)
+	assert.Equal(t, "linux", cmd.Options.PlatformOS)
+	assert.Equal(t, brig.PrivilegedPortOffset, cmd.Options.PortOffset)
+
+	cmd = newCommand(Options{Platform: trill.Platform{Architecture: "arm64", OS: "linux"}, PortOffset: 10000})
+	assert.Equal(t, "arm64", cmd.Options.PlatformArch)
+	assert.EqualValues(t, 10000, cmd.Options.PortOffset)
+}
@@ -17,28 +17,40 @@
 package brig
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/MakeNowJust/heredoc"
+	"github.com/fatih/color"
 	"github.com/go-git/go-git/v6"
-	"github.com/golang-cz/devslog"
-	"github.com/nlsantos/brig/internal/trill"
+	"github.com/moby/moby/api/types/network"
+	"github.com/nlsantos/brig/trill"
 	"github.com/nlsantos/brig/writ"
 	"github.com/pborman/options"
-	"golang.org/x/sync/errgroup"
 	"golang.org/x/term"
 )
 
 // ExitCode is a list of numeric exit codes used by brig
 type ExitCode uint
 
-// Exiting brig returns one of these values to the shell
+// Exiting brig returns one of these values to the shell.
+//
+// New values are only ever added at the end, so that the numbers stay
+// stable for scripts that check for them.
 const (
 	ExitNormal ExitCode = iota
 	ExitError
@@ -48,6 +60,14 @@ const (
 	ExitNoDevcJSONFound
 	ExitTooManyDevJSONFound
 	ExitUnsupportedConfiguration
+	ExitEngineUnreachable
+	ExitImageBuildFailed
+	ExitImagePullFailed
+	ExitContainerStartFailed
+	ExitLifecycleCommandFailed
+	ExitFeaturesFailed
+	ExitPanic
+	ExitWorkspaceLocked
 )
 
 // ImageTagPrefix is the default prefix used for the tag of images
@@ -61,6 +81,21 @@ const ImageTagPrefix = "localhost/devc--"
 // translated as (53 + PortElevationFactor) before binding.
 const PrivilegedPortOffset uint16 = 8000
 
+// Supported values for --port-conflict
+const (
+	PortConflictNext   = "next"   // Publish on the next free port
+	PortConflictPrompt = "prompt" // Ask which port to publish on
+	PortConflictFail   = "fail"   // Fail to bring the devcontainer up
+)
+
+// EnginePingTimeout is the maximum amount of time brig waits for the
+// Podman/Docker engine to respond before giving up on it.
+const EnginePingTimeout = 10 * time.Second
+
+// TeardownTimeout is the maximum amount of time brig spends cleaning
+// up the resources it created before exiting.
+const TeardownTimeout = 30 * time.Second
+
 // StandardDevcontainerJSONPatterns is a list of paths and globs where
 // devcontainer.json files could reside.
 //
@@ -91,183 +126,307 @@ type Command struct {
 	Arguments []string
 	Options   struct {
 		Help                      options.Help  `getopt:"-h --help display this help message"`
+		BuildFeatures             bool          `getopt:"--build-features install Features when building the image instead of in the running container"`
+		CACertificates            string        `getopt:"--ca-certificates=PATH PEM bundle of CA certificates to trust in the devcontainer, and when installing Features at build time"`
+		CacheFrom                 []string      `getopt:"--cache-from=IMAGE image to use as build cache, in addition to build.cacheFrom; can be repeated"`
+		CacheTo                   string        `getopt:"--cache-to=IMAGE push the devcontainer's image to IMAGE after building it, for use as build cache"`
+		CloneInVolume             bool          `getopt:"--clone-in-volume copy the workspace into a named volume instead of bind-mounting it"`
 		Config                    options.Flags `getopt:"-c --config=PATH path to rc file"`
+		ConfigName                string        `getopt:"--config-name=NAME use .devcontainer/NAME/devcontainer.json when there are several configurations"`
+		Context                   string        `getopt:"--context=NAME engine context to connect to, as listed by brig context ls; ignored if --socket is given"`
 		Debug                     bool          `getopt:"-d --debug enable debug messsages (implies -v)"`
+		DependencyPollInterval    time.Duration `getopt:"--dependency-poll-interval=DURATION how often to check on the devcontainer's health while waiting for it to be healthy"`
+		DependencySettleTime      time.Duration `getopt:"--dependency-settle-time=DURATION how long a service dependency has to stay up before it's considered satisfied; 0 doesn't wait"`
+		DependencyTimeout         time.Duration `getopt:"--dependency-timeout=DURATION how long to wait for a service dependency; 0 or negative waits indefinitely"`
+		Detach                    bool          `getopt:"--detach leave the devcontainer running on exit (implies --no-attach)"`
+		DetachKeys                string        `getopt:"--detach-keys=KEYS key sequence that detaches the terminal, leaving the devcontainer running; defaults to ctrl-p,ctrl-q"`
+		DotfilesInstallCommand    string        `getopt:"--dotfiles-install-command=CMD command to install dotfiles with; defaults to the first install script in the repository"`
+		DotfilesRepository        string        `getopt:"--dotfiles-repository=URL git repository to clone dotfiles from"`
+		DotfilesTargetPath        string        `getopt:"--dotfiles-target-path=PATH where dotfiles are cloned into in the devcontainer; defaults to ~/dotfiles"`
+		Exec                      string        `getopt:"--exec=CMD run CMD in the devcontainer and exit with its status (implies --no-attach)"`
+		FollowServiceLogs         bool          `getopt:"--follow-service-logs keep printing the output of the Compose services (bar the devcontainer's) once they're up"`
+		ForwardEngineSocket       bool          `getopt:"--forward-engine-socket mount the Podman/Docker socket into the devcontainer and point DOCKER_HOST at it"`
+		ForwardGitCredentials     bool          `getopt:"--forward-git-credentials answer git credential requests from the devcontainer with the host's credential helpers"`
+		ForwardGPGAgent           bool          `getopt:"--forward-gpg-agent make the host's gpg-agent available in the devcontainer"`
+		HealthTimeout             time.Duration `getopt:"--health-timeout=DURATION how long to wait for the devcontainer's healthcheck to pass before running postCreateCommand; negative waits indefinitely"`
+		HTTPProxy                 string        `getopt:"--http-proxy=URL proxy for HTTP requests made by image builds and containers; defaults to HTTP_PROXY"`
+		HTTPSProxy                string        `getopt:"--https-proxy=URL proxy for HTTPS requests made by image builds and containers; defaults to HTTPS_PROXY"`
+		IgnoreProxy               bool          `getopt:"--ignore-proxy don't propagate proxy settings into image builds and containers"`
 		IgnoreUpdateRemoteUserUID bool          `getopt:"--ignore-updateremoteuseruid always treat updateRemoteUserUID as set to false"`
+		KeepContainerfiles        bool          `getopt:"--keep-containerfiles leave the Containerfiles synthesized from Compose dockerfile_inline in place after building, for debugging"`
+		KeepOnFailure             bool          `getopt:"--keep-on-failure leave resources in place if a Compose deployment fails partway through"`
+		Lockfile                  bool          `getopt:"--lockfile record the artifacts Features resolve to in devcontainer-lock.json"`
+		LogFile                   string        `getopt:"--log-file=PATH append log messages to PATH instead of writing them to stderr"`
+		LogFormat                 string        `getopt:"--log-format=FORMAT format of log messages (text or json); defaults to text"`
+		LogLevel                  string        `getopt:"--log-level=LEVELS comma-separated log levels (debug, info, warn, or error), optionally per subsystem (brig, trill, or writ), e.g., info,trill=debug; overrides -d and -v"`
+		NestedIgnoreFiles         bool          `getopt:"--nested-ignore-files honor ignore files in subdirectories of build contexts"`
+		NoAttach                  bool          `getopt:"--no-attach run the lifecycle hooks without attaching the terminal, then exit"`
+		NoProxy                   string        `getopt:"--no-proxy=HOSTS comma-separated hosts, domains, and CIDRs reached without the proxy; defaults to NO_PROXY"`
+		Output                    string        `getopt:"--output=FORMAT write a summary of the run to stdout as FORMAT (text or json; json implies --no-attach)"`
+		OverrideConfig            string        `getopt:"--override-config=PATH JSON/JSONC file to deep-merge over devcontainer.json"`
 		PlatformArch              string        `getopt:"-a --platform-arch target architecture for the container; defaults to amd64"`
 		PlatformOS                string        `getopt:"-o --platform-os target operating system for the container; defaults to linux"`
+		PortConflict              string        `getopt:"--port-conflict=MODE what to do when a host port is in use (next, prompt, or fail); defaults to prompt in a terminal, next otherwise"`
 		PortOffset                uint16        `getopt:"-p --port-offset=UINT number to offset privileged ports by"`
+		Progress                  string        `getopt:"--progress=MODE how to show image build progress (plain, tty, or quiet); plain or tty imply showing it without -v"`
+		PropagateLocale           bool          `getopt:"--propagate-locale pass the host's locale settings (LANG, LANGUAGE, and LC_*) on to the devcontainer"`
+		PropagateTimezone         bool          `getopt:"--propagate-timezone pass the host's timezone on to the devcontainer as TZ, mounting /etc/localtime if the engine is local"`
+		Pull                      string        `getopt:"--pull=POLICY when to pull images (always, missing, or never); defaults to always, or missing with --skip-pull"`
+		QuietLifecycle            bool          `getopt:"--quiet-lifecycle only show the output of lifecycle commands if they fail"`
+		Rebuild                   bool          `getopt:"--rebuild rebuild images even if their build inputs haven't changed"`
+		RecreateNetworks          bool          `getopt:"--recreate-networks remove and recreate Compose networks left over from an earlier run instead of reusing them"`
+		Repo                      string        `getopt:"--repo=URL clone a git repository and bring up its devcontainer"`
+		RepoDir                   string        `getopt:"--repo-dir=PATH where to clone the repository given with --repo into"`
+		RepoRef                   string        `getopt:"--repo-ref=REF branch, tag, or commit to check out in the repository given with --repo"`
+		Scale                     []string      `getopt:"--scale=SERVICE=NUM run NUM replicas of a Compose service, overriding its deploy.replicas; can be repeated"`
+		SELinuxRelabel            string        `getopt:"--selinux-relabel=MODE whether the workspace bind mount is relabeled for SELinux (auto, shared, private, or off); auto relabels it as shared if SELinux is enforcing"`
+		Settings                  string        `getopt:"--settings=PATH path to a settings file to use in place of the global one"`
+		ShowInstallOrder          bool          `getopt:"--show-install-order print the order Features are installed in once they're resolved"`
+		SSH                       bool          `getopt:"--ssh run an SSH server in the devcontainer and add it to an ssh_config file"`
+		SSHConfig                 string        `getopt:"--ssh-config=PATH ssh_config file to add devcontainers to with --ssh; defaults to ~/.ssh/brig_config"`
 		SkipBuild                 bool          `getopt:"-B --skip-build skip building images unless they don't exist"`
 		SkipPull                  bool          `getopt:"-P --skip-pull skip pulling images unless they don't exist"`
 		Socket                    string        `getopt:"-s --socket=ADDR URI to the Podman/Docker socket"`
+		SyncBack                  bool          `getopt:"--sync-back copy the workspace volume's contents back to the host on exit (with --clone-in-volume)"`
+		Timings                   bool          `getopt:"--timings print how long each phase of the run took on exit"`
+		Userns                    string        `getopt:"--userns=MODE user namespace mode for the devcontainer (e.g., keep-id, keep-id:uid=1000,gid=1000, or auto); defaults to mapping the host user onto remoteUser"`
 		ValidateOnly              bool          `getopt:"-V --validate parse and validate  the config and exit immediately"`
 		Verbose                   bool          `getopt:"-v --verbose enable diagnostic messages"`
 		Version                   bool          `getopt:"--version display version information then exit"`
+		WaitForLock               bool          `getopt:"--wait-for-lock wait for other brig instances using the workspace to exit instead of failing"`
 	}
 
+	// Where the output of lifecycle commands and --exec goes; if nil,
+	// os.Stdout and os.Stderr are used
+	Stdout         io.Writer
+	Stderr         io.Writer
+	SuppressOutput bool // If true, image build/pull progress isn't printed out
+
 	appName                 string
 	appVersion              string
+	detached                bool     // Set if the terminal was detached with --detach-keys
+	execExitCode            ExitCode // Exit code of the command run via --exec, if it failed
+	caCertificates          []byte   // The bundle passed with --ca-certificates; see prepareCACertificates
 	featureArtifactsDigests *ArtifactDigest
+	featureLockfile         *FeaturesLockfile                          // The devcontainer's lockfile, if it has or is to have one; see --lockfile
 	featureParsersLookup    map[string]*writ.DevcontainerFeatureParser // Mapping of feature IDs and their parsed JSON configs
+	featureOrderOverride    []string                                   // The devcontainer's overrideFeatureInstallOrder; see ParseFeaturesConfig
+	featureMu               sync.Mutex                                 // Guards featurePathLookup and featureArtifactsDigests while Features are being retrieved
 	featurePathLookup       map[string]string
-	suppressOutput          bool
+	gitCredentialServer     *gitCredentialServer     // Answers git credential requests from the devcontainer; see --forward-git-credentials
+	parser                  *writ.DevcontainerParser // The devcontainer.json being worked on; used for diagnostics and image metadata
+	result                  Result                   // Summary of the run; see --output
+	resultMu                sync.Mutex               // Guards result
+	resultOutput            io.Writer                // Where result is written to on exit, if at all
+	settings                *Settings                // Layered structured configuration; see loadSettings
+	sshHost                 string                   // The Host alias added to the ssh_config file; see --ssh
+	sshPort                 int                      // The host port sshd is published on; see --ssh
+	timings                 *timingRecorder          // Times the phases of the run; only set with --timings
+	tracing                 *tracing                 // Exports spans over OTLP; only set if the OTEL_* environment variables ask for it
 	trillClient             *trill.Client
+	workspaceLock           *os.File // Held while the devcontainer is brought up for the workspace; see lockWorkspace
 }
 
 // NewCommand initializes the command's lifecycle
-func NewCommand(appName string, appVersion string) ExitCode {
+func NewCommand(appName string, appVersion string) (exitCode ExitCode) {
 	var err error
-	cmd := Command{
-		appName:              appName,
-		appVersion:           appVersion,
-		featureParsersLookup: make(map[string]*writ.DevcontainerFeatureParser),
-		featurePathLookup:    make(map[string]string),
-	}
-	defer cmd.SaveArtifactDigest()
+	cmd := New(appName, appVersion)
+	defer func() {
+		if err := cmd.Close(); err != nil {
+			slog.Error("encountered an error while cleaning up", "error", err)
+		}
+	}()
+
+	// Registered ahead of the teardown, so it runs after it and the
+	// document reflects the final state of the run
+	defer func() { cmd.writeResult(exitCode) }()
+	// Registered ahead of the teardown as well, so panics raised
+	// during it are caught, and the result document reflects them;
+	// ahead of parsing the options too, so panics raised there are
+	// caught as well
+	defer cmd.recoverPanic(&exitCode)
 
 	cmd.parseOptions()
+	// Registered ahead of the teardown too, so it's included
+	defer cmd.writeTimings()
 	slog.Debug("command line options parsed", "opts", cmd.Options)
 	slog.Debug("command line arguments ", "args", cmd.Arguments)
 
-	targetDevcontainerJSON := findDevcontainerJSON(cmd.Arguments)
+	if subcmdExitCode, ok := cmd.runSubcommand(); ok {
+		return subcmdExitCode
+	}
+
+	if len(cmd.Options.Repo) > 0 {
+		if len(cmd.Arguments) > 0 {
+			slog.Warn("ignoring arguments, as the devcontainer.json is looked for in the repository given with --repo", "args", cmd.Arguments)
+		}
+		workspaceDir, err := cmd.cloneRepository(context.Background())
+		if err != nil {
+			slog.Error("unable to clone the repository", "repo", cmd.Options.Repo, "error", err)
+			cmd.recordError(err)
+			return ExitError
+		}
+		cmd.Arguments = []string{workspaceDir}
+	}
+
+	targetDevcontainerJSON := cmd.findDevcontainerJSON(cmd.Arguments)
+	cmd.result.ConfigFile = targetDevcontainerJSON
 	slog.Debug("instantiating a parser for devcontainer.json", "path", targetDevcontainerJSON)
 
 	parser, err := writ.NewDevcontainerParser(targetDevcontainerJSON)
 	if err != nil {
 		slog.Error("encountered an error trying to create a devcontainer.json parser", "error", err)
+		cmd.recordError(err)
 		return ExitNonValidDevcontainerJSON
 	}
-	if err = parser.Validate(); err != nil {
+	cmd.parser = parser
+	parser.LocalWorkspaceFolder = workspaceForDevcontainerJSON(cmd.Arguments, targetDevcontainerJSON)
+	if len(cmd.Options.OverrideConfig) > 0 {
+		if err = parser.ApplyOverlay(cmd.Options.OverrideConfig); err != nil {
+			slog.Error("unable to apply the override config", "path", cmd.Options.OverrideConfig, "error", err)
+			cmd.recordError(err)
+			return ExitNonValidDevcontainerJSON
+		}
+	}
+	_, endSpan := cmd.startSpan(context.Background(), "validate devcontainer.json")
+	err = parser.Validate()
+	endSpan(err)
+	if err != nil {
 		slog.Error("devcontainer.json has syntax errors", "path", targetDevcontainerJSON, "error", err)
+		cmd.result.Error = "devcontainer.json failed schema validation"
+		cmd.result.ValidationErrors = writ.ValidationErrorMessages(err)
+		writeValidationReport(cmd.stderr(), &parser.Parser, err)
 		return ExitNonValidDevcontainerJSON
 	}
-	if err = parser.Parse(); err != nil {
+	_, endSpan = cmd.startSpan(context.Background(), "parse devcontainer.json")
+	err = parser.Parse()
+	endSpan(err)
+	if err != nil {
 		slog.Error("devcontainer.json could not be parsed", "path", targetDevcontainerJSON, "error", err)
+		cmd.recordError(err)
 		return ExitNonValidDevcontainerJSON
 	}
+	cmd.result.DevcontainerID = *parser.DevcontainerID
+	if err = cmd.addSettingsMounts(parser); err != nil {
+		slog.Error("unable to apply the mounts in the settings", "error", err)
+		cmd.recordError(err)
+		return ExitUnsupportedConfiguration
+	}
 	if cmd.Options.ValidateOnly {
 		slog.Info("devcontainer.json validated and parsed successfully", "path", targetDevcontainerJSON)
 		return ExitNormal
 	}
-	if cmd.Options.IgnoreUpdateRemoteUserUID {
-		*parser.Config.UpdateRemoteUserUID = false
-	}
 
-	socketAdddr := getSocketAddr(cmd.Options.Socket)
-	if len(socketAdddr) == 0 {
-		slog.Error("No socket address / path specified and none can be found")
-		fmt.Println("fatal: Could not determine Podman/Docker socket address. Exiting.")
-		return ExitNoSocketFound
+	if err = cmd.lockWorkspace(parser.WorkspaceFolder()); err != nil {
+		slog.Error("unable to lock the workspace", "error", err)
+		fmt.Fprintf(os.Stderr, "fatal: %s. Exiting.\n", err)
+		cmd.recordError(err)
+		return exitCodeForError(err)
 	}
 
-	cmd.trillClient = trill.NewClient(
-		socketAdddr,
-		trill.Platform{
-			Architecture: cmd.Options.PlatformArch,
-			OS:           cmd.Options.PlatformOS,
-		},
-		(trill.FeatureImageBuilder)(cmd.BuildImageWithFeatures),
-		(trill.PrivilegedPortElevator)(cmd.privilegedPortElevator),
-	)
-	defer func() {
-		if parser.Config.DockerComposeFile == nil {
-			if len(cmd.trillClient.ContainerID) > 0 {
-				cmd.trillClient.StopDevcontainer()
-			}
-		} else if err = cmd.trillClient.TeardownComposerProject(); err != nil {
-			slog.Error("encountered an error while trying to tear down the Compose project", "error", err)
+	if err = cmd.Connect(cmd.Options.Socket); err != nil {
+		if errors.Is(err, ErrNoSocketFound) {
+			fmt.Println("fatal: Could not determine Podman/Docker socket address. Exiting.")
+		} else {
+			fmt.Println("fatal: Could not reach Podman/Docker. Exiting.")
 		}
-
-		if err = cmd.trillClient.Close(); err != nil {
-			slog.Error("received an error while closing the trill client", "error", err)
-		}
-	}()
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	if err := cmd.PrepareFeaturesData(ctx, parser.Config.Features, parser.Filepath); err != nil {
-		slog.Error("encountered an error while trying to prepare features", "error", err)
-		return ExitError
-	}
-	if err := cmd.ParseFeaturesConfig(ctx, parser, parser.Config.Features); err != nil {
-		slog.Error("encountered an error while trying to parsing feature config(s)", "error", err)
-		return ExitError
+		cmd.recordError(err)
+		return exitCodeForError(err)
 	}
-	slog.Info("utilizing resolved features", "featurePathLookup", cmd.featurePathLookup)
 
-	eg, egCtx := errgroup.WithContext(ctx)
-	eg.Go(func() error {
-		defer cancel()
-		return cmd.lifecycleHandler(egCtx, eg, parser)
+	// Ctrl-C or a SIGTERM cancels ctx, which aborts any in-flight API
+	// calls and has the deferred teardown below kick in
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	context.AfterFunc(ctx, func() {
+		// Restore the default behavior so that a second signal
+		// terminates brig immediately, should cleanup get stuck
+		stopSignals()
 	})
-	eg.Go(func() (err error) {
-		imageName := createImageTagBase(parser)
-		var imageTag string
-		switch {
-		case parser.Config.DockerFile != nil && len(*parser.Config.DockerFile) > 0:
-			imageTag = fmt.Sprintf("%s%s", ImageTagPrefix, imageName)
-			if err = cmd.trillClient.BuildDevcontainerImage(parser, imageTag, cmd.Options.SkipBuild, cmd.suppressOutput); err != nil {
-				slog.Error("encountered an error while trying to build an image based on devcontainer.json", "error", err)
-				return err
-			}
-			if len(parser.Config.Features) > 0 {
-				// Use the .devcontainer directory as the context path
-				contextPath := filepath.Dir(parser.Filepath)
-				if err = cmd.BuildImageWithFeatures(contextPath, imageTag, imageTag); err != nil {
-					slog.Error("encountered an error while trying to build a feature-integrated image", "error", err)
-					return err
-				}
-			}
-			if err = cmd.trillClient.StartDevcontainerContainer(parser, imageTag, imageName); err != nil {
-				slog.Error("encountered an error while trying to start the devcontainer", "error", err)
-				return err
-			}
-
-		case parser.Config.DockerComposeFile != nil && len(*parser.Config.DockerComposeFile) > 0:
-			slog.Warn("SUPPORT FOR COMPOSER PROJECTS IS INCOMPLETE")
-			invalidProjectNamePattern := regexp.MustCompile("[^a-zA-Z0-9_-]")
-			// Replace non-valid characters for Composer project names
-			// with an underscore
-			projName := invalidProjectNamePattern.ReplaceAllString(imageName, "_")
-			if err = cmd.trillClient.DeployComposerProject(parser, projName, ImageTagPrefix, cmd.Options.SkipBuild, cmd.Options.SkipPull, cmd.suppressOutput); err != nil {
-				slog.Error("encountered an error while trying to build a Compose project", "error", err)
-			}
-
-		case parser.Config.Image != nil && len(*parser.Config.Image) > 0:
-			imageTag = *parser.Config.Image
-			if len(parser.Config.Features) > 0 {
-				// Use the .devcontainer directory as the context path
-				contextPath := filepath.Dir(parser.Filepath)
-				if err = cmd.BuildImageWithFeatures(contextPath, imageTag, imageName); err != nil {
-					slog.Error("encountered an error while trying to build a feature-integrated image", "error", err)
-					return err
-				}
-				imageTag = imageName
-			} else if err = cmd.trillClient.PullContainerImage(imageTag, cmd.Options.SkipPull, cmd.suppressOutput); err != nil {
-				slog.Error("encountered an error while trying to pull an image based on devcontainer.json", "error", err)
-				return err
-			}
-
-			if err = cmd.trillClient.StartDevcontainerContainer(parser, imageTag, imageName); err != nil {
-				slog.Error("encountered an error while trying to start the devcontainer", "error", err)
-			}
 
-		default:
-			return fmt.Errorf("devcontainer.json specifies an unsupported mode of operation; exiting")
+	// Only set once everything's up, so that --detach doesn't leave
+	// a half-deployed devcontainer behind
+	leaveRunning := false
+	defer func() {
+		if ctx.Err() != nil {
+			slog.Warn("interrupted; cleaning up")
 		}
-		return err
-	})
+		if leaveRunning {
+			slog.Info("leaving the devcontainer running as instructed", "id", cmd.trillClient.ContainerID)
+			return
+		}
+		_, endSpan := cmd.startSpan(ctx, "tear down")
+		err := cmd.Down(ctx, parser)
+		endSpan(err)
+		if err != nil {
+			slog.Error("encountered an error while tearing down the devcontainer", "error", err)
+		}
+	}()
 
-	if err = eg.Wait(); err != nil {
-		slog.Error("errgroup encountered an error", "error", err)
-		return ExitError
+	if err = cmd.Up(ctx, parser); err != nil {
+		// Panics in the goroutines Up runs come back as errors
+		var panicErr *trill.PanicError
+		if errors.As(err, &panicErr) {
+			return cmd.reportPanic(panicErr.Value, panicErr.Stack)
+		}
+		cmd.recordError(err)
+		if cmd.execExitCode != ExitNormal {
+			slog.Info("command exited with a non-zero code", "cmd", cmd.Options.Exec, "exit-code", cmd.execExitCode)
+			return cmd.execExitCode
+		}
+		slog.Error("encountered an error bringing up the devcontainer", "error", err)
+		return exitCodeForError(err)
 	}
 
+	switch {
+	case cmd.detached:
+		fmt.Fprintf(cmd.stderr(), "Detached from %s; it's been left running.\n", devcontainerContainerName(parser))
+		leaveRunning = true
+	case *parser.Config.ShutdownAction == writ.ShutdownActionNone && !cmd.Options.NoAttach:
+		slog.Info("leaving the devcontainer running, as its shutdownAction is none")
+		leaveRunning = true
+	default:
+		leaveRunning = cmd.Options.Detach
+	}
 	slog.Debug("exiting cleanly")
 	return ExitNormal
 }
 
+// exitCodeForError maps the class of failure err belongs to onto the
+// exit code brig reports for it, falling back to ExitError for
+// failures that don't fall into any particular class.
+func exitCodeForError(err error) ExitCode {
+	switch {
+	case err == nil:
+		return ExitNormal
+	case errors.Is(err, ErrNoSocketFound):
+		return ExitNoSocketFound
+	case errors.Is(err, ErrWorkspaceLocked):
+		return ExitWorkspaceLocked
+	case errors.Is(err, trill.ErrEngineUnreachable):
+		return ExitEngineUnreachable
+	case errors.Is(err, ErrFeatures):
+		return ExitFeaturesFailed
+	case errors.Is(err, writ.ErrSchemaValidation):
+		return ExitNonValidDevcontainerJSON
+	// Checked ahead of the others, as a failed lifecycle command can
+	// surface through the container's startup
+	case errors.Is(err, trill.ErrLifecycleHandler):
+		return ExitLifecycleCommandFailed
+	// Checked ahead of ErrImageBuild, which wraps it
+	case errors.Is(err, trill.ErrUnsupportedBuildOption), errors.Is(err, trill.ErrDependencyCycle):
+		return ExitUnsupportedConfiguration
+	case errors.Is(err, trill.ErrImageBuild):
+		return ExitImageBuildFailed
+	case errors.Is(err, trill.ErrImagePull):
+		return ExitImagePullFailed
+	case errors.Is(err, trill.ErrContainerStart), errors.Is(err, trill.ErrDevcontainerUnhealthy), errors.Is(err, trill.ErrPortInUse):
+		return ExitContainerStartFailed
+	default:
+		return ExitError
+	}
+}
+
 // Try to generate a distinct yet meaningful name for the generated
 // OCI image based on available metadata.
 //
@@ -327,6 +486,44 @@ func createImageTagBase(p *writ.DevcontainerParser) string {
 	return retval
 }
 
+// composeProjectName returns the name of the Compose project the
+// devcontainer described by p is brought up as: its image tag base
+// (see createImageTagBase), told apart from other devcontainers' by
+// its ID; see trill.ContainerName.
+func composeProjectName(p *writ.DevcontainerParser) string {
+	invalidProjectNamePattern := regexp.MustCompile("[^a-zA-Z0-9_-]")
+	// Replace non-valid characters for Composer project names with an
+	// underscore
+	return trill.ContainerName(devcontainerID(p), invalidProjectNamePattern.ReplaceAllString(createImageTagBase(p), "_"))
+}
+
+// devcontainerID returns the ID of the devcontainer described by p
+// (i.e., ${devcontainerId}), or an empty string if it hasn't been
+// parsed yet.
+func devcontainerID(p *writ.DevcontainerParser) string {
+	if p.DevcontainerID == nil {
+		return ""
+	}
+	return *p.DevcontainerID
+}
+
+// isComposeDevcontainer reports whether the devcontainer described by
+// p is part of a Compose project.
+func isComposeDevcontainer(p *writ.DevcontainerParser) bool {
+	return p.Config.DockerComposeFile != nil && len(*p.Config.DockerComposeFile) > 0
+}
+
+// devcontainerContainerName returns the name of the container the
+// devcontainer described by p runs in: either the one named after it
+// (see createImageTagBase) and its ID, or, for Compose projects, that
+// of the service it attaches to.
+func devcontainerContainerName(p *writ.DevcontainerParser) string {
+	if isComposeDevcontainer(p) && p.Config.Service != nil {
+		return trill.ContainerName("", composeProjectName(p), *p.Config.Service)
+	}
+	return trill.ContainerName(devcontainerID(p), createImageTagBase(p))
+}
+
 // findDevcontainerJSON attempts to find a suitable devcontainer.json
 // given a list of path patterns and/or plain paths.
 //
@@ -335,19 +532,25 @@ func createImageTagBase(p *writ.DevcontainerParser) string {
 //
 // If paths is empty, it attempts to find one or more valid file paths
 // using StandardDevcontainerJSONPatterns. Otherwise, paths is
-// iterated upon.
+// iterated upon; directories in it are searched using
+// StandardDevcontainerJSONPatterns as well.
+//
+// If more than one is found, --config-name picks one out by the name
+// of the subfolder it's in (e.g., python for
+// .devcontainer/python/devcontainer.json); failing that, the user is
+// asked to pick one if brig is running in a terminal.
 //
 // Returns a string if a valid devcontainers.json is found; any errors
 // encountered, it runs os.Exit() with the appropriate ExitCode value.
-func findDevcontainerJSON(paths []string) string {
+func (cmd *Command) findDevcontainerJSON(paths []string) string {
 	if len(paths) == 0 {
 		slog.Debug("iterating through standard devcontainer.json paths/patterns", "paths", StandardDevcontainerJSONPatterns)
-		return findDevcontainerJSON(StandardDevcontainerJSONPatterns)
+		return cmd.findDevcontainerJSON(StandardDevcontainerJSONPatterns)
 	}
 
 	slog.Debug("iterating through given paths/patterns looking for a devcontainer.json", "paths", paths)
 	var candidates []string
-	for _, path := range paths {
+	for _, path := range expandWorkspaceDirs(paths) {
 		matches, err := filepath.Glob(path)
 		if err != nil {
 			panic(err)
@@ -357,23 +560,43 @@ func findDevcontainerJSON(paths []string) string {
 			if _, err := os.Stat(match); err != nil {
 				continue
 			}
-			if abspath, err := filepath.Abs(path); err == nil {
+			if abspath, err := filepath.Abs(match); err == nil && !slices.Contains(candidates, abspath) {
 				candidates = append(candidates, abspath)
 			}
 		}
 	}
 
+	if len(cmd.Options.ConfigName) > 0 {
+		named := selectDevcontainerJSON(candidates, cmd.Options.ConfigName)
+		if len(named) == 0 && len(candidates) > 0 {
+			slog.Debug("no devcontainer.json candidates match the config name", "name", cmd.Options.ConfigName, "candidates", candidates)
+			fmt.Printf("None of the devcontainer configurations found are named %q; exiting.\n", cmd.Options.ConfigName)
+			os.Exit(int(ExitNoDevcJSONFound))
+		}
+		candidates = named
+	}
+
 	switch {
 	case len(candidates) == 0:
 		slog.Debug("unable to find any devcontainer.json candidates")
 		fmt.Println("Unable to find a valid devcontainer.json file to target; exiting.")
 		os.Exit(int(ExitNoDevcJSONFound))
 
+	case len(candidates) > 1 && term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stderr.Fd())):
+		slog.Debug("found multiple devcontainer.json candidates; asking which to use", "candidates", candidates)
+		picked, err := pickDevcontainerJSON(os.Stdin, os.Stderr, candidates)
+		if err != nil {
+			slog.Debug("no devcontainer.json candidate picked", "error", err)
+			os.Exit(int(ExitTooManyDevJSONFound))
+		}
+		return picked
+
 	case len(candidates) > 1:
 		slog.Debug("found multiple devcontainer.json candidates; giving up", "candidates", candidates)
 		fmt.Println(heredoc.Doc(`
 			Found multiple possible devcontainer configurations.
-			Specify one explicitly as an argument in the command line flag to continue.
+			Specify one explicitly as an argument in the command line flag,
+			or by the name of its subfolder with --config-name, to continue.
 
 			The following paths are eligible candidates:
 		`))
@@ -389,12 +612,99 @@ func findDevcontainerJSON(paths []string) string {
 	return candidates[0]
 }
 
+// expandWorkspaceDirs returns paths, with the directories in it
+// replaced by StandardDevcontainerJSONPatterns rooted in them.
+func expandWorkspaceDirs(paths []string) []string {
+	var expanded []string
+	for _, path := range paths {
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			expanded = append(expanded, path)
+			continue
+		}
+		for _, pattern := range StandardDevcontainerJSONPatterns {
+			expanded = append(expanded, filepath.Join(path, pattern))
+		}
+	}
+	return expanded
+}
+
+// workspaceForDevcontainerJSON returns the directory among paths that
+// configPath was found in, which is then used as the workspace in
+// place of the working directory.
+//
+// Returns an empty string if configPath wasn't found by searching a
+// directory; see findDevcontainerJSON.
+func workspaceForDevcontainerJSON(paths []string, configPath string) string {
+	for _, path := range paths {
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			continue
+		}
+		dir, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(dir, configPath); err == nil && filepath.IsLocal(rel) {
+			return dir
+		}
+	}
+	return ""
+}
+
+// devcontainerConfigName returns the name a devcontainer.json goes by
+// for --config-name: the name of the subfolder of .devcontainer it's
+// in.
+//
+// Returns an empty string for ones that aren't in such a subfolder.
+func devcontainerConfigName(configPath string) string {
+	dir := filepath.Dir(configPath)
+	if filepath.Base(filepath.Dir(dir)) != ".devcontainer" {
+		return ""
+	}
+	return filepath.Base(dir)
+}
+
+// selectDevcontainerJSON returns the candidates named name; see
+// devcontainerConfigName.
+func selectDevcontainerJSON(candidates []string, name string) []string {
+	return slices.DeleteFunc(slices.Clone(candidates), func(candidate string) bool {
+		return devcontainerConfigName(candidate) != name
+	})
+}
+
+// pickDevcontainerJSON lists candidates on out, then reads which one
+// to use, by number, from in; it asks again if given anything else.
+//
+// Returns an error if in runs out before a valid answer is given.
+func pickDevcontainerJSON(in io.Reader, out io.Writer, candidates []string) (string, error) {
+	fmt.Fprintln(out, "Found multiple possible devcontainer configurations:")
+	for idx, candidate := range candidates {
+		if name := devcontainerConfigName(candidate); len(name) > 0 {
+			fmt.Fprintf(out, "  %d) %s (%s)\n", idx+1, name, candidate)
+		} else {
+			fmt.Fprintf(out, "  %d) %s\n", idx+1, candidate)
+		}
+	}
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprintf(out, "Which one should be used? [1-%d] ", len(candidates))
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return "", errors.Join(errors.New("no configuration picked"), scanner.Err())
+		}
+		choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err == nil && choice >= 1 && choice <= len(candidates) {
+			return candidates[choice-1], nil
+		}
+	}
+}
+
 // parseOptions parses the command-line options and parameters and
 // does a little housekeeping.
 func (cmd *Command) parseOptions() {
 	options.SetDisplayWidth(80)
 	options.SetHelpColumn(40)
-	options.SetParameters("<path-to-devcontainer.json>")
+	options.SetParameters("[<path-to-devcontainer.json> | completion <shell>]")
 	options.Register(&cmd.Options)
 	cmd.setFlagsFile()
 	cmd.Arguments = options.Parse()
@@ -404,26 +714,29 @@ func (cmd *Command) parseOptions() {
 		os.Exit(int(ExitNormal))
 	}
 
-	logLevel := new(slog.LevelVar)
-	switch {
-	case cmd.Options.Debug:
-		logLevel.Set(slog.LevelDebug)
-	case cmd.Options.Verbose:
-		logLevel.Set(slog.LevelInfo)
-	default:
-		logLevel.Set(slog.LevelError)
+	if cmd.Options.Timings {
+		cmd.timings = newTimingRecorder()
+	}
+
+	logLevel, err := cmd.setUpLogging()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(int(ExitErrorParsingFlags))
+	}
+	if err = cmd.setUpTracing(); err != nil {
+		slog.Error("unable to set up tracing", "error", err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(int(ExitErrorParsingFlags))
 	}
 
-	slog.SetDefault(slog.New(devslog.NewHandler(os.Stderr, &devslog.Options{
-		HandlerOptions: &slog.HandlerOptions{
-			AddSource: true,
-			Level:     logLevel,
-		},
-		NewLineAfterLog:   false,
-		NoColor:           !term.IsTerminal(int(os.Stderr.Fd())),
-		SortKeys:          true,
-		StringIndentation: true,
-	})))
+	settings, err := cmd.loadSettings(cmd.Options.Settings, projectSettingsDir(cmd.Arguments))
+	if err != nil {
+		slog.Error("unable to load settings", "error", err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(int(ExitErrorParsingFlags))
+	}
+	cmd.settings = settings
+	cmd.applySettings()
 
 	if len(cmd.Options.PlatformArch) == 0 {
 		cmd.Options.PlatformArch = "amd64"
@@ -442,7 +755,80 @@ func (cmd *Command) parseOptions() {
 		os.Exit(int(ExitUnsupportedConfiguration))
 	}
 
-	cmd.suppressOutput = logLevel.Level() > slog.LevelInfo
+	switch cmd.Options.Output {
+	case "", OutputFormatText:
+	case OutputFormatJSON:
+		// Everything else brig (and the lifecycle commands) would
+		// normally print goes to stderr, to keep stdout parseable
+		cmd.resultOutput = os.Stdout
+		os.Stdout = os.Stderr
+		color.Output = color.Error
+		cmd.Options.NoAttach = true
+	default:
+		slog.Error("unsupported output format", "format", cmd.Options.Output)
+		os.Exit(int(ExitErrorParsingFlags))
+	}
+
+	if _, err := trill.ParsePullPolicy(cmd.Options.Pull); err != nil {
+		slog.Error("unsupported pull policy", "policy", cmd.Options.Pull)
+		os.Exit(int(ExitErrorParsingFlags))
+	}
+
+	if len(cmd.Options.DetachKeys) == 0 {
+		cmd.Options.DetachKeys = trill.DefaultDetachKeys
+	} else if _, err := trill.ParseDetachKeys(cmd.Options.DetachKeys); err != nil {
+		slog.Error("unsupported detach keys", "keys", cmd.Options.DetachKeys, "error", err)
+		os.Exit(int(ExitErrorParsingFlags))
+	}
+
+	if _, err := trill.ParseUsernsMode(cmd.Options.Userns); err != nil {
+		slog.Error("unsupported user namespace mode", "mode", cmd.Options.Userns)
+		os.Exit(int(ExitErrorParsingFlags))
+	}
+
+	if len(cmd.Options.CACertificates) > 0 {
+		if _, err := readCACertificates(cmd.Options.CACertificates); err != nil {
+			slog.Error("unusable CA certificates", "path", cmd.Options.CACertificates, "error", err)
+			os.Exit(int(ExitErrorParsingFlags))
+		}
+	}
+
+	if _, err := trill.ParseWorkspaceRelabel(cmd.Options.SELinuxRelabel); err != nil {
+		slog.Error("unsupported SELinux relabeling mode", "mode", cmd.Options.SELinuxRelabel)
+		os.Exit(int(ExitErrorParsingFlags))
+	}
+
+	if _, err := trill.ParseScale(cmd.Options.Scale); err != nil {
+		slog.Error("unsupported scale", "error", err)
+		os.Exit(int(ExitErrorParsingFlags))
+	}
+
+	switch cmd.Options.PortConflict {
+	case "", PortConflictNext, PortConflictPrompt, PortConflictFail:
+	default:
+		slog.Error("unsupported port conflict mode", "mode", cmd.Options.PortConflict)
+		os.Exit(int(ExitErrorParsingFlags))
+	}
+
+	if _, err := trill.ParseBuildProgress(cmd.Options.Progress); err != nil {
+		slog.Error("unsupported build progress mode", "mode", cmd.Options.Progress)
+		os.Exit(int(ExitErrorParsingFlags))
+	}
+
+	if cmd.Options.Detach || len(cmd.Options.Exec) > 0 {
+		cmd.Options.NoAttach = true
+	}
+
+	cmd.SuppressOutput = logLevel > slog.LevelInfo
+}
+
+// imageOptions returns the settings trill uses when building or
+// pulling an image.
+func (cmd *Command) imageOptions(skipIfAvailable bool) trill.ImageOptions {
+	return trill.ImageOptions{
+		SkipIfAvailable: skipIfAvailable,
+		SuppressOutput:  cmd.SuppressOutput,
+	}
 }
 
 // privilegedPortElevator is the function called by trill when
@@ -454,6 +840,64 @@ func (cmd *Command) privilegedPortElevator(port uint16) uint16 {
 	return port + cmd.Options.PortOffset
 }
 
+// portConflictResolver is the function called by trill when a host
+// port it's about to publish a container port on is already in use.
+//
+// Depending on --port-conflict, nextFree is used in its stead, the
+// user is asked which port to use, or bringing the devcontainer up
+// fails. The port that ends up being used is reported on stderr, as
+// it's not where the devcontainer.json said it would be.
+func (cmd *Command) portConflictResolver(containerPort network.Port, hostPort uint16, nextFree uint16) (uint16, error) {
+	mode := cmd.Options.PortConflict
+	if len(mode) == 0 {
+		mode = PortConflictNext
+		if term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stderr.Fd())) {
+			mode = PortConflictPrompt
+		}
+	}
+
+	chosen := nextFree
+	switch mode {
+	case PortConflictFail:
+		return 0, fmt.Errorf("not publishing container port %s on another port with --port-conflict=%s", containerPort, PortConflictFail)
+	case PortConflictPrompt:
+		var err error
+		if chosen, err = promptPortConflict(os.Stdin, cmd.stderr(), containerPort, hostPort, nextFree); err != nil {
+			return 0, err
+		}
+	}
+	if chosen != hostPort {
+		fmt.Fprintf(cmd.stderr(), "Host port %d is in use; publishing container port %s on port %d instead.\n", hostPort, containerPort, chosen)
+	}
+	return chosen, nil
+}
+
+// promptPortConflict asks, on out, which port containerPort should be
+// published on, as hostPort is in use, and reads the answer from in.
+//
+// Answering with nothing picks nextFree; answering "n" (or nothing at
+// all, if in is closed) gives up.
+func promptPortConflict(in io.Reader, out io.Writer, containerPort network.Port, hostPort uint16, nextFree uint16) (uint16, error) {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprintf(out, "Host port %d, for container port %s, is in use. Which port should be used instead? [%d, or n to give up] ", hostPort, containerPort, nextFree)
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return 0, errors.Join(errors.New("no port picked"), scanner.Err())
+		}
+		answer := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(answer) == 0:
+			return nextFree, nil
+		case strings.EqualFold(answer, "n"):
+			return 0, errors.New("no port picked")
+		}
+		if port, err := strconv.ParseUint(answer, 10, 16); err == nil && port > 0 {
+			return uint16(port), nil
+		}
+	}
+}
+
 // setFlagsFile goes through a list of supported paths for the flags
 // file and assigns the first valid hit for parsing
 func (cmd *Command) setFlagsFile() {
@@ -0,0 +1,280 @@
+package brig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/moby/api/types/network"
+	"github.com/nlsantos/brig/trill"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExitCodeForError checks that classes of failures are mapped
+// onto their own exit codes, even when wrapped.
+func TestExitCodeForError(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.Equal(t, ExitNormal, exitCodeForError(nil))
+	assert.Equal(t, ExitError, exitCodeForError(errors.New("something else")))
+
+	cases := map[error]ExitCode{
+		trill.ErrEngineUnreachable: ExitEngineUnreachable,
+		writ.ErrSchemaValidation:   ExitNonValidDevcontainerJSON,
+		trill.ErrImageBuild:        ExitImageBuildFailed,
+		trill.ErrImagePull:         ExitImagePullFailed,
+		trill.ErrContainerStart:    ExitContainerStartFailed,
+		trill.ErrLifecycleHandler:  ExitLifecycleCommandFailed,
+		ErrWorkspaceLocked:         ExitWorkspaceLocked,
+		trill.ErrDependencyCycle:   ExitUnsupportedConfiguration,
+	}
+	for sentinel, exitCode := range cases {
+		assert.Equal(t, exitCode, exitCodeForError(fmt.Errorf("%w: %w", sentinel, errors.New("cause"))), sentinel.Error())
+	}
+
+	// A lifecycle failure takes precedence over the container
+	// startup it surfaced through
+	err := fmt.Errorf("%w: %w", trill.ErrContainerStart, trill.ErrLifecycleHandler)
+	assert.Equal(t, ExitLifecycleCommandFailed, exitCodeForError(err))
+
+	// As does an unsupported build option over the build it failed
+	err = fmt.Errorf("%w: %w", trill.ErrImageBuild, trill.ErrUnsupportedBuildOption)
+	assert.Equal(t, ExitUnsupportedConfiguration, exitCodeForError(err))
+
+	// Exit codes are part of the CLI's interface; they must not shift
+	assert.EqualValues(t, 7, ExitUnsupportedConfiguration)
+	assert.EqualValues(t, 8, ExitEngineUnreachable)
+	assert.EqualValues(t, 13, ExitFeaturesFailed)
+}
+
+// TestFindDevcontainerJSONConfigName checks that --config-name picks
+// a devcontainer.json out by the name of its subfolder, and that glob
+// matches are resolved to where they point.
+func TestFindDevcontainerJSONConfigName(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dir := t.TempDir()
+	for _, name := range []string{"node", "python"} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(dir, ".devcontainer", name), 0o755))
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, ".devcontainer", name, "devcontainer.json"), []byte("{}"), 0o644))
+	}
+	t.Chdir(dir)
+	// Resolve symlinks in the temporary directory's path (e.g., on
+	// macOS), as the candidates are made absolute from the working
+	// directory
+	dir, err := os.Getwd()
+	assert.Nil(t, err)
+
+	cmd := New("brig", "")
+	cmd.Options.ConfigName = "python"
+	assert.Equal(t, filepath.Join(dir, ".devcontainer", "python", "devcontainer.json"), cmd.findDevcontainerJSON(nil))
+	cmd.Options.ConfigName = "node"
+	assert.Equal(t, filepath.Join(dir, ".devcontainer", "node", "devcontainer.json"), cmd.findDevcontainerJSON(nil))
+
+	assert.Equal(t, "python", devcontainerConfigName("/src/.devcontainer/python/devcontainer.json"))
+	assert.Empty(t, devcontainerConfigName("/src/.devcontainer/devcontainer.json"))
+	assert.Empty(t, devcontainerConfigName("/src/.devcontainer.json"))
+	assert.Empty(t, selectDevcontainerJSON([]string{"/src/.devcontainer/devcontainer.json"}, "python"))
+}
+
+// TestPickDevcontainerJSON checks that the user is asked which of
+// several devcontainer.json files to use until they give a valid
+// answer.
+func TestPickDevcontainerJSON(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	candidates := []string{
+		"/src/.devcontainer/node/devcontainer.json",
+		"/src/.devcontainer/python/devcontainer.json",
+	}
+
+	var out bytes.Buffer
+	picked, err := pickDevcontainerJSON(strings.NewReader("0\nthree\n2\n"), &out, candidates)
+	assert.Nil(t, err)
+	assert.Equal(t, candidates[1], picked)
+	assert.Contains(t, out.String(), "  1) node (/src/.devcontainer/node/devcontainer.json)\n")
+	assert.Contains(t, out.String(), "  2) python (/src/.devcontainer/python/devcontainer.json)\n")
+	assert.Equal(t, 3, strings.Count(out.String(), "Which one should be used? [1-2]"))
+
+	_, err = pickDevcontainerJSON(strings.NewReader("5\n"), io.Discard, candidates)
+	assert.NotNil(t, err)
+}
+
+// TestPromptPortConflict checks that the user is asked which port to
+// use in place of one that's in use until they give a valid answer,
+// and that the free port is suggested.
+func TestPromptPortConflict(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	containerPort := network.MustParsePort("3000/tcp")
+	var out bytes.Buffer
+	port, err := promptPortConflict(strings.NewReader("eighty\n70000\n9090\n"), &out, containerPort, 8080, 8081)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(9090), port)
+	assert.Equal(t, 3, strings.Count(out.String(), "Host port 8080, for container port 3000/tcp, is in use. Which port should be used instead? [8081, or n to give up]"))
+
+	port, err = promptPortConflict(strings.NewReader("\n"), io.Discard, containerPort, 8080, 8081)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(8081), port)
+
+	_, err = promptPortConflict(strings.NewReader("n\n"), io.Discard, containerPort, 8080, 8081)
+	assert.NotNil(t, err)
+	_, err = promptPortConflict(strings.NewReader(""), io.Discard, containerPort, 8080, 8081)
+	assert.NotNil(t, err)
+
+	cmd := New("brig", "")
+	cmd.Options.PortConflict = PortConflictNext
+	cmd.Stderr = &out
+	port, err = cmd.portConflictResolver(containerPort, 8080, 8081)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(8081), port)
+	assert.Contains(t, out.String(), "Host port 8080 is in use; publishing container port 3000/tcp on port 8081 instead.")
+
+	cmd.Options.PortConflict = PortConflictFail
+	_, err = cmd.portConflictResolver(containerPort, 8080, 8081)
+	assert.NotNil(t, err)
+}
+
+// TestFindDevcontainerJSONInDirectory checks that directories passed
+// as arguments are searched for a devcontainer.json, and are then
+// used as the workspace.
+func TestFindDevcontainerJSONInDirectory(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Chdir(t.TempDir())
+	projectDir := filepath.Join(t.TempDir(), "myproject")
+	configPath := filepath.Join(projectDir, ".devcontainer", "devcontainer.json")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(configPath), 0o755))
+	assert.Nil(t, os.WriteFile(configPath, []byte(`{"image": "alpine"}`), 0o644))
+
+	cmd := New("brig", "")
+	found := cmd.findDevcontainerJSON([]string{projectDir})
+	assert.Equal(t, configPath, found)
+	assert.Equal(t, projectDir, workspaceForDevcontainerJSON([]string{projectDir}, found))
+
+	// Paths to the file itself leave the workspace alone
+	assert.Equal(t, configPath, cmd.findDevcontainerJSON([]string{configPath}))
+	assert.Empty(t, workspaceForDevcontainerJSON([]string{configPath}, configPath))
+	assert.Empty(t, workspaceForDevcontainerJSON([]string{t.TempDir()}, configPath))
+
+	p, err := writ.NewDevcontainerParser(found)
+	assert.Nil(t, err)
+	p.LocalWorkspaceFolder = workspaceForDevcontainerJSON([]string{projectDir}, found)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+	assert.Equal(t, projectDir, *p.Config.Context)
+}
+
+// TestCacheSpecs checks that registry cache specifications given with
+// --cache-from are put back together after being split at commas.
+func TestCacheSpecs(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.Equal(t, []string{
+		"ghcr.io/example/app:cache",
+		"type=registry,ref=ghcr.io/example/app:main",
+		"ghcr.io/example/app:dev",
+		"type=registry,ref=ghcr.io/example/app:pr",
+	}, cacheSpecs([]string{
+		"ghcr.io/example/app:cache",
+		"type=registry", "ref=ghcr.io/example/app:main",
+		" ghcr.io/example/app:dev", "",
+		"type=registry", "ref=ghcr.io/example/app:pr",
+	}))
+	assert.Nil(t, cacheSpecs(nil))
+}
+
+// TestProxyConfig checks that the proxy flags override the settings
+// in the environment, and that --ignore-proxy drops them all.
+func TestProxyConfig(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Setenv("HTTP_PROXY", "http://env-proxy:3128")
+	t.Setenv("HTTPS_PROXY", "http://env-proxy:3128")
+	t.Setenv("NO_PROXY", ".corp.example")
+
+	cmd := New("brig", "")
+	cmd.Options.HTTPSProxy = "http://flag-proxy:3129"
+	assert.Equal(t, trill.ProxyConfig{
+		HTTPProxy:  "http://env-proxy:3128",
+		HTTPSProxy: "http://flag-proxy:3129",
+		NoProxy:    ".corp.example",
+	}, cmd.proxyConfig())
+
+	cmd.Options.IgnoreProxy = true
+	assert.False(t, cmd.proxyConfig().IsSet())
+}
+
+// TestDevcontainerContainerName checks that devcontainers that would
+// otherwise be named the same (e.g., checkouts of a repository in
+// directories named alike) are given containers of their own.
+func TestDevcontainerContainerName(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var names, projectNames []string
+	for _, parent := range []string{t.TempDir(), t.TempDir()} {
+		devcontainerDir := filepath.Join(parent, "workspace", ".devcontainer")
+		assert.Nil(t, os.MkdirAll(devcontainerDir, 0o755))
+		configPath := filepath.Join(devcontainerDir, "devcontainer.json")
+		assert.Nil(t, os.WriteFile(configPath, []byte(`{"dockerComposeFile": "compose.yml", "service": "app", "workspaceFolder": "/workspace"}`), 0o644))
+		parser, err := New("brig", "").loadDevcontainerJSON([]string{configPath})
+		assert.Nil(t, err)
+
+		projectName := composeProjectName(parser)
+		assert.True(t, strings.HasPrefix(projectName, createImageTagBase(parser)+"--"), projectName)
+		assert.Equal(t, projectName+"--app", devcontainerContainerName(parser))
+		projectNames = append(projectNames, projectName)
+
+		parser.Config.DockerComposeFile = nil
+		names = append(names, devcontainerContainerName(parser))
+	}
+	assert.NotEqual(t, projectNames[0], projectNames[1])
+	assert.NotEqual(t, names[0], names[1])
+}
+
+// TestApplyOptionsDependencyTimings checks that the dependency settle
+// time and timeout are passed on to the trill client as given,
+// including an explicit 0 and negative values, and default to trill's
+// own defaults otherwise.
+func TestApplyOptionsDependencyTimings(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cases := []struct {
+		settleTime, timeout time.Duration
+	}{
+		{trill.DefaultDependencySettleTime, trill.DefaultDependencyTimeout},
+		{0, 0},
+		{-time.Second, -time.Second},
+		{time.Second, time.Minute},
+	}
+	for idx, tc := range cases {
+		cmd := New("brig", "")
+		var err error
+		cmd.trillClient, err = trill.NewClient(trill.ClientOptions{SocketAddr: "unix:///nonexistent.sock"})
+		assert.Nil(t, err)
+		if idx > 0 {
+			cmd.Options.DependencySettleTime = tc.settleTime
+			cmd.Options.DependencyTimeout = tc.timeout
+		}
+		cmd.applyOptions(&writ.DevcontainerParser{})
+		assert.Equal(t, tc.settleTime, cmd.trillClient.DependencySettleTime, "case %d", idx)
+		assert.Equal(t, tc.timeout, cmd.trillClient.DependencyTimeout, "case %d", idx)
+	}
+}
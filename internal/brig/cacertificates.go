@@ -0,0 +1,206 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nlsantos/brig/trill"
+	"github.com/nlsantos/brig/writ"
+)
+
+// caCertificatesTarget is where the bundle passed with
+// --ca-certificates is kept inside the devcontainer; NODE_EXTRA_CA_CERTS
+// is pointed at it, as Node.js doesn't use the system's trust store.
+const caCertificatesTarget string = "/usr/local/share/brig/ca-certificates.crt"
+
+// caCertificatesFileName is the name the bundle is staged under,
+// both in the devcontainer and in the build context of the image
+// Features are installed into.
+const caCertificatesFileName string = "brig-ca-certificates.crt"
+
+// caCertificatesScriptName is the name caCertificatesInstallScript is
+// written out under in the build context of the image Features are
+// installed into.
+const caCertificatesScriptName string = "brig-ca-certificates.sh"
+
+// caCertificatesBuildDir is where the bundle and
+// caCertificatesInstallScript are copied into while building the
+// image Features are installed into.
+const caCertificatesBuildDir string = "/tmp/brig-ca"
+
+// caCertificatesInstallScript adds the bundle at $BRIG_CA_CERTIFICATES
+// to the system's trust store, using whichever tool the distro ships
+// with: update-ca-certificates (Debian, Ubuntu, Alpine),
+// update-ca-trust (Fedora, RHEL), or, failing those, by appending it
+// to the usual bundle. Nothing is done if the same bundle has been
+// added already.
+//
+// Its parameters are passed in via BRIG_CA_* env vars so they don't
+// need quoting; if BRIG_CA_STAGED_DIR is set, it's removed afterwards.
+const caCertificatesInstallScript string = `set -e
+target="` + caCertificatesTarget + `"
+if [ -n "$BRIG_CA_STAGED_DIR" ]; then
+	trap 'rm -rf "$BRIG_CA_STAGED_DIR"' EXIT
+fi
+if [ -f "$target" ] && command -v cmp >/dev/null 2>&1 && cmp -s "$BRIG_CA_CERTIFICATES" "$target"; then
+	echo "CA certificates are already trusted"
+	exit 0
+fi
+mkdir -p "$(dirname "$target")"
+cp "$BRIG_CA_CERTIFICATES" "$target"
+chmod 644 "$target"
+if command -v update-ca-certificates >/dev/null 2>&1; then
+	mkdir -p /usr/local/share/ca-certificates
+	cp "$target" /usr/local/share/ca-certificates/brig.crt
+	update-ca-certificates
+elif command -v update-ca-trust >/dev/null 2>&1; then
+	mkdir -p /etc/pki/ca-trust/source/anchors
+	cp "$target" /etc/pki/ca-trust/source/anchors/brig.crt
+	update-ca-trust extract
+else
+	mkdir -p /etc/ssl/certs
+	cat "$target" >> /etc/ssl/certs/ca-certificates.crt
+fi
+`
+
+// readCACertificates reads the PEM bundle at path, failing if it
+// doesn't contain at least one certificate.
+func readCACertificates(path string) ([]byte, error) {
+	bundle, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA certificates: %w", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no PEM-encoded certificates found in %s", path)
+	}
+	return bundle, nil
+}
+
+// prepareCACertificates reads the bundle passed with
+// --ca-certificates, and points NODE_EXTRA_CA_CERTS at where it's
+// going to be kept in the devcontainer, unless containerEnv sets it
+// already.
+//
+// Needs to be called before the devcontainer is created; the bundle
+// itself is only added to the devcontainer's trust store once it's
+// running (see installCACertificates), or when Features are
+// installed at build time (see writeCACertificatesStage).
+func (cmd *Command) prepareCACertificates(p *writ.DevcontainerParser) error {
+	if len(cmd.Options.CACertificates) == 0 {
+		return nil
+	}
+	bundle, err := readCACertificates(cmd.Options.CACertificates)
+	if err != nil {
+		return err
+	}
+	cmd.caCertificates = bundle
+
+	if p.Config.ContainerEnv == nil {
+		p.Config.ContainerEnv = make(writ.EnvVarMap)
+	}
+	if _, ok := p.Config.ContainerEnv["NODE_EXTRA_CA_CERTS"]; !ok {
+		p.Config.ContainerEnv["NODE_EXTRA_CA_CERTS"] = caCertificatesTarget
+	}
+	return nil
+}
+
+// installCACertificates copies the bundle passed with
+// --ca-certificates into the devcontainer and adds it to its trust
+// store as root; see caCertificatesInstallScript.
+//
+// It's run before Features are installed, so they can reach the
+// network through a TLS-intercepting proxy.
+func (cmd *Command) installCACertificates(ctx context.Context) error {
+	if len(cmd.caCertificates) == 0 {
+		return nil
+	}
+	slog.Debug("adding CA certificates to the devcontainer's trust store", "path", cmd.Options.CACertificates)
+
+	stdout, stderr, captured := cmd.lifecycleOutputWriters("CA", "")
+	start := time.Now()
+	err := cmd.stageCACertificates(ctx, func(stagedDir string) error {
+		env := writ.EnvVarMap{
+			"BRIG_CA_CERTIFICATES": stagedDir + "/" + caCertificatesFileName,
+			"BRIG_CA_STAGED_DIR":   stagedDir,
+		}
+		return cmd.trillClient.StreamExecInDevcontainer(ctx, trill.ExecOptions{
+			User:       "root",
+			Env:        &env,
+			RunInShell: true,
+			Stdout:     stdout,
+			Stderr:     stderr,
+		}, caCertificatesInstallScript)
+	})
+	cmd.recordLifecycleResult("CA", "", start, err)
+	if err != nil {
+		logCapturedLifecycleOutput("CA", captured)
+	}
+	return err
+}
+
+// stageCACertificates copies the bundle into a directory of its own
+// under /tmp in the devcontainer, then calls install with that
+// directory.
+func (cmd *Command) stageCACertificates(ctx context.Context, install func(stagedDir string) error) error {
+	exportDir, err := os.MkdirTemp("", "brig-ca-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(exportDir); err != nil {
+			slog.Error("could not remove staged CA certificates", "path", exportDir, "error", err)
+		}
+	}()
+	if err = os.WriteFile(filepath.Join(exportDir, caCertificatesFileName), cmd.caCertificates, 0o644); err != nil {
+		return err
+	}
+
+	stagedName := fmt.Sprintf("brig-ca-%d", time.Now().UnixNano())
+	if err = cmd.trillClient.CopyDirToDevcontainer(ctx, exportDir, "/tmp", stagedName); err != nil {
+		return err
+	}
+	return install("/tmp/" + stagedName)
+}
+
+// writeCACertificatesStage writes the bundle passed with
+// --ca-certificates and caCertificatesInstallScript into ctxPath, and
+// the instructions that run the latter to w.
+//
+// The instructions have to be run as root. Nothing is written if no
+// bundle was passed.
+func (cmd *Command) writeCACertificatesStage(w io.Writer, ctxPath string) error {
+	if len(cmd.caCertificates) == 0 {
+		return nil
+	}
+	if err := os.WriteFile(filepath.Join(ctxPath, caCertificatesFileName), cmd.caCertificates, 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(ctxPath, caCertificatesScriptName), []byte(caCertificatesInstallScript), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "COPY \"%s\" \"%s\" \"%s/\"\n", caCertificatesFileName, caCertificatesScriptName, caCertificatesBuildDir)
+	fmt.Fprintf(w, "RUN BRIG_CA_CERTIFICATES=%s/%s BRIG_CA_STAGED_DIR=%s /bin/sh %s/%s\n", caCertificatesBuildDir, caCertificatesFileName, caCertificatesBuildDir, caCertificatesBuildDir, caCertificatesScriptName)
+	return nil
+}
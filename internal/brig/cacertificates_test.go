@@ -0,0 +1,114 @@
+package brig
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCACertificate writes a self-signed CA certificate to a
+// PEM file in a temporary directory and returns its path.
+func writeTestCACertificate(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "brig test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	certPath := filepath.Join(t.TempDir(), "ca.pem")
+	assert.Nil(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644))
+	return certPath
+}
+
+// TestReadCACertificates checks that a bundle is only accepted if it
+// contains at least one PEM-encoded certificate.
+func TestReadCACertificates(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	certPath := writeTestCACertificate(t)
+	bundle, err := readCACertificates(certPath)
+	assert.Nil(t, err)
+	assert.Contains(t, string(bundle), "BEGIN CERTIFICATE")
+
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	assert.Nil(t, os.WriteFile(notPEM, []byte("not a certificate\n"), 0o644))
+	_, err = readCACertificates(notPEM)
+	assert.NotNil(t, err)
+
+	_, err = readCACertificates(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.NotNil(t, err)
+}
+
+// TestPrepareCACertificates checks that NODE_EXTRA_CA_CERTS is pointed
+// at the bundle in the devcontainer, unless containerEnv sets it.
+func TestPrepareCACertificates(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p := &writ.DevcontainerParser{}
+	cmd := &Command{}
+	assert.Nil(t, cmd.prepareCACertificates(p))
+	assert.Nil(t, cmd.caCertificates)
+	assert.Nil(t, p.Config.ContainerEnv)
+
+	cmd.Options.CACertificates = writeTestCACertificate(t)
+	assert.Nil(t, cmd.prepareCACertificates(p))
+	assert.NotEmpty(t, cmd.caCertificates)
+	assert.Equal(t, caCertificatesTarget, p.Config.ContainerEnv["NODE_EXTRA_CA_CERTS"])
+
+	p.Config.ContainerEnv["NODE_EXTRA_CA_CERTS"] = "/etc/ssl/custom.pem"
+	assert.Nil(t, cmd.prepareCACertificates(p))
+	assert.Equal(t, "/etc/ssl/custom.pem", p.Config.ContainerEnv["NODE_EXTRA_CA_CERTS"])
+}
+
+// TestWriteCACertificatesStage checks that the bundle and the script
+// that trusts it are written into the build context, along with the
+// instructions that run it.
+func TestWriteCACertificatesStage(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctxPath := t.TempDir()
+	var containerfile bytes.Buffer
+	cmd := &Command{}
+	assert.Nil(t, cmd.writeCACertificatesStage(&containerfile, ctxPath))
+	assert.Empty(t, containerfile.String())
+
+	cmd.caCertificates = []byte("-----BEGIN CERTIFICATE-----\n")
+	assert.Nil(t, cmd.writeCACertificatesStage(&containerfile, ctxPath))
+	assert.Equal(t, []string{
+		`COPY "brig-ca-certificates.crt" "brig-ca-certificates.sh" "/tmp/brig-ca/"`,
+		"RUN BRIG_CA_CERTIFICATES=/tmp/brig-ca/brig-ca-certificates.crt BRIG_CA_STAGED_DIR=/tmp/brig-ca /bin/sh /tmp/brig-ca/brig-ca-certificates.sh",
+	}, strings.Split(strings.TrimSpace(containerfile.String()), "\n"))
+
+	bundle, err := os.ReadFile(filepath.Join(ctxPath, caCertificatesFileName))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd.caCertificates, bundle)
+	script, err := os.ReadFile(filepath.Join(ctxPath, caCertificatesScriptName))
+	assert.Nil(t, err)
+	assert.Equal(t, caCertificatesInstallScript, string(script))
+}
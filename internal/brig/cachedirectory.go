@@ -39,7 +39,18 @@ import (
 // create the directory hierarchy nominated in the `fallbackPattern`
 // parameter, which should be a `fmt` string to which `cmd.appName` is
 // applied.
+//
+// A cache directory set in the settings takes precedence over all of
+// the above.
 func (cmd *Command) getCacheDirectoryBase(prefixes []string, fallbackPattern string) (string, error) {
+	if cmd.settings != nil {
+		if cacheDir, err := cmd.settings.cacheDir(); err != nil {
+			slog.Error("encountered an error while attempting to create the cache directory from the settings", "path", cmd.settings.CacheDir, "error", err)
+			return "", err
+		} else if len(cacheDir) > 0 {
+			return cacheDir, nil
+		}
+	}
 	for _, prefix := range prefixes {
 		slog.Debug("attempting to resolve raw prefix", "prefix", prefix)
 		cacheDirPrefix, err := shell.Expand(prefix, nil)
@@ -97,3 +108,42 @@ func (cmd *Command) getCacheDirectoryBase(prefixes []string, fallbackPattern str
 
 	return "", err
 }
+
+// withCacheFileLock runs fn while holding the lock on the file named
+// lockName in cacheDir, creating it if necessary, so concurrent runs
+// don't trample over each other's writes to the cache.
+func withCacheFileLock(cacheDir string, lockName string, fn func() error) (err error) {
+	lock, err := os.OpenFile(filepath.Join(cacheDir, lockName), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err = lockFile(lock); err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, unlockFile(lock))
+	}()
+	return fn()
+}
+
+// writeCacheFile atomically replaces the file named name in cacheDir
+// with contents, by writing them to a temporary file first and moving
+// it into place.
+func writeCacheFile(cacheDir string, name string, contents []byte) error {
+	tempFile, err := os.CreateTemp(cacheDir, name+".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(contents)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if err = errors.Join(err, tempFile.Close()); err == nil {
+		err = os.Rename(tempFile.Name(), filepath.Join(cacheDir, name))
+	}
+	if err != nil {
+		return errors.Join(err, os.Remove(tempFile.Name()))
+	}
+	return nil
+}
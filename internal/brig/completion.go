@@ -0,0 +1,405 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/pborman/options"
+)
+
+// CompletionShells lists the shells `brig completion` can generate a
+// script for.
+var CompletionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// completeSubcommand is the hidden subcommand the completion scripts
+// call back into for candidates that can only be determined at
+// completion time.
+const completeSubcommand = "__complete"
+
+// completionFlagValues holds the values offered when completing the
+// argument of a flag, keyed by the flag's long name.
+var completionFlagValues = map[string][]string{
+	"log-format":  {LogFormatText, LogFormatJSON},
+	"output":      {OutputFormatText, OutputFormatJSON},
+	"platform-os": {"linux", "windows"},
+}
+
+// completionFileFlags are the (long names of) flags whose argument is
+// a path on the host.
+var completionFileFlags = []string{"config", "log-file", "settings"}
+
+// completionFlag describes a command-line flag, as parsed out of the
+// getopt tags of Command.Options.
+type completionFlag struct {
+	Short  string   // Single-character name, without the leading dash; may be empty
+	Long   string   // Long name, without the leading dashes
+	Param  string   // Name of the flag's argument; empty if it takes none
+	Help   string   // Description of the flag
+	Values []string // Fixed set of values its argument can take, if any
+	IsFile bool     // If true, its argument is a path on the host
+}
+
+// completionFlags returns the flags described by the getopt tags in
+// opts, which should be a pointer to a struct like Command.Options.
+func completionFlags(opts any) []completionFlag {
+	var flags []completionFlag
+	optsType := reflect.TypeOf(opts).Elem()
+	for i := range optsType.NumField() {
+		field := optsType.Field(i)
+		tag, ok := field.Tag.Lookup("getopt")
+		if !ok {
+			continue
+		}
+
+		var flag completionFlag
+		words := strings.Fields(tag)
+		idx := 0
+		for ; idx < len(words) && strings.HasPrefix(words[idx], "-"); idx++ {
+			if long, found := strings.CutPrefix(words[idx], "--"); found {
+				flag.Long, flag.Param, _ = strings.Cut(long, "=")
+			} else {
+				flag.Short = strings.TrimPrefix(words[idx], "-")
+			}
+		}
+		flag.Help = strings.Join(words[idx:], " ")
+
+		// Anything other than a boolean takes an argument, whether
+		// or not the tag names it
+		if isBoolean := field.Type.Kind() == reflect.Bool || field.Type == reflect.TypeOf(options.Help(false)); !isBoolean && len(flag.Param) == 0 {
+			flag.Param = "VALUE"
+		}
+		flag.Values = completionFlagValues[flag.Long]
+		for _, fileFlag := range completionFileFlags {
+			if flag.Long == fileFlag {
+				flag.IsFile = true
+			}
+		}
+		flags = append(flags, flag)
+	}
+	return flags
+}
+
+// completionData is what the completion script templates are
+// rendered with.
+type completionData struct {
+	AppName     string
+	Flags       []completionFlag
+	Subcommands []Subcommand
+	Shells      []string
+	Complete    string
+}
+
+// OpaqueValueFlags returns the names of the flags whose argument
+// can't be completed, with their leading dashes.
+func (d completionData) OpaqueValueFlags() []string {
+	var names []string
+	for _, flag := range d.Flags {
+		if len(flag.Param) == 0 || len(flag.Values) > 0 || flag.IsFile {
+			continue
+		}
+		if len(flag.Short) > 0 {
+			names = append(names, "-"+flag.Short)
+		}
+		names = append(names, "--"+flag.Long)
+	}
+	return names
+}
+
+// runCompletion implements `brig completion <shell>`.
+func (cmd *Command) runCompletion(args []string) ExitCode {
+	if len(args) != 1 {
+		fmt.Fprintf(cmd.stderr(), "usage: %s completion <%s>\n", cmd.appName, strings.Join(CompletionShells, "|"))
+		return ExitErrorParsingFlags
+	}
+	tmpl, ok := completionTemplates[args[0]]
+	if !ok {
+		fmt.Fprintf(cmd.stderr(), "unsupported shell %q; expected one of: %s\n", args[0], strings.Join(CompletionShells, ", "))
+		return ExitErrorParsingFlags
+	}
+
+	data := completionData{
+		AppName:  cmd.appName,
+		Flags:    completionFlags(&cmd.Options),
+		Shells:   CompletionShells,
+		Complete: completeSubcommand,
+	}
+	for _, subcmd := range cmd.subcommands() {
+		if !subcmd.Hidden {
+			data.Subcommands = append(data.Subcommands, subcmd)
+		}
+	}
+	if err := tmpl.Execute(cmd.stdout(), data); err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to generate completion script: %s\n", err)
+		return ExitError
+	}
+	return ExitNormal
+}
+
+// runComplete implements the hidden `brig __complete <kind>`, which
+// the completion scripts call to get candidates for kind.
+//
+// Errors are swallowed; there's no sensible way to surface them in
+// the middle of a completion.
+func (cmd *Command) runComplete(args []string) ExitCode {
+	if len(args) != 1 {
+		return ExitErrorParsingFlags
+	}
+	switch args[0] {
+	case "devcontainers":
+		for _, candidate := range devcontainerJSONCandidates(StandardDevcontainerJSONPatterns) {
+			fmt.Fprintln(cmd.stdout(), candidate)
+		}
+	default:
+		return ExitErrorParsingFlags
+	}
+	return ExitNormal
+}
+
+// devcontainerJSONCandidates returns the paths matching patterns
+// that point to existing files, as they were matched.
+func devcontainerJSONCandidates(patterns []string) []string {
+	var candidates []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			continue
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && !info.IsDir() {
+				candidates = append(candidates, match)
+			}
+		}
+	}
+	return candidates
+}
+
+// completionFuncs are the helpers available to the completion script
+// templates, mostly for quoting.
+var completionFuncs = template.FuncMap{
+	"join": strings.Join,
+	// Single-quoted for POSIX shells
+	"shquote": func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	},
+	// Escaped for use inside the [...] of a zsh _arguments spec
+	"zshdesc": func(s string) string {
+		return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+	},
+	// Single-quoted for fish
+	"fishquote": func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+	},
+	// Single-quoted for PowerShell
+	"psquote": func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	},
+}
+
+// completionTemplates holds the completion script for each of
+// CompletionShells.
+var completionTemplates = map[string]*template.Template{
+	"bash": template.Must(template.New("bash").Funcs(completionFuncs).Parse(heredoc.Doc(`
+		# bash completion for {{.AppName}}
+		#
+		# Generated by '{{.AppName}} completion bash'; load it with
+		#   source <({{.AppName}} completion bash)
+		_{{.AppName}}_completions() {
+		    local cur="${COMP_WORDS[COMP_CWORD]}"
+		    local prev="${COMP_WORDS[COMP_CWORD-1]}"
+		    local value_flags="{{range .Flags}}{{if .Param}}{{if .Short}} -{{.Short}}{{end}} --{{.Long}}{{end}}{{end}} "
+		    local subcommand="" i
+
+		    for ((i = 1; i < COMP_CWORD; i++)); do
+		        case "${COMP_WORDS[i]}" in
+		        -*=*) ;;
+		        -*) [[ "$value_flags" == *" ${COMP_WORDS[i]} "* ]] && ((i++)) ;;
+		        *)
+		            subcommand="${COMP_WORDS[i]}"
+		            break
+		            ;;
+		        esac
+		    done
+
+		    case "$subcommand" in
+		    "") ;;
+		{{- range .Subcommands}}
+		    {{.Name}})
+		        COMPREPLY=($(compgen -W {{shquote (join .Args " ")}} -- "$cur"))
+		        return
+		        ;;
+		{{- end}}
+		    *) return ;;
+		    esac
+
+		    case "$prev" in
+		{{- range .Flags}}{{if or .Values .IsFile}}
+		    {{if .Short}}-{{.Short}}|{{end}}--{{.Long}})
+		        {{if .Values}}COMPREPLY=($(compgen -W {{shquote (join .Values " ")}} -- "$cur")){{else}}COMPREPLY=($(compgen -f -- "$cur")){{end}}
+		        return
+		        ;;
+		{{- end}}{{end}}
+		    {{join .OpaqueValueFlags "|"}})
+		        return
+		        ;;
+		    esac
+
+		    if [[ "$cur" == -* ]]; then
+		        COMPREPLY=($(compgen -W "{{range .Flags}}{{if .Short}}-{{.Short}} {{end}}--{{.Long}} {{end}}" -- "$cur"))
+		        return
+		    fi
+		    COMPREPLY=($(compgen -W "{{range .Subcommands}}{{.Name}} {{end}}$({{.AppName}} {{.Complete}} devcontainers 2>/dev/null)" -- "$cur"))
+		}
+		complete -o default -F _{{.AppName}}_completions {{.AppName}}
+	`))),
+
+	"zsh": template.Must(template.New("zsh").Funcs(completionFuncs).Parse(heredoc.Doc(`
+		#compdef {{.AppName}}
+		#
+		# zsh completion for {{.AppName}}
+		#
+		# Generated by '{{.AppName}} completion zsh'; load it with
+		#   source <({{.AppName}} completion zsh)
+		# or save it as _{{.AppName}} somewhere in your $fpath.
+		_{{.AppName}}() {
+		    local state
+		    local -a subcommands devcontainers
+		    subcommands=(
+		{{- range .Subcommands}}
+		        {{shquote (print .Name ":" .Usage)}}
+		{{- end}}
+		    )
+
+		    _arguments -s -S \
+		{{- range .Flags}}
+		        {{if .Short}}'(-{{.Short}} --{{.Long}})'{-{{.Short}}{{if .Param}}+{{end}},--{{.Long}}{{if .Param}}={{end}}}{{else}}'--{{.Long}}{{if .Param}}={{end}}'{{end}}'[{{zshdesc .Help}}]{{if .Param}}:{{.Param}}:{{if .Values}}({{join .Values " "}}){{else if .IsFile}}_files{{else}} {{end}}{{end}}' \
+		{{- end}}
+		        '1: :->target' \
+		        '*:: :->args'
+
+		    case $state in
+		    target)
+		        devcontainers=(${(f)"$({{.AppName}} {{.Complete}} devcontainers 2>/dev/null)"})
+		        _describe -t subcommands 'subcommand' subcommands
+		        compadd -a devcontainers
+		        _files
+		        ;;
+		    args)
+		        case $words[1] in
+		{{- range .Subcommands}}
+		        {{.Name}}) compadd -- {{join .Args " "}} ;;
+		{{- end}}
+		        esac
+		        ;;
+		    esac
+		}
+
+		if [[ $zsh_eval_context[-1] == loadautofunc ]]; then
+		    _{{.AppName}} "$@"
+		else
+		    compdef _{{.AppName}} {{.AppName}}
+		fi
+	`))),
+
+	"fish": template.Must(template.New("fish").Funcs(completionFuncs).Parse(heredoc.Doc(`
+		# fish completion for {{.AppName}}
+		#
+		# Generated by '{{.AppName}} completion fish'; load it with
+		#   {{.AppName}} completion fish | source
+		complete -c {{$.AppName}} -e
+		{{- range .Flags}}
+		complete -c {{$.AppName}}{{if .Short}} -s {{.Short}}{{end}} -l {{.Long}}{{if .Values}} -x -a {{fishquote (join .Values " ")}}{{else if .IsFile}} -r -F{{else if .Param}} -x{{end}} -d {{fishquote .Help}}
+		{{- end}}
+		{{- range .Subcommands}}
+		complete -c {{$.AppName}} -n __fish_use_subcommand -f -a {{.Name}} -d {{fishquote .Usage}}
+		complete -c {{$.AppName}} -n {{fishquote (print "__fish_seen_subcommand_from " .Name)}} -f -a {{fishquote (join .Args " ")}}
+		{{- end}}
+		complete -c {{$.AppName}} -n __fish_use_subcommand -a '({{.AppName}} {{.Complete}} devcontainers 2>/dev/null)' -d devcontainer.json
+	`))),
+
+	"powershell": template.Must(template.New("powershell").Funcs(completionFuncs).Parse(heredoc.Doc(`
+		# PowerShell completion for {{.AppName}}
+		#
+		# Generated by '{{.AppName}} completion powershell'; load it with
+		#   {{.AppName}} completion powershell | Out-String | Invoke-Expression
+		Register-ArgumentCompleter -Native -CommandName {{psquote .AppName}} -ScriptBlock {
+		    param($wordToComplete, $commandAst, $cursorPosition)
+
+		    $flags = @(
+		{{- range .Flags}}
+		        @{ Names = @({{if .Short}}{{psquote (print "-" .Short)}}, {{end}}{{psquote (print "--" .Long)}}); Help = {{psquote .Help}}; Values = @({{range $i, $v := .Values}}{{if $i}}, {{end}}{{psquote $v}}{{end}}); TakesValue = ${{if .Param}}true{{else}}false{{end}} }
+		{{- end}}
+		    )
+		    $subcommands = @(
+		{{- range .Subcommands}}
+		        @{ Name = {{psquote .Name}}; Help = {{psquote .Usage}}; Args = @({{range $i, $v := .Args}}{{if $i}}, {{end}}{{psquote $v}}{{end}}) }
+		{{- end}}
+		    )
+
+		    # Only look at the words before the one being completed
+		    $words = @($commandAst.CommandElements | Select-Object -Skip 1 |
+		        Where-Object { $_.Extent.EndOffset -lt $cursorPosition } |
+		        ForEach-Object { $_.ToString() })
+
+		    $subcommand = $null
+		    $prevFlag = $null
+		    for ($i = 0; $i -lt $words.Count; $i++) {
+		        $word = $words[$i]
+		        if ($word -like '-*') {
+		            $flag = $flags | Where-Object { $_.Names -contains $word } | Select-Object -First 1
+		            if ($flag -and $flag.TakesValue -and $word -notlike '*=*') {
+		                if ($i -eq $words.Count - 1) { $prevFlag = $flag }
+		                $i++
+		            }
+		            continue
+		        }
+		        $subcommand = $subcommands | Where-Object { $_.Name -eq $word } | Select-Object -First 1
+		        if (-not $subcommand) { return }
+		        break
+		    }
+
+		    $complete = {
+		        param($candidates, $type)
+		        $candidates | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+		            [System.Management.Automation.CompletionResult]::new($_, $_, $type, $_)
+		        }
+		    }
+
+		    if ($subcommand) {
+		        & $complete $subcommand.Args 'ParameterValue'
+		    } elseif ($prevFlag) {
+		        & $complete $prevFlag.Values 'ParameterValue'
+		    } elseif ($wordToComplete -like '-*') {
+		        $flags | ForEach-Object {
+		            $help = $_.Help
+		            $_.Names | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+		                [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterName', $help)
+		            }
+		        }
+		    } else {
+		        & $complete ($subcommands | ForEach-Object { $_.Name }) 'Command'
+		        & $complete @(& {{psquote .AppName}} {{psquote .Complete}} 'devcontainers' 2>$null) 'ParameterValue'
+		    }
+		}
+	`))),
+}
@@ -0,0 +1,94 @@
+package brig
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCompletionFlags checks that flags are read off the getopt tags
+// of Command.Options correctly.
+func TestCompletionFlags(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cmd := New("brig", "")
+	flags := map[string]completionFlag{}
+	for _, flag := range completionFlags(&cmd.Options) {
+		flags[flag.Long] = flag
+	}
+
+	assert.Equal(t, completionFlag{Short: "h", Long: "help", Help: "display this help message"}, flags["help"])
+	assert.Equal(t, completionFlag{Short: "c", Long: "config", Param: "PATH", Help: "path to rc file", IsFile: true}, flags["config"])
+	assert.Equal(t, []string{OutputFormatText, OutputFormatJSON}, flags["output"].Values)
+	// Takes an argument, even if the tag doesn't name it
+	assert.Equal(t, "VALUE", flags["platform-arch"].Param)
+	assert.Empty(t, flags["detach"].Param)
+}
+
+// TestRunCompletion checks that a script is printed for each of the
+// supported shells, and that other shells are rejected.
+func TestRunCompletion(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, shell := range CompletionShells {
+		var stdout bytes.Buffer
+		cmd := New("brig", "")
+		cmd.Stdout = &stdout
+		cmd.Arguments = []string{"completion", shell}
+
+		exitCode, ok := cmd.runSubcommand()
+		assert.True(t, ok)
+		assert.Equal(t, ExitNormal, exitCode, shell)
+		assert.Contains(t, stdout.String(), "output", shell)
+		assert.Contains(t, stdout.String(), "completion", shell)
+		assert.Contains(t, stdout.String(), completeSubcommand, shell)
+		assert.Contains(t, stdout.String(), "devcontainers", shell)
+		assert.NotContains(t, stdout.String(), "<no value>", shell)
+	}
+
+	cmd := New("brig", "")
+	cmd.Stderr = io.Discard
+	cmd.Arguments = []string{"completion", "tcsh"}
+	exitCode, ok := cmd.runSubcommand()
+	assert.True(t, ok)
+	assert.Equal(t, ExitErrorParsingFlags, exitCode)
+
+	// Not a subcommand, so it's left to be treated as a path
+	cmd.Arguments = []string{"completion.json"}
+	_, ok = cmd.runSubcommand()
+	assert.False(t, ok)
+}
+
+// TestRunCompleteDevcontainers checks that the devcontainer.json
+// files in the standard locations are offered as candidates.
+func TestRunCompleteDevcontainers(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dir := t.TempDir()
+	for _, path := range []string{
+		".devcontainer.json",
+		".devcontainer/python/devcontainer.json",
+		".devcontainer/node/devcontainer.json",
+	} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0o755))
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, path), []byte("{}"), 0o644))
+	}
+	t.Chdir(dir)
+
+	var stdout bytes.Buffer
+	cmd := New("brig", "")
+	cmd.Stdout = &stdout
+	cmd.Arguments = []string{completeSubcommand, "devcontainers"}
+	exitCode, ok := cmd.runSubcommand()
+	assert.True(t, ok)
+	assert.Equal(t, ExitNormal, exitCode)
+	assert.Equal(t, ".devcontainer.json\n.devcontainer/node/devcontainer.json\n.devcontainer/python/devcontainer.json\n", stdout.String())
+}
@@ -0,0 +1,208 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/nlsantos/brig/trill"
+	"github.com/pborman/options"
+)
+
+// ComposeSubcommandOptions are the flags the `brig compose`
+// subcommands take, on top of the global ones.
+type ComposeSubcommandOptions struct {
+	File string `getopt:"-f --file=PATH devcontainer.json of the Compose project; found the same way as when bringing it up if unset"`
+}
+
+// ComposeLogsOptions are the flags `brig compose logs` takes, on top
+// of the global ones.
+type ComposeLogsOptions struct {
+	File       string `getopt:"-f --file=PATH devcontainer.json of the Compose project; found the same way as when bringing it up if unset"`
+	Follow     bool   `getopt:"--follow keep printing output as it's written"`
+	Tail       string `getopt:"--tail=NUM number of lines to show from the end of each service's logs; defaults to all"`
+	Timestamps bool   `getopt:"--timestamps prefix every line with when it was written"`
+}
+
+// runCompose implements `brig compose {logs|ps|restart|start|stop}`,
+// which manage the services of the Compose project a devcontainer was
+// brought up with, without needing docker compose: the containers are
+// found by the labels brig applies to them.
+//
+// Each subcommand takes the names of the services to act on; with
+// none, it acts on every service in the project.
+func (cmd *Command) runCompose(args []string) ExitCode {
+	if len(args) > 0 {
+		switch args[0] {
+		case "logs":
+			return cmd.runComposeLogs(args)
+		case "ps", "restart", "start", "stop":
+			return cmd.runComposeServices(args)
+		}
+	}
+	fmt.Fprintf(cmd.stderr(), "usage: %s compose {logs|ps|restart|start|stop} [<flags>] [SERVICE...]\n", cmd.appName)
+	return ExitErrorParsingFlags
+}
+
+// runComposeServices implements `brig compose ps`, `brig compose
+// restart`, `brig compose start`, and `brig compose stop`.
+//
+// args begins with the subcommand's own name.
+func (cmd *Command) runComposeServices(args []string) ExitCode {
+	subcmd := args[0]
+	opts := ComposeSubcommandOptions{}
+	services, err := options.SubRegisterAndParse(&opts, args)
+	if err != nil {
+		fmt.Fprintln(cmd.stderr(), err)
+		fmt.Fprintf(cmd.stderr(), "usage: %s compose %s [-f PATH] [SERVICE...]\n", cmd.appName, subcmd)
+		return ExitErrorParsingFlags
+	}
+	ctx := context.Background()
+	if exitCode := cmd.loadComposeProject(ctx, opts.File); exitCode != ExitNormal {
+		return exitCode
+	}
+
+	switch subcmd {
+	case "ps":
+		return cmd.listComposeServices(ctx, services)
+	case "restart":
+		err = cmd.restartComposeServices(ctx, services)
+	case "start":
+		err = cmd.trillClient.StartComposerServices(ctx, services...)
+	case "stop":
+		err = cmd.trillClient.StopComposerServices(ctx, services...)
+	}
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to %s services: %s\n", subcmd, err)
+		return ExitError
+	}
+	return ExitNormal
+}
+
+// runComposeLogs implements `brig compose logs [<flags>] [SERVICE...]`,
+// which prints out what the services' containers have written; lines
+// are prefixed with the name of the container they're from when there
+// are several.
+//
+// args begins with the subcommand's own name.
+func (cmd *Command) runComposeLogs(args []string) ExitCode {
+	opts := ComposeLogsOptions{}
+	services, err := options.SubRegisterAndParse(&opts, args)
+	if err != nil {
+		fmt.Fprintln(cmd.stderr(), err)
+		fmt.Fprintf(cmd.stderr(), "usage: %s compose logs [-f PATH] [--follow] [--tail=NUM] [--timestamps] [SERVICE...]\n", cmd.appName)
+		return ExitErrorParsingFlags
+	}
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	if exitCode := cmd.loadComposeProject(ctx, opts.File); exitCode != ExitNormal {
+		return exitCode
+	}
+
+	logsOpts := trill.ComposerLogsOptions{Follow: opts.Follow, Tail: opts.Tail, Timestamps: opts.Timestamps}
+	if err = cmd.trillClient.ComposerServiceLogs(ctx, logsOpts, cmd.stdout(), cmd.stderr(), services...); err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to read the logs: %s\n", err)
+		return ExitError
+	}
+	return ExitNormal
+}
+
+// loadComposeProject loads the Compose project of the devcontainer.json
+// at configPath (found as when bringing the devcontainer up, if
+// empty), under the name it's brought up as, connecting to the engine
+// if need be.
+func (cmd *Command) loadComposeProject(ctx context.Context, configPath string) ExitCode {
+	var configArgs []string
+	if len(configPath) > 0 {
+		configArgs = []string{configPath}
+	}
+	parser, err := cmd.loadDevcontainerJSON(configArgs)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to parse devcontainer.json: %s\n", err)
+		return ExitNonValidDevcontainerJSON
+	}
+	if !isComposeDevcontainer(parser) {
+		fmt.Fprintln(cmd.stderr(), "the devcontainer isn't Compose-based")
+		return ExitUnsupportedConfiguration
+	}
+
+	if cmd.trillClient == nil {
+		if err = cmd.Connect(cmd.Options.Socket); err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to reach Podman/Docker: %s\n", err)
+			return exitCodeForError(err)
+		}
+	}
+	// Validated in parseOptions
+	scale, _ := trill.ParseScale(cmd.Options.Scale)
+	err = cmd.trillClient.LoadComposerProject(ctx, parser, trill.ComposeOptions{
+		ProjectName: composeProjectName(parser),
+		Scale:       scale,
+	})
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to load the Compose project: %s\n", err)
+		return exitCodeForError(err)
+	}
+	return ExitNormal
+}
+
+// listComposeServices prints out the containers of the named services
+// (of every service, if none are named), with their state and health,
+// and the ports they publish.
+func (cmd *Command) listComposeServices(ctx context.Context, services []string) ExitCode {
+	containers, err := cmd.trillClient.ComposerServiceContainers(ctx, services...)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to list services: %s\n", err)
+		return ExitError
+	}
+	w := tabwriter.NewWriter(cmd.stdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tNAME\tSTATE\tHEALTH\tPORTS")
+	for _, serviceContainer := range containers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", serviceContainer.Service, serviceContainer.Name, serviceContainer.State, serviceContainer.Health, strings.Join(serviceContainer.Ports, ", "))
+	}
+	if err = w.Flush(); err != nil {
+		slog.Error("unable to print out the services", "error", err)
+		return ExitError
+	}
+	return ExitNormal
+}
+
+// restartComposeServices restarts the named services, along with the
+// ones that depend on them with restart: true; with none named, every
+// service is stopped, then started again.
+func (cmd *Command) restartComposeServices(ctx context.Context, services []string) error {
+	if len(services) == 0 {
+		if err := cmd.trillClient.StopComposerServices(ctx); err != nil {
+			return err
+		}
+		return cmd.trillClient.StartComposerServices(ctx)
+	}
+	for _, serviceName := range services {
+		restarted, err := cmd.trillClient.RestartComposerService(ctx, serviceName)
+		if err != nil {
+			return err
+		}
+		slog.Debug("restarted services", "services", restarted)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package brig
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/moby/moby/api/types/container"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/trill"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRunCompose checks that `brig compose` lists, tails, stops, and
+// starts the services of the devcontainer's Compose project.
+func TestRunCompose(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dir := t.TempDir()
+	devcontainerDir := filepath.Join(dir, ".devcontainer")
+	assert.Nil(t, os.MkdirAll(devcontainerDir, 0o755))
+	configPath := filepath.Join(devcontainerDir, "devcontainer.json")
+	assert.Nil(t, os.WriteFile(configPath, []byte(`{"dockerComposeFile": "compose.yml", "service": "app", "workspaceFolder": "/workspace"}`), 0o644))
+	compose := "services:\n  app:\n    image: alpine\n    depends_on: [db]\n  db:\n    image: alpine\n"
+	assert.Nil(t, os.WriteFile(filepath.Join(devcontainerDir, "compose.yml"), []byte(compose), 0o644))
+
+	var stdout, stderr bytes.Buffer
+	cmd := New("brig", "")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	parser, err := cmd.loadDevcontainerJSON([]string{configPath})
+	assert.Nil(t, err)
+	projName := composeProjectName(parser)
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("alpine", nil)
+	engine.OutputHandler = func(containerID string, cmd []string) testutil.ExecResult {
+		return testutil.ExecResult{Stdout: "ready\n"}
+	}
+	for _, serviceName := range []string{"app", "db"} {
+		created, err := engine.ContainerCreate(context.Background(), mobyclient.ContainerCreateOptions{
+			Name: projName + "--" + serviceName,
+			Config: &container.Config{Image: "alpine", Labels: map[string]string{
+				trill.ComposeProjectLabel: projName,
+				trill.ComposeServiceLabel: serviceName,
+				trill.ComposeReplicaLabel: "1",
+			}},
+		})
+		assert.Nil(t, err)
+		_, err = engine.ContainerStart(context.Background(), created.ID, mobyclient.ContainerStartOptions{})
+		assert.Nil(t, err)
+	}
+	cmd.trillClient, err = trill.NewClient(trill.ClientOptions{SocketAddr: "fake://engine", Engine: engine})
+	assert.Nil(t, err)
+
+	cmd.Arguments = []string{"compose"}
+	exitCode, ok := cmd.runSubcommand()
+	assert.True(t, ok)
+	assert.Equal(t, ExitErrorParsingFlags, exitCode)
+
+	cmd.Arguments = []string{"compose", "ps", "-f", configPath}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitNormal, exitCode, stderr.String())
+	assert.Contains(t, stdout.String(), "SERVICE")
+	assert.Contains(t, stdout.String(), projName+"--db")
+	assert.Contains(t, stdout.String(), "running")
+
+	stdout.Reset()
+	cmd.Arguments = []string{"compose", "logs", "--file", configPath, "--tail=5", "db"}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitNormal, exitCode, stderr.String())
+	assert.Equal(t, "ready\n", stdout.String())
+
+	cmd.Arguments = []string{"compose", "stop", "-f", configPath}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitNormal, exitCode, stderr.String())
+	if stops := engine.CallsTo("ContainerStop"); assert.Len(t, stops, 2) {
+		app, _ := engine.Container(projName + "--app")
+		assert.Equal(t, app.ID, stops[0].Target)
+		assert.False(t, app.State.Running)
+	}
+
+	cmd.Arguments = []string{"compose", "start", "-f", configPath, "missing"}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitError, exitCode)
+
+	// Not a Compose-based devcontainer
+	imageConfigPath := filepath.Join(t.TempDir(), "devcontainer.json")
+	assert.Nil(t, os.WriteFile(imageConfigPath, []byte(`{"image": "alpine"}`), 0o644))
+	cmd.Arguments = []string{"compose", "ps", "-f", imageConfigPath}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitUnsupportedConfiguration, exitCode)
+}
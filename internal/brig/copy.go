@@ -0,0 +1,89 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// runCp implements `brig cp SRC DST`, which copies files between the
+// host and a container, with `docker cp`'s syntax: paths in the
+// container are given as CONTAINER:PATH, and the other path is on the
+// host.
+//
+// If CONTAINER is left empty (e.g., `:/etc/hosts`), the devcontainer
+// of the devcontainer.json in the working directory is used.
+func (cmd *Command) runCp(args []string) ExitCode {
+	if len(args) != 2 {
+		fmt.Fprintf(cmd.stderr(), "usage: %s cp [CONTAINER]:SRC_PATH DEST_PATH\n       %s cp SRC_PATH [CONTAINER]:DEST_PATH\n", cmd.appName, cmd.appName)
+		return ExitErrorParsingFlags
+	}
+	srcContainer, srcPath, srcInContainer := splitCpArg(args[0])
+	dstContainer, dstPath, dstInContainer := splitCpArg(args[1])
+	if srcInContainer == dstInContainer {
+		fmt.Fprintln(cmd.stderr(), "exactly one of the paths has to be in a container, given as [CONTAINER]:PATH")
+		return ExitErrorParsingFlags
+	}
+
+	containerName := srcContainer + dstContainer
+	if len(containerName) == 0 {
+		parser, err := cmd.loadDevcontainerJSON(nil)
+		if err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to parse devcontainer.json: %s\n", err)
+			return ExitNonValidDevcontainerJSON
+		}
+		containerName = devcontainerContainerName(parser)
+	}
+
+	if cmd.trillClient == nil {
+		if err := cmd.Connect(cmd.Options.Socket); err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to reach Podman/Docker: %s\n", err)
+			return exitCodeForError(err)
+		}
+	}
+	var err error
+	if srcInContainer {
+		err = cmd.trillClient.CopyFromContainer(context.Background(), containerName, srcPath, dstPath)
+	} else {
+		err = cmd.trillClient.CopyToContainer(context.Background(), containerName, srcPath, dstPath)
+	}
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to copy %s to %s: %s\n", args[0], args[1], err)
+		return ExitError
+	}
+	return ExitNormal
+}
+
+// splitCpArg splits an argument to `brig cp` into the container it
+// refers to, if any, and the path.
+//
+// As with `docker cp`, absolute host paths, and ones starting with a
+// dot, are never taken to be in a container, even if they have a colon
+// in them.
+func splitCpArg(arg string) (containerName string, path string, inContainer bool) {
+	if filepath.IsAbs(arg) {
+		return "", arg, false
+	}
+	containerName, path, ok := strings.Cut(arg, ":")
+	if !ok || strings.HasPrefix(containerName, ".") {
+		return "", arg, false
+	}
+	return containerName, path, true
+}
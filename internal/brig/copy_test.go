@@ -0,0 +1,96 @@
+package brig
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/moby/moby/api/types/container"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/trill"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSplitCpArg checks that `brig cp` arguments are told apart the
+// way `docker cp` does it.
+func TestSplitCpArg(t *testing.T) {
+	for arg, want := range map[string]struct {
+		container   string
+		path        string
+		inContainer bool
+	}{
+		"ctr:/etc/hosts":  {"ctr", "/etc/hosts", true},
+		":/etc/hosts":     {"", "/etc/hosts", true},
+		"file.txt":        {"", "file.txt", false},
+		"./odd:name":      {"", "./odd:name", false},
+		"/abs/odd:name":   {"", "/abs/odd:name", false},
+		"ctr:relative/fn": {"ctr", "relative/fn", true},
+	} {
+		containerName, path, inContainer := splitCpArg(arg)
+		assert.Equal(t, want.container, containerName, arg)
+		assert.Equal(t, want.path, path, arg)
+		assert.Equal(t, want.inContainer, inContainer, arg)
+	}
+}
+
+// TestRunCp checks that `brig cp` copies files into and out of the
+// named container, or the devcontainer if none is named.
+func TestRunCp(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dir := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, ".devcontainer"), 0o755))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, ".devcontainer", "devcontainer.json"), []byte(`{"image": "alpine"}`), 0o644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "token"), []byte("secret"), 0o600))
+	t.Chdir(dir)
+
+	var stderr bytes.Buffer
+	cmd := New("brig", "")
+	cmd.Stderr = &stderr
+	parser, err := cmd.loadDevcontainerJSON(nil)
+	assert.Nil(t, err)
+	containerName := devcontainerContainerName(parser)
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("alpine", nil)
+	for _, name := range []string{containerName, "other"} {
+		_, err = engine.ContainerCreate(context.Background(), mobyclient.ContainerCreateOptions{
+			Name:   name,
+			Config: &container.Config{Image: "alpine"},
+		})
+		assert.Nil(t, err)
+	}
+	cmd.trillClient, err = trill.NewClient(trill.ClientOptions{SocketAddr: "fake://engine", Engine: engine})
+	assert.Nil(t, err)
+
+	// One side has to be in a container
+	cmd.Arguments = []string{"cp", "token", "elsewhere"}
+	exitCode, ok := cmd.runSubcommand()
+	assert.True(t, ok)
+	assert.Equal(t, ExitErrorParsingFlags, exitCode)
+
+	cmd.Arguments = []string{"cp", "token", ":/tmp/token"}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitNormal, exitCode, stderr.String())
+	contents, ok := engine.File(containerName, "/tmp/token")
+	assert.True(t, ok)
+	assert.Equal(t, "secret", string(contents))
+
+	assert.Nil(t, engine.AddFile("other", "/out/artifact", []byte("built")))
+	cmd.Arguments = []string{"cp", "other:/out/artifact", "artifact"}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitNormal, exitCode, stderr.String())
+	contents, err = os.ReadFile(filepath.Join(dir, "artifact"))
+	assert.Nil(t, err)
+	assert.Equal(t, "built", string(contents))
+
+	cmd.Arguments = []string{"cp", "other:/nonexistent", "artifact"}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitError, exitCode)
+}
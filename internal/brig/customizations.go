@@ -0,0 +1,73 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/netip"
+
+	"github.com/nlsantos/brig/writ"
+)
+
+// CustomizationsTool is the name brig's settings go under in
+// customizations, i.e., customizations.brig.
+const CustomizationsTool string = "brig"
+
+// BrigCustomizations holds the settings brig reads from
+// customizations.brig.
+type BrigCustomizations struct {
+	Shell          string `json:"shell,omitempty"`          // The shell attached to the host terminal, in place of the remoteUser's login shell
+	ForwardAddress string `json:"forwardAddress,omitempty"` // The host address appPort and forwardPorts are bound to, unless they specify one
+
+	// If set, these take the place of --propagate-locale and
+	// --propagate-timezone for the devcontainer
+	PropagateLocale   *bool `json:"propagateLocale,omitempty"`
+	PropagateTimezone *bool `json:"propagateTimezone,omitempty"`
+}
+
+// handleBrigCustomizations applies customizations.brig; see
+// BrigCustomizations.
+//
+// Contributions are layered in order, so a setting in
+// devcontainer.json wins over one from a Feature.
+func (cmd *Command) handleBrigCustomizations(_ *writ.DevcontainerParser, contributions []json.RawMessage) error {
+	var customizations BrigCustomizations
+	for _, contribution := range contributions {
+		if err := json.Unmarshal(contribution, &customizations); err != nil {
+			return err
+		}
+	}
+	slog.Debug("applying brig customizations", "customizations", customizations)
+
+	if len(customizations.ForwardAddress) > 0 {
+		addr, err := netip.ParseAddr(customizations.ForwardAddress)
+		if err != nil {
+			return fmt.Errorf("forwardAddress is not a valid IP address: %w", err)
+		}
+		cmd.trillClient.ForwardAddress = addr
+	}
+	cmd.trillClient.AttachShell = customizations.Shell
+	if customizations.PropagateLocale != nil {
+		cmd.Options.PropagateLocale = *customizations.PropagateLocale
+	}
+	if customizations.PropagateTimezone != nil {
+		cmd.Options.PropagateTimezone = *customizations.PropagateTimezone
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package brig
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/netip"
+	"testing"
+
+	"github.com/nlsantos/brig/trill"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHandleBrigCustomizations checks that customizations.brig is
+// passed on to the trill client, with later contributions winning.
+func TestHandleBrigCustomizations(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cmd := New("brig", "")
+	var err error
+	cmd.trillClient, err = trill.NewClient(trill.ClientOptions{SocketAddr: "unix:///nonexistent.sock"})
+	assert.Nil(t, err)
+
+	assert.Nil(t, cmd.handleBrigCustomizations(nil, []json.RawMessage{
+		json.RawMessage(`{"shell": "/bin/bash", "forwardAddress": "0.0.0.0"}`),
+		json.RawMessage(`{"shell": "/bin/zsh"}`),
+	}))
+	assert.Equal(t, "/bin/zsh", cmd.trillClient.AttachShell)
+	assert.Equal(t, netip.MustParseAddr("0.0.0.0"), cmd.trillClient.ForwardAddress)
+
+	assert.False(t, cmd.Options.PropagateLocale)
+	assert.False(t, cmd.Options.PropagateTimezone)
+
+	// Unset settings leave the flags alone
+	cmd.Options.PropagateLocale = true
+	assert.Nil(t, cmd.handleBrigCustomizations(nil, []json.RawMessage{
+		json.RawMessage(`{"propagateTimezone": true}`),
+	}))
+	assert.True(t, cmd.Options.PropagateLocale)
+	assert.True(t, cmd.Options.PropagateTimezone)
+	assert.Nil(t, cmd.handleBrigCustomizations(nil, []json.RawMessage{
+		json.RawMessage(`{"propagateLocale": false}`),
+	}))
+	assert.False(t, cmd.Options.PropagateLocale)
+
+	assert.NotNil(t, cmd.handleBrigCustomizations(nil, []json.RawMessage{
+		json.RawMessage(`{"forwardAddress": "localhost"}`),
+	}))
+	assert.NotNil(t, cmd.handleBrigCustomizations(nil, []json.RawMessage{
+		json.RawMessage(`{"shell": 1}`),
+	}))
+}
@@ -0,0 +1,220 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/nlsantos/brig/trill"
+)
+
+// IssueTrackerURL is where users are pointed to when brig crashes.
+const IssueTrackerURL = "https://github.com/nlsantos/brig/issues"
+
+// Redacted stands in for values left out of a diagnostics bundle.
+const Redacted = "<redacted>"
+
+// DiagnosticsBundle is what gets written out when brig panics, for
+// users to attach to bug reports.
+type DiagnosticsBundle struct {
+	Time       time.Time         `json:"time"`
+	AppName    string            `json:"appName"`
+	AppVersion string            `json:"appVersion"`
+	GoVersion  string            `json:"goVersion"`
+	OS         string            `json:"os"`
+	Arch       string            `json:"arch"`
+	Arguments  []string          `json:"arguments"`
+	SocketAddr string            `json:"socketAddr,omitempty"`
+	Engine     *trill.EngineInfo `json:"engine,omitempty"`
+	EngineErr  string            `json:"engineError,omitempty"`
+	ConfigFile string            `json:"configFile,omitempty"`
+	Config     any               `json:"config,omitempty"` // The parsed devcontainer.json, with anything that may be sensitive redacted
+	Panic      string            `json:"panic"`
+	Stack      string            `json:"stack"`
+}
+
+// sensitiveKeyPattern matches the names of keys whose values are left
+// out of diagnostics bundles.
+var sensitiveKeyPattern = regexp.MustCompile(`(?i)(token|secret|passw(or)?d|credential|auth|private|apikey|api_key)`)
+
+// sensitiveMapKeys are the devcontainer.json keys whose values are
+// maps of environment variables and the like; every value in them is
+// left out of diagnostics bundles.
+var sensitiveMapKeys = []string{"args", "containerEnv", "remoteEnv", "environment"}
+
+// recoverPanic is deferred at the top of NewCommand; should anything
+// panic, it writes out a diagnostics bundle, tells the user where to
+// find it, and has brig exit with ExitPanic instead of crashing.
+//
+// Panics in goroutines are out of its reach; those are recovered
+// where they happen, and come back as a *trill.PanicError, which is
+// handed to reportPanic instead.
+func (cmd *Command) recoverPanic(exitCode *ExitCode) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	*exitCode = cmd.reportPanic(r, debug.Stack())
+}
+
+// reportPanic writes out a diagnostics bundle for the panic r, raised
+// from stack, and tells the user where to find it; it returns the
+// code brig should exit with.
+func (cmd *Command) reportPanic(r any, stack []byte) ExitCode {
+	slog.Error("brig panicked", "panic", r)
+	cmd.recordError(fmt.Errorf("panic: %v", r))
+
+	stderr := cmd.stderr()
+	fmt.Fprintf(stderr, "\nbrig ran into an unexpected problem and had to stop: %v\n", r)
+	bundlePath, err := cmd.writeDiagnosticsBundle(r, stack)
+	if err != nil {
+		slog.Error("unable to write out a diagnostics bundle", "error", err)
+		fmt.Fprintf(stderr, "This is a bug; please report it at %s, including the following:\n\n%s\n", IssueTrackerURL, stack)
+		return ExitPanic
+	}
+	fmt.Fprintf(stderr, "This is a bug; please report it at %s and attach %s\n", IssueTrackerURL, bundlePath)
+	fmt.Fprintln(stderr, "Sensitive values in your configuration have been redacted, but do look it over before sharing it.")
+	return ExitPanic
+}
+
+// writeDiagnosticsBundle collects what's known about the run and
+// writes it to a temporary file, returning its path.
+func (cmd *Command) writeDiagnosticsBundle(panicVal any, stack []byte) (string, error) {
+	bundle := cmd.collectDiagnostics(panicVal, stack)
+	bundleJSON, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp("", fmt.Sprintf("%s-diagnostics-*.json", cmd.appName))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err = f.Write(bundleJSON); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// collectDiagnostics gathers the contents of a diagnostics bundle.
+//
+// It tries its best to not panic itself: every piece of information
+// is optional.
+func (cmd *Command) collectDiagnostics(panicVal any, stack []byte) DiagnosticsBundle {
+	bundle := DiagnosticsBundle{
+		Time:       time.Now(),
+		AppName:    cmd.appName,
+		AppVersion: cmd.appVersion,
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		Arguments:  redactArguments(os.Args[1:]),
+		ConfigFile: cmd.Summary().ConfigFile,
+		Panic:      fmt.Sprint(panicVal),
+		Stack:      string(stack),
+	}
+
+	if cmd.trillClient != nil {
+		bundle.SocketAddr = cmd.trillClient.SocketAddr
+		ctx, cancel := context.WithTimeout(context.Background(), EnginePingTimeout)
+		defer cancel()
+		if engineInfo, err := cmd.trillClient.EngineInfo(ctx); err == nil {
+			bundle.Engine = &engineInfo
+		} else {
+			bundle.EngineErr = err.Error()
+		}
+	}
+
+	if cmd.parser != nil {
+		if configJSON, err := json.Marshal(cmd.parser.Config); err == nil {
+			var config any
+			if err = json.Unmarshal(configJSON, &config); err == nil {
+				bundle.Config = redactValue("", config)
+			}
+		}
+	}
+
+	return bundle
+}
+
+// redactValue returns a copy of v (as decoded by encoding/json) with
+// values that may hold secrets replaced with Redacted.
+func redactValue(key string, v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		redactAll := false
+		for _, sensitiveKey := range sensitiveMapKeys {
+			if strings.EqualFold(key, sensitiveKey) {
+				redactAll = true
+				break
+			}
+		}
+		redacted := make(map[string]any, len(val))
+		for k, item := range val {
+			if redactAll {
+				redacted[k] = Redacted
+				continue
+			}
+			redacted[k] = redactValue(k, item)
+		}
+		return redacted
+	case []any:
+		redacted := make([]any, len(val))
+		for i, item := range val {
+			redacted[i] = redactValue(key, item)
+		}
+		return redacted
+	case string:
+		if len(key) > 0 && sensitiveKeyPattern.MatchString(key) {
+			return Redacted
+		}
+		return redactAssignment(val)
+	default:
+		return val
+	}
+}
+
+// redactArguments returns a copy of args with the values of
+// sensitive-looking assignments (e.g., --env=GITHUB_TOKEN=...)
+// redacted.
+func redactArguments(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = redactAssignment(arg)
+	}
+	return redacted
+}
+
+// redactAssignment redacts the value of s if it's of the form
+// NAME=value and NAME looks like it's for a secret.
+func redactAssignment(s string) string {
+	idx := strings.LastIndex(s, "=")
+	if idx > 0 && sensitiveKeyPattern.MatchString(s[:idx]) {
+		return s[:idx+1] + Redacted
+	}
+	return s
+}
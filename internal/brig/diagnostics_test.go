@@ -0,0 +1,108 @@
+package brig
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/nlsantos/brig/trill"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sync/errgroup"
+)
+
+// TestRecoverPanic checks that a panic is turned into ExitPanic and a
+// diagnostics bundle with sensitive values redacted.
+func TestRecoverPanic(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Setenv("TMPDIR", t.TempDir())
+
+	var stderr bytes.Buffer
+	cmd := New("brig", "0.0.0-test")
+	cmd.Stderr = &stderr
+	cmd.parser = &writ.DevcontainerParser{}
+	cmd.parser.Config.ContainerEnv = writ.EnvVarMap{"GITHUB_TOKEN": "hunter2"}
+	cmd.parser.Config.RunArgs = []string{"--env=API_KEY=hunter2", "--cap-add=SYS_PTRACE"}
+
+	exitCode := func() (exitCode ExitCode) {
+		defer cmd.recoverPanic(&exitCode)
+		var lookup map[string]*string
+		_ = *lookup["missing"]
+		return ExitNormal
+	}()
+	assert.Equal(t, ExitPanic, exitCode)
+	assert.Contains(t, cmd.Summary().Error, "panic")
+
+	matches := regexp.MustCompile(`attach (\S+)`).FindStringSubmatch(stderr.String())
+	if !assert.Len(t, matches, 2) {
+		return
+	}
+	bundleJSON, err := os.ReadFile(matches[1])
+	assert.Nil(t, err)
+	assert.NotContains(t, string(bundleJSON), "hunter2")
+
+	var bundle DiagnosticsBundle
+	assert.Nil(t, json.Unmarshal(bundleJSON, &bundle))
+	assert.Equal(t, "0.0.0-test", bundle.AppVersion)
+	assert.Contains(t, bundle.Panic, "nil pointer dereference")
+	assert.Contains(t, bundle.Stack, "TestRecoverPanic")
+
+	config := bundle.Config.(map[string]any)
+	assert.Equal(t, map[string]any{"GITHUB_TOKEN": Redacted}, config["containerEnv"])
+	assert.Equal(t, []any{"--env=API_KEY=" + Redacted, "--cap-add=SYS_PTRACE"}, config["runArgs"])
+}
+
+// TestReportGoroutinePanic checks that a panic in a goroutine run
+// through an errgroup comes back as a *trill.PanicError carrying the
+// goroutine's stack, and is reported like any other panic.
+func TestReportGoroutinePanic(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Setenv("TMPDIR", t.TempDir())
+
+	var stderr bytes.Buffer
+	cmd := New("brig", "0.0.0-test")
+	cmd.Stderr = &stderr
+
+	var eg errgroup.Group
+	eg.Go(func() (err error) {
+		defer trill.RecoverPanic(&err)
+		var lookup map[string]*string
+		_ = *lookup["missing"]
+		return nil
+	})
+	err := eg.Wait()
+	var panicErr *trill.PanicError
+	if !assert.ErrorAs(t, err, &panicErr) {
+		return
+	}
+	assert.Contains(t, string(panicErr.Stack), "TestReportGoroutinePanic")
+
+	assert.Equal(t, ExitPanic, cmd.reportPanic(panicErr.Value, panicErr.Stack))
+	assert.Contains(t, cmd.Summary().Error, "nil pointer dereference")
+	matches := regexp.MustCompile(`attach (\S+)`).FindStringSubmatch(stderr.String())
+	if !assert.Len(t, matches, 2) {
+		return
+	}
+	bundleJSON, err := os.ReadFile(matches[1])
+	assert.Nil(t, err)
+	var bundle DiagnosticsBundle
+	assert.Nil(t, json.Unmarshal(bundleJSON, &bundle))
+	assert.Contains(t, bundle.Stack, "TestReportGoroutinePanic")
+}
+
+// TestRedactAssignment checks that only assignments to
+// sensitive-looking names are redacted.
+func TestRedactAssignment(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.Equal(t, "NPM_AUTH_TOKEN="+Redacted, redactAssignment("NPM_AUTH_TOKEN=abc"))
+	assert.Equal(t, "--socket=unix:///run/podman.sock", redactAssignment("--socket=unix:///run/podman.sock"))
+	assert.Equal(t, "plain", redactAssignment("plain"))
+}
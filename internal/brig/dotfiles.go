@@ -0,0 +1,168 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/nlsantos/brig/trill"
+	"github.com/nlsantos/brig/writ"
+)
+
+// DefDotfilesTargetPath is where dotfiles are cloned into inside the
+// devcontainer, unless --dotfiles-target-path says otherwise.
+const DefDotfilesTargetPath string = "~/dotfiles"
+
+// dotfilesStagingDir is where dotfiles cloned on the host are copied
+// into inside the devcontainer, before being moved to the target path.
+const dotfilesStagingDir string = "/tmp"
+
+// dotfilesInstallScript clones (or copies over) the dotfiles
+// repository into its target path, then runs the first install
+// script it finds, mirroring VS Code and Codespaces. If the
+// repository doesn't have one, its top-level dotfiles are symlinked
+// into $HOME instead.
+//
+// Its parameters are passed in via BRIG_DOTFILES_* env vars so they
+// don't need quoting.
+const dotfilesInstallScript string = `set -e
+target="$BRIG_DOTFILES_TARGET_PATH"
+case "$target" in
+	"~") target="$HOME" ;;
+	"~/"*) target="$HOME/${target#"~/"}" ;;
+esac
+if [ -e "$target" ]; then
+	echo "dotfiles are already installed in $target"
+	exit 0
+fi
+mkdir -p "$(dirname "$target")"
+if [ -n "$BRIG_DOTFILES_STAGED" ]; then
+	cp -R "$BRIG_DOTFILES_STAGED" "$target"
+else
+	git clone --depth 1 "$BRIG_DOTFILES_REPOSITORY" "$target"
+fi
+cd "$target"
+if [ -n "$BRIG_DOTFILES_INSTALL_COMMAND" ]; then
+	if [ -f "$BRIG_DOTFILES_INSTALL_COMMAND" ]; then
+		case "$BRIG_DOTFILES_INSTALL_COMMAND" in
+			/*) script="$BRIG_DOTFILES_INSTALL_COMMAND" ;;
+			*) script="./$BRIG_DOTFILES_INSTALL_COMMAND" ;;
+		esac
+		chmod +x "$script"
+		exec "$script"
+	fi
+	exec /bin/sh -c "$BRIG_DOTFILES_INSTALL_COMMAND"
+fi
+for script in install.sh install bootstrap.sh bootstrap script/bootstrap setup.sh setup script/setup; do
+	if [ -f "$script" ]; then
+		chmod +x "$script"
+		exec "./$script"
+	fi
+done
+for dotfile in .[!.]*; do
+	if [ "$dotfile" = ".git" ] || [ ! -e "$dotfile" ]; then
+		continue
+	fi
+	ln -sf "$target/$dotfile" "$HOME/$dotfile"
+done
+`
+
+// installDotfiles clones the repository named by
+// --dotfiles-repository into the devcontainer and installs it as its
+// remoteUser; see dotfilesInstallScript.
+//
+// The repository is cloned inside the devcontainer if it has git;
+// otherwise, it's cloned on the host and copied over.
+func (cmd *Command) installDotfiles(ctx context.Context, p *writ.DevcontainerParser) error {
+	if len(cmd.Options.DotfilesRepository) == 0 {
+		return nil
+	}
+	slog.Debug("installing dotfiles", "repository", cmd.Options.DotfilesRepository)
+
+	env := writ.EnvVarMap{
+		"BRIG_DOTFILES_INSTALL_COMMAND": cmd.Options.DotfilesInstallCommand,
+		"BRIG_DOTFILES_REPOSITORY":      cmd.Options.DotfilesRepository,
+		"BRIG_DOTFILES_TARGET_PATH":     cmd.Options.DotfilesTargetPath,
+	}
+	if len(env["BRIG_DOTFILES_TARGET_PATH"]) == 0 {
+		env["BRIG_DOTFILES_TARGET_PATH"] = DefDotfilesTargetPath
+	}
+
+	stdout, stderr, captured := cmd.lifecycleOutputWriters("DOTFILES", "")
+	start := time.Now()
+	err := cmd.stageDotfiles(ctx, p, env)
+	if err == nil {
+		maps.Copy(env, p.Config.RemoteEnv)
+		err = cmd.trillClient.StreamExecInDevcontainer(ctx, trill.ExecOptions{
+			User:       *p.Config.RemoteUser,
+			Env:        &env,
+			RunInShell: true,
+			Stdout:     stdout,
+			Stderr:     stderr,
+		}, dotfilesInstallScript)
+	}
+	cmd.recordLifecycleResult("DOTFILES", "", start, err)
+	if err != nil {
+		logCapturedLifecycleOutput("DOTFILES", captured)
+	}
+	return err
+}
+
+// stageDotfiles clones the dotfiles repository on the host and copies
+// it into the devcontainer if the devcontainer doesn't have git,
+// noting where it was copied to in env.
+func (cmd *Command) stageDotfiles(ctx context.Context, p *writ.DevcontainerParser, env writ.EnvVarMap) error {
+	err := cmd.trillClient.StreamExecInDevcontainer(ctx, trill.ExecOptions{
+		User:       *p.Config.RemoteUser,
+		RunInShell: true,
+	}, "command -v git")
+	var exitErr *trill.ExecExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+
+	slog.Info("devcontainer doesn't have git; cloning dotfiles on the host")
+	cloneDir, err := os.MkdirTemp("", "brig-dotfiles-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(cloneDir); err != nil {
+			slog.Error("could not remove temporary dotfiles clone", "path", cloneDir, "error", err)
+		}
+	}()
+	repoDir := filepath.Join(cloneDir, "dotfiles")
+	gitCmd := exec.CommandContext(ctx, "git", "clone", "--depth", "1", cmd.Options.DotfilesRepository, repoDir)
+	if out, err := gitCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to clone dotfiles: %w: %s", err, out)
+	}
+
+	stagedName := fmt.Sprintf("brig-dotfiles-%d", time.Now().UnixNano())
+	if err := cmd.trillClient.CopyDirToDevcontainer(ctx, repoDir, dotfilesStagingDir, stagedName); err != nil {
+		return err
+	}
+	env["BRIG_DOTFILES_STAGED"] = dotfilesStagingDir + "/" + stagedName
+	return nil
+}
@@ -0,0 +1,75 @@
+package brig
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runDotfilesInstallScript runs dotfilesInstallScript on the host
+// with home as $HOME, installing the dotfiles staged in staged.
+func runDotfilesInstallScript(t *testing.T, home string, staged string, installCommand string) {
+	execCmd := exec.CommandContext(context.Background(), "/bin/sh", "-c", dotfilesInstallScript)
+	execCmd.Env = append(os.Environ(),
+		"HOME="+home,
+		"BRIG_DOTFILES_INSTALL_COMMAND="+installCommand,
+		"BRIG_DOTFILES_STAGED="+staged,
+		"BRIG_DOTFILES_TARGET_PATH="+DefDotfilesTargetPath,
+	)
+	out, err := execCmd.CombinedOutput()
+	assert.Nil(t, err, string(out))
+}
+
+// TestDotfilesInstallScript checks that dotfiles are symlinked into
+// $HOME if the repository doesn't have an install script, and that
+// the install script is run instead if it does.
+func TestDotfilesInstallScript(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on a POSIX shell")
+	}
+
+	staged := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(staged, ".bashrc"), []byte("# bashrc\n"), 0o644))
+	assert.Nil(t, os.Mkdir(filepath.Join(staged, ".git"), 0o755))
+
+	home := t.TempDir()
+	runDotfilesInstallScript(t, home, staged, "")
+	target, err := os.Readlink(filepath.Join(home, ".bashrc"))
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(home, "dotfiles", ".bashrc"), target)
+	assert.NoFileExists(t, filepath.Join(home, ".git"))
+
+	// Installing again is a no-op
+	runDotfilesInstallScript(t, home, staged, "")
+
+	assert.Nil(t, os.WriteFile(filepath.Join(staged, "install.sh"), []byte("#!/bin/sh\ntouch \"$HOME/installed\"\n"), 0o644))
+	home = t.TempDir()
+	runDotfilesInstallScript(t, home, staged, "")
+	assert.FileExists(t, filepath.Join(home, "installed"))
+	assert.NoFileExists(t, filepath.Join(home, ".bashrc"))
+
+	home = t.TempDir()
+	runDotfilesInstallScript(t, home, staged, "touch \"$HOME/custom\"")
+	assert.FileExists(t, filepath.Join(home, "custom"))
+	assert.NoFileExists(t, filepath.Join(home, "installed"))
+}
+
+// TestInstallDotfilesWithoutRepository checks that nothing happens
+// unless a dotfiles repository is given.
+func TestInstallDotfilesWithoutRepository(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cmd := &Command{}
+	assert.Nil(t, cmd.installDotfiles(context.Background(), nil))
+}
@@ -0,0 +1,252 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// DefaultContextName is the name of the context that stands for the
+// socket found by searching the usual locations, as Docker's own
+// default context does.
+const DefaultContextName string = "default"
+
+// CurrentContextFile is the name of the file, in the cache directory,
+// the context selected with `brig context use` is stored in.
+const CurrentContextFile string = "context"
+
+// ErrUnknownContext is returned when the context asked for is neither
+// in the settings nor in Docker's contexts store.
+var ErrUnknownContext = fmt.Errorf("%w: unknown context", ErrNoSocketFound)
+
+// engineContext is a named engine endpoint, either from the settings
+// or from Docker's contexts store.
+type engineContext struct {
+	Name        string
+	Socket      string
+	Description string
+	Source      string // Where the context is defined: "brig" or "docker"
+}
+
+// runContext implements `brig context [ls | show | use NAME]`, which
+// lists the engine contexts brig knows of, prints the name of the
+// current one, or switches to another one, respectively.
+//
+// Contexts are defined in the settings; Docker's contexts (as created
+// with `docker context create`) are available too, unless a context
+// in the settings has the same name.
+func (cmd *Command) runContext(args []string) ExitCode {
+	switch {
+	case len(args) == 0 || (len(args) == 1 && args[0] == "ls"):
+		return cmd.listContexts()
+	case len(args) == 1 && args[0] == "show":
+		fmt.Fprintln(cmd.stdout(), cmd.currentContext())
+		return ExitNormal
+	case len(args) == 2 && args[0] == "use":
+		return cmd.useContext(args[1])
+	}
+	fmt.Fprintf(cmd.stderr(), "usage: %s context [ls | show | use NAME]\n", cmd.appName)
+	return ExitErrorParsingFlags
+}
+
+// listContexts prints out the known contexts, with the current one
+// marked with an asterisk.
+func (cmd *Command) listContexts() ExitCode {
+	current := cmd.currentContext()
+	w := tabwriter.NewWriter(cmd.stdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSOURCE\tSOCKET\tDESCRIPTION")
+	for _, ec := range cmd.engineContexts() {
+		name := ec.Name
+		if name == current {
+			name += " *"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, ec.Source, ec.Socket, ec.Description)
+	}
+	if err := w.Flush(); err != nil {
+		slog.Error("unable to print out the contexts", "error", err)
+		return ExitError
+	}
+	return ExitNormal
+}
+
+// useContext makes the context named name the current one; switching
+// to DefaultContextName forgets the previous choice instead.
+func (cmd *Command) useContext(name string) ExitCode {
+	if _, err := cmd.contextSocket(name); err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to use context %q: %s\n", name, err)
+		return ExitErrorParsingFlags
+	}
+	cacheDir, err := cmd.getCacheDirectory()
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to record the current context: %s\n", err)
+		return ExitError
+	}
+	if name == DefaultContextName {
+		err = os.Remove(filepath.Join(cacheDir, CurrentContextFile))
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+	} else {
+		err = writeCacheFile(cacheDir, CurrentContextFile, []byte(name+"\n"))
+	}
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to record the current context: %s\n", err)
+		return ExitError
+	}
+	fmt.Fprintln(cmd.stdout(), name)
+	return ExitNormal
+}
+
+// currentContext returns the name of the context to connect to: the
+// one passed with --context, else the one selected with `brig context
+// use`, else the one in the settings, else DefaultContextName.
+func (cmd *Command) currentContext() string {
+	if len(cmd.Options.Context) > 0 {
+		return cmd.Options.Context
+	}
+	if cacheDir, err := cmd.getCacheDirectory(); err == nil {
+		if contents, err := os.ReadFile(filepath.Join(cacheDir, CurrentContextFile)); err == nil {
+			if name := strings.TrimSpace(string(contents)); len(name) > 0 {
+				return name
+			}
+		}
+	}
+	if cmd.settings != nil && len(cmd.settings.Context) > 0 {
+		return cmd.settings.Context
+	}
+	return DefaultContextName
+}
+
+// contextSocket returns the socket address of the context named
+// name, looking in the settings first, then in Docker's contexts
+// store; DefaultContextName yields an empty string, so the usual
+// locations are searched instead.
+func (cmd *Command) contextSocket(name string) (string, error) {
+	if name == DefaultContextName {
+		return "", nil
+	}
+	if cmd.settings != nil {
+		if ctxSettings, ok := cmd.settings.Contexts[name]; ok {
+			slog.Debug("using the socket of a context in the settings", "context", name, "socket", ctxSettings.Socket)
+			return os.ExpandEnv(ctxSettings.Socket), nil
+		}
+	}
+	for _, ec := range dockerContexts() {
+		if ec.Name == name {
+			slog.Debug("using the socket of a Docker context", "context", name, "socket", ec.Socket)
+			return ec.Socket, nil
+		}
+	}
+	return "", fmt.Errorf("%w %q", ErrUnknownContext, name)
+}
+
+// engineContexts returns the default context, followed by the
+// contexts in the settings and those in Docker's contexts store, each
+// sorted by name.
+func (cmd *Command) engineContexts() []engineContext {
+	contexts := []engineContext{{
+		Name:        DefaultContextName,
+		Description: "the first socket found in the usual locations",
+		Source:      cmd.appName,
+	}}
+	var names []string
+	if cmd.settings != nil {
+		for name := range cmd.settings.Contexts {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		ctxSettings := cmd.settings.Contexts[name]
+		contexts = append(contexts, engineContext{
+			Name:        name,
+			Socket:      os.ExpandEnv(ctxSettings.Socket),
+			Description: ctxSettings.Description,
+			Source:      cmd.appName,
+		})
+	}
+	for _, ec := range dockerContexts() {
+		if ec.Name != DefaultContextName && !slices.Contains(names, ec.Name) {
+			contexts = append(contexts, ec)
+		}
+	}
+	return contexts
+}
+
+// dockerContexts returns the contexts in Docker's contexts store
+// (${DOCKER_CONFIG}/contexts, or ~/.docker/contexts), sorted by name.
+//
+// Only the endpoints' addresses are read; contexts whose endpoints
+// need TLS material won't work as-is.
+func dockerContexts() []engineContext {
+	configDir := os.Getenv("DOCKER_CONFIG")
+	if len(configDir) == 0 {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		configDir = filepath.Join(homeDir, ".docker")
+	}
+	metaPaths, err := filepath.Glob(filepath.Join(configDir, "contexts", "meta", "*", "meta.json"))
+	if err != nil {
+		return nil
+	}
+
+	var contexts []engineContext
+	for _, metaPath := range metaPaths {
+		contents, err := os.ReadFile(metaPath)
+		if err != nil {
+			slog.Debug("unable to read Docker context", "path", metaPath, "error", err)
+			continue
+		}
+		var meta struct {
+			Name     string
+			Metadata struct {
+				Description string
+			}
+			Endpoints map[string]struct {
+				Host string
+			}
+		}
+		if err = json.Unmarshal(contents, &meta); err != nil {
+			slog.Debug("unable to parse Docker context", "path", metaPath, "error", err)
+			continue
+		}
+		endpoint, ok := meta.Endpoints["docker"]
+		if len(meta.Name) == 0 || !ok || len(endpoint.Host) == 0 {
+			continue
+		}
+		contexts = append(contexts, engineContext{
+			Name:        meta.Name,
+			Socket:      endpoint.Host,
+			Description: meta.Metadata.Description,
+			Source:      "docker",
+		})
+	}
+	slices.SortFunc(contexts, func(a, b engineContext) int { return strings.Compare(a.Name, b.Name) })
+	return contexts
+}
@@ -0,0 +1,88 @@
+package brig
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRunContext checks that `brig context` lists the contexts in the
+// settings and in Docker's contexts store, and that the one selected
+// with `brig context use` is the one connected to.
+func TestRunContext(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dockerConfig := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dockerConfig)
+	metaDir := filepath.Join(dockerConfig, "contexts", "meta", "0123abcd")
+	assert.Nil(t, os.MkdirAll(metaDir, 0o755))
+	assert.Nil(t, os.WriteFile(filepath.Join(metaDir, "meta.json"), []byte(`{
+		"Name": "remote",
+		"Metadata": {"Description": "build box"},
+		"Endpoints": {"docker": {"Host": "tcp://build.example.com:2375"}}
+	}`), 0o644))
+
+	var stdout, stderr bytes.Buffer
+	cmd := New("brig", "")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.settings = &Settings{
+		CacheDir: t.TempDir(),
+		Contexts: map[string]ContextSettings{
+			"podman": {Socket: "unix://${BRIG_TEST_RUNTIME_DIR}/podman.sock", Description: "local Podman"},
+		},
+	}
+	t.Setenv("BRIG_TEST_RUNTIME_DIR", "/run/user/1000")
+
+	cmd.Arguments = []string{"context", "ls"}
+	exitCode, ok := cmd.runSubcommand()
+	assert.True(t, ok)
+	assert.Equal(t, ExitNormal, exitCode, stderr.String())
+	assert.Regexp(t, `(?m)^default \*\s+brig`, stdout.String())
+	assert.Regexp(t, `(?m)^podman\s+brig\s+unix:///run/user/1000/podman.sock\s+local Podman$`, stdout.String())
+	assert.Regexp(t, `(?m)^remote\s+docker\s+tcp://build.example.com:2375\s+build box$`, stdout.String())
+
+	cmd.Arguments = []string{"context", "use", "nonexistent"}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitErrorParsingFlags, exitCode)
+	assert.Equal(t, DefaultContextName, cmd.currentContext())
+
+	cmd.Arguments = []string{"context", "use", "remote"}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitNormal, exitCode, stderr.String())
+	stdout.Reset()
+	cmd.Arguments = []string{"context", "show"}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitNormal, exitCode)
+	assert.Equal(t, "remote\n", stdout.String())
+	socket, err := cmd.contextSocket(cmd.currentContext())
+	assert.Nil(t, err)
+	assert.Equal(t, "tcp://build.example.com:2375", socket)
+
+	// --context takes precedence over the context in use
+	cmd.Options.Context = "podman"
+	socket, err = cmd.contextSocket(cmd.currentContext())
+	assert.Nil(t, err)
+	assert.Equal(t, "unix:///run/user/1000/podman.sock", socket)
+	cmd.Options.Context = ""
+
+	// Switching back to the default context leads to the usual
+	// locations being searched
+	cmd.Arguments = []string{"context", "use", DefaultContextName}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitNormal, exitCode, stderr.String())
+	assert.Equal(t, DefaultContextName, cmd.currentContext())
+	socket, err = cmd.contextSocket(cmd.currentContext())
+	assert.Nil(t, err)
+	assert.Empty(t, socket)
+
+	// Unknown contexts are reported as a missing socket
+	cmd.Options.Context = "nonexistent"
+	assert.ErrorIs(t, cmd.Connect(""), ErrNoSocketFound)
+}
@@ -0,0 +1,412 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// featureCacheDirName is the subdirectory of the cache directory
+// Features distributed as OCI artifacts are cached in.
+const featureCacheDirName string = "features"
+
+// featureCacheSumExt is the extension of the file that sits next to
+// each cached Feature, listing the checksums of its files.
+const featureCacheSumExt string = ".sum"
+
+// featureCacheTempPrefix prefixes the names of the directories
+// Features are extracted into before being moved into place.
+const featureCacheTempPrefix string = ".tmp-"
+
+// featureCacheTempMaxAge is how old a temporary directory has to be
+// before it's assumed to have been left behind by a run that died.
+const featureCacheTempMaxAge time.Duration = time.Hour
+
+// DefFeatureCacheMaxSize is the size, in bytes, the Feature cache is
+// trimmed down to, unless cache-max-size says otherwise.
+const DefFeatureCacheMaxSize int64 = 1 << 30
+
+// ErrFeatureCacheCorrupt is returned when a cached Feature doesn't
+// match the checksums recorded when it was cached.
+var ErrFeatureCacheCorrupt = errors.New("cached feature doesn't match its checksums")
+
+// featureCacheDirectory returns the directory Features distributed
+// as OCI artifacts are cached in, creating it if needed.
+//
+// Each is kept in a directory named after the digest of its manifest
+// (e.g., features/sha256/<hex>), so different references to the same
+// artifact share a copy, and a reference that's been moved to another
+// artifact never picks up the old one.
+func (cmd *Command) featureCacheDirectory() (string, error) {
+	cacheDir, err := cmd.getCacheDirectory()
+	if err != nil {
+		return "", err
+	}
+	cacheRoot := filepath.Join(cacheDir, featureCacheDirName)
+	return cacheRoot, os.MkdirAll(cacheRoot, fs.ModeDir|0755)
+}
+
+// featureCachePath returns where the artifact whose manifest has the
+// digest dgst is cached in cacheRoot.
+func featureCachePath(cacheRoot string, dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheRoot, dgst.Algorithm().String(), dgst.Encoded()), nil
+}
+
+// cachedFeatureArtifact returns the path to the cached copy of the
+// artifact whose manifest has the digest dgst, after checking that
+// it's intact; see verifyFeatureCacheEntry.
+//
+// The copy is marked as used, for the benefit of
+// gcFeatureCache.
+func cachedFeatureArtifact(cacheRoot string, dgst digest.Digest) (string, error) {
+	path, err := featureCachePath(cacheRoot, dgst)
+	if err != nil {
+		return "", err
+	}
+	if err = verifyFeatureCacheEntry(path); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if err = os.Chtimes(path, now, now); err != nil {
+		slog.Warn("unable to mark cached feature as used", "path", path, "error", err)
+	}
+	return path, nil
+}
+
+// cacheFeatureArtifact returns the path to the cached copy of the
+// artifact ref, whose manifest has the digest dgst, pulling it from
+// repo first if there's no intact copy.
+//
+// The artifact is extracted into a temporary directory, which is then
+// moved into place, so other runs never see it half-extracted.
+func (cmd *Command) cacheFeatureArtifact(ctx context.Context, cacheRoot string, repo *remote.Repository, ref string, dgst digest.Digest) (string, error) {
+	path, err := cachedFeatureArtifact(cacheRoot, dgst)
+	switch {
+	case err == nil:
+		slog.Info("using cached copy of feature", "reference", ref, "digest", dgst)
+		return path, nil
+	case errors.Is(err, ErrFeatureCacheCorrupt):
+		slog.Warn("cached copy of feature is corrupt; pulling it again", "reference", ref, "digest", dgst, "error", err)
+	case !errors.Is(err, fs.ErrNotExist):
+		return "", err
+	}
+
+	if path, err = featureCachePath(cacheRoot, dgst); err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), fs.ModeDir|0755); err != nil {
+		return "", err
+	}
+	tempDir, err := os.MkdirTemp(filepath.Dir(path), featureCacheTempPrefix)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if removeErr := os.RemoveAll(tempDir); removeErr != nil {
+			slog.Error("could not remove temporary feature directory", "path", tempDir, "error", removeErr)
+		}
+	}()
+	if err = extractArtifactLayer(ctx, repo, ref, tempDir); err != nil {
+		return "", err
+	}
+
+	// The checksums go in first: a copy without them is taken to be
+	// corrupt, while checksums without a copy are just overwritten
+	if err = writeFeatureCacheSum(tempDir, path+featureCacheSumExt); err != nil {
+		return "", err
+	}
+	if err = os.RemoveAll(path); err != nil {
+		return "", err
+	}
+	if err = os.Rename(tempDir, path); err != nil {
+		// Another run may have beaten us to it
+		if cachedPath, cacheErr := cachedFeatureArtifact(cacheRoot, dgst); cacheErr == nil {
+			return cachedPath, nil
+		}
+		return "", err
+	}
+	slog.Debug("cached feature", "reference", ref, "digest", dgst, "path", path)
+	return path, nil
+}
+
+// featureCacheSums returns the SHA-256 checksums of the regular files
+// in dir, keyed by their paths relative to it, with forward slashes.
+func featureCacheSums(dir string) (map[string]string, error) {
+	sums := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		hash := sha256.New()
+		if _, err = io.Copy(hash, file); err != nil {
+			return err
+		}
+		sums[filepath.ToSlash(rel)] = hex.EncodeToString(hash.Sum(nil))
+		return nil
+	})
+	return sums, err
+}
+
+// writeFeatureCacheSum writes the checksums of the files in dir to
+// sumPath, in the format sha256sum uses.
+func writeFeatureCacheSum(dir string, sumPath string) error {
+	sums, err := featureCacheSums(dir)
+	if err != nil {
+		return err
+	}
+	var contents strings.Builder
+	for _, rel := range slices.Sorted(maps.Keys(sums)) {
+		fmt.Fprintf(&contents, "%s  %s\n", sums[rel], rel)
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(sumPath), featureCacheTempPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.WriteString(contents.String())
+	if err = errors.Join(err, tempFile.Close()); err == nil {
+		err = os.Rename(tempFile.Name(), sumPath)
+	}
+	if err != nil {
+		return errors.Join(err, os.Remove(tempFile.Name()))
+	}
+	return nil
+}
+
+// verifyFeatureCacheEntry checks that the files in the cached Feature
+// at path match the checksums recorded next to it when it was cached,
+// no more and no less.
+//
+// Returns an error wrapping fs.ErrNotExist if there's no such copy,
+// and ErrFeatureCacheCorrupt if it doesn't match.
+func verifyFeatureCacheEntry(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	sumFile, err := os.Open(path + featureCacheSumExt)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: no checksums recorded", ErrFeatureCacheCorrupt)
+	} else if err != nil {
+		return err
+	}
+	defer sumFile.Close()
+
+	recorded := make(map[string]string)
+	scanner := bufio.NewScanner(sumFile)
+	for scanner.Scan() {
+		sum, rel, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			return fmt.Errorf("%w: malformed checksums", ErrFeatureCacheCorrupt)
+		}
+		recorded[rel] = sum
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	actual, err := featureCacheSums(path)
+	if err != nil {
+		return err
+	}
+	if !maps.Equal(recorded, actual) {
+		return ErrFeatureCacheCorrupt
+	}
+	return nil
+}
+
+// collectFeatureCacheGarbage trims the Feature cache down; see
+// gcFeatureCache. Features used in this run are kept regardless.
+//
+// Copies cached under the old layout, in directories named after the
+// references to them, are removed as well.
+func (cmd *Command) collectFeatureCacheGarbage() error {
+	if cmd.featureArtifactsDigests == nil {
+		return nil
+	}
+	maxSize := DefFeatureCacheMaxSize
+	if cmd.settings != nil {
+		var err error
+		if maxSize, err = cmd.settings.cacheMaxSize(); err != nil {
+			return err
+		}
+	}
+	cacheDir, err := cmd.getCacheDirectory()
+	if err != nil {
+		return err
+	}
+
+	referenced := make(map[digest.Digest]bool)
+	for ref, entry := range cmd.featureArtifactsDigests.Entries {
+		referenced[digest.Digest(entry.Digest)] = true
+
+		legacyPath := filepath.Join(append([]string{cacheDir}, strings.Split(ref, ":")...)...)
+		if _, err := os.Stat(filepath.Join(legacyPath, "devcontainer-feature.json")); err == nil {
+			slog.Debug("removing feature cached under the old layout", "reference", ref, "path", legacyPath)
+			if err = os.RemoveAll(legacyPath); err != nil {
+				slog.Warn("unable to remove feature cached under the old layout", "path", legacyPath, "error", err)
+			}
+		}
+	}
+	return gcFeatureCache(filepath.Join(cacheDir, featureCacheDirName), referenced, slices.Collect(maps.Values(cmd.featurePathLookup)), maxSize)
+}
+
+// featureCacheEntry is a Feature cached in the Feature cache.
+type featureCacheEntry struct {
+	Path     string
+	Digest   digest.Digest
+	Size     int64
+	LastUsed time.Time
+	InUse    bool
+}
+
+// gcFeatureCache removes the copies in the Feature cache at cacheRoot
+// that aren't referenced, then, if what's left takes up more than
+// maxSize bytes, the least recently used ones until it doesn't. A
+// maxSize of 0 or less disables the latter.
+//
+// Copies at paths in inUse count towards the size, but are never
+// removed. Leftover temporary
+// directories, and checksums without a copy, are cleaned up as well.
+func gcFeatureCache(cacheRoot string, referenced map[digest.Digest]bool, inUse []string, maxSize int64) error {
+	algorithmDirs, err := os.ReadDir(cacheRoot)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var entries []featureCacheEntry
+	var errs []error
+	remove := func(path string) {
+		slog.Debug("removing from the feature cache", "path", path)
+		errs = append(errs, os.RemoveAll(path), os.RemoveAll(path+featureCacheSumExt))
+	}
+	for _, algorithmDir := range algorithmDirs {
+		if !algorithmDir.IsDir() {
+			continue
+		}
+		dir := filepath.Join(cacheRoot, algorithmDir.Name())
+		dirEntries, err := os.ReadDir(dir)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, dirEntry := range dirEntries {
+			path := filepath.Join(dir, dirEntry.Name())
+			info, err := dirEntry.Info()
+			if errors.Is(err, fs.ErrNotExist) {
+				// Removed along with the copy it belongs to
+				continue
+			} else if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			switch {
+			case strings.HasPrefix(dirEntry.Name(), featureCacheTempPrefix):
+				if time.Since(info.ModTime()) > featureCacheTempMaxAge {
+					errs = append(errs, os.RemoveAll(path))
+				}
+				continue
+			case strings.HasSuffix(dirEntry.Name(), featureCacheSumExt):
+				if _, err := os.Stat(strings.TrimSuffix(path, featureCacheSumExt)); errors.Is(err, fs.ErrNotExist) {
+					errs = append(errs, os.Remove(path))
+				}
+				continue
+			case !dirEntry.IsDir():
+				continue
+			}
+
+			dgst := digest.NewDigestFromEncoded(digest.Algorithm(algorithmDir.Name()), dirEntry.Name())
+			isInUse := slices.Contains(inUse, path)
+			if !referenced[dgst] && !isInUse {
+				remove(path)
+				continue
+			}
+			size, err := dirSize(path)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			entries = append(entries, featureCacheEntry{Path: path, Digest: dgst, Size: size, LastUsed: info.ModTime(), InUse: isInUse})
+		}
+	}
+
+	if maxSize > 0 {
+		var totalSize int64
+		for _, entry := range entries {
+			totalSize += entry.Size
+		}
+		slices.SortFunc(entries, func(a, b featureCacheEntry) int { return a.LastUsed.Compare(b.LastUsed) })
+		for _, entry := range entries {
+			if totalSize <= maxSize {
+				break
+			}
+			if entry.InUse {
+				continue
+			}
+			slog.Info("feature cache is over its maximum size; evicting least recently used copy", "digest", entry.Digest)
+			remove(entry.Path)
+			totalSize -= entry.Size
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// dirSize returns the total size of the regular files in dir.
+func dirSize(dir string) (size int64, err error) {
+	err = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,112 @@
+package brig
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+// makeFeatureCacheEntry creates a cached copy of a Feature with the
+// digest dgst in cacheRoot, last used at lastUsed.
+func makeFeatureCacheEntry(t *testing.T, cacheRoot string, dgst digest.Digest, contents string, lastUsed time.Time) string {
+	path, err := featureCachePath(cacheRoot, dgst)
+	assert.Nil(t, err)
+	assert.Nil(t, os.MkdirAll(filepath.Join(path, "lib"), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(path, "install.sh"), []byte(contents), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(path, "lib", "common.sh"), []byte("true\n"), 0644))
+	assert.Nil(t, writeFeatureCacheSum(path, path+featureCacheSumExt))
+	assert.Nil(t, os.Chtimes(path, lastUsed, lastUsed))
+	return path
+}
+
+// TestVerifyFeatureCacheEntry checks that cached Features are only
+// accepted if their files match the recorded checksums exactly.
+func TestVerifyFeatureCacheEntry(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cacheRoot := t.TempDir()
+	dgst := digest.FromString("feature")
+	path := makeFeatureCacheEntry(t, cacheRoot, dgst, "#!/bin/sh\n", time.Now())
+	assert.Nil(t, verifyFeatureCacheEntry(path))
+
+	cachedPath, err := cachedFeatureArtifact(cacheRoot, dgst)
+	assert.Nil(t, err)
+	assert.Equal(t, path, cachedPath)
+
+	_, err = cachedFeatureArtifact(cacheRoot, digest.FromString("missing"))
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	assert.Nil(t, os.WriteFile(filepath.Join(path, "extra.sh"), []byte("true\n"), 0644))
+	assert.True(t, errors.Is(verifyFeatureCacheEntry(path), ErrFeatureCacheCorrupt))
+	assert.Nil(t, os.Remove(filepath.Join(path, "extra.sh")))
+	assert.Nil(t, verifyFeatureCacheEntry(path))
+
+	assert.Nil(t, os.WriteFile(filepath.Join(path, "install.sh"), []byte("#!/bin/bash\n"), 0755))
+	assert.True(t, errors.Is(verifyFeatureCacheEntry(path), ErrFeatureCacheCorrupt))
+
+	assert.Nil(t, os.Remove(path+featureCacheSumExt))
+	assert.True(t, errors.Is(verifyFeatureCacheEntry(path), ErrFeatureCacheCorrupt))
+}
+
+// TestGCFeatureCache checks that unreferenced Features and leftovers
+// are removed, and that the least recently used Features are evicted
+// when the cache is over its maximum size.
+func TestGCFeatureCache(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cacheRoot := t.TempDir()
+	now := time.Now()
+	oldest := makeFeatureCacheEntry(t, cacheRoot, digest.FromString("oldest"), "1234567890", now.Add(-3*time.Hour))
+	older := makeFeatureCacheEntry(t, cacheRoot, digest.FromString("older"), "1234567890", now.Add(-2*time.Hour))
+	newest := makeFeatureCacheEntry(t, cacheRoot, digest.FromString("newest"), "1234567890", now)
+	unreferenced := makeFeatureCacheEntry(t, cacheRoot, digest.FromString("unreferenced"), "1234567890", now)
+	orphanSum := filepath.Join(filepath.Dir(newest), digest.FromString("orphan").Encoded()+featureCacheSumExt)
+	assert.Nil(t, os.WriteFile(orphanSum, nil, 0644))
+	staleTemp := filepath.Join(filepath.Dir(newest), featureCacheTempPrefix+"stale")
+	assert.Nil(t, os.Mkdir(staleTemp, 0755))
+	assert.Nil(t, os.Chtimes(staleTemp, now.Add(-2*featureCacheTempMaxAge), now.Add(-2*featureCacheTempMaxAge)))
+	freshTemp := filepath.Join(filepath.Dir(newest), featureCacheTempPrefix+"fresh")
+	assert.Nil(t, os.Mkdir(freshTemp, 0755))
+
+	referenced := map[digest.Digest]bool{
+		digest.FromString("oldest"): true,
+		digest.FromString("older"):  true,
+		digest.FromString("newest"): true,
+	}
+	entrySize, err := dirSize(newest)
+	assert.Nil(t, err)
+
+	// Room for two entries, but the oldest one is in use
+	assert.Nil(t, gcFeatureCache(cacheRoot, referenced, []string{oldest}, 2*entrySize))
+	for path, kept := range map[string]bool{
+		oldest:       true,
+		older:        false,
+		newest:       true,
+		unreferenced: false,
+		orphanSum:    false,
+		staleTemp:    false,
+		freshTemp:    true,
+	} {
+		_, err := os.Stat(path)
+		assert.Equal(t, kept, err == nil, path)
+	}
+	_, err = os.Stat(older + featureCacheSumExt)
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	// No maximum size
+	assert.Nil(t, gcFeatureCache(cacheRoot, referenced, nil, 0))
+	_, err = os.Stat(oldest)
+	assert.Nil(t, err)
+
+	assert.Nil(t, gcFeatureCache(filepath.Join(cacheRoot, "missing"), referenced, nil, 0))
+}
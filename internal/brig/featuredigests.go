@@ -17,21 +17,50 @@
 package brig
 
 import (
+	"encoding/json"
 	"errors"
+	"io/fs"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 
 	"github.com/gocarina/gocsv"
 )
 
+// ArtifactDigestsFile is the name of the file, in the cache
+// directory, the artifact digest lookup table is stored in.
+const ArtifactDigestsFile string = "digests.json"
+
+// legacyArtifactDigestsFile is the name of the CSV file the artifact
+// digest lookup table used to be stored in; it's migrated on load.
+const legacyArtifactDigestsFile string = "digests.csv"
+
+// artifactDigestsLockFile is the name of the file, in the cache
+// directory, locked while the artifact digest lookup table is read or
+// written, so concurrent runs don't trample over each other.
+const artifactDigestsLockFile string = "digests.lock"
+
 type ArtifactDigestEntry struct {
-	FeatureID string `csv:"feature_id"`
-	Digest    string `csv:"digest"`
+	FeatureID string `csv:"feature_id" json:"featureId"`
+	Digest    string `csv:"digest" json:"digest"`
 }
 
 type ArtifactDigest struct {
 	Entries map[string]*ArtifactDigestEntry
+	updated map[string]bool // IDs of the entries recorded during this run
+}
+
+// Record adds entry to the table, replacing the one for the same
+// Feature, if any. Only recorded entries are written back by
+// SaveArtifactDigest; the rest are left as they are on disk.
+func (d *ArtifactDigest) Record(entry *ArtifactDigestEntry) {
+	if d.updated == nil {
+		d.updated = make(map[string]bool)
+	}
+	d.Entries[entry.FeatureID] = entry
+	d.updated[entry.FeatureID] = true
 }
 
 func (cmd *Command) LoadArtifactDigest() error {
@@ -46,35 +75,29 @@ func (cmd *Command) LoadArtifactDigest() error {
 		return err
 	}
 
-	digestsTablePath := filepath.Join(cacheDir, "digests.csv")
-	digestsTable, err := os.OpenFile(digestsTablePath, os.O_CREATE|os.O_RDWR, 0o644)
+	var entries map[string]*ArtifactDigestEntry
+	err = withCacheFileLock(cacheDir, artifactDigestsLockFile, func() (err error) {
+		var migrate bool
+		if entries, migrate, err = readArtifactDigests(cacheDir); err != nil || !migrate {
+			return err
+		}
+		slog.Info("migrating artifact digest lookup table", "from", legacyArtifactDigestsFile, "to", ArtifactDigestsFile)
+		return writeArtifactDigests(cacheDir, entries)
+	})
 	if err != nil {
 		return err
 	}
-	defer digestsTable.Close()
-
-	digests := []*ArtifactDigestEntry{}
-	slog.Debug("attempting to unmarshal digests table")
-	if err := gocsv.UnmarshalFile(digestsTable, &digests); err != nil && !errors.Is(err, gocsv.ErrEmptyCSVFile) {
-		return err
-	} else {
-		// Initialize the struct manually
-		cmd.featureArtifactsDigests = &ArtifactDigest{
-			Entries: make(map[string]*ArtifactDigestEntry),
-		}
-	}
-	slog.Debug("digests table successful unmarshalled")
-	slog.Debug("artifact digest entries loaded", "count", len(digests))
-
-	for _, digest := range digests {
-		cmd.featureArtifactsDigests.Entries[digest.FeatureID] = digest
-	}
+	slog.Debug("artifact digest entries loaded", "count", len(entries))
 
+	cmd.featureArtifactsDigests = &ArtifactDigest{Entries: entries}
 	return nil
 }
 
+// SaveArtifactDigest writes the entries recorded during this run to
+// the artifact digest lookup table, on top of whatever's on disk at
+// the time; entries saved by other runs in the meantime are kept.
 func (cmd *Command) SaveArtifactDigest() error {
-	if cmd.featureArtifactsDigests == nil || len(cmd.featureArtifactsDigests.Entries) == 0 {
+	if cmd.featureArtifactsDigests == nil || len(cmd.featureArtifactsDigests.updated) == 0 {
 		return nil
 	}
 
@@ -85,22 +108,87 @@ func (cmd *Command) SaveArtifactDigest() error {
 		return err
 	}
 
-	digestsTablePath := filepath.Join(cacheDir, "digests.csv")
-	digestsTable, err := os.OpenFile(digestsTablePath, os.O_CREATE|os.O_RDWR, 0o644)
+	return withCacheFileLock(cacheDir, artifactDigestsLockFile, func() error {
+		entries, _, err := readArtifactDigests(cacheDir)
+		if err != nil {
+			return err
+		}
+		for featureID := range cmd.featureArtifactsDigests.updated {
+			entries[featureID] = cmd.featureArtifactsDigests.Entries[featureID]
+		}
+		slog.Debug("artifact digest entries to be saved", "count", len(entries))
+		if err = writeArtifactDigests(cacheDir, entries); err != nil {
+			return err
+		}
+		cmd.featureArtifactsDigests.Entries = entries
+		cmd.featureArtifactsDigests.updated = nil
+		return nil
+	})
+}
+
+// readArtifactDigests reads the artifact digest lookup table in
+// cacheDir, falling back to the legacy CSV file if it hasn't been
+// migrated yet; if it has to, migrate is true.
+//
+// The caller is expected to hold the lock on the table.
+func readArtifactDigests(cacheDir string) (entries map[string]*ArtifactDigestEntry, migrate bool, err error) {
+	entries = make(map[string]*ArtifactDigestEntry)
+	var digests []*ArtifactDigestEntry
+
+	contents, err := os.ReadFile(filepath.Join(cacheDir, ArtifactDigestsFile))
+	switch {
+	case err == nil:
+		if err = json.Unmarshal(contents, &digests); err != nil {
+			return nil, false, err
+		}
+	case errors.Is(err, fs.ErrNotExist):
+		legacyTable, err := os.Open(filepath.Join(cacheDir, legacyArtifactDigestsFile))
+		if errors.Is(err, fs.ErrNotExist) {
+			return entries, false, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		defer legacyTable.Close()
+		if err = gocsv.UnmarshalFile(legacyTable, &digests); err != nil && !errors.Is(err, gocsv.ErrEmptyCSVFile) {
+			// The old table could be corrupted by concurrent runs;
+			// it's only a cache, so start over instead
+			slog.Warn("discarding unreadable legacy artifact digest lookup table", "error", err)
+			digests = nil
+		}
+		migrate = true
+	default:
+		return nil, false, err
+	}
+
+	for _, digest := range digests {
+		if digest != nil && len(digest.FeatureID) > 0 {
+			entries[digest.FeatureID] = digest
+		}
+	}
+	return entries, migrate, nil
+}
+
+// writeArtifactDigests atomically replaces the artifact digest lookup
+// table in cacheDir with entries. The legacy CSV file is removed.
+//
+// The caller is expected to hold the lock on the table.
+func writeArtifactDigests(cacheDir string, entries map[string]*ArtifactDigestEntry) error {
+	digests := make([]*ArtifactDigestEntry, 0, len(entries))
+	for _, featureID := range slices.Sorted(maps.Keys(entries)) {
+		digests = append(digests, entries[featureID])
+	}
+	contents, err := json.MarshalIndent(digests, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	digests := []*ArtifactDigestEntry{}
-	for _, digestEntry := range cmd.featureArtifactsDigests.Entries {
-		digests = append(digests, digestEntry)
-	}
-	slog.Debug("artifact digest entries to be marshalled", "count", len(digests))
-	slog.Debug("attempting to marshal digests table")
-	if err = gocsv.MarshalFile(&digests, digestsTable); err != nil {
+	if err = writeCacheFile(cacheDir, ArtifactDigestsFile, contents); err != nil {
 		return err
 	}
-	slog.Debug("digests table successfully marshalled")
+	slog.Debug("artifact digest lookup table saved", "count", len(digests))
 
+	if err = os.Remove(filepath.Join(cacheDir, legacyArtifactDigestsFile)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,72 @@
+package brig
+
+import (
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestArtifactDigestMigration checks that the legacy CSV lookup table
+// is migrated on load.
+func TestArtifactDigestMigration(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cacheDir := t.TempDir()
+	legacy := "feature_id,digest\nghcr.io/devcontainers/features/go:1,sha256:1234\n"
+	assert.Nil(t, os.WriteFile(filepath.Join(cacheDir, legacyArtifactDigestsFile), []byte(legacy), 0o644))
+
+	cmd := New("brig", "")
+	cmd.settings = &Settings{CacheDir: cacheDir}
+	assert.Nil(t, cmd.LoadArtifactDigest())
+	assert.Equal(t, "sha256:1234", cmd.featureArtifactsDigests.Entries["ghcr.io/devcontainers/features/go:1"].Digest)
+
+	_, err := os.Stat(filepath.Join(cacheDir, legacyArtifactDigestsFile))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	cmd = New("brig", "")
+	cmd.settings = &Settings{CacheDir: cacheDir}
+	assert.Nil(t, cmd.LoadArtifactDigest())
+	assert.Len(t, cmd.featureArtifactsDigests.Entries, 1)
+}
+
+// TestArtifactDigestConcurrentSaves checks that runs saving the lookup
+// table at the same time keep each other's entries.
+func TestArtifactDigestConcurrentSaves(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cacheDir := t.TempDir()
+	featureIDs := []string{"ghcr.io/a/feature:1", "ghcr.io/b/feature:1", "ghcr.io/c/feature:1", "ghcr.io/d/feature:1"}
+	cmds := make([]*Command, len(featureIDs))
+	for idx := range featureIDs {
+		cmds[idx] = New("brig", "")
+		cmds[idx].settings = &Settings{CacheDir: cacheDir}
+		assert.Nil(t, cmds[idx].LoadArtifactDigest())
+	}
+
+	var wg sync.WaitGroup
+	for idx, featureID := range featureIDs {
+		cmds[idx].featureArtifactsDigests.Record(&ArtifactDigestEntry{FeatureID: featureID, Digest: "sha256:" + featureID})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, cmds[idx].SaveArtifactDigest())
+		}()
+	}
+	wg.Wait()
+
+	cmd := New("brig", "")
+	cmd.settings = &Settings{CacheDir: cacheDir}
+	assert.Nil(t, cmd.LoadArtifactDigest())
+	assert.Len(t, cmd.featureArtifactsDigests.Entries, len(featureIDs))
+	for _, featureID := range featureIDs {
+		assert.Equal(t, "sha256:"+featureID, cmd.featureArtifactsDigests.Entries[featureID].Digest)
+	}
+}
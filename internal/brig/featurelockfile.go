@@ -0,0 +1,141 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FeaturesLockfileName is the name of the file, next to
+// devcontainer.json, the versions its Features resolved to are
+// recorded in.
+//
+// https://github.com/devcontainers/spec/blob/main/docs/specs/devcontainer-lockfile.md
+const FeaturesLockfileName string = "devcontainer-lock.json"
+
+// FeaturesLockfile pins the Features a devcontainer uses to the
+// artifacts they resolved to, so they can be reproduced.
+//
+// Its entries are keyed by the Features' identifiers as they're
+// written in devcontainer.json (or, for the Features only depended
+// on, in the devcontainer-feature.json that depends on them).
+type FeaturesLockfile struct {
+	Features map[string]*FeaturesLockfileEntry `json:"features"`
+}
+
+// FeaturesLockfileEntry records what a reference to a Feature
+// resolved to.
+type FeaturesLockfileEntry struct {
+	Version   string   `json:"version"`             // The version in its devcontainer-feature.json
+	Resolved  string   `json:"resolved"`            // A reference to the exact artifact; e.g., ghcr.io/devcontainers/features/go@sha256:...
+	Integrity string   `json:"integrity"`           // The digest of the artifact's manifest
+	DependsOn []string `json:"dependsOn,omitempty"` // The references selected for the Features it depends on
+}
+
+// lookup returns the entry for the Feature ref, however it's written;
+// see parseFeatureReference.
+func (l *FeaturesLockfile) lookup(ref string) (entry *FeaturesLockfileEntry, ok bool) {
+	if entry, ok = l.Features[ref]; ok {
+		return entry, true
+	}
+	featureRef := parseFeatureReference(ref)
+	for key, entry := range l.Features {
+		if parseFeatureReference(key) == featureRef {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// featuresLockfileKey returns the key of the Feature selected, as a
+// reference, in a lockfile: how it's written in devcontainer.json, if
+// it's there, or in the first of requests that asks for it otherwise.
+func featuresLockfileKey(selected string, requests []featureRequest) string {
+	selectedRef := parseFeatureReference(selected)
+	key := selected
+	found := false
+	for _, request := range requests {
+		if parseFeatureReference(request.Ref) != selectedRef {
+			continue
+		}
+		if len(request.RequestedBy) == 0 {
+			return request.Ref
+		}
+		if !found {
+			key, found = request.Ref, true
+		}
+	}
+	return key
+}
+
+// featuresLockfilePath returns the path to the lockfile of the
+// devcontainer.json at configPath: next to it, and hidden if it is.
+func featuresLockfilePath(configPath string) string {
+	name := FeaturesLockfileName
+	if strings.HasPrefix(filepath.Base(configPath), ".") {
+		name = "." + name
+	}
+	return filepath.Join(filepath.Dir(configPath), name)
+}
+
+// readFeaturesLockfile reads the lockfile at path; if there's none,
+// returns nil, without an error.
+func readFeaturesLockfile(path string) (*FeaturesLockfile, error) {
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	lockfile := &FeaturesLockfile{}
+	if err = json.Unmarshal(contents, lockfile); err != nil {
+		return nil, err
+	}
+	if lockfile.Features == nil {
+		lockfile.Features = make(map[string]*FeaturesLockfileEntry)
+	}
+	return lockfile, nil
+}
+
+// writeFeaturesLockfile atomically replaces the lockfile at path with
+// lockfile.
+func writeFeaturesLockfile(path string, lockfile *FeaturesLockfile) error {
+	contents, err := json.MarshalIndent(lockfile, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(append(contents, '\n'))
+	if err == nil {
+		err = tempFile.Chmod(0o644)
+	}
+	if err = errors.Join(err, tempFile.Close()); err == nil {
+		err = os.Rename(tempFile.Name(), path)
+	}
+	if err != nil {
+		return errors.Join(err, os.Remove(tempFile.Name()))
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/nlsantos/brig/writ"
+	"golang.org/x/mod/semver"
+)
+
+// MaxFeatureResolutionRounds caps the number of times the versions of
+// Features are reselected as the dependsOn fields of newly retrieved
+// ones come in; see resolveFeatures.
+const MaxFeatureResolutionRounds int = 32
+
+// featureReference is a reference to a Feature, split into the
+// Feature it refers to and the version of it that's wanted.
+type featureReference struct {
+	ID      string // Canonical ID, sans version; e.g., ghcr.io/devcontainers/features/go
+	Version string // Tag or digest; empty for Features that aren't OCI artifacts
+}
+
+// parseFeatureReference splits the reference to a Feature ref into
+// its canonical ID and version, so that references to the same
+// Feature (e.g., ghcr.io/devcontainers/features/go and
+// GHCR.io/devcontainers/features/go:latest) can be told apart from
+// references to different ones.
+//
+// Locally-stored Features have their paths cleaned, and tarballs are
+// left as they are; neither have versions. OCI artifacts without a
+// tag or digest are taken to be at latest.
+func parseFeatureReference(ref string) featureReference {
+	switch {
+	case strings.HasPrefix(ref, "./") || strings.HasPrefix(ref, "../"):
+		return featureReference{ID: "./" + path.Clean(ref)}
+	case strings.HasPrefix(ref, "https://"):
+		return featureReference{ID: ref}
+	}
+
+	// Repository names are case-insensitive, but tags aren't
+	if id, dgst, ok := strings.Cut(ref, "@"); ok {
+		return featureReference{ID: strings.ToLower(id), Version: dgst}
+	}
+	// Only look for a tag past the last slash, so ports in registry
+	// hosts aren't mistaken for one
+	if idx := strings.LastIndex(ref, ":"); idx > strings.LastIndex(ref, "/") {
+		return featureReference{ID: strings.ToLower(ref[:idx]), Version: ref[idx+1:]}
+	}
+	return featureReference{ID: strings.ToLower(ref), Version: "latest"}
+}
+
+// String returns the reference r stands for.
+func (r featureReference) String() string {
+	switch {
+	case len(r.Version) == 0:
+		return r.ID
+	case strings.Contains(r.Version, ":"):
+		return r.ID + "@" + r.Version
+	default:
+		return r.ID + ":" + r.Version
+	}
+}
+
+// semverComponents returns the components of version if it's a
+// semantic version, or a prefix of one (e.g., 1 or 1.2, as Features
+// are tagged); otherwise, nil.
+func semverComponents(version string) []string {
+	if !semver.IsValid("v"+version) || len(semver.Prerelease("v"+version)) > 0 || len(semver.Build("v"+version)) > 0 {
+		return nil
+	}
+	return strings.Split(version, ".")
+}
+
+// featureVersionsCompatible reports whether there's a version of a
+// Feature that both the versions a and b allow for. latest allows for
+// any, a partial semantic version (e.g., 1.2) any that it's a prefix
+// of, and anything else (e.g., a digest) only itself.
+func featureVersionsCompatible(a string, b string) bool {
+	if a == b || a == "latest" || b == "latest" {
+		return true
+	}
+	aComponents, bComponents := semverComponents(a), semverComponents(b)
+	if aComponents == nil || bComponents == nil {
+		return false
+	}
+	n := min(len(aComponents), len(bComponents))
+	return slices.Equal(aComponents[:n], bComponents[:n])
+}
+
+// featureVersionSpecificity ranks how narrow the range of versions a
+// version of a Feature allows for is; higher is narrower.
+func featureVersionSpecificity(version string) int {
+	switch components := semverComponents(version); {
+	case version == "latest":
+		return 0
+	case components != nil:
+		return len(components)
+	default:
+		return 4
+	}
+}
+
+// selectFeatureVersion picks the one of versions, the versions of a
+// Feature that have been asked for, that satisfies all of them: the
+// narrowest. If there isn't one, the highest is picked and ok is
+// false.
+func selectFeatureVersion(versions []string) (selected string, ok bool) {
+	versions = slices.Clone(versions)
+	slices.SortFunc(versions, func(a, b string) int {
+		if diff := featureVersionSpecificity(b) - featureVersionSpecificity(a); diff != 0 {
+			return diff
+		}
+		return semver.Compare("v"+b, "v"+a)
+	})
+	for _, candidate := range versions {
+		if !slices.ContainsFunc(versions, func(version string) bool { return !featureVersionsCompatible(candidate, version) }) {
+			return candidate, true
+		}
+	}
+	slices.SortStableFunc(versions, func(a, b string) int { return semver.Compare("v"+b, "v"+a) })
+	return versions[0], false
+}
+
+// featureRequest is a reference to a Feature, either in
+// devcontainer.json or in the dependsOn field of another Feature.
+type featureRequest struct {
+	Ref         string
+	Values      writ.FeatureValues
+	RequestedBy string // The reference of the Feature that depends on it; empty if in devcontainer.json
+}
+
+// selectFeatures picks the reference to use for each Feature that's
+// been asked for in requests, keyed by its canonical ID; see
+// parseFeatureReference.
+//
+// A version asked for in devcontainer.json always wins; otherwise, it
+// is picked with selectFeatureVersion. Either way, versions it doesn't
+// satisfy are logged.
+func selectFeatures(requests []featureRequest) map[string]featureReference {
+	versions := make(map[string][]string)
+	pinned := make(map[string][]string)
+	for _, request := range requests {
+		ref := parseFeatureReference(request.Ref)
+		if !slices.Contains(versions[ref.ID], ref.Version) {
+			versions[ref.ID] = append(versions[ref.ID], ref.Version)
+		}
+		if len(request.RequestedBy) == 0 && !slices.Contains(pinned[ref.ID], ref.Version) {
+			pinned[ref.ID] = append(pinned[ref.ID], ref.Version)
+		}
+	}
+
+	selected := make(map[string]featureReference, len(versions))
+	for id, featureVersions := range versions {
+		candidates := featureVersions
+		if len(pinned[id]) > 0 {
+			candidates = pinned[id]
+		}
+		version, _ := selectFeatureVersion(candidates)
+		for _, other := range featureVersions {
+			if !featureVersionsCompatible(version, other) {
+				slog.Warn("feature is asked for at incompatible versions; only one will be installed", "feature", id, "selected", version, "ignored", other)
+			}
+		}
+		selected[id] = featureReference{ID: id, Version: version}
+	}
+	return selected
+}
+
+// resolveFeatures retrieves and parses the Features asked for in
+// featureMap, and the ones they depend on, with each Feature that's
+// asked for more than once deduplicated down to a single version; see
+// selectFeatures.
+//
+// As retrieving a Feature can bring in dependencies that change which
+// versions are selected, this is repeated until the selection
+// settles. The returned parsers are keyed by the reference selected
+// for them, and are yet to have their options set.
+func (cmd *Command) resolveFeatures(ctx context.Context, p *writ.DevcontainerParser, featureMap writ.FeatureMap) (parsers map[string]*writ.DevcontainerFeatureParser, requests []featureRequest, err error) {
+	parsers = make(map[string]*writ.DevcontainerFeatureParser)
+	var previous []string
+	for range MaxFeatureResolutionRounds {
+		requests = nil
+		for _, ref := range slices.Sorted(maps.Keys(featureMap)) {
+			requests = append(requests, featureRequest{Ref: ref, Values: featureMap[ref]})
+		}
+		for _, ref := range slices.Sorted(maps.Keys(parsers)) {
+			for _, dependency := range slices.Sorted(maps.Keys(parsers[ref].Config.DependsOn)) {
+				requests = append(requests, featureRequest{Ref: dependency, Values: parsers[ref].Config.DependsOn[dependency], RequestedBy: ref})
+			}
+		}
+
+		selection := []string{}
+		for _, ref := range selectFeatures(requests) {
+			selection = append(selection, ref.String())
+		}
+		slices.Sort(selection)
+		// Drop Features no longer selected, so their dependencies
+		// are no longer asked for either
+		for ref := range parsers {
+			if !slices.Contains(selection, ref) {
+				delete(parsers, ref)
+			}
+		}
+		if slices.Equal(selection, previous) {
+			return parsers, requests, nil
+		}
+		previous = selection
+
+		toPrepare := writ.FeatureMap{}
+		for _, ref := range selection {
+			if _, ok := parsers[ref]; !ok {
+				toPrepare[ref] = nil
+			}
+		}
+		if err = cmd.PrepareFeaturesData(ctx, toPrepare, p.Filepath); err != nil {
+			return nil, nil, err
+		}
+		for ref := range toPrepare {
+			if parsers[ref], err = cmd.parseFeature(ref, p); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("the versions of features to install didn't settle after %d rounds", MaxFeatureResolutionRounds)
+}
+
+// parseFeature parses the configuration of the Feature ref, which has
+// to have been retrieved beforehand.
+func (cmd *Command) parseFeature(ref string, p *writ.DevcontainerParser) (*writ.DevcontainerFeatureParser, error) {
+	cmd.featureMu.Lock()
+	featurePath, ok := cmd.featurePathLookup[ref]
+	cmd.featureMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("feature unavailable for parsing: %s", ref)
+	}
+
+	featureParser, err := writ.NewDevcontainerFeatureParser(filepath.Join(featurePath, "devcontainer-feature.json"), p)
+	if err != nil {
+		return nil, err
+	}
+	if err = featureParser.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid devcontainer-feature.json for %s: %w", ref, err)
+	}
+	if err = featureParser.Parse(); err != nil {
+		return nil, err
+	}
+	return featureParser, nil
+}
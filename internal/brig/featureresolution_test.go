@@ -0,0 +1,173 @@
+package brig
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseFeatureReference checks that references to the same
+// Feature share a canonical ID.
+func TestParseFeatureReference(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for ref, expected := range map[string]featureReference{
+		"ghcr.io/devcontainers/features/go":             {ID: "ghcr.io/devcontainers/features/go", Version: "latest"},
+		"GHCR.io/devcontainers/features/go:1":           {ID: "ghcr.io/devcontainers/features/go", Version: "1"},
+		"localhost:5000/features/go":                    {ID: "localhost:5000/features/go", Version: "latest"},
+		"localhost:5000/features/go:1.2":                {ID: "localhost:5000/features/go", Version: "1.2"},
+		"ghcr.io/devcontainers/features/go@sha256:abcd": {ID: "ghcr.io/devcontainers/features/go", Version: "sha256:abcd"},
+		"./go":             {ID: "./go"},
+		"./features/../go": {ID: "./go"},
+		"https://example.com/devcontainer-feature-go.tgz": {ID: "https://example.com/devcontainer-feature-go.tgz"},
+	} {
+		assert.Equal(t, expected, parseFeatureReference(ref), ref)
+	}
+	assert.Equal(t, "ghcr.io/devcontainers/features/go@sha256:abcd", parseFeatureReference("ghcr.io/devcontainers/features/go@sha256:abcd").String())
+	assert.Equal(t, "ghcr.io/devcontainers/features/go:latest", parseFeatureReference("ghcr.io/devcontainers/features/go").String())
+}
+
+// TestSelectFeatureVersion checks that the narrowest version
+// satisfying every constraint is selected, and the highest one when
+// there isn't one.
+func TestSelectFeatureVersion(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, tc := range []struct {
+		versions []string
+		selected string
+		ok       bool
+	}{
+		{[]string{"latest"}, "latest", true},
+		{[]string{"1", "latest"}, "1", true},
+		{[]string{"1", "1.2", "latest"}, "1.2", true},
+		{[]string{"1.2", "1.2.3", "1"}, "1.2.3", true},
+		{[]string{"1", "2"}, "2", false},
+		{[]string{"1.2", "1.10"}, "1.10", false},
+		{[]string{"sha256:abcd", "latest"}, "sha256:abcd", true},
+		{[]string{"sha256:abcd", "1"}, "1", false},
+	} {
+		selected, ok := selectFeatureVersion(tc.versions)
+		assert.Equal(t, tc.selected, selected, tc.versions)
+		assert.Equal(t, tc.ok, ok, tc.versions)
+	}
+}
+
+// TestSelectFeatures checks that versions asked for in
+// devcontainer.json win over the ones asked for by other Features.
+func TestSelectFeatures(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	selected := selectFeatures([]featureRequest{
+		{Ref: "ghcr.io/a/go:1"},
+		{Ref: "ghcr.io/a/go:2", RequestedBy: "ghcr.io/a/tool:1"},
+		{Ref: "ghcr.io/a/node:1", RequestedBy: "ghcr.io/a/tool:1"},
+		{Ref: "ghcr.io/a/node:1.4", RequestedBy: "ghcr.io/a/other:1"},
+	})
+	assert.Equal(t, map[string]featureReference{
+		"ghcr.io/a/go":   {ID: "ghcr.io/a/go", Version: "1"},
+		"ghcr.io/a/node": {ID: "ghcr.io/a/node", Version: "1.4"},
+	}, selected)
+}
+
+// TestParseFeaturesConfigDeduplicates checks that a Feature referenced
+// by several others, in different ways, is only installed once, with
+// the options set in devcontainer.json.
+func TestParseFeaturesConfigDeduplicates(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p, err := writ.NewDevcontainerParser(filepath.Join("testdata", "features-resolution", ".devcontainer", "devcontainer.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+
+	cmd := New("brig", "")
+	assert.Nil(t, cmd.ParseFeaturesConfig(context.Background(), p, p.Config.Features))
+	assert.ElementsMatch(t, []string{"./alpha", "./beta", "./omega"}, slices.Collect(maps.Keys(cmd.featureParsersLookup)))
+	assert.ElementsMatch(t, []string{"./alpha", "./beta", "./omega"}, slices.Collect(maps.Keys(cmd.featurePathLookup)))
+	assert.Equal(t, "lime", *cmd.featureParsersLookup["./beta"].Config.Options["flavor"].Value.String)
+
+	installDAG, err := cmd.BuildFeaturesInstallationGraph(nil)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"./beta"}, slices.Collect(maps.Keys(installDAG.GetRoots())))
+
+	// No lockfile is written unless asked for
+	_, err = os.Stat(featuresLockfilePath(p.Filepath))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestUpdateFeaturesLockfile checks that the artifacts Features
+// resolved to, and the Features they depend on, are recorded in the
+// lockfile.
+func TestUpdateFeaturesLockfile(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cmd := New("brig", "")
+	cmd.featureArtifactsDigests = &ArtifactDigest{Entries: map[string]*ArtifactDigestEntry{
+		"ghcr.io/a/tool:latest": {FeatureID: "ghcr.io/a/tool:latest", Digest: "sha256:1111"},
+		"ghcr.io/a/go:1.2":      {FeatureID: "ghcr.io/a/go:1.2", Digest: "sha256:2222"},
+	}}
+	tool := &writ.DevcontainerFeatureParser{}
+	tool.Config.Version = "1.0.3"
+	tool.Config.DependsOn = writ.FeatureMap{"ghcr.io/a/go:1": nil}
+	golang := &writ.DevcontainerFeatureParser{}
+	golang.Config.Version = "1.2.7"
+	cmd.featureParsersLookup["ghcr.io/a/tool:latest"] = tool
+	cmd.featureParsersLookup["ghcr.io/a/go:1.2"] = golang
+	cmd.featureParsersLookup["./local"] = &writ.DevcontainerFeatureParser{}
+	cmd.featureLockfile = &FeaturesLockfile{Features: map[string]*FeaturesLockfileEntry{}}
+	// Entries are keyed by how the Features are written in
+	// devcontainer.json, or else by the Features depending on them
+	requests := []featureRequest{
+		{Ref: "ghcr.io/a/go:1", RequestedBy: "ghcr.io/a/tool:latest"},
+		{Ref: "GHCR.io/a/tool"},
+		{Ref: "ghcr.io/a/go:1.2", RequestedBy: "ghcr.io/a/other:1"},
+		{Ref: "./local"},
+	}
+
+	lockfilePath := featuresLockfilePath(filepath.Join(t.TempDir(), ".devcontainer.json"))
+	assert.Equal(t, "."+FeaturesLockfileName, filepath.Base(lockfilePath))
+	assert.Nil(t, cmd.updateFeaturesLockfile(lockfilePath, requests))
+
+	lockfile, err := readFeaturesLockfile(lockfilePath)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]*FeaturesLockfileEntry{
+		"GHCR.io/a/tool": {
+			Version:   "1.0.3",
+			Resolved:  "ghcr.io/a/tool@sha256:1111",
+			Integrity: "sha256:1111",
+			DependsOn: []string{"ghcr.io/a/go:1.2"},
+		},
+		"ghcr.io/a/go:1.2": {
+			Version:   "1.2.7",
+			Resolved:  "ghcr.io/a/go@sha256:2222",
+			Integrity: "sha256:2222",
+		},
+	}, lockfile.Features)
+
+	// Entries are found however the Features are referred to
+	entry, ok := lockfile.lookup("ghcr.io/a/tool:latest")
+	assert.True(t, ok)
+	assert.Equal(t, "sha256:1111", entry.Integrity)
+
+	// Entries in the lockfile are kept as they are
+	cmd.featureLockfile = lockfile
+	cmd.featureArtifactsDigests.Entries["ghcr.io/a/go:1.2"].Digest = "sha256:3333"
+	assert.Nil(t, cmd.updateFeaturesLockfile(lockfilePath, requests))
+	lockfile, err = readFeaturesLockfile(lockfilePath)
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256:2222", lockfile.Features["ghcr.io/a/go:1.2"].Integrity)
+}
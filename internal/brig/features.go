@@ -17,23 +17,29 @@
 package brig
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log/slog"
-	"math/rand"
+	"maps"
 	"os"
 	"path/filepath"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/codeclysm/extract/v4"
 	"github.com/heimdalr/dag"
+	imagespec "github.com/moby/docker-image-spec/specs-go/v1"
+	"github.com/nlsantos/brig/trill"
 	"github.com/nlsantos/brig/writ"
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/errgroup"
 	"oras.land/oras-go/v2"
 	"oras.land/oras-go/v2/content"
 	"oras.land/oras-go/v2/registry/remote"
@@ -42,76 +48,231 @@ import (
 const FeatureArtifactMediaType string = "application/vnd.oci.image.manifest.v1+json"
 const FeatureLayerMediaType string = "application/vnd.devcontainers.layer.v1+tar"
 
+// MaxConcurrentFeatureDownloads is the maximum number of Features
+// being retrieved at any given time.
+const MaxConcurrentFeatureDownloads int = 4
+
+// ErrFeatureInstallOrderConflict is returned when a devcontainer's
+// overrideFeatureInstallOrder asks for a Feature to be installed
+// before one it depends on.
+var ErrFeatureInstallOrderConflict = errors.New("overrideFeatureInstallOrder conflicts with dependsOn")
+
 // BuildFeaturesInstallationGraph iterates over a devcontainer's
 // Features and builds a directed acyclic graph that can be used to
 // guide Features' installation order.
+//
+// dependsOn is honored above all else; Features that depend on each
+// other in a cycle are an error, as is orderOverride asking for a
+// Feature to be installed before one it depends on, which is reported
+// as ErrFeatureInstallOrderConflict, listing every such constraint.
+// Otherwise, the Features in orderOverride are installed in the order
+// given, ahead of the rest (bar the ones they depend on); installsAfter
+// is only honored where it doesn't go against either.
 func (cmd *Command) BuildFeaturesInstallationGraph(orderOverride *[]string) (installDAG *dag.DAG, err error) {
 	installDAG = dag.NewDAG()
 	for featureID, featureParser := range cmd.featureParsersLookup {
-		vertexID := featureID
-		if !strings.HasPrefix(featureID, "https://") {
-			// Remove version tags from feature IDs
-			featureIDAndTag := strings.Split(featureID, ":")
-			vertexID = featureIDAndTag[0]
-		}
-		if err := installDAG.AddVertexByID(vertexID, featureParser); err != nil {
+		// Versions are left out, so references to a Feature line up
+		// regardless of the version they ask for
+		if err := installDAG.AddVertexByID(parseFeatureReference(featureID).ID, featureParser); err != nil {
 			return nil, err
 		}
 	}
 
 	// As of this writing, I'm yet to encounter an official feature
 	// that actually utilizes the dependsOn field.
-	for featureID, featureParser := range cmd.featureParsersLookup {
-		for dependencyID := range featureParser.Config.DependsOn {
-			edgeID := dependencyID
-			if !strings.HasPrefix(dependencyID, "https://") {
-				// Remove version tags from dependency IDs
-				dependencyIDAndTag := strings.Split(edgeID, ":")
-				edgeID = dependencyIDAndTag[0]
+	for featureRef, featureParser := range cmd.featureParsersLookup {
+		featureID := parseFeatureReference(featureRef).ID
+		for dependency := range featureParser.Config.DependsOn {
+			dependencyID := parseFeatureReference(dependency).ID
+			err := installDAG.AddEdge(dependencyID, featureID)
+			var loopErr dag.EdgeLoopError
+			var srcDstErr dag.SrcDstEqualError
+			if errors.As(err, &loopErr) || errors.As(err, &srcDstErr) {
+				return nil, fmt.Errorf("unable to order Features: %s depends on %s, which depends on it in turn", featureID, dependencyID)
+			}
+		}
+	}
+
+	// If provided, set up edges to have the config's specified
+	// install order followed
+	if orderOverride != nil {
+		var overrideFeatures []string
+		for _, overrideFeature := range *orderOverride {
+			vertexID := parseFeatureReference(overrideFeature).ID
+			if _, err = installDAG.GetVertex(vertexID); err == nil && !slices.Contains(overrideFeatures, vertexID) {
+				overrideFeatures = append(overrideFeatures, vertexID)
+			}
+		}
+
+		var conflicts []error
+		for idx, overrideFeature := range overrideFeatures {
+			for _, laterFeature := range overrideFeatures[idx+1:] {
+				if path := cmd.featureDependencyPath(overrideFeature, laterFeature); path != nil {
+					conflicts = append(conflicts, fmt.Errorf("%w: %s is to be installed before %s, but depends on it (%s)",
+						ErrFeatureInstallOrderConflict, overrideFeature, laterFeature, strings.Join(path, " -> ")))
+				}
+			}
+		}
+		if len(conflicts) > 0 {
+			return nil, errors.Join(conflicts...)
+		}
+
+		for idx, overrideFeature := range overrideFeatures {
+			if idx < len(overrideFeatures)-1 {
+				if err = installDAG.AddEdge(overrideFeature, overrideFeatures[idx+1]); err != nil {
+					return nil, err
+				}
+			}
+			for featureID := range cmd.featureParsersLookup {
+				vertexID := parseFeatureReference(featureID).ID
+				if slices.Contains(overrideFeatures, vertexID) {
+					continue
+				}
+				// Fails if overrideFeature depends on it, in which
+				// case it has to be installed first anyway
+				_ = installDAG.AddEdge(overrideFeature, vertexID)
 			}
-			installDAG.AddEdge(edgeID, featureID)
 		}
 	}
 
 	// installsAfter entries are *soft* dependencies; if they're not
 	// specifically declared in dependsOn, they may not even be
-	// installed.
+	// installed. They're also overridden by
+	// overrideFeatureInstallOrder, so they're set up last, and skipped
+	// if they contradict what's been set up so far.
 	//
 	// https://containers.dev/implementors/features/#installsAfter
 	for featureID, featureParser := range cmd.featureParsersLookup {
 		for _, dependency := range featureParser.Config.InstallsAfter {
-			if _, err = installDAG.GetVertex(dependency); err != nil {
+			dependencyID := parseFeatureReference(dependency).ID
+			if _, err = installDAG.GetVertex(dependencyID); err != nil {
 				continue
 			}
-			installDAG.AddEdge(dependency, featureID)
+			_ = installDAG.AddEdge(dependencyID, parseFeatureReference(featureID).ID)
 		}
 	}
 
-	// If provided, set up edges to have the config's specified
-	// install order followed
-	if orderOverride != nil {
-		processedFeatures := []string{}
-		for idx := range len(*orderOverride) - 1 {
-			overrideFeature := (*orderOverride)[idx]
-			processedFeatures = append(processedFeatures, overrideFeature)
+	return installDAG, nil
+}
 
-			for featureID := range cmd.featureParsersLookup {
-				if slices.Contains(processedFeatures, featureID) {
-					continue
-				}
-				installDAG.AddEdge(overrideFeature, featureID)
+// featureDependencyPath returns the chain of dependsOn entries through
+// which the Feature identified by featureID depends on the one
+// identified by dependencyID, starting with the former and ending with
+// the latter, or nil if it doesn't depend on it.
+func (cmd *Command) featureDependencyPath(featureID string, dependencyID string) []string {
+	dependencies := make(map[string][]string)
+	for ref, featureParser := range cmd.featureParsersLookup {
+		id := parseFeatureReference(ref).ID
+		for dependency := range featureParser.Config.DependsOn {
+			dependencies[id] = append(dependencies[id], parseFeatureReference(dependency).ID)
+		}
+		slices.Sort(dependencies[id])
+	}
+
+	// Breadth-first, so the shortest chain is the one reported
+	dependents := map[string]string{featureID: ""}
+	queue := []string{featureID}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current == dependencyID {
+			var path []string
+			for id := current; len(id) > 0; id = dependents[id] {
+				path = append([]string{id}, path...)
+			}
+			return path
+		}
+		for _, dependency := range dependencies[current] {
+			if _, seen := dependents[dependency]; !seen {
+				dependents[dependency] = current
+				queue = append(queue, dependency)
 			}
 		}
 	}
+	return nil
+}
 
-	return installDAG, nil
+// FeatureInstallOrder returns the parsers of a devcontainer's
+// Features in the order the Features are to be installed in; see
+// BuildFeaturesInstallationGraph. Features that can be installed at
+// the same point are ordered by ID, so the order is stable across
+// runs.
+func (cmd *Command) FeatureInstallOrder(orderOverride *[]string) (featureParsers []*writ.DevcontainerFeatureParser, err error) {
+	installDAG, err := cmd.BuildFeaturesInstallationGraph(orderOverride)
+	if err != nil {
+		return nil, err
+	}
+	roots := installDAG.GetRoots()
+	for len(roots) > 0 {
+		for _, id := range slices.Sorted(maps.Keys(roots)) {
+			featureParser, ok := roots[id].(*writ.DevcontainerFeatureParser)
+			if !ok {
+				return nil, fmt.Errorf("value for vertex is of unexpected type")
+			}
+			featureParsers = append(featureParsers, featureParser)
+		}
+		for id := range roots {
+			if err := installDAG.DeleteVertex(id); err != nil {
+				return nil, err
+			}
+		}
+		roots = installDAG.GetRoots()
+	}
+	return featureParsers, nil
+}
+
+// writeFeatureInstallOrder prints out the Features in featureParsers,
+// which are in the order they're installed in, numbered; see
+// --show-install-order.
+func (cmd *Command) writeFeatureInstallOrder(featureParsers []*writ.DevcontainerFeatureParser) {
+	if len(featureParsers) == 0 {
+		return
+	}
+	refs := make(map[*writ.DevcontainerFeatureParser]string, len(cmd.featureParsersLookup))
+	for ref, featureParser := range cmd.featureParsersLookup {
+		refs[featureParser] = ref
+	}
+	w := cmd.stderr()
+	fmt.Fprintln(w, "Feature install order:")
+	for idx, featureParser := range featureParsers {
+		fmt.Fprintf(w, "  %d. %s\n", idx+1, refs[featureParser])
+	}
+}
+
+// featureInstallEnv returns the environment variables a Feature's
+// install script is run with: its options, with their names
+// upper-cased and characters not allowed in variable names replaced.
+//
+// https://containers.dev/implementors/features/#option-resolution
+func featureInstallEnv(featureParser *writ.DevcontainerFeatureParser) writ.EnvVarMap {
+	reAlphaNum := regexp.MustCompile(`[^\w_]`)
+	reDigits := regexp.MustCompile(`^[\d_]+`)
+	env := writ.EnvVarMap{}
+	for optName, opt := range featureParser.Config.Options {
+		envKey := reAlphaNum.ReplaceAllLiteralString(optName, "_")
+		envKey = reDigits.ReplaceAllLiteralString(envKey, "_")
+		envKey = strings.ToUpper(envKey)
+
+		env[envKey] = opt.EnvValue()
+	}
+	return env
 }
 
 // BuildImageWithFeatures builds an OCI image with baseImage as the
 // base and tags the resulting image as imageTag. The built OCI image
 // bundles in all of a devcontainer's Features, making them available
 // in the resulting container.
-func (cmd *Command) BuildImageWithFeatures(ctxPath string, baseImage string, imageTag string) (err error) {
+//
+// The build uses a dedicated context directory created inside
+// ctxPath that only contains the Features' files and the generated
+// Containerfile; its layout is deterministic so that trill can tell
+// when a rebuild is unnecessary.
+func (cmd *Command) BuildImageWithFeatures(ctx context.Context, ctxPath string, baseImage string, imageTag string) (err error) {
+	metadataLabel, err := cmd.featuresImageMetadataLabel(ctx, baseImage)
+	if err != nil {
+		return err
+	}
+
 	featuresBasePath, err := cmd.CopyFeaturesToContextDirectory(ctxPath)
 	if err != nil {
 		return err
@@ -120,20 +281,74 @@ func (cmd *Command) BuildImageWithFeatures(ctxPath string, baseImage string, ima
 		_ = os.RemoveAll(featuresBasePath)
 	}()
 
-	containerfilePath, err := cmd.GenerateContainerfileWithFeatures(ctxPath, baseImage)
+	containerfilePath, err := cmd.GenerateContainerfileWithFeatures(ctx, featuresBasePath, baseImage)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = os.Remove(containerfilePath)
-	}()
 
-	if err = cmd.trillClient.BuildContainerImage(ctxPath, containerfilePath, imageTag, nil, cmd.Options.SkipBuild, cmd.suppressOutput); err != nil {
+	if err = cmd.trillClient.BuildContainerImage(ctx, trill.BuildImageOptions{
+		ImageOptions:   cmd.imageOptions(cmd.Options.SkipBuild),
+		ContextPath:    featuresBasePath,
+		DockerfilePath: containerfilePath,
+		ImageTag:       imageTag,
+		Labels:         map[string]string{trill.ImageMetadataLabel: metadataLabel},
+		ResourceLabels: cmd.trillClient.ResourceLabels(cmd.parser),
+	}); err != nil {
 		return err
 	}
 	return nil
 }
 
+// featuresImageMetadataLabel returns the value of the
+// devcontainer.metadata label of the image with the devcontainer's
+// Features installed on top of baseImage: the metadata baseImage
+// already has, followed by that of each Feature in installation
+// order, then that of devcontainer.json.
+func (cmd *Command) featuresImageMetadataLabel(ctx context.Context, baseImage string) (string, error) {
+	imageCfg, err := cmd.inspectBaseImage(ctx, baseImage)
+	if err != nil {
+		return "", err
+	}
+	metadata, err := trill.ParseImageMetadata(imageCfg.Labels[trill.ImageMetadataLabel])
+	if err != nil {
+		return "", err
+	}
+
+	featureParsers, err := cmd.FeatureInstallOrder(&cmd.featureOrderOverride)
+	if err != nil {
+		return "", err
+	}
+	featureIDsByParser := make(map[*writ.DevcontainerFeatureParser]string, len(cmd.featureParsersLookup))
+	for featureID, featureParser := range cmd.featureParsersLookup {
+		featureIDsByParser[featureParser] = featureID
+	}
+	for _, featureParser := range featureParsers {
+		entry, err := trill.FeatureMetadata(featureIDsByParser[featureParser], &featureParser.Config)
+		if err != nil {
+			return "", err
+		}
+		metadata = append(metadata, entry)
+	}
+
+	entry, err := trill.DevcontainerMetadata(&cmd.parser.Config)
+	if err != nil {
+		return "", err
+	}
+	return append(metadata, entry).Label()
+}
+
+// inspectBaseImage returns the configuration of baseImage, pulling it
+// first if it isn't available locally.
+func (cmd *Command) inspectBaseImage(ctx context.Context, baseImage string) (*imagespec.DockerOCIImageConfig, error) {
+	if imageCfg, err := cmd.trillClient.InspectImage(ctx, baseImage); err == nil {
+		return imageCfg, nil
+	}
+	if err := cmd.trillClient.PullContainerImage(ctx, baseImage, cmd.imageOptions(true)); err != nil {
+		return nil, err
+	}
+	return cmd.trillClient.InspectImage(ctx, baseImage)
+}
+
 // CopyFeaturesToContextDirectory iterates over a devcontainer's
 // Features and copies their files from the cache directory into the
 // devcontainer's context directory (an actual context directory if
@@ -158,15 +373,13 @@ func (cmd *Command) CopyFeaturesToContextDirectory(ctxPath string) (featuresBase
 	// This will contain paths *within* the context directory that
 	// will eventually be incorporated into the OCI image
 	remoteFeaturePathLookup := make(map[string]string)
-	for featureID, cachedFeaturePath := range cmd.featurePathLookup {
-		// Create a tempdir to store feature files in; this gets
-		// around possibly dealing with invalid path names if they're
-		// based on feature references
-		featurePath, err := os.MkdirTemp(featuresBasePath, "feature-*")
-		if err != nil {
-			return "", err
-		}
-		if err := os.CopyFS(featurePath, os.DirFS(cachedFeaturePath)); err != nil {
+	for idx, featureID := range slices.Sorted(maps.Keys(cmd.featurePathLookup)) {
+		// Use an index-based name to store feature files in; this
+		// gets around possibly dealing with invalid path names if
+		// they're based on feature references, while keeping the
+		// layout stable across runs
+		featurePath := filepath.Join(featuresBasePath, fmt.Sprintf("feature-%d", idx))
+		if err := os.CopyFS(featurePath, os.DirFS(cmd.featurePathLookup[featureID])); err != nil {
 			return "", err
 		}
 		remoteFeaturePathLookup[featureID] = featurePath
@@ -180,29 +393,48 @@ func (cmd *Command) CopyFeaturesToContextDirectory(ctxPath string) (featuresBase
 // custom, ephemeral Containerfile to be used in an OCI build process
 // that ensures Features' files are incorporated into the resulting
 // OCI image.
-func (cmd *Command) GenerateContainerfileWithFeatures(ctxPath string, baseImage string) (containerfilePath string, err error) {
+//
+// The ID of baseImage is recorded in the generated Containerfile, so
+// that an updated base image results in a rebuild.
+//
+// With --build-features, the Features are installed as part of the
+// build as well; see writeFeatureInstallStages.
+func (cmd *Command) GenerateContainerfileWithFeatures(ctx context.Context, ctxPath string, baseImage string) (containerfilePath string, err error) {
 	containerfile, err := os.CreateTemp(ctxPath, fmt.Sprintf(".%s.Containerfile.*", cmd.appName))
 	if err != nil {
 		return "", err
 	}
 	defer containerfile.Close()
 
+	if baseImageID, err := cmd.trillClient.InspectImageID(ctx, baseImage); err == nil {
+		fmt.Fprintf(containerfile, "# %s: %s\n", baseImage, baseImageID)
+	} else {
+		slog.Debug("could not determine ID of base image; it may have to be pulled", "image", baseImage, "error", err)
+	}
+
+	if cmd.Options.BuildFeatures {
+		if err = cmd.writeFeatureInstallStages(ctx, containerfile, ctxPath, baseImage); err != nil {
+			return "", err
+		}
+		return containerfile.Name(), nil
+	}
+
 	remoteFeaturePathLookup := make(map[string]string)
 	fmt.Fprintf(containerfile, "FROM %s\n", baseImage)
-	for featureID, featurePath := range cmd.featurePathLookup {
-		relFeaturePath, err := filepath.Rel(ctxPath, featurePath)
+	for idx, featureID := range slices.Sorted(maps.Keys(cmd.featurePathLookup)) {
+		relFeaturePath, err := filepath.Rel(ctxPath, cmd.featurePathLookup[featureID])
 		if err != nil {
 			return "", err
 		}
 
-		remotePath := fmt.Sprintf("/devcontainer-features/%d", rand.Int())
+		remotePath := fmt.Sprintf("/devcontainer-features/%d", idx)
 		remoteConfigPath := fmt.Sprintf("%s/devcontainer-feature.json", remotePath)
 
 		remoteFeaturePathLookup[featureID] = remotePath
 		// Massage feature parser to the path within the OCI image for
 		// later execution
 		cmd.featureParsersLookup[featureID].Filepath = remoteConfigPath
-		fmt.Fprintf(containerfile, "COPY \"%s/*\" \"%s/\"\n", relFeaturePath, remotePath)
+		fmt.Fprintf(containerfile, "COPY \"%s/*\" \"%s/\"\n", filepath.ToSlash(relFeaturePath), remotePath)
 	}
 	// Overwrite previously set lookup table
 	cmd.featurePathLookup = remoteFeaturePathLookup
@@ -210,120 +442,309 @@ func (cmd *Command) GenerateContainerfileWithFeatures(ctxPath string, baseImage
 	return containerfilePath, err
 }
 
-// ParseFeaturesConfig instantiates a writ.DevcontainerFeatureParser
-// for each Feature a devcontainer references and stores it for later
-// use by Command.
+// writeFeatureInstallStages writes out a multi-stage Containerfile to
+// w that installs the Features into baseImage at build time, so they
+// persist in the image instead of having to be reinstalled whenever
+// the container is recreated.
 //
-// It also instantiates a corresponding parser for every dependency
-// referenced in the dependsOn field of a Feature configuration.
-func (cmd *Command) ParseFeaturesConfig(ctx context.Context, p *writ.DevcontainerParser, featureMap writ.FeatureMap) (err error) {
-	for featureID, featureMap := range featureMap {
-		slog.Debug("initializing configuration for feature", "feature", featureID)
-		featurePath, ok := cmd.featurePathLookup[featureID]
-		if !ok {
-			return fmt.Errorf("feature unavailable for parsing: %s", featurePath)
-		}
+// The Features' files are gathered in a stage of their own; the
+// target stage then copies each Feature in and runs its install
+// script, in installation order, with its options as environment
+// variables. Each Feature gets its own layers, so changing one only
+// invalidates the build cache for the ones installed after it.
+//
+// The environment variables a Feature sets in containerEnv are set
+// for the Features installed after it, and for the container.
+func (cmd *Command) writeFeatureInstallStages(ctx context.Context, w io.Writer, ctxPath string, baseImage string) error {
+	featureParsers, err := cmd.FeatureInstallOrder(&cmd.featureOrderOverride)
+	if err != nil {
+		return err
+	}
 
-		if _, ok := cmd.featureParsersLookup[featureID]; ok {
-			slog.Debug("feature already parsed; skipping", "featureID", featureID)
-			return nil
-		}
+	// The install scripts are run as root, so the user the base image
+	// runs as has to be restored afterwards
+	imageCfg, err := cmd.inspectBaseImage(ctx, baseImage)
+	if err != nil {
+		return err
+	}
 
-		featureParser, err := writ.NewDevcontainerFeatureParser(filepath.Join(featurePath, "devcontainer-feature.json"), p)
+	featureIDs := slices.Sorted(maps.Keys(cmd.featurePathLookup))
+	fmt.Fprintln(w, "FROM scratch AS dev_containers_feature_content_source")
+	for idx, featureID := range featureIDs {
+		relFeaturePath, err := filepath.Rel(ctxPath, cmd.featurePathLookup[featureID])
 		if err != nil {
 			return err
 		}
-		if err = featureParser.Validate(); err != nil {
-			return nil
+		fmt.Fprintf(w, "COPY \"%s/*\" \"/%d/\"\n", filepath.ToSlash(relFeaturePath), idx)
+	}
+
+	fmt.Fprintf(w, "\nFROM %s AS dev_containers_target_stage\n", baseImage)
+	fmt.Fprintln(w, "USER root")
+	if err = cmd.writeCACertificatesStage(w, ctxPath); err != nil {
+		return err
+	}
+	featureIDsByParser := make(map[*writ.DevcontainerFeatureParser]string, len(cmd.featureParsersLookup))
+	for featureID, featureParser := range cmd.featureParsersLookup {
+		featureIDsByParser[featureParser] = featureID
+	}
+	remoteFeaturePathLookup := make(map[string]string)
+	for _, featureParser := range featureParsers {
+		featureID := featureIDsByParser[featureParser]
+		idx := slices.Index(featureIDs, featureID)
+		if idx < 0 {
+			return fmt.Errorf("feature unavailable for installation: %s", featureParser.Config.ID)
 		}
-		if err = featureParser.Parse(); err != nil {
-			return nil
+
+		remotePath := fmt.Sprintf("/devcontainer-features/%d", idx)
+		remoteFeaturePathLookup[featureID] = remotePath
+		featureParser.Filepath = remotePath + "/devcontainer-feature.json"
+
+		var installCmd []string
+		env := featureInstallEnv(featureParser)
+		for _, key := range slices.Sorted(maps.Keys(env)) {
+			installCmd = append(installCmd, key+"="+shellQuote(env[key]))
 		}
+		installCmd = append(installCmd, "./install.sh")
+		fmt.Fprintf(w, "COPY --from=dev_containers_feature_content_source \"/%d/\" \"%s/\"\n", idx, remotePath)
+		fmt.Fprintf(w, "RUN cd %s && chmod +x ./install.sh && %s\n", shellQuote(remotePath), strings.Join(installCmd, " "))
+		for _, key := range slices.Sorted(maps.Keys(featureParser.Config.ContainerEnv)) {
+			fmt.Fprintf(w, "ENV %s=%s\n", key, strconv.Quote(featureParser.Config.ContainerEnv[key]))
+		}
+	}
+	if imageCfg != nil && len(imageCfg.User) > 0 {
+		fmt.Fprintf(w, "USER %s\n", imageCfg.User)
+	}
 
-		for key, val := range featureMap {
-			if err = featureParser.SetOption(key, &val); err != nil {
-				return err
+	// Overwrite previously set lookup table
+	cmd.featurePathLookup = remoteFeaturePathLookup
+	return nil
+}
+
+// ParseFeaturesConfig retrieves every Feature a devcontainer
+// references, and every Feature those depend on, then instantiates a
+// writ.DevcontainerFeatureParser for each and stores it for later use
+// by Command.
+//
+// References to the same Feature are deduplicated down to a single
+// version; see resolveFeatures. If the devcontainer has a lockfile,
+// the artifacts recorded in it are used, and it's updated with what
+// the Features resolved to; see FeaturesLockfile.
+func (cmd *Command) ParseFeaturesConfig(ctx context.Context, p *writ.DevcontainerParser, featureMap writ.FeatureMap) (err error) {
+	lockfilePath := featuresLockfilePath(p.Filepath)
+	if cmd.featureLockfile, err = readFeaturesLockfile(lockfilePath); err != nil {
+		return fmt.Errorf("unable to read %s: %w", lockfilePath, err)
+	}
+	if cmd.featureLockfile == nil && cmd.Options.Lockfile {
+		cmd.featureLockfile = &FeaturesLockfile{Features: make(map[string]*FeaturesLockfileEntry)}
+	}
+
+	cmd.featureOrderOverride = p.Config.OverrideFeatureInstallOrder
+	parsers, requests, err := cmd.resolveFeatures(ctx, p, featureMap)
+	if err != nil {
+		return err
+	}
+
+	// Options set in devcontainer.json take precedence over the ones
+	// set by Features depending on the same Feature
+	slices.SortStableFunc(requests, func(a, b featureRequest) int {
+		return strings.Compare(b.RequestedBy, a.RequestedBy)
+	})
+	for _, request := range requests {
+		ref := parseFeatureReference(request.Ref)
+		for selected, featureParser := range parsers {
+			if parseFeatureReference(selected).ID != ref.ID {
+				continue
+			}
+			for key, val := range request.Values {
+				if err = featureParser.SetOption(key, &val); err != nil {
+					return err
+				}
 			}
 		}
+	}
 
-		if err = cmd.PrepareFeaturesData(ctx, featureParser.Config.DependsOn, p.Filepath); err != nil {
+	for ref, featureParser := range parsers {
+		if err = featureParser.CheckRequiredOptions(); err != nil {
 			return err
 		}
-		if err = cmd.ParseFeaturesConfig(ctx, p, featureParser.Config.DependsOn); err != nil {
-			return err
+		featureParser.MergeContainerProperties()
+		cmd.featureParsersLookup[ref] = featureParser
+	}
+	// Worked out now, so that conflicts are reported before anything
+	// is built
+	installOrder, err := cmd.FeatureInstallOrder(&cmd.featureOrderOverride)
+	if err != nil {
+		return err
+	}
+	if cmd.Options.ShowInstallOrder {
+		cmd.writeFeatureInstallOrder(installOrder)
+	}
+	// Features retrieved, but not selected, aren't to be installed
+	cmd.featureMu.Lock()
+	for ref := range cmd.featurePathLookup {
+		if _, ok := parsers[ref]; !ok {
+			delete(cmd.featurePathLookup, ref)
 		}
+	}
+	cmd.featureMu.Unlock()
 
-		cmd.featureParsersLookup[featureID] = featureParser
+	if cmd.featureLockfile != nil {
+		if err = cmd.updateFeaturesLockfile(lockfilePath, requests); err != nil {
+			return fmt.Errorf("unable to update %s: %w", lockfilePath, err)
+		}
 	}
 	return nil
 }
 
+// updateFeaturesLockfile records what the Features selected for
+// installation resolved to in the lockfile at lockfilePath, keyed by
+// how requests refer to them; see featuresLockfileKey. Features that
+// aren't OCI artifacts are left out.
+func (cmd *Command) updateFeaturesLockfile(lockfilePath string, requests []featureRequest) error {
+	lockfile := &FeaturesLockfile{Features: make(map[string]*FeaturesLockfileEntry)}
+	for ref, featureParser := range cmd.featureParsersLookup {
+		featureRef := parseFeatureReference(ref)
+		if len(featureRef.Version) == 0 {
+			continue
+		}
+
+		entry, ok := cmd.featureLockfile.lookup(ref)
+		if !ok {
+			var digestEntry *ArtifactDigestEntry
+			if cmd.featureArtifactsDigests != nil {
+				digestEntry, ok = cmd.featureArtifactsDigests.Entries[ref]
+			}
+			if !ok {
+				slog.Warn("no digest recorded for feature; leaving it out of the lockfile", "feature", ref)
+				continue
+			}
+			entry = &FeaturesLockfileEntry{
+				Resolved:  featureRef.ID + "@" + digestEntry.Digest,
+				Integrity: digestEntry.Digest,
+			}
+		} else {
+			// Copied, so the lockfile as it was can be compared against
+			lockedEntry := *entry
+			entry = &lockedEntry
+		}
+		entry.Version = featureParser.Config.Version
+		entry.DependsOn = nil
+		for dependency := range featureParser.Config.DependsOn {
+			dependencyRef := parseFeatureReference(dependency)
+			for selected := range cmd.featureParsersLookup {
+				if parseFeatureReference(selected).ID == dependencyRef.ID {
+					entry.DependsOn = append(entry.DependsOn, featuresLockfileKey(selected, requests))
+				}
+			}
+		}
+		slices.Sort(entry.DependsOn)
+		lockfile.Features[featuresLockfileKey(ref, requests)] = entry
+	}
+
+	if maps.EqualFunc(lockfile.Features, cmd.featureLockfile.Features, func(a, b *FeaturesLockfileEntry) bool {
+		return a.Version == b.Version && a.Resolved == b.Resolved && a.Integrity == b.Integrity && slices.Equal(a.DependsOn, b.DependsOn)
+	}) {
+		if _, err := os.Stat(lockfilePath); err == nil {
+			return nil
+		}
+	}
+	slog.Info("updating features lockfile", "path", lockfilePath)
+	cmd.featureLockfile = lockfile
+	return writeFeaturesLockfile(lockfilePath, lockfile)
+}
+
 // PrepareFeaturesData retrieves each Feature's component files
 // (downloading them from remote endpoints if necessary, then caching
 // them for future use) and makes the parsed config available as
 // values in a lookup table.
 func (cmd *Command) PrepareFeaturesData(ctx context.Context, featureMap writ.FeatureMap, contextPath string) (err error) {
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(MaxConcurrentFeatureDownloads)
 	for featureID := range featureMap {
-		slog.Debug("attempting to pull feature metadata", "feature", featureID)
-		var featurePath string
-		switch {
-		case strings.HasPrefix(featureID, "/"):
-			// https://containers.dev/implementors/features-distribution/#addendum-locally-referenced
-			return fmt.Errorf("locally-stored features may not be referenced by an absolute path: %s", featureID)
-
-		// Features available on the local filesystem aren't
-		// redirected to the cache, unlike HTTPS-hosted tarballs and
-		// OCI artifacts, but are instead used as-is.
-		case strings.HasPrefix(featureID, "./"):
-			if featurePath, err = filepath.Abs(filepath.Join(filepath.Dir(contextPath), featureID)); err != nil {
-				return err
-			}
-			slog.Debug("referencing a locally-stored feature", "path", featurePath)
-			if _, err = os.Stat(featurePath); errors.Is(err, fs.ErrNotExist) {
-				return fmt.Errorf("referenced a locally-stored feature that doesn't exist: %s", featurePath)
-			}
-
-		case strings.HasPrefix(featureID, "https://"):
-			if featurePath, err = cmd.prepareFeatureDataURI(ctx, featureID); err != nil {
-				return err
-			}
+		cmd.featureMu.Lock()
+		_, prepared := cmd.featurePathLookup[featureID]
+		cmd.featureMu.Unlock()
+		if prepared {
+			slog.Debug("feature already prepared; skipping", "feature", featureID)
+			continue
+		}
 
-		default:
-			if err = cmd.LoadArtifactDigest(); err != nil {
+		eg.Go(func() (err error) {
+			defer trill.RecoverPanic(&err)
+			featurePath, err := cmd.prepareFeatureData(egCtx, featureID, contextPath)
+			if err != nil {
 				return err
 			}
+			cmd.featureMu.Lock()
+			defer cmd.featureMu.Unlock()
+			cmd.featurePathLookup[featureID] = featurePath
+			return nil
+		})
+	}
+	return eg.Wait()
+}
 
-			if featurePath, err = cmd.prepareFeatureDataArtifact(ctx, featureID); err != nil {
-				return err
-			}
+// prepareFeatureData retrieves the component files of the Feature
+// referenced by featureID; see PrepareFeaturesData.
+//
+// Safe to call concurrently.
+func (cmd *Command) prepareFeatureData(ctx context.Context, featureID string, contextPath string) (featurePath string, err error) {
+	slog.Debug("attempting to pull feature metadata", "feature", featureID)
+	switch {
+	case strings.HasPrefix(featureID, "/"):
+		// https://containers.dev/implementors/features-distribution/#addendum-locally-referenced
+		return "", fmt.Errorf("locally-stored features may not be referenced by an absolute path: %s", featureID)
+
+	// Features available on the local filesystem aren't
+	// redirected to the cache, unlike HTTPS-hosted tarballs and
+	// OCI artifacts, but are instead used as-is.
+	case strings.HasPrefix(featureID, "./"):
+		if featurePath, err = filepath.Abs(filepath.Join(filepath.Dir(contextPath), featureID)); err != nil {
+			return "", err
+		}
+		slog.Debug("referencing a locally-stored feature", "path", featurePath)
+		if _, err = os.Stat(featurePath); errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("referenced a locally-stored feature that doesn't exist: %s", featurePath)
 		}
+		return featurePath, nil
 
-		cmd.featurePathLookup[featureID] = featurePath
+	case strings.HasPrefix(featureID, "https://"):
+		return cmd.prepareFeatureDataURI(ctx, featureID)
+
+	default:
+		cmd.featureMu.Lock()
+		err = cmd.LoadArtifactDigest()
+		cmd.featureMu.Unlock()
+		if err != nil {
+			return "", err
+		}
+		if cmd.featureLockfile == nil {
+			return cmd.prepareFeatureDataArtifact(ctx, featureID)
+		}
+		if entry, ok := cmd.featureLockfile.lookup(featureID); ok && len(entry.Resolved) > 0 {
+			slog.Debug("using the artifact recorded in the lockfile", "feature", featureID, "resolved", entry.Resolved)
+			return cmd.prepareFeatureDataArtifact(ctx, entry.Resolved)
+		}
+		return cmd.prepareFeatureDataArtifact(ctx, featureID)
 	}
-	return nil
 }
 
 // prepareFeatureDataArtifact handles retrieving Features that are
 // distributed as OCI artifacts accessible via the reference `ref`.
 //
+// Artifacts are cached by the digest ref resolves to (see
+// cacheFeatureArtifact); if it can't be resolved, the copy cached for
+// the digest it last resolved to is used, if there's one.
+//
 // Currently only supports publicly-accessible OCI registries.
 func (cmd *Command) prepareFeatureDataArtifact(ctx context.Context, ref string) (path string, err error) {
 	slog.Debug("attempting to pull feature OCI artifact", "ref", ref)
-	cacheDir, err := cmd.getCacheDirectory()
+	cacheRoot, err := cmd.featureCacheDirectory()
 	if err != nil {
 		slog.Error("encountered an error while attempting to get cache directory", "error", err)
 		return "", err
 	}
 
-	cacheKeyComponents := []string{cacheDir}
-	cacheKeyComponents = append(cacheKeyComponents, strings.Split(ref, ":")...)
-	// cacheKey is the subdirectory within the root cache directory
-	// where the contents of the OCI artifact are going to be stored
-	cacheKey := filepath.Join(cacheKeyComponents...)
-
-	_, err = os.Stat(cacheKey)
-	cachedCopyExists := err == nil
-
 	repo, err := remote.NewRepository(ref)
 	if err != nil {
 		return "", err
@@ -332,78 +753,110 @@ func (cmd *Command) prepareFeatureDataArtifact(ctx context.Context, ref string)
 	slog.Debug("attempting to resolve reference to an OCI artifact")
 	description, err := repo.Resolve(ctx, repo.Reference.Reference)
 	if err != nil {
-		if cachedCopyExists {
+		cmd.featureMu.Lock()
+		digestTableEntry, ok := cmd.featureArtifactsDigests.Entries[ref]
+		cmd.featureMu.Unlock()
+		if ok {
 			// If the OCI artifact is already cached, this *could* be
 			// a recoverable situation, so return the cached path
 			// instead of conking out.
 			//
-			// The only caveat is that we aren't able to validate that
-			// the digests match, so the cache might be stale
-			slog.Warn("resolving OCI reference returned an error but a cached (possibly stale) copy already exists", "error", err)
-			return cacheKey, nil
+			// The only caveat is that we aren't able to check
+			// whether there's a newer version, so the cache might be
+			// stale
+			cachedPath, cacheErr := cachedFeatureArtifact(cacheRoot, digest.Digest(digestTableEntry.Digest))
+			if cacheErr == nil {
+				slog.Warn("resolving OCI reference returned an error but a cached (possibly stale) copy already exists", "error", err)
+				return cachedPath, nil
+			}
+			slog.Debug("no usable cached copy", "reference", ref, "error", cacheErr)
 		}
 		return "", err
 	}
-
 	slog.Debug("retrieved metadata for an OCI artifact", "digest", string(description.Digest))
-	digestTableEntry, ok := cmd.featureArtifactsDigests.Entries[ref]
-	if ok && cachedCopyExists {
-		if digestTableEntry.Digest == string(description.Digest) {
-			slog.Info("digest matches cached copy", "reference", ref, "digest", digestTableEntry.Digest)
-			return cacheKey, nil
-		}
-		slog.Info(
-			"cached copy exists but digests don't match",
-			"reference", ref,
-			"localDigest", digestTableEntry.Digest,
-			"remoteDigest", string(description.Digest),
-		)
-	}
 
 	if description.MediaType != FeatureArtifactMediaType {
 		slog.Error("feature URI resolved to an unsupported media type", "mime", description.MediaType)
+		return "", fmt.Errorf("feature %s resolved to an unsupported media type: %s", ref, description.MediaType)
+	}
+
+	if path, err = cmd.cacheFeatureArtifact(ctx, cacheRoot, repo, ref, description.Digest); err != nil {
 		return "", err
 	}
 
+	// Store the metadata for later marshalling
+	cmd.featureMu.Lock()
+	cmd.featureArtifactsDigests.Record(&ArtifactDigestEntry{
+		FeatureID: ref,
+		Digest:    string(description.Digest),
+	})
+	cmd.featureMu.Unlock()
+
+	return path, nil
+}
+
+// extractArtifactLayer fetches the manifest of the OCI artifact
+// referenced by ref from repo, and extracts its first layer of type
+// FeatureLayerMediaType into dest, creating it if needed.
+//
+// The layer is extracted as it's downloaded, rather than after it's
+// been read into memory, and its digest is verified once it's been
+// read in full. dest is removed if anything goes wrong, as it's left
+// with only part of the layer.
+//
+// Features and Templates are both distributed this way.
+func extractArtifactLayer(ctx context.Context, repo *remote.Repository, ref string, dest string) (err error) {
 	slog.Debug("retrieving OCI artifact manifest")
 	_, manifestContent, err := oras.FetchBytes(ctx, repo, ref, oras.DefaultFetchBytesOptions)
 	if err != nil {
-		return "", err
+		return err
 	}
 	var manifest ocispec.Manifest
 	if err := json.Unmarshal(manifestContent, &manifest); err != nil {
-		return "", err
+		return err
 	}
 	slog.Debug("retrieved manifest; iterating over layers", "mime", manifest.MediaType, "layerCount", len(manifest.Layers))
 	for _, layer := range manifest.Layers {
 		if layer.MediaType != FeatureLayerMediaType {
 			continue
 		}
-		slog.Debug("found layer with the target media type; extracting to cache", "path", cacheKey)
-		if !cachedCopyExists {
-			if err = os.MkdirAll(cacheKey, fs.ModeDir|0755); err != nil {
-				return "", err
-			}
+		slog.Debug("found layer with the target media type; extracting", "path", dest)
+		if err = os.MkdirAll(dest, fs.ModeDir|0755); err != nil {
+			return err
 		}
+		defer func() {
+			if err == nil {
+				return
+			}
+			if removeErr := os.RemoveAll(dest); removeErr != nil {
+				slog.Error("could not remove partially-extracted layer", "path", dest, "error", removeErr)
+			}
+		}()
 
-		layerBytes, err := content.FetchAll(ctx, repo, layer)
+		layerReader, err := repo.Fetch(ctx, layer)
 		if err != nil {
-			return "", err
-		}
-		if err = extract.Tar(ctx, bytes.NewBuffer(layerBytes), cacheKey, nil); err != nil {
-			return "", err
+			return err
 		}
+		defer layerReader.Close()
+		return extractVerifiedTar(ctx, layerReader, layer, dest)
+	}
 
-		// Store the metadata for later marshalling
-		cmd.featureArtifactsDigests.Entries[ref] = &ArtifactDigestEntry{
-			FeatureID: ref,
-			Digest:    string(description.Digest),
-		}
+	return fmt.Errorf("referenced OCI artifact didn't contain a usable layer")
+}
 
-		return cacheKey, nil
+// extractVerifiedTar extracts the tarball read from r, described by
+// desc, into dest, then checks that what was read matches desc's size
+// and digest.
+func extractVerifiedTar(ctx context.Context, r io.Reader, desc ocispec.Descriptor, dest string) error {
+	verifier := content.NewVerifyReader(r, desc)
+	if err := extract.Tar(ctx, verifier, dest, nil); err != nil {
+		return err
 	}
-
-	return "", fmt.Errorf("referenced OCI artifact didn't contain a usable layer")
+	// The tarball's padding may not have been read yet
+	if _, err := io.Copy(io.Discard, verifier); err != nil {
+		return err
+	}
+	return verifier.Verify()
 }
 
 // prepareFeatureDataURI handles Features distributed as tarballs via
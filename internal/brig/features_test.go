@@ -1,15 +1,25 @@
 package brig
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"maps"
+	"os"
 	"path/filepath"
 	"slices"
+	"strings"
 	"testing"
 
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/trill"
 	"github.com/nlsantos/brig/writ"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -136,3 +146,149 @@ func TestParseOverrideFeatureInstallOrderStandalone(t *testing.T) {
 	}
 	assert.EqualValues(t, dcParser.Config.OverrideFeatureInstallOrder, featureRoots)
 }
+
+// TestPrepareFeaturesDataConcurrently checks that every Feature ends
+// up in the lookup table when they're retrieved concurrently, and that
+// a Feature that can't be retrieved fails the lot.
+func TestPrepareFeaturesDataConcurrently(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	workspaceDir := t.TempDir()
+	contextPath := filepath.Join(workspaceDir, "devcontainer.json")
+	featureMap := writ.FeatureMap{}
+	for idx := range MaxConcurrentFeatureDownloads * 2 {
+		featureID := fmt.Sprintf("feature-%d", idx)
+		assert.Nil(t, os.Mkdir(filepath.Join(workspaceDir, featureID), 0o755))
+		featureMap["./"+featureID] = nil
+	}
+
+	cmd := New("brig", "")
+	assert.Nil(t, cmd.PrepareFeaturesData(context.Background(), featureMap, contextPath))
+	assert.Len(t, cmd.featurePathLookup, len(featureMap))
+	assert.Equal(t, filepath.Join(workspaceDir, "feature-0"), cmd.featurePathLookup["./feature-0"])
+
+	featureMap["./does-not-exist"] = nil
+	cmd = New("brig", "")
+	assert.NotNil(t, cmd.PrepareFeaturesData(context.Background(), featureMap, contextPath))
+}
+
+// TestExtractVerifiedTar checks that layers are extracted as they're
+// read, and rejected if they don't match their digest.
+func TestExtractVerifiedTar(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	contents := []byte("#!/bin/sh\n")
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "./install.sh", Mode: 0o755, Size: int64(len(contents))}))
+	_, err := tw.Write(contents)
+	assert.Nil(t, err)
+	assert.Nil(t, tw.Close())
+	desc := ocispec.Descriptor{
+		MediaType: FeatureLayerMediaType,
+		Digest:    digest.FromBytes(layer.Bytes()),
+		Size:      int64(layer.Len()),
+	}
+
+	dest := t.TempDir()
+	assert.Nil(t, extractVerifiedTar(context.Background(), bytes.NewReader(layer.Bytes()), desc, dest))
+	extracted, err := os.ReadFile(filepath.Join(dest, "install.sh"))
+	assert.Nil(t, err)
+	assert.Equal(t, contents, extracted)
+
+	desc.Digest = digest.FromString("something else")
+	assert.NotNil(t, extractVerifiedTar(context.Background(), bytes.NewReader(layer.Bytes()), desc, t.TempDir()))
+}
+
+// TestGenerateContainerfileWithFeaturesAtBuild checks that, with
+// --build-features, the generated Containerfile installs each Feature
+// in installation order, then switches back to the base image's user.
+func TestGenerateContainerfileWithFeaturesAtBuild(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	workspaceDir := t.TempDir()
+	assert.Nil(t, os.CopyFS(workspaceDir, os.DirFS(filepath.Join("testdata", "features-resolution"))))
+	p, err := writ.NewDevcontainerParser(filepath.Join(workspaceDir, ".devcontainer", "devcontainer.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("does-not-matter", &dockerspec.DockerOCIImageConfig{ImageConfig: ocispec.ImageConfig{User: "vscode"}})
+	cmd := New("brig", "")
+	cmd.Options.BuildFeatures = true
+	cmd.trillClient, err = trill.NewClient(trill.ClientOptions{SocketAddr: "fake://engine", Engine: engine})
+	assert.Nil(t, err)
+	assert.Nil(t, cmd.ParseFeaturesConfig(context.Background(), p, p.Config.Features))
+
+	containerfilePath, err := cmd.GenerateContainerfileWithFeatures(context.Background(), filepath.Dir(p.Filepath), "does-not-matter")
+	assert.Nil(t, err)
+	contents, err := os.ReadFile(containerfilePath)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+
+	// Features are numbered by their sorted IDs: alpha, beta, omega
+	assert.Equal(t, []string{
+		"FROM scratch AS dev_containers_feature_content_source",
+		`COPY "alpha/*" "/0/"`,
+		`COPY "beta/*" "/1/"`,
+		`COPY "omega/*" "/2/"`,
+		"",
+		"FROM does-not-matter AS dev_containers_target_stage",
+		"USER root",
+		`COPY --from=dev_containers_feature_content_source "/1/" "/devcontainer-features/1/"`,
+		`RUN cd '/devcontainer-features/1' && chmod +x ./install.sh && FLAVOR='lime' ./install.sh`,
+		`COPY --from=dev_containers_feature_content_source "/0/" "/devcontainer-features/0/"`,
+		`RUN cd '/devcontainer-features/0' && chmod +x ./install.sh && ./install.sh`,
+		`COPY --from=dev_containers_feature_content_source "/2/" "/devcontainer-features/2/"`,
+		`RUN cd '/devcontainer-features/2' && chmod +x ./install.sh && ./install.sh`,
+		"USER vscode",
+	}, lines[1:])
+	assert.Equal(t, "/devcontainer-features/1", cmd.featurePathLookup["./beta"])
+}
+
+// TestOverrideFeatureInstallOrderConflict checks that an
+// overrideFeatureInstallOrder that puts Features ahead of ones they
+// depend on is rejected, with every conflicting constraint listed,
+// and that a consistent one is reported as the final order.
+func TestOverrideFeatureInstallOrderConflict(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dcParser, err := writ.NewDevcontainerParser(filepath.Join("testdata", "features", "dependson-install-conflict.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, dcParser.Validate())
+	assert.Nil(t, dcParser.Parse())
+
+	var stderr bytes.Buffer
+	cmd := Command{Stderr: &stderr, featureParsersLookup: make(map[string]*writ.DevcontainerFeatureParser)}
+	for _, feature := range []string{"beta", "delta", "epsilon", "zeta"} {
+		p, err := writ.NewDevcontainerFeatureParser(filepath.Join("testdata", "features", fmt.Sprintf("%s.json", feature)), nil)
+		assert.Nil(t, err)
+		assert.Nil(t, p.Validate())
+		assert.Nil(t, p.Parse())
+
+		cmd.featureParsersLookup[fmt.Sprintf("./%s", feature)] = p
+	}
+
+	// zeta and epsilon depend on delta and beta respectively, but
+	// are listed ahead of them
+	_, err = cmd.BuildFeaturesInstallationGraph(&dcParser.Config.OverrideFeatureInstallOrder)
+	assert.ErrorIs(t, err, ErrFeatureInstallOrderConflict)
+	assert.Contains(t, err.Error(), "./zeta is to be installed before ./delta, but depends on it (./zeta -> ./delta)")
+	assert.Contains(t, err.Error(), "./epsilon is to be installed before ./beta, but depends on it (./epsilon -> ./beta)")
+	assert.Len(t, strings.Split(err.Error(), "\n"), 2)
+	_, err = cmd.FeatureInstallOrder(&dcParser.Config.OverrideFeatureInstallOrder)
+	assert.ErrorIs(t, err, ErrFeatureInstallOrderConflict)
+
+	// Features listed in the override go first, bar the ones they
+	// depend on
+	override := []string{"./zeta", "./epsilon"}
+	featureParsers, err := cmd.FeatureInstallOrder(&override)
+	assert.Nil(t, err)
+	cmd.writeFeatureInstallOrder(featureParsers)
+	assert.Equal(t, "Feature install order:\n  1. ./delta\n  2. ./zeta\n  3. ./beta\n  4. ./epsilon\n", stderr.String())
+}
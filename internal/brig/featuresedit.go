@@ -0,0 +1,286 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/nlsantos/brig/writ"
+	"github.com/pborman/options"
+	"golang.org/x/term"
+)
+
+// FeaturesEditOptions are the flags `brig features add` and `brig
+// features remove` take, on top of the global ones.
+type FeaturesEditOptions struct {
+	File string `getopt:"-f --file=PATH devcontainer.json to edit; found the same way as when bringing it up if unset"`
+}
+
+// runFeaturesAdd implements `brig features add <feature>
+// [<option>=<value>...]`, which adds a reference to a Feature to the
+// devcontainer.json, keeping its comments and formatting.
+//
+// The Feature is retrieved so the options given can be checked
+// against the ones it has; the rest are asked for if brig's running
+// in a terminal, and left at their defaults if it isn't. Only the
+// options given or answered are written out. If the devcontainer has
+// a lockfile, it's regenerated.
+//
+// args begins with the subcommand's own name.
+func (cmd *Command) runFeaturesAdd(args []string) ExitCode {
+	opts := FeaturesEditOptions{}
+	args, err := options.SubRegisterAndParse(&opts, args)
+	if err != nil || len(args) < 1 {
+		if err != nil {
+			fmt.Fprintln(cmd.stderr(), err)
+		}
+		fmt.Fprintf(cmd.stderr(), "usage: %s features add [-f PATH] <feature> [<option>=<value>...]\n", cmd.appName)
+		return ExitErrorParsingFlags
+	}
+	featureRef := args[0]
+
+	parser, err := cmd.loadDevcontainerJSON(editedDevcontainerJSON(opts.File))
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to parse devcontainer.json: %s\n", err)
+		return ExitNonValidDevcontainerJSON
+	}
+	editor, err := writ.NewDevcontainerEditor(parser.Filepath)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to read devcontainer.json: %s\n", err)
+		return ExitNonValidDevcontainerJSON
+	}
+	features, err := editor.Features()
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to read the features in %s: %s\n", editor.Filepath, err)
+		return ExitNonValidDevcontainerJSON
+	}
+	for _, existingRef := range slices.Sorted(maps.Keys(features)) {
+		if existingRef != featureRef && parseFeatureReference(existingRef).ID == parseFeatureReference(featureRef).ID {
+			fmt.Fprintf(cmd.stderr(), "%s already references %s; remove it first to switch to %s\n", editor.Filepath, existingRef, featureRef)
+			return ExitFeaturesFailed
+		}
+	}
+
+	ctx := context.Background()
+	var featureParser *writ.DevcontainerFeatureParser
+	if err = cmd.PrepareFeaturesData(ctx, writ.FeatureMap{featureRef: nil}, parser.Filepath); err == nil {
+		featureParser, err = cmd.parseFeature(featureRef, parser)
+	}
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to retrieve the feature %s: %s\n", featureRef, err)
+		return ExitFeaturesFailed
+	}
+
+	values := make(map[string]any)
+	for _, arg := range args[1:] {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			fmt.Fprintf(cmd.stderr(), "feature options are given as <option>=<value>: %s\n", arg)
+			return ExitErrorParsingFlags
+		}
+		if values[name], err = setFeatureOption(featureParser, name, value); err != nil {
+			fmt.Fprintf(cmd.stderr(), "%s\n", err)
+			return ExitErrorParsingFlags
+		}
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stderr.Fd())) {
+		if err = promptFeatureOptions(os.Stdin, cmd.stderr(), featureParser, values); err != nil {
+			fmt.Fprintf(cmd.stderr(), "%s\n", err)
+			return ExitError
+		}
+	}
+
+	if err = editor.AddFeature(featureRef, values); err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to add %s: %s\n", featureRef, err)
+		return ExitError
+	}
+	if exitCode := cmd.saveFeaturesEdit(ctx, &editor.Editor, opts.File); exitCode != ExitNormal {
+		return exitCode
+	}
+	fmt.Fprintf(cmd.stdout(), "%s: added %s\n", editor.Filepath, featureRef)
+	return ExitNormal
+}
+
+// runFeaturesRemove implements `brig features remove <feature>...`,
+// which removes references to Features from the devcontainer.json,
+// keeping its comments and formatting.
+//
+// A Feature can be named without a version (e.g.,
+// ghcr.io/devcontainers/features/go for
+// ghcr.io/devcontainers/features/go:1). If the devcontainer has a
+// lockfile, it's regenerated.
+//
+// args begins with the subcommand's own name.
+func (cmd *Command) runFeaturesRemove(args []string) ExitCode {
+	opts := FeaturesEditOptions{}
+	featureRefs, err := options.SubRegisterAndParse(&opts, args)
+	if err != nil || len(featureRefs) < 1 {
+		if err != nil {
+			fmt.Fprintln(cmd.stderr(), err)
+		}
+		fmt.Fprintf(cmd.stderr(), "usage: %s features remove [-f PATH] <feature>...\n", cmd.appName)
+		return ExitErrorParsingFlags
+	}
+
+	editor, err := writ.NewDevcontainerEditor(cmd.findDevcontainerJSON(editedDevcontainerJSON(opts.File)))
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to read devcontainer.json: %s\n", err)
+		return ExitNonValidDevcontainerJSON
+	}
+	features, err := editor.Features()
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to read the features in %s: %s\n", editor.Filepath, err)
+		return ExitNonValidDevcontainerJSON
+	}
+
+	var removed []string
+	for _, featureRef := range featureRefs {
+		existingRefs := slices.Sorted(maps.Keys(features))
+		idx := slices.IndexFunc(existingRefs, func(existingRef string) bool {
+			return featureReferenceMatches(existingRef, featureRef)
+		})
+		if idx < 0 {
+			fmt.Fprintf(cmd.stderr(), "%s doesn't reference %s\n", editor.Filepath, featureRef)
+			return ExitFeaturesFailed
+		}
+		existingRef := existingRefs[idx]
+		if _, err = editor.RemoveFeature(existingRef); err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to remove %s: %s\n", existingRef, err)
+			return ExitError
+		}
+		delete(features, existingRef)
+		removed = append(removed, existingRef)
+	}
+
+	if exitCode := cmd.saveFeaturesEdit(context.Background(), &editor.Editor, opts.File); exitCode != ExitNormal {
+		return exitCode
+	}
+	for _, featureRef := range removed {
+		fmt.Fprintf(cmd.stdout(), "%s: removed %s\n", editor.Filepath, featureRef)
+	}
+	return ExitNormal
+}
+
+// saveFeaturesEdit writes out the changes made to a devcontainer.json
+// with editor, then parses it again to make sure it's still valid,
+// putting it back the way it was if it isn't. If the devcontainer has
+// a lockfile (or --lockfile is set), it's regenerated from the
+// Features the devcontainer.json now references.
+//
+// file is the path given with --file, if any.
+func (cmd *Command) saveFeaturesEdit(ctx context.Context, editor *writ.Editor, file string) ExitCode {
+	info, err := os.Stat(editor.Filepath)
+	var original []byte
+	if err == nil {
+		original, err = os.ReadFile(editor.Filepath)
+	}
+	if err == nil {
+		err = editor.Save()
+	}
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to write %s: %s\n", editor.Filepath, err)
+		return ExitError
+	}
+
+	parser, err := cmd.loadDevcontainerJSON(editedDevcontainerJSON(file))
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "not saving changes, as they leave devcontainer.json invalid: %s\n", err)
+		if err = os.WriteFile(editor.Filepath, original, info.Mode().Perm()); err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to restore %s: %s\n", editor.Filepath, err)
+		}
+		return ExitNonValidDevcontainerJSON
+	}
+
+	if _, err = os.Stat(featuresLockfilePath(parser.Filepath)); err != nil && !cmd.Options.Lockfile {
+		return ExitNormal
+	}
+	if err = cmd.ParseFeaturesConfig(ctx, parser, parser.Config.Features); err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to regenerate the lockfile: %s\n", err)
+		return ExitFeaturesFailed
+	}
+	return ExitNormal
+}
+
+// editedDevcontainerJSON returns the [PATH] argument to pass to
+// findDevcontainerJSON and loadDevcontainerJSON for the
+// devcontainer.json given with --file, if any.
+func editedDevcontainerJSON(file string) []string {
+	if len(file) == 0 {
+		return nil
+	}
+	return []string{file}
+}
+
+// featureReferenceMatches reports whether existingRef, a reference to
+// a Feature in a devcontainer.json, is the one given refers to; if
+// given doesn't have a version, only the Features have to match.
+func featureReferenceMatches(existingRef string, given string) bool {
+	existing, wanted := parseFeatureReference(existingRef), parseFeatureReference(given)
+	if existing.ID != wanted.ID {
+		return false
+	}
+	hasVersion := strings.Contains(given, "@") || strings.LastIndex(given, ":") > strings.LastIndex(given, "/")
+	return existing.Version == wanted.Version || !hasVersion
+}
+
+// setFeatureOption sets the option name of the Feature parsed by p to
+// value, converting it to the option's type and checking it against
+// the values the option allows.
+//
+// Returns the converted value, as it's to be written in
+// devcontainer.json.
+func setFeatureOption(p *writ.DevcontainerFeatureParser, name string, value string) (any, error) {
+	featureValue, err := parseOptionValue("feature", p.Config.Options, name, value)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.SetOption(name, featureValue); err != nil {
+		return nil, err
+	}
+	if featureValue.Bool != nil {
+		return *featureValue.Bool, nil
+	}
+	return *featureValue.String, nil
+}
+
+// promptFeatureOptions asks, on out, for the values of the options of
+// the Feature parsed by p that aren't in values, reading the answers
+// from in, and adds the ones answered to values. An empty answer keeps
+// an option at its default.
+//
+// Returns an error if in runs out before every question is answered.
+func promptFeatureOptions(in io.Reader, out io.Writer, p *writ.DevcontainerFeatureParser, values map[string]any) error {
+	var optNames []string
+	for _, optName := range slices.Sorted(maps.Keys(p.Config.Options)) {
+		if _, given := values[optName]; !given {
+			optNames = append(optNames, optName)
+		}
+	}
+	return newOptionPrompt(in, out, "feature not added").askOptions(p.Config.Options, optNames, func(name string, answer string) error {
+		value, err := setFeatureOption(p, name, answer)
+		if err == nil {
+			values[name] = value
+		}
+		return err
+	})
+}
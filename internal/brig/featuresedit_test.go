@@ -0,0 +1,183 @@
+package brig
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFeaturesEditFixture writes out a devcontainer.json with a
+// comment in it, and a local Feature with options of every kind, in
+// a temporary directory; returns the path to the devcontainer.json.
+func writeFeaturesEditFixture(t *testing.T, features string) string {
+	devcontainerDir := filepath.Join(t.TempDir(), ".devcontainer")
+	assert.Nil(t, os.MkdirAll(filepath.Join(devcontainerDir, "feat"), 0o755))
+	configPath := filepath.Join(devcontainerDir, "devcontainer.json")
+	assert.Nil(t, os.WriteFile(configPath, []byte(`{
+	// Kept as-is
+	"image": "alpine",
+	"features": {`+features+`}
+}
+`), 0o644))
+	assert.Nil(t, os.WriteFile(filepath.Join(devcontainerDir, "feat", "devcontainer-feature.json"), []byte(`{
+		"id": "feat",
+		"version": "1.0.0",
+		"options": {
+			"flavor": {"type": "string", "enum": ["plain", "spicy"], "default": "plain"},
+			"verbose": {"type": "boolean", "default": false},
+			"token": {"type": "string", "description": "Used to authenticate", "default": ""}
+		}
+	}`), 0o644))
+	return configPath
+}
+
+// TestRunFeaturesAdd checks that a Feature is added with the options
+// given, with comments and formatting kept, and that options it
+// doesn't have or values it doesn't allow are turned away without
+// the devcontainer.json being touched.
+func TestRunFeaturesAdd(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	configPath := writeFeaturesEditFixture(t, "")
+	original, err := os.ReadFile(configPath)
+	assert.Nil(t, err)
+
+	for _, args := range [][]string{
+		{"./feat", "token=x", "color=red"},
+		{"./feat", "token=x", "flavor=mild"},
+		{"./feat", "token=x", "verbose=maybe"},
+		{"./feat", "token"},
+	} {
+		var stderr bytes.Buffer
+		cmd := New("brig", "")
+		cmd.Stderr = &stderr
+		cmd.Arguments = append([]string{"features", "add", "-f", configPath}, args...)
+		exitCode, ok := cmd.runSubcommand()
+		assert.True(t, ok)
+		assert.Equal(t, ExitErrorParsingFlags, exitCode, args)
+		assert.NotEmpty(t, stderr.String(), args)
+	}
+	contents, err := os.ReadFile(configPath)
+	assert.Nil(t, err)
+	assert.Equal(t, string(original), string(contents))
+
+	var stdout bytes.Buffer
+	cmd := New("brig", "")
+	cmd.Stdout = &stdout
+	cmd.Arguments = []string{"features", "add", "-f", configPath, "./feat", "token=secret", "flavor=spicy", "verbose=true"}
+	exitCode, _ := cmd.runSubcommand()
+	assert.Equal(t, ExitNormal, exitCode)
+	assert.Equal(t, configPath+": added ./feat\n", stdout.String())
+	contents, err = os.ReadFile(configPath)
+	assert.Nil(t, err)
+	assert.Equal(t, `{
+	// Kept as-is
+	"image": "alpine",
+	"features": {
+		"./feat": {
+			"flavor": "spicy",
+			"token": "secret",
+			"verbose": true
+		}
+	}
+}
+`, string(contents))
+}
+
+// TestRunFeaturesRemove checks that Features can be removed by their
+// references with or without versions, and that the lockfile is
+// regenerated without them.
+func TestRunFeaturesRemove(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	configPath := writeFeaturesEditFixture(t, `
+		// Pinned in the lockfile
+		"ghcr.io/example/features/extra:1": {},
+		"./feat": {"token": "secret"},
+	`)
+	lockfilePath := filepath.Join(filepath.Dir(configPath), FeaturesLockfileName)
+	assert.Nil(t, writeFeaturesLockfile(lockfilePath, &FeaturesLockfile{Features: map[string]*FeaturesLockfileEntry{
+		"ghcr.io/example/features/extra:1": {Version: "1.0.0", Resolved: "ghcr.io/example/features/extra@sha256:0", Integrity: "sha256:0"},
+	}}))
+
+	var stderr bytes.Buffer
+	cmd := New("brig", "")
+	cmd.Stderr = &stderr
+	cmd.Arguments = []string{"features", "remove", "-f", configPath, "ghcr.io/example/features/extra:2"}
+	exitCode, _ := cmd.runSubcommand()
+	assert.Equal(t, ExitFeaturesFailed, exitCode)
+	assert.Contains(t, stderr.String(), "doesn't reference")
+
+	var stdout bytes.Buffer
+	cmd = New("brig", "")
+	cmd.Stdout = &stdout
+	cmd.Arguments = []string{"features", "remove", "-f", configPath, "GHCR.io/example/features/extra"}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitNormal, exitCode)
+	assert.Equal(t, configPath+": removed ghcr.io/example/features/extra:1\n", stdout.String())
+
+	contents, err := os.ReadFile(configPath)
+	assert.Nil(t, err)
+	assert.Equal(t, `{
+	// Kept as-is
+	"image": "alpine",
+	"features": {
+		"./feat": {"token": "secret"},
+	}
+}
+`, string(contents))
+	lockfile, err := readFeaturesLockfile(lockfilePath)
+	assert.Nil(t, err)
+	assert.Empty(t, lockfile.Features)
+}
+
+// TestFeatureReferenceMatches checks that a Feature named without a
+// version matches any version of it, and one named with a version
+// only matches that version.
+func TestFeatureReferenceMatches(t *testing.T) {
+	assert.True(t, featureReferenceMatches("ghcr.io/devcontainers/features/go:1", "ghcr.io/devcontainers/features/go"))
+	assert.True(t, featureReferenceMatches("ghcr.io/devcontainers/features/go:1", "ghcr.io/devcontainers/features/go:1"))
+	assert.False(t, featureReferenceMatches("ghcr.io/devcontainers/features/go:1", "ghcr.io/devcontainers/features/go:2"))
+	assert.False(t, featureReferenceMatches("ghcr.io/devcontainers/features/go", "ghcr.io/devcontainers/features/go:1"))
+	assert.True(t, featureReferenceMatches("ghcr.io/devcontainers/features/go", "ghcr.io/devcontainers/features/go:latest"))
+	assert.True(t, featureReferenceMatches("./feat/", "./feat"))
+	assert.False(t, featureReferenceMatches("./feat", "./other"))
+}
+
+// TestPromptFeatureOptions checks that options not given are asked
+// for, that answers the option doesn't allow are asked for again, and
+// that options left unanswered are kept at their defaults.
+func TestPromptFeatureOptions(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	configPath := writeFeaturesEditFixture(t, "")
+	parser, err := writ.NewDevcontainerParser(configPath)
+	assert.Nil(t, err)
+	featureParser, err := writ.NewDevcontainerFeatureParser(filepath.Join(filepath.Dir(configPath), "feat", "devcontainer-feature.json"), parser)
+	assert.Nil(t, err)
+	assert.Nil(t, featureParser.Validate())
+	assert.Nil(t, featureParser.Parse())
+
+	var out bytes.Buffer
+	values := map[string]any{"verbose": true}
+	err = promptFeatureOptions(strings.NewReader("mild\n\nsecret\n"), &out, featureParser, values)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{"verbose": true, "token": "secret"}, values)
+	assert.Contains(t, out.String(), "  one of: plain, spicy\nflavor [plain]: ")
+	assert.Contains(t, out.String(), "option flavor must be one of: plain, spicy")
+	assert.Contains(t, out.String(), "token: Used to authenticate\ntoken []: ")
+	assert.NotContains(t, out.String(), "verbose [")
+
+	err = promptFeatureOptions(strings.NewReader(""), io.Discard, featureParser, map[string]any{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,460 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/nlsantos/brig/writ"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pborman/options"
+	"golang.org/x/mod/semver"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+const FeatureConfigMediaType string = "application/vnd.devcontainers"
+const FeatureCollectionLayerMediaType string = "application/vnd.devcontainers.collection.layer.v1+json"
+
+// FeatureCollectionFile is the name of the file that lists the
+// Features in a collection; it's published alongside them.
+const FeatureCollectionFile string = "devcontainer-collection.json"
+
+// FeatureMetadataAnnotation is the manifest annotation a published
+// Feature's devcontainer-feature.json is copied into, so tools can
+// read it without pulling the Feature.
+const FeatureMetadataAnnotation string = "dev.containers.metadata"
+
+// DefFeaturesPackageOutputFolder is where `brig features package`
+// writes packaged Features, unless --output-folder says otherwise.
+const DefFeaturesPackageOutputFolder string = "output"
+
+// DefFeaturesPublishRegistry is the registry `brig features publish`
+// pushes to, unless --registry says otherwise.
+const DefFeaturesPublishRegistry string = "ghcr.io"
+
+// FeaturesPackageOptions are the flags `brig features package` takes,
+// on top of the global ones.
+type FeaturesPackageOptions struct {
+	OutputFolder string `getopt:"-o --output-folder=PATH directory to write packaged Features to; defaults to ./output"`
+}
+
+// FeaturesPublishOptions are the flags `brig features publish` takes,
+// on top of the global ones.
+type FeaturesPublishOptions struct {
+	Namespace string `getopt:"-n --namespace=NAMESPACE namespace to publish Features under (e.g., owner/repo)"`
+	Registry  string `getopt:"-r --registry=HOST registry to publish Features to; defaults to ghcr.io"`
+}
+
+// FeatureCollection is the contents of FeatureCollectionFile.
+type FeatureCollection struct {
+	SourceInformation map[string]string `json:"sourceInformation"`
+	Features          []json.RawMessage `json:"features"`
+}
+
+// packagedFeature is a Feature that's been packaged for distribution.
+type packagedFeature struct {
+	ID       string          // ID of the Feature
+	Version  string          // Version of the Feature
+	Metadata json.RawMessage // Contents of its devcontainer-feature.json
+	Archive  string          // Path to the tarball holding its files
+}
+
+// runFeaturesPackage implements `brig features package [PATH]`, which
+// packages the Feature in PATH, or every Feature in it if it's a
+// directory of them (e.g., a Features repository's src), as they're
+// distributed: a tarball for each, named
+// devcontainer-feature-<id>.tgz, plus a FeatureCollectionFile listing
+// them.
+//
+// args begins with the subcommand's own name.
+func (cmd *Command) runFeaturesPackage(args []string) ExitCode {
+	opts := FeaturesPackageOptions{OutputFolder: DefFeaturesPackageOutputFolder}
+	args, err := options.SubRegisterAndParse(&opts, args)
+	if err != nil || len(args) > 1 {
+		if err != nil {
+			fmt.Fprintln(cmd.stderr(), err)
+		}
+		fmt.Fprintf(cmd.stderr(), "usage: %s features package [<flags>] [PATH]\n", cmd.appName)
+		return ExitErrorParsingFlags
+	}
+	srcPath := filepath.Join(".", "src")
+	if len(args) > 0 {
+		srcPath = args[0]
+	}
+
+	packaged, err := packageFeatures(srcPath, opts.OutputFolder)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to package features: %s\n", err)
+		return ExitFeaturesFailed
+	}
+	for _, feature := range packaged {
+		fmt.Fprintln(cmd.stdout(), feature.Archive)
+	}
+	return ExitNormal
+}
+
+// runFeaturesPublish implements `brig features publish [PATH]`, which
+// packages Features as `brig features package` does, then pushes each
+// to <registry>/<namespace>/<id>, and the collection to
+// <registry>/<namespace>.
+//
+// Each Feature is tagged with its version, as well as its major and
+// minor versions and "latest", unless a later version has already
+// been published under them. Versions that have already been
+// published are skipped.
+//
+// args begins with the subcommand's own name.
+func (cmd *Command) runFeaturesPublish(args []string) ExitCode {
+	opts := FeaturesPublishOptions{Registry: DefFeaturesPublishRegistry}
+	args, err := options.SubRegisterAndParse(&opts, args)
+	if err != nil || len(args) > 1 || len(opts.Namespace) == 0 {
+		if err != nil {
+			fmt.Fprintln(cmd.stderr(), err)
+		}
+		fmt.Fprintf(cmd.stderr(), "usage: %s features publish --namespace=NAMESPACE [<flags>] [PATH]\n", cmd.appName)
+		return ExitErrorParsingFlags
+	}
+	srcPath := filepath.Join(".", "src")
+	if len(args) > 0 {
+		srcPath = args[0]
+	}
+
+	outputDir, err := os.MkdirTemp("", cmd.appName+"-features-publish-")
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to create a temporary directory: %s\n", err)
+		return ExitError
+	}
+	defer os.RemoveAll(outputDir)
+	packaged, err := packageFeatures(srcPath, outputDir)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to package features: %s\n", err)
+		return ExitFeaturesFailed
+	}
+
+	ctx := context.Background()
+	client := cmd.registryClient()
+	namespace := fmt.Sprintf("%s/%s", opts.Registry, strings.Trim(opts.Namespace, "/"))
+	for _, feature := range packaged {
+		ref := fmt.Sprintf("%s/%s", namespace, feature.ID)
+		if err = publishFeature(ctx, client, ref, feature); err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to publish %s: %s\n", ref, err)
+			return ExitFeaturesFailed
+		}
+		fmt.Fprintf(cmd.stdout(), "%s:%s\n", ref, feature.Version)
+	}
+
+	collection, err := os.ReadFile(filepath.Join(outputDir, FeatureCollectionFile))
+	if err == nil {
+		err = publishFeatureCollection(ctx, client, namespace, collection)
+	}
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to publish the collection to %s: %s\n", namespace, err)
+		return ExitFeaturesFailed
+	}
+	return ExitNormal
+}
+
+// packageFeatures packages the Feature in srcPath, or every Feature
+// in it, into outputDir; see runFeaturesPackage.
+func packageFeatures(srcPath string, outputDir string) ([]packagedFeature, error) {
+	var featureDirs []string
+	if _, err := os.Stat(filepath.Join(srcPath, "devcontainer-feature.json")); err == nil {
+		featureDirs = append(featureDirs, srcPath)
+	} else {
+		entries, err := os.ReadDir(srcPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if _, err := os.Stat(filepath.Join(srcPath, entry.Name(), "devcontainer-feature.json")); err == nil {
+				featureDirs = append(featureDirs, filepath.Join(srcPath, entry.Name()))
+			}
+		}
+	}
+	if len(featureDirs) == 0 {
+		return nil, fmt.Errorf("no features found in %s", srcPath)
+	}
+
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return nil, err
+	}
+	collection := FeatureCollection{SourceInformation: map[string]string{"source": "brig"}}
+	var packaged []packagedFeature
+	for _, featureDir := range featureDirs {
+		feature, err := packageFeature(featureDir, outputDir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", featureDir, err)
+		}
+		slog.Info("packaged feature", "id", feature.ID, "version", feature.Version, "path", feature.Archive)
+		collection.Features = append(collection.Features, feature.Metadata)
+		packaged = append(packaged, feature)
+	}
+
+	contents, err := json.MarshalIndent(collection, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return packaged, os.WriteFile(filepath.Join(outputDir, FeatureCollectionFile), contents, 0o644)
+}
+
+// packageFeature validates the Feature in featureDir, then writes its
+// files into a tarball in outputDir.
+func packageFeature(featureDir string, outputDir string) (packagedFeature, error) {
+	parser, err := writ.NewDevcontainerFeatureParser(filepath.Join(featureDir, "devcontainer-feature.json"), nil)
+	if err == nil {
+		err = parser.Validate()
+	}
+	if err == nil {
+		err = parser.Parse()
+	}
+	if err != nil {
+		return packagedFeature{}, err
+	}
+	// The spec requires a Feature's ID to match its directory's name
+	if dirName := filepath.Base(filepath.Dir(parser.Filepath)); parser.Config.ID != dirName {
+		return packagedFeature{}, fmt.Errorf("feature ID %q doesn't match the name of its directory, %q", parser.Config.ID, dirName)
+	}
+
+	var metadata bytes.Buffer
+	if err = json.Compact(&metadata, parser.JSON()); err != nil {
+		return packagedFeature{}, err
+	}
+	feature := packagedFeature{
+		ID:       parser.Config.ID,
+		Version:  parser.Config.Version,
+		Metadata: metadata.Bytes(),
+		Archive:  filepath.Join(outputDir, fmt.Sprintf("devcontainer-feature-%s.tgz", parser.Config.ID)),
+	}
+
+	archive, err := os.Create(feature.Archive)
+	if err != nil {
+		return packagedFeature{}, err
+	}
+	err = writeTarball(archive, featureDir)
+	return feature, errors.Join(err, archive.Close())
+}
+
+// writeTarball writes the files in dir into w as an uncompressed
+// tarball, with paths relative to dir, in lexical order.
+//
+// The devcontainers layer media type is a plain tarball, despite the
+// .tgz extension packaged Features traditionally go by.
+func writeTarball(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsDir() && !info.Mode().IsRegular() {
+			slog.Warn("skipping file that's neither a regular file nor a directory", "path", path)
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = "./" + filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err = tw.WriteHeader(header); err != nil || info.IsDir() {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, file)
+		return errors.Join(err, file.Close())
+	})
+	return errors.Join(err, tw.Close())
+}
+
+// registryClient returns a client for talking to registries with the
+// credentials in the settings, falling back to those `docker login`
+// (or `podman login`, with REGISTRY_AUTH_FILE) stores.
+func (cmd *Command) registryClient() *auth.Client {
+	settingsCreds := cmd.settings.registryCredentials()
+	store, err := credentials.NewStoreFromDocker(credentials.StoreOptions{})
+	if err != nil {
+		slog.Warn("unable to read the docker credentials store", "error", err)
+	}
+	return &auth.Client{
+		Client: retry.DefaultClient,
+		Cache:  auth.NewCache(),
+		Credential: func(ctx context.Context, hostport string) (auth.Credential, error) {
+			if authConfig, ok := settingsCreds[hostport]; ok {
+				return auth.Credential{
+					Username:     authConfig.Username,
+					Password:     authConfig.Password,
+					RefreshToken: authConfig.IdentityToken,
+				}, nil
+			}
+			if store == nil {
+				return auth.EmptyCredential, nil
+			}
+			return credentials.Credential(store)(ctx, hostport)
+		},
+	}
+}
+
+// publishFeature pushes feature to the repository ref, tagging it as
+// featureVersionTags says.
+func publishFeature(ctx context.Context, client *auth.Client, ref string, feature packagedFeature) error {
+	repo, err := remote.NewRepository(ref)
+	if err != nil {
+		return err
+	}
+	repo.Client = client
+
+	var published []string
+	err = repo.Tags(ctx, "", func(tags []string) error {
+		published = append(published, tags...)
+		return nil
+	})
+	var errResp *errcode.ErrorResponse
+	if err != nil && !(errors.As(err, &errResp) && errResp.StatusCode == http.StatusNotFound) {
+		return fmt.Errorf("unable to list published versions: %w", err)
+	}
+	tags, err := featureVersionTags(feature.Version, published)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		slog.Warn("version already published; skipping", "ref", ref, "version", feature.Version)
+		return nil
+	}
+
+	layer, err := os.ReadFile(feature.Archive)
+	if err != nil {
+		return err
+	}
+	_, err = pushArtifact(ctx, repo, FeatureLayerMediaType, layer, filepath.Base(feature.Archive),
+		map[string]string{FeatureMetadataAnnotation: string(feature.Metadata)}, tags)
+	return err
+}
+
+// publishFeatureCollection pushes the contents of a
+// FeatureCollectionFile to the repository namespace, as "latest".
+func publishFeatureCollection(ctx context.Context, client *auth.Client, namespace string, collection []byte) error {
+	repo, err := remote.NewRepository(namespace)
+	if err != nil {
+		return err
+	}
+	repo.Client = client
+	_, err = pushArtifact(ctx, repo, FeatureCollectionLayerMediaType, collection, FeatureCollectionFile, nil, []string{"latest"})
+	return err
+}
+
+// pushArtifact pushes layer to target as the single layer of an
+// artifact in the devcontainers format, then tags the artifact's
+// manifest with tags.
+func pushArtifact(ctx context.Context, target oras.Target, layerMediaType string, layer []byte, title string, annotations map[string]string, tags []string) (ocispec.Descriptor, error) {
+	layerDesc, err := oras.PushBytes(ctx, target, layerMediaType, layer)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	layerDesc.Annotations = map[string]string{ocispec.AnnotationTitle: title}
+
+	manifestDesc, err := oras.PackManifest(ctx, target, oras.PackManifestVersion1_0, FeatureConfigMediaType, oras.PackManifestOptions{
+		Layers:              []ocispec.Descriptor{layerDesc},
+		ManifestAnnotations: annotations,
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	for _, tag := range tags {
+		slog.Debug("tagging artifact", "tag", tag, "digest", manifestDesc.Digest)
+		if err = target.Tag(ctx, manifestDesc, tag); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+	return manifestDesc, nil
+}
+
+// featureVersionTags returns the tags a Feature at version should be
+// published under, given the tags already published: the version
+// itself, plus its major and minor versions and "latest", unless a
+// later version has been published under them.
+//
+// Returns nothing if version has already been published, and an
+// error if it isn't a MAJOR.MINOR.PATCH semantic version.
+// Pre-releases are only tagged with their version.
+func featureVersionTags(version string, published []string) ([]string, error) {
+	v := "v" + version
+	if !semver.IsValid(v) || semver.Canonical(v) != v {
+		return nil, fmt.Errorf("version %q isn't a MAJOR.MINOR.PATCH semantic version", version)
+	}
+	if slices.Contains(published, version) {
+		return nil, nil
+	}
+	tags := []string{version}
+	if len(semver.Prerelease(v)) > 0 {
+		return tags, nil
+	}
+
+	// isLatest reports whether no version under prefix that's later
+	// than version has been published
+	isLatest := func(prefix string) bool {
+		for _, tag := range published {
+			pv := "v" + tag
+			if semver.Canonical(pv) != pv || !strings.HasPrefix(pv, prefix) {
+				continue
+			}
+			if semver.Prerelease(pv) == "" && semver.Compare(pv, v) > 0 {
+				return false
+			}
+		}
+		return true
+	}
+	if minor := semver.MajorMinor(v); isLatest(minor + ".") {
+		tags = append(tags, minor[1:])
+	}
+	if major := semver.Major(v); isLatest(major + ".") {
+		tags = append(tags, major[1:])
+	}
+	if isLatest("v") {
+		tags = append(tags, "latest")
+	}
+	return tags, nil
+}
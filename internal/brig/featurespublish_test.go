@@ -0,0 +1,126 @@
+package brig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codeclysm/extract/v4"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/memory"
+)
+
+// TestPackageFeatures checks that every Feature in a directory is
+// packaged into a tarball of its own and listed in the collection,
+// and that Features whose ID doesn't match their directory are
+// rejected.
+func TestPackageFeatures(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	outputDir := t.TempDir()
+	packaged, err := packageFeatures(filepath.Join("testdata", "features-test", "src"), outputDir)
+	assert.Nil(t, err)
+	assert.Len(t, packaged, 2)
+	assert.Equal(t, "color", packaged[0].ID)
+	assert.Equal(t, "1.0.0", packaged[0].Version)
+	assert.Equal(t, filepath.Join(outputDir, "devcontainer-feature-color.tgz"), packaged[0].Archive)
+
+	contents, err := os.ReadFile(filepath.Join(outputDir, FeatureCollectionFile))
+	assert.Nil(t, err)
+	var collection FeatureCollection
+	assert.Nil(t, json.Unmarshal(contents, &collection))
+	assert.Len(t, collection.Features, 2)
+	var hello map[string]any
+	assert.Nil(t, json.Unmarshal(collection.Features[1], &hello))
+	assert.Equal(t, "hello", hello["id"])
+
+	archive, err := os.ReadFile(packaged[0].Archive)
+	assert.Nil(t, err)
+	extractDir := t.TempDir()
+	assert.Nil(t, extract.Tar(context.Background(), bytes.NewReader(archive), extractDir, nil))
+	assert.FileExists(t, filepath.Join(extractDir, "devcontainer-feature.json"))
+	assert.FileExists(t, filepath.Join(extractDir, "install.sh"))
+
+	// A single Feature can be packaged by itself
+	packaged, err = packageFeatures(filepath.Join("testdata", "features-test", "src", "hello"), t.TempDir())
+	assert.Nil(t, err)
+	assert.Len(t, packaged, 1)
+
+	misnamedDir := filepath.Join(t.TempDir(), "misnamed")
+	assert.Nil(t, os.CopyFS(misnamedDir, os.DirFS(filepath.Join("testdata", "features-test", "src", "color"))))
+	_, err = packageFeatures(misnamedDir, t.TempDir())
+	assert.ErrorContains(t, err, "doesn't match")
+}
+
+// TestFeatureVersionTags checks that the major and minor versions and
+// "latest" only move forward.
+func TestFeatureVersionTags(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, tc := range []struct {
+		version   string
+		published []string
+		expected  []string
+	}{
+		{"1.2.3", nil, []string{"1.2.3", "1.2", "1", "latest"}},
+		{"1.2.3", []string{"1.2.3", "1.2", "1", "latest"}, nil},
+		{"1.2.4", []string{"1.2.3", "1.2", "1", "latest"}, []string{"1.2.4", "1.2", "1", "latest"}},
+		{"1.2.4", []string{"1.2.3", "1.3.0", "2.0.0"}, []string{"1.2.4", "1.2"}},
+		{"1.4.0", []string{"1.3.0", "2.0.0"}, []string{"1.4.0", "1.4", "1"}},
+		{"2.0.0-beta.1", []string{"1.3.0"}, []string{"2.0.0-beta.1"}},
+		{"1.3.1", []string{"1.3.0", "2.0.0-beta.1"}, []string{"1.3.1", "1.3", "1", "latest"}},
+	} {
+		tags, err := featureVersionTags(tc.version, tc.published)
+		assert.Nil(t, err, tc.version)
+		assert.Equal(t, tc.expected, tags, tc.version)
+	}
+
+	for _, version := range []string{"1", "1.2", "v1.2.3", "latest"} {
+		_, err := featureVersionTags(version, nil)
+		assert.NotNil(t, err, version)
+	}
+}
+
+// TestPushArtifact checks that artifacts are pushed in the
+// devcontainers format, and tagged as asked.
+func TestPushArtifact(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctx := context.Background()
+	store := memory.New()
+	layer := []byte("not really a tarball")
+	manifestDesc, err := pushArtifact(ctx, store, FeatureLayerMediaType, layer, "devcontainer-feature-color.tgz",
+		map[string]string{FeatureMetadataAnnotation: `{"id":"color"}`}, []string{"1.0.0", "latest"})
+	assert.Nil(t, err)
+	assert.Equal(t, FeatureArtifactMediaType, manifestDesc.MediaType)
+
+	for _, tag := range []string{"1.0.0", "latest"} {
+		desc, err := store.Resolve(ctx, tag)
+		assert.Nil(t, err)
+		assert.Equal(t, manifestDesc.Digest, desc.Digest)
+	}
+
+	manifestContent, err := content.FetchAll(ctx, store, manifestDesc)
+	assert.Nil(t, err)
+	var manifest ocispec.Manifest
+	assert.Nil(t, json.Unmarshal(manifestContent, &manifest))
+	assert.Equal(t, FeatureConfigMediaType, manifest.Config.MediaType)
+	assert.Equal(t, `{"id":"color"}`, manifest.Annotations[FeatureMetadataAnnotation])
+	assert.Len(t, manifest.Layers, 1)
+	assert.Equal(t, FeatureLayerMediaType, manifest.Layers[0].MediaType)
+	assert.Equal(t, "devcontainer-feature-color.tgz", manifest.Layers[0].Annotations[ocispec.AnnotationTitle])
+
+	layerContent, err := content.FetchAll(ctx, store, manifest.Layers[0])
+	assert.Nil(t, err)
+	assert.Equal(t, layer, layerContent)
+}
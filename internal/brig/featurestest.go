@@ -0,0 +1,372 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/nlsantos/brig/writ"
+	"github.com/pborman/options"
+)
+
+// DefFeaturesTestBaseImage is the image Features are installed into
+// for their autogenerated tests, unless --base-image says otherwise.
+const DefFeaturesTestBaseImage string = "mcr.microsoft.com/devcontainers/base:ubuntu"
+
+// featuresTestLibName is the name test scripts source the helpers in
+// featuresTestLib by.
+const featuresTestLibName string = "dev-container-features-test-lib"
+
+// featuresTestGlobalDir is the directory under test/ that holds
+// scenarios that aren't specific to a single Feature.
+const featuresTestGlobalDir string = "_global"
+
+// featuresTestLib provides the check and reportResults helpers test
+// scripts rely on, as the devcontainer CLI does.
+var featuresTestLib = heredoc.Doc(`
+	#!/bin/bash
+	FAILED=()
+
+	echoStderr() {
+	    echo "$@" 1>&2
+	}
+
+	check() {
+	    LABEL=$1
+	    shift
+	    echo -e "\nTesting '${LABEL}'"
+	    if "$@"; then
+	        echo "Passed '${LABEL}'"
+	        return 0
+	    else
+	        echoStderr "Check '${LABEL}' failed"
+	        FAILED+=("${LABEL}")
+	        return 1
+	    fi
+	}
+
+	reportResults() {
+	    if [ ${#FAILED[@]} -ne 0 ]; then
+	        echoStderr -e "\nFailed checks: ${FAILED[*]}"
+	        exit 1
+	    else
+	        echo -e "\nAll checks passed"
+	        exit 0
+	    fi
+	}
+`)
+
+// FeaturesTestOptions are the flags `brig features test` takes, on
+// top of the global ones.
+type FeaturesTestOptions struct {
+	BaseImage         string `getopt:"-i --base-image=IMAGE image to install Features into for their autogenerated tests"`
+	ProjectFolder     string `getopt:"--project-folder=PATH directory holding the src and test directories; defaults to the working directory"`
+	SkipAutogenerated bool   `getopt:"--skip-autogenerated skip each Feature's test.sh"`
+	SkipScenarios     bool   `getopt:"--skip-scenarios skip the scenarios in scenarios.json"`
+}
+
+// featureTestCase is a devcontainer to bring up, and a test script to
+// run in it.
+type featureTestCase struct {
+	Feature string         // Feature under test, or featuresTestGlobalDir
+	Name    string         // Name of the scenario; "test" for autogenerated tests
+	Config  map[string]any // The devcontainer.json to bring up, with Features referenced by their IDs
+	Script  string         // Name of the test script, in the Feature's test directory
+}
+
+// runFeatures implements `brig features <subcommand>`, which gathers
+// the commands for adding and removing the devcontainer's Features,
+// and the tools for Feature authors.
+func (cmd *Command) runFeatures(args []string) ExitCode {
+	switch {
+	case len(args) > 0 && args[0] == "add":
+		return cmd.runFeaturesAdd(args)
+	case len(args) > 0 && args[0] == "package":
+		return cmd.runFeaturesPackage(args)
+	case len(args) > 0 && args[0] == "publish":
+		return cmd.runFeaturesPublish(args)
+	case len(args) > 0 && args[0] == "remove":
+		return cmd.runFeaturesRemove(args)
+	case len(args) > 0 && args[0] == "test":
+		return cmd.runFeaturesTest(args)
+	}
+	fmt.Fprintf(cmd.stderr(), "usage: %s features {add|package|publish|remove|test} [<flags>] [<args>...]\n", cmd.appName)
+	return ExitErrorParsingFlags
+}
+
+// runFeaturesTest implements `brig features test [<feature>...]`,
+// which runs the tests of the Features in a Features repository, laid
+// out as the devcontainer CLI expects: the Features in src/<feature>,
+// and their tests in test/<feature>.
+//
+// Each Feature's test.sh is run in BaseImage with the Feature
+// installed with its default options; each scenario in its
+// scenarios.json is brought up as a devcontainer of its own, in which
+// the script named after the scenario is run. Scenarios in
+// test/_global are run when every Feature is being tested.
+//
+// args begins with the subcommand's own name.
+func (cmd *Command) runFeaturesTest(args []string) ExitCode {
+	opts := FeaturesTestOptions{BaseImage: DefFeaturesTestBaseImage, ProjectFolder: "."}
+	features, err := options.SubRegisterAndParse(&opts, args)
+	if err != nil {
+		fmt.Fprintln(cmd.stderr(), err)
+		fmt.Fprintf(cmd.stderr(), "usage: %s features test [<flags>] [<feature>...]\n", cmd.appName)
+		return ExitErrorParsingFlags
+	}
+	projectDir, err := filepath.Abs(opts.ProjectFolder)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to resolve the project folder: %s\n", err)
+		return ExitError
+	}
+
+	testCases, err := collectFeatureTests(projectDir, features, opts)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to collect the tests: %s\n", err)
+		return ExitFeaturesFailed
+	}
+	if len(testCases) == 0 {
+		fmt.Fprintln(cmd.stderr(), "no tests found")
+		return ExitFeaturesFailed
+	}
+
+	var failed []string
+	ctx := context.Background()
+	for _, tc := range testCases {
+		label := fmt.Sprintf("%s/%s", tc.Feature, tc.Name)
+		fmt.Fprintf(cmd.stderr(), "==> running %s\n", label)
+		if err := cmd.runFeatureTest(ctx, projectDir, tc); err != nil {
+			slog.Error("feature test failed", "test", label, "error", err)
+			fmt.Fprintf(cmd.stdout(), "FAIL %s\n", label)
+			failed = append(failed, label)
+			continue
+		}
+		fmt.Fprintf(cmd.stdout(), "PASS %s\n", label)
+	}
+
+	fmt.Fprintf(cmd.stdout(), "%d passed, %d failed\n", len(testCases)-len(failed), len(failed))
+	if len(failed) > 0 {
+		return ExitLifecycleCommandFailed
+	}
+	return ExitNormal
+}
+
+// collectFeatureTests returns the tests for features, or, if it's
+// empty, for every Feature in projectDir/src and the scenarios in
+// projectDir/test/_global; see runFeaturesTest.
+func collectFeatureTests(projectDir string, features []string, opts FeaturesTestOptions) ([]featureTestCase, error) {
+	testGlobal := len(features) == 0
+	if testGlobal {
+		entries, err := os.ReadDir(filepath.Join(projectDir, "src"))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if isLocalFeature(projectDir, entry.Name()) {
+				features = append(features, entry.Name())
+			}
+		}
+	}
+
+	var testCases []featureTestCase
+	for _, feature := range features {
+		if !isLocalFeature(projectDir, feature) {
+			return nil, fmt.Errorf("no such feature in %s: %s", filepath.Join(projectDir, "src"), feature)
+		}
+		testDir := filepath.Join(projectDir, "test", feature)
+		if _, err := os.Stat(filepath.Join(testDir, "test.sh")); !opts.SkipAutogenerated && err == nil {
+			testCases = append(testCases, featureTestCase{
+				Feature: feature,
+				Name:    "test",
+				Config: map[string]any{
+					"image":    opts.BaseImage,
+					"features": map[string]any{feature: map[string]any{}},
+				},
+				Script: "test.sh",
+			})
+		}
+		if !opts.SkipScenarios {
+			scenarios, err := readFeatureScenarios(testDir, feature)
+			if err != nil {
+				return nil, err
+			}
+			testCases = append(testCases, scenarios...)
+		}
+	}
+
+	if testGlobal && !opts.SkipScenarios {
+		scenarios, err := readFeatureScenarios(filepath.Join(projectDir, "test", featuresTestGlobalDir), featuresTestGlobalDir)
+		if err != nil {
+			return nil, err
+		}
+		testCases = append(testCases, scenarios...)
+	}
+	return testCases, nil
+}
+
+// readFeatureScenarios returns the scenarios in testDir/scenarios.json,
+// in order of their names, if there's such a file.
+//
+// Each scenario has to come with a script named after it.
+func readFeatureScenarios(testDir string, feature string) ([]featureTestCase, error) {
+	contents, err := os.ReadFile(filepath.Join(testDir, "scenarios.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var scenarios map[string]map[string]any
+	if err = json.Unmarshal(contents, &scenarios); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", filepath.Join(testDir, "scenarios.json"), err)
+	}
+
+	var testCases []featureTestCase
+	for _, name := range slices.Sorted(maps.Keys(scenarios)) {
+		script := name + ".sh"
+		if _, err := os.Stat(filepath.Join(testDir, script)); err != nil {
+			return nil, fmt.Errorf("scenario %s/%s has no test script: %w", feature, name, err)
+		}
+		testCases = append(testCases, featureTestCase{
+			Feature: feature,
+			Name:    name,
+			Config:  scenarios[name],
+			Script:  script,
+		})
+	}
+	return testCases, nil
+}
+
+// isLocalFeature reports whether projectDir/src/name is a Feature.
+func isLocalFeature(projectDir string, name string) bool {
+	if !filepath.IsLocal(name) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(projectDir, "src", name, "devcontainer-feature.json"))
+	return err == nil
+}
+
+// runFeatureTest brings up the devcontainer tc describes, with the
+// Features in it that are from projectDir referenced locally, then
+// runs the test script in it.
+//
+// A workspace is put together in a temporary directory for the run:
+// the devcontainer.json and the Features go in its .devcontainer, and
+// the contents of the Feature's test directory, along with
+// featuresTestLib, at its root.
+func (cmd *Command) runFeatureTest(ctx context.Context, projectDir string, tc featureTestCase) (err error) {
+	workspaceDir, err := os.MkdirTemp("", cmd.appName+"-features-test-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if removeErr := os.RemoveAll(workspaceDir); removeErr != nil {
+			slog.Error("could not remove the test workspace", "path", workspaceDir, "error", removeErr)
+		}
+	}()
+
+	if err = writeFeatureTestWorkspace(projectDir, workspaceDir, tc); err != nil {
+		return err
+	}
+
+	parser, err := writ.NewDevcontainerParser(filepath.Join(workspaceDir, ".devcontainer", "devcontainer.json"))
+	if err != nil {
+		return err
+	}
+	parser.LocalWorkspaceFolder = workspaceDir
+	if err = parser.Validate(); err != nil {
+		return err
+	}
+	if err = parser.Parse(); err != nil {
+		return err
+	}
+
+	testCmd := New(cmd.appName, cmd.appVersion)
+	testCmd.Options = cmd.Options
+	testCmd.Options.Detach = false
+	testCmd.Options.NoAttach = true
+	testCmd.Options.Exec = fmt.Sprintf("cd %s && PATH=\"$PWD:$PATH\" ./%s",
+		shellQuote(*parser.Config.WorkspaceFolder), shellQuote(tc.Script))
+	testCmd.Stdout = cmd.Stdout
+	testCmd.Stderr = cmd.Stderr
+	testCmd.SuppressOutput = cmd.SuppressOutput
+	testCmd.settings = cmd.settings
+	if err = testCmd.Connect(cmd.Options.Socket); err != nil {
+		return errors.Join(err, testCmd.Close())
+	}
+	defer func() {
+		err = errors.Join(err, testCmd.Down(ctx, parser), testCmd.Close())
+	}()
+	return testCmd.Up(ctx, parser)
+}
+
+// writeFeatureTestWorkspace lays out the workspace for tc in
+// workspaceDir; see runFeatureTest.
+func writeFeatureTestWorkspace(projectDir string, workspaceDir string, tc featureTestCase) error {
+	devcontainerDir := filepath.Join(workspaceDir, ".devcontainer")
+	if err := os.MkdirAll(devcontainerDir, 0o755); err != nil {
+		return err
+	}
+
+	config := maps.Clone(tc.Config)
+	if features, ok := config["features"].(map[string]any); ok {
+		localFeatures := make(map[string]any, len(features))
+		for featureID, featureOptions := range features {
+			if !isLocalFeature(projectDir, featureID) {
+				localFeatures[featureID] = featureOptions
+				continue
+			}
+			if err := os.CopyFS(filepath.Join(devcontainerDir, featureID), os.DirFS(filepath.Join(projectDir, "src", featureID))); err != nil {
+				return err
+			}
+			localFeatures["./"+path.Clean(featureID)] = featureOptions
+		}
+		config["features"] = localFeatures
+	}
+	contents, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(devcontainerDir, "devcontainer.json"), contents, 0o644); err != nil {
+		return err
+	}
+
+	if err = os.CopyFS(workspaceDir, os.DirFS(filepath.Join(projectDir, "test", tc.Feature))); err != nil {
+		return err
+	}
+	// Test scripts aren't always checked in as executable
+	if err = os.Chmod(filepath.Join(workspaceDir, tc.Script), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(workspaceDir, featuresTestLibName), []byte(featuresTestLib), 0o755) // #nosec G306
+}
+
+// shellQuote quotes s for use as a single word in a POSIX shell
+// command line.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
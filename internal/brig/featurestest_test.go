@@ -0,0 +1,94 @@
+package brig
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCollectFeatureTests checks that autogenerated tests and
+// scenarios are picked up for every Feature, and that global
+// scenarios are only run along with every Feature's tests.
+func TestCollectFeatureTests(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	projectDir := filepath.Join("testdata", "features-test")
+	opts := FeaturesTestOptions{BaseImage: "alpine:latest"}
+	labels := func(testCases []featureTestCase) (labels []string) {
+		for _, tc := range testCases {
+			labels = append(labels, tc.Feature+"/"+tc.Name+"/"+tc.Script)
+		}
+		return labels
+	}
+
+	testCases, err := collectFeatureTests(projectDir, nil, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"color/test/test.sh", "color/gold/gold.sh", "hello/test/test.sh", "_global/both/both.sh"}, labels(testCases))
+	assert.Equal(t, map[string]any{
+		"image":    "alpine:latest",
+		"features": map[string]any{"color": map[string]any{}},
+	}, testCases[0].Config)
+
+	testCases, err = collectFeatureTests(projectDir, []string{"color"}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"color/test/test.sh", "color/gold/gold.sh"}, labels(testCases))
+
+	opts.SkipScenarios = true
+	testCases, err = collectFeatureTests(projectDir, nil, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"color/test/test.sh", "hello/test/test.sh"}, labels(testCases))
+
+	opts.SkipScenarios = false
+	opts.SkipAutogenerated = true
+	testCases, err = collectFeatureTests(projectDir, []string{"color"}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"color/gold/gold.sh"}, labels(testCases))
+
+	_, err = collectFeatureTests(projectDir, []string{"does-not-exist"}, opts)
+	assert.NotNil(t, err)
+	_, err = collectFeatureTests(projectDir, []string{"../src/color"}, opts)
+	assert.NotNil(t, err)
+}
+
+// TestWriteFeatureTestWorkspace checks that Features from the project
+// are copied into the workspace and referenced locally, and that the
+// test scripts and their helpers end up at its root.
+func TestWriteFeatureTestWorkspace(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	projectDir := filepath.Join("testdata", "features-test")
+	testCases, err := collectFeatureTests(projectDir, nil, FeaturesTestOptions{BaseImage: "alpine:latest"})
+	assert.Nil(t, err)
+	global := testCases[len(testCases)-1]
+	assert.Equal(t, featuresTestGlobalDir, global.Feature)
+
+	workspaceDir := t.TempDir()
+	assert.Nil(t, writeFeatureTestWorkspace(projectDir, workspaceDir, global))
+
+	contents, err := os.ReadFile(filepath.Join(workspaceDir, ".devcontainer", "devcontainer.json"))
+	assert.Nil(t, err)
+	var config map[string]any
+	assert.Nil(t, json.Unmarshal(contents, &config))
+	assert.Equal(t, map[string]any{
+		"./color": map[string]any{},
+		"./hello": map[string]any{},
+		"ghcr.io/devcontainers/features/common-utils:2": map[string]any{},
+	}, config["features"])
+	assert.FileExists(t, filepath.Join(workspaceDir, ".devcontainer", "color", "install.sh"))
+	assert.FileExists(t, filepath.Join(workspaceDir, ".devcontainer", "hello", "devcontainer-feature.json"))
+
+	info, err := os.Stat(filepath.Join(workspaceDir, "both.sh"))
+	assert.Nil(t, err)
+	assert.NotZero(t, info.Mode().Perm()&0o100)
+	assert.FileExists(t, filepath.Join(workspaceDir, featuresTestLibName))
+
+	// The scenario itself is left untouched
+	assert.Contains(t, global.Config["features"], "color")
+}
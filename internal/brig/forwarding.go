@@ -0,0 +1,349 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/moby/moby/api/types/mount"
+	"github.com/nlsantos/brig/trill"
+	"github.com/nlsantos/brig/writ"
+)
+
+// gpgAgentSocketTarget is where the host's gpg-agent socket is
+// mounted inside the devcontainer.
+const gpgAgentSocketTarget string = "/tmp/brig-gpg-agent.sock"
+
+// gitCredentialsTarget is where the directory holding the git
+// credentials socket is mounted inside the devcontainer.
+const gitCredentialsTarget string = "/tmp/brig-git-credentials"
+
+// gitCredentialsSocketName is the name of the socket git credential
+// requests from the devcontainer are sent to.
+const gitCredentialsSocketName string = "git-credentials.sock"
+
+// engineSocketTarget is where the Podman/Docker socket is mounted
+// inside the devcontainer; it's where most clients look for it by
+// default.
+const engineSocketTarget string = "/var/run/docker.sock"
+
+// engineSocketSetupScript gives $BRIG_ENGINE_SOCKET_USER access to
+// the Podman/Docker socket by adding them to the group that owns it,
+// creating the group if the devcontainer doesn't have one with the
+// same GID.
+//
+// Under rootless Podman, the socket belongs to the host user, who's
+// root inside the devcontainer; the group is then root's, and the
+// socket's group permissions are what lets the user in.
+const engineSocketSetupScript string = `set -e
+if [ "$(id -u "$BRIG_ENGINE_SOCKET_USER")" = "0" ]; then
+	exit 0
+fi
+gid="$(stat -c %g ` + engineSocketTarget + `)"
+group="$(awk -F: -v gid="$gid" '$3 == gid { print $1; exit }' /etc/group)"
+if [ -z "$group" ]; then
+	group=brig-engine
+	if command -v groupadd >/dev/null 2>&1; then
+		groupadd -g "$gid" "$group"
+	else
+		addgroup -g "$gid" "$group"
+	fi
+fi
+if command -v usermod >/dev/null 2>&1; then
+	usermod -aG "$group" "$BRIG_ENGINE_SOCKET_USER"
+else
+	addgroup "$BRIG_ENGINE_SOCKET_USER" "$group"
+fi
+`
+
+// gpgAgentSetupScript points gpg in the devcontainer at the host's
+// gpg-agent, then imports the host's public keys (staged in
+// $BRIG_GPG_PUBKEYS), which gpg needs to know which key to sign with.
+const gpgAgentSetupScript string = `if ! command -v gpgconf >/dev/null 2>&1; then
+	echo "gpg isn't installed in the devcontainer; skipping gpg-agent forwarding"
+	exit 0
+fi
+socket="$(gpgconf --list-dirs agent-socket)"
+mkdir -p "$(dirname "$socket")"
+chmod 700 "$(dirname "$socket")"
+ln -sf ` + gpgAgentSocketTarget + ` "$socket"
+if [ -f "$BRIG_GPG_PUBKEYS" ]; then
+	gpg --batch --quiet --import "$BRIG_GPG_PUBKEYS"
+fi
+`
+
+// gitCredentialHelperScript registers a git credential helper in the
+// devcontainer that passes requests on to the host; see
+// gitCredentialServer.
+const gitCredentialHelperScript string = `if ! command -v git >/dev/null 2>&1; then
+	echo "git isn't installed in the devcontainer; skipping git credential forwarding"
+	exit 0
+fi
+git config --global credential.helper '!f() { curl --silent --fail --unix-socket ` + gitCredentialsTarget + `/` + gitCredentialsSocketName + ` --data-binary @- "http://brig/$1"; }; f'
+`
+
+// gitCredentialServer answers git credential requests from the
+// devcontainer by passing them on to the host's git (and so, its
+// credential helpers).
+//
+// Requests are made over HTTP on a unix socket: the path names the
+// operation (get, store, or erase), and the body holds the
+// credential description as git writes it.
+type gitCredentialServer struct {
+	dir    string // Holds the socket; mounted into the devcontainer
+	server *http.Server
+}
+
+// gitCredentialOperations maps the operations git asks credential
+// helpers to perform onto the git credential subcommands that perform
+// them.
+var gitCredentialOperations = map[string]string{
+	"get":   "fill",
+	"store": "approve",
+	"erase": "reject",
+}
+
+// newGitCredentialServer starts a gitCredentialServer listening on a
+// socket in a new temporary directory.
+func newGitCredentialServer() (*gitCredentialServer, error) {
+	dir, err := os.MkdirTemp("", "brig-git-credentials-")
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", filepath.Join(dir, gitCredentialsSocketName))
+	if err != nil {
+		return nil, errors.Join(err, os.RemoveAll(dir))
+	}
+
+	s := &gitCredentialServer{
+		dir: dir,
+		server: &http.Server{
+			Handler:           http.HandlerFunc(handleGitCredentialRequest),
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("git credential server stopped unexpectedly", "error", err)
+		}
+	}()
+	slog.Debug("listening for git credential requests", "dir", dir)
+	return s, nil
+}
+
+// Close stops the server and removes its socket.
+func (s *gitCredentialServer) Close() error {
+	return errors.Join(s.server.Close(), os.RemoveAll(s.dir))
+}
+
+// handleGitCredentialRequest runs the git credential subcommand that
+// corresponds to the requested operation on the host, with the
+// request's body as its input.
+//
+// git is kept from prompting for credentials, as there's no terminal
+// to prompt on.
+func handleGitCredentialRequest(w http.ResponseWriter, r *http.Request) {
+	operation := strings.TrimPrefix(r.URL.Path, "/")
+	subcommand, ok := gitCredentialOperations[operation]
+	if !ok || r.Method != http.MethodPost {
+		http.Error(w, "unsupported credential operation", http.StatusNotFound)
+		return
+	}
+
+	var stdout bytes.Buffer
+	gitCmd := exec.CommandContext(r.Context(), "git", "credential", subcommand)
+	gitCmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	gitCmd.Stdin = r.Body
+	gitCmd.Stdout = &stdout
+	slog.Debug("answering git credential request from the devcontainer", "operation", operation)
+	if err := gitCmd.Run(); err != nil {
+		slog.Warn("git credential request from the devcontainer failed", "operation", operation, "error", err)
+		http.Error(w, "credential request failed", http.StatusBadGateway)
+		return
+	}
+	if _, err := io.Copy(w, &stdout); err != nil {
+		slog.Error("could not respond to git credential request", "error", err)
+	}
+}
+
+// prepareForwarding sets up what's needed on the host to forward the
+// Podman/Docker socket, the gpg-agent, and git credentials into the
+// devcontainer described by p, if asked to, and mounts them into it.
+//
+// Needs to be called before the devcontainer is created; see
+// setUpForwarding for the parts that happen inside it.
+func (cmd *Command) prepareForwarding(p *writ.DevcontainerParser) error {
+	if cmd.Options.ForwardEngineSocket {
+		socket, err := engineSocketPath(cmd.trillClient.SocketAddr)
+		if err != nil {
+			return err
+		}
+		slog.Warn("forwarding the Podman/Docker socket; anything in the devcontainer can now control the engine, and through it, the host", "socket", socket)
+		p.Config.Mounts = append(p.Config.Mounts, &writ.MobyMount{Mount: mount.Mount{
+			Type:   mount.TypeBind,
+			Source: socket,
+			Target: engineSocketTarget,
+		}})
+		if p.Config.ContainerEnv == nil {
+			p.Config.ContainerEnv = make(writ.EnvVarMap)
+		}
+		p.Config.ContainerEnv["DOCKER_HOST"] = "unix://" + engineSocketTarget
+	}
+
+	if cmd.Options.ForwardGPGAgent {
+		out, err := exec.Command("gpgconf", "--list-dirs", "agent-extra-socket").Output()
+		if err != nil {
+			return fmt.Errorf("unable to locate the gpg-agent socket: %w", err)
+		}
+		socket := strings.TrimSpace(string(out))
+		slog.Debug("forwarding gpg-agent", "socket", socket)
+		p.Config.Mounts = append(p.Config.Mounts, &writ.MobyMount{Mount: mount.Mount{
+			Type:   mount.TypeBind,
+			Source: socket,
+			Target: gpgAgentSocketTarget,
+		}})
+	}
+
+	if cmd.Options.ForwardGitCredentials {
+		server, err := newGitCredentialServer()
+		if err != nil {
+			return fmt.Errorf("unable to listen for git credential requests: %w", err)
+		}
+		cmd.gitCredentialServer = server
+		p.Config.Mounts = append(p.Config.Mounts, &writ.MobyMount{Mount: mount.Mount{
+			Type:   mount.TypeBind,
+			Source: server.dir,
+			Target: gitCredentialsTarget,
+		}})
+	}
+	return nil
+}
+
+// setUpForwarding points the remoteUser, gpg, and git inside the
+// devcontainer at what prepareForwarding set up.
+func (cmd *Command) setUpForwarding(ctx context.Context, p *writ.DevcontainerParser) error {
+	if cmd.Options.ForwardEngineSocket {
+		env := writ.EnvVarMap{"BRIG_ENGINE_SOCKET_USER": *p.Config.RemoteUser}
+		if err := cmd.runForwardingScript(ctx, p, "root", "engine-socket", env, engineSocketSetupScript); err != nil {
+			return err
+		}
+	}
+	if cmd.Options.ForwardGPGAgent {
+		env := writ.EnvVarMap{}
+		pubkeysDir, err := cmd.stageGPGPublicKeys(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pubkeysDir) > 0 {
+			env["BRIG_GPG_PUBKEYS"] = pubkeysDir + "/pubkeys.asc"
+		}
+		if err = cmd.runForwardingScript(ctx, p, *p.Config.RemoteUser, "gpg-agent", env, gpgAgentSetupScript); err != nil {
+			return err
+		}
+	}
+	if cmd.Options.ForwardGitCredentials {
+		if err := cmd.runForwardingScript(ctx, p, *p.Config.RemoteUser, "git-credentials", nil, gitCredentialHelperScript); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stageGPGPublicKeys exports the host's public keys and copies them
+// into the devcontainer, returning the directory they were copied
+// into.
+//
+// Returns an empty string if there are no public keys to export.
+func (cmd *Command) stageGPGPublicKeys(ctx context.Context) (string, error) {
+	exportDir, err := os.MkdirTemp("", "brig-gpg-")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := os.RemoveAll(exportDir); err != nil {
+			slog.Error("could not remove exported gpg public keys", "path", exportDir, "error", err)
+		}
+	}()
+
+	pubkeys, err := exec.CommandContext(ctx, "gpg", "--armor", "--export").Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to export gpg public keys: %w", err)
+	}
+	if len(pubkeys) == 0 {
+		return "", nil
+	}
+	if err = os.WriteFile(filepath.Join(exportDir, "pubkeys.asc"), pubkeys, 0o644); err != nil {
+		return "", err
+	}
+
+	stagedName := fmt.Sprintf("brig-gpg-%d", time.Now().UnixNano())
+	if err = cmd.trillClient.CopyDirToDevcontainer(ctx, exportDir, "/tmp", stagedName); err != nil {
+		return "", err
+	}
+	return "/tmp/" + stagedName, nil
+}
+
+// runForwardingScript runs script in the devcontainer as user, with
+// its output treated like a lifecycle command's.
+func (cmd *Command) runForwardingScript(ctx context.Context, p *writ.DevcontainerParser, user string, label string, env writ.EnvVarMap, script string) error {
+	scriptEnv := writ.EnvVarMap{}
+	maps.Copy(scriptEnv, p.Config.RemoteEnv)
+	maps.Copy(scriptEnv, env)
+
+	stdout, stderr, captured := cmd.lifecycleOutputWriters("FORWARD", label)
+	start := time.Now()
+	err := cmd.trillClient.StreamExecInDevcontainer(ctx, trill.ExecOptions{
+		User:       user,
+		Env:        &scriptEnv,
+		RunInShell: true,
+		Stdout:     stdout,
+		Stderr:     stderr,
+	}, script)
+	cmd.recordLifecycleResult("FORWARD", label, start, err)
+	if err != nil {
+		logCapturedLifecycleOutput("FORWARD", captured)
+	}
+	return err
+}
+
+// engineSocketPath returns the path to the unix socket socketAddr
+// points to.
+//
+// Only unix sockets can be mounted into the devcontainer; TCP and SSH
+// connections to the engine aren't supported.
+func engineSocketPath(socketAddr string) (string, error) {
+	if socket, ok := strings.CutPrefix(socketAddr, "unix://"); ok {
+		return socket, nil
+	}
+	if strings.HasPrefix(socketAddr, "/") {
+		return socketAddr, nil
+	}
+	return "", fmt.Errorf("unable to forward the Podman/Docker socket: %q isn't a unix socket", socketAddr)
+}
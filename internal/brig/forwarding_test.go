@@ -0,0 +1,88 @@
+package brig
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGitCredentialServer checks that credential requests sent to the
+// server's socket are answered by the host's credential helpers.
+func TestGitCredentialServer(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on a POSIX shell")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	gitConfig := filepath.Join(t.TempDir(), "gitconfig")
+	helper := "[credential]\n\thelper = \"!f() { test \\\"$1\\\" = get && echo username=brig && echo password=hunter2; }; f\"\n"
+	assert.Nil(t, os.WriteFile(gitConfig, []byte(helper), 0o644))
+	t.Setenv("GIT_CONFIG_GLOBAL", gitConfig)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	server, err := newGitCredentialServer()
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, server.Close()) }()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", filepath.Join(server.dir, gitCredentialsSocketName))
+			},
+		},
+	}
+	request := "protocol=https\nhost=example.com\n\n"
+
+	resp, err := client.Post("http://brig/get", "text/plain", strings.NewReader(request))
+	assert.Nil(t, err)
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Nil(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "username=brig\n")
+	assert.Contains(t, string(body), "password=hunter2\n")
+
+	resp, err = client.Post("http://brig/steal", "text/plain", strings.NewReader(request))
+	assert.Nil(t, err)
+	assert.Nil(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	assert.Nil(t, server.Close())
+	assert.NoDirExists(t, server.dir)
+}
+
+// TestEngineSocketPath checks that only unix socket addresses are
+// accepted for forwarding into the devcontainer.
+func TestEngineSocketPath(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	socket, err := engineSocketPath("unix:///run/user/1000/podman/podman.sock")
+	assert.Nil(t, err)
+	assert.Equal(t, "/run/user/1000/podman/podman.sock", socket)
+
+	socket, err = engineSocketPath("/var/run/docker.sock")
+	assert.Nil(t, err)
+	assert.Equal(t, "/var/run/docker.sock", socket)
+
+	_, err = engineSocketPath("tcp://127.0.0.1:2375")
+	assert.NotNil(t, err)
+	_, err = engineSocketPath("ssh://core@localhost:22/run/podman/podman.sock")
+	assert.NotNil(t, err)
+}
@@ -17,106 +17,142 @@
 package brig
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
-	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
+	"runtime"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
 
-	"github.com/nlsantos/brig/internal/trill"
+	"github.com/nlsantos/brig/trill"
 	"github.com/nlsantos/brig/writ"
 	"golang.org/x/sync/errgroup"
+	"mvdan.cc/sh/v3/shell"
 )
 
 // lifecycleHandler monitors the trill client's lifecycle channel and
 // runs the appropriate hooks.
+//
+// It exits when the lifecycle channel is closed or ctx is cancelled,
+// whichever comes first. Failures are reported wrapping
+// trill.ErrLifecycleHandler.
 func (cmd *Command) lifecycleHandler(ctx context.Context, eg *errgroup.Group, p *writ.DevcontainerParser) (err error) {
 	defer func() {
-		cmd.trillClient.DevcontainerLifecycleResp <- err == nil
+		if err != nil && ctx.Err() == nil {
+			err = fmt.Errorf("%w: %w", trill.ErrLifecycleHandler, err)
+		}
+		// Don't block if trill has stopped listening for a response
+		// (e.g., because ctx was cancelled)
+		select {
+		case cmd.trillClient.DevcontainerLifecycleResp <- err == nil:
+		default:
+		}
 		close(cmd.trillClient.DevcontainerLifecycleResp)
 	}()
 
-	for event := range cmd.trillClient.DevcontainerLifecycleChan {
+	attachHostTerminal := func() (err error) {
+		defer trill.RecoverPanic(&err)
+		err = cmd.trillClient.AttachHostTerminalToDevcontainer(ctx)
+		if errors.Is(err, trill.ErrDetached) {
+			cmd.detached = true
+			return nil
+		}
+		return err
+	}
+	// attachIfWaitedFor attaches the host terminal once the lifecycle
+	// reaches the point named in waitFor; in headless mode, the
+	// terminal is never attached, and runHeadless takes over once all
+	// the hooks have run instead
+	attachIfWaitedFor := func(waitFor writ.WaitFor) {
+		if !cmd.Options.NoAttach && *p.Config.WaitFor == waitFor {
+			eg.Go(attachHostTerminal)
+		}
+	}
+
+	for {
+		var event trill.LifecycleEvents
+		var ok bool
+		select {
+		case <-ctx.Done():
+			slog.Debug("context cancelled; exiting lifecycle handler")
+			return ctx.Err()
+		case event, ok = <-cmd.trillClient.DevcontainerLifecycleChan:
+		}
+		if !ok {
+			break
+		}
+
 		switch event {
 		case trill.LifecycleFeatureInstall:
 			slog.Debug("lifecycle", "event", "feature:install")
-			installDAG, err := cmd.BuildFeaturesInstallationGraph(&p.Config.OverrideFeatureInstallOrder)
+			if err = cmd.installCACertificates(ctx); err != nil {
+				return err
+			}
+			if cmd.Options.BuildFeatures {
+				slog.Debug("features were installed when the image was built; skipping")
+				break
+			}
+			featureParsers, err := cmd.FeatureInstallOrder(&p.Config.OverrideFeatureInstallOrder)
 			if err != nil {
 				return err
 			}
-			roots := installDAG.GetRoots()
-			for len(roots) > 0 {
-				for raw := range maps.Values(roots) {
-					featureParser, ok := raw.(*writ.DevcontainerFeatureParser)
-					if !ok {
-						return fmt.Errorf("value for vertex is of unexpected type")
-					}
-
-					featureInstallScript := filepath.Join(filepath.Dir(featureParser.Filepath), "install.sh")
-					featureOptions := &writ.EnvVarMap{}
-					for optName, opt := range featureParser.Config.Options {
-						reAlphaNum := regexp.MustCompile(`[^\w_]`)
-						reDigits := regexp.MustCompile(`^[\d_]+`)
-
-						envKey := reAlphaNum.ReplaceAllLiteralString(optName, "_")
-						envKey = reDigits.ReplaceAllLiteralString(envKey, "_")
-						envKey = strings.ToUpper(envKey)
-
-						switch opt.Type {
-						case writ.FeatureOptionTypeBoolean:
-							(*featureOptions)[envKey] = strconv.FormatBool(*opt.Value.Bool)
-
-						case writ.FeatureOptionTypeString:
-							(*featureOptions)[envKey] = *opt.Value.String
-						}
-					}
-
-					if _, _, err = cmd.trillClient.ExecInDevcontainer(ctx, "root", featureOptions, false, featureInstallScript); err != nil {
-						return err
-					}
-				}
+			for _, featureParser := range featureParsers {
+				featureInstallScript := filepath.Join(filepath.Dir(featureParser.Filepath), "install.sh")
+				featureOptions := featureInstallEnv(featureParser)
 
-				for id := range roots {
-					if err := installDAG.DeleteVertex(id); err != nil {
-						return err
-					}
+				stdout, stderr, captured := cmd.lifecycleOutputWriters("FEATURE", featureParser.Config.ID)
+				start := time.Now()
+				err = cmd.trillClient.StreamExecInDevcontainer(ctx, trill.ExecOptions{
+					User:   "root",
+					Env:    &featureOptions,
+					Stdout: stdout,
+					Stderr: stderr,
+				}, featureInstallScript)
+				cmd.recordLifecycleResult("FEATURE", featureParser.Config.ID, start, err)
+				if err != nil {
+					logCapturedLifecycleOutput("FEATURE", captured)
+					return err
 				}
-
-				roots = installDAG.GetRoots()
 			}
 
 		case trill.LifecycleInitialize:
 			slog.Debug("lifecycle", "event", "init")
 			if p.Config.InitializeCommand != nil {
-				if err = cmd.runLifecycleCommand(ctx, p.Config.InitializeCommand, p, true); err != nil {
+				if err = cmd.runLifecycleCommand(ctx, "INITIALIZE", "", p.Config.InitializeCommand, p, true); err != nil {
 					return err
 				}
 			}
-			if *p.Config.WaitFor == writ.WaitForInitializeCommand {
-				eg.Go(cmd.trillClient.AttachHostTerminalToDevcontainer)
-			}
+			attachIfWaitedFor(writ.WaitForInitializeCommand)
 
 		case trill.LifecycleOnCreate:
 			slog.Debug("lifecycle", "event", "onCreate")
+			if err = cmd.setUpForwarding(ctx, p); err != nil {
+				return err
+			}
+			if err = cmd.startSSHServer(ctx, p); err != nil {
+				return err
+			}
 			if p.Config.OnCreateCommand != nil {
-				if err = cmd.runLifecycleCommand(ctx, p.Config.OnCreateCommand, p, false); err != nil {
+				if err = cmd.runLifecycleCommand(ctx, "ONCREATE", "", p.Config.OnCreateCommand, p, false); err != nil {
 					return err
 				}
 			}
-			if *p.Config.WaitFor == writ.WaitForOnCreateCommand {
-				eg.Go(cmd.trillClient.AttachHostTerminalToDevcontainer)
+			if err = cmd.installDotfiles(ctx, p); err != nil {
+				return err
 			}
+			attachIfWaitedFor(writ.WaitForOnCreateCommand)
 
 		case trill.LifecyclePostAttach:
 			slog.Debug("lifecycle", "event", "postAttach")
 			if p.Config.PostAttachCommand != nil {
-				if err = cmd.runLifecycleCommand(ctx, p.Config.PostAttachCommand, p, false); err != nil {
+				if err = cmd.runLifecycleCommand(ctx, "POSTATTACH", "", p.Config.PostAttachCommand, p, false); err != nil {
 					return err
 				}
 			}
@@ -124,35 +160,36 @@ func (cmd *Command) lifecycleHandler(ctx context.Context, eg *errgroup.Group, p
 		case trill.LifecyclePostCreate:
 			slog.Debug("lifecycle", "event", "postCreate")
 			if p.Config.PostCreateCommand != nil {
-				if err = cmd.runLifecycleCommand(ctx, p.Config.PostCreateCommand, p, false); err != nil {
+				if err = cmd.runLifecycleCommand(ctx, "POSTCREATE", "", p.Config.PostCreateCommand, p, false); err != nil {
 					return err
 				}
 			}
-			if *p.Config.WaitFor == writ.WaitForPostCreateCommand {
-				eg.Go(cmd.trillClient.AttachHostTerminalToDevcontainer)
-			}
+			attachIfWaitedFor(writ.WaitForPostCreateCommand)
 
 		case trill.LifecyclePostStart:
 			slog.Debug("lifecycle", "event", "postStart")
 			if p.Config.PostStartCommand != nil {
-				if err = cmd.runLifecycleCommand(ctx, p.Config.PostStartCommand, p, false); err != nil {
+				if err = cmd.runLifecycleCommand(ctx, "POSTSTART", "", p.Config.PostStartCommand, p, false); err != nil {
 					return err
 				}
 			}
-			if *p.Config.WaitFor == writ.WaitForPostStartCommand {
-				eg.Go(cmd.trillClient.AttachHostTerminalToDevcontainer)
+			cmd.openForwardedPorts(ctx, p)
+			attachIfWaitedFor(writ.WaitForPostStartCommand)
+			if cmd.Options.NoAttach {
+				eg.Go(func() (err error) {
+					defer trill.RecoverPanic(&err)
+					return cmd.runHeadless(ctx, p)
+				})
 			}
 
 		case trill.LifecycleUpdate:
 			slog.Debug("lifecycle", "event", "update")
 			if p.Config.UpdateContentCommand != nil {
-				if err = cmd.runLifecycleCommand(ctx, p.Config.UpdateContentCommand, p, false); err != nil {
+				if err = cmd.runLifecycleCommand(ctx, "UPDATECONTENT", "", p.Config.UpdateContentCommand, p, false); err != nil {
 					return err
 				}
 			}
-			if *p.Config.WaitFor == writ.WaitForUpdateContentCommand {
-				eg.Go(cmd.trillClient.AttachHostTerminalToDevcontainer)
-			}
+			attachIfWaitedFor(writ.WaitForUpdateContentCommand)
 
 		default:
 			return fmt.Errorf("received unhandled lifecycle event: %v", event)
@@ -164,32 +201,77 @@ func (cmd *Command) lifecycleHandler(ctx context.Context, eg *errgroup.Group, p
 	return nil
 }
 
+// runHeadless stands in for attaching the host terminal when brig
+// runs headless (e.g., in CI), once the devcontainer has gone through
+// all its lifecycle hooks.
+//
+// If --exec is specified, the command is run in the devcontainer with
+// its output passed through, and its exit code is recorded so brig
+// can exit with it.
+func (cmd *Command) runHeadless(ctx context.Context, p *writ.DevcontainerParser) error {
+	defer cmd.trillClient.CloseLifecycle()
+
+	cmd.recordForwardedPorts(ctx)
+	if len(cmd.Options.Exec) == 0 {
+		slog.Info("devcontainer is up", "id", cmd.trillClient.ContainerID)
+		return nil
+	}
+
+	err := cmd.trillClient.StreamExecInDevcontainer(ctx, trill.ExecOptions{
+		User:       *p.Config.RemoteUser,
+		Env:        &p.Config.RemoteEnv,
+		RunInShell: true,
+		Stdout:     cmd.stdout(),
+		Stderr:     cmd.stderr(),
+	}, cmd.Options.Exec)
+	var exitErr *trill.ExecExitError
+	if errors.As(err, &exitErr) {
+		cmd.execExitCode = ExitCode(exitErr.ExitCode) // #nosec G115
+	}
+	return err
+}
+
 // runLifecycleCommand determines which parameter of a given lifecycle
 // command is active and runs it.
-func (cmd *Command) runLifecycleCommand(ctx context.Context, lc *writ.LifecycleCommand, p *writ.DevcontainerParser, runOnHost bool) (err error) {
+//
+// hook and label are used to tag the command's output; label defaults
+// to where the command runs, or to the command's name for parallel
+// commands.
+func (cmd *Command) runLifecycleCommand(ctx context.Context, hook string, label string, lc *writ.LifecycleCommand, p *writ.DevcontainerParser, runOnHost bool) (err error) {
+	if len(label) == 0 {
+		if runOnHost {
+			label = "host"
+		} else {
+			label = "devcontainer"
+		}
+	}
+
 	switch {
 	case lc.String != nil:
 		if runOnHost {
-			err = cmd.runLifecycleCommandOnHost(ctx, true, *lc.String)
+			err = cmd.runLifecycleCommandOnHost(ctx, hook, label, p, true, *lc.String)
 		} else {
-			err = cmd.runLifecycleCommandInContainer(ctx, p, true, *lc.String)
+			err = cmd.runLifecycleCommandInContainer(ctx, hook, label, p, true, *lc.String)
 		}
 
 	case len(lc.StringArray) > 0:
 		if runOnHost {
-			err = cmd.runLifecycleCommandOnHost(ctx, false, lc.StringArray...)
+			err = cmd.runLifecycleCommandOnHost(ctx, hook, label, p, false, lc.StringArray...)
 		} else {
-			err = cmd.runLifecycleCommandInContainer(ctx, p, false, lc.StringArray...)
+			err = cmd.runLifecycleCommandInContainer(ctx, hook, label, p, false, lc.StringArray...)
 		}
 
 	case lc.ParallelCommands != nil:
 		var wg sync.WaitGroup
 		errChan := make(chan error, len(*lc.ParallelCommands))
-		for _, pcmd := range *lc.ParallelCommands {
+		for name, pcmd := range *lc.ParallelCommands {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				errChan <- cmd.runLifecycleCommand(ctx, &writ.LifecycleCommand{CommandBase: pcmd}, p, runOnHost)
+				errChan <- func() (err error) {
+					defer trill.RecoverPanic(&err)
+					return cmd.runLifecycleCommand(ctx, hook, name, &writ.LifecycleCommand{CommandBase: pcmd}, p, runOnHost)
+				}()
 			}()
 		}
 		wg.Wait()
@@ -207,30 +289,140 @@ func (cmd *Command) runLifecycleCommand(ctx context.Context, lc *writ.LifecycleC
 // parameter inside the designated devcontainer (i.e., the lone
 // container in non-Composer configurations, or the one named in the
 // service field otherwise).
-func (cmd *Command) runLifecycleCommandInContainer(ctx context.Context, p *writ.DevcontainerParser, runInShell bool, args ...string) error {
-	_, _, err := cmd.trillClient.ExecInDevcontainer(ctx, *p.Config.RemoteUser, &p.Config.RemoteEnv, runInShell, args...)
+func (cmd *Command) runLifecycleCommandInContainer(ctx context.Context, hook string, label string, p *writ.DevcontainerParser, runInShell bool, args ...string) error {
+	stdout, stderr, captured := cmd.lifecycleOutputWriters(hook, label)
+	start := time.Now()
+	err := cmd.trillClient.StreamExecInDevcontainer(ctx, trill.ExecOptions{
+		User:       *p.Config.RemoteUser,
+		Env:        &p.Config.RemoteEnv,
+		RunInShell: runInShell,
+		Stdout:     stdout,
+		Stderr:     stderr,
+	}, args...)
+	cmd.recordLifecycleResult(hook, label, start, err)
+	if err != nil {
+		logCapturedLifecycleOutput(hook, captured)
+	}
 	return err
 }
 
 // runLifecycleCommandOnHost executes a lifecycle command parameter
 // locally on the host.
-func (cmd *Command) runLifecycleCommandOnHost(ctx context.Context, runInShell bool, args ...string) error {
-	var execCmd *exec.Cmd
+//
+// As the spec requires, the command runs in the local workspace
+// folder with the host's environment; that's not necessarily the
+// context directory, which context can point elsewhere.
+func (cmd *Command) runLifecycleCommandOnHost(ctx context.Context, hook string, label string, p *writ.DevcontainerParser, runInShell bool, args ...string) error {
+	execCmd := buildHostCommand(ctx, runInShell, args...)
+	execCmd.Dir = p.WorkspaceFolder()
+	// The host's environment, with PWD pointed at Dir
+	execCmd.Env = execCmd.Environ()
+	var captured *bytes.Buffer
+	execCmd.Stdout, execCmd.Stderr, captured = cmd.lifecycleOutputWriters(hook, label)
 
-	if runInShell {
-		shell := os.Getenv("SHELL")
-		if len(shell) == 0 {
-			shell = "/bin/sh"
+	slog.Info("running command on host", "cmd", execCmd.String(), "dir", execCmd.Dir)
+	start := time.Now()
+	err := execCmd.Run()
+	cmd.recordLifecycleResult(hook, label, start, err)
+	if err != nil {
+		slog.Error("command on host failed", "cmd", execCmd.String(), "error", err)
+		logCapturedLifecycleOutput(hook, captured)
+		return fmt.Errorf("command on host failed: %w", err)
+	}
+	return nil
+}
+
+// lifecycleOutputWriters returns the writers that a lifecycle
+// command's stdout and stderr are sent to.
+//
+// Output is normally passed through as it's produced, with each line
+// prefixed with hook and label so the output of commands running in
+// parallel can be told apart. With --quiet-lifecycle, output is
+// collected in the returned buffer instead, so it can be shown if the
+// command fails; otherwise, the buffer is nil.
+func (cmd *Command) lifecycleOutputWriters(hook string, label string) (stdout io.Writer, stderr io.Writer, captured *bytes.Buffer) {
+	if cmd.Options.QuietLifecycle {
+		captured = &bytes.Buffer{}
+		// Both streams share the one buffer; the writes to them are
+		// sequential, so they don't need to be synchronized
+		return captured, captured, captured
+	}
+	return trill.NewPrefixedStreamWriter(cmd.stdout(), hook, label), trill.NewPrefixedStreamWriter(cmd.stderr(), hook, label), nil
+}
+
+// logCapturedLifecycleOutput logs the output a failed lifecycle
+// command produced while --quiet-lifecycle was in effect.
+func logCapturedLifecycleOutput(hook string, captured *bytes.Buffer) {
+	if captured != nil && captured.Len() > 0 {
+		slog.Error("output of failed lifecycle command", "hook", hook, "output", captured.String())
+	}
+}
+
+// openForwardedPorts runs the browser command set in the settings for
+// each port the devcontainer publishes whose onAutoForward attribute
+// is openBrowser.
+//
+// Failures are logged but otherwise ignored, as they don't affect the
+// devcontainer itself.
+func (cmd *Command) openForwardedPorts(ctx context.Context, p *writ.DevcontainerParser) {
+	if cmd.settings == nil || len(cmd.settings.BrowserCommand) == 0 || len(cmd.trillClient.ContainerID) == 0 {
+		return
+	}
+	browserArgs, err := shell.Fields(cmd.settings.BrowserCommand, nil)
+	if err != nil || len(browserArgs) == 0 {
+		slog.Warn("unable to parse the browser command in the settings", "command", cmd.settings.BrowserCommand, "error", err)
+		return
+	}
+
+	ports, err := cmd.trillClient.InspectPublishedPorts(ctx, cmd.trillClient.ContainerID)
+	if err != nil {
+		slog.Warn("unable to determine the devcontainer's forwarded ports", "error", err)
+		return
+	}
+	for _, port := range ports {
+		attrs, ok := p.Config.PortsAttributes[strconv.Itoa(int(port.ContainerPort))]
+		if !ok && p.Config.OtherPortsAttributes != nil {
+			attrs = *p.Config.OtherPortsAttributes
+		}
+		if attrs.OnAutoForward == nil || *attrs.OnAutoForward != writ.OnAutoForwardOpenBrowser {
+			continue
+		}
+
+		host := port.HostIP
+		if len(host) == 0 || host == "0.0.0.0" || host == "::" {
+			host = "localhost"
+		}
+		url := fmt.Sprintf("http://%s:%s", host, port.HostPort)
+		slog.Info("opening forwarded port in browser", "port", port.ContainerPort, "url", url)
+		// Not tied to ctx; the browser shouldn't go away with brig
+		browserCmd := exec.Command(browserArgs[0], append(browserArgs[1:], url)...)
+		if err := browserCmd.Start(); err != nil {
+			slog.Warn("unable to run the browser command", "command", cmd.settings.BrowserCommand, "error", err)
+			continue
 		}
-		slog.Info("running command via shell on host", "shell", shell, "args", args)
-		args = append([]string{"-c"}, args...)
-		execCmd = exec.CommandContext(ctx, shell, args...)
-	} else {
-		slog.Info("running command directly on host", "args", args)
-		execCmd = exec.CommandContext(ctx, args[0], args[1:]...)
+		go func() { _ = browserCmd.Wait() }()
 	}
+}
 
-	out, err := execCmd.CombinedOutput()
-	slog.Info("command output", "cmd", execCmd.String(), "output", string(out), "error", err)
-	return err
+// buildHostCommand prepares args to be run on the host, either
+// through the user's shell or directly.
+//
+// The shell is taken from $SHELL, falling back to /bin/sh, or to
+// cmd.exe on Windows.
+func buildHostCommand(ctx context.Context, runInShell bool, args ...string) *exec.Cmd {
+	if !runInShell {
+		return exec.CommandContext(ctx, args[0], args[1:]...)
+	}
+
+	shell := os.Getenv("SHELL")
+	shellFlag := "-c"
+	if len(shell) == 0 {
+		if runtime.GOOS == "windows" {
+			shell = "cmd.exe"
+			shellFlag = "/C"
+		} else {
+			shell = "/bin/sh"
+		}
+	}
+	return exec.CommandContext(ctx, shell, append([]string{shellFlag}, args...)...)
 }
@@ -0,0 +1,67 @@
+package brig
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRunLifecycleCommandOnHost checks that commands run on the host
+// (i.e., initializeCommand) run in the workspace, rather than the
+// context directory, and see the host's environment.
+func TestRunLifecycleCommandOnHost(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on a POSIX shell")
+	}
+	t.Setenv("SHELL", "/bin/sh")
+	t.Setenv("BRIG_TEST_VAR", "from-host")
+
+	workspaceDir := t.TempDir()
+	contextDir := t.TempDir()
+	p := &writ.DevcontainerParser{LocalWorkspaceFolder: workspaceDir}
+	p.Config.Context = &contextDir
+
+	cmd := &Command{}
+	initCmd := "pwd > out.txt; echo \"$BRIG_TEST_VAR\" >> out.txt"
+	assert.Nil(t, cmd.runLifecycleCommand(context.Background(), "INITIALIZE", "", &writ.LifecycleCommand{CommandBase: writ.CommandBase{String: &initCmd}}, p, true))
+
+	out, err := os.ReadFile(filepath.Join(workspaceDir, "out.txt"))
+	assert.Nil(t, err)
+	resolvedWorkspaceDir, err := filepath.EvalSymlinks(workspaceDir)
+	assert.Nil(t, err)
+	assert.Equal(t, resolvedWorkspaceDir+"\nfrom-host\n", string(out))
+
+	assert.Error(t, cmd.runLifecycleCommand(context.Background(), "INITIALIZE", "", &writ.LifecycleCommand{CommandBase: writ.CommandBase{StringArray: []string{"false"}}}, p, true))
+}
+
+// TestLifecycleOutputWriters checks that lifecycle command output is
+// only collected when --quiet-lifecycle is in effect.
+func TestLifecycleOutputWriters(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cmd := &Command{}
+	stdout, stderr, captured := cmd.lifecycleOutputWriters("POSTCREATE", "devcontainer")
+	assert.Nil(t, captured)
+	assert.NotNil(t, stdout)
+	assert.NotNil(t, stderr)
+
+	cmd.Options.QuietLifecycle = true
+	stdout, stderr, captured = cmd.lifecycleOutputWriters("POSTCREATE", "devcontainer")
+	assert.NotNil(t, captured)
+	_, err := io.WriteString(stdout, "out\n")
+	assert.Nil(t, err)
+	_, err = io.WriteString(stderr, "err\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "out\nerr\n", captured.String())
+}
@@ -0,0 +1,140 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/moby/moby/api/types/mount"
+	"github.com/nlsantos/brig/writ"
+)
+
+// hostLocaltimePath is the host's zoneinfo file, which is mounted
+// into the devcontainer at the same path with --propagate-timezone.
+const hostLocaltimePath string = "/etc/localtime"
+
+// hostTimezoneFilePath is where Debian-based distros record the
+// name of the host's timezone.
+const hostTimezoneFilePath string = "/etc/timezone"
+
+// propagateLocalization passes the host's timezone and locale settings
+// on to the devcontainer described by p, as --propagate-timezone and
+// --propagate-locale (or their customizations.brig counterparts) say.
+//
+// Needs to be called before the devcontainer is created; variables
+// containerEnv sets already are left alone.
+func (cmd *Command) propagateLocalization(p *writ.DevcontainerParser) {
+	if cmd.Options.PropagateTimezone {
+		cmd.propagateTimezone(p)
+	}
+	if cmd.Options.PropagateLocale {
+		propagateLocale(p, os.Environ())
+	}
+}
+
+// propagateTimezone sets TZ in the devcontainer to the host's
+// timezone, and mounts the host's /etc/localtime over the
+// devcontainer's if the engine runs on this host, so the timezone
+// applies even if the devcontainer doesn't have tzdata installed.
+func (cmd *Command) propagateTimezone(p *writ.DevcontainerParser) {
+	tz := timezoneName(os.Getenv("TZ"), hostLocaltimePath, hostTimezoneFilePath)
+	if len(tz) > 0 {
+		setContainerEnvDefault(p, "TZ", tz)
+	} else {
+		slog.Warn("could not determine the host's timezone; not setting TZ")
+	}
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if _, err := engineSocketPath(cmd.trillClient.SocketAddr); err != nil {
+		slog.Debug("not mounting the host's localtime, as the engine isn't local", "socket", cmd.trillClient.SocketAddr)
+		return
+	}
+	if _, err := os.Stat(hostLocaltimePath); err != nil {
+		slog.Debug("not mounting the host's localtime", "error", err)
+		return
+	}
+	for _, m := range p.Config.Mounts {
+		if m.Target == hostLocaltimePath {
+			slog.Debug("leaving the localtime mount in the devcontainer's configuration alone")
+			return
+		}
+	}
+	p.Config.Mounts = append(p.Config.Mounts, &writ.MobyMount{Mount: mount.Mount{
+		Type:     mount.TypeBind,
+		Source:   hostLocaltimePath,
+		Target:   hostLocaltimePath,
+		ReadOnly: true,
+	}})
+}
+
+// timezoneName returns the name of the host's timezone (e.g.,
+// Asia/Manila), going by tz (i.e., $TZ) if it's set, then by what
+// localtimePath links to, then by what's recorded in timezonePath.
+//
+// Returns an empty string if none of them name one.
+func timezoneName(tz string, localtimePath string, timezonePath string) string {
+	tz = strings.TrimPrefix(tz, ":")
+	if len(tz) > 0 && !filepath.IsAbs(tz) {
+		return tz
+	}
+	if len(tz) > 0 {
+		localtimePath = tz
+	}
+	if target, err := filepath.EvalSymlinks(localtimePath); err == nil {
+		if _, name, ok := strings.Cut(filepath.ToSlash(target), "/zoneinfo/"); ok && len(name) > 0 {
+			return name
+		}
+	}
+	if contents, err := os.ReadFile(timezonePath); err == nil {
+		return strings.TrimSpace(string(contents))
+	}
+	return ""
+}
+
+// propagateLocale copies the locale settings in environ (i.e., LANG,
+// LANGUAGE, and the LC_* variables) into the devcontainer's
+// containerEnv.
+func propagateLocale(p *writ.DevcontainerParser, environ []string) {
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || len(value) == 0 {
+			continue
+		}
+		if key == "LANG" || key == "LANGUAGE" || strings.HasPrefix(key, "LC_") {
+			setContainerEnvDefault(p, key, value)
+		}
+	}
+}
+
+// setContainerEnvDefault sets key to value in the devcontainer's
+// containerEnv, unless it's set already.
+func setContainerEnvDefault(p *writ.DevcontainerParser, key string, value string) {
+	if p.Config.ContainerEnv == nil {
+		p.Config.ContainerEnv = make(writ.EnvVarMap)
+	}
+	if _, ok := p.Config.ContainerEnv[key]; ok {
+		slog.Debug("leaving variable set in containerEnv alone", "key", key)
+		return
+	}
+	p.Config.ContainerEnv[key] = value
+}
@@ -0,0 +1,65 @@
+package brig
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTimezoneName checks that the host's timezone is taken from TZ,
+// then from what /etc/localtime links to, then from /etc/timezone.
+func TestTimezoneName(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+	assert.Equal(t, "Asia/Manila", timezoneName("Asia/Manila", missing, missing))
+	assert.Equal(t, "UTC", timezoneName(":UTC", missing, missing))
+	assert.Equal(t, "", timezoneName("", missing, missing))
+
+	timezonePath := filepath.Join(dir, "timezone")
+	assert.Nil(t, os.WriteFile(timezonePath, []byte("Europe/Berlin\n"), 0o644))
+	assert.Equal(t, "Europe/Berlin", timezoneName("", missing, timezonePath))
+
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on symlinks")
+	}
+	zoneinfo := filepath.Join(dir, "usr", "share", "zoneinfo", "America")
+	assert.Nil(t, os.MkdirAll(zoneinfo, 0o755))
+	assert.Nil(t, os.WriteFile(filepath.Join(zoneinfo, "Chicago"), []byte("TZif"), 0o644))
+	localtime := filepath.Join(dir, "localtime")
+	assert.Nil(t, os.Symlink(filepath.Join(zoneinfo, "Chicago"), localtime))
+	assert.Equal(t, "America/Chicago", timezoneName("", localtime, timezonePath))
+	assert.Equal(t, "America/Chicago", timezoneName(":"+localtime, missing, missing))
+}
+
+// TestPropagateLocale checks that only the locale settings are copied
+// into containerEnv, and that those containerEnv sets win.
+func TestPropagateLocale(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p := &writ.DevcontainerParser{}
+	p.Config.ContainerEnv = writ.EnvVarMap{"LC_TIME": "C.UTF-8"}
+	propagateLocale(p, []string{
+		"HOME=/home/user",
+		"LANG=en_PH.UTF-8",
+		"LANGUAGE=en_PH:en",
+		"LC_ALL=",
+		"LC_TIME=en_GB.UTF-8",
+		"LC_MONETARY=fil_PH.UTF-8",
+	})
+	assert.Equal(t, writ.EnvVarMap{
+		"LANG":        "en_PH.UTF-8",
+		"LANGUAGE":    "en_PH:en",
+		"LC_MONETARY": "fil_PH.UTF-8",
+		"LC_TIME":     "C.UTF-8",
+	}, p.Config.ContainerEnv)
+}
@@ -0,0 +1,225 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/golang-cz/devslog"
+	"golang.org/x/term"
+)
+
+// Supported values for --log-format
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Subsystems whose log level can be set on its own with --log-level
+const (
+	LogSubsystemBrig  = "brig"
+	LogSubsystemTrill = "trill"
+	LogSubsystemWrit  = "writ"
+)
+
+// modulePath is the import path of the module the subsystems live in.
+const modulePath = "github.com/nlsantos/brig/"
+
+// logLevels holds the level messages have to be at to be logged, both
+// overall and for specific subsystems.
+type logLevels struct {
+	base       slog.Level
+	subsystems map[string]slog.Level
+}
+
+// parseLogLevels parses the argument of --log-level on top of base:
+// a comma-separated list of levels (debug, info, warn, or error),
+// each either bare, to apply to every subsystem, or prefixed with the
+// name of the one subsystem it applies to (e.g., "info,trill=debug").
+func parseLogLevels(s string, base slog.Level) (logLevels, error) {
+	levels := logLevels{base: base, subsystems: make(map[string]slog.Level)}
+	if len(s) == 0 {
+		return levels, nil
+	}
+	for entry := range strings.SplitSeq(s, ",") {
+		subsystem, levelName, hasSubsystem := strings.Cut(strings.TrimSpace(entry), "=")
+		if !hasSubsystem {
+			subsystem, levelName = "", subsystem
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(levelName)); err != nil {
+			return logLevels{}, fmt.Errorf("invalid log level %q: %w", entry, err)
+		}
+		switch subsystem {
+		case "":
+			levels.base = level
+		case LogSubsystemBrig, LogSubsystemTrill, LogSubsystemWrit:
+			levels.subsystems[subsystem] = level
+		default:
+			return logLevels{}, fmt.Errorf("unknown log subsystem %q; expected %s, %s, or %s", subsystem, LogSubsystemBrig, LogSubsystemTrill, LogSubsystemWrit)
+		}
+	}
+	return levels, nil
+}
+
+// min returns the lowest level anything gets logged at.
+func (l logLevels) min() slog.Level {
+	lowest := l.base
+	for _, level := range l.subsystems {
+		lowest = min(lowest, level)
+	}
+	return lowest
+}
+
+// forSubsystem returns the level messages from subsystem have to be
+// at to be logged.
+func (l logLevels) forSubsystem(subsystem string) slog.Level {
+	if level, ok := l.subsystems[subsystem]; ok {
+		return level
+	}
+	return l.base
+}
+
+// logSubsystem returns the subsystem the function at pc is a part of,
+// going by the package it's in; anything outside of trill and writ
+// (including third-party packages) counts as brig.
+func logSubsystem(pc uintptr) string {
+	if pc == 0 {
+		return LogSubsystemBrig
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	pkgPath, ok := strings.CutPrefix(frame.Function, modulePath)
+	if !ok {
+		return LogSubsystemBrig
+	}
+	pkg, _, _ := strings.Cut(pkgPath, ".")
+	pkg, _, _ = strings.Cut(pkg, "/")
+	switch pkg {
+	case LogSubsystemTrill, LogSubsystemWrit:
+		return pkg
+	default:
+		return LogSubsystemBrig
+	}
+}
+
+// subsystemHandler is a slog.Handler that drops records that are
+// below the level of the subsystem they were logged from before
+// passing the rest on to the handler it wraps.
+type subsystemHandler struct {
+	slog.Handler
+	levels logLevels
+}
+
+// Enabled reports whether level is high enough to be logged from any
+// subsystem; which one a record comes from is only known once it's
+// handled.
+func (h *subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.levels.min()
+}
+
+// Handle passes r on to the wrapped handler if its level is high
+// enough for the subsystem it was logged from.
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < h.levels.forSubsystem(logSubsystem(r.PC)) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs wraps the handler the wrapped handler returns.
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &subsystemHandler{Handler: h.Handler.WithAttrs(attrs), levels: h.levels}
+}
+
+// WithGroup wraps the handler the wrapped handler returns.
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return &subsystemHandler{Handler: h.Handler.WithGroup(name), levels: h.levels}
+}
+
+// newLogHandler returns the handler brig logs through: one that
+// writes records in format to w, filtered by levels.
+//
+// Text is written with devslog, colored if w is a terminal.
+func newLogHandler(w io.Writer, format string, levels logLevels) (slog.Handler, error) {
+	handlerOpts := &slog.HandlerOptions{
+		AddSource: true,
+		Level:     levels.min(),
+	}
+	var handler slog.Handler
+	switch format {
+	case "", LogFormatText:
+		file, isFile := w.(*os.File)
+		handler = devslog.NewHandler(w, &devslog.Options{
+			HandlerOptions:    handlerOpts,
+			NewLineAfterLog:   false,
+			NoColor:           !isFile || !term.IsTerminal(int(file.Fd())),
+			SortKeys:          true,
+			StringIndentation: true,
+		})
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", format)
+	}
+	if len(levels.subsystems) == 0 {
+		return handler, nil
+	}
+	return &subsystemHandler{Handler: handler, levels: levels}, nil
+}
+
+// setUpLogging points slog's default logger at stderr, or the file
+// named by --log-file, in the format set with --log-format, and at the
+// levels set with -d, -v, and --log-level.
+//
+// Returns the level that applies to subsystems without a level of
+// their own. The log file is appended to, and is left open until brig
+// exits.
+func (cmd *Command) setUpLogging() (slog.Level, error) {
+	base := slog.LevelError
+	switch {
+	case cmd.Options.Debug:
+		base = slog.LevelDebug
+	case cmd.Options.Verbose:
+		base = slog.LevelInfo
+	}
+	levels, err := parseLogLevels(cmd.Options.LogLevel, base)
+	if err != nil {
+		return 0, err
+	}
+
+	var w io.Writer = os.Stderr
+	if len(cmd.Options.LogFile) > 0 {
+		logFile, err := os.OpenFile(cmd.Options.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return 0, fmt.Errorf("unable to open log file: %w", err)
+		}
+		w = logFile
+	}
+
+	handler, err := newLogHandler(w, cmd.Options.LogFormat, levels)
+	if err != nil {
+		return 0, err
+	}
+	slog.SetDefault(slog.New(handler))
+	return levels.base, nil
+}
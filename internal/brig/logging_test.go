@@ -0,0 +1,77 @@
+package brig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseLogLevels checks that bare levels apply to every
+// subsystem, and prefixed ones only to theirs.
+func TestParseLogLevels(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	levels, err := parseLogLevels("", slog.LevelInfo)
+	assert.Nil(t, err)
+	assert.Equal(t, slog.LevelInfo, levels.base)
+	assert.Empty(t, levels.subsystems)
+
+	levels, err = parseLogLevels("warn, trill=debug,writ=ERROR", slog.LevelInfo)
+	assert.Nil(t, err)
+	assert.Equal(t, slog.LevelWarn, levels.base)
+	assert.Equal(t, slog.LevelDebug, levels.forSubsystem(LogSubsystemTrill))
+	assert.Equal(t, slog.LevelError, levels.forSubsystem(LogSubsystemWrit))
+	assert.Equal(t, slog.LevelWarn, levels.forSubsystem(LogSubsystemBrig))
+	assert.Equal(t, slog.LevelDebug, levels.min())
+
+	_, err = parseLogLevels("loud", slog.LevelInfo)
+	assert.NotNil(t, err)
+	_, err = parseLogLevels("compose=debug", slog.LevelInfo)
+	assert.NotNil(t, err)
+}
+
+// TestLogSubsystem checks that records are attributed to the
+// subsystem of the package they were logged from.
+func TestLogSubsystem(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	pc, _, _, _ := runtime.Caller(0)
+	assert.Equal(t, LogSubsystemBrig, logSubsystem(pc))
+	assert.Equal(t, LogSubsystemBrig, logSubsystem(0))
+}
+
+// TestNewLogHandler checks that JSON records are written one per
+// line, and that records below their subsystem's level are dropped.
+func TestNewLogHandler(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var buf bytes.Buffer
+	levels, err := parseLogLevels("debug,brig=warn", slog.LevelError)
+	assert.Nil(t, err)
+	handler, err := newLogHandler(&buf, LogFormatJSON, levels)
+	assert.Nil(t, err)
+	logger := slog.New(handler)
+	assert.True(t, handler.Enabled(context.Background(), slog.LevelDebug))
+
+	// These are logged from brig, so only the warning gets through
+	logger.Info("dropped")
+	logger.With("key", "value").Warn("kept")
+
+	var record map[string]any
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "kept", record["msg"])
+	assert.Equal(t, "value", record["key"])
+	assert.Contains(t, record, slog.SourceKey)
+
+	_, err = newLogHandler(&buf, "xml", levels)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,120 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nlsantos/brig/writ"
+)
+
+// parseOptionValue converts value, as given by the user, to the type
+// of the option name among options, checking it against the values
+// the option allows. kind is what the options belong to (e.g.,
+// "feature"), for the error messages.
+func parseOptionValue(kind string, options writ.FeatureOptions, name string, value string) (*writ.FeatureValue, error) {
+	option, ok := options[name]
+	if !ok {
+		return nil, fmt.Errorf("the %s has no option named %s; it has: %s", kind, name, strings.Join(slices.Sorted(maps.Keys(options)), ", "))
+	}
+	if option.Type == writ.FeatureOptionTypeBoolean {
+		boolValue, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("option %s takes a boolean value", name)
+		}
+		return &writ.FeatureValue{Bool: &boolValue}, nil
+	}
+	if len(option.Enum) > 0 && !slices.Contains(option.Enum, value) {
+		return nil, fmt.Errorf("option %s must be one of: %s", name, strings.Join(option.Enum, ", "))
+	}
+	return &writ.FeatureValue{String: &value}, nil
+}
+
+// optionPrompt asks the user questions on out, reading the answers
+// from scanner.
+type optionPrompt struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+	abort   string // What the error returned if the answers run out says; e.g., "feature not added"
+}
+
+// newOptionPrompt returns an optionPrompt that asks on out, reading
+// the answers from in.
+func newOptionPrompt(in io.Reader, out io.Writer, abort string) *optionPrompt {
+	return &optionPrompt{scanner: bufio.NewScanner(in), out: out, abort: abort}
+}
+
+// ask writes prompt to out, and returns the answer read, trimmed of
+// surrounding whitespace.
+func (o *optionPrompt) ask(prompt string) (string, error) {
+	fmt.Fprint(o.out, prompt)
+	if !o.scanner.Scan() {
+		fmt.Fprintln(o.out)
+		return "", errors.Join(errors.New(o.abort), o.scanner.Err())
+	}
+	return strings.TrimSpace(o.scanner.Text()), nil
+}
+
+// askOptions asks for the value of each option among options named
+// in optNames, in order, showing its description, the values it
+// allows or suggests, and its current value. Each answer is passed to
+// set, and asked for again if set fails; an empty answer keeps the
+// option at its current value.
+func (o *optionPrompt) askOptions(options writ.FeatureOptions, optNames []string, set func(name string, value string) error) error {
+	for _, optName := range optNames {
+		option := options[optName]
+		if option.Description != nil {
+			fmt.Fprintf(o.out, "%s: %s\n", optName, *option.Description)
+		}
+		switch {
+		case len(option.Enum) > 0:
+			fmt.Fprintf(o.out, "  one of: %s\n", strings.Join(option.Enum, ", "))
+		case len(option.Proposals) > 0:
+			fmt.Fprintf(o.out, "  e.g.: %s\n", strings.Join(option.Proposals, ", "))
+		}
+		var current string
+		switch {
+		case option.Value == nil:
+		case option.Value.Bool != nil:
+			current = strconv.FormatBool(*option.Value.Bool)
+		case option.Value.String != nil:
+			current = *option.Value.String
+		}
+
+		for {
+			answer, err := o.ask(fmt.Sprintf("%s [%s]: ", optName, current))
+			if err != nil {
+				return err
+			}
+			if len(answer) == 0 {
+				break
+			}
+			if err = set(optName, answer); err == nil {
+				break
+			}
+			fmt.Fprintln(o.out, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package brig
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseOptionValue checks that values given by the user are
+// converted to the types of Feature and Template options, and checked
+// against the values they allow.
+func TestParseOptionValue(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	options := writ.FeatureOptions{
+		"verbose": {Type: writ.FeatureOptionTypeBoolean},
+		"flavor":  {Type: writ.FeatureOptionTypeString, Enum: []string{"plain", "spicy"}},
+		"token":   {Type: writ.FeatureOptionTypeString},
+	}
+
+	value, err := parseOptionValue("feature", options, "verbose", "true")
+	assert.Nil(t, err)
+	if assert.NotNil(t, value.Bool) {
+		assert.True(t, *value.Bool)
+	}
+	_, err = parseOptionValue("feature", options, "verbose", "maybe")
+	assert.ErrorContains(t, err, "option verbose takes a boolean value")
+
+	value, err = parseOptionValue("feature", options, "flavor", "spicy")
+	assert.Nil(t, err)
+	assert.Equal(t, "spicy", *value.String)
+	_, err = parseOptionValue("feature", options, "flavor", "mild")
+	assert.ErrorContains(t, err, "option flavor must be one of: plain, spicy")
+
+	value, err = parseOptionValue("feature", options, "token", "true")
+	assert.Nil(t, err)
+	assert.Equal(t, "true", *value.String)
+
+	_, err = parseOptionValue("template", options, "shell", "zsh")
+	assert.ErrorContains(t, err, "the template has no option named shell; it has: flavor, token, verbose")
+}
+
+// TestOptionPromptAbort checks that running out of answers is
+// reported with the error the prompt was made with.
+func TestOptionPromptAbort(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	prompt := newOptionPrompt(strings.NewReader("  yes  \n"), io.Discard, "nothing done")
+	answer, err := prompt.ask("Continue? ")
+	assert.Nil(t, err)
+	assert.Equal(t, "yes", answer)
+	_, err = prompt.ask("Continue? ")
+	assert.EqualError(t, err, "nothing done")
+}
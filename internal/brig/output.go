@@ -0,0 +1,144 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/nlsantos/brig/trill"
+)
+
+// Supported values for --output
+const (
+	OutputFormatText = "text"
+	OutputFormatJSON = "json"
+)
+
+// Result is the document written to stdout on exit when --output=json
+// is in effect.
+//
+// Fields that don't apply to a given run (e.g., the image tag when
+// validation fails) are omitted.
+type Result struct {
+	Status             string                `json:"status"` // "ok" or "error"
+	ExitCode           ExitCode              `json:"exitCode"`
+	Error              string                `json:"error,omitempty"`
+	ConfigFile         string                `json:"configFile,omitempty"`
+	DevcontainerID     string                `json:"devcontainerId,omitempty"`
+	ImageTag           string                `json:"imageTag,omitempty"`
+	ContainerID        string                `json:"containerId,omitempty"`
+	ContainerName      string                `json:"containerName,omitempty"`
+	ComposeProjectName string                `json:"composeProjectName,omitempty"`
+	ForwardedPorts     []trill.PublishedPort `json:"forwardedPorts,omitempty"`
+	Lifecycle          []LifecycleResult     `json:"lifecycle,omitempty"`
+	SSHHost            string                `json:"sshHost,omitempty"`
+	ValidationErrors   []string              `json:"validationErrors,omitempty"`
+}
+
+// LifecycleResult records the outcome of a single lifecycle command
+// or feature installation.
+type LifecycleResult struct {
+	Hook       string `json:"hook"`
+	Label      string `json:"label"`
+	DurationMs int64  `json:"durationMs"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+}
+
+// updateResult runs fn against the result document while holding its
+// lock, as parts of it are filled in from different goroutines.
+func (cmd *Command) updateResult(fn func(r *Result)) {
+	cmd.resultMu.Lock()
+	defer cmd.resultMu.Unlock()
+	fn(&cmd.result)
+}
+
+// recordError notes err as the reason the run failed; only the first
+// error recorded is kept, as it's usually the root cause.
+func (cmd *Command) recordError(err error) {
+	if err == nil {
+		return
+	}
+	cmd.updateResult(func(r *Result) {
+		if len(r.Error) == 0 {
+			r.Error = err.Error()
+		}
+	})
+}
+
+// recordLifecycleResult notes the outcome of a lifecycle command that
+// was started at start.
+func (cmd *Command) recordLifecycleResult(hook string, label string, start time.Time, err error) {
+	lr := LifecycleResult{
+		Hook:       hook,
+		Label:      label,
+		DurationMs: time.Since(start).Milliseconds(),
+		Success:    err == nil,
+	}
+	if err != nil {
+		lr.Error = err.Error()
+	}
+	cmd.updateResult(func(r *Result) {
+		r.Lifecycle = append(r.Lifecycle, lr)
+	})
+	cmd.recordSpan(strings.TrimSpace(hook+" "+label), start, err)
+}
+
+// recordForwardedPorts notes the ports the devcontainer has published
+// on the host.
+//
+// This is only done when the result document is going to be written
+// out, as it costs a round-trip to the API.
+func (cmd *Command) recordForwardedPorts(ctx context.Context) {
+	if cmd.resultOutput == nil || len(cmd.trillClient.ContainerID) == 0 {
+		return
+	}
+	ports, err := cmd.trillClient.InspectPublishedPorts(ctx, cmd.trillClient.ContainerID)
+	if err != nil {
+		slog.Warn("unable to determine the devcontainer's forwarded ports", "error", err)
+		return
+	}
+	cmd.updateResult(func(r *Result) {
+		r.ForwardedPorts = ports
+	})
+}
+
+// writeResult writes the result document out as JSON, if
+// --output=json is in effect; otherwise, it does nothing.
+func (cmd *Command) writeResult(exitCode ExitCode) {
+	if cmd.resultOutput == nil {
+		return
+	}
+	cmd.updateResult(func(r *Result) {
+		r.ExitCode = exitCode
+		if exitCode == ExitNormal {
+			r.Status = "ok"
+		} else {
+			r.Status = "error"
+		}
+		if cmd.trillClient != nil {
+			r.ContainerID = cmd.trillClient.ContainerID
+		}
+		if err := json.NewEncoder(cmd.resultOutput).Encode(r); err != nil {
+			slog.Error("unable to write out result document", "error", err)
+		}
+	})
+}
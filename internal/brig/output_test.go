@@ -0,0 +1,56 @@
+package brig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWriteResult checks that the result document is only written out
+// when requested, and that it carries what was recorded during the
+// run.
+func TestWriteResult(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// Without --output=json, nothing is written anywhere
+	cmd := &Command{}
+	cmd.writeResult(ExitNormal)
+
+	var out bytes.Buffer
+	cmd = &Command{resultOutput: &out}
+	cmd.result.ConfigFile = "/src/.devcontainer/devcontainer.json"
+	cmd.updateResult(func(r *Result) {
+		r.ImageTag = "localhost/devc--src"
+	})
+	cmd.recordLifecycleResult("ONCREATE", "devcontainer", time.Now(), nil)
+	cmd.recordLifecycleResult("POSTCREATE", "devcontainer", time.Now(), errors.New("command returned non-zero exit code: 2"))
+	cmd.recordError(errors.New("first"))
+	cmd.recordError(errors.New("second"))
+	cmd.writeResult(ExitError)
+
+	var res Result
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &res))
+	assert.Equal(t, "error", res.Status)
+	assert.Equal(t, ExitError, res.ExitCode)
+	assert.Equal(t, "first", res.Error)
+	assert.Equal(t, "/src/.devcontainer/devcontainer.json", res.ConfigFile)
+	assert.Equal(t, "localhost/devc--src", res.ImageTag)
+	assert.Len(t, res.Lifecycle, 2)
+	assert.True(t, res.Lifecycle[0].Success)
+	assert.False(t, res.Lifecycle[1].Success)
+	assert.Equal(t, "POSTCREATE", res.Lifecycle[1].Hook)
+	assert.NotEmpty(t, res.Lifecycle[1].Error)
+
+	// Fields that don't apply are left out of the document entirely
+	var raw map[string]any
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &raw))
+	assert.NotContains(t, raw, "containerId")
+	assert.NotContains(t, raw, "validationErrors")
+}
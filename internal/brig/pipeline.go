@@ -0,0 +1,508 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nlsantos/brig/trill"
+	"github.com/nlsantos/brig/writ"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrNoSocketFound is returned by Connect when no socket address is
+// given and none can be found.
+var ErrNoSocketFound = errors.New("no Podman/Docker socket address specified and none can be found")
+
+// ErrFeatures is wrapped by the error Up returns when the
+// devcontainer's features can't be resolved.
+var ErrFeatures = errors.New("unable to prepare features")
+
+// New returns a Command ready to have its options set.
+//
+// The CLI goes through NewCommand instead; New is for driving the
+// devcontainer pipeline from Go code: set Options, then call Connect,
+// Up, Down, and Close, in that order.
+func New(appName string, appVersion string) *Command {
+	cmd := &Command{
+		appName:              appName,
+		appVersion:           appVersion,
+		featureParsersLookup: make(map[string]*writ.DevcontainerFeatureParser),
+		featurePathLookup:    make(map[string]string),
+		settings:             &Settings{},
+	}
+	// Set up front, as 0 and negative values mean something to trill
+	cmd.Options.DependencySettleTime = trill.DefaultDependencySettleTime
+	cmd.Options.DependencyTimeout = trill.DefaultDependencyTimeout
+	return cmd
+}
+
+// Connect creates the trill client used to talk to Podman/Docker
+// through socketAddr, and checks that the engine is reachable.
+//
+// If socketAddr is empty, the socket of the current engine context is
+// used; if that's the default context, the usual locations are
+// searched for a socket. ErrNoSocketFound is returned if none turns
+// up.
+func (cmd *Command) Connect(socketAddr string) (err error) {
+	if len(socketAddr) == 0 {
+		if socketAddr, err = cmd.contextSocket(cmd.currentContext()); err != nil {
+			return err
+		}
+	}
+	socketAddr = getSocketAddr(socketAddr)
+	if len(socketAddr) == 0 {
+		slog.Error("No socket address / path specified and none can be found")
+		return ErrNoSocketFound
+	}
+
+	var spanRecorder trill.SpanRecorder
+	if cmd.timings != nil || cmd.tracing != nil {
+		spanRecorder = cmd.startSpan
+	}
+	cmd.trillClient, err = trill.NewClient(trill.ClientOptions{
+		SocketAddr: socketAddr,
+		Platform: trill.Platform{
+			Architecture: cmd.Options.PlatformArch,
+			OS:           cmd.Options.PlatformOS,
+		},
+		FeatureImageBuilder:    cmd.BuildImageWithFeatures,
+		PrivilegedPortElevator: cmd.privilegedPortElevator,
+		PortConflictResolver:   cmd.portConflictResolver,
+		Proxy:                  cmd.proxyConfig(),
+		RegistryCredentials:    cmd.settings.registryCredentials(),
+		SpanRecorder:           spanRecorder,
+		Version:                cmd.appVersion,
+	})
+	if err != nil {
+		return err
+	}
+
+	pingCtx, cancelPing := context.WithTimeout(context.Background(), EnginePingTimeout)
+	defer cancelPing()
+	_, endSpan := cmd.startSpan(pingCtx, "connect to engine")
+	err = cmd.trillClient.Ping(pingCtx)
+	endSpan(err)
+	if err != nil {
+		if closeErr := cmd.trillClient.Close(); closeErr != nil {
+			slog.Error("received an error while closing the trill client", "error", closeErr)
+		}
+		cmd.trillClient = nil
+		return err
+	}
+	return nil
+}
+
+// proxyConfig returns the proxy settings to propagate into image
+// builds and containers: the ones in the environment, overridden by
+// --http-proxy, --https-proxy, and --no-proxy. There are none with
+// --ignore-proxy.
+func (cmd *Command) proxyConfig() trill.ProxyConfig {
+	if cmd.Options.IgnoreProxy {
+		return trill.ProxyConfig{}
+	}
+	proxy := trill.ProxyFromEnvironment()
+	if len(cmd.Options.HTTPProxy) > 0 {
+		proxy.HTTPProxy = cmd.Options.HTTPProxy
+	}
+	if len(cmd.Options.HTTPSProxy) > 0 {
+		proxy.HTTPSProxy = cmd.Options.HTTPSProxy
+	}
+	if len(cmd.Options.NoProxy) > 0 {
+		proxy.NoProxy = cmd.Options.NoProxy
+	}
+	if proxy.IsSet() {
+		// The proxies themselves are left out, as they may have
+		// credentials in them
+		slog.Debug("propagating proxy settings", "no-proxy", proxy.NoProxy)
+	}
+	return proxy
+}
+
+// Client returns the trill client created by Connect.
+func (cmd *Command) Client() *trill.Client {
+	return cmd.trillClient
+}
+
+// Up brings the devcontainer described by parser up: it resolves its
+// features, builds or pulls its image(s), creates its container(s),
+// and runs its lifecycle hooks.
+//
+// Unless Options.NoAttach is set, the host terminal is attached to
+// the devcontainer, and Up only returns once it's detached. Either
+// way, the devcontainer is left running; call Down to tear it down,
+// even if Up fails.
+func (cmd *Command) Up(ctx context.Context, parser *writ.DevcontainerParser) error {
+	cmd.applyOptions(parser)
+	if err := cmd.prepareForwarding(parser); err != nil {
+		slog.Error("encountered an error while setting up forwarding", "error", err)
+		return err
+	}
+	if err := cmd.prepareCACertificates(parser); err != nil {
+		slog.Error("encountered an error while reading CA certificates", "error", err)
+		return err
+	}
+	if err := cmd.prepareSSH(parser); err != nil {
+		slog.Error("encountered an error while setting up sshd", "error", err)
+		return err
+	}
+
+	_, endSpan := cmd.startSpan(ctx, "resolve features")
+	err := cmd.ParseFeaturesConfig(ctx, parser, parser.Config.Features)
+	endSpan(err)
+	if err != nil {
+		slog.Error("encountered an error while trying to parsing feature config(s)", "error", err)
+		return fmt.Errorf("%w: %w", ErrFeatures, err)
+	}
+	slog.Info("utilizing resolved features", "featurePathLookup", cmd.featurePathLookup)
+	parser.RegisterCustomizationsHandler(CustomizationsTool, writ.CustomizationsHandlerFunc(cmd.handleBrigCustomizations))
+	if err := parser.ApplyCustomizations(); err != nil {
+		slog.Error("encountered an error while applying customizations", "error", err)
+		return err
+	}
+	cmd.propagateLocalization(parser)
+
+	// The lifecycle handler cancels this once it's done, which
+	// detaches the host terminal
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.Go(func() (err error) {
+		defer trill.RecoverPanic(&err)
+		defer cancel()
+		return cmd.lifecycleHandler(egCtx, eg, parser)
+	})
+	eg.Go(func() (err error) {
+		defer trill.RecoverPanic(&err)
+		imageName := createImageTagBase(parser)
+		containerName := devcontainerContainerName(parser)
+		var imageTag, snapshotTag string
+		if !isComposeDevcontainer(parser) {
+			snapshotTag = cmd.recordedSnapshot(egCtx, containerName)
+		}
+		switch {
+		case len(snapshotTag) > 0:
+			// Recorded with `brig snapshot --use`; it already has
+			// everything the build and the Features would add
+			slog.Info("bringing the devcontainer up from its recorded snapshot", "tag", snapshotTag)
+			cmd.updateResult(func(r *Result) {
+				r.ImageTag = snapshotTag
+				r.ContainerName = containerName
+			})
+			if err = cmd.trillClient.StartDevcontainerContainer(egCtx, parser, snapshotTag, containerName); err != nil {
+				slog.Error("encountered an error while trying to start the devcontainer", "error", err)
+				return err
+			}
+
+		case parser.Config.DockerFile != nil && len(*parser.Config.DockerFile) > 0:
+			imageTag = fmt.Sprintf("%s%s", ImageTagPrefix, imageName)
+			// With Features, the metadata is recorded in the
+			// feature-integrated image instead
+			var metadata trill.ImageMetadata
+			if len(parser.Config.Features) == 0 {
+				entry, err := trill.DevcontainerMetadata(&parser.Config)
+				if err != nil {
+					return err
+				}
+				metadata = trill.ImageMetadata{entry}
+			}
+			if err = cmd.trillClient.BuildDevcontainerImage(egCtx, parser, imageTag, cmd.imageOptions(cmd.Options.SkipBuild), metadata); err != nil {
+				slog.Error("encountered an error while trying to build an image based on devcontainer.json", "error", err)
+				return err
+			}
+			if len(parser.Config.Features) > 0 {
+				// Use the .devcontainer directory as the context path
+				contextPath := filepath.Dir(parser.Filepath)
+				// Tag the feature-integrated image separately so the
+				// base image's build hash stays intact
+				featuresImageTag := fmt.Sprintf("%s--features", imageTag)
+				if err = cmd.BuildImageWithFeatures(egCtx, contextPath, imageTag, featuresImageTag); err != nil {
+					slog.Error("encountered an error while trying to build a feature-integrated image", "error", err)
+					return err
+				}
+				imageTag = featuresImageTag
+			}
+			if err = cmd.pushBuildCache(egCtx, imageTag); err != nil {
+				slog.Error("encountered an error while trying to push the build cache", "error", err)
+				return err
+			}
+			cmd.updateResult(func(r *Result) {
+				r.ImageTag = imageTag
+				r.ContainerName = containerName
+			})
+			if err = cmd.trillClient.StartDevcontainerContainer(egCtx, parser, imageTag, containerName); err != nil {
+				slog.Error("encountered an error while trying to start the devcontainer", "error", err)
+				return err
+			}
+
+		case isComposeDevcontainer(parser):
+			slog.Warn("SUPPORT FOR COMPOSER PROJECTS IS INCOMPLETE")
+			projName := composeProjectName(parser)
+			// Validated in parseOptions
+			scale, _ := trill.ParseScale(cmd.Options.Scale)
+			cmd.updateResult(func(r *Result) {
+				r.ComposeProjectName = projName
+			})
+			if err = cmd.trillClient.DeployComposerProject(egCtx, parser, trill.ComposeOptions{
+				ProjectName:          projName,
+				FollowServiceOutput:  cmd.Options.FollowServiceLogs,
+				ImageTagPrefix:       ImageTagPrefix,
+				RecreateNetworks:     cmd.Options.RecreateNetworks,
+				Scale:                scale,
+				ServiceOutput:        color.Output,
+				SkipBuildIfAvailable: cmd.Options.SkipBuild,
+				SkipPullIfAvailable:  cmd.Options.SkipPull,
+				SuppressOutput:       cmd.SuppressOutput,
+			}); err != nil {
+				slog.Error("encountered an error while trying to build a Compose project", "error", err)
+			}
+
+		case parser.Config.Image != nil && len(*parser.Config.Image) > 0:
+			imageTag = *parser.Config.Image
+			if err = cmd.trillClient.PullContainerImage(egCtx, imageTag, cmd.imageOptions(cmd.Options.SkipPull)); err != nil {
+				slog.Error("encountered an error while trying to pull an image based on devcontainer.json", "error", err)
+				return err
+			}
+			if len(parser.Config.Features) > 0 {
+				// Use the .devcontainer directory as the context path
+				contextPath := filepath.Dir(parser.Filepath)
+				if err = cmd.BuildImageWithFeatures(egCtx, contextPath, imageTag, imageName); err != nil {
+					slog.Error("encountered an error while trying to build a feature-integrated image", "error", err)
+					return err
+				}
+				imageTag = imageName
+				if err = cmd.pushBuildCache(egCtx, imageTag); err != nil {
+					slog.Error("encountered an error while trying to push the build cache", "error", err)
+					return err
+				}
+			}
+
+			cmd.updateResult(func(r *Result) {
+				r.ImageTag = imageTag
+				r.ContainerName = containerName
+			})
+			if err = cmd.trillClient.StartDevcontainerContainer(egCtx, parser, imageTag, containerName); err != nil {
+				slog.Error("encountered an error while trying to start the devcontainer", "error", err)
+			}
+
+		default:
+			return fmt.Errorf("devcontainer.json specifies an unsupported mode of operation; exiting")
+		}
+		return err
+	})
+
+	return eg.Wait()
+}
+
+// Down tears down what Up brought up: the devcontainer, or the
+// Compose project it's a part of.
+//
+// Cleanup goes through even if ctx has been cancelled, but is bounded
+// by TeardownTimeout, except for syncing a cloned workspace back to
+// the host (see Options.SyncBack), which can take a while.
+func (cmd *Command) Down(ctx context.Context, parser *writ.DevcontainerParser) (err error) {
+	if cmd.trillClient == nil {
+		return nil
+	}
+
+	teardownCtx, cancelTeardown := context.WithTimeout(context.WithoutCancel(ctx), TeardownTimeout)
+	defer cancelTeardown()
+	if parser.Config.DockerComposeFile != nil {
+		return cmd.trillClient.TeardownComposerProject(teardownCtx)
+	}
+
+	if sshErr := cmd.removeSSHConfigEntry(); sshErr != nil {
+		slog.Error("encountered an error while removing the devcontainer from the ssh_config file", "error", sshErr)
+		err = sshErr
+	}
+	if len(cmd.trillClient.ContainerID) > 0 {
+		err = errors.Join(err, cmd.trillClient.StopDevcontainer(teardownCtx))
+	}
+	if cmd.Options.SyncBack {
+		if syncErr := cmd.trillClient.SyncWorkspaceVolume(context.WithoutCancel(ctx), parser.WorkspaceFolder()); syncErr != nil {
+			slog.Error("encountered an error while trying to sync the workspace volume back to the host", "error", syncErr)
+			err = errors.Join(err, syncErr)
+		}
+	}
+	return err
+}
+
+// Close releases what the command holds on to: the connection to
+// Podman/Docker, and the feature artifact digests, which are saved
+// to the cache directory. The Feature cache is trimmed down as well;
+// see gcFeatureCache.
+func (cmd *Command) Close() (err error) {
+	err = cmd.SaveArtifactDigest()
+	if gcErr := cmd.collectFeatureCacheGarbage(); gcErr != nil {
+		slog.Error("encountered an error while trimming the feature cache", "error", gcErr)
+		err = errors.Join(err, gcErr)
+	}
+	if cmd.gitCredentialServer != nil {
+		if closeErr := cmd.gitCredentialServer.Close(); closeErr != nil {
+			slog.Error("received an error while stopping the git credential server", "error", closeErr)
+			err = errors.Join(err, closeErr)
+		}
+	}
+	if cmd.trillClient != nil {
+		if closeErr := cmd.trillClient.Close(); closeErr != nil {
+			slog.Error("received an error while closing the trill client", "error", closeErr)
+			err = errors.Join(err, closeErr)
+		}
+	}
+	if cmd.workspaceLock != nil {
+		if unlockErr := releaseWorkspaceLock(cmd.workspaceLock); unlockErr != nil {
+			slog.Error("received an error while releasing the workspace lock", "error", unlockErr)
+			err = errors.Join(err, unlockErr)
+		}
+		cmd.workspaceLock = nil
+	}
+	if cmd.tracing != nil {
+		if shutDownErr := cmd.tracing.shutDown(); shutDownErr != nil {
+			slog.Error("received an error while exporting spans", "error", shutDownErr)
+			err = errors.Join(err, shutDownErr)
+		}
+	}
+	return err
+}
+
+// Summary returns a copy of what has been recorded about the run so
+// far; see Result.
+func (cmd *Command) Summary() Result {
+	cmd.resultMu.Lock()
+	defer cmd.resultMu.Unlock()
+	return cmd.result
+}
+
+// applyOptions reconciles the options with what parser describes,
+// then passes them on to the trill client.
+func (cmd *Command) applyOptions(parser *writ.DevcontainerParser) {
+	if cmd.Options.IgnoreUpdateRemoteUserUID {
+		*parser.Config.UpdateRemoteUserUID = false
+	}
+	if cmd.Options.CloneInVolume && parser.Config.DockerComposeFile != nil {
+		slog.Warn("--clone-in-volume is not supported for Compose projects; ignoring")
+		cmd.Options.CloneInVolume = false
+	}
+	if cmd.Options.SyncBack && !cmd.Options.CloneInVolume {
+		slog.Warn("--sync-back has no effect without --clone-in-volume; ignoring")
+		cmd.Options.SyncBack = false
+	}
+	if cmd.Options.SyncBack && cmd.Options.Detach {
+		slog.Warn("--sync-back has no effect with --detach, as the devcontainer is left running; ignoring")
+		cmd.Options.SyncBack = false
+	}
+
+	if len(cmd.Options.CacheTo) > 0 && (parser.Config.DockerComposeFile != nil || (parser.Config.DockerFile == nil && len(parser.Config.Features) == 0)) {
+		slog.Warn("--cache-to only has an effect on devcontainers whose image is built, outside of Compose projects; ignoring")
+		cmd.Options.CacheTo = ""
+	}
+
+	// Validated in parseOptions
+	pullPolicy, _ := trill.ParsePullPolicy(cmd.Options.Pull)
+	if cmd.Options.SkipPull {
+		if pullPolicy == trill.PullPolicyDefault {
+			pullPolicy = trill.PullPolicyMissing
+		} else {
+			slog.Warn("--skip-pull has no effect with --pull; ignoring", "policy", pullPolicy)
+			cmd.Options.SkipPull = false
+		}
+	}
+
+	cmd.trillClient.BuildProgress = trill.BuildProgress(cmd.Options.Progress)
+	cmd.trillClient.CacheFrom = cacheSpecs(cmd.Options.CacheFrom)
+	cmd.trillClient.CloneWorkspaceInVolume = cmd.Options.CloneInVolume
+	cmd.trillClient.CommandStdout = cmd.stdout()
+	cmd.trillClient.CommandStderr = cmd.stderr()
+	if detachKeys, err := trill.ParseDetachKeys(cmd.Options.DetachKeys); err == nil {
+		cmd.trillClient.DetachKeys = detachKeys
+	}
+	cmd.trillClient.ExportBuildCache = len(cmd.Options.CacheTo) > 0
+	cmd.trillClient.ForceRebuild = cmd.Options.Rebuild
+	cmd.trillClient.Headless = cmd.Options.NoAttach
+	cmd.trillClient.KeepOnFailure = cmd.Options.KeepOnFailure
+	cmd.trillClient.KeepSynthesizedContainerfiles = cmd.Options.KeepContainerfiles
+	cmd.trillClient.NestedIgnoreFiles = cmd.Options.NestedIgnoreFiles
+	cmd.trillClient.PullPolicy = pullPolicy
+	// Validated in parseOptions
+	cmd.trillClient.UsernsMode, _ = trill.ParseUsernsMode(cmd.Options.Userns)
+	cmd.trillClient.WorkspaceRelabel, _ = trill.ParseWorkspaceRelabel(cmd.Options.SELinuxRelabel)
+	if cmd.Options.DependencyPollInterval > 0 {
+		cmd.trillClient.DependencyPollInterval = cmd.Options.DependencyPollInterval
+	}
+	cmd.trillClient.DependencySettleTime = cmd.Options.DependencySettleTime
+	cmd.trillClient.DependencyTimeout = cmd.Options.DependencyTimeout
+	if cmd.Options.HealthTimeout != 0 {
+		cmd.trillClient.HealthTimeout = cmd.Options.HealthTimeout
+	}
+}
+
+// cacheSpecs reassembles the values given with --cache-from, which
+// are split at commas, into the items they were given as, so registry
+// cache specifications (e.g., type=registry,ref=IMAGE) stay whole.
+func cacheSpecs(values []string) (specs []string) {
+	for _, val := range values {
+		val = strings.TrimSpace(val)
+		switch {
+		case len(val) == 0:
+		case len(specs) > 0 && strings.Contains(val, "=") && !strings.HasPrefix(val, "type=") && strings.Contains(specs[len(specs)-1], "="):
+			specs[len(specs)-1] += "," + val
+		default:
+			specs = append(specs, val)
+		}
+	}
+	return specs
+}
+
+// pushBuildCache pushes imageTag to where --cache-to points, if it
+// was given.
+func (cmd *Command) pushBuildCache(ctx context.Context, imageTag string) error {
+	if len(cmd.Options.CacheTo) == 0 {
+		return nil
+	}
+	ref, ok := trill.CacheImageRef(cmd.Options.CacheTo)
+	if !ok {
+		return fmt.Errorf("unsupported --cache-to value: %s", cmd.Options.CacheTo)
+	}
+	return cmd.trillClient.PushContainerImage(ctx, imageTag, ref, cmd.imageOptions(false))
+}
+
+// stdout returns where output meant for stdout goes; see
+// Command.Stdout.
+func (cmd *Command) stdout() io.Writer {
+	if cmd.Stdout != nil {
+		return cmd.Stdout
+	}
+	return os.Stdout
+}
+
+// stderr returns where output meant for stderr goes; see
+// Command.Stderr.
+func (cmd *Command) stderr() io.Writer {
+	if cmd.Stderr != nil {
+		return cmd.Stderr
+	}
+	return os.Stderr
+}
@@ -0,0 +1,124 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/nlsantos/brig/writ"
+)
+
+// ReadConfiguration is what `brig read-configuration` prints out.
+type ReadConfiguration struct {
+	ConfigFile            string                                     `json:"configFile"`            // Path to the devcontainer.json
+	Configuration         json.RawMessage                            `json:"configuration"`         // devcontainer.json as parsed: with --override-config merged in, defaults filled in, and local variables substituted
+	FeaturesConfiguration map[string]*writ.DevcontainerFeatureConfig `json:"featuresConfiguration"` // The devcontainer's Features, with their options set, keyed by how they're referenced
+	MergedConfiguration   *writ.DevcontainerConfig                   `json:"mergedConfiguration"`   // Configuration, with the Features' contributions and the mounts in the settings merged in
+	Customizations        map[string][]json.RawMessage               `json:"customizations"`        // Every contribution to each tool's customizations, in order of increasing precedence
+}
+
+// runReadConfiguration implements `brig read-configuration [PATH]`,
+// which prints out the configuration brig would bring the
+// devcontainer up with, as JSON, without bringing it up.
+//
+// Features are resolved (and downloaded, if necessary) along the way.
+// Options that only take effect once the devcontainer is being
+// created (e.g., --ssh) aren't reflected.
+func (cmd *Command) runReadConfiguration(args []string) ExitCode {
+	if len(args) > 1 {
+		fmt.Fprintf(cmd.stderr(), "usage: %s read-configuration [PATH]\n", cmd.appName)
+		return ExitErrorParsingFlags
+	}
+
+	parser, err := cmd.loadDevcontainerJSON(args)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to parse devcontainer.json: %s\n", err)
+		return ExitNonValidDevcontainerJSON
+	}
+
+	result := ReadConfiguration{
+		ConfigFile:            parser.Filepath,
+		FeaturesConfiguration: make(map[string]*writ.DevcontainerFeatureConfig),
+		Customizations:        make(map[string][]json.RawMessage),
+	}
+	if result.Configuration, err = json.Marshal(parser.Config); err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to encode the configuration: %s\n", err)
+		return ExitError
+	}
+
+	if err = cmd.addSettingsMounts(parser); err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to apply the mounts in the settings: %s\n", err)
+		return ExitUnsupportedConfiguration
+	}
+	ctx := context.Background()
+	if err = cmd.ParseFeaturesConfig(ctx, parser, parser.Config.Features); err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to prepare features: %s\n", err)
+		return ExitFeaturesFailed
+	}
+	for featureID, featureParser := range cmd.featureParsersLookup {
+		result.FeaturesConfiguration[featureID] = &featureParser.Config
+	}
+	result.MergedConfiguration = &parser.Config
+
+	tools := slices.Collect(maps.Keys(parser.Config.Customizations))
+	for _, featureCustomizations := range parser.FeatureCustomizations {
+		tools = slices.AppendSeq(tools, maps.Keys(featureCustomizations))
+	}
+	for _, tool := range tools {
+		if result.Customizations[tool], err = parser.Customizations(tool); err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to encode the customizations: %s\n", err)
+			return ExitError
+		}
+	}
+
+	encoder := json.NewEncoder(cmd.stdout())
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(result); err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to write out the configuration: %s\n", err)
+		return ExitError
+	}
+	return ExitNormal
+}
+
+// loadDevcontainerJSON finds the devcontainer.json the [PATH]
+// argument a subcommand takes points to, then applies
+// --override-config to it, and validates and parses it, as brig would
+// before bringing the devcontainer up.
+func (cmd *Command) loadDevcontainerJSON(args []string) (*writ.DevcontainerParser, error) {
+	configPath := cmd.findDevcontainerJSON(args)
+	parser, err := writ.NewDevcontainerParser(configPath)
+	if err != nil {
+		return nil, err
+	}
+	parser.LocalWorkspaceFolder = workspaceForDevcontainerJSON(args, configPath)
+	if len(cmd.Options.OverrideConfig) > 0 {
+		if err = parser.ApplyOverlay(cmd.Options.OverrideConfig); err != nil {
+			return nil, err
+		}
+	}
+	if err = parser.Validate(); err != nil {
+		return nil, err
+	}
+	if err = parser.Parse(); err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
@@ -0,0 +1,63 @@
+package brig
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRunReadConfiguration checks that the configuration is printed
+// out both as parsed and with its Features' contributions merged in.
+func TestRunReadConfiguration(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Setenv("BRIG_TEST_GREETING", "hello")
+	dir := t.TempDir()
+	devcontainerDir := filepath.Join(dir, ".devcontainer")
+	assert.Nil(t, os.MkdirAll(filepath.Join(devcontainerDir, "feat"), 0o755))
+	assert.Nil(t, os.WriteFile(filepath.Join(devcontainerDir, "devcontainer.json"), []byte(`{
+		"image": "alpine",
+		"features": {"./feat": {"greeting": "hi"}},
+		"postCreateCommand": ["echo", "${localEnv:BRIG_TEST_GREETING}"],
+		"customizations": {"brig": {"shell": "/bin/zsh"}}
+	}`), 0o644))
+	assert.Nil(t, os.WriteFile(filepath.Join(devcontainerDir, "feat", "devcontainer-feature.json"), []byte(`{
+		"id": "feat",
+		"version": "1.0.0",
+		"init": true,
+		"options": {"greeting": {"type": "string", "default": "hello"}},
+		"customizations": {"brig": {"shell": "/bin/bash"}}
+	}`), 0o644))
+
+	var stdout bytes.Buffer
+	cmd := New("brig", "")
+	cmd.Stdout = &stdout
+	cmd.Arguments = []string{"read-configuration", filepath.Join(devcontainerDir, "devcontainer.json")}
+	exitCode, ok := cmd.runSubcommand()
+	assert.True(t, ok)
+	assert.Equal(t, ExitNormal, exitCode)
+
+	var result struct {
+		ConfigFile            string                      `json:"configFile"`
+		Configuration         map[string]any              `json:"configuration"`
+		FeaturesConfiguration map[string]map[string]any   `json:"featuresConfiguration"`
+		MergedConfiguration   map[string]any              `json:"mergedConfiguration"`
+		Customizations        map[string][]map[string]any `json:"customizations"`
+	}
+	assert.Nil(t, json.Unmarshal(stdout.Bytes(), &result))
+	assert.Equal(t, filepath.Join(devcontainerDir, "devcontainer.json"), result.ConfigFile)
+	assert.Equal(t, []any{"echo", "hello"}, result.Configuration["postCreateCommand"])
+	assert.Equal(t, false, result.Configuration["init"])
+	assert.Equal(t, "/workspace", result.Configuration["workspaceFolder"])
+	assert.Equal(t, true, result.MergedConfiguration["init"])
+	assert.Contains(t, result.FeaturesConfiguration, "./feat")
+	assert.Equal(t, "feat", result.FeaturesConfiguration["./feat"]["id"])
+	assert.Equal(t, []map[string]any{{"shell": "/bin/bash"}, {"shell": "/bin/zsh"}}, result.Customizations["brig"])
+}
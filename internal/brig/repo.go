@@ -0,0 +1,168 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+)
+
+// invalidWorkspaceNamePattern matches characters that shouldn't go
+// into the name of a workspace directory.
+var invalidWorkspaceNamePattern = regexp.MustCompile("[^a-zA-Z0-9._-]")
+
+// cloneRepository clones the repository named by --repo into a
+// managed workspace (see repoWorkspaceDir), and checks out
+// --repo-ref, if set. Returns the path to the workspace.
+//
+// If the workspace already exists, it's fetched into instead, and
+// left as it is unless --repo-ref is set; changes made in it are kept
+// either way.
+func (cmd *Command) cloneRepository(ctx context.Context) (string, error) {
+	workspaceDir, err := cmd.repoWorkspaceDir()
+	if err != nil {
+		return "", err
+	}
+
+	var progress io.Writer
+	if !cmd.SuppressOutput {
+		progress = cmd.stderr()
+	}
+
+	repo, err := git.PlainOpen(workspaceDir)
+	switch {
+	case err == nil:
+		slog.Info("fetching into the existing clone of the repository", "repo", cmd.Options.Repo, "path", workspaceDir)
+		err = repo.FetchContext(ctx, &git.FetchOptions{Progress: progress, Tags: plumbing.AllTags})
+		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return "", fmt.Errorf("unable to fetch %s: %w", cmd.Options.Repo, err)
+		}
+
+	case errors.Is(err, git.ErrRepositoryNotExists):
+		slog.Info("cloning the repository", "repo", cmd.Options.Repo, "path", workspaceDir)
+		if err = os.MkdirAll(filepath.Dir(workspaceDir), 0o755); err != nil {
+			return "", err
+		}
+		repo, err = git.PlainCloneContext(ctx, workspaceDir, &git.CloneOptions{
+			URL:      cmd.Options.Repo,
+			Progress: progress,
+		})
+		if err != nil {
+			// Don't leave a half-cloned workspace behind, which
+			// would be mistaken for a clone on the next run
+			if removeErr := os.RemoveAll(workspaceDir); removeErr != nil {
+				slog.Error("could not remove partial clone", "path", workspaceDir, "error", removeErr)
+			}
+			return "", fmt.Errorf("unable to clone %s: %w", cmd.Options.Repo, err)
+		}
+
+	default:
+		return "", err
+	}
+
+	if len(cmd.Options.RepoRef) > 0 {
+		if err = checkoutRef(repo, cmd.Options.RepoRef); err != nil {
+			return "", fmt.Errorf("unable to check out %s: %w", cmd.Options.RepoRef, err)
+		}
+	}
+	return workspaceDir, nil
+}
+
+// checkoutRef checks out ref, which can be a branch, a tag, or a
+// commit, in repo.
+//
+// Branches are checked out as local branches that track the remote
+// ones, creating them as needed; everything else leaves HEAD
+// detached.
+func checkoutRef(repo *git.Repository, ref string) error {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	localBranch := plumbing.NewBranchReferenceName(ref)
+	if _, err = repo.Reference(localBranch, false); err == nil {
+		return worktree.Checkout(&git.CheckoutOptions{Branch: localBranch})
+	}
+	if remoteBranch, err := repo.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, ref), true); err == nil {
+		return worktree.Checkout(&git.CheckoutOptions{
+			Branch: localBranch,
+			Create: true,
+			Hash:   remoteBranch.Hash(),
+		})
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return err
+	}
+	return worktree.Checkout(&git.CheckoutOptions{Hash: *hash})
+}
+
+// repoWorkspaceDir returns where the repository named by --repo is
+// cloned into: --repo-dir, if set; otherwise, a directory named after
+// the repository in the workspaces directory (see
+// workspacesDirectory).
+//
+// The directory's name carries a hash of the repository's URL, so
+// repositories that share a name don't clash.
+func (cmd *Command) repoWorkspaceDir() (string, error) {
+	if len(cmd.Options.RepoDir) > 0 {
+		return filepath.Abs(cmd.Options.RepoDir)
+	}
+
+	workspacesDir, err := cmd.workspacesDirectory()
+	if err != nil {
+		return "", err
+	}
+	repoName := strings.TrimSuffix(filepath.Base(strings.TrimRight(cmd.Options.Repo, "/")), ".git")
+	repoName = invalidWorkspaceNamePattern.ReplaceAllString(repoName, "-")
+	urlHash := sha256.Sum256([]byte(cmd.Options.Repo))
+	return filepath.Join(workspacesDir, fmt.Sprintf("%s-%s", repoName, hex.EncodeToString(urlHash[:4]))), nil
+}
+
+// workspacesDirectory returns the directory repositories cloned with
+// --repo are kept in.
+//
+// It's kept out of the cache directory, as the workspaces hold work
+// that shouldn't be cleaned up along with the cache.
+func (cmd *Command) workspacesDirectory() (string, error) {
+	if dataHome := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dataHome) {
+		return filepath.Join(dataHome, cmd.appName, "workspaces"), nil
+	}
+	if localAppData := os.Getenv("LOCALAPPDATA"); runtime.GOOS == "windows" && filepath.IsAbs(localAppData) {
+		return filepath.Join(localAppData, cmd.appName, "workspaces"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", cmd.appName, "workspaces"), nil
+}
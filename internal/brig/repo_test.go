@@ -0,0 +1,111 @@
+package brig
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/stretchr/testify/assert"
+)
+
+// commitFile writes contents to name in worktree and commits it.
+func commitFile(t *testing.T, worktree *git.Worktree, name string, contents string) plumbing.Hash {
+	path := filepath.Join(worktree.Filesystem.Root(), name)
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	assert.Nil(t, os.WriteFile(path, []byte(contents), 0o644))
+	_, err := worktree.Add(name)
+	assert.Nil(t, err)
+	hash, err := worktree.Commit("update "+name, &git.CommitOptions{
+		Author: &object.Signature{Name: "brig", Email: "brig@example.com", When: time.Now()},
+	})
+	assert.Nil(t, err)
+	return hash
+}
+
+// TestCloneRepository checks that --repo clones the repository into
+// --repo-dir, that --repo-ref checks out branches, tags, and commits,
+// and that an existing clone is fetched into instead of cloned over.
+func TestCloneRepository(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	upstreamDir := t.TempDir()
+	upstream, err := git.PlainInit(upstreamDir, false)
+	assert.Nil(t, err)
+	worktree, err := upstream.Worktree()
+	assert.Nil(t, err)
+	first := commitFile(t, worktree, ".devcontainer/devcontainer.json", `{"image": "alpine:latest"}`)
+	_, err = upstream.CreateTag("v1", first, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("feature"), Create: true}))
+	second := commitFile(t, worktree, "feature.txt", "feature\n")
+
+	cmd := &Command{SuppressOutput: true}
+	cmd.Options.Repo = upstreamDir
+	cmd.Options.RepoDir = filepath.Join(t.TempDir(), "workspace")
+
+	workspaceDir, err := cmd.cloneRepository(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, cmd.Options.RepoDir, workspaceDir)
+	assert.FileExists(t, filepath.Join(workspaceDir, ".devcontainer", "devcontainer.json"))
+
+	clone, err := git.PlainOpen(workspaceDir)
+	assert.Nil(t, err)
+	headHash := func() plumbing.Hash {
+		head, err := clone.Head()
+		assert.Nil(t, err)
+		return head.Hash()
+	}
+
+	cmd.Options.RepoRef = "v1"
+	_, err = cmd.cloneRepository(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, first, headHash())
+	assert.NoFileExists(t, filepath.Join(workspaceDir, "feature.txt"))
+
+	cmd.Options.RepoRef = "feature"
+	_, err = cmd.cloneRepository(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, second, headHash())
+	assert.FileExists(t, filepath.Join(workspaceDir, "feature.txt"))
+
+	cmd.Options.RepoRef = first.String()
+	_, err = cmd.cloneRepository(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, first, headHash())
+
+	cmd.Options.RepoRef = "does-not-exist"
+	_, err = cmd.cloneRepository(context.Background())
+	assert.NotNil(t, err)
+}
+
+// TestRepoWorkspaceDir checks that repositories are cloned into
+// directories named after them, without clashing with ones that share
+// a name.
+func TestRepoWorkspaceDir(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dataHome := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dataHome)
+
+	cmd := &Command{appName: "brig"}
+	cmd.Options.Repo = "https://github.com/nlsantos/brig.git"
+	first, err := cmd.repoWorkspaceDir()
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dataHome, "brig", "workspaces"), filepath.Dir(first))
+	assert.Regexp(t, `^brig-[0-9a-f]{8}$`, filepath.Base(first))
+
+	cmd.Options.Repo = "https://example.com/someone-else/brig/"
+	second, err := cmd.repoWorkspaceDir()
+	assert.Nil(t, err)
+	assert.Regexp(t, `^brig-[0-9a-f]{8}$`, filepath.Base(second))
+	assert.NotEqual(t, first, second)
+}
@@ -0,0 +1,399 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"dario.cat/mergo"
+	"github.com/BurntSushi/toml"
+	"github.com/docker/go-units"
+	"github.com/moby/moby/api/types/registry"
+	"github.com/nlsantos/brig/trill"
+	"github.com/nlsantos/brig/writ"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrSettings is wrapped by errors encountered while loading settings
+// files.
+var ErrSettings = errors.New("unable to load settings")
+
+// SettingsFileExtensions are the extensions settings files can have;
+// the format a file is parsed as is determined by its extension.
+var SettingsFileExtensions = []string{".toml", ".yaml", ".yml"}
+
+// Settings holds brig's structured configuration.
+//
+// Unlike the rc file, which only holds defaults for command-line
+// flags, settings are layered: a global settings file is read first,
+// then a per-project one (.brig.yaml, .brig.yml, or .brig.toml, in
+// the workspace or one of its parents), then environment
+// variables (e.g., BRIG_PORT_OFFSET). Later layers override earlier
+// ones, except for mounts, which accumulate.
+//
+// Command-line flags and the rc file take precedence over all of
+// them.
+type Settings struct {
+	BrowserCommand string                      `toml:"browser-command" yaml:"browser-command"` // Run with a URL for ports whose onAutoForward is openBrowser
+	CacheDir       string                      `toml:"cache-dir" yaml:"cache-dir"`             // Where Features and other downloads are cached
+	CacheMaxSize   string                      `toml:"cache-max-size" yaml:"cache-max-size"`   // Size the Feature cache is trimmed down to (e.g., 512m); 0 disables trimming
+	Context        string                      `toml:"context" yaml:"context"`                 // The engine context used when neither --socket nor --context is given
+	Contexts       map[string]ContextSettings  `toml:"contexts" yaml:"contexts"`               // Context name -> engine endpoint
+	Mounts         []string                    `toml:"mounts" yaml:"mounts"`                   // Mounts added to every devcontainer, in --mount or --volume format
+	Platform       PlatformSettings            `toml:"platform" yaml:"platform"`
+	PortOffset     uint16                      `toml:"port-offset" yaml:"port-offset"`
+	Registries     map[string]RegistrySettings `toml:"registries" yaml:"registries"` // Registry host -> credentials
+	Socket         string                      `toml:"socket" yaml:"socket"`
+
+	files []string // The settings files that were read, in the order they were applied
+}
+
+// PlatformSettings holds the default target platform for containers.
+type PlatformSettings struct {
+	Arch string `toml:"arch" yaml:"arch"`
+	OS   string `toml:"os" yaml:"os"`
+}
+
+// ContextSettings holds a named engine endpoint, which can be
+// selected with --context or `brig context use`.
+//
+// The socket may reference environment variables (e.g.,
+// unix://${XDG_RUNTIME_DIR}/podman/podman.sock).
+type ContextSettings struct {
+	Socket      string `toml:"socket" yaml:"socket"`
+	Description string `toml:"description" yaml:"description"`
+}
+
+// RegistrySettings holds the credentials for a container registry.
+//
+// Values may reference environment variables (e.g., ${GHCR_TOKEN}),
+// so secrets don't have to be stored in the file itself.
+type RegistrySettings struct {
+	Username      string `toml:"username" yaml:"username"`
+	Password      string `toml:"password" yaml:"password"`
+	IdentityToken string `toml:"identity-token" yaml:"identity-token"`
+}
+
+// loadSettings reads and layers the global settings file (or the one
+// at globalPath, if set), the per-project settings file nearest to
+// projectDir (or the working directory, if it's empty), and
+// environment variables.
+func (cmd *Command) loadSettings(globalPath string, projectDir string) (*Settings, error) {
+	settings := &Settings{}
+
+	if len(globalPath) == 0 {
+		globalPath = cmd.findGlobalSettingsFile()
+	}
+	var layerPaths []string
+	if len(globalPath) > 0 {
+		layerPaths = append(layerPaths, globalPath)
+	}
+	if len(projectDir) == 0 {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrSettings, err)
+		}
+		projectDir = cwd
+	}
+	if projectPath := cmd.findProjectSettingsFile(projectDir); len(projectPath) > 0 {
+		layerPaths = append(layerPaths, projectPath)
+	}
+
+	for _, layerPath := range layerPaths {
+		layer, err := readSettingsFile(layerPath)
+		if err != nil {
+			return nil, err
+		}
+		if err = settings.merge(layer); err != nil {
+			return nil, fmt.Errorf("%w from %s: %w", ErrSettings, layerPath, err)
+		}
+		settings.files = append(settings.files, layerPath)
+	}
+
+	envLayer, err := cmd.settingsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if err = settings.merge(envLayer); err != nil {
+		return nil, fmt.Errorf("%w from the environment: %w", ErrSettings, err)
+	}
+
+	slog.Debug("settings loaded", "files", settings.files)
+	return settings, nil
+}
+
+// applySettings fills in the options that weren't set via the
+// command line or the rc file with their values in the settings.
+func (cmd *Command) applySettings() {
+	if len(cmd.Options.PlatformArch) == 0 {
+		cmd.Options.PlatformArch = cmd.settings.Platform.Arch
+	}
+	if len(cmd.Options.PlatformOS) == 0 {
+		cmd.Options.PlatformOS = cmd.settings.Platform.OS
+	}
+	if cmd.Options.PortOffset == 0 {
+		cmd.Options.PortOffset = cmd.settings.PortOffset
+	}
+	if len(cmd.Options.Socket) == 0 {
+		cmd.Options.Socket = cmd.settings.Socket
+	}
+}
+
+// addSettingsMounts adds the mounts in the settings to the ones the
+// devcontainer.json p was created for asks for.
+func (cmd *Command) addSettingsMounts(p *writ.DevcontainerParser) error {
+	mounts, err := cmd.settings.parsedMounts()
+	if err != nil {
+		return err
+	}
+	p.Config.Mounts = append(p.Config.Mounts, mounts...)
+	return nil
+}
+
+// findGlobalSettingsFile returns the path to the first global
+// settings file that exists, or an empty string if there's none.
+func (cmd *Command) findGlobalSettingsFile() string {
+	dirPatterns := []string{
+		"${XDG_CONFIG_HOME}/%s",
+		"${HOME}/.config/%s",
+		"${APPDATA}/%s",
+		"${LOCALAPPDATA}/%s",
+	}
+	for _, dirPattern := range dirPatterns {
+		dir := os.ExpandEnv(fmt.Sprintf(dirPattern, cmd.appName))
+		// Guard against unset variables turning this into a path off
+		// the root
+		if !filepath.IsAbs(dir) || dir == fmt.Sprintf("/%s", cmd.appName) {
+			continue
+		}
+		if settingsPath := findSettingsFile(dir, "config"); len(settingsPath) > 0 {
+			return settingsPath
+		}
+	}
+	return ""
+}
+
+// projectSettingsDir returns the directory the per-project settings
+// file is looked for from: the workspace of the devcontainer.json brig
+// is pointed at, i.e., the first directory among args (see
+// workspaceForDevcontainerJSON).
+//
+// Returns an empty string if there's no such directory, in which case
+// the workspace is the working directory.
+func projectSettingsDir(args []string) string {
+	for _, arg := range args {
+		if info, err := os.Stat(arg); err != nil || !info.IsDir() {
+			continue
+		}
+		if dir, err := filepath.Abs(arg); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+// findProjectSettingsFile looks for a per-project settings file in
+// dir, then in each of its parents, returning the path to the first
+// one found, or an empty string if there's none.
+func (cmd *Command) findProjectSettingsFile(dir string) string {
+	for {
+		if settingsPath := findSettingsFile(dir, fmt.Sprintf(".%s", cmd.appName)); len(settingsPath) > 0 {
+			return settingsPath
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// findSettingsFile returns the path to the file in dir named
+// basename plus one of SettingsFileExtensions, or an empty string if
+// there's none.
+func findSettingsFile(dir string, basename string) string {
+	for _, ext := range SettingsFileExtensions {
+		settingsPath := filepath.Join(dir, basename+ext)
+		if info, err := os.Stat(settingsPath); err == nil && info.Mode().IsRegular() {
+			return settingsPath
+		}
+	}
+	return ""
+}
+
+// readSettingsFile parses the settings file at settingsPath as TOML
+// or YAML, depending on its extension.
+//
+// Unknown keys are rejected, to catch typos.
+func readSettingsFile(settingsPath string) (*Settings, error) {
+	slog.Debug("reading settings file", "path", settingsPath)
+	contents, err := os.ReadFile(settingsPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w from %s: %w", ErrSettings, settingsPath, err)
+	}
+
+	settings := &Settings{}
+	switch strings.ToLower(filepath.Ext(settingsPath)) {
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(contents), settings)
+		if err == nil {
+			if undecoded := md.Undecoded(); len(undecoded) > 0 {
+				err = fmt.Errorf("unknown key %q", undecoded[0].String())
+			}
+		}
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(strings.NewReader(string(contents)))
+		decoder.KnownFields(true)
+		// An empty file is fine
+		if err = decoder.Decode(settings); errors.Is(err, io.EOF) {
+			err = nil
+		}
+	default:
+		err = fmt.Errorf("unsupported file type; expected one of %s", strings.Join(SettingsFileExtensions, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w from %s: %w", ErrSettings, settingsPath, err)
+	}
+	return settings, nil
+}
+
+// settingsFromEnv returns the settings set via environment variables,
+// which are named after the settings keys, prefixed with the app name
+// (e.g., BRIG_PLATFORM_ARCH).
+//
+// Mounts are separated by semicolons, as they can contain commas.
+func (cmd *Command) settingsFromEnv() (*Settings, error) {
+	prefix := strings.ToUpper(cmd.appName) + "_"
+	lookup := func(name string) (string, bool) {
+		val, ok := os.LookupEnv(prefix + name)
+		return val, ok && len(val) > 0
+	}
+
+	settings := &Settings{}
+	if val, ok := lookup("BROWSER_COMMAND"); ok {
+		settings.BrowserCommand = val
+	}
+	if val, ok := lookup("CACHE_DIR"); ok {
+		settings.CacheDir = val
+	}
+	if val, ok := lookup("CACHE_MAX_SIZE"); ok {
+		settings.CacheMaxSize = val
+	}
+	if val, ok := lookup("CONTEXT"); ok {
+		settings.Context = val
+	}
+	if val, ok := lookup("MOUNTS"); ok {
+		for mountString := range strings.SplitSeq(val, ";") {
+			if mountString = strings.TrimSpace(mountString); len(mountString) > 0 {
+				settings.Mounts = append(settings.Mounts, mountString)
+			}
+		}
+	}
+	if val, ok := lookup("PLATFORM_ARCH"); ok {
+		settings.Platform.Arch = val
+	}
+	if val, ok := lookup("PLATFORM_OS"); ok {
+		settings.Platform.OS = val
+	}
+	if val, ok := lookup("PORT_OFFSET"); ok {
+		portOffset, err := strconv.ParseUint(val, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("%w: value of %sPORT_OFFSET must be a port number, not %q", ErrSettings, prefix, val)
+		}
+		settings.PortOffset = uint16(portOffset)
+	}
+	if val, ok := lookup("SOCKET"); ok {
+		settings.Socket = val
+	}
+	return settings, nil
+}
+
+// merge applies the non-empty values in layer on top of s.
+func (s *Settings) merge(layer *Settings) error {
+	return mergo.Merge(s, layer, mergo.WithOverride, mergo.WithAppendSlice)
+}
+
+// registryCredentials returns the registry credentials in s in the
+// form trill expects, with references to environment variables
+// expanded.
+func (s *Settings) registryCredentials() trill.RegistryCredentials {
+	if len(s.Registries) == 0 {
+		return nil
+	}
+	creds := make(trill.RegistryCredentials, len(s.Registries))
+	for host, regSettings := range s.Registries {
+		creds[host] = registry.AuthConfig{
+			Username:      os.ExpandEnv(regSettings.Username),
+			Password:      os.ExpandEnv(regSettings.Password),
+			IdentityToken: os.ExpandEnv(regSettings.IdentityToken),
+		}
+	}
+	return creds
+}
+
+// cacheMaxSize returns the size, in bytes, the Feature cache is
+// trimmed down to; DefFeatureCacheMaxSize, unless set in s.
+func (s *Settings) cacheMaxSize() (int64, error) {
+	if len(s.CacheMaxSize) == 0 {
+		return DefFeatureCacheMaxSize, nil
+	}
+	size, err := units.RAMInBytes(s.CacheMaxSize)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid cache-max-size: %w", ErrSettings, err)
+	}
+	return size, nil
+}
+
+// parsedMounts parses the mounts in s, with references to
+// environment variables expanded.
+func (s *Settings) parsedMounts() ([]*writ.MobyMount, error) {
+	var mounts []*writ.MobyMount
+	for _, mountString := range s.Mounts {
+		m, err := writ.ParseMountString(os.ExpandEnv(mountString))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrSettings, err)
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// cacheDir returns the cache directory set in s, with references to
+// environment variables expanded, creating it if necessary; it
+// returns an empty string if none is set.
+func (s *Settings) cacheDir() (string, error) {
+	if len(s.CacheDir) == 0 {
+		return "", nil
+	}
+	cacheDir, err := filepath.Abs(os.ExpandEnv(s.CacheDir))
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(cacheDir, fs.ModeDir|0755); err != nil {
+		return "", err
+	}
+	return cacheDir, nil
+}
@@ -0,0 +1,159 @@
+package brig
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoadSettings checks that the global settings, per-project
+// settings, and environment variables are layered in that order.
+func TestLoadSettings(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	t.Setenv("BRIG_SOCKET", "")
+	t.Setenv("BRIG_MOUNTS", "")
+	t.Setenv("GHCR_TOKEN", "s3cr3t")
+	assert.Nil(t, os.MkdirAll(filepath.Join(configDir, "brig"), 0o755))
+	assert.Nil(t, os.WriteFile(filepath.Join(configDir, "brig", "config.toml"), []byte(`
+socket = "unix:///global.sock"
+port-offset = 9000
+mounts = ["type=volume,source=global-cache,target=/cache"]
+
+[platform]
+arch = "arm64"
+
+[registries."ghcr.io"]
+username = "octocat"
+password = "${GHCR_TOKEN}"
+`), 0o644))
+
+	projectDir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(projectDir, ".brig.yaml"), []byte(`
+port-offset: 10000
+mounts:
+  - type=bind,source=/tmp,target=/host-tmp
+`), 0o644))
+	workDir := filepath.Join(projectDir, "src", "app")
+	assert.Nil(t, os.MkdirAll(workDir, 0o755))
+	t.Chdir(workDir)
+	t.Setenv("BRIG_PLATFORM_OS", "windows")
+
+	cmd := New("brig", "")
+	settings, err := cmd.loadSettings("", "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(configDir, "brig", "config.toml"), filepath.Join(projectDir, ".brig.yaml")}, settings.files)
+	assert.Equal(t, "unix:///global.sock", settings.Socket)
+	assert.EqualValues(t, 10000, settings.PortOffset)
+	assert.Equal(t, PlatformSettings{Arch: "arm64", OS: "windows"}, settings.Platform)
+	assert.Equal(t, []string{"type=volume,source=global-cache,target=/cache", "type=bind,source=/tmp,target=/host-tmp"}, settings.Mounts)
+
+	creds := settings.registryCredentials()
+	assert.Equal(t, "octocat", creds["ghcr.io"].Username)
+	assert.Equal(t, "s3cr3t", creds["ghcr.io"].Password)
+
+	// Flags that were set take precedence
+	cmd.settings = settings
+	cmd.Options.PortOffset = 2000
+	cmd.applySettings()
+	assert.EqualValues(t, 2000, cmd.Options.PortOffset)
+	assert.Equal(t, "arm64", cmd.Options.PlatformArch)
+	assert.Equal(t, "unix:///global.sock", cmd.Options.Socket)
+
+	mounts, err := settings.parsedMounts()
+	assert.Nil(t, err)
+	assert.Len(t, mounts, 2)
+}
+
+// TestLoadSettingsProjectDir checks that the per-project settings
+// file is looked for from the workspace brig is pointed at, rather
+// than the working directory.
+func TestLoadSettingsProjectDir(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("BRIG_PORT_OFFSET", "")
+
+	projectDir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(projectDir, ".brig.yaml"), []byte("port-offset: 10000\n"), 0o644))
+	workspaceDir := filepath.Join(projectDir, "app")
+	assert.Nil(t, os.MkdirAll(workspaceDir, 0o755))
+
+	// Run from somewhere else entirely, which has its own settings
+	workDir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(workDir, ".brig.yaml"), []byte("port-offset: 20000\n"), 0o644))
+	t.Chdir(workDir)
+
+	assert.Equal(t, workspaceDir, projectSettingsDir([]string{workspaceDir}))
+	assert.Empty(t, projectSettingsDir([]string{filepath.Join(workspaceDir, "missing")}))
+
+	settings, err := New("brig", "").loadSettings("", projectSettingsDir([]string{workspaceDir}))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(projectDir, ".brig.yaml")}, settings.files)
+	assert.EqualValues(t, 10000, settings.PortOffset)
+
+	// Without a workspace to go by, the working directory's used
+	settings, err = New("brig", "").loadSettings("", projectSettingsDir(nil))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 20000, settings.PortOffset)
+}
+
+// TestLoadSettingsMalformed checks that unknown keys and malformed
+// values are rejected.
+func TestLoadSettingsMalformed(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Chdir(t.TempDir())
+	for name, contents := range map[string]string{
+		"unknown.toml": `sockett = "unix:///typo.sock"`,
+		"unknown.yaml": `sockett: unix:///typo.sock`,
+		"invalid.toml": `port-offset = "lots"`,
+		"invalid.yml":  `platform: arm64`,
+	} {
+		settingsPath := filepath.Join(t.TempDir(), name)
+		assert.Nil(t, os.WriteFile(settingsPath, []byte(contents), 0o644))
+		_, err := New("brig", "").loadSettings(settingsPath, "")
+		assert.ErrorIs(t, err, ErrSettings, name)
+	}
+
+	_, err := New("brig", "").loadSettings(filepath.Join(t.TempDir(), "missing.toml"), "")
+	assert.ErrorIs(t, err, ErrSettings)
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("BRIG_PORT_OFFSET", "lots")
+	_, err = New("brig", "").loadSettings("", "")
+	assert.ErrorIs(t, err, ErrSettings)
+}
+
+// TestSettingsCacheMaxSize checks that cache-max-size is parsed as a
+// human-readable size, with a default when unset.
+func TestSettingsCacheMaxSize(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	size, err := (&Settings{}).cacheMaxSize()
+	assert.Nil(t, err)
+	assert.Equal(t, DefFeatureCacheMaxSize, size)
+
+	size, err = (&Settings{CacheMaxSize: "512m"}).cacheMaxSize()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(512*1024*1024), size)
+
+	size, err = (&Settings{CacheMaxSize: "0"}).cacheMaxSize()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), size)
+
+	_, err = (&Settings{CacheMaxSize: "lots"}).cacheMaxSize()
+	assert.ErrorIs(t, err, ErrSettings)
+}
@@ -0,0 +1,115 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nlsantos/brig/trill"
+	"github.com/pborman/options"
+	"golang.org/x/sync/errgroup"
+)
+
+// ShellOptions are the flags `brig shell` takes, on top of the global
+// ones.
+type ShellOptions struct {
+	Shell string `getopt:"--shell=PATH shell to start in place of the remote user's, e.g., /bin/zsh"`
+}
+
+// runShell implements `brig shell [--shell PATH] [PATH]`, which
+// attaches the terminal to a new shell in a devcontainer that's
+// already up (e.g., one left running with --detach), the way editors
+// open terminals in it.
+//
+// The shell runs as the remoteUser, with the remoteEnv, in the
+// workspaceFolder; the devcontainer's postAttachCommand is run as it's
+// attached. The devcontainer's left running once the shell exits.
+func (cmd *Command) runShell(args []string) ExitCode {
+	opts := ShellOptions{}
+	args, err := options.SubRegisterAndParse(&opts, append([]string{"shell"}, args...))
+	if err != nil || len(args) > 1 {
+		if err != nil {
+			fmt.Fprintln(cmd.stderr(), err)
+		}
+		fmt.Fprintf(cmd.stderr(), "usage: %s shell [--shell PATH] [PATH]\n", cmd.appName)
+		return ExitErrorParsingFlags
+	}
+
+	parser, err := cmd.loadDevcontainerJSON(args)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to parse devcontainer.json: %s\n", err)
+		return ExitNonValidDevcontainerJSON
+	}
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	// The Features' postAttachCommands are run as well
+	if err = cmd.ParseFeaturesConfig(ctx, parser, parser.Config.Features); err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to prepare features: %s\n", err)
+		return ExitFeaturesFailed
+	}
+
+	if cmd.trillClient == nil {
+		if err = cmd.Connect(cmd.Options.Socket); err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to reach Podman/Docker: %s\n", err)
+			return exitCodeForError(err)
+		}
+	}
+	containerName := devcontainerContainerName(parser)
+	running, err := cmd.trillClient.IsContainerRunning(ctx, containerName)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to find the devcontainer %s: %s\n", containerName, err)
+		return ExitError
+	} else if !running {
+		fmt.Fprintf(cmd.stderr(), "the devcontainer %s isn't running; bring it up with `%s --detach` first\n", containerName, cmd.appName)
+		return ExitError
+	}
+	if err = cmd.trillClient.UseRunningDevcontainer(ctx, parser, containerName); err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to use the devcontainer %s: %s\n", containerName, err)
+		return ExitError
+	}
+	cmd.trillClient.AttachShell = opts.Shell
+	if detachKeys, err := trill.ParseDetachKeys(cmd.Options.DetachKeys); err == nil {
+		cmd.trillClient.DetachKeys = detachKeys
+	}
+
+	// The lifecycle handler runs the postAttachCommand, and exits once
+	// the terminal's detached
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.Go(func() (err error) {
+		defer trill.RecoverPanic(&err)
+		return cmd.lifecycleHandler(egCtx, eg, parser)
+	})
+	eg.Go(func() (err error) {
+		defer trill.RecoverPanic(&err)
+		return cmd.trillClient.AttachHostTerminalToDevcontainer(egCtx)
+	})
+	err = eg.Wait()
+	var panicErr *trill.PanicError
+	if errors.As(err, &panicErr) {
+		return cmd.reportPanic(panicErr.Value, panicErr.Stack)
+	}
+	if err != nil && !errors.Is(err, trill.ErrDetached) && !errors.Is(err, context.Canceled) {
+		fmt.Fprintf(cmd.stderr(), "unable to attach to the devcontainer %s: %s\n", containerName, err)
+		return exitCodeForError(err)
+	}
+	return ExitNormal
+}
@@ -0,0 +1,59 @@
+package brig
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/moby/moby/api/types/container"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/trill"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRunShellNotRunning checks that `brig shell` refuses to start a
+// shell in a devcontainer that isn't up, rather than bringing it up.
+func TestRunShellNotRunning(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, ".devcontainer", "devcontainer.json")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(configPath), 0o755))
+	assert.Nil(t, os.WriteFile(configPath, []byte(`{"image": "alpine"}`), 0o644))
+
+	var stderr bytes.Buffer
+	cmd := New("brig", "")
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &stderr
+	cmd.settings = &Settings{CacheDir: t.TempDir()}
+	parser, err := cmd.loadDevcontainerJSON([]string{configPath})
+	assert.Nil(t, err)
+	containerName := devcontainerContainerName(parser)
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("alpine", nil)
+	cmd.trillClient, err = trill.NewClient(trill.ClientOptions{SocketAddr: "fake://engine", Engine: engine})
+	assert.Nil(t, err)
+
+	assert.Equal(t, ExitError, cmd.runShell([]string{configPath}))
+	assert.Contains(t, stderr.String(), "unable to find the devcontainer")
+
+	_, err = engine.ContainerCreate(context.Background(), mobyclient.ContainerCreateOptions{
+		Name:   containerName,
+		Config: &container.Config{Image: "alpine"},
+	})
+	assert.Nil(t, err)
+	stderr.Reset()
+	assert.Equal(t, ExitError, cmd.runShell([]string{"--shell", "/bin/zsh", configPath}))
+	assert.Contains(t, stderr.String(), "isn't running")
+	assert.Empty(t, engine.CallsTo("ContainerStart"))
+	assert.Empty(t, engine.CallsTo("ExecCreate"))
+
+	assert.Equal(t, ExitErrorParsingFlags, cmd.runShell([]string{"--bogus"}))
+}
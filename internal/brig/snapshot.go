@@ -0,0 +1,181 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/pborman/options"
+)
+
+// SnapshotsFile is the name of the file, in the cache directory, the
+// snapshots recorded with `brig snapshot --use` are stored in.
+const SnapshotsFile string = "snapshots.json"
+
+// snapshotsLockFile is the name of the file, in the cache directory,
+// locked while SnapshotsFile is read or written.
+const snapshotsLockFile string = "snapshots.lock"
+
+// SnapshotOptions are the flags `brig snapshot` takes, on top of the
+// global ones.
+type SnapshotOptions struct {
+	Forget bool `getopt:"--forget stop bringing the devcontainer up from its recorded snapshot"`
+	Use    bool `getopt:"--use bring the devcontainer up from the snapshot from now on"`
+}
+
+// runSnapshot implements `brig snapshot [<flags>] TAG [PATH]`, which
+// saves the devcontainer's container, as it currently is, as an image
+// tagged TAG.
+//
+// With --use, the snapshot is recorded, and the devcontainer is
+// brought up from it instead of its image, skipping the build and any
+// Features, until `brig snapshot --forget [PATH]` is run.
+func (cmd *Command) runSnapshot(args []string) ExitCode {
+	opts := SnapshotOptions{}
+	args, err := options.SubRegisterAndParse(&opts, append([]string{"snapshot"}, args...))
+	if err != nil || (opts.Forget && (opts.Use || len(args) > 1)) || (!opts.Forget && (len(args) < 1 || len(args) > 2)) {
+		if err != nil {
+			fmt.Fprintln(cmd.stderr(), err)
+		}
+		fmt.Fprintf(cmd.stderr(), "usage: %s snapshot [--use] TAG [PATH]\n       %s snapshot --forget [PATH]\n", cmd.appName, cmd.appName)
+		return ExitErrorParsingFlags
+	}
+
+	var imageTag string
+	if !opts.Forget {
+		imageTag, args = args[0], args[1:]
+	}
+	parser, err := cmd.loadDevcontainerJSON(args)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to parse devcontainer.json: %s\n", err)
+		return ExitNonValidDevcontainerJSON
+	}
+	if isComposeDevcontainer(parser) {
+		fmt.Fprintln(cmd.stderr(), "snapshots of Compose-based devcontainers aren't supported")
+		return ExitUnsupportedConfiguration
+	}
+	containerName := devcontainerContainerName(parser)
+
+	if opts.Forget {
+		if err = cmd.recordSnapshot(containerName, ""); err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to forget the snapshot: %s\n", err)
+			return ExitError
+		}
+		return ExitNormal
+	}
+
+	if cmd.trillClient == nil {
+		if err = cmd.Connect(cmd.Options.Socket); err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to reach Podman/Docker: %s\n", err)
+			return exitCodeForError(err)
+		}
+	}
+	imageID, err := cmd.trillClient.CommitContainer(context.Background(), containerName, imageTag)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to snapshot %s: %s\n", containerName, err)
+		return ExitError
+	}
+	fmt.Fprintln(cmd.stdout(), imageID)
+
+	if opts.Use {
+		if err = cmd.recordSnapshot(containerName, imageTag); err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to record the snapshot: %s\n", err)
+			return ExitError
+		}
+	}
+	return ExitNormal
+}
+
+// recordSnapshot records imageTag as the snapshot the devcontainer
+// whose container is named containerName is brought up from; an empty
+// imageTag removes the record instead.
+func (cmd *Command) recordSnapshot(containerName string, imageTag string) error {
+	cacheDir, err := cmd.getCacheDirectory()
+	if err != nil {
+		return err
+	}
+	return withCacheFileLock(cacheDir, snapshotsLockFile, func() error {
+		snapshots, err := readSnapshots(cacheDir)
+		if err != nil {
+			return err
+		}
+		if len(imageTag) > 0 {
+			snapshots[containerName] = imageTag
+		} else {
+			delete(snapshots, containerName)
+		}
+		contents, err := json.MarshalIndent(snapshots, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeCacheFile(cacheDir, SnapshotsFile, contents)
+	})
+}
+
+// recordedSnapshot returns the tag of the snapshot recorded for the
+// devcontainer whose container is named containerName, or an empty
+// string if there's none, or if the snapshot can't be used.
+func (cmd *Command) recordedSnapshot(ctx context.Context, containerName string) string {
+	cacheDir, err := cmd.getCacheDirectory()
+	if err != nil {
+		slog.Warn("unable to look for a recorded snapshot", "error", err)
+		return ""
+	}
+	var snapshots map[string]string
+	err = withCacheFileLock(cacheDir, snapshotsLockFile, func() (err error) {
+		snapshots, err = readSnapshots(cacheDir)
+		return err
+	})
+	if err != nil {
+		slog.Warn("unable to look for a recorded snapshot", "error", err)
+		return ""
+	}
+	imageTag, ok := snapshots[containerName]
+	if !ok {
+		return ""
+	}
+	if !cmd.trillClient.IsImageTagAvailable(ctx, imageTag) {
+		slog.Warn("the recorded snapshot no longer exists; ignoring it", "tag", imageTag)
+		return ""
+	}
+	return imageTag
+}
+
+// readSnapshots reads the snapshots recorded in cacheDir, keyed by the
+// name of the container they were taken of.
+//
+// The caller is expected to hold the lock on SnapshotsFile.
+func readSnapshots(cacheDir string) (map[string]string, error) {
+	snapshots := make(map[string]string)
+	contents, err := os.ReadFile(filepath.Join(cacheDir, SnapshotsFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return snapshots, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(contents, &snapshots); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
@@ -0,0 +1,77 @@
+package brig
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/moby/moby/api/types/container"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/trill"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRunSnapshot checks that `brig snapshot` commits the
+// devcontainer's container, and that --use and --forget record and
+// drop the snapshot it's brought up from.
+func TestRunSnapshot(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dir := t.TempDir()
+	devcontainerDir := filepath.Join(dir, ".devcontainer")
+	assert.Nil(t, os.MkdirAll(devcontainerDir, 0o755))
+	configPath := filepath.Join(devcontainerDir, "devcontainer.json")
+	assert.Nil(t, os.WriteFile(configPath, []byte(`{"image": "alpine"}`), 0o644))
+
+	var stdout, stderr bytes.Buffer
+	cmd := New("brig", "")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.settings = &Settings{CacheDir: t.TempDir()}
+	parser, err := cmd.loadDevcontainerJSON([]string{configPath})
+	assert.Nil(t, err)
+	containerName := devcontainerContainerName(parser)
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("alpine", nil)
+	_, err = engine.ContainerCreate(context.Background(), mobyclient.ContainerCreateOptions{
+		Name:   containerName,
+		Config: &container.Config{Image: "alpine", User: "vscode", Labels: map[string]string{trill.ImageMetadataLabel: "[]"}},
+	})
+	assert.Nil(t, err)
+	cmd.trillClient, err = trill.NewClient(trill.ClientOptions{SocketAddr: "fake://engine", Engine: engine})
+	assert.Nil(t, err)
+
+	// A tag is required, unless the snapshot is being forgotten
+	cmd.Arguments = []string{"snapshot"}
+	exitCode, ok := cmd.runSubcommand()
+	assert.True(t, ok)
+	assert.Equal(t, ExitErrorParsingFlags, exitCode)
+
+	cmd.Arguments = []string{"snapshot", "--use", "snapshot:latest", configPath}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitNormal, exitCode, stderr.String())
+	if commits := engine.CallsTo("ContainerCommit"); assert.Len(t, commits, 1) {
+		assert.Equal(t, containerName, commits[0].Target)
+	}
+	assert.True(t, engine.HasImage("snapshot:latest"))
+	assert.NotEmpty(t, strings.TrimSpace(stdout.String()))
+	assert.Equal(t, "snapshot:latest", cmd.recordedSnapshot(context.Background(), containerName))
+
+	// Snapshots that no longer exist are ignored
+	assert.Nil(t, cmd.recordSnapshot("elsewhere", "gone:latest"))
+	assert.Equal(t, "", cmd.recordedSnapshot(context.Background(), "elsewhere"))
+
+	cmd.Arguments = []string{"snapshot", "--forget", configPath}
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitNormal, exitCode, stderr.String())
+	assert.Equal(t, "", cmd.recordedSnapshot(context.Background(), containerName))
+	assert.Len(t, engine.CallsTo("ContainerCommit"), 1)
+}
@@ -19,9 +19,13 @@
 package brig
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/exec"
+	"time"
 
 	"mvdan.cc/sh/v3/shell"
 )
@@ -54,6 +58,10 @@ func getSocketAddr(socketAddr string) string {
 		fmt.Sprintf("/run/user/%d/docker.sock", uid),
 		fmt.Sprintf("/run/user/%d/podman/podman.sock", uid), // This also covers Podman + macOS, apparently?
 		"/var/run/podman/podman.sock",                       // FreeBSD uses this
+		"${HOME}/.docker/run/docker.sock",                   // Docker Desktop, which no longer needs /var/run/docker.sock
+		"${HOME}/.colima/default/docker.sock",               // Colima
+		"${HOME}/.colima/docker.sock",                       // Colima, before profiles got directories of their own
+		"${HOME}/.rd/docker.sock",                           // Rancher Desktop
 		"/var/run/docker.sock",                              // Docker + GNU/Linux
 		"/private/var/run/docker.sock",                      // Docker + macOS
 	}
@@ -69,6 +77,65 @@ func getSocketAddr(socketAddr string) string {
 		}
 	}
 
+	// Podman on macOS runs in a VM, and forwards its socket to a path
+	// that depends on the VM's provider
+	if socketPath := podmanMachineSocket(); len(socketPath) > 0 {
+		slog.Debug("using the socket of a running podman machine", "socket", socketPath)
+		return fmt.Sprintf("unix://%s", socketPath)
+	}
+
 	slog.Error("unable to find a suitable socket address/path to target")
 	return ""
 }
+
+// podmanMachineInspectTimeout is how long `podman machine inspect` is
+// given to answer.
+const podmanMachineInspectTimeout = 5 * time.Second
+
+// podmanMachineSocket returns the path to the host end of the socket
+// a running podman machine forwards its engine's socket to, as
+// reported by `podman machine inspect`, or an empty string if there
+// isn't one (or podman isn't installed).
+func podmanMachineSocket() string {
+	podmanPath, err := exec.LookPath("podman")
+	if err != nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), podmanMachineInspectTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, podmanPath, "machine", "inspect").Output()
+	if err != nil {
+		slog.Debug("unable to inspect podman machines", "error", err)
+		return ""
+	}
+	return runningMachineSocket(out)
+}
+
+// runningMachineSocket picks the socket of the first running machine
+// that has one out of the output of `podman machine inspect`.
+func runningMachineSocket(inspectOutput []byte) string {
+	var machines []struct {
+		Name           string
+		State          string
+		ConnectionInfo struct {
+			PodmanSocket *struct {
+				Path string
+			}
+		}
+	}
+	if err := json.Unmarshal(inspectOutput, &machines); err != nil {
+		slog.Debug("unable to parse the output of podman machine inspect", "error", err)
+		return ""
+	}
+	for _, machine := range machines {
+		socket := machine.ConnectionInfo.PodmanSocket
+		if machine.State != "running" || socket == nil || len(socket.Path) == 0 {
+			continue
+		}
+		if _, err := os.Stat(socket.Path); err == nil {
+			return socket.Path
+		}
+		slog.Debug("podman machine's socket doesn't exist", "machine", machine.Name, "socket", socket.Path)
+	}
+	return ""
+}
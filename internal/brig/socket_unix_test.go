@@ -0,0 +1,37 @@
+//go:build !windows
+
+package brig
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRunningMachineSocket checks that the socket of the first running
+// podman machine whose socket exists is picked out of the output of
+// `podman machine inspect`.
+func TestRunningMachineSocket(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "podman-machine-default-api.sock")
+	assert.Nil(t, os.WriteFile(socketPath, nil, 0o600))
+
+	output := fmt.Sprintf(`[
+		{"Name": "stopped", "State": "stopped", "ConnectionInfo": {"PodmanSocket": {"Path": %q}}},
+		{"Name": "stale", "State": "running", "ConnectionInfo": {"PodmanSocket": {"Path": %q}}},
+		{"Name": "windows", "State": "running", "ConnectionInfo": {"PodmanSocket": null}},
+		{"Name": "podman-machine-default", "State": "running", "ConnectionInfo": {"PodmanSocket": {"Path": %q}}}
+	]`, socketPath, filepath.Join(dir, "missing.sock"), socketPath)
+	assert.Equal(t, socketPath, runningMachineSocket([]byte(output)))
+
+	assert.Empty(t, runningMachineSocket([]byte(`[]`)))
+	assert.Empty(t, runningMachineSocket([]byte(`Error: no machines`)))
+}
@@ -0,0 +1,292 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nlsantos/brig/trill"
+	"github.com/nlsantos/brig/writ"
+)
+
+// DefSSHConfigPath is the ssh_config file brig adds devcontainers
+// started with --ssh to, unless --ssh-config says otherwise.
+//
+// It's kept separate from ~/.ssh/config so brig never has to touch
+// the latter; add "Include brig_config" to it to pick the entries up.
+const DefSSHConfigPath string = "~/.ssh/brig_config"
+
+// sshContainerPort is the port sshd listens on inside the
+// devcontainer; it's kept off of 22 so it doesn't clash with an sshd
+// the image might already run.
+const sshContainerPort string = "2222"
+
+// sshdSetupScript installs sshd in the devcontainer if it's missing,
+// authorizes the host user's public keys for $BRIG_SSH_USER, and
+// starts sshd on $BRIG_SSH_PORT with only public key authentication
+// allowed.
+//
+// remoteEnv is handed to SSH sessions through ~/.ssh/environment, as
+// sshd otherwise starts them with a bare environment.
+const sshdSetupScript string = `set -e
+if ! command -v sshd >/dev/null 2>&1 && [ ! -x /usr/sbin/sshd ]; then
+	if command -v apk >/dev/null 2>&1; then
+		apk add --no-cache openssh-server
+	elif command -v apt-get >/dev/null 2>&1; then
+		apt-get update
+		DEBIAN_FRONTEND=noninteractive apt-get install -y --no-install-recommends openssh-server
+	elif command -v dnf >/dev/null 2>&1; then
+		dnf install -y openssh-server
+	elif command -v microdnf >/dev/null 2>&1; then
+		microdnf install -y openssh-server
+	elif command -v yum >/dev/null 2>&1; then
+		yum install -y openssh-server
+	else
+		echo "unable to install sshd: no supported package manager found" >&2
+		exit 1
+	fi
+fi
+sshd="$(command -v sshd || echo /usr/sbin/sshd)"
+ssh-keygen -A
+mkdir -p /run/sshd
+home="$(awk -F: -v user="$BRIG_SSH_USER" '$1 == user { print $6 }' /etc/passwd)"
+if [ -z "$home" ]; then
+	echo "unable to find the home directory of $BRIG_SSH_USER" >&2
+	exit 1
+fi
+mkdir -p "$home/.ssh"
+keys="$home/.ssh/authorized_keys"
+touch "$keys"
+printf '%s\n' "$BRIG_SSH_AUTHORIZED_KEYS" | while IFS= read -r key; do
+	if [ -n "$key" ] && ! grep -qxF "$key" "$keys"; then
+		printf '%s\n' "$key" >> "$keys"
+	fi
+done
+printf '%s' "$BRIG_SSH_ENVIRONMENT" > "$home/.ssh/environment"
+chmod 700 "$home/.ssh"
+chmod 600 "$keys" "$home/.ssh/environment"
+chown -R "$BRIG_SSH_USER:$(id -g "$BRIG_SSH_USER")" "$home/.ssh"
+# sshd refuses logins to locked accounts, even with a key; accounts
+# created without a password are locked by default
+if [ -f /etc/shadow ]; then
+	sed -i "s/^$BRIG_SSH_USER:!:/$BRIG_SSH_USER:*:/" /etc/shadow
+fi
+"$sshd" -p "$BRIG_SSH_PORT" -o PasswordAuthentication=no -o PermitUserEnvironment=yes
+`
+
+// invalidSSHHostPattern matches characters that shouldn't go into an
+// ssh_config Host alias.
+var invalidSSHHostPattern = regexp.MustCompile("[^a-zA-Z0-9._-]")
+
+// prepareSSH publishes the port sshd is going to listen on in the
+// devcontainer described by p on a free port on the host, if --ssh is
+// set.
+//
+// Needs to be called before the devcontainer is created; see
+// startSSHServer for the parts that happen inside it.
+func (cmd *Command) prepareSSH(p *writ.DevcontainerParser) error {
+	if !cmd.Options.SSH {
+		return nil
+	}
+	if p.Config.DockerComposeFile != nil {
+		slog.Warn("--ssh is not supported for Compose projects; ignoring")
+		cmd.Options.SSH = false
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("unable to find a free port for sshd: %w", err)
+	}
+	cmd.sshPort = listener.Addr().(*net.TCPAddr).Port
+	if err = listener.Close(); err != nil {
+		return err
+	}
+
+	if p.Config.AppPort == nil {
+		p.Config.AppPort = &writ.AppPort{}
+	}
+	*p.Config.AppPort = append(*p.Config.AppPort, fmt.Sprintf("127.0.0.1:%d:%s", cmd.sshPort, sshContainerPort))
+	slog.Debug("publishing sshd", "host-port", cmd.sshPort, "container-port", sshContainerPort)
+	return nil
+}
+
+// startSSHServer starts sshd in the devcontainer (see
+// sshdSetupScript), then adds a Host entry for it to the ssh_config
+// file named by --ssh-config.
+func (cmd *Command) startSSHServer(ctx context.Context, p *writ.DevcontainerParser) error {
+	if !cmd.Options.SSH {
+		return nil
+	}
+
+	authorizedKeys := hostPublicKeys(ctx)
+	if len(authorizedKeys) == 0 {
+		slog.Warn("no SSH public keys found on the host; the devcontainer won't accept any logins until some are added")
+	}
+	var environment strings.Builder
+	for key, val := range p.Config.RemoteEnv {
+		fmt.Fprintf(&environment, "%s=%s\n", key, val)
+	}
+	env := writ.EnvVarMap{
+		"BRIG_SSH_AUTHORIZED_KEYS": strings.Join(authorizedKeys, "\n"),
+		"BRIG_SSH_ENVIRONMENT":     environment.String(),
+		"BRIG_SSH_PORT":            sshContainerPort,
+		"BRIG_SSH_USER":            *p.Config.RemoteUser,
+	}
+
+	stdout, stderr, captured := cmd.lifecycleOutputWriters("SSH", "")
+	start := time.Now()
+	err := cmd.trillClient.StreamExecInDevcontainer(ctx, trill.ExecOptions{
+		User:       "root",
+		Env:        &env,
+		RunInShell: true,
+		Stdout:     stdout,
+		Stderr:     stderr,
+	}, sshdSetupScript)
+	cmd.recordLifecycleResult("SSH", "", start, err)
+	if err != nil {
+		logCapturedLifecycleOutput("SSH", captured)
+		return err
+	}
+
+	host := "brig-" + invalidSSHHostPattern.ReplaceAllString(createImageTagBase(p), "-")
+	configPath, err := cmd.sshConfigPath()
+	if err != nil {
+		return err
+	}
+	entry := fmt.Sprintf("Host %s\n\tHostName 127.0.0.1\n\tPort %d\n\tUser %s\n\tStrictHostKeyChecking no\n\tUserKnownHostsFile /dev/null\n\tLogLevel ERROR\n",
+		host, cmd.sshPort, *p.Config.RemoteUser)
+	if err = writeSSHConfigEntry(configPath, host, entry); err != nil {
+		return fmt.Errorf("unable to update %s: %w", configPath, err)
+	}
+	cmd.sshHost = host
+	cmd.updateResult(func(r *Result) {
+		r.SSHHost = host
+	})
+	slog.Info("devcontainer is reachable over SSH", "host", host, "port", cmd.sshPort, "config", configPath)
+	return nil
+}
+
+// removeSSHConfigEntry removes the Host entry startSSHServer added,
+// if any.
+func (cmd *Command) removeSSHConfigEntry() error {
+	if len(cmd.sshHost) == 0 {
+		return nil
+	}
+	configPath, err := cmd.sshConfigPath()
+	if err != nil {
+		return err
+	}
+	return writeSSHConfigEntry(configPath, cmd.sshHost, "")
+}
+
+// sshConfigPath returns the path to the ssh_config file devcontainers
+// are added to, with a leading ~ expanded to the home directory.
+func (cmd *Command) sshConfigPath() (string, error) {
+	configPath := cmd.Options.SSHConfig
+	if len(configPath) == 0 {
+		configPath = DefSSHConfigPath
+	}
+	if rest, ok := strings.CutPrefix(configPath, "~"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configPath = filepath.Join(home, rest)
+	}
+	return configPath, nil
+}
+
+// hostPublicKeys returns the public keys of the host user: those held
+// by ssh-agent, and those in ~/.ssh/*.pub.
+func hostPublicKeys(ctx context.Context) []string {
+	var keys []string
+	if out, err := exec.CommandContext(ctx, "ssh-add", "-L").Output(); err == nil {
+		keys = append(keys, strings.Split(strings.TrimSpace(string(out)), "\n")...)
+	} else {
+		slog.Debug("unable to list the keys held by ssh-agent", "error", err)
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		pubKeyFiles, _ := filepath.Glob(filepath.Join(home, ".ssh", "*.pub"))
+		for _, pubKeyFile := range pubKeyFiles {
+			contents, err := os.ReadFile(pubKeyFile)
+			if err != nil {
+				slog.Warn("unable to read SSH public key", "path", pubKeyFile, "error", err)
+				continue
+			}
+			keys = append(keys, strings.Split(strings.TrimSpace(string(contents)), "\n")...)
+		}
+	}
+
+	keys = slices.DeleteFunc(keys, func(key string) bool { return len(key) == 0 })
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+// writeSSHConfigEntry replaces the entry for host in the ssh_config
+// file at configPath with entry, creating the file if necessary. An
+// empty entry removes it.
+//
+// Entries are delimited by marker comments, so the rest of the file
+// is left alone.
+func writeSSHConfigEntry(configPath string, host string, entry string) error {
+	contents, err := os.ReadFile(configPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	beginMarker := fmt.Sprintf("# BEGIN brig %s", host)
+	endMarker := fmt.Sprintf("# END brig %s", host)
+	var lines []string
+	inEntry := false
+	for line := range strings.Lines(string(contents)) {
+		switch strings.TrimSpace(line) {
+		case beginMarker:
+			inEntry = true
+		case endMarker:
+			inEntry = false
+		default:
+			if !inEntry {
+				lines = append(lines, line)
+			}
+		}
+	}
+	if len(entry) > 0 {
+		if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+			lines[len(lines)-1] += "\n"
+		}
+		lines = append(lines, beginMarker+"\n", entry, endMarker+"\n")
+	}
+
+	if err = os.MkdirAll(filepath.Dir(configPath), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(configPath, []byte(strings.Join(lines, "")), 0o600)
+}
@@ -0,0 +1,42 @@
+package brig
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWriteSSHConfigEntry checks that entries are added, replaced,
+// and removed without disturbing the rest of the ssh_config file.
+func TestWriteSSHConfigEntry(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	configPath := filepath.Join(t.TempDir(), "ssh", "brig_config")
+	readConfig := func() string {
+		contents, err := os.ReadFile(configPath)
+		assert.Nil(t, err)
+		return string(contents)
+	}
+
+	assert.Nil(t, writeSSHConfigEntry(configPath, "brig-a", "Host brig-a\n\tPort 1\n"))
+	assert.Equal(t, "# BEGIN brig brig-a\nHost brig-a\n\tPort 1\n# END brig brig-a\n", readConfig())
+	info, err := os.Stat(configPath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	assert.Nil(t, os.WriteFile(configPath, []byte("Host elsewhere\n\tPort 22\n"+readConfig()+"# trailing comment"), 0o600))
+	assert.Nil(t, writeSSHConfigEntry(configPath, "brig-b", "Host brig-b\n\tPort 2\n"))
+	assert.Nil(t, writeSSHConfigEntry(configPath, "brig-a", "Host brig-a\n\tPort 3\n"))
+	assert.Equal(t, "Host elsewhere\n\tPort 22\n# trailing comment\n"+
+		"# BEGIN brig brig-b\nHost brig-b\n\tPort 2\n# END brig brig-b\n"+
+		"# BEGIN brig brig-a\nHost brig-a\n\tPort 3\n# END brig brig-a\n", readConfig())
+
+	assert.Nil(t, writeSSHConfigEntry(configPath, "brig-b", ""))
+	assert.Nil(t, writeSSHConfigEntry(configPath, "brig-a", ""))
+	assert.Equal(t, "Host elsewhere\n\tPort 22\n# trailing comment\n", readConfig())
+}
@@ -0,0 +1,123 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"slices"
+)
+
+// Subcommand is a command that does something other than bring a
+// devcontainer up, e.g., `brig completion bash`.
+//
+// Subcommands are picked out by the first non-flag argument; the
+// global flags still apply to them.
+type Subcommand struct {
+	Name   string                                     // What the subcommand is invoked as
+	Usage  string                                     // One-line description, shown in completions
+	Args   []string                                   // Values its first argument can take, if it's from a fixed set
+	Hidden bool                                       // If true, it's left out of completions
+	Run    func(cmd *Command, args []string) ExitCode // Runs the subcommand with the arguments after its name
+}
+
+// subcommands returns every subcommand brig knows of, in the order
+// they're listed in completions.
+func (cmd *Command) subcommands() []Subcommand {
+	return []Subcommand{
+		{
+			Name:  "completion",
+			Usage: "print a shell completion script",
+			Args:  CompletionShells,
+			Run:   (*Command).runCompletion,
+		},
+		{
+			Name:  "compose",
+			Usage: "list, tail, restart, start, and stop the devcontainer's Compose services",
+			Args:  []string{"logs", "ps", "restart", "start", "stop"},
+			Run:   (*Command).runCompose,
+		},
+		{
+			Name:  "context",
+			Usage: "list and switch between engine contexts",
+			Args:  []string{"ls", "show", "use"},
+			Run:   (*Command).runContext,
+		},
+		{
+			Name:  "cp",
+			Usage: "copy files between the host and the devcontainer",
+			Run:   (*Command).runCp,
+		},
+		{
+			Name:  "features",
+			Usage: "add and remove the devcontainer's Features, and tools for Feature authors",
+			Args:  []string{"add", "package", "publish", "remove", "test"},
+			Run:   (*Command).runFeatures,
+		},
+		{
+			Name:  "init",
+			Usage: "apply a devcontainer Template to the working directory",
+			Run:   (*Command).runInit,
+		},
+		{
+			Name:  "read-configuration",
+			Usage: "print the fully-resolved configuration as JSON",
+			Run:   (*Command).runReadConfiguration,
+		},
+		{
+			Name:  "shell",
+			Usage: "attach the terminal to a new shell in the running devcontainer",
+			Run:   (*Command).runShell,
+		},
+		{
+			Name:  "snapshot",
+			Usage: "save the devcontainer's container as an image",
+			Run:   (*Command).runSnapshot,
+		},
+		{
+			Name:  "validate",
+			Usage: "check devcontainer.json against the spec, pointing out what's wrong with it",
+			Run:   (*Command).runValidate,
+		},
+		{
+			Name:  "watch",
+			Usage: "report changes to the devcontainer's state, optionally restarting it",
+			Run:   (*Command).runWatch,
+		},
+		{
+			Name:   completeSubcommand,
+			Usage:  "print completion candidates; used by the completion scripts",
+			Hidden: true,
+			Run:    (*Command).runComplete,
+		},
+	}
+}
+
+// runSubcommand runs the subcommand named in the first argument, if
+// any.
+//
+// Returns false if there's no such subcommand, in which case the
+// argument is taken to be the path to a devcontainer.json.
+func (cmd *Command) runSubcommand() (ExitCode, bool) {
+	if len(cmd.Arguments) == 0 {
+		return ExitNormal, false
+	}
+	subcmds := cmd.subcommands()
+	idx := slices.IndexFunc(subcmds, func(s Subcommand) bool { return s.Name == cmd.Arguments[0] })
+	if idx < 0 {
+		return ExitNormal, false
+	}
+	return subcmds[idx].Run(cmd, cmd.Arguments[1:]), true
+}
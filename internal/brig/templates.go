@@ -0,0 +1,285 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nlsantos/brig/writ"
+	"golang.org/x/term"
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// TemplateConfigFile is the name of the file at the root of a
+// Template that describes it.
+const TemplateConfigFile string = "devcontainer-template.json"
+
+// templateDocFiles are files at the root of a Template that document
+// the Template itself, rather than the project it's applied to; they
+// aren't copied over.
+var templateDocFiles = []string{TemplateConfigFile, "NOTES.md", "README.md"}
+
+// runInit implements `brig init <template> [<option>=<value>...]`,
+// which applies a devcontainer Template to the working directory.
+//
+// The Template can be referenced as an OCI artifact (e.g.,
+// ghcr.io/devcontainers/templates/go), or as a directory on the local
+// filesystem. Options not set in the arguments are asked for if
+// brig's running in a terminal, and left at their defaults if it
+// isn't.
+func (cmd *Command) runInit(args []string) ExitCode {
+	if len(args) < 1 {
+		fmt.Fprintf(cmd.stderr(), "usage: %s init <template> [<option>=<value>...]\n", cmd.appName)
+		return ExitErrorParsingFlags
+	}
+
+	ctx := context.Background()
+	templateDir := args[0]
+	if info, err := os.Stat(templateDir); err != nil || !info.IsDir() {
+		tempDir, err := os.MkdirTemp("", cmd.appName+"-template-")
+		if err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to create a temporary directory: %s\n", err)
+			return ExitError
+		}
+		defer os.RemoveAll(tempDir)
+		if err = pullTemplate(ctx, args[0], tempDir); err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to pull the template %s: %s\n", args[0], err)
+			return ExitError
+		}
+		templateDir = tempDir
+	}
+
+	parser, err := writ.NewDevcontainerTemplateParser(filepath.Join(templateDir, TemplateConfigFile))
+	if err == nil {
+		err = parser.Validate()
+	}
+	if err == nil {
+		err = parser.Parse()
+	}
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to parse %s: %s\n", TemplateConfigFile, err)
+		return ExitError
+	}
+
+	given := make(map[string]bool)
+	for _, arg := range args[1:] {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			fmt.Fprintf(cmd.stderr(), "template options are given as <option>=<value>: %s\n", arg)
+			return ExitErrorParsingFlags
+		}
+		if err = setTemplateOption(parser, name, value); err != nil {
+			fmt.Fprintf(cmd.stderr(), "%s\n", err)
+			return ExitErrorParsingFlags
+		}
+		given[name] = true
+	}
+
+	var excludedPaths []string
+	if term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stderr.Fd())) {
+		if excludedPaths, err = promptTemplate(os.Stdin, cmd.stderr(), parser, given); err != nil {
+			slog.Debug("template not applied", "error", err)
+			return ExitError
+		}
+	}
+
+	workspaceDir, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to get the working directory: %s\n", err)
+		return ExitError
+	}
+	written, err := applyTemplate(templateDir, workspaceDir, parser.OptionValues(), excludedPaths)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to apply the template: %s\n", err)
+		return ExitError
+	}
+	for _, path := range written {
+		fmt.Fprintln(cmd.stdout(), path)
+	}
+	return ExitNormal
+}
+
+// pullTemplate retrieves the Template distributed as the OCI artifact
+// referenced by ref, and extracts it into dest.
+//
+// Templates aren't cached, as they're only ever applied once.
+func pullTemplate(ctx context.Context, ref string, dest string) error {
+	parsedRef, err := registry.ParseReference(ref)
+	if err != nil {
+		return err
+	}
+	if len(parsedRef.Reference) == 0 {
+		parsedRef.Reference = "latest"
+	}
+
+	repo, err := remote.NewRepository(parsedRef.String())
+	if err != nil {
+		return err
+	}
+	slog.Debug("attempting to pull template OCI artifact", "ref", parsedRef.String())
+	return extractArtifactLayer(ctx, repo, parsedRef.String(), dest)
+}
+
+// setTemplateOption sets the option name of the Template parsed by p
+// to value, converting it to the option's type and checking it against
+// the values the option allows.
+func setTemplateOption(p *writ.DevcontainerTemplateParser, name string, value string) error {
+	templateValue, err := parseOptionValue("template", p.Config.Options, name, value)
+	if err != nil {
+		return err
+	}
+	return p.SetOption(name, templateValue)
+}
+
+// promptTemplate asks, on out, for the values of the options of the
+// Template parsed by p that aren't in given, and whether to apply each
+// of its optional paths, reading the answers from in. An empty answer
+// keeps an option at its default, and applies an optional path.
+//
+// Returns the optional paths that shouldn't be applied, or an error
+// if in runs out before every question is answered.
+func promptTemplate(in io.Reader, out io.Writer, p *writ.DevcontainerTemplateParser, given map[string]bool) ([]string, error) {
+	prompt := newOptionPrompt(in, out, "template not applied")
+	optNames := slices.Sorted(func(yield func(string) bool) {
+		for optName := range p.Config.Options {
+			if !given[optName] && !yield(optName) {
+				return
+			}
+		}
+	})
+	if err := prompt.askOptions(p.Config.Options, optNames, func(name string, answer string) error {
+		return setTemplateOption(p, name, answer)
+	}); err != nil {
+		return nil, err
+	}
+
+	var excludedPaths []string
+	for _, optionalPath := range p.Config.OptionalPaths {
+		for {
+			answer, err := prompt.ask(fmt.Sprintf("Apply %s? [Y/n] ", optionalPath))
+			if err != nil {
+				return nil, err
+			}
+			if len(answer) == 0 || strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes") {
+				break
+			}
+			if strings.EqualFold(answer, "n") || strings.EqualFold(answer, "no") {
+				excludedPaths = append(excludedPaths, optionalPath)
+				break
+			}
+		}
+	}
+	return excludedPaths, nil
+}
+
+// applyTemplate copies the files of the Template in templateDir into
+// workspaceDir, with every ${templateOption:<option>} in them replaced
+// by the option's value in values.
+//
+// Files under excludedPaths (as named in optionalPaths) and the
+// Template's own documentation are skipped. Nothing is written if any
+// of the files already exists in workspaceDir.
+//
+// Returns the paths of the files written, relative to workspaceDir.
+func applyTemplate(templateDir string, workspaceDir string, values map[string]string, excludedPaths []string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(templateDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(templateDir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if slices.Contains(templateDocFiles, rel) || isExcludedTemplatePath(rel, excludedPaths) {
+			slog.Debug("skipping template file", "path", rel)
+			return nil
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var existing []string
+	for _, rel := range files {
+		if _, err := os.Lstat(filepath.Join(workspaceDir, filepath.FromSlash(rel))); err == nil {
+			existing = append(existing, rel)
+		}
+	}
+	if len(existing) > 0 {
+		return nil, fmt.Errorf("refusing to overwrite existing files: %s", strings.Join(existing, ", "))
+	}
+
+	replacements := make([]string, 0, len(values)*2)
+	for optName, value := range values {
+		replacements = append(replacements, fmt.Sprintf("${templateOption:%s}", optName), value)
+	}
+	replacer := strings.NewReplacer(replacements...)
+	for _, rel := range files {
+		src := filepath.Join(templateDir, filepath.FromSlash(rel))
+		dest := filepath.Join(workspaceDir, filepath.FromSlash(rel))
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		contents, err := os.ReadFile(src)
+		if err != nil {
+			return nil, err
+		}
+		// Leave binary files as they are
+		if utf8.Valid(contents) {
+			contents = []byte(replacer.Replace(string(contents)))
+		}
+		if err = os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return nil, err
+		}
+		if err = os.WriteFile(dest, contents, info.Mode().Perm()); err != nil {
+			return nil, err
+		}
+		slog.Info("wrote template file", "path", dest)
+	}
+	return files, nil
+}
+
+// isExcludedTemplatePath reports whether the file rel, relative to
+// the root of a Template, falls under one of excludedPaths; a path
+// ending in "/*" covers everything in that directory.
+func isExcludedTemplatePath(rel string, excludedPaths []string) bool {
+	for _, excluded := range excludedPaths {
+		if dir, ok := strings.CutSuffix(excluded, "/*"); ok {
+			if strings.HasPrefix(rel, dir+"/") {
+				return true
+			}
+		} else if rel == excluded {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package brig
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// parseTestTemplate parses the Template in testdata/templates/name.
+func parseTestTemplate(t *testing.T, name string) (string, *writ.DevcontainerTemplateParser) {
+	templateDir := filepath.Join("testdata", "templates", name)
+	p, err := writ.NewDevcontainerTemplateParser(filepath.Join(templateDir, TemplateConfigFile))
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+	return templateDir, p
+}
+
+// TestApplyTemplate checks that a Template's files are copied over
+// with its options substituted in, that optional paths can be left
+// out, and that existing files aren't overwritten.
+func TestApplyTemplate(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	templateDir, p := parseTestTemplate(t, "simple")
+	assert.NotNil(t, setTemplateOption(p, "installTools", "maybe"))
+	assert.Nil(t, setTemplateOption(p, "installTools", "true"))
+	assert.NotNil(t, setTemplateOption(p, "shell", "zsh"))
+
+	workspaceDir := t.TempDir()
+	written, err := applyTemplate(templateDir, workspaceDir, p.OptionValues(), nil)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{".devcontainer/devcontainer.json", ".github/workflows/ci.yml"}, written)
+	assert.NoFileExists(t, filepath.Join(workspaceDir, TemplateConfigFile))
+	assert.NoFileExists(t, filepath.Join(workspaceDir, "README.md"))
+
+	contents, err := os.ReadFile(filepath.Join(workspaceDir, ".devcontainer", "devcontainer.json"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(contents), `"image": "alpine:3.22"`)
+	assert.Contains(t, string(contents), `"INSTALL_TOOLS": "true"`)
+	assert.Contains(t, string(contents), `"SHELL": "/bin/ash"`)
+
+	// Applying it again would clobber the files written the first time
+	_, err = applyTemplate(templateDir, workspaceDir, p.OptionValues(), nil)
+	assert.ErrorContains(t, err, ".devcontainer/devcontainer.json")
+
+	workspaceDir = t.TempDir()
+	written, err = applyTemplate(templateDir, workspaceDir, p.OptionValues(), []string{".github/*"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{".devcontainer/devcontainer.json"}, written)
+	assert.NoDirExists(t, filepath.Join(workspaceDir, ".github"))
+}
+
+// TestPromptTemplate checks that options not given on the command
+// line are asked for, with empty answers keeping the defaults, and
+// that optional paths can be declined.
+func TestPromptTemplate(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	_, p := parseTestTemplate(t, "simple")
+	var out strings.Builder
+	// imageVariant is taken as given; installTools is left at its
+	// default; shell is asked for again after an invalid answer
+	excluded, err := promptTemplate(strings.NewReader("\nzsh\nbash\nn\n"), &out, p, map[string]bool{"imageVariant": true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{".github/*"}, excluded)
+	assert.Equal(t, map[string]string{
+		"imageVariant": "3.22",
+		"installTools": "false",
+		"shell":        "bash",
+	}, p.OptionValues())
+	assert.NotContains(t, out.String(), "imageVariant [")
+	assert.Contains(t, out.String(), "one of: ash, bash")
+
+	_, p = parseTestTemplate(t, "simple")
+	_, err = promptTemplate(strings.NewReader("\n"), &out, p, nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,2 @@
+#!/bin/sh
+echo "${FAVORITE}" > /usr/local/share/favorite-color
@@ -0,0 +1,3 @@
+#!/bin/sh
+printf '#!/bin/sh\necho hello\n' > /usr/local/bin/hello
+chmod +x /usr/local/bin/hello
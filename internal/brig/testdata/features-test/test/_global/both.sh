@@ -0,0 +1,6 @@
+#!/bin/bash
+set -e
+source dev-container-features-test-lib
+check "color" grep red /usr/local/share/favorite-color
+check "hello" hello
+reportResults
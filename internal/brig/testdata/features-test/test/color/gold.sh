@@ -0,0 +1,5 @@
+#!/bin/bash
+set -e
+source dev-container-features-test-lib
+check "gold" grep gold /usr/local/share/favorite-color
+reportResults
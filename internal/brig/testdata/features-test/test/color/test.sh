@@ -0,0 +1,5 @@
+#!/bin/bash
+set -e
+source dev-container-features-test-lib
+check "default color" grep red /usr/local/share/favorite-color
+reportResults
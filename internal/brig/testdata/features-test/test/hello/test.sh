@@ -0,0 +1,5 @@
+#!/bin/bash
+set -e
+source dev-container-features-test-lib
+check "hello" hello
+reportResults
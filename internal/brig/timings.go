@@ -0,0 +1,169 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// timingSpan is a single phase of a run timed with --timings.
+type timingSpan struct {
+	name     string
+	depth    int // How many spans it's nested in
+	start    time.Time
+	duration time.Duration // Zero until the span ends
+	failed   bool
+	ended    bool
+}
+
+// timingDepthKey is the context key the depth of the span a context
+// was started for is kept under.
+type timingDepthKey struct{}
+
+// timingRecorder collects the spans of a run for the report --timings
+// prints on exit; nothing about them leaves the host.
+type timingRecorder struct {
+	start time.Time
+	spans []*timingSpan
+	mu    sync.Mutex // Guards spans
+}
+
+// newTimingRecorder returns a timingRecorder whose total is measured
+// from now.
+func newTimingRecorder() *timingRecorder {
+	return &timingRecorder{start: time.Now()}
+}
+
+// startSpan records the start of the phase named name, nested in the
+// span ctx was started for, if any; it's a trill.SpanRecorder.
+func (r *timingRecorder) startSpan(ctx context.Context, name string) (context.Context, func(err error)) {
+	depth, _ := ctx.Value(timingDepthKey{}).(int)
+	span := &timingSpan{name: name, depth: depth, start: time.Now()}
+	r.mu.Lock()
+	r.spans = append(r.spans, span)
+	r.mu.Unlock()
+
+	return context.WithValue(ctx, timingDepthKey{}, depth+1), func(err error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		span.duration = time.Since(span.start)
+		span.failed = err != nil
+		span.ended = true
+	}
+}
+
+// addSpan records a phase that was timed by other means.
+func (r *timingRecorder) addSpan(name string, start time.Time, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, &timingSpan{
+		name:     name,
+		start:    start,
+		duration: time.Since(start),
+		failed:   err != nil,
+		ended:    true,
+	})
+}
+
+// writeReport writes out how long each span took, in the order they
+// were started, indented by how deeply they're nested, followed by
+// how long the whole run took.
+func (r *timingRecorder) writeReport(w io.Writer) {
+	r.mu.Lock()
+	spans := slices.Clone(r.spans)
+	r.mu.Unlock()
+	slices.SortStableFunc(spans, func(a, b *timingSpan) int {
+		return a.start.Compare(b.start)
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Timings:")
+	for _, span := range spans {
+		note := ""
+		switch {
+		case !span.ended:
+			note = " (unfinished)"
+		case span.failed:
+			note = " (failed)"
+		}
+		fmt.Fprintf(tw, "%s\t  %s%s%s\n", formatTiming(span.duration), strings.Repeat("  ", span.depth), span.name, note)
+	}
+	fmt.Fprintf(tw, "%s\t  %s\n", formatTiming(time.Since(r.start)), "total")
+	_ = tw.Flush()
+}
+
+// formatTiming rounds d to a precision that's useful at a glance.
+func formatTiming(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(time.Millisecond).String()
+	default:
+		return d.Round(time.Microsecond).String()
+	}
+}
+
+// startSpan records the start of the phase named name with the
+// timing recorder if --timings is in effect, and as a trace span if
+// spans are being exported; see setUpTracing. It's also the
+// trill.SpanRecorder the trill client is given.
+func (cmd *Command) startSpan(ctx context.Context, name string) (context.Context, func(err error)) {
+	endTiming := func(error) {}
+	if cmd.timings != nil {
+		ctx, endTiming = cmd.timings.startSpan(ctx, name)
+	}
+	var span trace.Span
+	if cmd.tracing != nil {
+		ctx, span = cmd.tracing.startSpan(ctx, name)
+	}
+	return ctx, func(err error) {
+		endTiming(err)
+		if span != nil {
+			endSpan(span, err)
+		}
+	}
+}
+
+// recordSpan records a phase that started at start and has just
+// ended, but was timed by other means (e.g., a lifecycle command).
+func (cmd *Command) recordSpan(name string, start time.Time, err error) {
+	if cmd.timings != nil {
+		cmd.timings.addSpan(name, start, err)
+	}
+	if cmd.tracing != nil {
+		_, span := cmd.tracing.startSpan(context.Background(), name, trace.WithTimestamp(start))
+		endSpan(span, err)
+	}
+}
+
+// writeTimings prints out the timing report if --timings is in
+// effect.
+func (cmd *Command) writeTimings() {
+	if cmd.timings != nil {
+		cmd.timings.writeReport(cmd.stderr())
+	}
+}
@@ -0,0 +1,55 @@
+package brig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTimingRecorder checks that spans are reported in the order
+// they were started, indented by how deeply they're nested, and
+// flagged if they failed or never ended.
+func TestTimingRecorder(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	r := newTimingRecorder()
+	ctx, endBuild := r.startSpan(context.Background(), "build image")
+	_, endArchive := r.startSpan(ctx, "archive build context")
+	endArchive(nil)
+	endBuild(errors.New("failed"))
+	r.addSpan("postCreateCommand", time.Now(), nil)
+	r.startSpan(context.Background(), "attach")
+
+	var buf bytes.Buffer
+	r.writeReport(&buf)
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	assert.Len(t, lines, 6)
+	assert.Equal(t, "Timings:", lines[0])
+	assert.True(t, strings.HasSuffix(lines[1], "  build image (failed)"))
+	assert.True(t, strings.HasSuffix(lines[2], "    archive build context"))
+	assert.True(t, strings.HasSuffix(lines[3], "  postCreateCommand"))
+	assert.True(t, strings.HasSuffix(lines[4], "  attach (unfinished)"))
+	assert.True(t, strings.HasSuffix(lines[5], "  total"))
+
+	// Without --timings, spans are no-ops
+	cmd := &Command{}
+	spanCtx, end := cmd.startSpan(context.Background(), "nothing")
+	assert.Equal(t, context.Background(), spanCtx)
+	end(nil)
+}
+
+// TestFormatTiming checks that durations are rounded to a precision
+// that suits their magnitude.
+func TestFormatTiming(t *testing.T) {
+	assert.Equal(t, "1.23s", formatTiming(1234567890*time.Nanosecond))
+	assert.Equal(t, "12ms", formatTiming(12345678*time.Nanosecond))
+	assert.Equal(t, "123µs", formatTiming(123456*time.Nanosecond))
+}
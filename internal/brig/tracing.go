@@ -0,0 +1,162 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingShutdownTimeout is how long brig waits for the spans it's
+// yet to export to be sent off on exit.
+const TracingShutdownTimeout = 5 * time.Second
+
+// tracing holds what's needed to export spans over OTLP; see
+// setUpTracing.
+type tracing struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	rootSpan trace.Span // Covers the whole run; every other span is nested in it
+}
+
+// tracingEnabled reports whether spans are to be exported, based on
+// the standard OTEL_* environment variables: it's only the case if
+// OTEL_TRACES_EXPORTER is "otlp", or if it's unset and an OTLP
+// endpoint is, unless OTEL_SDK_DISABLED is "true".
+func tracingEnabled(getenv func(string) string) bool {
+	if strings.EqualFold(getenv("OTEL_SDK_DISABLED"), "true") {
+		return false
+	}
+	switch exporter := strings.TrimSpace(getenv("OTEL_TRACES_EXPORTER")); exporter {
+	case "":
+		return len(getenv("OTEL_EXPORTER_OTLP_ENDPOINT")) > 0 || len(getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")) > 0
+	case "otlp":
+		return true
+	case "none":
+		return false
+	default:
+		slog.Warn("ignoring unsupported trace exporter; only otlp is", "exporter", exporter)
+		return false
+	}
+}
+
+// setUpTracing has spans exported over OTLP if the environment asks
+// for it; see tracingEnabled. The exporter is configured with the
+// usual OTEL_EXPORTER_OTLP_* variables, and the resource the spans
+// are attributed to with OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES.
+//
+// The provider is also made the global one, which the engine client
+// uses to trace every REST call it makes.
+func (cmd *Command) setUpTracing() error {
+	if !tracingEnabled(os.Getenv) {
+		return nil
+	}
+	if protocol := tracingProtocol(os.Getenv); protocol != "http/protobuf" {
+		return fmt.Errorf("unsupported OTLP protocol %q; only http/protobuf is", protocol)
+	}
+
+	ctx := context.Background()
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to set up the OTLP trace exporter: %w", err)
+	}
+	// Later detectors win, so the environment can override the name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", cmd.appName),
+			attribute.String("service.version", cmd.appVersion),
+		),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to describe the trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("encountered an error while exporting spans", "error", err)
+	}))
+
+	tracer := provider.Tracer(modulePath + "internal/brig")
+	_, rootSpan := tracer.Start(ctx, cmd.appName)
+	cmd.tracing = &tracing{
+		provider: provider,
+		tracer:   tracer,
+		rootSpan: rootSpan,
+	}
+	slog.Debug("exporting spans over OTLP")
+	return nil
+}
+
+// tracingProtocol returns the OTLP protocol the environment asks
+// spans to be exported with, defaulting to http/protobuf.
+func tracingProtocol(getenv func(string) string) string {
+	for _, key := range []string{"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"} {
+		if protocol := strings.TrimSpace(getenv(key)); len(protocol) > 0 {
+			return protocol
+		}
+	}
+	return "http/protobuf"
+}
+
+// startSpan starts the span named name, nested in the one ctx carries
+// or, failing that, in the one covering the whole run.
+func (t *tracing) startSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = trace.ContextWithSpan(ctx, t.rootSpan)
+	}
+	return t.tracer.Start(ctx, name, opts...)
+}
+
+// endSpan ends span, marking it as failed if err is non-nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// shutDown ends the span covering the whole run, and waits for every
+// span to be exported.
+func (t *tracing) shutDown() error {
+	t.rootSpan.End()
+	ctx, cancel := context.WithTimeout(context.Background(), TracingShutdownTimeout)
+	defer cancel()
+	if err := t.provider.Shutdown(ctx); err != nil {
+		return fmt.Errorf("unable to export spans: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package brig
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestTracingEnabled checks that spans are only exported if the
+// environment asks for it.
+func TestTracingEnabled(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+	assert.False(t, tracingEnabled(env(nil)))
+	assert.True(t, tracingEnabled(env(map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318"})))
+	assert.True(t, tracingEnabled(env(map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://localhost:4318/v1/traces"})))
+	assert.True(t, tracingEnabled(env(map[string]string{"OTEL_TRACES_EXPORTER": "otlp"})))
+	assert.False(t, tracingEnabled(env(map[string]string{"OTEL_TRACES_EXPORTER": "none", "OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318"})))
+	assert.False(t, tracingEnabled(env(map[string]string{"OTEL_TRACES_EXPORTER": "zipkin"})))
+	assert.False(t, tracingEnabled(env(map[string]string{"OTEL_SDK_DISABLED": "TRUE", "OTEL_TRACES_EXPORTER": "otlp"})))
+
+	assert.Equal(t, "http/protobuf", tracingProtocol(env(nil)))
+	assert.Equal(t, "grpc", tracingProtocol(env(map[string]string{"OTEL_EXPORTER_OTLP_PROTOCOL": "grpc"})))
+	assert.Equal(t, "http/protobuf", tracingProtocol(env(map[string]string{"OTEL_EXPORTER_OTLP_PROTOCOL": "grpc", "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL": "http/protobuf"})))
+}
+
+// TestTracingSpans checks that spans are nested in the one covering
+// the whole run unless started in another, and that failures are
+// recorded.
+func TestTracingSpans(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := provider.Tracer("test")
+	_, rootSpan := tracer.Start(context.Background(), "brig")
+	cmd := &Command{tracing: &tracing{provider: provider, tracer: tracer, rootSpan: rootSpan}}
+
+	ctx, endBuild := cmd.startSpan(context.Background(), "build image")
+	_, endArchive := cmd.startSpan(ctx, "archive build context")
+	endArchive(nil)
+	endBuild(errors.New("failed"))
+	cmd.recordSpan("POSTCREATE devcontainer", time.Now().Add(-time.Second), nil)
+	rootSpan.End()
+
+	// The exporter forgets its spans when it's shut down
+	spans := exporter.GetSpans().Snapshots()
+	assert.Nil(t, cmd.tracing.shutDown())
+	assert.Len(t, spans, 4)
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		byName[span.Name()] = span
+	}
+	root := byName["brig"].SpanContext().SpanID()
+	assert.Equal(t, root, byName["build image"].Parent().SpanID())
+	assert.Equal(t, byName["build image"].SpanContext().SpanID(), byName["archive build context"].Parent().SpanID())
+	assert.Equal(t, root, byName["POSTCREATE devcontainer"].Parent().SpanID())
+	assert.Equal(t, codes.Error, byName["build image"].Status().Code)
+	assert.Equal(t, codes.Unset, byName["archive build context"].Status().Code)
+	assert.GreaterOrEqual(t, byName["POSTCREATE devcontainer"].EndTime().Sub(byName["POSTCREATE devcontainer"].StartTime()), time.Second)
+}
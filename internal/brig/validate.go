@@ -0,0 +1,120 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/nlsantos/brig/writ"
+)
+
+// runValidate implements `brig validate [PATH]`, which checks the
+// devcontainer.json (with --override-config merged in) against the
+// spec, pointing out where in the file whatever's wrong with it is,
+// without bringing the devcontainer up.
+func (cmd *Command) runValidate(args []string) ExitCode {
+	if len(args) > 1 {
+		fmt.Fprintf(cmd.stderr(), "usage: %s validate [PATH]\n", cmd.appName)
+		return ExitErrorParsingFlags
+	}
+
+	configPath := cmd.findDevcontainerJSON(args)
+	parser, err := writ.NewDevcontainerParser(configPath)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to read devcontainer.json: %s\n", err)
+		return ExitNonValidDevcontainerJSON
+	}
+	parser.LocalWorkspaceFolder = workspaceForDevcontainerJSON(args, configPath)
+	if len(cmd.Options.OverrideConfig) > 0 {
+		if err = parser.ApplyOverlay(cmd.Options.OverrideConfig); err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to apply the override config: %s\n", err)
+			return ExitNonValidDevcontainerJSON
+		}
+	}
+	if err = parser.Validate(); err != nil {
+		writeValidationReport(cmd.stderr(), &parser.Parser, err)
+		return ExitNonValidDevcontainerJSON
+	}
+	if err = parser.Parse(); err != nil {
+		fmt.Fprintf(cmd.stderr(), "%s: %s\n", parser.Filepath, err)
+		return ExitNonValidDevcontainerJSON
+	}
+	fmt.Fprintf(cmd.stdout(), "%s: valid\n", parser.Filepath)
+	return ExitNormal
+}
+
+// writeValidationReport writes out what's wrong with the config
+// parser is for, as told by err, an error returned by its Validate
+// method, to w, in the format compilers use: where each problem is,
+// the line it's on with the spot marked, and a likely fix, if there's
+// one, e.g.:
+//
+//	/src/.devcontainer/devcontainer.json:4:3: error: unknown property "imagee"
+//	   4 |   "imagee": "alpine",
+//	     |   ^
+//	     = did you mean "image"?
+func writeValidationReport(w io.Writer, parser *writ.Parser, err error) {
+	problems := parser.ValidationProblems(err)
+	if len(problems) == 0 {
+		fmt.Fprintf(w, "%s: error: %s\n", parser.Filepath, err)
+		return
+	}
+
+	gutter := 0
+	for _, problem := range problems {
+		gutter = max(gutter, len(strconv.Itoa(problem.Line)))
+	}
+	for _, problem := range problems {
+		if problem.Line == 0 {
+			fmt.Fprintf(w, "%s: error: %s: %s\n", parser.Filepath, problem.Pointer, problem.Message)
+		} else {
+			fmt.Fprintf(w, "%s:%d:%d: error: %s\n", parser.Filepath, problem.Line, problem.Column, problem.Message)
+			fmt.Fprintf(w, " %*d | %s\n", gutter, problem.Line, problem.Source)
+			fmt.Fprintf(w, " %*s | %s^\n", gutter, "", caretIndent(problem.Source, problem.Column))
+		}
+		if len(problem.Suggestion) > 0 {
+			fmt.Fprintf(w, " %*s = %s\n", gutter, "", problem.Suggestion)
+		}
+	}
+	noun := "problems"
+	if len(problems) == 1 {
+		noun = "problem"
+	}
+	fmt.Fprintf(w, "%d %s found in %s\n", len(problems), noun, parser.Filepath)
+}
+
+// caretIndent returns what goes before the caret marking column (in
+// characters, counting from 1) of line, so that it lines up with it
+// when printed below it: tabs are kept, so they take up as much room
+// as they do in line, and everything else is replaced with a space.
+func caretIndent(line string, column int) string {
+	var b strings.Builder
+	for i, r := range []rune(line) {
+		if i >= column-1 {
+			break
+		}
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,57 @@
+package brig
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRunValidate checks that problems with a devcontainer.json are
+// reported where they are in the file, comments and all, with likely
+// fixes suggested, and that a valid one is reported as such.
+func TestRunValidate(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	configPath := filepath.Join(t.TempDir(), "devcontainer.json")
+	assert.Nil(t, os.WriteFile(configPath, []byte(`{
+	// Misspelled
+	"imagee": "alpine",
+	"forwardPorts": [3000, true],
+}
+`), 0o644))
+
+	var stdout, stderr bytes.Buffer
+	cmd := New("brig", "")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Arguments = []string{"validate", configPath}
+	exitCode, ok := cmd.runSubcommand()
+	assert.True(t, ok)
+	assert.Equal(t, ExitNonValidDevcontainerJSON, exitCode)
+	assert.Empty(t, stdout.String())
+	assert.Equal(t, configPath+`:1:1: error: missing property 'image'
+ 1 | {
+   | ^
+`+configPath+`:3:2: error: unknown property "imagee"
+ 3 | 	"imagee": "alpine",
+   | 	^
+   = did you mean "image"?
+`+configPath+`:4:25: error: got boolean, want integer or string
+ 4 | 	"forwardPorts": [3000, true],
+   | 	                       ^
+3 problems found in `+configPath+"\n", stderr.String())
+
+	assert.Nil(t, os.WriteFile(configPath, []byte(`{"image": "alpine"}`), 0o644))
+	stdout.Reset()
+	stderr.Reset()
+	exitCode, _ = cmd.runSubcommand()
+	assert.Equal(t, ExitNormal, exitCode)
+	assert.Equal(t, configPath+": valid\n", stdout.String())
+	assert.Empty(t, stderr.String())
+}
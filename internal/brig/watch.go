@@ -0,0 +1,166 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/moby/moby/api/types/events"
+	"github.com/nlsantos/brig/writ"
+	"github.com/pborman/options"
+)
+
+// WatchRestartMaxDelay is the longest `brig watch --restart` waits
+// before restarting a devcontainer that keeps dying; the delay starts
+// at watchRestartDelay, and doubles each time it dies within this long
+// of being restarted.
+const WatchRestartMaxDelay = time.Minute
+
+// watchRestartDelay is how long `brig watch --restart` waits before
+// restarting a devcontainer that's died, at first. It gives whatever
+// stopped it (e.g., a brig instance tearing it down) time to finish.
+var watchRestartDelay = time.Second
+
+// WatchOptions are the flags `brig watch` takes, on top of the global
+// ones.
+type WatchOptions struct {
+	Restart bool `getopt:"--restart restart the devcontainer, and rerun its postStartCommand, whenever it dies"`
+}
+
+// runWatch implements `brig watch [--restart] [PATH]`, which prints
+// out changes to the state of the devcontainer's container (e.g., it
+// dying, or turning unhealthy) as they happen, until it's removed or
+// brig is interrupted.
+func (cmd *Command) runWatch(args []string) ExitCode {
+	opts := WatchOptions{}
+	args, err := options.SubRegisterAndParse(&opts, append([]string{"watch"}, args...))
+	if err != nil || len(args) > 1 {
+		if err != nil {
+			fmt.Fprintln(cmd.stderr(), err)
+		}
+		fmt.Fprintf(cmd.stderr(), "usage: %s watch [--restart] [PATH]\n", cmd.appName)
+		return ExitErrorParsingFlags
+	}
+
+	parser, err := cmd.loadDevcontainerJSON(args)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to parse devcontainer.json: %s\n", err)
+		return ExitNonValidDevcontainerJSON
+	}
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	if opts.Restart {
+		// The Features' postStartCommands are rerun as well
+		if err = cmd.ParseFeaturesConfig(ctx, parser, parser.Config.Features); err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to prepare features: %s\n", err)
+			return ExitFeaturesFailed
+		}
+	}
+
+	if cmd.trillClient == nil {
+		if err = cmd.Connect(cmd.Options.Socket); err != nil {
+			fmt.Fprintf(cmd.stderr(), "unable to reach Podman/Docker: %s\n", err)
+			return exitCodeForError(err)
+		}
+	}
+	containerName := devcontainerContainerName(parser)
+	if _, err = cmd.trillClient.IsContainerRunning(ctx, containerName); err != nil {
+		fmt.Fprintf(cmd.stderr(), "unable to find the devcontainer %s: %s\n", containerName, err)
+		return ExitError
+	}
+	if err = cmd.watch(ctx, parser, containerName, opts.Restart); err != nil {
+		fmt.Fprintf(cmd.stderr(), "stopped watching %s: %s\n", containerName, err)
+		return ExitError
+	}
+	return ExitNormal
+}
+
+// watch prints out the events for the container named containerName
+// until it's removed or ctx is cancelled; with restart, the container
+// is restarted whenever it dies.
+func (cmd *Command) watch(ctx context.Context, p *writ.DevcontainerParser, containerName string, restart bool) error {
+	containerEvents, errs := cmd.trillClient.ContainerEvents(ctx, containerName)
+	delay := watchRestartDelay
+	var lastRestart time.Time
+	for event := range containerEvents {
+		fmt.Fprintf(cmd.stdout(), "%s %s\n", event.Time.Format(time.TimeOnly), event)
+		switch {
+		case event.Action == events.ActionDestroy:
+			slog.Info("the devcontainer has been removed; nothing left to watch", "name", containerName)
+			return nil
+
+		case event.Died() && restart:
+			// Start over if it stayed up for a while
+			if time.Since(lastRestart) > WatchRestartMaxDelay {
+				delay = watchRestartDelay
+			}
+			if err := cmd.restartDevcontainer(ctx, p, containerName, delay); err != nil {
+				return err
+			}
+			lastRestart = time.Now()
+			delay = min(2*delay, WatchRestartMaxDelay)
+		}
+	}
+	if err := <-errs; err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// restartDevcontainer starts the container named containerName again
+// after waiting for delay, then reruns its postStartCommand.
+//
+// It's left alone if, by then, it's been removed, or something else
+// has restarted it. A failing postStartCommand is only reported.
+func (cmd *Command) restartDevcontainer(ctx context.Context, p *writ.DevcontainerParser, containerName string, delay time.Duration) error {
+	slog.Info("the devcontainer died; restarting it", "name", containerName, "delay", delay)
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-time.After(delay):
+	}
+
+	running, err := cmd.trillClient.IsContainerRunning(ctx, containerName)
+	if err != nil {
+		slog.Info("the devcontainer can no longer be inspected; not restarting it", "name", containerName, "error", err)
+		return nil
+	} else if running {
+		slog.Info("the devcontainer has already been restarted", "name", containerName)
+		return nil
+	}
+	if err = cmd.trillClient.StartContainer(ctx, containerName); err != nil {
+		return err
+	}
+
+	if p.Config.PostStartCommand == nil {
+		return nil
+	}
+	if err = cmd.trillClient.UseRunningDevcontainer(ctx, p, containerName); err != nil {
+		return err
+	}
+	if err = cmd.runLifecycleCommand(ctx, "POSTSTART", "", p.Config.PostStartCommand, p, false); err != nil {
+		slog.Error("the devcontainer's postStartCommand failed", "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package brig
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/trill"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWatchRestart checks that `brig watch --restart` reports the
+// devcontainer's state changes, restarts it when it dies and reruns
+// its postStartCommand, and stops once it's removed.
+func TestWatchRestart(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	defaultDelay := watchRestartDelay
+	watchRestartDelay = time.Millisecond
+	t.Cleanup(func() { watchRestartDelay = defaultDelay })
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, ".devcontainer", "devcontainer.json")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(configPath), 0o755))
+	assert.Nil(t, os.WriteFile(configPath, []byte(`{"image": "alpine", "postStartCommand": "echo started"}`), 0o644))
+
+	var stdout bytes.Buffer
+	cmd := New("brig", "")
+	cmd.Stdout = &stdout
+	cmd.Stderr = io.Discard
+	parser, err := cmd.loadDevcontainerJSON([]string{configPath})
+	assert.Nil(t, err)
+	containerName := devcontainerContainerName(parser)
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("alpine", nil)
+	_, err = engine.ContainerCreate(context.Background(), mobyclient.ContainerCreateOptions{
+		Name:   containerName,
+		Config: &container.Config{Image: "alpine"},
+	})
+	assert.Nil(t, err)
+	_, err = engine.ContainerStart(context.Background(), containerName, mobyclient.ContainerStartOptions{})
+	assert.Nil(t, err)
+	cmd.trillClient, err = trill.NewClient(trill.ClientOptions{SocketAddr: "fake://engine", Engine: engine})
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.watch(ctx, parser, containerName, true)
+	}()
+	assert.Eventually(t, func() bool { return len(engine.CallsTo("Events")) > 0 }, 5*time.Second, time.Millisecond)
+
+	_, err = engine.ContainerStop(context.Background(), containerName, mobyclient.ContainerStopOptions{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return len(engine.CallsTo("ExecCreate")) > 0 }, 5*time.Second, time.Millisecond)
+	running, err := cmd.trillClient.IsContainerRunning(context.Background(), containerName)
+	assert.Nil(t, err)
+	assert.True(t, running)
+	opts := engine.CallsTo("ExecCreate")[0].Options.(mobyclient.ExecCreateOptions)
+	assert.Contains(t, strings.Join(opts.Cmd, " "), "echo started")
+
+	_, err = engine.ContainerRemove(context.Background(), containerName, mobyclient.ContainerRemoveOptions{Force: true})
+	assert.Nil(t, err)
+	select {
+	case err = <-done:
+		assert.Nil(t, err)
+	case <-ctx.Done():
+		t.Fatal("watch didn't stop once the devcontainer was removed")
+	}
+
+	var actions []string
+	for line := range strings.Lines(stdout.String()) {
+		if _, event, ok := strings.Cut(strings.TrimSpace(line), containerName+": "); ok {
+			actions = append(actions, event)
+		}
+	}
+	assert.Equal(t, []string{"die (exit code 0)", "stop", "start", "destroy"}, actions)
+}
@@ -0,0 +1,188 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package brig houses a CLI tool for working with devcontainer.json
+package brig
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrWorkspaceLocked is returned when another brig instance is using
+// the same workspace, and --wait-for-lock isn't in effect.
+var ErrWorkspaceLocked = errors.New("workspace is in use by another brig instance")
+
+// WorkspaceLockDir is the subdirectory of the cache directory the
+// workspace lock files are kept in.
+const WorkspaceLockDir = "locks"
+
+// workspaceLockHolder describes the brig instance holding a workspace
+// lock; it's written into the lock file so that others can tell who
+// they're waiting on.
+type workspaceLockHolder struct {
+	PID       int       `json:"pid"`
+	Hostname  string    `json:"hostname"`
+	Workspace string    `json:"workspace"`
+	Since     time.Time `json:"since"`
+}
+
+// String returns a short description of h for messages.
+func (h workspaceLockHolder) String() string {
+	return fmt.Sprintf("PID %d on %s, since %s", h.PID, h.Hostname, h.Since.Local().Format(time.DateTime))
+}
+
+// workspaceLockPath returns the path to the lock file for
+// workspaceDir in lockDir; the workspace's absolute path is hashed,
+// so that every way of referring to it maps to the same file.
+func workspaceLockPath(lockDir string, workspaceDir string) (string, error) {
+	absWorkspaceDir, err := filepath.Abs(workspaceDir)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(absWorkspaceDir); err == nil {
+		absWorkspaceDir = resolved
+	}
+	hash := sha256.Sum256([]byte(absWorkspaceDir))
+	return filepath.Join(lockDir, hex.EncodeToString(hash[:16])+".lock"), nil
+}
+
+// acquireWorkspaceLock locks the file at lockPath on behalf of the
+// instance described by holder, which is then recorded in it.
+//
+// If another instance holds the lock, it either returns
+// ErrWorkspaceLocked, or waits for it to be released if wait is true.
+//
+// The lock itself is held by the operating system, which releases it
+// once the process holding it exits, however it exits. A lock file
+// that still describes a holder when it's acquired was left behind by
+// an instance that crashed, and is reported as stale, then reused.
+func acquireWorkspaceLock(lockPath string, holder workspaceLockHolder, wait bool) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(lockPath), fs.ModeDir|0o755); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := tryLockFile(lock)
+	if err == nil && !locked {
+		other, readErr := readWorkspaceLockHolder(lock)
+		if !wait {
+			_ = lock.Close()
+			if readErr != nil {
+				return nil, ErrWorkspaceLocked
+			}
+			return nil, fmt.Errorf("%w (%s)", ErrWorkspaceLocked, other)
+		}
+		if readErr == nil {
+			slog.Info("waiting for another brig instance to release the workspace", "holder", other.String())
+			fmt.Fprintf(os.Stderr, "Waiting for another brig instance (%s) to release the workspace...\n", other)
+		} else {
+			fmt.Fprintln(os.Stderr, "Waiting for another brig instance to release the workspace...")
+		}
+		err = lockFile(lock)
+	}
+	if err != nil {
+		_ = lock.Close()
+		return nil, err
+	}
+
+	if stale, err := readWorkspaceLockHolder(lock); err == nil {
+		slog.Warn("reusing a stale workspace lock left behind by an instance that didn't exit cleanly", "path", lockPath, "holder", stale.String())
+	}
+	if err = writeWorkspaceLockHolder(lock, &holder); err != nil {
+		return nil, errors.Join(err, releaseWorkspaceLock(lock))
+	}
+	slog.Debug("acquired workspace lock", "path", lockPath)
+	return lock, nil
+}
+
+// releaseWorkspaceLock empties the lock file, so that it isn't taken
+// for a stale one, then releases the lock on it.
+//
+// The file itself is left in place, as removing it would let another
+// instance lock a new file by the same name while one waiting on the
+// old one acquires it too.
+func releaseWorkspaceLock(lock *os.File) error {
+	err := writeWorkspaceLockHolder(lock, nil)
+	err = errors.Join(err, unlockFile(lock))
+	return errors.Join(err, lock.Close())
+}
+
+// readWorkspaceLockHolder reads the description of the instance
+// holding lock out of it; it fails if there's none.
+func readWorkspaceLockHolder(lock *os.File) (holder workspaceLockHolder, err error) {
+	contents, err := io.ReadAll(io.NewSectionReader(lock, 0, 1<<16))
+	if err != nil {
+		return holder, err
+	}
+	if len(contents) == 0 {
+		return holder, fs.ErrNotExist
+	}
+	err = json.Unmarshal(contents, &holder)
+	return holder, err
+}
+
+// writeWorkspaceLockHolder replaces the contents of lock with a
+// description of holder, or empties it if holder is nil.
+func writeWorkspaceLockHolder(lock *os.File, holder *workspaceLockHolder) error {
+	if err := lock.Truncate(0); err != nil {
+		return err
+	}
+	if holder == nil {
+		return nil
+	}
+	contents, err := json.Marshal(holder)
+	if err != nil {
+		return err
+	}
+	_, err = lock.WriteAt(contents, 0)
+	return err
+}
+
+// lockWorkspace keeps other brig instances from bringing up a
+// devcontainer for workspaceDir until this one exits, as they'd race
+// on the synthesized Containerfiles, the Feature cache, and the names
+// of the containers; see acquireWorkspaceLock.
+func (cmd *Command) lockWorkspace(workspaceDir string) error {
+	cacheDir, err := cmd.getCacheDirectory()
+	if err != nil {
+		return err
+	}
+	lockPath, err := workspaceLockPath(filepath.Join(cacheDir, WorkspaceLockDir), workspaceDir)
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	absWorkspaceDir, _ := filepath.Abs(workspaceDir)
+	cmd.workspaceLock, err = acquireWorkspaceLock(lockPath, workspaceLockHolder{
+		PID:       os.Getpid(),
+		Hostname:  hostname,
+		Workspace: absWorkspaceDir,
+		Since:     time.Now(),
+	}, cmd.Options.WaitForLock)
+	return err
+}
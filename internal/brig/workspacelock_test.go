@@ -0,0 +1,75 @@
+package brig
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWorkspaceLockPath checks that every way of referring to a
+// workspace maps to the same lock file, and different workspaces to
+// different ones.
+func TestWorkspaceLockPath(t *testing.T) {
+	workspaceDir := t.TempDir()
+	t.Chdir(workspaceDir)
+
+	absPath, err := workspaceLockPath("locks", workspaceDir)
+	assert.Nil(t, err)
+	relPath, err := workspaceLockPath("locks", ".")
+	assert.Nil(t, err)
+	assert.Equal(t, absPath, relPath)
+	assert.Equal(t, "locks", filepath.Dir(absPath))
+
+	otherPath, err := workspaceLockPath("locks", filepath.Join(workspaceDir, "other"))
+	assert.Nil(t, err)
+	assert.NotEqual(t, absPath, otherPath)
+}
+
+// TestAcquireWorkspaceLock checks that a workspace can only be locked
+// by one instance at a time, that whoever holds it is reported, and
+// that stale lock files don't get in the way.
+func TestAcquireWorkspaceLock(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	lockPath := filepath.Join(t.TempDir(), WorkspaceLockDir, "workspace.lock")
+	holder := workspaceLockHolder{PID: 1234, Hostname: "host", Workspace: "/src", Since: time.Now()}
+	lock, err := acquireWorkspaceLock(lockPath, holder, false)
+	assert.Nil(t, err)
+
+	_, err = acquireWorkspaceLock(lockPath, holder, false)
+	assert.True(t, errors.Is(err, ErrWorkspaceLocked))
+	assert.Contains(t, err.Error(), "PID 1234 on host")
+
+	// Waiting instances get the lock once it's released
+	acquired := make(chan error)
+	go func() {
+		waitingLock, err := acquireWorkspaceLock(lockPath, holder, true)
+		if err == nil {
+			err = releaseWorkspaceLock(waitingLock)
+		}
+		acquired <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, releaseWorkspaceLock(lock))
+	assert.Nil(t, <-acquired)
+
+	contents, err := os.ReadFile(lockPath)
+	assert.Nil(t, err)
+	assert.Empty(t, contents)
+
+	// Left behind by an instance that crashed
+	assert.Nil(t, os.WriteFile(lockPath, []byte(`{"pid":99999,"hostname":"host"}`), 0o644))
+	lock, err = acquireWorkspaceLock(lockPath, holder, false)
+	assert.Nil(t, err)
+	recorded, err := readWorkspaceLockHolder(lock)
+	assert.Nil(t, err)
+	assert.Equal(t, 1234, recorded.PID)
+	assert.Nil(t, releaseWorkspaceLock(lock))
+}
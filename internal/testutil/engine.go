@@ -0,0 +1,1225 @@
+/*
+   brig: The lightweight, native Go CLI for devcontainers
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package testutil houses helpers shared by brig's tests.
+package testutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"maps"
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/events"
+	"github.com/moby/moby/api/types/image"
+	"github.com/moby/moby/api/types/jsonstream"
+	"github.com/moby/moby/api/types/network"
+	"github.com/moby/moby/api/types/volume"
+	mobyclient "github.com/moby/moby/client"
+)
+
+// ErrNotFound is returned by FakeEngine when a container, image,
+// network, volume, or exec instance can't be found; like the errors
+// of the actual client, it satisfies errdefs.IsNotFound.
+var ErrNotFound = fmt.Errorf("no such object: %w", cerrdefs.ErrNotFound)
+
+// Call records a single call made to FakeEngine.
+type Call struct {
+	Method  string // Name of the method called, e.g., "ContainerCreate"
+	Target  string // The container/exec ID, image reference, or network/volume name the call was for, if any
+	Options any    // The options struct the method was passed
+}
+
+// ExecResult is what a command run through FakeEngine's exec methods
+// produces.
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Dropped  bool // If true, the output ends while the command's still running, as if the connection to the engine had dropped
+}
+
+// ExecHandler decides what running cmd inside the container
+// designated by containerID produces.
+type ExecHandler func(containerID string, cmd []string) ExecResult
+
+// FakeEngine is an in-memory stand-in for a Podman/Docker engine that
+// records the calls made to it.
+//
+// It keeps just enough state (containers, images, networks, volumes)
+// for the orchestration in trill to run against it. It's safe for
+// concurrent use.
+type FakeEngine struct {
+	// Called whenever a command is run through the exec methods; if
+	// nil, commands produce no output and exit with 0
+	ExecHandler ExecHandler
+	// Called whenever a container's output is attached to, with its
+	// entrypoint and command; if nil, containers produce no output
+	OutputHandler ExecHandler
+
+	mu         sync.Mutex
+	calls      []Call
+	errs       map[string]error
+	containers map[string]*container.InspectResponse // Container ID -> state
+	execs      map[string]*fakeExec                  // Exec ID -> state
+	files      map[string]map[string][]byte          // Container ID -> path -> contents
+	images     map[string]*dockerspec.DockerOCIImageConfig
+	networks   map[string]network.Inspect
+	volumes    map[string]volume.Volume
+	nextID     int
+
+	subscribers []*fakeSubscriber // Callers of Events
+}
+
+// fakeExec holds the state of an exec instance.
+type fakeExec struct {
+	containerID string
+	cmd         []string
+	tty         bool
+	exitCode    int
+	running     bool
+}
+
+// fakeEventsBuffer is how many events a caller of Events can fall
+// behind by before further ones are dropped.
+const fakeEventsBuffer = 64
+
+// fakeSubscriber is a caller of Events.
+type fakeSubscriber struct {
+	filters  mobyclient.Filters
+	messages chan events.Message
+}
+
+// matches reports whether msg passes the type and container filters
+// s subscribed with.
+func (s *fakeSubscriber) matches(msg events.Message) bool {
+	if types := s.filters["type"]; len(types) > 0 && !types[string(msg.Type)] {
+		return false
+	}
+	if containers := s.filters["container"]; len(containers) > 0 && !containers[msg.Actor.ID] && !containers[msg.Actor.Attributes["name"]] {
+		return false
+	}
+	return true
+}
+
+// NewFakeEngine returns a FakeEngine with no resources in it.
+func NewFakeEngine() *FakeEngine {
+	return &FakeEngine{
+		errs:       make(map[string]error),
+		containers: make(map[string]*container.InspectResponse),
+		execs:      make(map[string]*fakeExec),
+		files:      make(map[string]map[string][]byte),
+		images:     make(map[string]*dockerspec.DockerOCIImageConfig),
+		networks:   make(map[string]network.Inspect),
+		volumes:    make(map[string]volume.Volume),
+	}
+}
+
+// FailOn makes every subsequent call to method return err; passing a
+// nil err makes method succeed again.
+//
+// method can also be given as METHOD:TARGET (e.g.,
+// "ImagePull:alpine:3") to only fail calls for that target.
+func (f *FakeEngine) FailOn(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errs, method)
+		return
+	}
+	f.errs[method] = err
+}
+
+// Calls returns the calls made so far, in order.
+func (f *FakeEngine) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// CallsTo returns the calls made so far to method, in order.
+func (f *FakeEngine) CallsTo(method string) []Call {
+	var calls []Call
+	for _, call := range f.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// AddFile puts a file at filePath, an absolute path, in the container
+// designated by idOrName, as if it had been created in it.
+func (f *FakeEngine) AddFile(idOrName string, filePath string, contents []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ctr := f.findContainer(idOrName)
+	if ctr == nil {
+		return fmt.Errorf("container %s: %w", idOrName, ErrNotFound)
+	}
+	f.addFile(ctr.ID, filePath, contents)
+	return nil
+}
+
+// AddImage makes an image tagged ref available, as if it had been
+// pulled; cfg may be nil.
+func (f *FakeEngine) AddImage(ref string, cfg *dockerspec.DockerOCIImageConfig) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cfg == nil {
+		cfg = &dockerspec.DockerOCIImageConfig{}
+	}
+	f.images[ref] = cfg
+}
+
+// AddNetwork makes a network named name available, as if it had been
+// created outside of brig.
+func (f *FakeEngine) AddNetwork(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.networks[name] = network.Inspect{Network: network.Network{Name: name, ID: f.newID("network")}}
+}
+
+// AddVolume makes a volume named name available, as if it had been
+// created outside of brig.
+func (f *FakeEngine) AddVolume(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.volumes[name] = volume.Volume{Name: name}
+}
+
+// Container returns the state of the container designated by
+// idOrName, if it exists.
+func (f *FakeEngine) Container(idOrName string) (container.InspectResponse, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ctr := f.findContainer(idOrName)
+	if ctr == nil {
+		return container.InspectResponse{}, false
+	}
+	return *ctr, true
+}
+
+// Containers returns the IDs of the containers that currently exist.
+func (f *FakeEngine) Containers() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for id := range f.containers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// File returns the contents of the file at filePath in the container
+// designated by idOrName, if it exists.
+func (f *FakeEngine) File(idOrName string, filePath string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ctr := f.findContainer(idOrName)
+	if ctr == nil {
+		return nil, false
+	}
+	contents, ok := f.files[ctr.ID][path.Clean(filePath)]
+	return contents, ok
+}
+
+// EmitEvent sends msg to the callers of Events whose filters it
+// matches, e.g., to simulate a container turning healthy.
+func (f *FakeEngine) EmitEvent(msg events.Message) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.emit(msg)
+}
+
+// HasImage reports whether an image tagged ref exists.
+func (f *FakeEngine) HasImage(ref string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.images[ref]
+	return ok
+}
+
+// HasNetwork reports whether a network named name exists.
+func (f *FakeEngine) HasNetwork(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.networks[name]
+	return ok
+}
+
+// HasVolume reports whether a volume named name exists.
+func (f *FakeEngine) HasVolume(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.volumes[name]
+	return ok
+}
+
+// SetContainerState overwrites the state of the container designated
+// by idOrName, e.g., to simulate it exiting or turning healthy.
+//
+// The events the engine would send for the change (start, die, and
+// health_status) are sent to the callers of Events.
+func (f *FakeEngine) SetContainerState(idOrName string, state container.State) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ctr := f.findContainer(idOrName)
+	if ctr == nil {
+		return fmt.Errorf("container %s: %w", idOrName, ErrNotFound)
+	}
+	prev := ctr.State
+	ctr.State = &state
+	wasRunning := prev != nil && prev.Running
+	switch {
+	case !wasRunning && state.Running:
+		f.emitContainerEvent(ctr, events.ActionStart)
+	case wasRunning && !state.Running:
+		f.emitContainerEvent(ctr, events.ActionDie)
+	}
+	var prevHealth container.HealthStatus
+	if prev != nil && prev.Health != nil {
+		prevHealth = prev.Health.Status
+	}
+	if state.Health != nil && state.Health.Status != prevHealth && state.Health.Status != container.NoHealthcheck {
+		f.emitContainerEvent(ctr, events.Action(fmt.Sprintf("%s: %s", events.ActionHealthStatus, state.Health.Status)))
+	}
+	return nil
+}
+
+// record notes a call to method and returns the error it should fail
+// with, if any.
+//
+// f.mu must be held.
+func (f *FakeEngine) record(method string, target string, options any) error {
+	f.calls = append(f.calls, Call{Method: method, Target: target, Options: options})
+	if err, ok := f.errs[method+":"+target]; ok {
+		return err
+	}
+	return f.errs[method]
+}
+
+// newID returns a unique, fake object ID.
+//
+// f.mu must be held.
+func (f *FakeEngine) newID(kind string) string {
+	f.nextID++
+	return fmt.Sprintf("%s-%04d", kind, f.nextID)
+}
+
+// addFile puts a file at filePath in the container whose ID is
+// containerID.
+//
+// f.mu must be held.
+func (f *FakeEngine) addFile(containerID string, filePath string, contents []byte) {
+	if f.files[containerID] == nil {
+		f.files[containerID] = make(map[string][]byte)
+	}
+	f.files[containerID][path.Clean(filePath)] = contents
+}
+
+// statPath describes what's at filePath in the container whose ID is
+// containerID: a file, if one was put there, or a directory, if files
+// were put under it.
+//
+// As with the engine, the name of a path ending in /. is ".".
+//
+// f.mu must be held.
+func (f *FakeEngine) statPath(containerID string, filePath string) (container.PathStat, error) {
+	name := path.Base(filePath)
+	if strings.HasSuffix(filePath, "/.") {
+		name = "."
+	}
+	cleanPath := path.Clean(filePath)
+	if contents, ok := f.files[containerID][cleanPath]; ok {
+		return container.PathStat{Name: name, Size: int64(len(contents)), Mode: 0o644}, nil
+	}
+	for filePath := range f.files[containerID] {
+		if cleanPath == "/" || strings.HasPrefix(filePath, cleanPath+"/") {
+			return container.PathStat{Name: name, Mode: fs.ModeDir | 0o755}, nil
+		}
+	}
+	return container.PathStat{}, fmt.Errorf("path %s: %w", filePath, ErrNotFound)
+}
+
+// emit sends msg to the subscribers whose filters it matches; events
+// that don't fit in a subscriber's buffer are dropped.
+//
+// f.mu must be held.
+func (f *FakeEngine) emit(msg events.Message) {
+	if msg.TimeNano == 0 {
+		now := time.Now()
+		msg.Time, msg.TimeNano = now.Unix(), now.UnixNano()
+	}
+	for _, sub := range f.subscribers {
+		if !sub.matches(msg) {
+			continue
+		}
+		select {
+		case sub.messages <- msg:
+		default:
+		}
+	}
+}
+
+// emitContainerEvent sends an event for action happening to ctr.
+//
+// f.mu must be held.
+func (f *FakeEngine) emitContainerEvent(ctr *container.InspectResponse, action events.Action) {
+	attrs := map[string]string{"name": strings.TrimPrefix(ctr.Name, "/")}
+	if ctr.Config != nil {
+		maps.Copy(attrs, ctr.Config.Labels)
+	}
+	if action == events.ActionDie && ctr.State != nil {
+		attrs["exitCode"] = strconv.Itoa(ctr.State.ExitCode)
+	}
+	f.emit(events.Message{
+		Type:   events.ContainerEventType,
+		Action: action,
+		Actor:  events.Actor{ID: ctr.ID, Attributes: attrs},
+	})
+}
+
+// findContainer looks up a container by its ID or name.
+//
+// f.mu must be held.
+func (f *FakeEngine) findContainer(idOrName string) *container.InspectResponse {
+	if ctr, ok := f.containers[idOrName]; ok {
+		return ctr
+	}
+	for _, ctr := range f.containers {
+		if strings.TrimPrefix(ctr.Name, "/") == strings.TrimPrefix(idOrName, "/") {
+			return ctr
+		}
+	}
+	return nil
+}
+
+// Close implements trill.EngineAPI.
+func (f *FakeEngine) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.record("Close", "", nil)
+}
+
+// Ping implements trill.EngineAPI.
+func (f *FakeEngine) Ping(ctx context.Context, options mobyclient.PingOptions) (mobyclient.PingResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("Ping", "", options); err != nil {
+		return mobyclient.PingResult{}, err
+	}
+	return mobyclient.PingResult{APIVersion: "1.52", OSType: "linux"}, nil
+}
+
+// ContainerAttach implements trill.EngineAPI.
+//
+// What the container's command writes is decided by f.OutputHandler;
+// the stream ends once it's been read, as if the command had exited.
+// It's multiplexed the same way the engine does it for containers
+// without a TTY.
+func (f *FakeEngine) ContainerAttach(ctx context.Context, containerID string, options mobyclient.ContainerAttachOptions) (mobyclient.ContainerAttachResult, error) {
+	f.mu.Lock()
+	if err := f.record("ContainerAttach", containerID, options); err != nil {
+		f.mu.Unlock()
+		return mobyclient.ContainerAttachResult{}, err
+	}
+	ctr := f.findContainer(containerID)
+	if ctr == nil {
+		f.mu.Unlock()
+		return mobyclient.ContainerAttachResult{}, fmt.Errorf("container %s: %w", containerID, ErrNotFound)
+	}
+	id := ctr.ID
+	var tty bool
+	var cmd []string
+	if ctr.Config != nil {
+		tty = ctr.Config.Tty
+		cmd = slices.Concat(ctr.Config.Entrypoint, ctr.Config.Cmd)
+	}
+	handler := f.OutputHandler
+	f.mu.Unlock()
+
+	var res ExecResult
+	if handler != nil {
+		res = handler(id, cmd)
+	}
+	var output bytes.Buffer
+	if tty {
+		output.WriteString(res.Stdout + res.Stderr)
+	} else {
+		writeFrame(&output, stdcopy.Stdout, res.Stdout)
+		writeFrame(&output, stdcopy.Stderr, res.Stderr)
+	}
+	return mobyclient.ContainerAttachResult{HijackedResponse: newHijackedResponse(output.Bytes())}, nil
+}
+
+// ContainerCommit implements trill.EngineAPI.
+//
+// The image it adds takes its user, environment, working directory,
+// and labels from the container's configuration.
+func (f *FakeEngine) ContainerCommit(ctx context.Context, containerID string, options mobyclient.ContainerCommitOptions) (mobyclient.ContainerCommitResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ContainerCommit", containerID, options); err != nil {
+		return mobyclient.ContainerCommitResult{}, err
+	}
+	ctr := f.findContainer(containerID)
+	if ctr == nil {
+		return mobyclient.ContainerCommitResult{}, fmt.Errorf("container %s: %w", containerID, ErrNotFound)
+	}
+	cfg := &dockerspec.DockerOCIImageConfig{}
+	if ctr.Config != nil {
+		cfg.User = ctr.Config.User
+		cfg.Env = ctr.Config.Env
+		cfg.WorkingDir = ctr.Config.WorkingDir
+		cfg.Labels = ctr.Config.Labels
+	}
+	id := f.newID("image")
+	f.images[id] = cfg
+	if len(options.Reference) > 0 {
+		f.images[options.Reference] = cfg
+	}
+	return mobyclient.ContainerCommitResult{ID: id}, nil
+}
+
+// ContainerCreate implements trill.EngineAPI.
+func (f *FakeEngine) ContainerCreate(ctx context.Context, options mobyclient.ContainerCreateOptions) (mobyclient.ContainerCreateResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ContainerCreate", options.Name, options); err != nil {
+		return mobyclient.ContainerCreateResult{}, err
+	}
+	if len(options.Name) > 0 && f.findContainer(options.Name) != nil {
+		return mobyclient.ContainerCreateResult{}, fmt.Errorf("container name %s is already in use", options.Name)
+	}
+	if options.Config != nil {
+		if _, ok := f.images[options.Config.Image]; !ok {
+			return mobyclient.ContainerCreateResult{}, fmt.Errorf("image %s: %w", options.Config.Image, ErrNotFound)
+		}
+	}
+
+	// Like the engine, fill in the image's healthcheck if the
+	// container doesn't have one of its own
+	cfg := options.Config
+	if cfg != nil && cfg.Healthcheck == nil && f.images[cfg.Image].Healthcheck != nil {
+		withHealthcheck := *cfg
+		withHealthcheck.Healthcheck = f.images[cfg.Image].Healthcheck
+		cfg = &withHealthcheck
+	}
+
+	id := f.newID("container")
+	ctr := &container.InspectResponse{
+		ID:              id,
+		Name:            "/" + options.Name,
+		Config:          cfg,
+		HostConfig:      options.HostConfig,
+		State:           &container.State{Status: container.StateCreated},
+		NetworkSettings: &container.NetworkSettings{Networks: make(map[string]*network.EndpointSettings)},
+	}
+	if options.NetworkingConfig != nil {
+		for name, endpoint := range options.NetworkingConfig.EndpointsConfig {
+			ctr.NetworkSettings.Networks[name] = endpoint
+		}
+	}
+	f.containers[id] = ctr
+	return mobyclient.ContainerCreateResult{ID: id}, nil
+}
+
+// ContainerInspect implements trill.EngineAPI.
+func (f *FakeEngine) ContainerInspect(ctx context.Context, containerID string, options mobyclient.ContainerInspectOptions) (mobyclient.ContainerInspectResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ContainerInspect", containerID, options); err != nil {
+		return mobyclient.ContainerInspectResult{}, err
+	}
+	ctr := f.findContainer(containerID)
+	if ctr == nil {
+		return mobyclient.ContainerInspectResult{}, fmt.Errorf("container %s: %w", containerID, ErrNotFound)
+	}
+	return mobyclient.ContainerInspectResult{Container: *ctr}, nil
+}
+
+// ContainerList implements trill.EngineAPI.
+//
+// Only the "label" (KEY or KEY=VALUE) and "name" filters are honored;
+// unless options.All is set, only running containers are listed.
+func (f *FakeEngine) ContainerList(ctx context.Context, options mobyclient.ContainerListOptions) (mobyclient.ContainerListResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ContainerList", "", options); err != nil {
+		return mobyclient.ContainerListResult{}, err
+	}
+	var res mobyclient.ContainerListResult
+	for _, id := range slices.Sorted(maps.Keys(f.containers)) {
+		ctr := f.containers[id]
+		if !options.All && !ctr.State.Running {
+			continue
+		}
+		var labels map[string]string
+		if ctr.Config != nil {
+			labels = ctr.Config.Labels
+		}
+		matches := true
+		for label := range options.Filters["label"] {
+			key, value, hasValue := strings.Cut(label, "=")
+			if actual, ok := labels[key]; !ok || (hasValue && actual != value) {
+				matches = false
+			}
+		}
+		if names := options.Filters["name"]; len(names) > 0 && !names[strings.TrimPrefix(ctr.Name, "/")] {
+			matches = false
+		}
+		if !matches {
+			continue
+		}
+		res.Items = append(res.Items, container.Summary{
+			ID:     ctr.ID,
+			Names:  []string{ctr.Name},
+			Labels: labels,
+			State:  ctr.State.Status,
+		})
+	}
+	return res, nil
+}
+
+// ContainerLogs implements trill.EngineAPI.
+//
+// What the container has written is decided by f.OutputHandler, the
+// same way as for ContainerAttach.
+func (f *FakeEngine) ContainerLogs(ctx context.Context, containerID string, options mobyclient.ContainerLogsOptions) (mobyclient.ContainerLogsResult, error) {
+	f.mu.Lock()
+	if err := f.record("ContainerLogs", containerID, options); err != nil {
+		f.mu.Unlock()
+		return nil, err
+	}
+	ctr := f.findContainer(containerID)
+	if ctr == nil {
+		f.mu.Unlock()
+		return nil, fmt.Errorf("container %s: %w", containerID, ErrNotFound)
+	}
+	id := ctr.ID
+	var tty bool
+	var cmd []string
+	if ctr.Config != nil {
+		tty = ctr.Config.Tty
+		cmd = slices.Concat(ctr.Config.Entrypoint, ctr.Config.Cmd)
+	}
+	handler := f.OutputHandler
+	f.mu.Unlock()
+
+	var res ExecResult
+	if handler != nil {
+		res = handler(id, cmd)
+	}
+	var output bytes.Buffer
+	if tty {
+		output.WriteString(res.Stdout + res.Stderr)
+	} else {
+		if options.ShowStdout {
+			writeFrame(&output, stdcopy.Stdout, res.Stdout)
+		}
+		if options.ShowStderr {
+			writeFrame(&output, stdcopy.Stderr, res.Stderr)
+		}
+	}
+	return io.NopCloser(&output), nil
+}
+
+// ContainerRemove implements trill.EngineAPI.
+func (f *FakeEngine) ContainerRemove(ctx context.Context, containerID string, options mobyclient.ContainerRemoveOptions) (mobyclient.ContainerRemoveResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ContainerRemove", containerID, options); err != nil {
+		return mobyclient.ContainerRemoveResult{}, err
+	}
+	ctr := f.findContainer(containerID)
+	if ctr == nil {
+		return mobyclient.ContainerRemoveResult{}, fmt.Errorf("container %s: %w", containerID, ErrNotFound)
+	}
+	if ctr.State.Running && !options.Force {
+		return mobyclient.ContainerRemoveResult{}, fmt.Errorf("container %s is running", containerID)
+	}
+	delete(f.containers, ctr.ID)
+	delete(f.files, ctr.ID)
+	f.emitContainerEvent(ctr, events.ActionDestroy)
+	return mobyclient.ContainerRemoveResult{}, nil
+}
+
+// ContainerResize implements trill.EngineAPI.
+func (f *FakeEngine) ContainerResize(ctx context.Context, containerID string, options mobyclient.ContainerResizeOptions) (mobyclient.ContainerResizeResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return mobyclient.ContainerResizeResult{}, f.record("ContainerResize", containerID, options)
+}
+
+// ContainerStart implements trill.EngineAPI.
+func (f *FakeEngine) ContainerStart(ctx context.Context, containerID string, options mobyclient.ContainerStartOptions) (mobyclient.ContainerStartResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ContainerStart", containerID, options); err != nil {
+		return mobyclient.ContainerStartResult{}, err
+	}
+	ctr := f.findContainer(containerID)
+	if ctr == nil {
+		return mobyclient.ContainerStartResult{}, fmt.Errorf("container %s: %w", containerID, ErrNotFound)
+	}
+	ctr.State = &container.State{Status: container.StateRunning, Running: true}
+	if ctr.HostConfig != nil {
+		ctr.NetworkSettings.Ports = maps.Clone(ctr.HostConfig.PortBindings)
+	}
+	// Containers with a healthcheck start off with their health
+	// undetermined; see SetContainerState
+	if ctr.Config != nil && ctr.Config.Healthcheck != nil && len(ctr.Config.Healthcheck.Test) > 0 && ctr.Config.Healthcheck.Test[0] != "NONE" {
+		ctr.State.Health = &container.Health{Status: container.Starting}
+	}
+	f.emitContainerEvent(ctr, events.ActionStart)
+	return mobyclient.ContainerStartResult{}, nil
+}
+
+// ContainerStop implements trill.EngineAPI.
+func (f *FakeEngine) ContainerStop(ctx context.Context, containerID string, options mobyclient.ContainerStopOptions) (mobyclient.ContainerStopResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ContainerStop", containerID, options); err != nil {
+		return mobyclient.ContainerStopResult{}, err
+	}
+	ctr := f.findContainer(containerID)
+	if ctr == nil {
+		return mobyclient.ContainerStopResult{}, fmt.Errorf("container %s: %w", containerID, ErrNotFound)
+	}
+	ctr.State = &container.State{Status: container.StateExited, ExitCode: ctr.State.ExitCode}
+	f.emitContainerEvent(ctr, events.ActionDie)
+	f.emitContainerEvent(ctr, events.ActionStop)
+	if ctr.HostConfig != nil && ctr.HostConfig.AutoRemove {
+		delete(f.containers, ctr.ID)
+		delete(f.files, ctr.ID)
+		f.emitContainerEvent(ctr, events.ActionDestroy)
+	}
+	return mobyclient.ContainerStopResult{}, nil
+}
+
+// ContainerWait implements trill.EngineAPI.
+//
+// Waiting on a running container makes it exit immediately.
+func (f *FakeEngine) ContainerWait(ctx context.Context, containerID string, options mobyclient.ContainerWaitOptions) mobyclient.ContainerWaitResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resultChan := make(chan container.WaitResponse, 1)
+	errChan := make(chan error, 1)
+
+	if err := f.record("ContainerWait", containerID, options); err != nil {
+		errChan <- err
+		return mobyclient.ContainerWaitResult{Result: resultChan, Error: errChan}
+	}
+	ctr := f.findContainer(containerID)
+	if ctr == nil {
+		errChan <- fmt.Errorf("container %s: %w", containerID, ErrNotFound)
+		return mobyclient.ContainerWaitResult{Result: resultChan, Error: errChan}
+	}
+	ctr.State.Running = false
+	ctr.State.Status = container.StateExited
+	resultChan <- container.WaitResponse{StatusCode: int64(ctr.State.ExitCode)}
+	return mobyclient.ContainerWaitResult{Result: resultChan, Error: errChan}
+}
+
+// CopyFromContainer implements trill.EngineAPI.
+//
+// The archive holds the file at options.SourcePath, or the files under
+// it, as added by AddFile or copied in by CopyToContainer.
+func (f *FakeEngine) CopyFromContainer(ctx context.Context, containerID string, options mobyclient.CopyFromContainerOptions) (mobyclient.CopyFromContainerResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("CopyFromContainer", containerID, options); err != nil {
+		return mobyclient.CopyFromContainerResult{}, err
+	}
+	ctr := f.findContainer(containerID)
+	if ctr == nil {
+		return mobyclient.CopyFromContainerResult{}, fmt.Errorf("container %s: %w", containerID, ErrNotFound)
+	}
+	stat, err := f.statPath(ctr.ID, options.SourcePath)
+	if err != nil {
+		return mobyclient.CopyFromContainerResult{}, err
+	}
+
+	// Entries are named relative to the source's parent, as the
+	// engine does it
+	srcPath := path.Clean(options.SourcePath)
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, filePath := range slices.Sorted(maps.Keys(f.files[ctr.ID])) {
+		name := stat.Name
+		if filePath != srcPath {
+			rel, ok := strings.CutPrefix(filePath, strings.TrimSuffix(srcPath, "/")+"/")
+			if !ok {
+				continue
+			}
+			name = path.Join(stat.Name, rel)
+		}
+		contents := f.files[ctr.ID][filePath]
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(contents)), Typeflag: tar.TypeReg}); err != nil {
+			return mobyclient.CopyFromContainerResult{}, err
+		}
+		if _, err := tw.Write(contents); err != nil {
+			return mobyclient.CopyFromContainerResult{}, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return mobyclient.CopyFromContainerResult{}, err
+	}
+	return mobyclient.CopyFromContainerResult{
+		Content: io.NopCloser(&archive),
+		Stat:    stat,
+	}, nil
+}
+
+// CopyToContainer implements trill.EngineAPI.
+//
+// The regular files in the archive are kept, under
+// options.DestinationPath, for CopyFromContainer and File; everything
+// else in it is discarded.
+func (f *FakeEngine) CopyToContainer(ctx context.Context, containerID string, options mobyclient.CopyToContainerOptions) (mobyclient.CopyToContainerResult, error) {
+	f.mu.Lock()
+	if err := f.record("CopyToContainer", containerID, options); err != nil {
+		f.mu.Unlock()
+		return mobyclient.CopyToContainerResult{}, err
+	}
+	ctr := f.findContainer(containerID)
+	f.mu.Unlock()
+
+	if ctr == nil {
+		return mobyclient.CopyToContainerResult{}, fmt.Errorf("container %s: %w", containerID, ErrNotFound)
+	}
+	if options.Content == nil {
+		return mobyclient.CopyToContainerResult{}, nil
+	}
+	files := make(map[string][]byte)
+	tr := tar.NewReader(options.Content)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return mobyclient.CopyToContainerResult{}, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			return mobyclient.CopyToContainerResult{}, err
+		}
+		files[path.Join(options.DestinationPath, hdr.Name)] = contents
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for filePath, contents := range files {
+		f.addFile(ctr.ID, filePath, contents)
+	}
+	return mobyclient.CopyToContainerResult{}, nil
+}
+
+// ContainerStatPath implements trill.EngineAPI.
+func (f *FakeEngine) ContainerStatPath(ctx context.Context, containerID string, options mobyclient.ContainerStatPathOptions) (mobyclient.ContainerStatPathResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ContainerStatPath", containerID, options); err != nil {
+		return mobyclient.ContainerStatPathResult{}, err
+	}
+	ctr := f.findContainer(containerID)
+	if ctr == nil {
+		return mobyclient.ContainerStatPathResult{}, fmt.Errorf("container %s: %w", containerID, ErrNotFound)
+	}
+	stat, err := f.statPath(ctr.ID, options.Path)
+	if err != nil {
+		return mobyclient.ContainerStatPathResult{}, err
+	}
+	return mobyclient.ContainerStatPathResult{Stat: stat}, nil
+}
+
+// Events implements trill.EngineAPI.
+//
+// Containers being started, stopped, and removed are reported as the
+// engine would; anything else has to be sent with EmitEvent.
+func (f *FakeEngine) Events(ctx context.Context, options mobyclient.EventsListOptions) mobyclient.EventsResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	errs := make(chan error, 1)
+	if err := f.record("Events", "", options); err != nil {
+		errs <- err
+		close(errs)
+		return mobyclient.EventsResult{Messages: make(chan events.Message), Err: errs}
+	}
+
+	sub := &fakeSubscriber{filters: options.Filters, messages: make(chan events.Message, fakeEventsBuffer)}
+	f.subscribers = append(f.subscribers, sub)
+	go func() {
+		<-ctx.Done()
+		f.mu.Lock()
+		f.subscribers = slices.DeleteFunc(f.subscribers, func(s *fakeSubscriber) bool { return s == sub })
+		f.mu.Unlock()
+		errs <- ctx.Err()
+		close(errs)
+	}()
+	return mobyclient.EventsResult{Messages: sub.messages, Err: errs}
+}
+
+// ExecAttach implements trill.EngineAPI.
+//
+// The command is "run" by passing it to f.ExecHandler; its output is
+// multiplexed the same way the engine does it for non-TTY execs.
+func (f *FakeEngine) ExecAttach(ctx context.Context, execID string, options mobyclient.ExecAttachOptions) (mobyclient.ExecAttachResult, error) {
+	f.mu.Lock()
+	if err := f.record("ExecAttach", execID, options); err != nil {
+		f.mu.Unlock()
+		return mobyclient.ExecAttachResult{}, err
+	}
+	exec, ok := f.execs[execID]
+	handler := f.ExecHandler
+	f.mu.Unlock()
+
+	if !ok {
+		return mobyclient.ExecAttachResult{}, fmt.Errorf("exec %s: %w", execID, ErrNotFound)
+	}
+
+	var res ExecResult
+	if handler != nil {
+		res = handler(exec.containerID, exec.cmd)
+	}
+	f.mu.Lock()
+	exec.exitCode = res.ExitCode
+	exec.running = res.Dropped
+	f.mu.Unlock()
+
+	// With a TTY, the engine doesn't multiplex the output
+	var output bytes.Buffer
+	if exec.tty {
+		output.WriteString(res.Stdout + res.Stderr)
+	} else {
+		writeFrame(&output, stdcopy.Stdout, res.Stdout)
+		writeFrame(&output, stdcopy.Stderr, res.Stderr)
+	}
+	return mobyclient.ExecAttachResult{HijackedResponse: newHijackedResponse(output.Bytes())}, nil
+}
+
+// ExecCreate implements trill.EngineAPI.
+func (f *FakeEngine) ExecCreate(ctx context.Context, containerID string, options mobyclient.ExecCreateOptions) (mobyclient.ExecCreateResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ExecCreate", containerID, options); err != nil {
+		return mobyclient.ExecCreateResult{}, err
+	}
+	ctr := f.findContainer(containerID)
+	if ctr == nil {
+		return mobyclient.ExecCreateResult{}, fmt.Errorf("container %s: %w", containerID, ErrNotFound)
+	}
+	if !ctr.State.Running {
+		return mobyclient.ExecCreateResult{}, fmt.Errorf("container %s is not running", containerID)
+	}
+
+	id := f.newID("exec")
+	f.execs[id] = &fakeExec{containerID: ctr.ID, cmd: options.Cmd, tty: options.TTY}
+	return mobyclient.ExecCreateResult{ID: id}, nil
+}
+
+// ExecInspect implements trill.EngineAPI.
+func (f *FakeEngine) ExecInspect(ctx context.Context, execID string, options mobyclient.ExecInspectOptions) (mobyclient.ExecInspectResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ExecInspect", execID, options); err != nil {
+		return mobyclient.ExecInspectResult{}, err
+	}
+	exec, ok := f.execs[execID]
+	if !ok {
+		return mobyclient.ExecInspectResult{}, fmt.Errorf("exec %s: %w", execID, ErrNotFound)
+	}
+	return mobyclient.ExecInspectResult{ID: execID, ContainerID: exec.containerID, ExitCode: exec.exitCode, Running: exec.running}, nil
+}
+
+// ExecResize implements trill.EngineAPI.
+func (f *FakeEngine) ExecResize(ctx context.Context, execID string, options mobyclient.ExecResizeOptions) (mobyclient.ExecResizeResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ExecResize", execID, options); err != nil {
+		return mobyclient.ExecResizeResult{}, err
+	}
+	if _, ok := f.execs[execID]; !ok {
+		return mobyclient.ExecResizeResult{}, fmt.Errorf("exec %s: %w", execID, ErrNotFound)
+	}
+	return mobyclient.ExecResizeResult{}, nil
+}
+
+// ImageBuild implements trill.EngineAPI.
+//
+// The build context is drained, and every tag in options is made
+// available as an image.
+func (f *FakeEngine) ImageBuild(ctx context.Context, buildContext io.Reader, options mobyclient.ImageBuildOptions) (mobyclient.ImageBuildResult, error) {
+	f.mu.Lock()
+	err := f.record("ImageBuild", strings.Join(options.Tags, ","), options)
+	f.mu.Unlock()
+	if err != nil {
+		return mobyclient.ImageBuildResult{}, err
+	}
+
+	if buildContext != nil {
+		if _, err := io.Copy(io.Discard, buildContext); err != nil {
+			return mobyclient.ImageBuildResult{}, err
+		}
+	}
+	// Keep the labels, so builds can be skipped when the build hash
+	// matches
+	for _, tag := range options.Tags {
+		cfg := &dockerspec.DockerOCIImageConfig{}
+		cfg.Labels = maps.Clone(options.Labels)
+		f.AddImage(tag, cfg)
+	}
+	return mobyclient.ImageBuildResult{Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+// ImageInspect implements trill.EngineAPI.
+func (f *FakeEngine) ImageInspect(ctx context.Context, imageID string, inspectOpts ...mobyclient.ImageInspectOption) (mobyclient.ImageInspectResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ImageInspect", imageID, inspectOpts); err != nil {
+		return mobyclient.ImageInspectResult{}, err
+	}
+	cfg, ok := f.images[imageID]
+	if !ok {
+		return mobyclient.ImageInspectResult{}, fmt.Errorf("image %s: %w", imageID, ErrNotFound)
+	}
+	return mobyclient.ImageInspectResult{InspectResponse: image.InspectResponse{
+		ID:       "sha256:" + imageID,
+		RepoTags: []string{imageID},
+		Config:   cfg,
+	}}, nil
+}
+
+// ImagePull implements trill.EngineAPI.
+//
+// The pull always succeeds without progress output, and makes refStr
+// available as an image.
+func (f *FakeEngine) ImagePull(ctx context.Context, refStr string, options mobyclient.ImagePullOptions) (mobyclient.ImagePullResponse, error) {
+	f.mu.Lock()
+	err := f.record("ImagePull", refStr, options)
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if !f.HasImage(refStr) {
+		f.AddImage(refStr, nil)
+	}
+	return &fakePullResponse{ReadCloser: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+// ImagePush implements trill.EngineAPI.
+//
+// The push succeeds without progress output as long as image exists.
+func (f *FakeEngine) ImagePush(ctx context.Context, image string, options mobyclient.ImagePushOptions) (mobyclient.ImagePushResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ImagePush", image, options); err != nil {
+		return nil, err
+	}
+	if _, ok := f.images[image]; !ok {
+		return nil, fmt.Errorf("image %s: %w", image, ErrNotFound)
+	}
+	return &fakePullResponse{ReadCloser: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+// ImageTag implements trill.EngineAPI.
+func (f *FakeEngine) ImageTag(ctx context.Context, options mobyclient.ImageTagOptions) (mobyclient.ImageTagResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ImageTag", options.Target, options); err != nil {
+		return mobyclient.ImageTagResult{}, err
+	}
+	cfg, ok := f.images[options.Source]
+	if !ok {
+		return mobyclient.ImageTagResult{}, fmt.Errorf("image %s: %w", options.Source, ErrNotFound)
+	}
+	f.images[options.Target] = cfg
+	return mobyclient.ImageTagResult{}, nil
+}
+
+// NetworkConnect implements trill.EngineAPI.
+func (f *FakeEngine) NetworkConnect(ctx context.Context, networkID string, options mobyclient.NetworkConnectOptions) (mobyclient.NetworkConnectResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("NetworkConnect", networkID, options); err != nil {
+		return mobyclient.NetworkConnectResult{}, err
+	}
+	if _, ok := f.networks[networkID]; !ok {
+		return mobyclient.NetworkConnectResult{}, fmt.Errorf("network %s: %w", networkID, ErrNotFound)
+	}
+	ctr := f.findContainer(options.Container)
+	if ctr == nil {
+		return mobyclient.NetworkConnectResult{}, fmt.Errorf("container %s: %w", options.Container, ErrNotFound)
+	}
+	ctr.NetworkSettings.Networks[networkID] = options.EndpointConfig
+	return mobyclient.NetworkConnectResult{}, nil
+}
+
+// NetworkCreate implements trill.EngineAPI.
+func (f *FakeEngine) NetworkCreate(ctx context.Context, name string, options mobyclient.NetworkCreateOptions) (mobyclient.NetworkCreateResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("NetworkCreate", name, options); err != nil {
+		return mobyclient.NetworkCreateResult{}, err
+	}
+	if _, ok := f.networks[name]; ok {
+		return mobyclient.NetworkCreateResult{}, fmt.Errorf("network with name %s already exists", name)
+	}
+	id := f.newID("network")
+	f.networks[name] = network.Inspect{Network: network.Network{Name: name, ID: id, Driver: options.Driver, Labels: options.Labels}}
+	return mobyclient.NetworkCreateResult{ID: id}, nil
+}
+
+// NetworkInspect implements trill.EngineAPI.
+func (f *FakeEngine) NetworkInspect(ctx context.Context, networkID string, options mobyclient.NetworkInspectOptions) (mobyclient.NetworkInspectResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("NetworkInspect", networkID, options); err != nil {
+		return mobyclient.NetworkInspectResult{}, err
+	}
+	nw, ok := f.networks[networkID]
+	if !ok {
+		return mobyclient.NetworkInspectResult{}, fmt.Errorf("network %s: %w", networkID, ErrNotFound)
+	}
+	return mobyclient.NetworkInspectResult{Network: nw}, nil
+}
+
+// NetworkRemove implements trill.EngineAPI.
+func (f *FakeEngine) NetworkRemove(ctx context.Context, networkID string, options mobyclient.NetworkRemoveOptions) (mobyclient.NetworkRemoveResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("NetworkRemove", networkID, options); err != nil {
+		return mobyclient.NetworkRemoveResult{}, err
+	}
+	if _, ok := f.networks[networkID]; !ok {
+		return mobyclient.NetworkRemoveResult{}, fmt.Errorf("network %s: %w", networkID, ErrNotFound)
+	}
+	delete(f.networks, networkID)
+	return mobyclient.NetworkRemoveResult{}, nil
+}
+
+// VolumeCreate implements trill.EngineAPI.
+func (f *FakeEngine) VolumeCreate(ctx context.Context, options mobyclient.VolumeCreateOptions) (mobyclient.VolumeCreateResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("VolumeCreate", options.Name, options); err != nil {
+		return mobyclient.VolumeCreateResult{}, err
+	}
+	name := options.Name
+	if len(name) == 0 {
+		name = f.newID("volume")
+	}
+	vol, ok := f.volumes[name]
+	if !ok {
+		// Like the engine, creating a volume that already exists
+		// is a no-op
+		vol = volume.Volume{Name: name, Driver: options.Driver, Labels: options.Labels}
+		f.volumes[name] = vol
+	}
+	return mobyclient.VolumeCreateResult{Volume: vol}, nil
+}
+
+// VolumeInspect implements trill.EngineAPI.
+func (f *FakeEngine) VolumeInspect(ctx context.Context, volumeID string, options mobyclient.VolumeInspectOptions) (mobyclient.VolumeInspectResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("VolumeInspect", volumeID, options); err != nil {
+		return mobyclient.VolumeInspectResult{}, err
+	}
+	vol, ok := f.volumes[volumeID]
+	if !ok {
+		return mobyclient.VolumeInspectResult{}, fmt.Errorf("volume %s: %w", volumeID, ErrNotFound)
+	}
+	return mobyclient.VolumeInspectResult{Volume: vol}, nil
+}
+
+// VolumeRemove implements trill.EngineAPI.
+func (f *FakeEngine) VolumeRemove(ctx context.Context, volumeID string, options mobyclient.VolumeRemoveOptions) (mobyclient.VolumeRemoveResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("VolumeRemove", volumeID, options); err != nil {
+		return mobyclient.VolumeRemoveResult{}, err
+	}
+	if _, ok := f.volumes[volumeID]; !ok {
+		return mobyclient.VolumeRemoveResult{}, fmt.Errorf("volume %s: %w", volumeID, ErrNotFound)
+	}
+	delete(f.volumes, volumeID)
+	return mobyclient.VolumeRemoveResult{}, nil
+}
+
+// fakePullResponse is an ImagePullResponse (or ImagePushResponse)
+// with no progress messages.
+type fakePullResponse struct {
+	io.ReadCloser
+}
+
+// JSONMessages implements mobyclient.ImagePullResponse.
+func (r *fakePullResponse) JSONMessages(ctx context.Context) iter.Seq2[jsonstream.Message, error] {
+	return func(yield func(jsonstream.Message, error) bool) {}
+}
+
+// Wait implements mobyclient.ImagePullResponse.
+func (r *fakePullResponse) Wait(ctx context.Context) error {
+	return r.Close()
+}
+
+// newHijackedResponse returns a HijackedResponse whose reader yields
+// output, then EOF.
+//
+// It's backed by an actual connection, so closing it behaves the same
+// as with one the engine returns.
+func newHijackedResponse(output []byte) mobyclient.HijackedResponse {
+	clientConn, engineConn := net.Pipe()
+	go func() {
+		defer engineConn.Close()
+		_, _ = engineConn.Write(output)
+	}()
+	return mobyclient.NewHijackedResponse(clientConn, "")
+}
+
+// writeFrame writes payload to w as a single stdcopy frame for
+// stream, which is how the engine multiplexes stdout and stderr.
+func writeFrame(w io.Writer, stream stdcopy.StdType, payload string) {
+	if len(payload) == 0 {
+		return
+	}
+	header := make([]byte, 8)
+	header[0] = byte(stream)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	_, _ = w.Write(header)
+	_, _ = io.WriteString(w, payload)
+}
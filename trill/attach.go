@@ -0,0 +1,312 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"syscall"
+	"time"
+
+	"github.com/moby/moby/api/pkg/stdcopy"
+	mobyclient "github.com/moby/moby/client"
+)
+
+// Defaults for the Client fields governing how reconnecting to the
+// shell attached to the host terminal is attempted
+const (
+	DefaultReattachAttempts int           = 5
+	DefaultReattachDelay    time.Duration = 500 * time.Millisecond
+)
+
+// MaxReattachDelay is the longest Client waits between attempts to
+// reconnect to the shell attached to the host terminal.
+const MaxReattachDelay = 10 * time.Second
+
+// shellExitGracePeriod is how long the engine is given to notice the
+// attached shell has exited once its output ends, before the
+// connection is taken to have dropped instead.
+const shellExitGracePeriod = 500 * time.Millisecond
+
+// ErrShellConnectionLost is returned when the connection to the shell
+// attached to the host terminal drops, and can't be reestablished.
+var ErrShellConnectionLost = errors.New("lost the connection to the devcontainer's shell")
+
+// errDevcontainerNotRunning is returned by reattachShell when the
+// devcontainer has stopped, so there's nothing to reconnect to.
+var errDevcontainerNotRunning = errors.New("the devcontainer is no longer running")
+
+// pumpShellOutput copies the output of the shell attached to the host
+// terminal to stdout until the shell exits or ctx is cancelled.
+//
+// If the output ends while the shell's still running (e.g., because
+// the engine's socket hiccuped, or Podman was restarted), a new shell
+// is started in its place, waiting c.ReattachDelay, doubled after each
+// failure, between up to c.ReattachAttempts attempts. An error
+// wrapping ErrShellConnectionLost is returned if all of them fail.
+func (c *Client) pumpShellOutput(ctx context.Context, stdout io.Writer) error {
+	for {
+		if _, err := io.Copy(stdout, c.shellConn().Reader); err != nil && err != io.EOF {
+			slog.Error("encountered an error copying container output to stdout", "error", err)
+		}
+		if c.isDetaching() {
+			return ErrDetached
+		}
+		if ctx.Err() != nil || c.shellExited(ctx) {
+			return nil
+		}
+
+		slog.Warn("the connection to the devcontainer's shell dropped while it was still running; reconnecting", "container", c.ContainerID)
+		err := errors.New("reconnecting is disabled")
+		delay := c.ReattachDelay
+		for attempt := 1; attempt <= c.ReattachAttempts; attempt++ {
+			// The terminal's in raw mode, so lines have to be ended
+			// with a carriage return
+			fmt.Fprintf(stdout, "\r\nbrig: lost the connection to the devcontainer; reconnecting in %s (attempt %d of %d)\r\n", delay, attempt, c.ReattachAttempts)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+			if err = c.reattachShell(ctx); err == nil || errors.Is(err, errDevcontainerNotRunning) {
+				break
+			}
+			slog.Debug("unable to reconnect to the devcontainer's shell", "attempt", attempt, "error", err)
+			delay = min(2*delay, MaxReattachDelay)
+		}
+		if err != nil {
+			fmt.Fprintf(stdout, "\r\nbrig: unable to reconnect to the devcontainer: %s\r\n", err)
+			return fmt.Errorf("%w: %w", ErrShellConnectionLost, err)
+		}
+		fmt.Fprint(stdout, "\r\nbrig: reconnected; this is a new shell\r\n")
+	}
+}
+
+// pumpShellInput copies stdin to the shell attached to the host
+// terminal, across reconnections, until stdin is exhausted or
+// c.DetachKeys are typed.
+//
+// Input that can't be delivered because the connection has dropped is
+// discarded.
+func (c *Client) pumpShellInput(stdin io.Reader) {
+	filter := detachKeyFilter{keys: c.DetachKeys}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := stdin.Read(buf)
+		if n > 0 {
+			input, detach := filter.filter(buf[:n])
+			if len(input) > 0 {
+				if _, writeErr := c.shellConn().Conn.Write(input); writeErr != nil && !errors.Is(writeErr, syscall.EPIPE) {
+					slog.Debug("discarding terminal input; the connection to the container is down", "error", writeErr)
+				}
+			}
+			if detach {
+				slog.Debug("detach keys typed; detaching from the devcontainer", "container", c.ContainerID)
+				c.detachShell()
+				return
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				slog.Error("encountered an error reading terminal input", "error", err)
+			}
+			return
+		}
+	}
+}
+
+// shellExited reports whether the shell attached to the host terminal
+// has exited, as opposed to the connection to it having dropped.
+//
+// The engine can lag behind the shell's output ending in noticing it's
+// exited, so it's given shellExitGracePeriod to catch up.
+func (c *Client) shellExited(ctx context.Context) bool {
+	c.shellMu.Lock()
+	execID := c.shellExecID
+	c.shellMu.Unlock()
+
+	deadline := time.Now().Add(shellExitGracePeriod)
+	for {
+		res, err := c.mobyClient.ExecInspect(ctx, execID, mobyclient.ExecInspectOptions{})
+		if err != nil {
+			slog.Debug("unable to inspect the attached shell", "error", err)
+			return false
+		}
+		if !res.Running {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(shellExitGracePeriod / 10)
+	}
+}
+
+// reattachShell replaces the shell attached to the host terminal with
+// a new one, of the same size, once the engine is reachable again.
+func (c *Client) reattachShell(ctx context.Context) error {
+	if err := c.Ping(ctx); err != nil {
+		return err
+	}
+	running, err := c.IsContainerRunning(ctx, c.ContainerID)
+	if err != nil {
+		return err
+	} else if !running {
+		return errDevcontainerNotRunning
+	}
+
+	c.shellMu.Lock()
+	h, w := c.shellHeight, c.shellWidth
+	c.shellMu.Unlock()
+	c.closeShellConn()
+	return c.startShell(ctx, h, w)
+}
+
+// shellConn returns the current connection to the shell attached to
+// the host terminal.
+func (c *Client) shellConn() *mobyclient.HijackedResponse {
+	c.shellMu.Lock()
+	defer c.shellMu.Unlock()
+	return c.attachResp
+}
+
+// detachShell closes the connection to the shell attached to the host
+// terminal, without it being taken to have dropped.
+func (c *Client) detachShell() {
+	c.shellMu.Lock()
+	c.detaching = true
+	c.shellMu.Unlock()
+	c.closeShellConn()
+}
+
+// isDetaching reports whether detachShell has been called.
+func (c *Client) isDetaching() bool {
+	c.shellMu.Lock()
+	defer c.shellMu.Unlock()
+	return c.detaching
+}
+
+// closeShellConn closes the connection to the shell attached to the
+// host terminal, if there is one.
+func (c *Client) closeShellConn() {
+	c.shellMu.Lock()
+	defer c.shellMu.Unlock()
+	if c.attachResp != nil {
+		c.attachResp.Close()
+	}
+}
+
+// AttachContainerOutput copies what the command of the container
+// designated by containerID has written, and goes on to write, to
+// stdout and stderr, until it exits or ctx is cancelled.
+//
+// The engine multiplexes the output of containers without a TTY, which
+// is undone here; with one, stdout and stderr can't be told apart, and
+// everything goes to stdout.
+func (c *Client) AttachContainerOutput(ctx context.Context, containerID string, stdout io.Writer, stderr io.Writer) error {
+	copyOutput, err := c.attachContainerOutput(ctx, containerID, stdout, stderr)
+	if err != nil {
+		return err
+	}
+	return copyOutput()
+}
+
+// attachContainerOutput connects to the output of the container
+// designated by containerID, and returns a function that copies it
+// to stdout and stderr until the stream ends or ctx is cancelled.
+//
+// Connecting before the container's started ensures none of its
+// output is missed.
+func (c *Client) attachContainerOutput(ctx context.Context, containerID string, stdout io.Writer, stderr io.Writer) (func() error, error) {
+	inspectRes, err := c.mobyClient.ContainerInspect(ctx, containerID, mobyclient.ContainerInspectOptions{})
+	if err != nil {
+		slog.Error("encountered an error while inspecting a container", "container-id", containerID, "error", err)
+		return nil, fmt.Errorf("%w %s: %w", ErrContainerAttach, containerID, err)
+	}
+	tty := inspectRes.Container.Config != nil && inspectRes.Container.Config.Tty
+	attachRes, err := c.mobyClient.ContainerAttach(ctx, containerID, mobyclient.ContainerAttachOptions{
+		Stream: true,
+		Stdout: true,
+		Stderr: true,
+		Logs:   true,
+	})
+	if err != nil {
+		slog.Error("encountered an error while attaching to a container's output", "container-id", containerID, "error", err)
+		return nil, fmt.Errorf("%w %s: %w", ErrContainerAttach, containerID, err)
+	}
+
+	return func() (err error) {
+		defer attachRes.Close()
+		stop := context.AfterFunc(ctx, attachRes.Close)
+		defer stop()
+		if tty {
+			_, err = io.Copy(stdout, attachRes.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(stdout, stderr, attachRes.Reader)
+		}
+		// Closing the connection on cancellation makes the copy fail
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("%w %s: %w", ErrContainerAttach, containerID, err)
+		}
+		return nil
+	}, nil
+}
+
+// streamCommandOutput connects to the output of the devcontainer's own
+// command, and copies it to c.CommandStdout and c.CommandStderr in the
+// background until stopCommandOutput is called.
+func (c *Client) streamCommandOutput(ctx context.Context, containerID string) error {
+	// The lifecycle handler cancels the context brig brings the
+	// devcontainer up with once it's done, well before the container
+	// stops
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stderr := c.CommandStderr
+	if stderr == nil {
+		stderr = c.CommandStdout
+	}
+	copyOutput, err := c.attachContainerOutput(ctx, containerID, c.CommandStdout, stderr)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	c.commandOutputMu.Lock()
+	c.commandOutputStop = cancel
+	c.commandOutputMu.Unlock()
+	go func() {
+		defer cancel()
+		if err := copyOutput(); err != nil {
+			slog.Warn("stopped copying the devcontainer's output", "error", err)
+		}
+	}()
+	return nil
+}
+
+// stopCommandOutput stops copying the devcontainer's own output to
+// c.CommandStdout and c.CommandStderr, if it's being copied.
+func (c *Client) stopCommandOutput() {
+	c.commandOutputMu.Lock()
+	defer c.commandOutputMu.Unlock()
+	if c.commandOutputStop != nil {
+		c.commandOutputStop()
+		c.commandOutputStop = nil
+	}
+}
@@ -0,0 +1,203 @@
+package trill
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// newAttachedFakeClient returns a Client attached to a shell in a
+// running container in engine, which runs handler for every shell
+// started.
+func newAttachedFakeClient(t *testing.T, engine *testutil.FakeEngine, handler testutil.ExecHandler) *Client {
+	engine.AddImage("alpine", nil)
+	engine.ExecHandler = handler
+	created, err := engine.ContainerCreate(context.Background(), mobyclient.ContainerCreateOptions{
+		Name:   "devcontainer",
+		Config: &container.Config{Image: "alpine"},
+	})
+	assert.Nil(t, err)
+	_, err = engine.ContainerStart(context.Background(), created.ID, mobyclient.ContainerStartOptions{})
+	assert.Nil(t, err)
+
+	c := newFakeClient(t, engine)
+	c.ContainerID = created.ID
+	c.ReattachDelay = time.Millisecond
+	assert.Nil(t, c.startShell(context.Background(), 24, 80))
+	return c
+}
+
+// TestPumpShellOutputReattach checks that a new shell is attached, with
+// the same size, when the connection to the previous one drops while
+// it's still running.
+func TestPumpShellOutputReattach(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	var shells atomic.Int32
+	c := newAttachedFakeClient(t, engine, func(containerID string, cmd []string) testutil.ExecResult {
+		if shells.Add(1) == 1 {
+			return testutil.ExecResult{Stdout: "first shell\r\n", Dropped: true}
+		}
+		return testutil.ExecResult{Stdout: "second shell\r\n"}
+	})
+
+	var stdout bytes.Buffer
+	assert.Nil(t, c.pumpShellOutput(context.Background(), &stdout))
+	assert.Equal(t, int32(2), shells.Load())
+	output := stdout.String()
+	assert.Contains(t, output, "first shell")
+	assert.Contains(t, output, "reconnecting")
+	assert.Contains(t, output, "second shell")
+	if execs := engine.CallsTo("ExecCreate"); assert.Len(t, execs, 2) {
+		opts := execs[1].Options.(mobyclient.ExecCreateOptions)
+		assert.Equal(t, mobyclient.ConsoleSize{Height: 24, Width: 80}, opts.ConsoleSize)
+	}
+}
+
+// TestPumpShellOutputGiveUp checks that reconnecting is given up on,
+// with ErrShellConnectionLost, once the devcontainer's stopped or the
+// attempts run out.
+func TestPumpShellOutputGiveUp(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dropped := func(containerID string, cmd []string) testutil.ExecResult {
+		return testutil.ExecResult{Dropped: true}
+	}
+
+	// A stopped devcontainer isn't retried
+	engine := testutil.NewFakeEngine()
+	c := newAttachedFakeClient(t, engine, dropped)
+	_, err := engine.ContainerStop(context.Background(), c.ContainerID, mobyclient.ContainerStopOptions{})
+	assert.Nil(t, err)
+	var stdout bytes.Buffer
+	err = c.pumpShellOutput(context.Background(), &stdout)
+	assert.ErrorIs(t, err, ErrShellConnectionLost)
+	assert.ErrorIs(t, err, errDevcontainerNotRunning)
+	assert.Equal(t, 1, strings.Count(stdout.String(), "reconnecting in"))
+
+	// An unreachable engine is, up to ReattachAttempts times
+	engine = testutil.NewFakeEngine()
+	c = newAttachedFakeClient(t, engine, dropped)
+	c.ReattachAttempts = 2
+	engine.FailOn("Ping", errors.New("connection refused"))
+	stdout.Reset()
+	err = c.pumpShellOutput(context.Background(), &stdout)
+	assert.ErrorIs(t, err, ErrShellConnectionLost)
+	assert.ErrorIs(t, err, ErrEngineUnreachable)
+	assert.Equal(t, 2, strings.Count(stdout.String(), "reconnecting in"))
+	assert.Contains(t, stdout.String(), "unable to reconnect")
+}
+
+// TestAttachContainerOutput checks that the output of a container
+// without a TTY is split back into stdout and stderr, and that of one
+// with a TTY is passed through as-is.
+func TestAttachContainerOutput(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("alpine", nil)
+	engine.OutputHandler = func(containerID string, cmd []string) testutil.ExecResult {
+		return testutil.ExecResult{Stdout: "listening on :8080\n", Stderr: "warning: no config\n"}
+	}
+	c := newFakeClient(t, engine)
+
+	for _, tty := range []bool{false, true} {
+		created, err := engine.ContainerCreate(context.Background(), mobyclient.ContainerCreateOptions{
+			Config: &container.Config{Image: "alpine", Tty: tty},
+		})
+		assert.Nil(t, err)
+
+		var stdout, stderr bytes.Buffer
+		assert.Nil(t, c.AttachContainerOutput(context.Background(), created.ID, &stdout, &stderr))
+		if tty {
+			assert.Equal(t, "listening on :8080\nwarning: no config\n", stdout.String())
+			assert.Empty(t, stderr.String())
+		} else {
+			assert.Equal(t, "listening on :8080\n", stdout.String())
+			assert.Equal(t, "warning: no config\n", stderr.String())
+		}
+	}
+
+	created, err := engine.ContainerCreate(context.Background(), mobyclient.ContainerCreateOptions{
+		Config: &container.Config{Image: "alpine"},
+	})
+	assert.Nil(t, err)
+	engine.FailOn("ContainerAttach", errors.New("connection reset"))
+	assert.ErrorIs(t, c.AttachContainerOutput(context.Background(), created.ID, io.Discard, io.Discard), ErrContainerAttach)
+}
+
+// TestStartDevcontainerContainerCommandOutput checks that the output
+// of the devcontainer's own command is copied to CommandStdout and
+// CommandStderr when overrideCommand is false.
+func TestStartDevcontainerContainerCommandOutput(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Chdir(filepath.Join("testdata", "image"))
+	p, err := writ.NewDevcontainerParser("devcontainer.json")
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+	*p.Config.OverrideCommand = false
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("docker.io/library/alpine:3", nil)
+	engine.OutputHandler = func(containerID string, cmd []string) testutil.ExecResult {
+		return testutil.ExecResult{Stdout: "ready\n", Stderr: "oops\n"}
+	}
+	c := newFakeClient(t, engine)
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	c.CommandStdout = stdoutW
+	c.CommandStderr = stderrW
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	seen := answerLifecycleEvents(ctx, c)
+
+	assert.Nil(t, c.StartDevcontainerContainer(ctx, p, "docker.io/library/alpine:3", "command-output"))
+	c.CloseLifecycle()
+	<-seen
+
+	stdout := make([]byte, len("ready\n"))
+	_, err = io.ReadFull(stdoutR, stdout)
+	assert.Nil(t, err)
+	assert.Equal(t, "ready\n", string(stdout))
+	stderr := make([]byte, len("oops\n"))
+	_, err = io.ReadFull(stderrR, stderr)
+	assert.Nil(t, err)
+	assert.Equal(t, "oops\n", string(stderr))
+	assert.Nil(t, c.Close())
+}
+
+// TestPumpShellInputDetach checks that typing the detach keys closes
+// the connection to the shell without it being reattached.
+func TestPumpShellInputDetach(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	c := newAttachedFakeClient(t, engine, func(containerID string, cmd []string) testutil.ExecResult {
+		return testutil.ExecResult{Dropped: true}
+	})
+
+	c.pumpShellInput(strings.NewReader("\x10\x11"))
+	assert.ErrorIs(t, c.pumpShellOutput(context.Background(), io.Discard), ErrDetached)
+	assert.Len(t, engine.CallsTo("ExecCreate"), 1)
+}
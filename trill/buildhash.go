@@ -0,0 +1,151 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	mobyclient "github.com/moby/moby/client"
+	"github.com/moby/patternmatcher"
+)
+
+// BuildHashLabel is the label applied to images built by trill; its
+// value is a hash of the inputs that went into the build, and is used
+// to determine whether or not an image needs to be rebuilt.
+const BuildHashLabel string = "io.github.nlsantos.brig.build-hash"
+
+// hashBuildInputs computes a hash of everything that goes into
+// building an image: the contents of the context directory (minus
+// anything matched by excludes), the Containerfile, and the build
+// options that influence the resulting image, labels included.
+//
+// dockerfilePath is relative to ctxDir, unless absolute. The
+// Containerfile is hashed by content rather than by name, so
+// generated Containerfiles with randomized names don't affect the
+// result.
+func hashBuildInputs(ctxDir string, dockerfilePath string, excludes []string, buildOpts *mobyclient.ImageBuildOptions) (string, error) {
+	slog.Debug("hashing build inputs", "context", ctxDir, "containerfile", dockerfilePath)
+	if !filepath.IsAbs(dockerfilePath) {
+		dockerfilePath = filepath.Join(ctxDir, dockerfilePath)
+	}
+
+	h := sha256.New()
+	if err := hashFile(h, "containerfile", dockerfilePath); err != nil {
+		return "", err
+	}
+
+	pm, err := patternmatcher.New(excludes)
+	if err != nil {
+		return "", err
+	}
+	err = filepath.WalkDir(ctxDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == ctxDir || path == dockerfilePath {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(ctxDir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if excluded, err := pm.MatchesOrParentMatches(relPath); err != nil {
+			return err
+		} else if excluded {
+			if d.IsDir() && !pm.Exclusions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "symlink %s %s\n", relPath, target)
+
+		case d.IsDir():
+			fmt.Fprintf(h, "dir %s\n", relPath)
+
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "mode %s %o\n", relPath, info.Mode().Perm())
+			return hashFile(h, relPath, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if buildOpts != nil {
+		fmt.Fprintf(h, "target %s\n", buildOpts.Target)
+		for _, argName := range slices.Sorted(maps.Keys(buildOpts.BuildArgs)) {
+			if argVal := buildOpts.BuildArgs[argName]; argVal != nil {
+				fmt.Fprintf(h, "arg %s=%s\n", argName, *argVal)
+			} else {
+				fmt.Fprintf(h, "arg %s\n", argName)
+			}
+		}
+		for _, platform := range buildOpts.Platforms {
+			fmt.Fprintf(h, "platform %s/%s\n", platform.OS, platform.Architecture)
+		}
+		for _, labelName := range slices.Sorted(maps.Keys(buildOpts.Labels)) {
+			if labelName != BuildHashLabel {
+				fmt.Fprintf(h, "label %s=%s\n", labelName, buildOpts.Labels[labelName])
+			}
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashFile writes the contents of the file at path into h, preceded
+// by name.
+func hashFile(h hash.Hash, name string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			slog.Error("could not close file handle while hashing", "path", path, "error", err)
+		}
+	}()
+
+	fmt.Fprintf(h, "file %s\n", name)
+	_, err = io.Copy(h, f)
+	return err
+}
@@ -0,0 +1,74 @@
+package trill
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mobyclient "github.com/moby/moby/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHashBuildInputs checks that the build hash only changes when
+// inputs that aren't excluded from the context change.
+func TestHashBuildInputs(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctxDir := t.TempDir()
+	files := map[string]string{
+		"Containerfile":    "FROM scratch\n",
+		"src/main.go":      "package main\n",
+		"build/output.log": "ignored\n",
+	}
+	for name, contents := range files {
+		assert.Nil(t, os.MkdirAll(filepath.Join(ctxDir, filepath.Dir(name)), 0o755))
+		assert.Nil(t, os.WriteFile(filepath.Join(ctxDir, name), []byte(contents), 0o644))
+	}
+	excludes := []string{"build"}
+
+	baseHash, err := hashBuildInputs(ctxDir, "Containerfile", excludes, nil)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, baseHash)
+
+	// Hashing is deterministic
+	hash, err := hashBuildInputs(ctxDir, "Containerfile", excludes, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, baseHash, hash)
+
+	// Excluded files don't affect the hash
+	assert.Nil(t, os.WriteFile(filepath.Join(ctxDir, "build", "output.log"), []byte("changed\n"), 0o644))
+	hash, err = hashBuildInputs(ctxDir, "Containerfile", excludes, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, baseHash, hash)
+
+	// Build args do
+	argVal := "value"
+	hash, err = hashBuildInputs(ctxDir, "Containerfile", excludes, &mobyclient.ImageBuildOptions{
+		BuildArgs: map[string]*string{"ARG": &argVal},
+	})
+	assert.Nil(t, err)
+	assert.NotEqual(t, baseHash, hash)
+
+	// So do labels, other than the one the hash is stored in
+	hash, err = hashBuildInputs(ctxDir, "Containerfile", excludes, &mobyclient.ImageBuildOptions{
+		Labels: map[string]string{BuildHashLabel: "stale"},
+	})
+	assert.Nil(t, err)
+	emptyOptsHash, err := hashBuildInputs(ctxDir, "Containerfile", excludes, &mobyclient.ImageBuildOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, emptyOptsHash, hash)
+	hash, err = hashBuildInputs(ctxDir, "Containerfile", excludes, &mobyclient.ImageBuildOptions{
+		Labels: map[string]string{ImageMetadataLabel: "[]"},
+	})
+	assert.Nil(t, err)
+	assert.NotEqual(t, emptyOptsHash, hash)
+
+	// So does the context's contents
+	assert.Nil(t, os.WriteFile(filepath.Join(ctxDir, "src", "main.go"), []byte("package changed\n"), 0o644))
+	hash, err = hashBuildInputs(ctxDir, "Containerfile", excludes, nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, baseHash, hash)
+}
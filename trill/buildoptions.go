@@ -0,0 +1,258 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	units "github.com/docker/go-units"
+	"github.com/moby/moby/api/types/container"
+	mobyclient "github.com/moby/moby/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// buildCLIFlag is a `docker build` flag that applyBuildCLIOptions
+// knows how to translate.
+type buildCLIFlag struct {
+	takesValue bool // If false, the flag is a boolean one; it can still be passed =true or =false
+	apply      func(buildOpts *mobyclient.ImageBuildOptions, val string) error
+}
+
+// buildCLIFlags are the `docker build` flags applyBuildCLIOptions
+// translates, keyed by their long names.
+//
+// Flags that need BuildKit sessions (e.g., --ssh and --secret) or
+// that control brig's own job (e.g., --tag and --file) have no
+// equivalent in ImageBuildOptions, and are left out.
+var buildCLIFlags = map[string]buildCLIFlag{
+	"add-host": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) error {
+		// Accept docker's host:ip as well as podman's host=ip
+		host, ip, ok := strings.Cut(val, "=")
+		if !ok {
+			host, ip, ok = strings.Cut(val, ":")
+		}
+		if !ok || len(host) == 0 || len(ip) == 0 {
+			return fmt.Errorf("value must be of the form host:ip, not %q", val)
+		}
+		buildOpts.ExtraHosts = append(buildOpts.ExtraHosts, host+":"+ip)
+		return nil
+	}},
+	"build-arg": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) error {
+		name, argVal, ok := strings.Cut(val, "=")
+		if !ok {
+			// As with docker build, a bare name takes its value from
+			// the environment, and is left out if it isn't set there
+			envVal, isSet := os.LookupEnv(name)
+			if !isSet {
+				return nil
+			}
+			argVal = envVal
+		}
+		if buildOpts.BuildArgs == nil {
+			buildOpts.BuildArgs = make(map[string]*string)
+		}
+		buildOpts.BuildArgs[name] = &argVal
+		return nil
+	}},
+	"cache-from": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) error {
+		buildOpts.CacheFrom = append(buildOpts.CacheFrom, val)
+		return nil
+	}},
+	"cgroup-parent": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) error {
+		buildOpts.CgroupParent = val
+		return nil
+	}},
+	"cpu-period": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) (err error) {
+		buildOpts.CPUPeriod, err = strconv.ParseInt(val, 10, 64)
+		return err
+	}},
+	"cpu-quota": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) (err error) {
+		buildOpts.CPUQuota, err = strconv.ParseInt(val, 10, 64)
+		return err
+	}},
+	"cpu-shares": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) (err error) {
+		buildOpts.CPUShares, err = strconv.ParseInt(val, 10, 64)
+		return err
+	}},
+	"cpuset-cpus": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) error {
+		buildOpts.CPUSetCPUs = val
+		return nil
+	}},
+	"cpuset-mems": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) error {
+		buildOpts.CPUSetMems = val
+		return nil
+	}},
+	"force-rm": {false, func(buildOpts *mobyclient.ImageBuildOptions, val string) (err error) {
+		buildOpts.ForceRemove, err = strconv.ParseBool(val)
+		return err
+	}},
+	"isolation": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) error {
+		buildOpts.Isolation = container.Isolation(val)
+		return nil
+	}},
+	"label": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) error {
+		key, labelVal, _ := strings.Cut(val, "=")
+		if buildOpts.Labels == nil {
+			buildOpts.Labels = make(map[string]string)
+		}
+		buildOpts.Labels[key] = labelVal
+		return nil
+	}},
+	"memory": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) (err error) {
+		buildOpts.Memory, err = units.RAMInBytes(val)
+		return err
+	}},
+	"memory-swap": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) (err error) {
+		// -1 means unlimited swap
+		if val == "-1" {
+			buildOpts.MemorySwap = -1
+			return nil
+		}
+		buildOpts.MemorySwap, err = units.RAMInBytes(val)
+		return err
+	}},
+	"network": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) error {
+		buildOpts.NetworkMode = val
+		return nil
+	}},
+	"no-cache": {false, func(buildOpts *mobyclient.ImageBuildOptions, val string) (err error) {
+		buildOpts.NoCache, err = strconv.ParseBool(val)
+		return err
+	}},
+	"platform": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) error {
+		var platforms []ocispec.Platform
+		for spec := range strings.SplitSeq(val, ",") {
+			parts := strings.Split(strings.TrimSpace(spec), "/")
+			if len(parts) < 2 || len(parts) > 3 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+				return fmt.Errorf("platform must be of the form os/arch[/variant], not %q", spec)
+			}
+			platform := ocispec.Platform{OS: parts[0], Architecture: parts[1]}
+			if len(parts) == 3 {
+				platform.Variant = parts[2]
+			}
+			platforms = append(platforms, platform)
+		}
+		buildOpts.Platforms = platforms
+		return nil
+	}},
+	"pull": {false, func(buildOpts *mobyclient.ImageBuildOptions, val string) (err error) {
+		buildOpts.PullParent, err = strconv.ParseBool(val)
+		return err
+	}},
+	"rm": {false, func(buildOpts *mobyclient.ImageBuildOptions, val string) (err error) {
+		buildOpts.Remove, err = strconv.ParseBool(val)
+		return err
+	}},
+	"security-opt": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) error {
+		buildOpts.SecurityOpt = append(buildOpts.SecurityOpt, val)
+		return nil
+	}},
+	"shm-size": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) (err error) {
+		buildOpts.ShmSize, err = units.RAMInBytes(val)
+		return err
+	}},
+	"squash": {false, func(buildOpts *mobyclient.ImageBuildOptions, val string) (err error) {
+		buildOpts.Squash, err = strconv.ParseBool(val)
+		return err
+	}},
+	"target": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) error {
+		buildOpts.Target = val
+		return nil
+	}},
+	"ulimit": {true, func(buildOpts *mobyclient.ImageBuildOptions, val string) error {
+		ulimit, err := units.ParseUlimit(val)
+		if err != nil {
+			return err
+		}
+		buildOpts.Ulimits = append(buildOpts.Ulimits, &container.Ulimit{
+			Name: ulimit.Name,
+			Hard: ulimit.Hard,
+			Soft: ulimit.Soft,
+		})
+		return nil
+	}},
+}
+
+// buildCLIShorthands maps the single-letter `docker build` flags
+// applyBuildCLIOptions knows to their long names.
+var buildCLIShorthands = map[string]string{
+	"c": "cpu-shares",
+	"m": "memory",
+}
+
+// applyBuildCLIOptions translates options, a list of `docker build`
+// command-line flags (i.e., build.options in devcontainer.json), into
+// the equivalent fields of buildOpts.
+//
+// Flag values can either be attached (--flag=value) or be the next
+// element of options. Flags that can't be translated are collected and
+// reported in a single error wrapping ErrUnsupportedBuildOption.
+//
+// Requires buildOpts to be a pointer to its respective struct.
+func applyBuildCLIOptions(options []string, buildOpts *mobyclient.ImageBuildOptions) error {
+	var unsupported []string
+	for i := 0; i < len(options); i++ {
+		arg := options[i]
+		var name, val string
+		var hasVal bool
+		switch {
+		case strings.HasPrefix(arg, "--") && len(arg) > 2:
+			name, val, hasVal = strings.Cut(arg[2:], "=")
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			name = buildCLIShorthands[arg[1:2]]
+			val = strings.TrimPrefix(arg[2:], "=")
+			hasVal = len(val) > 0
+			if len(name) == 0 {
+				name = arg[1:2]
+			}
+		default:
+			return fmt.Errorf("%w: %q isn't a flag", ErrUnsupportedBuildOption, arg)
+		}
+
+		flag, ok := buildCLIFlags[name]
+		if !ok {
+			unsupported = append(unsupported, strings.SplitN(arg, "=", 2)[0])
+			// Skip what's likely to be the flag's value as well
+			if !hasVal && i+1 < len(options) && !strings.HasPrefix(options[i+1], "-") {
+				i++
+			}
+			continue
+		}
+		switch {
+		case !flag.takesValue && !hasVal:
+			val = "true"
+		case flag.takesValue && !hasVal:
+			if i+1 >= len(options) {
+				return fmt.Errorf("build option %s is missing a value", arg)
+			}
+			i++
+			val = options[i]
+		}
+		if err := flag.apply(buildOpts, val); err != nil {
+			return fmt.Errorf("invalid build option %s: %w", arg, err)
+		}
+	}
+
+	if len(unsupported) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnsupportedBuildOption, strings.Join(unsupported, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package trill
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/moby/moby/api/types/container"
+	mobyclient "github.com/moby/moby/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestApplyBuildCLIOptions checks that docker build flags are
+// translated into build options, with their values either attached or
+// passed separately.
+func TestApplyBuildCLIOptions(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Setenv("BRIG_TEST_BUILD_ARG", "from-env")
+
+	buildOpts := &mobyclient.ImageBuildOptions{Remove: true}
+	assert.Nil(t, applyBuildCLIOptions([]string{
+		"--add-host=db:10.0.0.2",
+		"--add-host", "cache=10.0.0.3",
+		"--build-arg", "VERSION=1.2",
+		"--build-arg=BRIG_TEST_BUILD_ARG",
+		"--build-arg=BRIG_TEST_UNSET_BUILD_ARG",
+		"--label", "team=dev",
+		"-m", "1g",
+		"--memory-swap", "-1",
+		"-c512",
+		"--network=host",
+		"--no-cache",
+		"--platform", "linux/arm64/v8",
+		"--pull=false",
+		"--rm=false",
+		"--shm-size=64m",
+		"--target", "dev",
+		"--ulimit", "nofile=1024:2048",
+	}, buildOpts))
+
+	assert.Equal(t, []string{"db:10.0.0.2", "cache:10.0.0.3"}, buildOpts.ExtraHosts)
+	if assert.Len(t, buildOpts.BuildArgs, 2) {
+		assert.Equal(t, "1.2", *buildOpts.BuildArgs["VERSION"])
+		assert.Equal(t, "from-env", *buildOpts.BuildArgs["BRIG_TEST_BUILD_ARG"])
+	}
+	assert.Equal(t, map[string]string{"team": "dev"}, buildOpts.Labels)
+	assert.EqualValues(t, 1<<30, buildOpts.Memory)
+	assert.EqualValues(t, -1, buildOpts.MemorySwap)
+	assert.EqualValues(t, 512, buildOpts.CPUShares)
+	assert.Equal(t, "host", buildOpts.NetworkMode)
+	assert.True(t, buildOpts.NoCache)
+	assert.Equal(t, []ocispec.Platform{{OS: "linux", Architecture: "arm64", Variant: "v8"}}, buildOpts.Platforms)
+	assert.False(t, buildOpts.PullParent)
+	assert.False(t, buildOpts.Remove)
+	assert.EqualValues(t, 64<<20, buildOpts.ShmSize)
+	assert.Equal(t, "dev", buildOpts.Target)
+	assert.Equal(t, []*container.Ulimit{{Name: "nofile", Soft: 1024, Hard: 2048}}, buildOpts.Ulimits)
+}
+
+// TestApplyBuildCLIOptionsUnsupported checks that flags without an
+// equivalent are all reported at once, and that malformed ones are
+// rejected.
+func TestApplyBuildCLIOptionsUnsupported(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	err := applyBuildCLIOptions([]string{"--ssh", "default", "--network=host", "--secret=id=token", "-t", "other"}, &mobyclient.ImageBuildOptions{})
+	assert.ErrorIs(t, err, ErrUnsupportedBuildOption)
+	assert.ErrorContains(t, err, "--ssh, --secret, -t")
+
+	err = applyBuildCLIOptions([]string{"host"}, &mobyclient.ImageBuildOptions{})
+	assert.ErrorIs(t, err, ErrUnsupportedBuildOption)
+
+	for _, options := range [][]string{
+		{"--target"},
+		{"--memory=lots"},
+		{"--add-host=db"},
+		{"--platform=linux"},
+		{"--no-cache=maybe"},
+		{"--ulimit=nofile"},
+	} {
+		err := applyBuildCLIOptions(options, &mobyclient.ImageBuildOptions{})
+		assert.NotNil(t, err, options)
+		assert.NotErrorIs(t, err, ErrUnsupportedBuildOption, options)
+	}
+}
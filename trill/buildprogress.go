@@ -0,0 +1,388 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+// BuildProgress selects how the progress of image builds is shown.
+type BuildProgress string
+
+// Supported values for BuildProgress
+const (
+	BuildProgressDefault BuildProgress = ""      // Like BuildProgressPlain, unless build output is suppressed
+	BuildProgressPlain   BuildProgress = "plain" // The build's output is printed as-is, along with how long each step took
+	BuildProgressQuiet   BuildProgress = "quiet" // Only errors are printed
+	BuildProgressTTY     BuildProgress = "tty"   // The running step is shown with its latest output, then collapsed to a line once it's done
+)
+
+// BuildProgressTTYOutputLines is how many of the latest lines of
+// output of the running step are shown with BuildProgressTTY.
+const BuildProgressTTYOutputLines int = 6
+
+// BuildProgressTTYRefreshInterval is how often the running step is
+// redrawn with BuildProgressTTY, so its elapsed time stays current
+// even when it doesn't output anything.
+const BuildProgressTTYRefreshInterval time.Duration = 100 * time.Millisecond
+
+// buildStepPattern matches the line the engine outputs at the start
+// of each step of a build; Docker writes "Step 1/5 : ...", while
+// Podman writes "STEP 1/5: ...".
+var buildStepPattern = regexp.MustCompile(`(?i)^step (\d+/\d+)\s*:\s*(.*)$`)
+
+// ParseBuildProgress converts s into a BuildProgress, failing if it
+// isn't one of the supported values.
+func ParseBuildProgress(s string) (BuildProgress, error) {
+	switch progress := BuildProgress(s); progress {
+	case BuildProgressDefault, BuildProgressPlain, BuildProgressQuiet, BuildProgressTTY:
+		return progress, nil
+	default:
+		return "", fmt.Errorf("unsupported build progress mode: %s", s)
+	}
+}
+
+// buildRenderer shows the output of a build as it comes in.
+type buildRenderer interface {
+	Stream(text string) // Handles a chunk of the build's output
+	Error(msg string)   // Handles an error reported by the build
+	Close()             // Flushes what's left to show once the build is over
+}
+
+// buildStep is a step of a build, and the output it's produced.
+type buildStep struct {
+	title  string // e.g., "1/5: FROM alpine"
+	start  time.Time
+	output []string
+}
+
+// buildLines splits the chunks of a build's output into lines.
+type buildLines struct {
+	pending string
+}
+
+// feed splits text into lines, passing each complete one to fn and
+// holding on to the rest until the next call.
+func (b *buildLines) feed(text string, fn func(line string)) {
+	text = b.pending + strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	b.pending = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		fn(line)
+	}
+}
+
+// flush passes the incomplete line being held on to, if any, to fn.
+func (b *buildLines) flush(fn func(line string)) {
+	if len(b.pending) > 0 {
+		fn(b.pending)
+		b.pending = ""
+	}
+}
+
+// formatStepDuration formats d the way step timings are shown.
+func formatStepDuration(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
+
+// plainBuildRenderer prints a build's output as-is, each line
+// prefixed, and how long each step took once it's done.
+type plainBuildRenderer struct {
+	printf    func(format string, a ...any) (int, error)
+	errPrintf func(format string, a ...any) (int, error)
+	now       func() time.Time
+	lines     buildLines
+	step      *buildStep
+}
+
+// newPlainBuildRenderer returns a plainBuildRenderer for the build of
+// the image tagged imageTag, which prints out through out, so its
+// lines aren't mixed up with those of other builds running alongside
+// it.
+func newPlainBuildRenderer(out *outputMux, imageTag string) *plainBuildRenderer {
+	return &plainBuildRenderer{
+		printf:    out.printf(outputPrefix("BUILD", imageTag)),
+		errPrintf: out.printf(outputErrorPrefix("BUILD")),
+		now:       time.Now,
+	}
+}
+
+// Stream implements buildRenderer.
+func (r *plainBuildRenderer) Stream(text string) {
+	r.lines.feed(text, r.line)
+}
+
+// line prints a line of output, ending the current step first if the
+// line starts the next one.
+func (r *plainBuildRenderer) line(line string) {
+	if match := buildStepPattern.FindStringSubmatch(line); match != nil {
+		r.endStep()
+		r.step = &buildStep{title: match[1] + ": " + match[2], start: r.now()}
+	}
+	_, _ = r.printf("%s\r\n", line)
+}
+
+// endStep prints how long the current step took, if there is one.
+func (r *plainBuildRenderer) endStep() {
+	if r.step != nil {
+		_, _ = r.printf("DONE %s\r\n", formatStepDuration(r.now().Sub(r.step.start)))
+		r.step = nil
+	}
+}
+
+// Error implements buildRenderer.
+func (r *plainBuildRenderer) Error(msg string) {
+	r.lines.flush(r.line)
+	// The step didn't finish, so it doesn't get a timing
+	r.step = nil
+	_, _ = r.errPrintf("%s\r\n", msg)
+}
+
+// Close implements buildRenderer.
+func (r *plainBuildRenderer) Close() {
+	r.lines.flush(r.line)
+	r.endStep()
+}
+
+// ttyBuildRenderer shows the step a build is running along with the
+// latest lines of its output, redrawing them in place; steps that are
+// done are collapsed into a line with how long they took. If the build
+// fails, the whole output of the step it failed at is kept.
+//
+// The running step is kept below whatever else is written through the
+// outputMux it draws on, e.g., the output of builds running alongside
+// it, which is printed as with BuildProgressPlain.
+type ttyBuildRenderer struct {
+	out    *outputMux // Its lock guards the fields below
+	prefix string     // Shown at the start of every line
+	width  int        // Lines are truncated to this many characters, so they don't wrap
+	now    func() time.Time
+
+	lines     buildLines
+	step      *buildStep
+	failed    string // The error the build reported, if any
+	liveLines int    // How many lines the running step currently takes up
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// newTTYBuildRenderer returns a ttyBuildRenderer that draws through
+// out, on a terminal, for the build of the image tagged imageTag, and
+// starts refreshing it.
+func newTTYBuildRenderer(out *outputMux, fd int, imageTag string) *ttyBuildRenderer {
+	width, _, err := term.GetSize(fd)
+	if err != nil || width <= 0 {
+		width = 80
+	}
+	r := &ttyBuildRenderer{
+		out:     out,
+		prefix:  fmt.Sprintf("%s %s ", color.New(color.BgHiGreen, color.FgBlack).Sprint(" BUILD "), color.New(color.FgHiWhite).Sprint(imageTag)),
+		width:   width - len(" BUILD  ") - utf8.RuneCountInString(imageTag) - 1,
+		now:     time.Now,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	out.mu.Lock()
+	out.setLive(r)
+	out.mu.Unlock()
+	go r.refresh()
+	return r
+}
+
+// refresh redraws the running step periodically until r is closed.
+func (r *ttyBuildRenderer) refresh() {
+	defer close(r.stopped)
+	ticker := time.NewTicker(BuildProgressTTYRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.out.mu.Lock()
+			r.draw()
+			r.out.mu.Unlock()
+		}
+	}
+}
+
+// Stream implements buildRenderer.
+func (r *ttyBuildRenderer) Stream(text string) {
+	r.out.mu.Lock()
+	defer r.out.mu.Unlock()
+	r.lines.feed(text, r.line)
+	r.draw()
+}
+
+// line adds a line of output to the current step, or starts the next
+// step if the line is the start of one.
+func (r *ttyBuildRenderer) line(line string) {
+	if match := buildStepPattern.FindStringSubmatch(line); match != nil {
+		r.endStep()
+		r.step = &buildStep{title: match[1] + ": " + match[2], start: r.now()}
+		return
+	}
+	line = strings.TrimRight(line, " \t")
+	if len(line) == 0 {
+		return
+	}
+	if r.step == nil {
+		// Output from before the first step isn't part of any
+		r.clear()
+		r.println(line)
+		return
+	}
+	r.step.output = append(r.step.output, line)
+}
+
+// endStep collapses the current step, if there is one, into a line
+// with how long it took.
+func (r *ttyBuildRenderer) endStep() {
+	if r.step == nil {
+		return
+	}
+	r.clear()
+	r.println(fmt.Sprintf("=> %s %s", r.step.title, color.New(color.FgCyan).Sprint(formatStepDuration(r.now().Sub(r.step.start)))))
+	r.step = nil
+}
+
+// Error implements buildRenderer.
+func (r *ttyBuildRenderer) Error(msg string) {
+	r.out.mu.Lock()
+	defer r.out.mu.Unlock()
+	r.lines.flush(r.line)
+	r.failed = msg
+}
+
+// Close implements buildRenderer.
+func (r *ttyBuildRenderer) Close() {
+	close(r.stop)
+	<-r.stopped
+
+	r.out.mu.Lock()
+	defer r.out.mu.Unlock()
+	r.out.setLive(nil)
+	r.lines.flush(r.line)
+	if len(r.failed) == 0 {
+		r.endStep()
+		return
+	}
+
+	r.clear()
+	if r.step != nil {
+		r.println(fmt.Sprintf("=> %s %s", r.step.title, color.New(color.FgRed).Sprint("ERROR")))
+		for _, line := range r.step.output {
+			r.println("   " + line)
+		}
+		r.step = nil
+	}
+	_, _ = fmt.Fprintf(r.out.writer(), "%s%s %s\r\n", r.prefix, color.New(color.BgHiRed, color.FgBlack, color.Bold).Sprint(" ERROR "), r.failed)
+}
+
+// draw implements liveRegion, redrawing the running step with its
+// elapsed time and latest output.
+//
+// r.out.mu must be held.
+func (r *ttyBuildRenderer) draw() {
+	r.clear()
+	if r.step == nil {
+		return
+	}
+	r.println(fmt.Sprintf("=> %s %s", r.step.title, color.New(color.FgYellow).Sprint(formatStepDuration(r.now().Sub(r.step.start)))))
+	output := r.step.output[max(len(r.step.output)-BuildProgressTTYOutputLines, 0):]
+	for _, line := range output {
+		r.println("   " + line)
+	}
+	r.liveLines = 1 + len(output)
+}
+
+// clear implements liveRegion, erasing the running step from the
+// terminal.
+//
+// r.out.mu must be held.
+func (r *ttyBuildRenderer) clear() {
+	if r.liveLines > 0 {
+		// Move to the start of the step's first line, then erase
+		// everything below it
+		_, _ = fmt.Fprintf(r.out.writer(), "\x1b[%dF\x1b[J", r.liveLines)
+		r.liveLines = 0
+	}
+}
+
+// println writes line, truncated to fit on a single line of the
+// terminal.
+//
+// r.out.mu must be held.
+func (r *ttyBuildRenderer) println(line string) {
+	_, _ = fmt.Fprintf(r.out.writer(), "%s%s\r\n", r.prefix, truncateVisible(line, r.width))
+}
+
+// truncateVisible truncates s to width characters, not counting the
+// escape sequences in it, which are kept so colors are still reset.
+func truncateVisible(s string, width int) string {
+	var b strings.Builder
+	visible, inEscape := 0, false
+	for _, c := range s {
+		if c == '\x1b' {
+			inEscape = true
+		}
+		if inEscape {
+			b.WriteRune(c)
+			// Escape sequences end with a character in this range
+			if c != '\x1b' && c != '[' && c >= '@' && c <= '~' {
+				inEscape = false
+			}
+			continue
+		}
+		if visible < width {
+			b.WriteRune(c)
+			visible++
+		}
+	}
+	return b.String()
+}
+
+// newBuildRenderer returns the buildRenderer for the build of the
+// image tagged imageTag, as selected by c.BuildProgress.
+//
+// BuildProgressTTY needs stdout to be a terminal, and only one build to
+// be drawn at a time; otherwise (or if the build's output is
+// suppressed), it falls back to BuildProgressPlain. Either way, output
+// goes through c.output, so builds running concurrently don't garble
+// each other's. The returned function has to be called once the build
+// is over.
+func (c *Client) newBuildRenderer(imageTag string, suppressOutput bool) (buildRenderer, func()) {
+	fd := int(os.Stdout.Fd())
+	if c.BuildProgress == BuildProgressTTY && !suppressOutput && term.IsTerminal(fd) && c.ttyBuildMu.TryLock() {
+		r := newTTYBuildRenderer(&c.output, fd, imageTag)
+		return r, func() {
+			r.Close()
+			c.ttyBuildMu.Unlock()
+		}
+	}
+	r := newPlainBuildRenderer(&c.output, imageTag)
+	return r, r.Close
+}
@@ -0,0 +1,110 @@
+package trill
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBuildClock returns a clock for build renderers that only moves
+// when advanced.
+func fakeBuildClock() (now func() time.Time, advance func(time.Duration)) {
+	current := time.Unix(0, 0)
+	return func() time.Time { return current }, func(d time.Duration) { current = current.Add(d) }
+}
+
+// TestPlainBuildRenderer checks that the build's output is passed
+// through line by line, with how long each step took.
+func TestPlainBuildRenderer(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var out bytes.Buffer
+	printf := func(format string, a ...any) (int, error) { return fmt.Fprintf(&out, format, a...) }
+	now, advance := fakeBuildClock()
+	r := &plainBuildRenderer{printf: printf, errPrintf: printf, now: now}
+
+	r.Stream("Step 1/2 : FROM alpine\n ---> 1234")
+	advance(1500 * time.Millisecond)
+	r.Stream("abcd\nSTEP 2/2: RUN make\n")
+	advance(3 * time.Second)
+	r.Stream("built\n")
+	r.Close()
+
+	assert.Equal(t, strings.Join([]string{
+		"Step 1/2 : FROM alpine",
+		" ---> 1234abcd",
+		"DONE 1.5s",
+		"STEP 2/2: RUN make",
+		"built",
+		"DONE 3.0s",
+		"",
+	}, "\r\n"), out.String())
+}
+
+// TestTTYBuildRenderer checks that steps are collapsed once they're
+// done, and that the output of the step a build failed at is kept.
+func TestTTYBuildRenderer(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	newRenderer := func(w io.Writer) (*ttyBuildRenderer, func(time.Duration)) {
+		now, advance := fakeBuildClock()
+		r := &ttyBuildRenderer{out: &outputMux{w: w}, width: 40, now: now, stop: make(chan struct{}), stopped: make(chan struct{})}
+		// Refreshes aren't needed; the renderer draws on every update
+		close(r.stopped)
+		return r, advance
+	}
+
+	var out bytes.Buffer
+	r, advance := newRenderer(&out)
+	r.Stream("Step 1/2 : FROM alpine\n")
+	advance(2 * time.Second)
+	r.Stream("Step 2/2 : RUN echo building\n")
+	for i := range 10 {
+		r.Stream(fmt.Sprintf("line %d\n", i))
+	}
+	advance(time.Second)
+	// Only the latest lines are shown while the step runs
+	frames := strings.Split(out.String(), "\x1b[J")
+	lastFrame := frames[len(frames)-1]
+	assert.True(t, strings.HasPrefix(lastFrame, "=> 2/2: RUN echo building"))
+	assert.Contains(t, lastFrame, "   line 9\r\n")
+	assert.NotContains(t, lastFrame, "line 3")
+	r.Close()
+	assert.Contains(t, out.String(), "=> 1/2: FROM alpine 2.0s\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "\x1b[7F\x1b[J=> 2/2: RUN echo building 1.0s\r\n"))
+
+	out.Reset()
+	r, advance = newRenderer(&out)
+	r.Stream("Step 1/1 : RUN make\n")
+	for i := range 10 {
+		r.Stream(fmt.Sprintf("line %d\n", i))
+	}
+	advance(time.Second)
+	r.Error("The command '/bin/sh -c make' returned a non-zero code: 2")
+	r.Close()
+	failure := out.String()[strings.LastIndex(out.String(), "\x1b[J")+len("\x1b[J"):]
+	assert.True(t, strings.HasPrefix(failure, "=> 1/1: RUN make ERROR\r\n"))
+	for i := range 10 {
+		assert.Contains(t, failure, fmt.Sprintf("   line %d\r\n", i))
+	}
+	assert.Contains(t, failure, "returned a non-zero code: 2")
+}
+
+// TestTruncateVisible checks that escape sequences don't count
+// towards the width lines are truncated to.
+func TestTruncateVisible(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.Equal(t, "abc", truncateVisible("abcdef", 3))
+	assert.Equal(t, "\x1b[36mabc\x1b[0m", truncateVisible("\x1b[36mabcdef\x1b[0m", 3))
+	assert.Equal(t, "short", truncateVisible("short", 10))
+}
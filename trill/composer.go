@@ -0,0 +1,1599 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	compose "github.com/compose-spec/compose-go/cli"
+	composetypes "github.com/compose-spec/compose-go/types"
+	"github.com/heimdalr/dag"
+	dockerspecs "github.com/moby/docker-image-spec/specs-go/v1"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/events"
+	"github.com/moby/moby/api/types/mount"
+	"github.com/moby/moby/api/types/network"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/writ"
+	"golang.org/x/sync/errgroup"
+)
+
+// Defaults for the Client fields governing how long service
+// dependencies are waited on, and how often the devcontainer's health
+// is polled
+const (
+	DefaultDependencyPollInterval time.Duration = 1 * time.Second
+	DefaultDependencySettleTime   time.Duration = 5 * time.Second
+	DefaultDependencyTimeout      time.Duration = 2 * time.Minute
+)
+
+// MaxConcurrentImageJobs is the maximum number of image pulls/builds
+// that are performed concurrently when preparing a Composer project's
+// images.
+const MaxConcurrentImageJobs int = 4
+
+// ComposeOptions holds the settings for deploying a Composer project.
+type ComposeOptions struct {
+	ProjectName          string         // Name of the project; prefixes the names of the resources created for it
+	FollowServiceOutput  bool           // If true, ServiceOutput keeps being streamed to once the project is up, until it's torn down
+	ImageTagPrefix       string         // Prefix for the tags of images built for services
+	RecreateNetworks     bool           // If true, networks left over from an earlier run are removed and created anew instead of being reused
+	Scale                map[string]int // Number of replicas to run of the services it names, overriding their deploy.replicas; see ParseScale
+	ServiceOutput        io.Writer      // Where what the services (bar the devcontainer's) print out is streamed to while the project is brought up; nil leaves it out
+	SkipBuildIfAvailable bool           // If true, service images are only built if they don't exist yet
+	SkipPullIfAvailable  bool           // If true, service images are only pulled if they don't exist yet
+	SuppressOutput       bool           // If true, build and pull progress isn't printed out
+}
+
+// DeployComposerProject provisions a Composer project as referenced
+// by a devcontainer.json configuration.
+//
+// It is not dissimlar for running `docker compose up` inside your
+// codebase.
+//
+// If deployment fails partway through, the networks and containers
+// created up to that point are torn down, unless c.KeepOnFailure is
+// set.
+func (c *Client) DeployComposerProject(ctx context.Context, p *writ.DevcontainerParser, opts ComposeOptions) (err error) {
+	defer func() {
+		if err == nil {
+			return
+		}
+		if c.KeepOnFailure {
+			slog.Warn("leaving partially deployed Composer project resources in place as instructed", "containers", slices.Collect(maps.Values(c.createdContainers)), "networks", c.createdNetworks)
+			// Forget about them so that a later teardown doesn't
+			// remove them either
+			c.untrackComposerResources()
+			return
+		}
+		slog.Info("rolling back partially deployed Composer project")
+		// The rollback needs to go through even if ctx has been
+		// cancelled
+		if rollbackErr := c.TeardownComposerProject(context.WithoutCancel(ctx)); rollbackErr != nil {
+			slog.Error("encountered an error while rolling back the Composer project", "error", rollbackErr)
+		}
+	}()
+	// Ended ahead of the rollback, which isn't part of the deployment
+	ctx, endSpan := c.startSpan(ctx, "deploy Compose project")
+	defer func() { endSpan(err) }()
+
+	if err = c.loadComposerProject(ctx, p, opts.ProjectName); err != nil {
+		return err
+	}
+
+	if err = c.applyServiceScale(opts.Scale, *p.Config.Service); err != nil {
+		return err
+	}
+
+	c.startServiceOutput(ctx, opts.ServiceOutput, *p.Config.Service)
+	// Stopped ahead of the rollback, so the output of the services
+	// that failed has been written out by then
+	defer func() {
+		if err != nil || !opts.FollowServiceOutput {
+			c.stopServiceOutput()
+		}
+	}()
+
+	if c.servicesDAG, err = c.buildServicesDAG(); err != nil {
+		return err
+	}
+
+	// Check that p.Config.Service is named as a container in the
+	// Composer project, otherwise we won't know which one to attach
+	// to.
+	if _, err := c.servicesDAG.GetVertex(*p.Config.Service); err != nil {
+		slog.Debug("service container in devcontainer.json not named in Composer YAML", "service", *p.Config.Service, "vertices", maps.Keys(c.servicesDAG.GetVertices()))
+		return fmt.Errorf("service container in devcontainer.json not named in Composer YAML: %s", *p.Config.Service)
+	}
+
+	if err = c.createComposerNetworks(ctx, c.composerProject.Networks, c.ResourceLabels(p), opts.RecreateNetworks); err != nil {
+		slog.Error("encountered an error while attempting to create network(s)", "error", err)
+		return err
+	}
+
+	if err = c.createComposerVolumes(ctx, c.composerProject.Volumes, c.ResourceLabels(p)); err != nil {
+		slog.Error("encountered an error while attempting to create service volume(s)", "error", err)
+		return err
+	}
+
+	if err = c.prepareComposerImages(ctx, opts); err != nil {
+		slog.Error("encountered an error while trying to prepare service image(s)", "error", err)
+		return err
+	}
+
+	if err = c.diffComposerServices(ctx, p, opts.ImageTagPrefix); err != nil {
+		slog.Error("encountered an error while comparing services with their existing containers", "error", err)
+		return err
+	}
+
+	spinUpDAG, err := c.servicesDAG.Copy()
+	if err != nil {
+		slog.Error("could not duplicate services DAG", "error", err)
+		return nil
+	}
+
+	if err = c.createComposerServices(ctx, p, spinUpDAG, opts.ImageTagPrefix); err != nil {
+		slog.Error("encountered an error while trying to spin up service(s)", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// TeardownComposerProject tears down a provisioned Composer project's
+// resources.
+//
+// It is not dissimlar to running `docker compose down` inside your
+// codebase.
+//
+// Only the containers and networks brig itself created are removed.
+// As this is usually called during cleanup, callers should take care
+// not to pass in a ctx that has already been cancelled.
+//
+// Errors don't stop the teardown; every resource is given a chance to
+// be removed, and the errors encountered are returned together.
+func (c *Client) TeardownComposerProject(ctx context.Context) error {
+	if c.composerProject == nil || c.servicesDAG == nil {
+		slog.Debug("no Composer project has been loaded; nothing to tear down")
+		return nil
+	}
+	c.stopServiceOutput()
+
+	slog.Debug("tearing down resources related to the Composer project")
+	teardownDAG, err := c.servicesDAG.Copy()
+	if err != nil {
+		return err
+	}
+	errs := []error{c.teardownComposerServices(ctx, teardownDAG)}
+
+	c.resourcesMu.Lock()
+	defer c.resourcesMu.Unlock()
+	var remainingNetworks []string
+	for _, networkName := range c.createdNetworks {
+		slog.Debug("removing generated network", "network", networkName)
+		if _, err := c.mobyClient.NetworkRemove(ctx, networkName, mobyclient.NetworkRemoveOptions{}); err != nil {
+			errs = append(errs, err)
+			remainingNetworks = append(remainingNetworks, networkName)
+		}
+	}
+	c.createdNetworks = remainingNetworks
+
+	if len(c.fileObjectsDir) > 0 {
+		slog.Debug("removing materialized secrets and configs", "path", c.fileObjectsDir)
+		if err := os.RemoveAll(c.fileObjectsDir); err != nil {
+			errs = append(errs, err)
+		} else {
+			c.fileObjectsDir = ""
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// buildServiceBuildOpts creates a mobyclient.ImageBuildOptions from a
+// composetypes.BuildConfig; the result is used when provisioning the
+// container for the target service.
+//
+// It returns the first error it encounters.
+func (c *Client) buildServiceBuildOpts(serviceName string, buildCfg *composetypes.BuildConfig, suppressOutput bool) (buildOpts *mobyclient.ImageBuildOptions, err error) {
+	if buildCfg == nil {
+		return nil, nil
+	}
+
+	if len(buildCfg.DockerfileInline) > 0 {
+		containerfilePath, err := c.synthesizeInlineContainerfile(serviceName, &buildCfg.DockerfileInline)
+		if err != nil {
+			slog.Error("encountered an error while attempting to synthesize a Containerfile from an inlined one", "error", err)
+			return nil, err
+		}
+		buildCfg.Dockerfile = containerfilePath
+	}
+
+	buildOpts = &mobyclient.ImageBuildOptions{
+		Tags:           buildCfg.Tags,
+		SuppressOutput: suppressOutput,
+		NoCache:        buildCfg.NoCache,
+		PullParent:     buildCfg.Pull || c.PullPolicy == PullPolicyAlways,
+		Isolation:      container.Isolation(buildCfg.Isolation),
+		NetworkMode:    buildCfg.Network, // This might not be equivalent
+		Dockerfile:     buildCfg.Dockerfile,
+		BuildArgs:      buildCfg.Args,
+		Labels:         buildCfg.Labels,
+		CacheFrom:      buildCfg.CacheFrom,
+		Target:         buildCfg.Target,
+	}
+
+	for name, uliimit := range buildCfg.Ulimits {
+		buildOpts.Ulimits = append(buildOpts.Ulimits, &container.Ulimit{
+			Name: name,
+			Hard: int64(uliimit.Hard),
+			Soft: int64(uliimit.Soft),
+		})
+	}
+
+	return buildOpts, err
+}
+
+// buildServiceContainerConfig creates a container.Config based on a
+// composetypes.ServiceConfig; it is eventually used to provision the
+// container for the target service.
+func (c *Client) buildServiceContainerConfig(p *writ.DevcontainerParser, serviceCfg *composetypes.ServiceConfig) *container.Config {
+	// We mostly want the Env field and some defaults set...
+	containerCfg := c.buildContainerConfig(p, serviceCfg.Image)
+	// ... we overwrite where needed
+	containerCfg.Hostname = serviceCfg.Hostname
+	containerCfg.Domainname = serviceCfg.DomainName
+	containerCfg.Tty = serviceCfg.Tty
+	containerCfg.OpenStdin = serviceCfg.StdinOpen
+	containerCfg.Cmd = serviceCfg.Command
+	containerCfg.Entrypoint = serviceCfg.Entrypoint
+	// Labels from the Composer YAML win over the ones identifying the
+	// devcontainer, which are applied to every service
+	containerCfg.Labels = mergeLabels(containerCfg.Labels, serviceCfg.Labels)
+	containerCfg.StopSignal = serviceCfg.StopSignal
+	// workspaceFolder only applies to the primary service; see
+	// createComposerService
+	containerCfg.WorkingDir = serviceCfg.WorkingDir
+
+	if serviceCfg.Attach != nil {
+		containerCfg.AttachStdin = *serviceCfg.Attach
+		containerCfg.AttachStdout = *serviceCfg.Attach
+		containerCfg.AttachStderr = *serviceCfg.Attach
+	}
+
+	if serviceCfg.HealthCheck != nil && !serviceCfg.HealthCheck.Disable {
+		containerCfg.Healthcheck = &dockerspecs.HealthcheckConfig{
+			Test:          serviceCfg.HealthCheck.Test,
+			Interval:      time.Duration(*serviceCfg.HealthCheck.Interval),
+			Timeout:       time.Duration(*serviceCfg.HealthCheck.Timeout),
+			StartPeriod:   time.Duration(*serviceCfg.HealthCheck.StartPeriod),
+			StartInterval: time.Duration(*serviceCfg.HealthCheck.StartInterval),
+			Retries:       int(*serviceCfg.HealthCheck.Retries),
+		}
+	}
+
+	// The service's environment already includes the contents of its
+	// env_file entries; it's layered on top of the proxy settings, and,
+	// for the primary service, containerEnv from devcontainer.json on
+	// top of that
+	env := c.proxyEnv()
+	for key, val := range serviceCfg.Environment {
+		if val != nil {
+			env[key] = *val
+		} else if projEnv, ok := c.composerProject.Environment[key]; ok {
+			env[key] = projEnv
+		} else if localEnv, ok := os.LookupEnv(key); ok {
+			env[key] = localEnv
+		}
+	}
+	if p.Config.Service != nil && serviceCfg.Name == *p.Config.Service {
+		maps.Copy(env, p.Config.ContainerEnv)
+	}
+	containerCfg.Env = make([]string, 0, len(env))
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		containerCfg.Env = append(containerCfg.Env, fmt.Sprintf("%s=%s", key, env[key]))
+	}
+
+	containerCfg.User = serviceCfg.User
+
+	return containerCfg
+}
+
+func (c *Client) buildServiceHostConfig(serviceCfg *composetypes.ServiceConfig) *container.HostConfig {
+	hostCfg := container.HostConfig{
+		PortBindings:   make(network.PortMap),
+		AutoRemove:     false, // This is handled when the project is torn down
+		CapAdd:         serviceCfg.CapAdd,
+		CapDrop:        serviceCfg.CapDrop,
+		DNSOptions:     serviceCfg.DNSOpts,
+		GroupAdd:       serviceCfg.GroupAdd,
+		IpcMode:        container.IpcMode(serviceCfg.Ipc),
+		Cgroup:         container.CgroupSpec(serviceCfg.Cgroup),
+		Links:          serviceCfg.Links,
+		OomScoreAdj:    int(serviceCfg.OomScoreAdj),
+		PidMode:        container.PidMode(serviceCfg.Pid),
+		Privileged:     serviceCfg.Privileged,
+		ReadonlyRootfs: serviceCfg.ReadOnly,
+		SecurityOpt:    serviceCfg.SecurityOpt,
+		UTSMode:        container.UTSMode(serviceCfg.Uts),
+		UsernsMode:     container.UsernsMode(serviceCfg.UserNSMode),
+		ShmSize:        int64(serviceCfg.ShmSize),
+		Sysctls:        serviceCfg.Sysctls,
+		Runtime:        serviceCfg.Runtime,
+		Isolation:      container.Isolation(serviceCfg.Isolation),
+		Init:           serviceCfg.Init,
+	}
+	hostCfg.Resources = buildServiceResources(serviceCfg)
+	hostCfg.RestartPolicy = buildServiceRestartPolicy(serviceCfg)
+	if serviceCfg.Logging != nil {
+		hostCfg.LogConfig = container.LogConfig{
+			Type:   serviceCfg.Logging.Driver,
+			Config: serviceCfg.Logging.Options,
+		}
+	}
+
+	for _, dns := range serviceCfg.DNS {
+		hostCfg.DNS = append(hostCfg.DNS, netip.MustParseAddr(dns))
+	}
+
+	for host, addr := range serviceCfg.ExtraHosts {
+		hostCfg.ExtraHosts = append(hostCfg.ExtraHosts, fmt.Sprintf("%s:%s", host, addr))
+	}
+
+	if len(serviceCfg.Tmpfs) > 0 {
+		hostCfg.Tmpfs = make(map[string]string)
+	}
+	for _, tmpfs := range serviceCfg.Tmpfs {
+		// Entries may carry mount options after the path (e.g.,
+		// /run:size=64m,mode=1777)
+		tmpfsPath, tmpfsOpts, _ := strings.Cut(tmpfs, ":")
+		hostCfg.Tmpfs[tmpfsPath] = tmpfsOpts
+	}
+
+	for _, volume := range serviceCfg.Volumes {
+		if volumeCfg, ok := c.composerProject.Volumes[volume.Source]; volume.Type == composetypes.VolumeTypeVolume && ok {
+			// Named volumes are referenced by their key in the
+			// Composer YAML; swap that out for the actual name
+			volume.Source = volumeCfg.Name
+		}
+		if useServiceVolumeBind(volume) {
+			hostCfg.Binds = append(hostCfg.Binds, volume.String())
+			continue
+		}
+		hostCfg.Mounts = append(hostCfg.Mounts, buildServiceMount(volume))
+	}
+
+	return &hostCfg
+}
+
+// useServiceVolumeBind reports whether volume has to be passed to
+// the engine as a bind string instead of as a structured mount.
+//
+// Structured mounts are preferred as they're able to carry all of a
+// volume's options, but the engine won't create a missing host path
+// for them (see https://github.com/moby/moby/issues/43483) and they
+// have no way of expressing SELinux relabeling. Like docker compose,
+// fall back to a bind string for bind mounts that need either.
+func useServiceVolumeBind(volume composetypes.ServiceVolumeConfig) bool {
+	if volume.Type != composetypes.VolumeTypeBind || volume.Bind == nil {
+		return false
+	}
+	return volume.Bind.CreateHostPath || len(volume.Bind.SELinux) > 0
+}
+
+// buildServiceMount converts an entry in a service's volumes into the
+// equivalent Moby mount, carrying over its access mode and
+// type-specific options.
+func buildServiceMount(volume composetypes.ServiceVolumeConfig) mount.Mount {
+	serviceMount := mount.Mount{
+		Type:        mount.Type(volume.Type),
+		Source:      volume.Source,
+		Target:      volume.Target,
+		ReadOnly:    volume.ReadOnly,
+		Consistency: mount.Consistency(volume.Consistency),
+	}
+
+	switch volume.Type {
+	case composetypes.VolumeTypeBind:
+		if volume.Bind != nil && len(volume.Bind.Propagation) > 0 {
+			serviceMount.BindOptions = &mount.BindOptions{
+				Propagation: mount.Propagation(volume.Bind.Propagation),
+			}
+		}
+	case composetypes.VolumeTypeVolume:
+		if volume.Volume != nil && volume.Volume.NoCopy {
+			serviceMount.VolumeOptions = &mount.VolumeOptions{
+				NoCopy: true,
+			}
+		}
+	case composetypes.VolumeTypeTmpfs:
+		// tmpfs mounts have no source; the engine rejects them if one
+		// is set
+		serviceMount.Source = ""
+		if volume.Tmpfs != nil {
+			serviceMount.TmpfsOptions = &mount.TmpfsOptions{
+				SizeBytes: int64(volume.Tmpfs.Size),
+				Mode:      os.FileMode(volume.Tmpfs.Mode),
+			}
+		}
+	}
+
+	return serviceMount
+}
+
+// bindServiceWorkspaceMount adds the workspace mount to the primary
+// service's container.
+//
+// Composer projects commonly mount the workspace themselves, so the
+// mount is skipped if one of the service's volumes already targets
+// the same path; otherwise, the engine would reject the container for
+// having duplicate mount points.
+func (c *Client) bindServiceWorkspaceMount(p *writ.DevcontainerParser, serviceCfg *composetypes.ServiceConfig, hostCfg *container.HostConfig) {
+	workspaceMount := c.buildWorkspaceMount(p)
+	for _, volume := range serviceCfg.Volumes {
+		if path.Clean(volume.Target) == path.Clean(workspaceMount.Target) {
+			slog.Debug("service already mounts the workspace folder; skipping workspace mount", "service", serviceCfg.Name, "target", workspaceMount.Target)
+			return
+		}
+	}
+	addMount(hostCfg, workspaceMount)
+}
+
+// buildServiceResources maps a service's resource constraints into
+// the equivalent Moby structure.
+//
+// As with docker compose, the limits and reservations under
+// deploy.resources take precedence over their service-level
+// counterparts (e.g., mem_limit and cpus).
+func buildServiceResources(serviceCfg *composetypes.ServiceConfig) container.Resources {
+	resources := container.Resources{
+		CPUCount:           serviceCfg.CPUCount,
+		CPUPercent:         int64(serviceCfg.CPUPercent),
+		CPUPeriod:          serviceCfg.CPUPeriod,
+		CPUQuota:           serviceCfg.CPUQuota,
+		CPURealtimePeriod:  serviceCfg.CPURTPeriod,
+		CPURealtimeRuntime: serviceCfg.CPURTRuntime,
+		CPUShares:          serviceCfg.CPUShares,
+		CpusetCpus:         serviceCfg.CPUSet,
+		Memory:             int64(serviceCfg.MemLimit),
+		MemoryReservation:  int64(serviceCfg.MemReservation),
+		MemorySwap:         int64(serviceCfg.MemSwapLimit),
+		NanoCPUs:           int64(float64(serviceCfg.CPUS) * 1e9),
+	}
+	if serviceCfg.MemSwappiness != 0 {
+		swappiness := int64(serviceCfg.MemSwappiness)
+		resources.MemorySwappiness = &swappiness
+	}
+	if serviceCfg.OomKillDisable {
+		resources.OomKillDisable = &serviceCfg.OomKillDisable
+	}
+	if serviceCfg.PidsLimit != 0 {
+		resources.PidsLimit = &serviceCfg.PidsLimit
+	}
+
+	if serviceCfg.Deploy == nil {
+		return resources
+	}
+	if limits := serviceCfg.Deploy.Resources.Limits; limits != nil {
+		if len(limits.NanoCPUs) > 0 {
+			if cpus, err := strconv.ParseFloat(limits.NanoCPUs, 64); err == nil {
+				resources.NanoCPUs = int64(cpus * 1e9)
+			} else {
+				slog.Error("deploy.resources.limits.cpus cannot be converted to a number", "service", serviceCfg.Name, "cpus", limits.NanoCPUs)
+			}
+		}
+		if limits.MemoryBytes > 0 {
+			resources.Memory = int64(limits.MemoryBytes)
+		}
+		if limits.Pids != 0 {
+			resources.PidsLimit = &limits.Pids
+		}
+	}
+	if reservations := serviceCfg.Deploy.Resources.Reservations; reservations != nil && reservations.MemoryBytes > 0 {
+		resources.MemoryReservation = int64(reservations.MemoryBytes)
+	}
+
+	return resources
+}
+
+// buildServiceRestartPolicy maps a service's restart field (or, in
+// its absence, deploy.restart_policy) into the equivalent Moby
+// structure.
+func buildServiceRestartPolicy(serviceCfg *composetypes.ServiceConfig) container.RestartPolicy {
+	if len(serviceCfg.Restart) > 0 {
+		mode, maxRetries, _ := strings.Cut(serviceCfg.Restart, ":")
+		restartPolicy := container.RestartPolicy{Name: container.RestartPolicyMode(mode)}
+		if len(maxRetries) > 0 {
+			if retries, err := strconv.Atoi(maxRetries); err == nil {
+				restartPolicy.MaximumRetryCount = retries
+			} else {
+				slog.Error("restart policy has a non-numeric maximum retry count", "service", serviceCfg.Name, "restart", serviceCfg.Restart)
+			}
+		}
+		return restartPolicy
+	}
+
+	if serviceCfg.Deploy == nil || serviceCfg.Deploy.RestartPolicy == nil {
+		return container.RestartPolicy{}
+	}
+	deployPolicy := serviceCfg.Deploy.RestartPolicy
+	switch deployPolicy.Condition {
+	case "none":
+		return container.RestartPolicy{Name: container.RestartPolicyDisabled}
+	case "on-failure":
+		restartPolicy := container.RestartPolicy{Name: container.RestartPolicyOnFailure}
+		if deployPolicy.MaxAttempts != nil {
+			restartPolicy.MaximumRetryCount = int(*deployPolicy.MaxAttempts)
+		}
+		return restartPolicy
+	case "any", "":
+		return container.RestartPolicy{Name: container.RestartPolicyAlways}
+	default:
+		slog.Error("unknown deploy.restart_policy condition; ignoring", "service", serviceCfg.Name, "condition", deployPolicy.Condition)
+		return container.RestartPolicy{}
+	}
+}
+
+// buildServiceNetworkingConfig determines which networks a service's
+// container is attached to.
+//
+// The network with the highest priority (or, failing that, the first
+// one by name) is set as hostCfg's network mode; all of the service's
+// networks are included in the returned config's endpoints, along with
+// any aliases and static addresses set in the service's networks
+// block. If the service specifies network_mode, it is used as-is
+// instead.
+func (c *Client) buildServiceNetworkingConfig(serviceCfg *composetypes.ServiceConfig, hostCfg *container.HostConfig) (*network.NetworkingConfig, error) {
+	if len(serviceCfg.NetworkMode) > 0 {
+		networkMode := serviceCfg.NetworkMode
+		if serviceName, ok := strings.CutPrefix(networkMode, composetypes.NetworkModeServicePrefix); ok {
+			networkMode = "container:" + c.composerContainerName(serviceName, 1)
+		}
+		hostCfg.NetworkMode = container.NetworkMode(networkMode)
+		return nil, nil
+	}
+
+	networkKeys := slices.Collect(maps.Keys(serviceCfg.Networks))
+	slices.SortFunc(networkKeys, func(a, b string) int {
+		var priorityA, priorityB int
+		if serviceCfg.Networks[a] != nil {
+			priorityA = serviceCfg.Networks[a].Priority
+		}
+		if serviceCfg.Networks[b] != nil {
+			priorityB = serviceCfg.Networks[b].Priority
+		}
+		if priorityA != priorityB {
+			return priorityB - priorityA
+		}
+		return strings.Compare(a, b)
+	})
+
+	networkingCfg := &network.NetworkingConfig{
+		EndpointsConfig: make(map[string]*network.EndpointSettings),
+	}
+	for _, networkKey := range networkKeys {
+		networkCfg, ok := c.composerProject.Networks[networkKey]
+		if !ok {
+			return nil, fmt.Errorf("service %s references undefined network %s", serviceCfg.Name, networkKey)
+		}
+		if len(hostCfg.NetworkMode) == 0 {
+			hostCfg.NetworkMode = container.NetworkMode(networkCfg.Name)
+		}
+		endpointSettings, err := buildServiceEndpointSettings(serviceCfg.Name, serviceCfg.Networks[networkKey])
+		if err != nil {
+			return nil, fmt.Errorf("service %s, network %s: %w", serviceCfg.Name, networkKey, err)
+		}
+		networkingCfg.EndpointsConfig[networkCfg.Name] = endpointSettings
+	}
+
+	return networkingCfg, nil
+}
+
+// buildServiceEndpointSettings converts an entry in a service's
+// networks block into the settings used to connect the service's
+// container to that network.
+//
+// The service's name is always included as an alias so that other
+// services can reach it by name, mirroring Compose's behavior.
+func buildServiceEndpointSettings(serviceName string, serviceNetworkCfg *composetypes.ServiceNetworkConfig) (*network.EndpointSettings, error) {
+	endpointSettings := &network.EndpointSettings{
+		Aliases: []string{serviceName},
+	}
+	if serviceNetworkCfg == nil {
+		return endpointSettings, nil
+	}
+
+	for _, alias := range serviceNetworkCfg.Aliases {
+		if !slices.Contains(endpointSettings.Aliases, alias) {
+			endpointSettings.Aliases = append(endpointSettings.Aliases, alias)
+		}
+	}
+	endpointSettings.GwPriority = serviceNetworkCfg.Priority
+
+	var ipamCfg network.EndpointIPAMConfig
+	var hasIPAMCfg bool
+	if len(serviceNetworkCfg.Ipv4Address) > 0 {
+		addr, err := netip.ParseAddr(serviceNetworkCfg.Ipv4Address)
+		if err != nil || !addr.Is4() {
+			return nil, fmt.Errorf("invalid ipv4_address %q", serviceNetworkCfg.Ipv4Address)
+		}
+		ipamCfg.IPv4Address = addr
+		hasIPAMCfg = true
+	}
+	if len(serviceNetworkCfg.Ipv6Address) > 0 {
+		addr, err := netip.ParseAddr(serviceNetworkCfg.Ipv6Address)
+		if err != nil || !addr.Is6() {
+			return nil, fmt.Errorf("invalid ipv6_address %q", serviceNetworkCfg.Ipv6Address)
+		}
+		ipamCfg.IPv6Address = addr
+		hasIPAMCfg = true
+	}
+	for _, linkLocalIP := range serviceNetworkCfg.LinkLocalIPs {
+		addr, err := netip.ParseAddr(linkLocalIP)
+		if err != nil || !addr.IsLinkLocalUnicast() {
+			return nil, fmt.Errorf("invalid link_local_ips entry %q", linkLocalIP)
+		}
+		ipamCfg.LinkLocalIPs = append(ipamCfg.LinkLocalIPs, addr)
+		hasIPAMCfg = true
+	}
+	if hasIPAMCfg {
+		endpointSettings.IPAMConfig = &ipamCfg
+	}
+
+	if len(serviceNetworkCfg.MacAddress) > 0 {
+		macAddr, err := net.ParseMAC(serviceNetworkCfg.MacAddress)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mac_address %q: %w", serviceNetworkCfg.MacAddress, err)
+		}
+		endpointSettings.MacAddress = network.HardwareAddr(macAddr)
+	}
+
+	return endpointSettings, nil
+}
+
+// convertNetworkConfig converts a NetworkConfig to a
+// NetworkCreateOptions so it can be used with the REST API.
+func (c *Client) convertNetworkConfig(networkCfg composetypes.NetworkConfig) (*mobyclient.NetworkCreateOptions, error) {
+	// TODO: Implement conversion
+	if len(networkCfg.Ipam.Driver) > 0 || networkCfg.Ipam.Config != nil {
+		slog.Error("network config conversion for IPAM config is not yet implemented", "ipamcfg", networkCfg.Ipam)
+		return nil, fmt.Errorf("network config relies on unimplemented functionality")
+	}
+
+	defTrue := true
+	nco := mobyclient.NetworkCreateOptions{
+		Driver:     networkCfg.Driver,
+		Scope:      "local",
+		EnableIPv4: &defTrue,
+		EnableIPv6: &networkCfg.EnableIPv6,
+		Internal:   networkCfg.Internal,
+		Attachable: networkCfg.Attachable,
+		Ingress:    false,
+		ConfigOnly: false,
+	}
+	return &nco, nil
+}
+
+// createComposerNetworks provisions networks declared by a Composer
+// configuration, with labels applied to them on top of their own.
+//
+// Networks left over from an earlier run in the same workspace (e.g.,
+// one that crashed) are reused, or, if recreate is set, removed and
+// created anew; either way, they're torn down with the project.
+// Networks that exist but weren't created for this workspace are
+// sidestepped where possible (see resolveComposerResourceName), and
+// reused, but not torn down, otherwise.
+//
+// Returns the first error it encounters (if any), and is liable to
+// leave to Composer project in an indeterminate state.
+func (c *Client) createComposerNetworks(ctx context.Context, networks map[string]composetypes.NetworkConfig, labels map[string]string, recreate bool) error {
+	inspectNetwork := func(name string) (map[string]string, bool) {
+		res, err := c.mobyClient.NetworkInspect(ctx, name, mobyclient.NetworkInspectOptions{})
+		if err != nil {
+			return nil, false
+		}
+		return res.Network.Labels, true
+	}
+
+	for key, networkCfg := range networks {
+		// External networks are expected to exist already; they're
+		// not created, and consequently, not torn down either
+		if networkCfg.External.External {
+			slog.Debug("looking up external network", "network", networkCfg.Name)
+			if _, err := c.mobyClient.NetworkInspect(ctx, networkCfg.Name, mobyclient.NetworkInspectOptions{}); err != nil {
+				slog.Error("external network could not be found", "network", networkCfg.Name, "error", err)
+				return fmt.Errorf("external network %s could not be found: %w", networkCfg.Name, err)
+			}
+			continue
+		}
+
+		networkName, existingLabels, exists := c.resolveComposerResourceName(key, networkCfg.Name, labels, inspectNetwork)
+		if networkName != networkCfg.Name {
+			slog.Info("network name is taken by another workspace's; using one scoped to this workspace", "network", networkCfg.Name, "scoped", networkName)
+			// Services look networks up by their key, so they pick
+			// up the new name
+			networkCfg.Name = networkName
+			networks[key] = networkCfg
+		}
+		if exists {
+			if ownerOf(existingLabels, labels) != ownerWorkspace {
+				slog.Warn("network already exists, but wasn't created for this workspace; reusing it", "network", networkName)
+				continue
+			}
+			if !recreate {
+				slog.Info("reusing network left over from an earlier run", "network", networkName)
+				c.trackComposerNetwork(networkName)
+				continue
+			}
+			slog.Info("removing network left over from an earlier run", "network", networkName)
+			if _, err := c.mobyClient.NetworkRemove(ctx, networkName, mobyclient.NetworkRemoveOptions{}); err != nil {
+				return fmt.Errorf("unable to remove leftover network %s: %w", networkName, err)
+			}
+		}
+
+		networkCreateOpts, err := c.convertNetworkConfig(networkCfg)
+		if err != nil {
+			return err
+		}
+		networkCreateOpts.Labels = mergeLabels(labels, networkCfg.Labels)
+		res, err := c.mobyClient.NetworkCreate(ctx, networkName, *networkCreateOpts)
+		if err != nil {
+			return err
+		}
+		c.trackComposerNetwork(networkName)
+		for _, warning := range res.Warning {
+			slog.Warn(warning)
+		}
+	}
+	return nil
+}
+
+// resolveComposerResourceName returns the name the network or volume
+// declared under key in the Composer YAML, and named name, is to be
+// found or created under, along with whether it exists and, if so,
+// its labels. labels are the ones identifying this workspace's
+// resources (see ResourceLabels).
+//
+// If a resource named name was created for another workspace whose
+// project happens to have the same name (e.g., another clone of the
+// same repository, on the same branch), a name scoped to this
+// workspace (see scopedResourceName) is used instead, unless name was
+// set explicitly in the Composer YAML. Once created, a resource with
+// a scoped name keeps being used for as long as it exists.
+func (c *Client) resolveComposerResourceName(key string, name string, labels map[string]string, inspect func(name string) (map[string]string, bool)) (string, map[string]string, bool) {
+	scopedName := scopedResourceName(name, labels)
+	if scopedName != name {
+		if existingLabels, ok := inspect(scopedName); ok {
+			return scopedName, existingLabels, true
+		}
+	}
+	existingLabels, ok := inspect(name)
+	if !ok {
+		return name, nil, false
+	}
+	// compose-go names resources <project>_<key>, unless they're
+	// given a name of their own
+	defaultName := fmt.Sprintf("%s_%s", c.composerProject.Name, key)
+	if ownerOf(existingLabels, labels) == ownerOther && scopedName != name && name == defaultName {
+		return scopedName, nil, false
+	}
+	return name, existingLabels, true
+}
+
+// createComposerService provisions a single Composer service, and is
+// intended to be called by createComposerServices when it walks a DAG
+// of services.
+//
+// Its replicas (see serviceReplicas) are created one after the other,
+// once the services it depends on are up; the first one that fails
+// stops the rest from being created. Containers left over from an
+// earlier run are reused or recreated as diffComposerServices decided.
+func (c *Client) createComposerService(ctx context.Context, p *writ.DevcontainerParser, serviceCfg *composetypes.ServiceConfig, imageTagPrefix string) error {
+	slog.Debug("waiting for service dependencies", "service", serviceCfg.Name)
+	if err := c.waitForServiceDependencies(ctx, &serviceCfg.DependsOn); err != nil {
+		return err
+	}
+
+	replicas := serviceReplicas(serviceCfg)
+	if replicas < 1 {
+		slog.Info("service is scaled to zero replicas; skipping", "service", serviceCfg.Name)
+	}
+	for replica := 1; replica <= replicas; replica++ {
+		if err := c.createComposerServiceReplica(ctx, p, serviceCfg, imageTagPrefix, replica); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createComposerServiceReplica creates and starts the container for
+// the given replica (counting from 1) of a Composer service; see
+// composerContainerName.
+//
+// Every replica is reachable by the service's name on its networks.
+func (c *Client) createComposerServiceReplica(ctx context.Context, p *writ.DevcontainerParser, serviceCfg *composetypes.ServiceConfig, imageTagPrefix string, replica int) error {
+	containerName := c.composerContainerName(serviceCfg.Name, replica)
+	// Replicas share the image built for the service
+	imageTag := fmt.Sprintf("%s%s", imageTagPrefix, c.composerContainerName(serviceCfg.Name, 1))
+
+	if reused, err := c.reuseServiceContainer(ctx, serviceCfg.Name, containerName); reused || err != nil {
+		if err == nil {
+			c.streamServiceOutput(c.serviceContainers[containerName].ID, containerName, serviceCfg.Tty, true)
+		}
+		return err
+	}
+
+	slog.Debug("converting service config to Moby equivalents", "name", containerName)
+	containerCfg := c.buildServiceContainerConfig(p, serviceCfg)
+	containerCfg.Labels[ComposeServiceLabel] = serviceCfg.Name
+	containerCfg.Labels[ComposeReplicaLabel] = strconv.Itoa(replica)
+	if hash := c.serviceConfigHashes[serviceCfg.Name]; len(hash) > 0 {
+		containerCfg.Labels[ConfigHashLabel] = hash
+	}
+	hostCfg := c.buildServiceHostConfig(serviceCfg)
+	if err := c.bindServicePorts(serviceCfg, containerCfg, hostCfg); err != nil {
+		return err
+	}
+	if err := c.bindServiceFileObjects(serviceCfg, hostCfg); err != nil {
+		return err
+	}
+	networkingCfg, err := c.buildServiceNetworkingConfig(serviceCfg, hostCfg)
+	if err != nil {
+		return err
+	}
+
+	// Images are pulled/built ahead of time by prepareComposerImages
+	if serviceCfg.Build != nil {
+		containerCfg.Image = imageTag
+	} else if len(serviceCfg.Image) > 0 {
+		containerCfg.Image = serviceCfg.Image
+	}
+
+	isDevcontainer := *p.Config.Service == serviceCfg.Name
+	if isDevcontainer {
+		if p.Config.ContainerUser != nil {
+			// If the devcontainer.json specifies containerUser, it will
+			// override the user field in the Compose config
+			containerCfg.User = *p.Config.ContainerUser
+		} else if len(containerCfg.User) > 0 {
+			// If containerUser in devcontainer.json isn't specified,
+			// but the Composer config has a user field for this
+			// service, use that instead
+			*p.Config.ContainerUser = containerCfg.User
+		}
+
+		if p.Config.WorkspaceFolder != nil {
+			containerCfg.WorkingDir = *p.Config.WorkspaceFolder
+		}
+		c.bindServiceWorkspaceMount(p, serviceCfg, hostCfg)
+		// init in devcontainer.json (or from a Feature) can only
+		// turn the service's own setting on
+		if *p.Config.Init {
+			hostCfg.Init = p.Config.Init
+		}
+		// Likewise, the security options are added to the service's
+		securityOpts := writ.SecurityOptions{
+			CapAdd:      hostCfg.CapAdd,
+			Privileged:  hostCfg.Privileged,
+			SecurityOpt: hostCfg.SecurityOpt,
+		}.Merge(p.SecurityOptions())
+		hostCfg.CapAdd = securityOpts.CapAdd
+		hostCfg.Privileged = securityOpts.Privileged
+		hostCfg.SecurityOpt = securityOpts.SecurityOpt
+
+		if len(p.Config.Features) > 0 {
+			contextPath := filepath.Dir(p.Filepath)
+			// Tag the feature-integrated image separately so the
+			// service image's build hash stays intact
+			featuresImageTag := fmt.Sprintf("%s--features", imageTag)
+			if c.FeatureImageBuilder == nil {
+				return fmt.Errorf("%w for %s: no FeatureImageBuilder set to install Features with", ErrImageBuild, featuresImageTag)
+			}
+			if err := c.FeatureImageBuilder(ctx, contextPath, containerCfg.Image, featuresImageTag); err != nil {
+				slog.Error("encountered an error while trying to build a feature-integrated image for a service", "error", err)
+				return err
+			}
+			containerCfg.Image = featuresImageTag
+		}
+	} else if err := c.bindForwardPorts(p, serviceCfg.Name, containerCfg, hostCfg); err != nil {
+		// forwardPorts entries may name other services (e.g.,
+		// "db:5432")
+		return err
+	}
+
+	slog.Debug("starting Composer service container", "name", containerName)
+	containerID, err := c.startContainer(ctx, p, containerCfg, hostCfg, networkingCfg, containerName, isDevcontainer)
+	if len(containerID) > 0 {
+		// Track the container even if it failed to start, so it can
+		// be cleaned up
+		c.trackComposerContainer(serviceCfg.Name, containerID)
+	}
+	if err == nil {
+		c.streamServiceOutput(containerID, containerName, containerCfg.Tty, false)
+	}
+	return err
+}
+
+// createComposerServices iterates through servicesDAG breadth-first
+// and fires off provisioning functions until the DAG is exhausted. It
+// then collates function returns and runs any
+// ComposerServicesWaitFunc.
+//
+// It returns the first error it encounters, and is liable to leave
+// the Composer project in an indeterminate state. Errors bringing up
+// services that nothing requires (see isOptionalService) are only
+// logged.
+func (c *Client) createComposerServices(ctx context.Context, p *writ.DevcontainerParser, servicesDAG *dag.DAG, imageTagPrefix string) error {
+	roots := servicesDAG.GetRoots()
+	for len(roots) > 0 {
+		type serviceFailure struct {
+			serviceName string
+			err         error
+		}
+		errChan := make(chan serviceFailure, len(roots))
+		var wg sync.WaitGroup
+
+		for raw := range maps.Values(roots) {
+			serviceCfg, ok := raw.(*composetypes.ServiceConfig)
+			if !ok {
+				return fmt.Errorf("value for vertex is of unexpected type")
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errChan <- serviceFailure{serviceCfg.Name, func() (err error) {
+					defer RecoverPanic(&err)
+					return c.createComposerService(ctx, p, serviceCfg, imageTagPrefix)
+				}()}
+			}()
+		}
+		wg.Wait()
+		close(errChan)
+
+		for failure := range errChan {
+			if failure.err == nil {
+				continue
+			}
+			// A panic is a bug, not a service failing to come up
+			var panicErr *PanicError
+			if !c.isOptionalService(failure.serviceName, *p.Config.Service) || errors.As(failure.err, &panicErr) {
+				return failure.err
+			}
+			// Its dependents only wait on it if it's up
+			slog.Warn("service failed to come up, but nothing requires it; carrying on", "service", failure.serviceName, "error", failure.err)
+		}
+
+		for id := range roots {
+			if err := servicesDAG.DeleteVertex(id); err != nil {
+				return err
+			}
+		}
+
+		roots = servicesDAG.GetRoots()
+	}
+
+	return nil
+}
+
+// createComposerVolumes creates the named volumes declared in a
+// Composer project that don't exist yet, and checks that the external
+// ones do.
+//
+// labels are applied to the volumes it creates, on top of the ones
+// set in the Composer YAML.
+//
+// Like `docker compose down` (sans --volumes), tearing down the
+// project leaves volumes alone so their contents survive between
+// runs.
+func (c *Client) createComposerVolumes(ctx context.Context, volumes composetypes.Volumes, labels map[string]string) error {
+	inspectVolume := func(name string) (map[string]string, bool) {
+		res, err := c.mobyClient.VolumeInspect(ctx, name, mobyclient.VolumeInspectOptions{})
+		if err != nil {
+			return nil, false
+		}
+		return res.Volume.Labels, true
+	}
+
+	for key, volumeCfg := range volumes {
+		if volumeCfg.External.External {
+			if _, err := c.mobyClient.VolumeInspect(ctx, volumeCfg.Name, mobyclient.VolumeInspectOptions{}); err != nil {
+				slog.Error("external volume could not be found", "volume", volumeCfg.Name, "error", err)
+				return fmt.Errorf("external volume %s could not be found: %w", volumeCfg.Name, err)
+			}
+			slog.Debug("external volume found", "volume", volumeCfg.Name)
+			continue
+		}
+
+		volumeName, existingLabels, exists := c.resolveComposerResourceName(key, volumeCfg.Name, labels, inspectVolume)
+		if volumeName != volumeCfg.Name {
+			slog.Info("volume name is taken by another workspace's; using one scoped to this workspace", "volume", volumeCfg.Name, "scoped", volumeName)
+			// Services look volumes up by their key, so they pick up
+			// the new name
+			volumeCfg.Name = volumeName
+			volumes[key] = volumeCfg
+		}
+		if exists {
+			if ownerOf(existingLabels, labels) == ownerOther {
+				slog.Warn("volume already exists, and was created for another workspace; sharing it", "volume", volumeName)
+			} else {
+				slog.Debug("volume already exists; reusing", "volume", volumeName)
+			}
+			continue
+		}
+
+		slog.Debug("creating volume", "volume", volumeName)
+		if _, err := c.mobyClient.VolumeCreate(ctx, mobyclient.VolumeCreateOptions{
+			Name:       volumeName,
+			Driver:     volumeCfg.Driver,
+			DriverOpts: volumeCfg.DriverOpts,
+			Labels:     mergeLabels(labels, volumeCfg.Labels),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadComposerProject parses the Composer YAML file(s) referenced by
+// a devcontainer.json configuration.
+//
+// Like docker compose, variables in the YAML are interpolated using
+// the host's environment, falling back to the .env file next to the
+// first Composer YAML file. Services' env_file entries are merged
+// into their environment by the loader, with values in their
+// environment field taking precedence.
+func (c *Client) loadComposerProject(ctx context.Context, p *writ.DevcontainerParser, projName string) error {
+	composeFiles := []string(*p.Config.DockerComposeFile)
+	projOptionsFns := []compose.ProjectOptionsFn{
+		compose.WithConsistency(true),
+		compose.WithContext(ctx),
+		compose.WithInterpolation(true),
+		compose.WithName(projName), // Maybe overriding the name can be a flag?
+		compose.WithNormalization(true),
+		compose.WithResolvedPaths(true),
+		compose.WithWorkingDirectory(*p.Config.Context),
+		compose.WithOsEnv,
+	}
+	if len(composeFiles) > 0 {
+		dotEnvPath := filepath.Join(filepath.Dir(composeFiles[0]), ".env")
+		if info, err := os.Stat(dotEnvPath); err == nil && !info.IsDir() {
+			slog.Debug("loading project-level .env file", "path", dotEnvPath)
+			projOptionsFns = append(projOptionsFns, compose.WithEnvFiles(dotEnvPath), compose.WithDotEnv)
+		}
+	}
+
+	projOptions, err := compose.NewProjectOptions(composeFiles, projOptionsFns...)
+	if err != nil {
+		slog.Error("encountered an error while creating project options", "error", err)
+		return err
+	}
+
+	c.composerProject, err = compose.ProjectFromOptions(projOptions)
+	if err != nil {
+		slog.Error("encountered an error while trying to create a project from options", "error", err)
+		return err
+	}
+	return nil
+}
+
+// prepareComposerImages pulls or builds the images required by every
+// service in the Composer project ahead of container creation.
+//
+// Images are prepared concurrently, with at most
+// MaxConcurrentImageJobs pulls/builds in flight at any given time.
+//
+// It returns the first error it encounters.
+//
+// If any of the pulls/builds fail, the ones still in flight are
+// cancelled.
+//
+// With more than one of them, their progress is written out a whole
+// line at a time, so that it isn't garbled; see c.output.
+func (c *Client) prepareComposerImages(ctx context.Context, opts ComposeOptions) error {
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(MaxConcurrentImageJobs)
+	defer c.removeSynthesizedContainerfiles()
+
+	var jobs int
+	for _, serviceCfg := range c.composerProject.AllServices() {
+		if serviceCfg.Build != nil || len(serviceCfg.Image) > 0 {
+			jobs++
+		}
+	}
+
+	for _, serviceCfg := range c.composerProject.AllServices() {
+		imageTag := fmt.Sprintf("%s%s", opts.ImageTagPrefix, c.composerContainerName(serviceCfg.Name, 1))
+		imageOpts := ImageOptions{SuppressOutput: opts.SuppressOutput, concurrent: jobs > 1}
+
+		switch {
+		case serviceCfg.Build != nil:
+			eg.Go(func() (err error) {
+				defer RecoverPanic(&err)
+				slog.Debug("building image for service", "service", serviceCfg.Name, "tag", imageTag)
+				buildOpts, err := c.buildServiceBuildOpts(serviceCfg.Name, serviceCfg.Build, opts.SuppressOutput)
+				if err != nil {
+					return err
+				}
+				buildOpts.Tags = append(buildOpts.Tags, imageTag)
+				imageOpts.SkipIfAvailable = opts.SkipBuildIfAvailable
+				return c.BuildContainerImage(egCtx, BuildImageOptions{
+					ImageOptions:   imageOpts,
+					ContextPath:    serviceCfg.Build.Context,
+					DockerfilePath: serviceCfg.Build.Dockerfile,
+					ImageTag:       imageTag,
+					ResourceLabels: c.ResourceLabels(nil),
+					BuildOptions:   buildOpts,
+				})
+			})
+
+		case len(serviceCfg.Image) > 0:
+			eg.Go(func() (err error) {
+				defer RecoverPanic(&err)
+				slog.Debug("pulling image for service", "service", serviceCfg.Name, "image", serviceCfg.Image)
+				imageOpts.SkipIfAvailable = opts.SkipPullIfAvailable
+				// The service's pull_policy applies unless one was
+				// set for the whole run
+				if c.PullPolicy == PullPolicyDefault && len(serviceCfg.PullPolicy) > 0 {
+					policy, err := ParsePullPolicy(serviceCfg.PullPolicy)
+					if err != nil {
+						return fmt.Errorf("service %s: %w", serviceCfg.Name, err)
+					}
+					imageOpts.PullPolicy = policy
+				}
+				return c.PullContainerImage(egCtx, serviceCfg.Image, imageOpts)
+			})
+		}
+	}
+
+	return eg.Wait()
+}
+
+// synthesizeInlineContainerfile writes an inlined Containerfile from
+// a Composer YAML out to a uniquely named file in a private, temporary
+// directory, leaving the build context alone; BuildContainerImage
+// adds it to the context archive.
+//
+// The directory is removed once the images have been built; see
+// removeSynthesizedContainerfiles.
+func (c *Client) synthesizeInlineContainerfile(serviceName string, inlinedContainerfile *string) (containerfilePath string, err error) {
+	c.resourcesMu.Lock()
+	defer c.resourcesMu.Unlock()
+
+	if len(c.containerfilesDir) == 0 {
+		dir, err := os.MkdirTemp("", fmt.Sprintf("brig-%s-containerfiles-*", c.composerProject.Name))
+		if err != nil {
+			return "", err
+		}
+		c.containerfilesDir = dir
+	}
+
+	cf, err := os.CreateTemp(c.containerfilesDir, fmt.Sprintf("%s.*.Containerfile", serviceName))
+	if err != nil {
+		return "", err
+	}
+	_, err = cf.WriteString(*inlinedContainerfile)
+	if err = errors.Join(err, cf.Close()); err != nil {
+		return "", err
+	}
+	slog.Debug("synthesized a Containerfile from an inlined one", "service", serviceName, "path", cf.Name())
+	return cf.Name(), nil
+}
+
+// removeSynthesizedContainerfiles removes the directory the
+// Containerfiles written by synthesizeInlineContainerfile are in,
+// unless c.KeepSynthesizedContainerfiles is set.
+func (c *Client) removeSynthesizedContainerfiles() {
+	c.resourcesMu.Lock()
+	defer c.resourcesMu.Unlock()
+
+	if len(c.containerfilesDir) == 0 {
+		return
+	}
+	if c.KeepSynthesizedContainerfiles {
+		slog.Info("leaving synthesized Containerfiles in place as instructed", "path", c.containerfilesDir)
+		return
+	}
+	slog.Debug("removing synthesized Containerfiles", "path", c.containerfilesDir)
+	if err := os.RemoveAll(c.containerfilesDir); err != nil {
+		slog.Error("encountered an error while removing synthesized Containerfiles", "path", c.containerfilesDir, "error", err)
+		return
+	}
+	c.containerfilesDir = ""
+}
+
+// teardownComposerServices goes through the services from leaves to
+// roots to stop and remove their containers.
+//
+// Services whose containers weren't created by brig are skipped. An
+// error encountered with one service doesn't stop the others from
+// being torn down; all errors are returned together.
+func (c *Client) teardownComposerServices(ctx context.Context, servicesDAG *dag.DAG) error {
+	var errs []error
+	leaves := servicesDAG.GetLeaves()
+	for len(leaves) > 0 {
+		var wg sync.WaitGroup
+		errChan := make(chan error, c.trackedComposerContainerCount())
+
+		for raw := range maps.Values(leaves) {
+			serviceCfg, ok := raw.(*composetypes.ServiceConfig)
+			if !ok {
+				return fmt.Errorf("value for vertex is of unexpected type")
+			}
+
+			c.resourcesMu.Lock()
+			containerIDs := slices.Clone(c.createdContainers[serviceCfg.Name])
+			c.resourcesMu.Unlock()
+			if len(containerIDs) == 0 {
+				slog.Debug("service container was not created; skipping", "service", serviceCfg.Name)
+				continue
+			}
+
+			for _, containerID := range containerIDs {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					slog.Info("stopping and removing Composer container", "service", serviceCfg.Name, "container", containerID)
+					if _, err := c.mobyClient.ContainerStop(ctx, containerID, mobyclient.ContainerStopOptions{}); err != nil {
+						errChan <- err
+						return
+					}
+					if _, err := c.mobyClient.ContainerRemove(ctx, containerID, mobyclient.ContainerRemoveOptions{}); err != nil {
+						errChan <- err
+						return
+					}
+					c.untrackComposerContainer(serviceCfg.Name, containerID)
+				}()
+			}
+		}
+		wg.Wait()
+		close(errChan)
+
+		for err := range errChan {
+			errs = append(errs, err)
+		}
+
+		for id := range leaves {
+			if err := servicesDAG.DeleteVertex(id); err != nil {
+				return err
+			}
+		}
+
+		leaves = servicesDAG.GetLeaves()
+	}
+
+	return errors.Join(errs...)
+}
+
+// trackComposerContainer records that brig created the container
+// designated by containerID for (a replica of) the service named
+// serviceName, so it can be torn down later.
+func (c *Client) trackComposerContainer(serviceName string, containerID string) {
+	c.resourcesMu.Lock()
+	defer c.resourcesMu.Unlock()
+	if c.createdContainers == nil {
+		c.createdContainers = make(map[string][]string)
+	}
+	c.createdContainers[serviceName] = append(c.createdContainers[serviceName], containerID)
+}
+
+// untrackComposerContainer forgets about the container designated by
+// containerID, once it's been torn down.
+func (c *Client) untrackComposerContainer(serviceName string, containerID string) {
+	c.resourcesMu.Lock()
+	defer c.resourcesMu.Unlock()
+	c.createdContainers[serviceName] = slices.DeleteFunc(c.createdContainers[serviceName], func(id string) bool { return id == containerID })
+	if len(c.createdContainers[serviceName]) == 0 {
+		delete(c.createdContainers, serviceName)
+	}
+}
+
+// trackedComposerContainerCount returns how many containers brig
+// created for the Composer project that have yet to be torn down.
+func (c *Client) trackedComposerContainerCount() (count int) {
+	c.resourcesMu.Lock()
+	defer c.resourcesMu.Unlock()
+	for _, containerIDs := range c.createdContainers {
+		count += len(containerIDs)
+	}
+	return count
+}
+
+// trackComposerNetwork records that brig created the network named
+// networkName, so it can be torn down later.
+func (c *Client) trackComposerNetwork(networkName string) {
+	c.resourcesMu.Lock()
+	defer c.resourcesMu.Unlock()
+	c.createdNetworks = append(c.createdNetworks, networkName)
+}
+
+// untrackComposerResources forgets about every container, network,
+// and materialized secret/config brig created for the Composer
+// project, which leaves them in place come teardown.
+func (c *Client) untrackComposerResources() {
+	c.resourcesMu.Lock()
+	defer c.resourcesMu.Unlock()
+	c.createdContainers = nil
+	c.createdNetworks = nil
+	c.fileObjectsDir = ""
+}
+
+// waitForServiceDependencies goes through a service's depends_on
+// configuration and performs blocking checks until the specified
+// conditions are met.
+//
+// Note that, at the point this function is called, the services a
+// target service depends on would have been created and started.
+//
+// Dependencies marked required: false that aren't satisfied are only
+// logged.
+//
+// Each dependency is given c.DependencyTimeout to satisfy its
+// condition; for service_healthy, the budget is extended to cover the
+// dependency's own healthcheck configuration if that works out to be
+// longer. If c.DependencyTimeout is 0 or negative, it's waited on for
+// as long as it takes. Cancelling ctx aborts the wait.
+func (c *Client) waitForServiceDependencies(ctx context.Context, dependsOn *composetypes.DependsOnConfig) error {
+	if len(*dependsOn) < 1 {
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	var containerCount int
+	for serviceName := range *dependsOn {
+		containerCount += len(c.composerContainerNames(serviceName))
+	}
+	errChan := make(chan error, containerCount)
+
+	for serviceName, dependency := range *dependsOn {
+		// Every replica of the dependency has to satisfy the
+		// condition
+		for _, containerName := range c.composerContainerNames(serviceName) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := c.waitForServiceDependency(ctx, serviceName, containerName, dependency.Condition)
+				if err != nil && !dependency.Required && ctx.Err() == nil {
+					slog.Warn("service dependency isn't satisfied, but isn't required; carrying on", "service", serviceName, "container", containerName, "condition", dependency.Condition, "error", err)
+					err = nil
+				}
+				errChan <- err
+			}()
+		}
+	}
+	wg.Wait()
+	close(errChan)
+
+	for err := range errChan {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitForServiceDependency waits for containerName, one of the
+// containers for serviceName, to satisfy condition, until the time
+// allotted to it runs out or ctx is cancelled.
+//
+// Rather than polling the container, it follows the engine's events
+// for it (start, die, and health_status), having inspected it once to
+// know where it stands; it subscribes to the events before doing so,
+// so no transition in between is missed.
+func (c *Client) waitForServiceDependency(ctx context.Context, serviceName string, containerName string, condition string) error {
+	switch condition {
+	case composetypes.ServiceConditionCompletedSuccessfully, composetypes.ServiceConditionHealthy, composetypes.ServiceConditionStarted:
+	default:
+		return fmt.Errorf("unknown dependency condition specified: %s", condition)
+	}
+
+	timeout := c.DependencyTimeout
+	if timeout > 0 && condition == composetypes.ServiceConditionHealthy {
+		if serviceCfg, err := c.composerProject.GetService(serviceName); err == nil {
+			timeout = max(timeout, healthcheckBudget(serviceCfg.HealthCheck))
+		}
+	}
+	slog.Debug("attempting to resolve service dependency", "service", containerName, "condition", condition, "timeout", timeout)
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	eventsCtx, stopEvents := context.WithCancel(ctx)
+	defer stopEvents()
+	containerEvents, eventErrs := c.ContainerEvents(eventsCtx, containerName)
+
+	inspectRes, err := c.mobyClient.ContainerInspect(ctx, containerName, mobyclient.ContainerInspectOptions{})
+	if err != nil {
+		slog.Debug("encountered an error while inspecting container state", "service", containerName, "error", err)
+		return err
+	}
+	var state container.State
+	if inspectRes.Container.State != nil {
+		state = *inspectRes.Container.State
+	}
+	slog.Debug("container state inspected", "service", containerName, "state", state.Status)
+
+	// The condition has to hold for c.DependencySettleTime before the
+	// dependency is considered resolved, as a guard against services
+	// that crash shortly after starting
+	var settleTimer *time.Timer
+	defer func() {
+		if settleTimer != nil {
+			settleTimer.Stop()
+		}
+	}()
+	for {
+		satisfied, err := dependencySatisfied(containerName, condition, &state)
+		if err != nil {
+			slog.Error("service dependency can't be satisfied", "service", containerName, "condition", condition, "error", err)
+			return err
+		}
+		switch {
+		case !satisfied && settleTimer != nil:
+			settleTimer.Stop()
+			settleTimer = nil
+		case !satisfied:
+		case condition == composetypes.ServiceConditionCompletedSuccessfully || c.DependencySettleTime <= 0:
+			slog.Debug("service dependency resolved", "service", containerName, "condition", condition)
+			return nil
+		case settleTimer == nil:
+			settleTimer = time.NewTimer(c.DependencySettleTime)
+		}
+		var settled <-chan time.Time
+		if settleTimer != nil {
+			settled = settleTimer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				slog.Error("encountered timeout while waiting for service dependency", "service", containerName, "condition", condition, "timeout", timeout)
+				return fmt.Errorf("timed out after %s waiting for service %s to satisfy %s", timeout, containerName, condition)
+			}
+			return ctx.Err()
+		case <-settled:
+			slog.Debug("service dependency resolved", "service", containerName, "condition", condition)
+			return nil
+		case event, ok := <-containerEvents:
+			if !ok {
+				containerEvents = nil
+				if err := <-eventErrs; ctx.Err() == nil {
+					return fmt.Errorf("lost the engine's events while waiting for service %s: %w", containerName, err)
+				}
+				continue
+			}
+			slog.Debug("container event received", "service", containerName, "action", event.Action)
+			if event.Action == events.ActionDestroy {
+				return fmt.Errorf("service %s was removed while waiting for it to satisfy %s", containerName, condition)
+			}
+			applyContainerEvent(&state, event)
+		}
+	}
+}
+
+// applyContainerEvent updates state, as last inspected, with the
+// change event reports.
+func applyContainerEvent(state *container.State, event ContainerEvent) {
+	switch {
+	case event.Action == events.ActionStart:
+		state.Status = container.StateRunning
+		state.Running = true
+		// The healthcheck starts over with the container
+		if state.Health != nil && state.Health.Status != container.NoHealthcheck {
+			state.Health = &container.Health{Status: container.Starting}
+		}
+	case event.Died():
+		state.Status = container.StateExited
+		state.Running = false
+		state.ExitCode = event.ExitCode
+	case strings.HasPrefix(string(event.Action), string(events.ActionHealthStatus)+":"):
+		status := strings.TrimSpace(strings.TrimPrefix(string(event.Action), string(events.ActionHealthStatus)+":"))
+		state.Health = &container.Health{Status: container.HealthStatus(status)}
+	}
+}
+
+// dependencySatisfied reports whether a container in state satisfies
+// condition; it fails if the condition can no longer be satisfied,
+// e.g., a container that needed to be healthy has stopped running.
+func dependencySatisfied(containerName string, condition string, state *container.State) (bool, error) {
+	switch condition {
+	case composetypes.ServiceConditionCompletedSuccessfully:
+		if state.Running || state.Status == container.StateCreated {
+			return false, nil
+		}
+		if state.ExitCode != 0 {
+			return false, fmt.Errorf("service %s needed to complete successfully but had exit code %d", containerName, state.ExitCode)
+		}
+		return true, nil
+
+	case composetypes.ServiceConditionHealthy:
+		// If a container isn't running this early on, it probably
+		// means it has crashed shortly after it was started and bears
+		// investigation
+		if !state.Running {
+			return false, fmt.Errorf("service %s needed to be healthy but isn't running (exit code %d)", containerName, state.ExitCode)
+		}
+		if state.Health == nil || state.Health.Status == container.NoHealthcheck {
+			return false, fmt.Errorf("service %s lacks a healthcheck", containerName)
+		}
+		switch state.Health.Status {
+		case container.Unhealthy:
+			// The engine only flags a container as unhealthy after
+			// its healthcheck has exhausted its retries, so there's
+			// little point in waiting any further
+			return false, fmt.Errorf("service %s needed to be healthy but is unhealthy", containerName)
+		case container.Healthy:
+			return true, nil
+		}
+		return false, nil
+
+	case composetypes.ServiceConditionStarted:
+		// See comment for service_healthy
+		if !state.Running {
+			return false, fmt.Errorf("service %s needed to be running but isn't (exit code %d)", containerName, state.ExitCode)
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown dependency condition specified: %s", condition)
+}
+
+// healthcheckBudget returns how long it could reasonably take for a
+// service with the given healthcheck to be flagged as healthy, using
+// the same defaults the engine does for unset values.
+//
+// Returns 0 if the healthcheck is absent or disabled.
+func healthcheckBudget(healthCheck *composetypes.HealthCheckConfig) time.Duration {
+	if healthCheck == nil || healthCheck.Disable {
+		return 0
+	}
+
+	var interval, timeout, startPeriod time.Duration
+	var retries uint64
+	if healthCheck.Interval != nil {
+		interval = time.Duration(*healthCheck.Interval)
+	}
+	if healthCheck.Timeout != nil {
+		timeout = time.Duration(*healthCheck.Timeout)
+	}
+	if healthCheck.StartPeriod != nil {
+		startPeriod = time.Duration(*healthCheck.StartPeriod)
+	}
+	if healthCheck.Retries != nil {
+		retries = *healthCheck.Retries
+	}
+
+	return healthcheckDuration(interval, timeout, startPeriod, int(retries))
+}
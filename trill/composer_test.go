@@ -0,0 +1,317 @@
+package trill
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/mount"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHealthcheckBudget checks that the time allotted to a service
+// to become healthy follows its healthcheck configuration, falling
+// back to the engine's defaults for unset values.
+func TestHealthcheckBudget(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.Equal(t, time.Duration(0), healthcheckBudget(nil))
+	assert.Equal(t, time.Duration(0), healthcheckBudget(&composetypes.HealthCheckConfig{Disable: true}))
+
+	// Engine defaults: 30s interval, 30s timeout, 3 retries
+	assert.Equal(t, 4*time.Minute, healthcheckBudget(&composetypes.HealthCheckConfig{}))
+
+	interval := composetypes.Duration(5 * time.Second)
+	timeout := composetypes.Duration(2 * time.Second)
+	startPeriod := composetypes.Duration(10 * time.Second)
+	retries := uint64(5)
+	budget := healthcheckBudget(&composetypes.HealthCheckConfig{
+		Interval:    &interval,
+		Timeout:     &timeout,
+		StartPeriod: &startPeriod,
+		Retries:     &retries,
+	})
+	assert.Equal(t, 10*time.Second+6*7*time.Second, budget)
+}
+
+// TestLoadComposerProjectEnv checks that the project-level .env file
+// is used for interpolation, that env_file entries, environment, and
+// containerEnv are layered in the right order, and that containerEnv
+// only reaches the devcontainer's own service.
+func TestLoadComposerProjectEnv(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// The context directory defaults to the working directory
+	t.Chdir(filepath.Join("testdata", "compose", "env"))
+	p, err := writ.NewDevcontainerParser("devcontainer.json")
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+
+	c := &Client{}
+	assert.Nil(t, c.loadComposerProject(context.Background(), p, "env"))
+
+	serviceCfg, err := c.composerProject.GetService("app")
+	assert.Nil(t, err)
+	assert.Equal(t, "docker.io/library/alpine:3", serviceCfg.Image)
+
+	containerCfg := c.buildServiceContainerConfig(p, &serviceCfg)
+	assert.Contains(t, containerCfg.Env, "FROM_DOTENV=from-dotenv")
+	assert.Contains(t, containerCfg.Env, "ONLY_IN_ENV_FILE=from-env-file")
+	assert.Contains(t, containerCfg.Env, "OVERRIDDEN=from-environment")
+	assert.Contains(t, containerCfg.Env, "FROM_CONTAINER_ENV=from-containerenv")
+	assert.NotContains(t, containerCfg.Env, "OVERRIDDEN=from-env-file")
+
+	// containerEnv is meant for the devcontainer alone, not the
+	// services running alongside it
+	serviceCfg, err = c.composerProject.GetService("db")
+	assert.Nil(t, err)
+	containerCfg = c.buildServiceContainerConfig(p, &serviceCfg)
+	assert.Contains(t, containerCfg.Env, "FROM_DOTENV=from-dotenv")
+	assert.NotContains(t, containerCfg.Env, "FROM_CONTAINER_ENV=from-containerenv")
+}
+
+// TestBuildServiceResources checks that deploy.resources takes
+// precedence over the service-level resource constraints.
+func TestBuildServiceResources(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	serviceCfg := &composetypes.ServiceConfig{
+		Name:      "db",
+		CPUS:      1.5,
+		CPUSet:    "0-1",
+		MemLimit:  composetypes.UnitBytes(512 * 1024 * 1024),
+		PidsLimit: 100,
+	}
+	resources := buildServiceResources(serviceCfg)
+	assert.Equal(t, int64(1_500_000_000), resources.NanoCPUs)
+	assert.Equal(t, "0-1", resources.CpusetCpus)
+	assert.Equal(t, int64(512*1024*1024), resources.Memory)
+	assert.Equal(t, int64(100), *resources.PidsLimit)
+
+	serviceCfg.Deploy = &composetypes.DeployConfig{
+		Resources: composetypes.Resources{
+			Limits: &composetypes.Resource{
+				NanoCPUs:    "0.5",
+				MemoryBytes: composetypes.UnitBytes(256 * 1024 * 1024),
+			},
+			Reservations: &composetypes.Resource{
+				MemoryBytes: composetypes.UnitBytes(128 * 1024 * 1024),
+			},
+		},
+	}
+	resources = buildServiceResources(serviceCfg)
+	assert.Equal(t, int64(500_000_000), resources.NanoCPUs)
+	assert.Equal(t, int64(256*1024*1024), resources.Memory)
+	assert.Equal(t, int64(128*1024*1024), resources.MemoryReservation)
+	assert.Equal(t, int64(100), *resources.PidsLimit)
+}
+
+// TestBuildServiceRestartPolicy checks the translation of both the
+// restart field and deploy.restart_policy.
+func TestBuildServiceRestartPolicy(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.Equal(t, container.RestartPolicy{}, buildServiceRestartPolicy(&composetypes.ServiceConfig{}))
+	assert.Equal(t, container.RestartPolicy{Name: container.RestartPolicyUnlessStopped}, buildServiceRestartPolicy(&composetypes.ServiceConfig{Restart: "unless-stopped"}))
+	assert.Equal(t, container.RestartPolicy{Name: container.RestartPolicyOnFailure, MaximumRetryCount: 3}, buildServiceRestartPolicy(&composetypes.ServiceConfig{Restart: "on-failure:3"}))
+
+	maxAttempts := uint64(5)
+	serviceCfg := &composetypes.ServiceConfig{
+		Deploy: &composetypes.DeployConfig{
+			RestartPolicy: &composetypes.RestartPolicy{Condition: "on-failure", MaxAttempts: &maxAttempts},
+		},
+	}
+	assert.Equal(t, container.RestartPolicy{Name: container.RestartPolicyOnFailure, MaximumRetryCount: 5}, buildServiceRestartPolicy(serviceCfg))
+
+	// restart takes precedence over deploy.restart_policy
+	serviceCfg.Restart = "always"
+	assert.Equal(t, container.RestartPolicy{Name: container.RestartPolicyAlways}, buildServiceRestartPolicy(serviceCfg))
+}
+
+func TestBuildServiceEndpointSettings(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	endpointSettings, err := buildServiceEndpointSettings("app", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app"}, endpointSettings.Aliases)
+	assert.Nil(t, endpointSettings.IPAMConfig)
+
+	endpointSettings, err = buildServiceEndpointSettings("app", &composetypes.ServiceNetworkConfig{
+		Aliases:      []string{"web", "app"},
+		Ipv4Address:  "172.28.0.10",
+		Ipv6Address:  "fd00::10",
+		LinkLocalIPs: []string{"169.254.8.8"},
+		Priority:     100,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app", "web"}, endpointSettings.Aliases)
+	assert.Equal(t, 100, endpointSettings.GwPriority)
+	if assert.NotNil(t, endpointSettings.IPAMConfig) {
+		assert.Equal(t, netip.MustParseAddr("172.28.0.10"), endpointSettings.IPAMConfig.IPv4Address)
+		assert.Equal(t, netip.MustParseAddr("fd00::10"), endpointSettings.IPAMConfig.IPv6Address)
+		assert.Equal(t, []netip.Addr{netip.MustParseAddr("169.254.8.8")}, endpointSettings.IPAMConfig.LinkLocalIPs)
+	}
+
+	_, err = buildServiceEndpointSettings("app", &composetypes.ServiceNetworkConfig{Ipv4Address: "fd00::10"})
+	assert.Error(t, err)
+	_, err = buildServiceEndpointSettings("app", &composetypes.ServiceNetworkConfig{LinkLocalIPs: []string{"10.0.0.1"}})
+	assert.Error(t, err)
+}
+
+// TestBindServiceWorkspaceMount checks that the primary service gets
+// the workspace mount, unless one of its volumes already targets the
+// workspace folder.
+func TestBindServiceWorkspaceMount(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	contextPath := "/src/project/.devcontainer"
+	workspacePath := "/src/project"
+	workspaceFolder := "/workspace"
+	p := &writ.DevcontainerParser{LocalWorkspaceFolder: workspacePath}
+	p.Config.Context = &contextPath
+	p.Config.WorkspaceFolder = &workspaceFolder
+
+	c := &Client{}
+	hostCfg := &container.HostConfig{}
+	c.bindServiceWorkspaceMount(p, &composetypes.ServiceConfig{Name: "app"}, hostCfg)
+	assert.Equal(t, []mount.Mount{{Type: mount.TypeBind, Source: workspacePath, Target: workspaceFolder}}, hostCfg.Mounts)
+
+	hostCfg = &container.HostConfig{}
+	serviceCfg := &composetypes.ServiceConfig{
+		Name:    "app",
+		Volumes: []composetypes.ServiceVolumeConfig{{Type: "bind", Source: "..", Target: "/workspace/"}},
+	}
+	c.bindServiceWorkspaceMount(p, serviceCfg, hostCfg)
+	assert.Empty(t, hostCfg.Mounts)
+
+	p.Config.WorkspaceMount = &writ.MobyMount{Mount: mount.Mount{Type: mount.TypeVolume, Source: "project-src", Target: "/code"}}
+	hostCfg = &container.HostConfig{}
+	c.bindServiceWorkspaceMount(p, &composetypes.ServiceConfig{Name: "app"}, hostCfg)
+	assert.Equal(t, []mount.Mount{{Type: mount.TypeVolume, Source: "project-src", Target: "/code"}}, hostCfg.Mounts)
+}
+
+// TestBuildServiceMount checks that a service's volumes carry their
+// options over to the Moby mounts they're converted into, and that
+// bind mounts that can't be expressed as one fall back to bind
+// strings.
+func TestBuildServiceMount(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	bindVolume := composetypes.ServiceVolumeConfig{
+		Type:        composetypes.VolumeTypeBind,
+		Source:      "/src",
+		Target:      "/dst",
+		ReadOnly:    true,
+		Consistency: "cached",
+		Bind:        &composetypes.ServiceVolumeBind{Propagation: composetypes.PropagationRShared},
+	}
+	assert.False(t, useServiceVolumeBind(bindVolume))
+	assert.Equal(t, mount.Mount{
+		Type:        mount.TypeBind,
+		Source:      "/src",
+		Target:      "/dst",
+		ReadOnly:    true,
+		Consistency: mount.ConsistencyCached,
+		BindOptions: &mount.BindOptions{Propagation: mount.PropagationRShared},
+	}, buildServiceMount(bindVolume))
+
+	bindVolume.Bind.CreateHostPath = true
+	assert.True(t, useServiceVolumeBind(bindVolume))
+	bindVolume.Bind = &composetypes.ServiceVolumeBind{SELinux: composetypes.SELinuxShared}
+	assert.True(t, useServiceVolumeBind(bindVolume))
+
+	namedVolume := composetypes.ServiceVolumeConfig{
+		Type:   composetypes.VolumeTypeVolume,
+		Source: "proj_data",
+		Target: "/data",
+		Volume: &composetypes.ServiceVolumeVolume{NoCopy: true},
+	}
+	assert.False(t, useServiceVolumeBind(namedVolume))
+	assert.Equal(t, mount.Mount{
+		Type:          mount.TypeVolume,
+		Source:        "proj_data",
+		Target:        "/data",
+		VolumeOptions: &mount.VolumeOptions{NoCopy: true},
+	}, buildServiceMount(namedVolume))
+}
+
+// TestBuildServiceTmpfs checks that tmpfs mounts declared either in a
+// service's volumes or its tmpfs field keep their options.
+func TestBuildServiceTmpfs(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tmpfsVolume := composetypes.ServiceVolumeConfig{
+		Type:   composetypes.VolumeTypeTmpfs,
+		Target: "/scratch",
+		Tmpfs:  &composetypes.ServiceVolumeTmpfs{Size: 64 * 1024 * 1024, Mode: 0o1777},
+	}
+	assert.Equal(t, mount.Mount{
+		Type:         mount.TypeTmpfs,
+		Target:       "/scratch",
+		TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 64 * 1024 * 1024, Mode: os.FileMode(0o1777)},
+	}, buildServiceMount(tmpfsVolume))
+
+	c := &Client{composerProject: &composetypes.Project{}}
+	hostCfg := c.buildServiceHostConfig(&composetypes.ServiceConfig{
+		Name:    "app",
+		Tmpfs:   composetypes.StringList{"/run", "/tmp:size=64m,mode=1777"},
+		Volumes: []composetypes.ServiceVolumeConfig{tmpfsVolume},
+	})
+	assert.Equal(t, map[string]string{"/run": "", "/tmp": "size=64m,mode=1777"}, hostCfg.Tmpfs)
+	assert.Len(t, hostCfg.Mounts, 1)
+	assert.Empty(t, hostCfg.Binds)
+}
+
+// TestSynthesizeInlineContainerfile checks that inlined Containerfiles
+// are written outside the build context, to files of their own, and
+// removed once the images are built unless they're to be kept.
+func TestSynthesizeInlineContainerfile(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctxDir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(ctxDir, "Containerfile"), []byte("FROM project\n"), 0o644))
+
+	c := &Client{composerProject: &composetypes.Project{Name: "inline"}}
+	buildCfg := &composetypes.BuildConfig{Context: ctxDir, DockerfileInline: "FROM inline\n"}
+	buildOpts, err := c.buildServiceBuildOpts("app", buildCfg, true)
+	assert.Nil(t, err)
+	assert.True(t, containerfileOutsideContext(ctxDir, buildOpts.Dockerfile))
+	contents, err := os.ReadFile(buildOpts.Dockerfile)
+	assert.Nil(t, err)
+	assert.Equal(t, "FROM inline\n", string(contents))
+
+	otherPath, err := c.synthesizeInlineContainerfile("app", &buildCfg.DockerfileInline)
+	assert.Nil(t, err)
+	assert.NotEqual(t, buildOpts.Dockerfile, otherPath)
+
+	contents, err = os.ReadFile(filepath.Join(ctxDir, "Containerfile"))
+	assert.Nil(t, err)
+	assert.Equal(t, "FROM project\n", string(contents))
+
+	containerfilesDir := c.containerfilesDir
+	c.KeepSynthesizedContainerfiles = true
+	c.removeSynthesizedContainerfiles()
+	assert.DirExists(t, containerfilesDir)
+	c.KeepSynthesizedContainerfiles = false
+	c.removeSynthesizedContainerfiles()
+	assert.NoDirExists(t, containerfilesDir)
+	assert.Empty(t, c.containerfilesDir)
+}
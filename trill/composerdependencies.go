@@ -0,0 +1,191 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+	"github.com/heimdalr/dag"
+	mobyclient "github.com/moby/moby/client"
+)
+
+// findDependencyCycle returns the names of the services that make up
+// a cycle of depends_on entries, starting and ending with the same
+// service (e.g., [a b c a]), or nil if there's none.
+//
+// Services are visited in alphabetical order, so the same cycle is
+// reported every time.
+func findDependencyCycle(services composetypes.Services) []string {
+	dependsOn := make(map[string][]string, len(services))
+	for _, service := range services {
+		dependsOn[service.Name] = slices.Sorted(slices.Values(service.GetDependencies()))
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(services))
+	var path []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case visiting:
+			start := slices.Index(path, name)
+			return append(slices.Clone(path[start:]), name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dependency := range dependsOn[name] {
+			if cycle := visit(dependency); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(dependsOn)) {
+		if cycle := visit(name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// buildServicesDAG arranges the services of the Composer project into
+// a DAG, with edges running from each dependency to the services that
+// depend on it.
+//
+// Dependency cycles are reported as ErrDependencyCycle, naming the
+// services involved, rather than whatever the DAG makes of them.
+func (c *Client) buildServicesDAG() (*dag.DAG, error) {
+	if cycle := findDependencyCycle(c.composerProject.AllServices()); cycle != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(cycle, " -> "))
+	}
+
+	servicesDAG := dag.NewDAG()
+	// First, add the vertices, to make sure the edges will have a
+	// valid reference...
+	for _, service := range c.composerProject.AllServices() {
+		if err := servicesDAG.AddVertexByID(service.Name, &service); err != nil {
+			return nil, err
+		}
+	}
+	// ... then the edges
+	for _, service := range c.composerProject.AllServices() {
+		for _, dependency := range service.GetDependencies() {
+			if err := servicesDAG.AddEdge(dependency, service.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return servicesDAG, nil
+}
+
+// isOptionalService reports whether a failure to bring up the service
+// named serviceName can be tolerated: it has to have dependents, and
+// every one of them has to have marked its dependency on it as
+// required: false.
+//
+// The primary service is never optional.
+func (c *Client) isOptionalService(serviceName string, primaryService string) bool {
+	if serviceName == primaryService {
+		return false
+	}
+	hasDependents := false
+	for _, service := range c.composerProject.AllServices() {
+		dependency, ok := service.DependsOn[serviceName]
+		if !ok {
+			continue
+		}
+		if dependency.Required {
+			return false
+		}
+		hasDependents = true
+	}
+	return hasDependents
+}
+
+// servicesToRestartWith returns the services that have to be
+// restarted along with the one named serviceName: the ones that
+// depend on it with restart: true, then the ones that depend on
+// those in turn, and so on, each listed once, after all of the
+// services it depends on.
+func (c *Client) servicesToRestartWith(serviceName string) []string {
+	var restart []string
+	queue := []string{serviceName}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, service := range c.composerProject.AllServices() {
+			dependency, ok := service.DependsOn[current]
+			if !ok || !dependency.Restart {
+				continue
+			}
+			// Moved to the end, so it comes after every dependency
+			// that has it restarted
+			if idx := slices.Index(restart, service.Name); idx >= 0 {
+				restart = slices.Delete(restart, idx, idx+1)
+			}
+			restart = append(restart, service.Name)
+			queue = append(queue, service.Name)
+		}
+	}
+	return restart
+}
+
+// RestartComposerService restarts the containers of the service named
+// serviceName, then those of the services that depend on it with
+// restart: true, as `docker compose restart` does; see
+// servicesToRestartWith.
+//
+// It returns the names of the services it restarted, in the order it
+// restarted them.
+func (c *Client) RestartComposerService(ctx context.Context, serviceName string) (restarted []string, err error) {
+	if c.composerProject == nil {
+		return nil, fmt.Errorf("no Composer project has been loaded")
+	}
+	if _, err := c.composerProject.GetService(serviceName); err != nil {
+		return nil, err
+	}
+
+	for _, name := range append([]string{serviceName}, c.servicesToRestartWith(serviceName)...) {
+		for _, containerName := range c.composerContainerNames(name) {
+			slog.Debug("restarting Composer service container", "service", name, "container", containerName)
+			if _, err := c.mobyClient.ContainerStop(ctx, containerName, mobyclient.ContainerStopOptions{}); err != nil {
+				return restarted, fmt.Errorf("unable to stop service %s: %w", name, err)
+			}
+			if _, err := c.mobyClient.ContainerStart(ctx, containerName, mobyclient.ContainerStartOptions{}); err != nil {
+				return restarted, fmt.Errorf("%w %s: %w", ErrContainerStart, containerName, err)
+			}
+		}
+		restarted = append(restarted, name)
+	}
+	return restarted, nil
+}
@@ -0,0 +1,212 @@
+package trill
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+	"github.com/moby/moby/api/types/container"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// dependsOn returns a depends_on configuration on each of names, with
+// the given attributes.
+func dependsOn(required bool, restart bool, names ...string) composetypes.DependsOnConfig {
+	cfg := make(composetypes.DependsOnConfig, len(names))
+	for _, name := range names {
+		cfg[name] = composetypes.ServiceDependency{
+			Condition: composetypes.ServiceConditionStarted,
+			Required:  required,
+			Restart:   restart,
+		}
+	}
+	return cfg
+}
+
+// TestBuildServicesDAGCycle checks that dependency cycles are
+// reported up front, naming the services involved.
+func TestBuildServicesDAGCycle(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	c := &Client{composerProject: &composetypes.Project{Services: composetypes.Services{
+		{Name: "app", DependsOn: dependsOn(true, false, "api")},
+		{Name: "api", DependsOn: dependsOn(true, false, "db", "cache")},
+		{Name: "cache"},
+		{Name: "db", DependsOn: dependsOn(true, false, "app")},
+	}}}
+	_, err := c.buildServicesDAG()
+	assert.ErrorIs(t, err, ErrDependencyCycle)
+	assert.ErrorContains(t, err, "api -> db -> app -> api")
+
+	c.composerProject.Services[3].DependsOn = nil
+	servicesDAG, err := c.buildServicesDAG()
+	assert.Nil(t, err)
+	assert.Len(t, servicesDAG.GetRoots(), 2)
+
+	assert.Nil(t, findDependencyCycle(composetypes.Services{{Name: "solo"}}))
+	assert.Equal(t, []string{"solo", "solo"}, findDependencyCycle(composetypes.Services{{Name: "solo", DependsOn: dependsOn(true, false, "solo")}}))
+}
+
+// TestIsOptionalService checks that only services every dependent
+// marks as required: false are optional.
+func TestIsOptionalService(t *testing.T) {
+	c := &Client{composerProject: &composetypes.Project{Services: composetypes.Services{
+		{Name: "app", DependsOn: dependsOn(false, false, "metrics", "db")},
+		{Name: "worker", DependsOn: dependsOn(true, false, "db")},
+		{Name: "db"},
+		{Name: "metrics"},
+		{Name: "standalone"},
+	}}}
+	assert.True(t, c.isOptionalService("metrics", "app"))
+	assert.False(t, c.isOptionalService("db", "app"))
+	assert.False(t, c.isOptionalService("standalone", "app"))
+	assert.False(t, c.isOptionalService("metrics", "metrics"))
+}
+
+// TestRestartComposerService checks that restarting a service
+// restarts the ones that depend on it with restart: true, after the
+// ones they depend on.
+func TestRestartComposerService(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	c := newFakeClient(t, engine)
+	c.composerProject = &composetypes.Project{Name: "restart", Services: composetypes.Services{
+		{Name: "app", DependsOn: composetypes.DependsOnConfig{
+			"db":  {Condition: composetypes.ServiceConditionStarted, Required: true, Restart: true},
+			"api": {Condition: composetypes.ServiceConditionStarted, Required: true, Restart: true},
+		}},
+		{Name: "api", DependsOn: dependsOn(true, true, "db")},
+		{Name: "worker", DependsOn: dependsOn(true, false, "db")},
+		{Name: "db"},
+	}}
+	for _, service := range c.composerProject.Services {
+		_, err := engine.ContainerCreate(context.Background(), mobyclient.ContainerCreateOptions{Name: "restart--" + service.Name})
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, []string{"api", "app"}, c.servicesToRestartWith("db"))
+	assert.Empty(t, c.servicesToRestartWith("app"))
+
+	restarted, err := c.RestartComposerService(context.Background(), "db")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"db", "api", "app"}, restarted)
+	starts := engine.CallsTo("ContainerStart")
+	if assert.Len(t, starts, 3) {
+		assert.Equal(t, "restart--db", starts[0].Target)
+		assert.Equal(t, "restart--app", starts[2].Target)
+	}
+
+	_, err = c.RestartComposerService(context.Background(), "missing")
+	assert.NotNil(t, err)
+
+	engine.FailOn("ContainerStart:restart--api", errors.New("no such container"))
+	restarted, err = c.RestartComposerService(context.Background(), "db")
+	assert.ErrorIs(t, err, ErrContainerStart)
+	assert.Equal(t, []string{"db"}, restarted)
+}
+
+// TestWaitForServiceDependency checks that each depends_on condition
+// is resolved, or fails, as the engine reports the dependency's state
+// changing, without polling it.
+func TestWaitForServiceDependency(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("alpine", nil)
+	c := newFakeClient(t, engine)
+	c.composerProject = &composetypes.Project{Name: "wait", Services: composetypes.Services{{Name: "db"}}}
+	ctx := context.Background()
+
+	// start creates and starts a container for db, with a
+	// healthcheck if healthy is set
+	start := func(name string, healthy bool) {
+		cfg := &container.Config{Image: "alpine"}
+		if healthy {
+			cfg.Healthcheck = &container.HealthConfig{Test: []string{"CMD", "true"}}
+		}
+		created, err := engine.ContainerCreate(ctx, mobyclient.ContainerCreateOptions{Name: name, Config: cfg})
+		assert.Nil(t, err)
+		_, err = engine.ContainerStart(ctx, created.ID, mobyclient.ContainerStartOptions{})
+		assert.Nil(t, err)
+	}
+	// wait waits on name in the background, returning once it has
+	// inspected the container, and so is following its events
+	wait := func(name string, condition string) <-chan error {
+		inspects := len(engine.CallsTo("ContainerInspect"))
+		errChan := make(chan error, 1)
+		go func() { errChan <- c.waitForServiceDependency(ctx, "db", name, condition) }()
+		assert.Eventually(t, func() bool { return len(engine.CallsTo("ContainerInspect")) > inspects }, 5*time.Second, time.Millisecond)
+		return errChan
+	}
+	state := func(running bool, exitCode int, health container.HealthStatus) container.State {
+		s := container.State{Running: running, ExitCode: exitCode, Status: container.StateExited}
+		if running {
+			s.Status = container.StateRunning
+		}
+		if len(health) > 0 {
+			s.Health = &container.Health{Status: health}
+		}
+		return s
+	}
+
+	t.Run("started", func(t *testing.T) {
+		start("wait--started", false)
+		assert.Nil(t, c.waitForServiceDependency(ctx, "db", "wait--started", composetypes.ServiceConditionStarted))
+
+		// Crashing while settling
+		c.DependencySettleTime = time.Minute
+		defer func() { c.DependencySettleTime = 0 }()
+		errChan := wait("wait--started", composetypes.ServiceConditionStarted)
+		assert.Nil(t, engine.SetContainerState("wait--started", state(false, 1, "")))
+		assert.ErrorContains(t, <-errChan, "needed to be running")
+	})
+
+	t.Run("healthy", func(t *testing.T) {
+		start("wait--healthy", true)
+		errChan := wait("wait--healthy", composetypes.ServiceConditionHealthy)
+		assert.Nil(t, engine.SetContainerState("wait--healthy", state(true, 0, container.Healthy)))
+		assert.Nil(t, <-errChan)
+		assert.Empty(t, engine.CallsTo("ContainerWait"))
+
+		assert.Nil(t, engine.SetContainerState("wait--healthy", state(true, 0, container.Starting)))
+		errChan = wait("wait--healthy", composetypes.ServiceConditionHealthy)
+		assert.Nil(t, engine.SetContainerState("wait--healthy", state(true, 0, container.Unhealthy)))
+		assert.ErrorContains(t, <-errChan, "unhealthy")
+
+		start("wait--nohealth", false)
+		assert.ErrorContains(t, c.waitForServiceDependency(ctx, "db", "wait--nohealth", composetypes.ServiceConditionHealthy), "lacks a healthcheck")
+	})
+
+	t.Run("completed_successfully", func(t *testing.T) {
+		start("wait--completed", false)
+		errChan := wait("wait--completed", composetypes.ServiceConditionCompletedSuccessfully)
+		assert.Nil(t, engine.SetContainerState("wait--completed", state(false, 0, "")))
+		assert.Nil(t, <-errChan)
+
+		start("wait--failed", false)
+		errChan = wait("wait--failed", composetypes.ServiceConditionCompletedSuccessfully)
+		assert.Nil(t, engine.SetContainerState("wait--failed", state(false, 3, "")))
+		assert.ErrorContains(t, <-errChan, "exit code 3")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		c.DependencyTimeout = 10 * time.Millisecond
+		defer func() { c.DependencyTimeout = DefaultDependencyTimeout }()
+		start("wait--slow", true)
+		assert.ErrorContains(t, c.waitForServiceDependency(ctx, "db", "wait--slow", composetypes.ServiceConditionHealthy), "timed out")
+	})
+
+	// The containers were inspected once per wait, and never polled
+	assert.Len(t, engine.CallsTo("ContainerInspect"), len(engine.CallsTo("Events")))
+	assert.ErrorContains(t, c.waitForServiceDependency(ctx, "db", "wait--started", "service_bored"), "unknown")
+}
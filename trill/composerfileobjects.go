@@ -0,0 +1,164 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/mount"
+)
+
+// Where secrets end up inside a container when their target isn't an
+// absolute path; configs go to the root of the filesystem instead.
+const secretsTargetDir string = "/run/secrets"
+
+// Compose's default permissions for secrets and configs
+const defaultFileObjectMode fs.FileMode = 0o444
+
+// bindServiceFileObjects mounts the secrets and configs a service
+// references into its container, the way docker compose does when not
+// in swarm mode: read-only bind mounts of either the file the secret
+// or config points to, or of a file brig writes out with its contents
+// (for environment- and content-based ones).
+//
+// External secrets and configs only make sense in swarm mode, and are
+// rejected.
+func (c *Client) bindServiceFileObjects(serviceCfg *composetypes.ServiceConfig, hostCfg *container.HostConfig) error {
+	for _, ref := range serviceCfg.Secrets {
+		secretCfg, ok := c.composerProject.Secrets[ref.Source]
+		if !ok {
+			return fmt.Errorf("service %s references undefined secret %s", serviceCfg.Name, ref.Source)
+		}
+		target := fileObjectTarget(secretsTargetDir, composetypes.FileReferenceConfig(ref))
+		if err := c.bindFileObject(serviceCfg.Name, "secret", composetypes.FileObjectConfig(secretCfg), composetypes.FileReferenceConfig(ref), target, hostCfg); err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range serviceCfg.Configs {
+		configCfg, ok := c.composerProject.Configs[ref.Source]
+		if !ok {
+			return fmt.Errorf("service %s references undefined config %s", serviceCfg.Name, ref.Source)
+		}
+		target := fileObjectTarget("/", composetypes.FileReferenceConfig(ref))
+		if err := c.bindFileObject(serviceCfg.Name, "config", composetypes.FileObjectConfig(configCfg), composetypes.FileReferenceConfig(ref), target, hostCfg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bindFileObject adds a read-only bind mount of a secret or config
+// (objType) to hostCfg, materializing its contents into a file first
+// if it isn't file-based.
+func (c *Client) bindFileObject(serviceName string, objType string, objCfg composetypes.FileObjectConfig, ref composetypes.FileReferenceConfig, target string, hostCfg *container.HostConfig) error {
+	if objCfg.External.External {
+		return fmt.Errorf("service %s references external %s %s, which is only supported in swarm mode", serviceName, objType, ref.Source)
+	}
+	if len(ref.UID) > 0 || len(ref.GID) > 0 {
+		slog.Warn("uid/gid are not supported for bind-mounted secrets and configs; ignoring", "service", serviceName, objType, ref.Source)
+	}
+
+	mode := defaultFileObjectMode
+	if ref.Mode != nil {
+		mode = fs.FileMode(*ref.Mode)
+	}
+
+	var source string
+	switch {
+	case len(objCfg.File) > 0:
+		source = objCfg.File
+
+	case len(objCfg.Environment) > 0:
+		val, ok := c.composerProject.Environment[objCfg.Environment]
+		if !ok {
+			return fmt.Errorf("environment variable %s for %s %s is not set", objCfg.Environment, objType, ref.Source)
+		}
+		var err error
+		if source, err = c.writeFileObject(objType, ref.Source, val, mode); err != nil {
+			return err
+		}
+
+	case len(objCfg.Content) > 0:
+		var err error
+		if source, err = c.writeFileObject(objType, ref.Source, objCfg.Content, mode); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("%s %s specifies neither a file, an environment variable, nor content", objType, ref.Source)
+	}
+
+	slog.Debug("binding file object into service container", "service", serviceName, "type", objType, "name", ref.Source, "source", source, "target", target)
+	hostCfg.Mounts = append(hostCfg.Mounts, mount.Mount{
+		Type:     mount.TypeBind,
+		Source:   source,
+		Target:   target,
+		ReadOnly: true,
+	})
+	return nil
+}
+
+// writeFileObject writes contents into a file in a private, temporary
+// directory so it can be bind-mounted into a container; the directory
+// is removed when the Composer project is torn down.
+func (c *Client) writeFileObject(objType string, name string, contents string, mode fs.FileMode) (string, error) {
+	c.resourcesMu.Lock()
+	defer c.resourcesMu.Unlock()
+
+	if len(c.fileObjectsDir) == 0 {
+		dir, err := os.MkdirTemp("", fmt.Sprintf("brig-%s-*", c.composerProject.Name))
+		if err != nil {
+			return "", err
+		}
+		c.fileObjectsDir = dir
+	}
+
+	objPath := filepath.Join(c.fileObjectsDir, fmt.Sprintf("%s-%s", objType, name))
+	if err := os.WriteFile(objPath, []byte(contents), mode); err != nil {
+		return "", err
+	}
+	// WriteFile is subject to the umask, and won't change the mode of
+	// a file that already exists
+	if err := os.Chmod(objPath, mode); err != nil {
+		return "", err
+	}
+	return objPath, nil
+}
+
+// fileObjectTarget determines where a secret or config ends up inside
+// the container: baseDir/<source> if no target is given, or
+// baseDir/<target> if the target is relative.
+func fileObjectTarget(baseDir string, ref composetypes.FileReferenceConfig) string {
+	target := ref.Target
+	if len(target) == 0 {
+		target = ref.Source
+	}
+	if path.IsAbs(target) {
+		return target
+	}
+	return path.Join(baseDir, target)
+}
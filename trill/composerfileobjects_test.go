@@ -0,0 +1,61 @@
+package trill
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/mount"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBindServiceFileObjects checks that file-, environment-, and
+// content-based secrets and configs are bind-mounted where docker
+// compose would put them.
+func TestBindServiceFileObjects(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Setenv("BRIG_TEST_SECRET", "from-environment")
+	// The context directory defaults to the working directory
+	t.Chdir(filepath.Join("testdata", "compose", "fileobjects"))
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+
+	p, err := writ.NewDevcontainerParser("devcontainer.json")
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+
+	c := &Client{}
+	assert.Nil(t, c.loadComposerProject(context.Background(), p, "fileobjects"))
+	defer os.RemoveAll(c.fileObjectsDir)
+
+	serviceCfg, err := c.composerProject.GetService("app")
+	assert.Nil(t, err)
+	hostCfg := &container.HostConfig{}
+	assert.Nil(t, c.bindServiceFileObjects(&serviceCfg, hostCfg))
+
+	mounts := make(map[string]mount.Mount)
+	for _, m := range hostCfg.Mounts {
+		assert.True(t, m.ReadOnly)
+		mounts[m.Target] = m
+	}
+	assert.Len(t, mounts, 4)
+
+	assert.Equal(t, filepath.Join(cwd, "secret.txt"), mounts["/run/secrets/file_secret"].Source)
+	assert.Equal(t, filepath.Join(cwd, "app.conf"), mounts["/etc/app.conf"].Source)
+
+	contents, err := os.ReadFile(mounts["/run/secrets/token"].Source)
+	assert.Nil(t, err)
+	assert.Equal(t, "from-environment", string(contents))
+
+	contents, err = os.ReadFile(mounts["/inline_config"].Source)
+	assert.Nil(t, err)
+	assert.Equal(t, "inlined", string(contents))
+}
@@ -0,0 +1,154 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/moby/moby/api/pkg/stdcopy"
+	mobyclient "github.com/moby/moby/client"
+)
+
+// serviceOutputColors are cycled through to tell services apart in
+// their streamed output, as docker compose does.
+var serviceOutputColors = []color.Attribute{
+	color.FgCyan, color.FgYellow, color.FgGreen, color.FgMagenta, color.FgBlue,
+	color.FgHiCyan, color.FgHiYellow, color.FgHiGreen, color.FgHiMagenta, color.FgHiBlue,
+}
+
+// composerOutput streams what the containers of a Composer project's
+// services print out; see ComposeOptions.ServiceOutput.
+type composerOutput struct {
+	w        io.Writer
+	writeMu  sync.Mutex        // Serializes writes to w, so lines from different containers don't run into each other
+	prefixes map[string]string // Container name -> what its lines are prefixed with
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stateMu sync.Mutex // Guards stopped, and adding to wg
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// startServiceOutput sets up the streaming of the output of the
+// containers of the loaded Composer project's services to w, bar the
+// one for primaryService, which is the devcontainer; a nil w leaves
+// their output out.
+//
+// Each service is given a color of its own, and its containers' lines
+// are prefixed with their names, padded to line up.
+func (c *Client) startServiceOutput(ctx context.Context, w io.Writer, primaryService string) {
+	if w == nil {
+		c.serviceOutput = nil
+		return
+	}
+	so := &composerOutput{w: w, prefixes: make(map[string]string)}
+	// Streams can outlive the deployment; see stopServiceOutput
+	so.ctx, so.cancel = context.WithCancel(context.WithoutCancel(ctx))
+
+	var serviceNames []string
+	var width int
+	for _, serviceName := range slices.Sorted(slices.Values(c.composerProject.ServiceNames())) {
+		if serviceName == primaryService {
+			continue
+		}
+		serviceNames = append(serviceNames, serviceName)
+		for _, containerName := range c.composerContainerNames(serviceName) {
+			width = max(width, len(containerName))
+		}
+	}
+	for idx, serviceName := range serviceNames {
+		paint := color.New(serviceOutputColors[idx%len(serviceOutputColors)]).SprintFunc()
+		for _, containerName := range c.composerContainerNames(serviceName) {
+			so.prefixes[containerName] = paint(fmt.Sprintf("%-*s |", width, containerName)) + " "
+		}
+	}
+	c.serviceOutput = so
+}
+
+// streamServiceOutput streams the output of the container designated
+// by containerID, named containerName, in the background, until it
+// exits or stopServiceOutput is called; tty is whether it has a TTY,
+// in which case its output isn't multiplexed.
+//
+// If fromNow is set (e.g., for containers reused from an earlier
+// run), only what it prints from then on is streamed.
+func (c *Client) streamServiceOutput(containerID string, containerName string, tty bool, fromNow bool) {
+	so := c.serviceOutput
+	if so == nil {
+		return
+	}
+	prefix, ok := so.prefixes[containerName]
+	if !ok {
+		return
+	}
+	so.stateMu.Lock()
+	defer so.stateMu.Unlock()
+	if so.stopped {
+		return
+	}
+	tail := "all"
+	if fromNow {
+		tail = "0"
+	}
+
+	so.wg.Add(1)
+	go func() {
+		defer so.wg.Done()
+		logs, err := c.mobyClient.ContainerLogs(so.ctx, containerID, mobyclient.ContainerLogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Follow:     true,
+			Tail:       tail,
+		})
+		if err != nil {
+			slog.Debug("unable to stream service output", "container", containerName, "error", err)
+			return
+		}
+		defer func() { _ = logs.Close() }()
+		w := &lockedWriter{mu: &so.writeMu, w: NewStreamWriter(so.w, prefix)}
+		if tty {
+			_, err = io.Copy(w, logs)
+		} else {
+			_, err = stdcopy.StdCopy(w, w, logs)
+		}
+		if err != nil && so.ctx.Err() == nil {
+			slog.Debug("service output stream ended with an error", "container", containerName, "error", err)
+		}
+	}()
+}
+
+// stopServiceOutput stops streaming the output of the services'
+// containers, once what's been read so far has been written out.
+func (c *Client) stopServiceOutput() {
+	so := c.serviceOutput
+	if so == nil {
+		return
+	}
+	so.stateMu.Lock()
+	so.stopped = true
+	so.stateMu.Unlock()
+	so.cancel()
+	so.wg.Wait()
+}
@@ -0,0 +1,87 @@
+package trill
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+	"github.com/fatih/color"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStartServiceOutput checks that every service but the
+// devcontainer's is given a prefix, padded to line up, with services
+// colored differently from one another.
+func TestStartServiceOutput(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = false
+	defer func() { color.NoColor = noColor }()
+
+	two := uint64(2)
+	c := &Client{composerProject: &composetypes.Project{Name: "out", Services: composetypes.Services{
+		{Name: "app"},
+		{Name: "db"},
+		{Name: "worker", Deploy: &composetypes.DeployConfig{Replicas: &two}},
+	}}}
+	c.startServiceOutput(context.Background(), io.Discard, "app")
+	defer c.stopServiceOutput()
+
+	prefixes := c.serviceOutput.prefixes
+	assert.Len(t, prefixes, 3)
+	assert.NotContains(t, prefixes, "out--app")
+	assert.Contains(t, prefixes["out--db"], "out--db        |")
+	assert.Contains(t, prefixes["out--worker--2"], "out--worker--2 |")
+	assert.NotEqual(t, prefixes["out--db"][:5], prefixes["out--worker"][:5])
+	assert.Equal(t, prefixes["out--worker"][:5], prefixes["out--worker--2"][:5])
+
+	c.startServiceOutput(context.Background(), nil, "app")
+	assert.Nil(t, c.serviceOutput)
+}
+
+// TestDeployComposerProjectServiceOutput checks that what the
+// services print out is streamed while the project is brought up,
+// with the devcontainer's own output left out.
+func TestDeployComposerProjectServiceOutput(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Chdir(filepath.Join("testdata", "compose", "deploy"))
+	p, err := writ.NewDevcontainerParser("devcontainer.json")
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+
+	engine := testutil.NewFakeEngine()
+	engine.OutputHandler = func(containerID string, cmd []string) testutil.ExecResult {
+		ctr, _ := engine.Container(containerID)
+		return testutil.ExecResult{Stdout: "up: " + strings.TrimPrefix(ctr.Name, "/") + "\n", Stderr: "warming up\n"}
+	}
+	c := newFakeClient(t, engine)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	answerLifecycleEvents(ctx, c)
+
+	var output bytes.Buffer
+	err = c.DeployComposerProject(ctx, p, ComposeOptions{ProjectName: "deploy", SuppressOutput: true, ServiceOutput: &output, FollowServiceOutput: true})
+	assert.Nil(t, err)
+	c.CloseLifecycle()
+	db, _ := engine.Container("deploy--db")
+	// Still streaming, as it's being followed
+	assert.False(t, c.serviceOutput.stopped)
+	assert.Nil(t, c.TeardownComposerProject(context.Background()))
+	assert.True(t, c.serviceOutput.stopped)
+
+	assert.Contains(t, output.String(), "deploy--db | up: deploy--db\n")
+	assert.Contains(t, output.String(), "deploy--db | warming up\n")
+	assert.NotContains(t, output.String(), "deploy--app")
+	if calls := engine.CallsTo("ContainerLogs"); assert.Len(t, calls, 1) {
+		assert.Equal(t, db.ID, calls[0].Target)
+	}
+}
@@ -0,0 +1,227 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+	cerrdefs "github.com/containerd/errdefs"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/writ"
+)
+
+// ConfigHashLabel is the label applied to the containers of Composer
+// services; its value is a hash of the service's configuration and
+// image, and is used to determine whether or not a container left
+// over from an earlier run (e.g., with --detach) can be reused.
+const ConfigHashLabel string = "io.github.nlsantos.brig.config-hash"
+
+// existingServiceContainer describes a container for a Composer
+// service that's left over from an earlier run.
+type existingServiceContainer struct {
+	ID      string
+	Running bool
+	Reuse   bool // If true, the container is up to date, and is used as-is instead of being recreated
+}
+
+// hashServiceConfig computes a hash of what goes into the container
+// for serviceCfg: its configuration (sans the number of replicas,
+// which doesn't affect the containers themselves), the environment it
+// ends up with (containerEnv and proxy settings included), and the ID
+// of its image, so rebuilt or newly pulled images are picked up.
+func hashServiceConfig(serviceCfg composetypes.ServiceConfig, env []string, imageID string) (string, error) {
+	if serviceCfg.Deploy != nil {
+		deployCfg := *serviceCfg.Deploy
+		deployCfg.Replicas = nil
+		serviceCfg.Deploy = &deployCfg
+		// As added by applyServiceScale
+		if reflect.DeepEqual(deployCfg, composetypes.DeployConfig{}) {
+			serviceCfg.Deploy = nil
+		}
+	}
+	serviceJSON, err := json.Marshal(serviceCfg)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "service %s\n", serviceJSON)
+	for _, envVar := range env {
+		fmt.Fprintf(h, "env %s\n", envVar)
+	}
+	fmt.Fprintf(h, "image %s\n", imageID)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// diffComposerServices compares the services of the Composer project
+// with the containers left over for them from an earlier run, and
+// decides which of those can be reused as they are: the ones whose
+// configuration hash (see hashServiceConfig) hasn't changed, and that
+// don't depend on a service whose containers have to be recreated.
+//
+// The devcontainer's own service is always recreated, as its
+// lifecycle commands are run against a fresh container, and so are
+// the services depending on it. Replicas
+// beyond the number a service is now scaled to are removed.
+//
+// It fails if a container the project needs the name of wasn't
+// created by brig for this workspace, or if one can't be inspected for
+// any reason other than its not existing.
+func (c *Client) diffComposerServices(ctx context.Context, p *writ.DevcontainerParser, imageTagPrefix string) error {
+	c.serviceConfigHashes = make(map[string]string)
+	c.serviceContainers = make(map[string]existingServiceContainer)
+	ourLabels := c.ResourceLabels(p)
+	changed := make(map[string]bool)
+
+	for _, serviceCfg := range c.composerProject.AllServices() {
+		hash, err := c.serviceConfigHash(ctx, p, serviceCfg, imageTagPrefix)
+		if err != nil {
+			return err
+		}
+		c.serviceConfigHashes[serviceCfg.Name] = hash
+		if serviceCfg.Name == *p.Config.Service {
+			changed[serviceCfg.Name] = true
+		}
+
+		replicas := serviceReplicas(&serviceCfg)
+		for replica := 1; ; replica++ {
+			containerName := c.composerContainerName(serviceCfg.Name, replica)
+			inspectRes, err := c.mobyClient.ContainerInspect(ctx, containerName, mobyclient.ContainerInspectOptions{})
+			if err != nil {
+				if !cerrdefs.IsNotFound(err) {
+					slog.Error("encountered an error while inspecting a container left over from an earlier run", "container", containerName, "error", err)
+					return fmt.Errorf("%w %s: %w", ErrContainerStart, containerName, err)
+				}
+				if replica > replicas {
+					break
+				}
+				continue
+			}
+			var labels map[string]string
+			if inspectRes.Container.Config != nil {
+				labels = inspectRes.Container.Config.Labels
+			}
+			if ownerOf(labels, ourLabels) != ownerWorkspace || labels[ComposeProjectLabel] != c.composerProject.Name {
+				return fmt.Errorf("%w %s: the name is taken by a container brig didn't create for this project", ErrContainerStart, containerName)
+			}
+
+			if replica > replicas {
+				slog.Info("removing replica left over from an earlier run beyond the service's scale", "service", serviceCfg.Name, "container", containerName)
+				if err := c.removeServiceContainer(ctx, inspectRes.Container.ID); err != nil {
+					return err
+				}
+				continue
+			}
+
+			existing := existingServiceContainer{
+				ID:      inspectRes.Container.ID,
+				Running: inspectRes.Container.State != nil && inspectRes.Container.State.Running,
+			}
+			switch {
+			case serviceCfg.Name == *p.Config.Service:
+			case len(hash) > 0 && labels[ConfigHashLabel] == hash:
+				existing.Reuse = true
+			default:
+				slog.Debug("service configuration changed since its container was created", "service", serviceCfg.Name, "container", containerName)
+				changed[serviceCfg.Name] = true
+			}
+			c.serviceContainers[containerName] = existing
+		}
+	}
+
+	// The services depending on one that's recreated are recreated
+	// along with it
+	for serviceName := range changed {
+		descendants, err := c.servicesDAG.GetDescendants(serviceName)
+		if err != nil {
+			return err
+		}
+		for dependent := range descendants {
+			for _, containerName := range c.composerContainerNames(dependent) {
+				if existing, ok := c.serviceContainers[containerName]; ok && existing.Reuse {
+					slog.Debug("recreating container along with a service it depends on", "container", containerName, "dependency", serviceName)
+					existing.Reuse = false
+					c.serviceContainers[containerName] = existing
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// serviceConfigHash returns the configuration hash of serviceCfg (see
+// hashServiceConfig), or an empty string if its image can't be
+// inspected, in which case its containers are always recreated.
+func (c *Client) serviceConfigHash(ctx context.Context, p *writ.DevcontainerParser, serviceCfg composetypes.ServiceConfig, imageTagPrefix string) (string, error) {
+	imageRef := serviceCfg.Image
+	if serviceCfg.Build != nil {
+		imageRef = fmt.Sprintf("%s%s", imageTagPrefix, c.composerContainerName(serviceCfg.Name, 1))
+	}
+	inspectRes, err := c.mobyClient.ImageInspect(ctx, imageRef)
+	if err != nil {
+		slog.Debug("unable to inspect service image; its containers will be recreated", "service", serviceCfg.Name, "image", imageRef, "error", err)
+		return "", nil
+	}
+	return hashServiceConfig(serviceCfg, c.buildServiceContainerConfig(p, &serviceCfg).Env, inspectRes.ID)
+}
+
+// reuseServiceContainer tracks the container left over from an
+// earlier run for serviceName as containerName, if it can be reused
+// (see diffComposerServices), starting it if it isn't running; it
+// returns false if it can't be.
+//
+// A container that can't be reused is removed, to make way for a new
+// one.
+func (c *Client) reuseServiceContainer(ctx context.Context, serviceName string, containerName string) (bool, error) {
+	existing, ok := c.serviceContainers[containerName]
+	if !ok {
+		return false, nil
+	}
+	if !existing.Reuse {
+		slog.Info("recreating Composer service container", "service", serviceName, "container", containerName)
+		return false, c.removeServiceContainer(ctx, existing.ID)
+	}
+
+	slog.Info("reusing up-to-date Composer service container", "service", serviceName, "container", containerName)
+	c.trackComposerContainer(serviceName, existing.ID)
+	if existing.Running {
+		return true, nil
+	}
+	if _, err := c.mobyClient.ContainerStart(ctx, existing.ID, mobyclient.ContainerStartOptions{}); err != nil {
+		return true, fmt.Errorf("%w %s: %w", ErrContainerStart, containerName, err)
+	}
+	return true, nil
+}
+
+// removeServiceContainer stops and removes the container designated
+// by containerID.
+func (c *Client) removeServiceContainer(ctx context.Context, containerID string) error {
+	if _, err := c.mobyClient.ContainerStop(ctx, containerID, mobyclient.ContainerStopOptions{}); err != nil {
+		return err
+	}
+	_, err := c.mobyClient.ContainerRemove(ctx, containerID, mobyclient.ContainerRemoveOptions{Force: true})
+	return err
+}
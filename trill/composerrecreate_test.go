@@ -0,0 +1,137 @@
+package trill
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+	"github.com/moby/moby/api/types/container"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHashServiceConfig checks that the configuration hash changes
+// with the service's configuration, environment, and image, but not
+// with its number of replicas.
+func TestHashServiceConfig(t *testing.T) {
+	serviceCfg := composetypes.ServiceConfig{Name: "db", Image: "postgres:16"}
+	base, err := hashServiceConfig(serviceCfg, []string{"A=1"}, "sha256:one")
+	assert.Nil(t, err)
+
+	two := uint64(2)
+	scaled := serviceCfg
+	scaled.Deploy = &composetypes.DeployConfig{Replicas: &two}
+	hash, err := hashServiceConfig(scaled, []string{"A=1"}, "sha256:one")
+	assert.Nil(t, err)
+	assert.Equal(t, base, hash)
+	assert.Equal(t, &two, scaled.Deploy.Replicas)
+
+	hash, _ = hashServiceConfig(serviceCfg, []string{"A=2"}, "sha256:one")
+	assert.NotEqual(t, base, hash)
+	hash, _ = hashServiceConfig(serviceCfg, []string{"A=1"}, "sha256:two")
+	assert.NotEqual(t, base, hash)
+	serviceCfg.Command = composetypes.ShellCommand{"postgres", "-c", "fsync=off"}
+	hash, _ = hashServiceConfig(serviceCfg, []string{"A=1"}, "sha256:one")
+	assert.NotEqual(t, base, hash)
+}
+
+// TestDiffComposerServices checks that only up-to-date containers
+// left over from an earlier run are reused, that the dependents of
+// changed services are recreated along with them, and that containers
+// brig didn't create are left alone.
+func TestDiffComposerServices(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctx := context.Background()
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("alpine", nil)
+	c := newFakeClient(t, engine)
+	c.composerProject = &composetypes.Project{Name: "diff", Services: composetypes.Services{
+		{Name: "app", Image: "alpine", DependsOn: dependsOn(true, false, "api")},
+		{Name: "api", Image: "alpine", DependsOn: dependsOn(true, false, "db")},
+		{Name: "db", Image: "alpine"},
+		{Name: "cache", Image: "alpine"},
+		{Name: "sidecar", Image: "alpine", DependsOn: dependsOn(true, false, "app")},
+	}}
+	var err error
+	c.servicesDAG, err = c.buildServicesDAG()
+	assert.Nil(t, err)
+
+	workspaceDir := t.TempDir()
+	workspaceFolder := "/workspace"
+	service := "app"
+	p := &writ.DevcontainerParser{LocalWorkspaceFolder: workspaceDir}
+	p.Filepath = filepath.Join(workspaceDir, ".devcontainer", "devcontainer.json")
+	p.Config.Context = &workspaceDir
+	p.Config.WorkspaceFolder = &workspaceFolder
+	p.Config.Service = &service
+
+	leftOver := func(containerName string, hash string, running bool) {
+		labels := mergeLabels(c.ResourceLabels(p), map[string]string{ConfigHashLabel: hash})
+		created, err := engine.ContainerCreate(ctx, mobyclient.ContainerCreateOptions{
+			Name:   containerName,
+			Config: &container.Config{Image: "alpine", Labels: labels},
+		})
+		assert.Nil(t, err)
+		if running {
+			_, err = engine.ContainerStart(ctx, created.ID, mobyclient.ContainerStartOptions{})
+			assert.Nil(t, err)
+		}
+	}
+	hashOf := func(serviceName string) string {
+		serviceCfg, err := c.composerProject.GetService(serviceName)
+		assert.Nil(t, err)
+		hash, err := c.serviceConfigHash(ctx, p, serviceCfg, "")
+		assert.Nil(t, err)
+		assert.NotEmpty(t, hash)
+		return hash
+	}
+	leftOver("diff--app", hashOf("app"), true)
+	leftOver("diff--api", hashOf("api"), true)
+	leftOver("diff--db", "stale", true)
+	leftOver("diff--cache", hashOf("cache"), false)
+	leftOver("diff--cache--2", hashOf("cache"), true)
+	leftOver("diff--sidecar", hashOf("sidecar"), true)
+
+	assert.Nil(t, c.diffComposerServices(ctx, p, ""))
+	assert.False(t, c.serviceContainers["diff--app"].Reuse)
+	assert.False(t, c.serviceContainers["diff--api"].Reuse)
+	assert.False(t, c.serviceContainers["diff--db"].Reuse)
+	assert.True(t, c.serviceContainers["diff--cache"].Reuse)
+	// Recreated along with the devcontainer's own service
+	assert.False(t, c.serviceContainers["diff--sidecar"].Reuse)
+	// The replica beyond the service's scale is gone
+	assert.Len(t, engine.Containers(), 5)
+	_, exists := engine.Container("diff--cache--2")
+	assert.False(t, exists)
+
+	reused, err := c.reuseServiceContainer(ctx, "cache", "diff--cache")
+	assert.True(t, reused)
+	assert.Nil(t, err)
+	state, _ := engine.Container("diff--cache")
+	assert.True(t, state.State.Running)
+	assert.Equal(t, 1, c.trackedComposerContainerCount())
+
+	reused, err = c.reuseServiceContainer(ctx, "db", "diff--db")
+	assert.False(t, reused)
+	assert.Nil(t, err)
+	_, exists = engine.Container("diff--db")
+	assert.False(t, exists)
+
+	// Only a container that doesn't exist is taken to be absent
+	engine.FailOn("ContainerInspect", errors.New("connection refused"))
+	assert.ErrorIs(t, c.diffComposerServices(ctx, p, ""), ErrContainerStart)
+	engine.FailOn("ContainerInspect", nil)
+
+	// Not one of brig's
+	_, err = engine.ContainerCreate(ctx, mobyclient.ContainerCreateOptions{Name: "diff--db"})
+	assert.Nil(t, err)
+	assert.ErrorIs(t, c.diffComposerServices(ctx, p, ""), ErrContainerStart)
+}
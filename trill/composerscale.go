@@ -0,0 +1,123 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+)
+
+// ParseScale parses `--scale` style specifications (SERVICE=NUM) into
+// the number of replicas to run of each service they name; later
+// specifications for the same service win.
+func ParseScale(specs []string) (map[string]int, error) {
+	scale := make(map[string]int, len(specs))
+	for _, spec := range specs {
+		serviceName, replicas, ok := strings.Cut(strings.TrimSpace(spec), "=")
+		if !ok || len(serviceName) == 0 {
+			return nil, fmt.Errorf("invalid scale %q; expected SERVICE=NUM", spec)
+		}
+		num, err := strconv.Atoi(replicas)
+		if err != nil || num < 0 {
+			return nil, fmt.Errorf("invalid number of replicas for service %s: %q", serviceName, replicas)
+		}
+		scale[serviceName] = num
+	}
+	return scale, nil
+}
+
+// applyServiceScale overrides the deploy.replicas of the services of
+// the Composer project named in scale (see ParseScale).
+//
+// The primary service can't be scaled, as there'd be no telling which
+// of its replicas to attach to; nor can services that other services
+// share the network stack of (via network_mode: service:NAME), as
+// they'd only share it with one of them.
+func (c *Client) applyServiceScale(scale map[string]int, primaryService string) error {
+	for serviceName, replicas := range scale {
+		idx := slices.IndexFunc(c.composerProject.Services, func(s composetypes.ServiceConfig) bool { return s.Name == serviceName })
+		if idx < 0 {
+			return fmt.Errorf("cannot scale service %s: not named in Composer YAML", serviceName)
+		}
+		if c.composerProject.Services[idx].Deploy == nil {
+			c.composerProject.Services[idx].Deploy = &composetypes.DeployConfig{}
+		}
+		num := uint64(replicas)
+		c.composerProject.Services[idx].Deploy.Replicas = &num
+	}
+
+	for _, serviceCfg := range c.composerProject.Services {
+		if serviceReplicas(&serviceCfg) == 1 {
+			continue
+		}
+		if serviceCfg.Name == primaryService {
+			return fmt.Errorf("cannot scale service %s: it's the devcontainer", serviceCfg.Name)
+		}
+		for _, other := range c.composerProject.Services {
+			if other.NetworkMode == composetypes.NetworkModeServicePrefix+serviceCfg.Name {
+				return fmt.Errorf("cannot scale service %s: service %s shares its network stack", serviceCfg.Name, other.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// serviceReplicas returns how many containers to run for serviceCfg:
+// its deploy.replicas (which the loader also moves the deprecated
+// scale into), or 1 if it doesn't have one.
+func serviceReplicas(serviceCfg *composetypes.ServiceConfig) int {
+	if serviceCfg.Deploy == nil || serviceCfg.Deploy.Replicas == nil {
+		return 1
+	}
+	return int(*serviceCfg.Deploy.Replicas)
+}
+
+// composerContainerName returns the name of the container for the
+// given replica (counting from 1) of the service named serviceName.
+//
+// The first replica's is the project's and the service's names alone,
+// which is what the devcontainer is attached by, and what network_mode:
+// service:NAME refers to; the others' have the replica's number
+// appended to that. Either way, it's made fit for use by
+// ContainerName; the project's name is expected to already tell it
+// apart from other devcontainers'.
+func (c *Client) composerContainerName(serviceName string, replica int) string {
+	if replica <= 1 {
+		return ContainerName("", c.composerProject.Name, serviceName)
+	}
+	return ContainerName("", c.composerProject.Name, serviceName, strconv.Itoa(replica))
+}
+
+// composerContainerNames returns the names of the containers for every
+// replica of the service named serviceName, in order; see
+// composerContainerName.
+func (c *Client) composerContainerNames(serviceName string) []string {
+	replicas := 1
+	if serviceCfg, err := c.composerProject.GetService(serviceName); err == nil {
+		replicas = serviceReplicas(&serviceCfg)
+	}
+	names := make([]string, 0, replicas)
+	for replica := 1; replica <= replicas; replica++ {
+		names = append(names, c.composerContainerName(serviceName, replica))
+	}
+	return names
+}
@@ -0,0 +1,86 @@
+package trill
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseScale checks that --scale specifications are parsed into
+// replica counts, with later ones winning, and that malformed ones
+// are rejected.
+func TestParseScale(t *testing.T) {
+	scale, err := ParseScale([]string{"worker=3", "db=1", " worker=2 ", "cache=0"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"worker": 2, "db": 1, "cache": 0}, scale)
+
+	for _, spec := range []string{"worker", "=3", "worker=", "worker=-1", "worker=many"} {
+		_, err := ParseScale([]string{spec})
+		assert.NotNil(t, err, spec)
+	}
+}
+
+// TestApplyServiceScale checks that --scale overrides deploy.replicas,
+// and that services that can't be scaled are refused.
+func TestApplyServiceScale(t *testing.T) {
+	three := uint64(3)
+	newClient := func() *Client {
+		return &Client{composerProject: &composetypes.Project{Name: "scaled", Services: composetypes.Services{
+			{Name: "app"},
+			{Name: "worker", Deploy: &composetypes.DeployConfig{Replicas: &three}},
+			{Name: "db"},
+			{Name: "sidecar", NetworkMode: "service:db"},
+		}}}
+	}
+
+	c := newClient()
+	assert.Nil(t, c.applyServiceScale(map[string]int{"worker": 2}, "app"))
+	assert.Equal(t, []string{"scaled--worker", "scaled--worker--2"}, c.composerContainerNames("worker"))
+	assert.Equal(t, []string{"scaled--db"}, c.composerContainerNames("db"))
+
+	c = newClient()
+	assert.Nil(t, c.applyServiceScale(nil, "app"))
+	assert.Len(t, c.composerContainerNames("worker"), 3)
+	assert.Nil(t, c.applyServiceScale(map[string]int{"worker": 0}, "app"))
+	assert.Empty(t, c.composerContainerNames("worker"))
+
+	assert.ErrorContains(t, newClient().applyServiceScale(map[string]int{"app": 2}, "app"), "devcontainer")
+	assert.ErrorContains(t, newClient().applyServiceScale(map[string]int{"db": 2}, "app"), "sidecar")
+	assert.ErrorContains(t, newClient().applyServiceScale(map[string]int{"missing": 2}, "app"), "not named")
+}
+
+// TestTeardownComposerServicesReplicas checks that every replica of a
+// service is torn down.
+func TestTeardownComposerServicesReplicas(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	two := uint64(2)
+	engine := testutil.NewFakeEngine()
+	c := newFakeClient(t, engine)
+	c.composerProject = &composetypes.Project{Name: "scaled", Services: composetypes.Services{
+		{Name: "app", DependsOn: dependsOn(true, false, "worker")},
+		{Name: "worker", Deploy: &composetypes.DeployConfig{Replicas: &two}},
+	}}
+	for _, serviceName := range []string{"app", "worker"} {
+		for _, containerName := range c.composerContainerNames(serviceName) {
+			created, err := engine.ContainerCreate(context.Background(), mobyclient.ContainerCreateOptions{Name: containerName})
+			assert.Nil(t, err)
+			c.trackComposerContainer(serviceName, created.ID)
+		}
+	}
+	assert.Equal(t, 3, c.trackedComposerContainerCount())
+
+	servicesDAG, err := c.buildServicesDAG()
+	assert.Nil(t, err)
+	assert.Nil(t, c.teardownComposerServices(context.Background(), servicesDAG))
+	assert.Len(t, engine.CallsTo("ContainerRemove"), 3)
+	assert.Empty(t, engine.Containers())
+	assert.Zero(t, c.trackedComposerContainerCount())
+}
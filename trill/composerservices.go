@@ -0,0 +1,319 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/network"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/writ"
+)
+
+// ComposerServiceContainer describes a container brig created for a
+// Composer service, as found by its labels (see ComposeServiceLabel).
+type ComposerServiceContainer struct {
+	Service string   // Name of the service
+	Replica int      // Which of the service's replicas it is, counting from 1
+	Name    string   // Name of the container
+	ID      string   // ID of the container
+	State   string   // e.g., running, or exited
+	Health  string   // e.g., healthy; empty if it has no healthcheck
+	Ports   []string // Published ports, as [HOST_IP:]HOST_PORT->PORT/PROTO
+	Tty     bool     // If true, its output isn't multiplexed
+}
+
+// ComposerLogsOptions holds the settings for ComposerServiceLogs.
+type ComposerLogsOptions struct {
+	Follow     bool   // If true, output is streamed until the containers stop or ctx is cancelled
+	Tail       string // Number of lines to show from the end of the logs, or "all"
+	Timestamps bool   // If true, every line is prefixed with when it was written
+}
+
+// LoadComposerProject loads the Composer project p refers to, named
+// and scaled as in opts, without deploying it, so the services of a
+// project that's already up can be managed (see
+// ComposerServiceContainers).
+func (c *Client) LoadComposerProject(ctx context.Context, p *writ.DevcontainerParser, opts ComposeOptions) (err error) {
+	if err = c.loadComposerProject(ctx, p, opts.ProjectName); err != nil {
+		return err
+	}
+	if err = c.applyServiceScale(opts.Scale, *p.Config.Service); err != nil {
+		return err
+	}
+	c.servicesDAG, err = c.buildServicesDAG()
+	return err
+}
+
+// ComposerServiceContainers returns the containers of the named
+// services of the loaded Composer project (of every service, if none
+// are named), whether or not they're running, ordered by service then
+// replica.
+//
+// Containers are found by their labels rather than by their names, so
+// every replica is found however many there are. It fails if a named
+// service isn't part of the project.
+func (c *Client) ComposerServiceContainers(ctx context.Context, services ...string) ([]ComposerServiceContainer, error) {
+	if c.composerProject == nil {
+		return nil, fmt.Errorf("no Composer project has been loaded")
+	}
+	if _, err := c.composerProject.GetServices(services...); err != nil {
+		return nil, err
+	}
+
+	listRes, err := c.mobyClient.ContainerList(ctx, mobyclient.ContainerListOptions{
+		All:     true,
+		Filters: make(mobyclient.Filters).Add("label", fmt.Sprintf("%s=%s", ComposeProjectLabel, c.composerProject.Name)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []ComposerServiceContainer
+	for _, summary := range listRes.Items {
+		serviceName, ok := summary.Labels[ComposeServiceLabel]
+		if !ok || (len(services) > 0 && !slices.Contains(services, serviceName)) {
+			continue
+		}
+		inspectRes, err := c.mobyClient.ContainerInspect(ctx, summary.ID, mobyclient.ContainerInspectOptions{})
+		if err != nil {
+			// Removed since it was listed
+			slog.Debug("unable to inspect Composer service container", "id", summary.ID, "error", err)
+			continue
+		}
+		replica, _ := strconv.Atoi(summary.Labels[ComposeReplicaLabel])
+		serviceContainer := ComposerServiceContainer{
+			Service: serviceName,
+			Replica: max(replica, 1),
+			Name:    strings.TrimPrefix(inspectRes.Container.Name, "/"),
+			ID:      inspectRes.Container.ID,
+		}
+		if state := inspectRes.Container.State; state != nil {
+			serviceContainer.State = string(state.Status)
+			if state.Health != nil && state.Health.Status != container.NoHealthcheck {
+				serviceContainer.Health = string(state.Health.Status)
+			}
+		}
+		if inspectRes.Container.Config != nil {
+			serviceContainer.Tty = inspectRes.Container.Config.Tty
+		}
+		if inspectRes.Container.NetworkSettings != nil {
+			ports := inspectRes.Container.NetworkSettings.Ports
+			for _, port := range slices.SortedFunc(maps.Keys(ports), func(a, b network.Port) int {
+				return cmp.Or(cmp.Compare(a.Num(), b.Num()), strings.Compare(string(a.Proto()), string(b.Proto())))
+			}) {
+				for _, binding := range ports[port] {
+					hostAddr := binding.HostPort
+					if binding.HostIP.IsValid() {
+						hostAddr = net.JoinHostPort(binding.HostIP.String(), binding.HostPort)
+					}
+					serviceContainer.Ports = append(serviceContainer.Ports, fmt.Sprintf("%s->%s", hostAddr, port))
+				}
+			}
+		}
+		containers = append(containers, serviceContainer)
+	}
+
+	slices.SortFunc(containers, func(a, b ComposerServiceContainer) int {
+		return cmp.Or(strings.Compare(a.Service, b.Service), cmp.Compare(a.Replica, b.Replica))
+	})
+	return containers, nil
+}
+
+// StartComposerServices starts the containers of the named services
+// of the loaded Composer project (of every service, if none are
+// named), each after the services it depends on.
+func (c *Client) StartComposerServices(ctx context.Context, services ...string) error {
+	containers, err := c.composerServiceContainersInOrder(ctx, services)
+	if err != nil {
+		return err
+	}
+	for _, serviceContainer := range containers {
+		slog.Info("starting Composer service container", "service", serviceContainer.Service, "container", serviceContainer.Name)
+		if _, err := c.mobyClient.ContainerStart(ctx, serviceContainer.ID, mobyclient.ContainerStartOptions{}); err != nil {
+			return fmt.Errorf("%w %s: %w", ErrContainerStart, serviceContainer.Name, err)
+		}
+	}
+	return nil
+}
+
+// StopComposerServices stops the containers of the named services of
+// the loaded Composer project (of every service, if none are named),
+// each before the services it depends on. The containers are left in
+// place, so they can be started again.
+func (c *Client) StopComposerServices(ctx context.Context, services ...string) error {
+	containers, err := c.composerServiceContainersInOrder(ctx, services)
+	if err != nil {
+		return err
+	}
+	slices.Reverse(containers)
+	for _, serviceContainer := range containers {
+		slog.Info("stopping Composer service container", "service", serviceContainer.Service, "container", serviceContainer.Name)
+		if _, err := c.mobyClient.ContainerStop(ctx, serviceContainer.ID, mobyclient.ContainerStopOptions{}); err != nil {
+			return fmt.Errorf("unable to stop service %s: %w", serviceContainer.Service, err)
+		}
+	}
+	return nil
+}
+
+// composerServiceContainersInOrder returns the containers of the named
+// services (see ComposerServiceContainers), ordered so the ones of
+// each service come after those of the services it depends on.
+//
+// It fails if one of the named services doesn't have any.
+func (c *Client) composerServiceContainersInOrder(ctx context.Context, services []string) ([]ComposerServiceContainer, error) {
+	containers, err := c.ComposerServiceContainers(ctx, services...)
+	if err != nil {
+		return nil, err
+	}
+	for _, serviceName := range services {
+		if !slices.ContainsFunc(containers, func(s ComposerServiceContainer) bool { return s.Service == serviceName }) {
+			return nil, fmt.Errorf("service %s has no containers; is the Composer project up?", serviceName)
+		}
+	}
+
+	var serviceNames []string
+	for _, serviceContainer := range containers {
+		if !slices.Contains(serviceNames, serviceContainer.Service) {
+			serviceNames = append(serviceNames, serviceContainer.Service)
+		}
+	}
+	order, err := c.servicesInStartOrder(serviceNames)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(containers, func(a, b ComposerServiceContainer) int {
+		return cmp.Compare(slices.Index(order, a.Service), slices.Index(order, b.Service))
+	})
+	return containers, nil
+}
+
+// servicesInStartOrder returns serviceNames ordered so that each comes
+// after the ones among them it depends on, directly or not; services
+// that don't depend on each other are kept in alphabetical order.
+func (c *Client) servicesInStartOrder(serviceNames []string) ([]string, error) {
+	if c.servicesDAG == nil {
+		return nil, fmt.Errorf("no Composer project has been loaded")
+	}
+	remaining := slices.Sorted(slices.Values(serviceNames))
+	ordered := make([]string, 0, len(remaining))
+	for len(remaining) > 0 {
+		next := -1
+		for idx, serviceName := range remaining {
+			ancestors, err := c.servicesDAG.GetAncestors(serviceName)
+			if err != nil {
+				return nil, err
+			}
+			if !slices.ContainsFunc(remaining, func(other string) bool { _, ok := ancestors[other]; return ok }) {
+				next = idx
+				break
+			}
+		}
+		// The DAG has no cycles, so one of them is always ready
+		ordered = append(ordered, remaining[next])
+		remaining = slices.Delete(remaining, next, next+1)
+	}
+	return ordered, nil
+}
+
+// ComposerServiceLogs writes out what the containers of the named
+// services of the loaded Composer project (of every service, if none
+// are named) have printed to stdout and stderr.
+//
+// If there's more than one container, each line is prefixed with the
+// name of the container it came from.
+func (c *Client) ComposerServiceLogs(ctx context.Context, opts ComposerLogsOptions, stdout io.Writer, stderr io.Writer, services ...string) error {
+	containers, err := c.ComposerServiceContainers(ctx, services...)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errChan := make(chan error, len(containers))
+	var outputMu sync.Mutex
+	for _, serviceContainer := range containers {
+		containerStdout, containerStderr := stdout, stderr
+		if len(containers) > 1 {
+			prefix := serviceContainer.Name + " | "
+			containerStdout = &lockedWriter{mu: &outputMu, w: NewStreamWriter(stdout, prefix)}
+			containerStderr = &lockedWriter{mu: &outputMu, w: NewStreamWriter(stderr, prefix)}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errChan <- c.containerLogs(ctx, serviceContainer, opts, containerStdout, containerStderr)
+		}()
+	}
+	wg.Wait()
+	close(errChan)
+
+	for err := range errChan {
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// containerLogs writes out the logs of serviceContainer to stdout and
+// stderr.
+func (c *Client) containerLogs(ctx context.Context, serviceContainer ComposerServiceContainer, opts ComposerLogsOptions, stdout io.Writer, stderr io.Writer) error {
+	logs, err := c.mobyClient.ContainerLogs(ctx, serviceContainer.ID, mobyclient.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+		Timestamps: opts.Timestamps,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read the logs of %s: %w", serviceContainer.Name, err)
+	}
+	defer func() { _ = logs.Close() }()
+	if serviceContainer.Tty {
+		_, err = io.Copy(stdout, logs)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, logs)
+	}
+	return err
+}
+
+// lockedWriter serializes writes to w with mu, which is shared with
+// other writers to the same destination.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+// Write implements the io.Writer interface for lockedWriter
+func (lw *lockedWriter) Write(data []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(data)
+}
@@ -0,0 +1,176 @@
+package trill
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/network"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// newServicesClient returns a client with a Composer project named
+// "svc" loaded, whose app service depends on api, which depends on
+// db, along with their containers; worker has two replicas, and
+// there's a container of another project's db.
+func newServicesClient(t *testing.T, engine *testutil.FakeEngine) *Client {
+	t.Helper()
+	ctx := context.Background()
+	engine.AddImage("alpine", nil)
+	c := newFakeClient(t, engine)
+	c.composerProject = &composetypes.Project{Name: "svc", Services: composetypes.Services{
+		{Name: "app", DependsOn: dependsOn(true, false, "api")},
+		{Name: "api", DependsOn: dependsOn(true, false, "db")},
+		{Name: "db"},
+		{Name: "worker"},
+	}}
+	var err error
+	c.servicesDAG, err = c.buildServicesDAG()
+	assert.Nil(t, err)
+
+	create := func(project string, serviceName string, replica string, running bool) {
+		labels := map[string]string{ComposeProjectLabel: project, ComposeServiceLabel: serviceName, ComposeReplicaLabel: replica}
+		name := project + "--" + serviceName
+		if replica != "1" {
+			name += "--" + replica
+		}
+		created, err := engine.ContainerCreate(ctx, mobyclient.ContainerCreateOptions{
+			Name:   name,
+			Config: &container.Config{Image: "alpine", Labels: labels},
+			HostConfig: &container.HostConfig{PortBindings: network.PortMap{
+				network.MustParsePort("8080/tcp"): {{HostIP: netip.MustParseAddr("127.0.0.1"), HostPort: "18080"}},
+			}},
+		})
+		assert.Nil(t, err)
+		if running {
+			_, err = engine.ContainerStart(ctx, created.ID, mobyclient.ContainerStartOptions{})
+			assert.Nil(t, err)
+		}
+	}
+	create("svc", "app", "1", true)
+	create("svc", "api", "1", true)
+	create("svc", "db", "1", true)
+	create("svc", "worker", "2", false)
+	create("svc", "worker", "1", true)
+	create("other", "db", "1", true)
+	return c
+}
+
+// TestComposerServiceContainers checks that a project's containers
+// are found by their labels, every replica included, and that their
+// state and ports are reported.
+func TestComposerServiceContainers(t *testing.T) {
+	engine := testutil.NewFakeEngine()
+	c := newServicesClient(t, engine)
+
+	containers, err := c.ComposerServiceContainers(context.Background())
+	assert.Nil(t, err)
+	var names []string
+	for _, serviceContainer := range containers {
+		names = append(names, serviceContainer.Name)
+	}
+	assert.Equal(t, []string{"svc--api", "svc--app", "svc--db", "svc--worker", "svc--worker--2"}, names)
+	assert.Equal(t, "running", containers[0].State)
+	assert.Equal(t, []string{"127.0.0.1:18080->8080/tcp"}, containers[0].Ports)
+	assert.Equal(t, 2, containers[4].Replica)
+	assert.Equal(t, "created", containers[4].State)
+	assert.Empty(t, containers[4].Ports)
+
+	containers, err = c.ComposerServiceContainers(context.Background(), "worker")
+	assert.Nil(t, err)
+	assert.Len(t, containers, 2)
+
+	_, err = c.ComposerServiceContainers(context.Background(), "missing")
+	assert.NotNil(t, err)
+}
+
+// TestStartStopComposerServices checks that services are stopped
+// before the ones they depend on, and started after them.
+func TestStartStopComposerServices(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	c := newServicesClient(t, engine)
+	targets := func(method string) []string {
+		var names []string
+		for _, call := range engine.CallsTo(method) {
+			ctr, _ := engine.Container(call.Target)
+			names = append(names, strings.TrimPrefix(ctr.Name, "/"))
+		}
+		return names
+	}
+
+	assert.Nil(t, c.StopComposerServices(context.Background(), "db", "app"))
+	assert.Equal(t, []string{"svc--app", "svc--db"}, targets("ContainerStop"))
+	other, _ := engine.Container("other--db")
+	assert.True(t, other.State.Running)
+
+	before := len(engine.CallsTo("ContainerStart"))
+	assert.Nil(t, c.StartComposerServices(context.Background()))
+	assert.Equal(t, []string{"svc--db", "svc--api", "svc--app", "svc--worker", "svc--worker--2"}, targets("ContainerStart")[before:])
+
+	engine = testutil.NewFakeEngine()
+	c = newFakeClient(t, engine)
+	c.composerProject = &composetypes.Project{Name: "svc", Services: composetypes.Services{{Name: "db"}}}
+	c.servicesDAG, _ = c.buildServicesDAG()
+	assert.ErrorContains(t, c.StartComposerServices(context.Background(), "db"), "no containers")
+}
+
+// TestComposerServiceLogs checks that logs are demultiplexed, and
+// that lines are prefixed with the container they're from when there
+// are several.
+func TestComposerServiceLogs(t *testing.T) {
+	engine := testutil.NewFakeEngine()
+	engine.OutputHandler = func(containerID string, cmd []string) testutil.ExecResult {
+		ctr, _ := engine.Container(containerID)
+		return testutil.ExecResult{Stdout: "hello from " + ctr.Config.Labels[ComposeServiceLabel] + "\n", Stderr: "oops\n"}
+	}
+	c := newServicesClient(t, engine)
+
+	var stdout, stderr bytes.Buffer
+	opts := ComposerLogsOptions{Tail: "10"}
+	assert.Nil(t, c.ComposerServiceLogs(context.Background(), opts, &stdout, &stderr, "db"))
+	assert.Equal(t, "hello from db\n", stdout.String())
+	assert.Equal(t, "oops\n", stderr.String())
+	if calls := engine.CallsTo("ContainerLogs"); assert.Len(t, calls, 1) {
+		assert.Equal(t, "10", calls[0].Options.(mobyclient.ContainerLogsOptions).Tail)
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	assert.Nil(t, c.ComposerServiceLogs(context.Background(), opts, &stdout, &stderr, "worker"))
+	assert.Contains(t, stdout.String(), "svc--worker | hello from worker\n")
+	assert.Contains(t, stdout.String(), "svc--worker--2 | hello from worker\n")
+	assert.Equal(t, 2, strings.Count(stderr.String(), "| oops\n"))
+}
+
+// TestLoadComposerProject checks that a Composer project can be
+// loaded without deploying it.
+func TestLoadComposerProject(t *testing.T) {
+	t.Chdir(filepath.Join("testdata", "compose", "deploy"))
+	p, err := writ.NewDevcontainerParser("devcontainer.json")
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+
+	engine := testutil.NewFakeEngine()
+	c := newFakeClient(t, engine)
+	assert.Nil(t, c.LoadComposerProject(context.Background(), p, ComposeOptions{ProjectName: "deploy", Scale: map[string]int{"db": 2}}))
+	assert.Equal(t, "deploy", c.composerProject.Name)
+	assert.Equal(t, []string{"deploy--db", "deploy--db--2"}, c.composerContainerNames("db"))
+	order, err := c.servicesInStartOrder([]string{"app", "db"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"db", "app"}, order)
+	assert.Empty(t, engine.CallsTo("ContainerCreate"))
+}
@@ -0,0 +1,61 @@
+//go:build !windows
+
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"io"
+	"log/slog"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// switchTerminalToRaw attempts to switch the current terminal to raw
+// mode.
+//
+// If no errors are encountered, returns a function that restores the
+// previous state of the terminal.
+//
+// Switching the terminal to raw mode ensures that input with
+// control characters (e.g., Ctrl-D) get passed through to the
+// container
+func (c *Client) switchTerminalToRaw() (func(), error) {
+	slog.Debug("switching terminal to raw mode")
+	fd := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		slog.Error("encountered an error while trying to switch terminal to raw mode", "error", err)
+		return nil, err
+	}
+
+	return func() {
+		slog.Debug("restoring terminal state")
+		if err := term.Restore(fd, oldState); err != nil {
+			slog.Error("encountered an error while trying to restore terminal state", "error", err)
+			panic(err)
+		}
+	}, nil
+}
+
+// terminalInput returns what the host terminal's input is read from
+// while it's attached to the devcontainer.
+func terminalInput() io.Reader {
+	return os.Stdin
+}
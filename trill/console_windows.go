@@ -0,0 +1,181 @@
+//go:build windows
+
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// vkMenu is the virtual-key code of the Alt key, whose release
+// delivers characters entered with Alt and the numeric keypad.
+const vkMenu = 0x12
+
+// procReadConsoleInputW reads raw console input records, which
+// golang.org/x/sys/windows doesn't wrap.
+var procReadConsoleInputW = windows.NewLazySystemDLL("kernel32.dll").NewProc("ReadConsoleInputW")
+
+// consoleResized is signalled by consoleInput whenever the console
+// reports its window has been resized; see listenForTerminalResize.
+var consoleResized = make(chan struct{}, 1)
+
+// inputRecord mirrors the console API's INPUT_RECORD.
+type inputRecord struct {
+	eventType uint16
+	_         uint16
+	event     [16]byte
+}
+
+// keyEventRecord mirrors the console API's KEY_EVENT_RECORD, the event
+// an inputRecord holds when its eventType is KEY_EVENT.
+type keyEventRecord struct {
+	keyDown         int32
+	repeatCount     uint16
+	virtualKeyCode  uint16
+	virtualScanCode uint16
+	unicodeChar     uint16
+	controlKeyState uint32
+}
+
+// consoleInput reads the host console's input records, passing on the
+// characters typed as UTF-8, and signalling consoleResized when the
+// window's resized.
+//
+// With ENABLE_VIRTUAL_TERMINAL_INPUT set, keys without characters of
+// their own (e.g., the arrow keys) are delivered as the VT sequences
+// the shell in the devcontainer expects.
+type consoleInput struct {
+	handle    windows.Handle
+	records   [64]inputRecord
+	pending   []byte // Characters read but not yet passed on
+	surrogate uint16 // The first half of a surrogate pair, awaiting the second
+}
+
+// Read implements io.Reader.
+func (ci *consoleInput) Read(p []byte) (int, error) {
+	for len(ci.pending) == 0 {
+		var read uint32
+		r1, _, err := procReadConsoleInputW.Call(uintptr(ci.handle), uintptr(unsafe.Pointer(&ci.records[0])), uintptr(len(ci.records)), uintptr(unsafe.Pointer(&read)))
+		if r1 == 0 {
+			return 0, err
+		}
+		for i := range ci.records[:read] {
+			switch ci.records[i].eventType {
+			case windows.WINDOW_BUFFER_SIZE_EVENT:
+				select {
+				case consoleResized <- struct{}{}:
+				default:
+				}
+			case windows.KEY_EVENT:
+				key := (*keyEventRecord)(unsafe.Pointer(&ci.records[i].event))
+				if key.unicodeChar == 0 || (key.keyDown == 0 && key.virtualKeyCode != vkMenu) {
+					continue
+				}
+				for range max(key.repeatCount, 1) {
+					ci.appendChar(key.unicodeChar)
+				}
+			}
+		}
+	}
+	n := copy(p, ci.pending)
+	ci.pending = ci.pending[n:]
+	return n, nil
+}
+
+// appendChar adds the UTF-16 code unit c to what's to be passed on,
+// once it makes up a whole character.
+func (ci *consoleInput) appendChar(c uint16) {
+	switch {
+	case ci.surrogate != 0:
+		ci.pending = utf8.AppendRune(ci.pending, utf16.DecodeRune(rune(ci.surrogate), rune(c)))
+		ci.surrogate = 0
+	case utf16.IsSurrogate(rune(c)):
+		ci.surrogate = c
+	default:
+		ci.pending = utf8.AppendRune(ci.pending, rune(c))
+	}
+}
+
+// terminalInput returns what the host terminal's input is read from
+// while it's attached to the devcontainer: the console's input
+// records, if stdin is a console.
+func terminalInput() io.Reader {
+	handle := windows.Handle(os.Stdin.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return os.Stdin
+	}
+	return &consoleInput{handle: handle}
+}
+
+// switchTerminalToRaw attempts to switch the host console to raw
+// mode, with VT sequences enabled both ways.
+//
+// If no errors are encountered, returns a function that restores the
+// previous state of the console.
+//
+// Input with control characters (e.g., Ctrl-C) gets passed through to
+// the container instead of being handled by the console, keys are
+// delivered as VT sequences, and window resizes are reported (see
+// consoleInput). The VT sequences the container writes are
+// interpreted rather than printed out.
+func (c *Client) switchTerminalToRaw() (func(), error) {
+	slog.Debug("switching console to raw mode")
+	stdin := windows.Handle(os.Stdin.Fd())
+	stdout := windows.Handle(os.Stdout.Fd())
+	var inMode, outMode uint32
+	if err := windows.GetConsoleMode(stdin, &inMode); err != nil {
+		slog.Error("encountered an error while trying to get the console's input mode", "error", err)
+		return nil, err
+	}
+	if err := windows.GetConsoleMode(stdout, &outMode); err != nil {
+		slog.Error("encountered an error while trying to get the console's output mode", "error", err)
+		return nil, err
+	}
+
+	rawIn := inMode&^(windows.ENABLE_ECHO_INPUT|windows.ENABLE_PROCESSED_INPUT|windows.ENABLE_LINE_INPUT) | windows.ENABLE_VIRTUAL_TERMINAL_INPUT | windows.ENABLE_WINDOW_INPUT
+	if err := windows.SetConsoleMode(stdin, rawIn); err != nil {
+		slog.Error("encountered an error while trying to switch the console's input to raw mode", "error", err)
+		return nil, err
+	}
+	rawOut := outMode | windows.ENABLE_PROCESSED_OUTPUT | windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING
+	if err := windows.SetConsoleMode(stdout, rawOut|windows.DISABLE_NEWLINE_AUTO_RETURN); err != nil {
+		// Consoles predating DISABLE_NEWLINE_AUTO_RETURN reject it
+		if err = windows.SetConsoleMode(stdout, rawOut); err != nil {
+			slog.Warn("unable to enable VT processing on the console; output may be garbled", "error", err)
+		}
+	}
+
+	return func() {
+		slog.Debug("restoring console state")
+		if err := windows.SetConsoleMode(stdin, inMode); err != nil {
+			slog.Error("encountered an error while trying to restore the console's input mode", "error", err)
+		}
+		if err := windows.SetConsoleMode(stdout, outMode); err != nil {
+			slog.Error("encountered an error while trying to restore the console's output mode", "error", err)
+		}
+	}, nil
+}
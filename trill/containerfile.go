@@ -0,0 +1,210 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/moby/buildkit/frontend/dockerfile/shell"
+	"github.com/nlsantos/brig/writ"
+)
+
+// ContainerfileInfo is what can be told about the image a
+// Containerfile builds without building it.
+type ContainerfileInfo struct {
+	BaseImage string // The image the target stage is ultimately based on, with build arguments substituted
+	Stage     string // The name of the target stage, if it has one
+	User      string // The user the target stage ends with; empty if the Containerfile leaves it to BaseImage
+}
+
+// containerfileStage tracks the state of a stage while a
+// Containerfile is being walked through.
+type containerfileStage struct {
+	ContainerfileInfo
+	args map[string]string // Values of the ARGs in scope
+	env  map[string]string // Values set with ENV, which later stages based on this one inherit
+}
+
+// vars returns the variables in scope in the stage; ENV values take
+// precedence over ARG values.
+func (s *containerfileStage) vars() map[string]string {
+	vars := maps.Clone(s.args)
+	maps.Copy(vars, s.env)
+	return vars
+}
+
+// ReadContainerfile parses the Containerfile at path; see
+// ParseContainerfile.
+func ReadContainerfile(path string, buildArgs map[string]*string, target string) (*ContainerfileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseContainerfile(f, buildArgs, target)
+}
+
+// ReadDevcontainerContainerfile parses the Containerfile referenced by
+// a devcontainer.json, taking its build arguments and target stage
+// into account; see ParseContainerfile.
+func ReadDevcontainerContainerfile(p *writ.DevcontainerParser) (*ContainerfileInfo, error) {
+	if p.Config.DockerFile == nil || len(*p.Config.DockerFile) == 0 {
+		return nil, fmt.Errorf("devcontainer.json does not reference a Containerfile")
+	}
+	buildArgs, target := devcontainerBuildArgs(p)
+	return ReadContainerfile(filepath.Join(*p.Config.Context, filepath.FromSlash(*p.Config.DockerFile)), buildArgs, target)
+}
+
+// devcontainerBuildArgs returns the build arguments and the target
+// stage set in a devcontainer.json's build property, if any.
+//
+// The spec's variables have already been substituted in the
+// arguments' values by the time they're parsed.
+func devcontainerBuildArgs(p *writ.DevcontainerParser) (buildArgs map[string]*string, target string) {
+	if p.Config.Build == nil {
+		return nil, ""
+	}
+	buildArgs = make(map[string]*string, len(p.Config.Build.Args))
+	for name, val := range p.Config.Build.Args {
+		buildArgs[name] = &val
+	}
+	if p.Config.Build.Target != nil {
+		target = *p.Config.Build.Target
+	}
+	return buildArgs, target
+}
+
+// ParseContainerfile determines the base image and the effective user
+// of the stage target of the Containerfile read from r (the last stage
+// if target is empty), with buildArgs overriding the defaults of its
+// ARG instructions.
+//
+// Stages based on earlier stages inherit their base image and user.
+// Only ARG, ENV, FROM, and USER instructions are looked at; variables
+// set in any other way (e.g., by the base image) aren't known, and
+// are substituted as empty strings.
+func ParseContainerfile(r io.Reader, buildArgs map[string]*string, target string) (*ContainerfileInfo, error) {
+	result, err := parser.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	lex := shell.NewLex(result.EscapeToken)
+	expand := func(word string, env map[string]string) (string, error) {
+		envSlice := make([]string, 0, len(env))
+		for key, val := range env {
+			envSlice = append(envSlice, key+"="+val)
+		}
+		expanded, _, err := lex.ProcessWord(word, shell.EnvsFromSlice(envSlice))
+		return expanded, err
+	}
+	// declareArgs handles ARG instructions, which declare one or more
+	// variables, optionally with a default
+	declareArgs := func(node *parser.Node, args map[string]string, vars map[string]string, metaArgs map[string]string) error {
+		for arg := node.Next; arg != nil; arg = arg.Next {
+			name, defVal, hasDefault := strings.Cut(arg.Value, "=")
+			switch {
+			case buildArgs[name] != nil:
+				args[name] = *buildArgs[name]
+			case hasDefault:
+				expanded, err := expand(defVal, vars)
+				if err != nil {
+					return err
+				}
+				args[name] = expanded
+			default:
+				// Re-declaring a global ARG brings it into the stage
+				if val, ok := metaArgs[name]; ok {
+					args[name] = val
+				}
+			}
+			vars[name] = args[name]
+		}
+		return nil
+	}
+
+	metaArgs := make(map[string]string)
+	var stages []*containerfileStage
+	var current *containerfileStage
+	for _, node := range result.AST.Children {
+		switch strings.ToLower(node.Value) {
+		case "arg":
+			if current == nil {
+				err = declareArgs(node, metaArgs, metaArgs, nil)
+			} else {
+				err = declareArgs(node, current.args, current.vars(), metaArgs)
+			}
+
+		case "from":
+			if current != nil && len(target) > 0 && strings.EqualFold(current.Stage, target) {
+				return &current.ContainerfileInfo, nil
+			}
+			if node.Next == nil {
+				return nil, fmt.Errorf("line %d: FROM without an image", node.StartLine)
+			}
+			current = &containerfileStage{args: make(map[string]string), env: make(map[string]string)}
+			if current.BaseImage, err = expand(node.Next.Value, metaArgs); err != nil {
+				break
+			}
+			if node.Next.Next != nil && strings.EqualFold(node.Next.Next.Value, "as") && node.Next.Next.Next != nil {
+				current.Stage = node.Next.Next.Next.Value
+			}
+			for _, stage := range stages {
+				if strings.EqualFold(stage.Stage, current.BaseImage) {
+					current.BaseImage, current.User = stage.BaseImage, stage.User
+					current.env = maps.Clone(stage.env)
+				}
+			}
+			stages = append(stages, current)
+
+		case "env":
+			if current == nil {
+				break
+			}
+			// Each variable is a triplet of nodes: its name, its
+			// value, and the separator between the two
+			for key := node.Next; key != nil && key.Next != nil && err == nil; {
+				current.env[key.Value], err = expand(key.Next.Value, current.vars())
+				if key = key.Next.Next; key != nil {
+					key = key.Next
+				}
+			}
+
+		case "user":
+			if current != nil && node.Next != nil {
+				current.User, err = expand(node.Next.Value, current.vars())
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", node.StartLine, err)
+		}
+	}
+
+	switch {
+	case current == nil:
+		return nil, fmt.Errorf("no FROM instruction found")
+	case len(target) > 0 && !strings.EqualFold(current.Stage, target):
+		return nil, fmt.Errorf("target stage not found: %s", target)
+	}
+	return &current.ContainerfileInfo, nil
+}
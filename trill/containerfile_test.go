@@ -0,0 +1,98 @@
+package trill
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	imagespec "github.com/moby/docker-image-spec/specs-go/v1"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/writ"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseContainerfile checks that the base image and user of the
+// target stage are determined, following stages based on other stages
+// and substituting build arguments.
+func TestParseContainerfile(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	containerfile := strings.Join([]string{
+		"ARG VARIANT=bookworm",
+		"ARG REGISTRY",
+		"FROM ${REGISTRY:-docker.io}/library/debian:${VARIANT} AS base",
+		"ARG USERNAME=dev",
+		"ENV HOME_USER=${USERNAME}",
+		"USER ${HOME_USER}",
+		"",
+		"FROM base AS tools",
+		"RUN echo building tools",
+		"",
+		"FROM --platform=linux/amd64 alpine:3 AS runner",
+		"USER 1000:1000",
+		"",
+		"FROM tools",
+		"RUN echo done",
+	}, "\n")
+
+	info, err := ParseContainerfile(strings.NewReader(containerfile), nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, &ContainerfileInfo{BaseImage: "docker.io/library/debian:bookworm", User: "dev"}, info)
+
+	variant, registry := "trixie", "mirror.example.com"
+	info, err = ParseContainerfile(strings.NewReader(containerfile), map[string]*string{"VARIANT": &variant, "REGISTRY": &registry}, "base")
+	assert.Nil(t, err)
+	assert.Equal(t, &ContainerfileInfo{BaseImage: "mirror.example.com/library/debian:trixie", Stage: "base", User: "dev"}, info)
+
+	info, err = ParseContainerfile(strings.NewReader(containerfile), nil, "RUNNER")
+	assert.Nil(t, err)
+	assert.Equal(t, &ContainerfileInfo{BaseImage: "alpine:3", Stage: "runner", User: "1000:1000"}, info)
+
+	_, err = ParseContainerfile(strings.NewReader(containerfile), nil, "missing")
+	assert.NotNil(t, err)
+	_, err = ParseContainerfile(strings.NewReader("# just a comment\n"), nil, "")
+	assert.NotNil(t, err)
+
+	// A Containerfile that doesn't set a user leaves it to the image
+	path := filepath.Join(t.TempDir(), "Containerfile")
+	assert.Nil(t, os.WriteFile(path, []byte("FROM mcr.microsoft.com/devcontainers/base:ubuntu\nENV FOO=bar\n"), 0o644))
+	info, err = ReadContainerfile(path, nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, &ContainerfileInfo{BaseImage: "mcr.microsoft.com/devcontainers/base:ubuntu"}, info)
+}
+
+// TestSetContainerAndRemoteUserFromContainerfile checks that the
+// users are taken from the Containerfile, or the image it's based on,
+// when the built image can't be inspected.
+func TestSetContainerAndRemoteUserFromContainerfile(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("docker.io/library/debian:bookworm", &imagespec.DockerOCIImageConfig{ImageConfig: ocispec.ImageConfig{User: "vscode"}})
+	c := newFakeClient(t, engine)
+	ctx := context.Background()
+
+	ctxDir := t.TempDir()
+	dockerFile := "Containerfile"
+	p := &writ.DevcontainerParser{}
+	p.Config.Context = &ctxDir
+	p.Config.DockerFile = &dockerFile
+	p.Config.Build = &writ.BuildOptions{Args: map[string]string{"VARIANT": "bookworm"}}
+
+	assert.Nil(t, os.WriteFile(filepath.Join(ctxDir, dockerFile), []byte("ARG VARIANT=trixie\nFROM docker.io/library/debian:${VARIANT}\n"), 0o644))
+	assert.Nil(t, c.setContainerAndRemoteUser(ctx, p, "missing:latest"))
+	assert.Equal(t, "vscode", *p.Config.ContainerUser)
+	assert.Equal(t, "vscode", *p.Config.RemoteUser)
+
+	p.Config.ContainerUser, p.Config.RemoteUser = nil, nil
+	assert.Nil(t, os.WriteFile(filepath.Join(ctxDir, dockerFile), []byte("FROM docker.io/library/debian:bookworm\nUSER builder\n"), 0o644))
+	assert.Nil(t, c.setContainerAndRemoteUser(ctx, p, "missing:latest"))
+	assert.Equal(t, "builder", *p.Config.ContainerUser)
+}
@@ -0,0 +1,966 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/matoous/go-nanoid/v2"
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/mount"
+	"github.com/moby/moby/api/types/network"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/writ"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/term"
+)
+
+// ExecOptions holds the settings for running a command in a
+// container.
+type ExecOptions struct {
+	User       string          // The user to run the command as; defaults to the container's
+	Env        *writ.EnvVarMap // Extra environment variables for the command
+	RunInShell bool            // If true, the command is run via `/bin/sh -c`; otherwise, its first argument is the program name
+	WorkingDir string          // The directory to run the command in; defaults to the container's
+	Stdout     io.Writer       // Receives the command's stdout as it's produced; discarded if nil
+	Stderr     io.Writer       // Receives the command's stderr as it's produced; discarded if nil
+}
+
+// ExecInDevcontainer runs a command inside the designated
+// devcontainer (i.e., the lone container in non-Composer
+// configurations, or the one named in the service field otherwise).
+func (c *Client) ExecInDevcontainer(ctx context.Context, opts ExecOptions, args ...string) (bytes.Buffer, bytes.Buffer, error) {
+	return c.ExecInContainer(ctx, c.ContainerID, opts, args...)
+}
+
+// ExecInContainer runs a command inside a container designated by
+// containerID, collecting its output.
+//
+// opts.Stdout and opts.Stderr are ignored; the command's output is
+// returned instead.
+func (c *Client) ExecInContainer(ctx context.Context, containerID string, opts ExecOptions, args ...string) (cmdStdout bytes.Buffer, cmdStderr bytes.Buffer, err error) {
+	opts.Stdout, opts.Stderr = &cmdStdout, &cmdStderr
+	err = c.StreamExecInContainer(ctx, containerID, opts, args...)
+	slog.Debug("command output", "args", args, "stdout", cmdStdout.String(), "stderr", cmdStderr.String())
+	return cmdStdout, cmdStderr, err
+}
+
+// StreamExecInDevcontainer is like ExecInDevcontainer, but writes the
+// command's output to opts.Stdout and opts.Stderr as it's produced
+// instead of collecting it.
+func (c *Client) StreamExecInDevcontainer(ctx context.Context, opts ExecOptions, args ...string) error {
+	return c.StreamExecInContainer(ctx, c.ContainerID, opts, args...)
+}
+
+// StreamExecInContainer is like ExecInContainer, but writes the
+// command's output to opts.Stdout and opts.Stderr as it's produced
+// instead of collecting it.
+//
+// Returns an *ExecExitError if the command exits with a non-zero
+// code.
+func (c *Client) StreamExecInContainer(ctx context.Context, containerID string, opts ExecOptions, args ...string) error {
+	stdout, stderr := opts.Stdout, opts.Stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	if opts.RunInShell {
+		shellCmd := []string{"/bin/sh", "-c"}
+		args = append(shellCmd, args...)
+	}
+	cmd := strings.Join(args, " ")
+	slog.Info("running command in container", "container", containerID, "cmd", cmd)
+
+	execCreateOpts := mobyclient.ExecCreateOptions{
+		User:         opts.User,
+		TTY:          false,
+		AttachStderr: true,
+		AttachStdout: true,
+		Cmd:          args,
+		WorkingDir:   opts.WorkingDir,
+	}
+	if opts.Env != nil && len(*opts.Env) > 0 {
+		for name, val := range *opts.Env {
+			execCreateOpts.Env = append(execCreateOpts.Env, fmt.Sprintf("%s=%s", name, val))
+		}
+	}
+	slog.Debug("creating execution context", "container", containerID, "opts", execCreateOpts)
+	execCreateRes, err := c.mobyClient.ExecCreate(ctx, containerID, execCreateOpts)
+	if err != nil {
+		slog.Error("encountered error while preparing execution context", "error", err)
+		return err
+	}
+	slog.Debug("executing command", "container", containerID, "context", execCreateRes.ID)
+	execAttachRes, err := c.mobyClient.ExecAttach(ctx, execCreateRes.ID, mobyclient.ExecAttachOptions{})
+	if err != nil {
+		slog.Error("encountered error while executing the command", "error", err)
+		return err
+	}
+	defer execAttachRes.Close()
+
+	if _, err = stdcopy.StdCopy(stdout, stderr, execAttachRes.Reader); err != nil {
+		slog.Error("could not demultiplex output from command", "cmd", cmd, "error", err)
+		return err
+	}
+
+	// The exit code is only meaningful once the command is done, i.e.,
+	// once its output has been drained
+	execInspectRes, err := c.mobyClient.ExecInspect(ctx, execCreateRes.ID, mobyclient.ExecInspectOptions{})
+	if err != nil {
+		slog.Error("encountered error while inspecting execution context", "error", err)
+		return err
+	}
+	if execInspectRes.ExitCode != 0 {
+		slog.Error("command ran in container returned non-zero", "exit-code", execInspectRes.ExitCode, "cmd", cmd)
+		return &ExecExitError{ExitCode: execInspectRes.ExitCode}
+	}
+
+	return nil
+}
+
+// execInTempContainer spins up a container based on containerCfg and
+// hostCfg then runs the specified command in it, returning the stdout
+// and stderr (if applicable).
+func (c *Client) execInTempContainer(ctx context.Context, containerCfg *container.Config, hostCfg *container.HostConfig, env *writ.EnvVarMap, args ...string) (cmdStdout bytes.Buffer, cmdStderr bytes.Buffer, err error) {
+	singleExecArg := [][]string{args}
+	cmdSO, cmdSE, err := c.multiExecInTempContainer(ctx, containerCfg, hostCfg, env, singleExecArg)
+	if err == nil {
+		if len(cmdSO) > 0 {
+			cmdStdout = cmdSO[0]
+		}
+		if len(cmdSE) > 0 {
+			cmdStderr = cmdSE[0]
+		}
+	}
+	return cmdStdout, cmdStderr, err
+}
+
+// multiExecInTempContainer spins up a container based on containerCfg
+// and hostCfg then runs the list of commands specified in args in the
+// spun up container, returning their stdout and stderr in the same
+// order (if applicable).
+func (c *Client) multiExecInTempContainer(ctx context.Context, containerCfg *container.Config, hostCfg *container.HostConfig, env *writ.EnvVarMap, args [][]string) (cmdStdout []bytes.Buffer, cmdStderr []bytes.Buffer, err error) {
+	tempContainerName, err := gonanoid.New(16)
+	if err != nil {
+		slog.Error("encountered an error while trying to generate a name for a temporary container", "error", err)
+		return cmdStdout, cmdStderr, err
+	}
+	tempContainerID, err := c.startContainer(ctx, nil, containerCfg, hostCfg, nil, ContainerName("", "tmp", tempContainerName), false)
+	if err != nil {
+		slog.Error("encountered an error while spinning up a temporary container", "error", err)
+		return cmdStdout, cmdStderr, err
+	}
+	defer func() {
+		if tempContainerID != "" {
+			// Clean up even if ctx has been cancelled
+			c.StopContainer(context.WithoutCancel(ctx), tempContainerID)
+		}
+	}()
+
+	execOpts := ExecOptions{User: containerCfg.User, Env: env, RunInShell: true}
+	for _, arg := range args {
+		var cmdSO, cmdSE bytes.Buffer
+		if cmdSO, cmdSE, err = c.ExecInContainer(ctx, tempContainerID, execOpts, arg...); err != nil {
+			break
+		}
+		cmdStdout = append(cmdStdout, cmdSO)
+		cmdStderr = append(cmdStderr, cmdSE)
+	}
+
+	return cmdStdout, cmdStderr, err
+}
+
+// StartDevcontainerContainer starts and attaches to a container based
+// on configuration from devcontainer.json.
+//
+// Requires metadata parsed from a devcontainer.json config, the
+// tag/image name for the OCI image to use as base, and a name for the
+// created container.
+func (c *Client) StartDevcontainerContainer(ctx context.Context, p *writ.DevcontainerParser, imageTag string, containerName string) (err error) {
+	slog.Debug("attempting to start and attach to devcontainer", "tag", imageTag, "name", containerName)
+	if c.CloneWorkspaceInVolume {
+		if err = c.prepareWorkspaceVolume(ctx, p, imageTag, containerName); err != nil {
+			return err
+		}
+	}
+	containerCfg := c.buildContainerConfig(p, imageTag)
+	hostCfg := c.buildHostConfig(p)
+
+	// TODO: Respect userEnvProbe
+	if p.EnvProbeNeeded && (len(p.Config.ContainerEnv) > 0 || len(p.Config.RemoteEnv) > 0) {
+		// The probes run as the container and remote users, so they
+		// have to be known beforehand
+		if err = c.setContainerAndRemoteUser(ctx, p, containerCfg.Image); err != nil {
+			slog.Error("encountered an error while attempting to determine container/remote user", "image", containerCfg.Image, "error", err)
+			return err
+		}
+		containerEnv, err := c.probeEnv(ctx, containerCfg, hostCfg, *p.Config.ContainerUser)
+		if err != nil {
+			return err
+		}
+		remoteEnv := containerEnv
+		if *p.Config.RemoteUser != *p.Config.ContainerUser {
+			if remoteEnv, err = c.probeEnv(ctx, containerCfg, hostCfg, *p.Config.RemoteUser); err != nil {
+				return err
+			}
+		}
+		maps.Copy(p.EnvVarsContainer, containerEnv)
+		maps.Copy(p.EnvVarsRemote, remoteEnv)
+		p.ProcessSubstitutions()
+		// containerEnv is part of the environment remoteEnv and the
+		// lifecycle commands see
+		maps.Copy(containerEnv, p.Config.ContainerEnv)
+		maps.Copy(remoteEnv, p.Config.ContainerEnv)
+		p.ResolveContainerEnv(containerEnv, remoteEnv)
+		containerCfg = c.buildContainerConfig(p, imageTag)
+	}
+
+	_, err = c.startContainer(ctx, p, containerCfg, hostCfg, nil, containerName, true)
+	return err
+}
+
+// probeEnv returns the environment variables available to user in a
+// temporary container based on containerCfg, before anything from
+// devcontainer.json is applied to it.
+func (c *Client) probeEnv(ctx context.Context, containerCfg *container.Config, hostCfg *container.HostConfig, user string) (map[string]string, error) {
+	dupContainerCfg := *containerCfg
+	dupContainerCfg.Env = []string{}
+	dupContainerCfg.User = user
+	cmdStdout, _, err := c.execInTempContainer(ctx, &dupContainerCfg, hostCfg, nil, "export")
+	if err != nil {
+		return nil, err
+	}
+	env := make(map[string]string)
+	lineSep := regexp.MustCompile(`\r?\n|\r`)
+	for _, export := range lineSep.Split(strings.TrimSpace(cmdStdout.String()), -1) {
+		splitExport := strings.SplitN(export, "=", 2)
+		varNameFields := strings.Fields(splitExport[0])
+		if len(splitExport) < 2 || len(varNameFields) < 1 {
+			continue
+		}
+		varName := varNameFields[len(varNameFields)-1]
+		if strings.HasPrefix(varName, "BASH_FUNC__") {
+			continue
+		}
+		env[varName] = strings.Trim(splitExport[1], `'"`)
+	}
+	return env, nil
+}
+
+// startContainer creates a container based on the passed in arguments
+// then starts it.
+//
+// networkingCfg is optional, and specifies the networks the container
+// is attached to on creation.
+func (c *Client) startContainer(ctx context.Context, p *writ.DevcontainerParser, containerCfg *container.Config, hostCfg *container.HostConfig, networkingCfg *network.NetworkingConfig, containerName string, isDevcontainer bool) (containerID string, err error) {
+	if isDevcontainer {
+		if err = c.bindAppPorts(p, containerCfg, hostCfg); err != nil {
+			slog.Error("encountered an error binding appPort items", "error", err)
+			return "", err
+		}
+		service := ""
+		if p.Config.Service != nil {
+			service = *p.Config.Service
+		}
+		if err = c.bindForwardPorts(p, service, containerCfg, hostCfg); err != nil {
+			slog.Error("encountered an error binding forwardPorts items", "error", err)
+			return "", err
+		}
+		c.bindMounts(p, hostCfg)
+
+		if err = c.setContainerAndRemoteUser(ctx, p, containerCfg.Image); err != nil {
+			slog.Error("encountered an error while attempting to determine container/remote user", "image", containerCfg.Image, "error", err)
+			return "", err
+		}
+
+		if err = c.selectUsernsMode(ctx, p, containerCfg, hostCfg); err != nil {
+			slog.Error("encountered an error while picking the user namespace mode", "error", err)
+			return "", err
+		}
+
+		if *p.Config.OverrideCommand {
+			slog.Debug("overriding the container's command to keep it alive")
+			containerCfg.Entrypoint = keepAliveEntrypoint
+			containerCfg.Cmd = nil
+		} else if p.Config.DockerComposeFile == nil {
+			// Without a TTY, what the image's own command writes to
+			// stdout and stderr can be told apart, as with `docker run`;
+			// Composer services keep their own tty setting
+			containerCfg.Tty = false
+		}
+
+		// Lifecycle: initialize
+		if err = c.fireLifecycleEvent(ctx, LifecycleInitialize); err != nil {
+			return "", err
+		}
+	}
+
+	slog.Debug("using container config", "config", containerCfg)
+	slog.Debug("using host config", "config", hostCfg)
+
+	// Only the endpoint for the primary network is passed at creation
+	// time; the rest are connected afterwards, as not every engine
+	// accepts multiple endpoints on create.
+	var createNetworkingCfg *network.NetworkingConfig
+	var extraEndpoints map[string]*network.EndpointSettings
+	if networkingCfg != nil {
+		extraEndpoints = maps.Clone(networkingCfg.EndpointsConfig)
+		createNetworkingCfg = &network.NetworkingConfig{
+			EndpointsConfig: make(map[string]*network.EndpointSettings),
+		}
+		primaryNetwork := string(hostCfg.NetworkMode)
+		if endpointSettings, ok := extraEndpoints[primaryNetwork]; ok {
+			createNetworkingCfg.EndpointsConfig[primaryNetwork] = endpointSettings
+			delete(extraEndpoints, primaryNetwork)
+		}
+	}
+
+	_, endCreateSpan := c.startSpan(ctx, "create container "+containerName)
+	defer func() { endCreateSpan(err) }()
+	createResp, err := c.mobyClient.ContainerCreate(ctx, mobyclient.ContainerCreateOptions{
+		Config:           containerCfg,
+		HostConfig:       hostCfg,
+		NetworkingConfig: createNetworkingCfg,
+		Name:             containerName,
+		Platform:         (*ocispec.Platform)(&c.Platform),
+	})
+	if err != nil {
+		slog.Error("encountered an error creating a container", "error", err)
+		return "", fmt.Errorf("%w %s: %w", ErrContainerStart, containerName, err)
+	}
+	slog.Debug("container created successfully", "id", createResp.ID)
+
+	for _, networkName := range slices.Sorted(maps.Keys(extraEndpoints)) {
+		slog.Debug("connecting container to network", "id", createResp.ID, "network", networkName)
+		if _, err := c.mobyClient.NetworkConnect(ctx, networkName, mobyclient.NetworkConnectOptions{
+			Container:      createResp.ID,
+			EndpointConfig: extraEndpoints[networkName],
+		}); err != nil {
+			slog.Error("encountered an error connecting the container to a network", "id", createResp.ID, "network", networkName, "error", err)
+			return createResp.ID, err
+		}
+	}
+
+	if isDevcontainer {
+		c.ContainerID = createResp.ID
+		c.configureShell(p)
+		if !*p.Config.OverrideCommand && c.CommandStdout != nil {
+			if err := c.streamCommandOutput(ctx, createResp.ID); err != nil {
+				slog.Warn("unable to show the output of the devcontainer's command", "error", err)
+			}
+		}
+	}
+
+	slog.Debug("attempting to start container", "id", createResp.ID)
+	// TODO: Support the container initialization options/operations
+	// exposed by the devcontainer spec
+	if _, err := c.mobyClient.ContainerStart(ctx, createResp.ID, mobyclient.ContainerStartOptions{}); err != nil {
+		slog.Error("encountered an error while trying to start the container", "error", err)
+		return createResp.ID, fmt.Errorf("%w %s: %w", ErrContainerStart, containerName, wrapPortConflict(err))
+	}
+	slog.Debug("container started successfully", "id", createResp.ID)
+	endCreateSpan(nil)
+
+	if isDevcontainer {
+		// The lifecycle commands and remoteEnv can refer to the
+		// container's environment, which is only fully known now
+		// unless it's been probed beforehand
+		if p.EnvProbeNeeded {
+			if err = c.resolveContainerEnv(ctx, p); err != nil {
+				return c.ContainerID, err
+			}
+		}
+
+		// Lifecycle: featureInstall, then the lifecycle hooks; from
+		// postCreateCommand on, they can count on the devcontainer
+		// being healthy
+		for _, event := range []LifecycleEvents{LifecycleFeatureInstall, LifecycleOnCreate, LifecycleUpdate, LifecyclePostCreate, LifecyclePostStart} {
+			if event == LifecyclePostCreate {
+				if err = c.waitForHealthy(ctx, c.ContainerID, containerName); err != nil {
+					return c.ContainerID, err
+				}
+			}
+			if err = c.fireLifecycleEvent(ctx, event); err != nil {
+				return c.ContainerID, err
+			}
+		}
+	}
+
+	return createResp.ID, nil
+}
+
+// InspectContainerEnv returns the environment variables the container
+// designated by containerID was created with, i.e., those set by its
+// image and in its configuration.
+func (c *Client) InspectContainerEnv(ctx context.Context, containerID string) (map[string]string, error) {
+	inspectRes, err := c.mobyClient.ContainerInspect(ctx, containerID, mobyclient.ContainerInspectOptions{})
+	if err != nil {
+		slog.Error("encountered an error while inspecting a container", "container-id", containerID, "error", err)
+		return nil, err
+	}
+	env := make(map[string]string)
+	if inspectRes.Container.Config == nil {
+		return env, nil
+	}
+	for _, envVar := range inspectRes.Container.Config.Env {
+		key, val, _ := strings.Cut(envVar, "=")
+		env[key] = val
+	}
+	return env, nil
+}
+
+// resolveContainerEnv substitutes references to the devcontainer's
+// environment in the parts of its configuration that are used after
+// it starts.
+func (c *Client) resolveContainerEnv(ctx context.Context, p *writ.DevcontainerParser) error {
+	containerEnv, err := c.InspectContainerEnv(ctx, c.ContainerID)
+	if err != nil {
+		return fmt.Errorf("unable to determine the devcontainer's environment: %w", err)
+	}
+	slog.Debug("resolving references to the devcontainer's environment", "vars", len(containerEnv))
+	p.ResolveContainerEnv(containerEnv, containerEnv)
+	return nil
+}
+
+// PublishedPort describes a container port that's published on the
+// host.
+type PublishedPort struct {
+	ContainerPort uint16 `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+	HostIP        string `json:"hostIp,omitempty"`
+	HostPort      string `json:"hostPort"`
+	// The host port that was asked for, if it was in use and HostPort
+	// was published on instead
+	RequestedHostPort string `json:"requestedHostPort,omitempty"`
+}
+
+// InspectPublishedPorts returns the ports the container designated by
+// containerID has published on the host, sorted by container port.
+//
+// Ports are read off the running container, so they reflect what the
+// engine actually bound, including any ports it picked itself.
+func (c *Client) InspectPublishedPorts(ctx context.Context, containerID string) ([]PublishedPort, error) {
+	inspectRes, err := c.mobyClient.ContainerInspect(ctx, containerID, mobyclient.ContainerInspectOptions{})
+	if err != nil {
+		slog.Error("encountered an error while inspecting a container", "container-id", containerID, "error", err)
+		return nil, err
+	}
+	if inspectRes.Container.NetworkSettings == nil {
+		return nil, nil
+	}
+
+	var ports []PublishedPort
+	for port, bindings := range inspectRes.Container.NetworkSettings.Ports {
+		for _, binding := range bindings {
+			publishedPort := PublishedPort{
+				ContainerPort: port.Num(),
+				Protocol:      string(port.Proto()),
+				HostPort:      binding.HostPort,
+			}
+			if binding.HostIP.IsValid() {
+				publishedPort.HostIP = binding.HostIP.String()
+				if requested := c.requestedHostPort(port.Proto(), binding.HostIP, binding.HostPort); requested != binding.HostPort {
+					publishedPort.RequestedHostPort = requested
+				}
+			}
+			ports = append(ports, publishedPort)
+		}
+	}
+	slices.SortFunc(ports, func(a, b PublishedPort) int {
+		if a.ContainerPort != b.ContainerPort {
+			return int(a.ContainerPort) - int(b.ContainerPort)
+		}
+		if a.Protocol != b.Protocol {
+			return strings.Compare(a.Protocol, b.Protocol)
+		}
+		return strings.Compare(a.HostIP, b.HostIP)
+	})
+	return ports, nil
+}
+
+// StartContainer starts the existing container designated by
+// containerID, e.g., to bring it back after it's died.
+func (c *Client) StartContainer(ctx context.Context, containerID string) error {
+	if _, err := c.mobyClient.ContainerStart(ctx, containerID, mobyclient.ContainerStartOptions{}); err != nil {
+		slog.Error("encountered an error while trying to start a container", "error", err, "container-id", containerID)
+		return fmt.Errorf("%w: %w", ErrContainerStart, err)
+	}
+	return nil
+}
+
+// IsContainerRunning reports whether the container designated by
+// containerID is running; an error is returned if it can't be
+// inspected, e.g., because it doesn't exist.
+func (c *Client) IsContainerRunning(ctx context.Context, containerID string) (bool, error) {
+	res, err := c.mobyClient.ContainerInspect(ctx, containerID, mobyclient.ContainerInspectOptions{})
+	if err != nil {
+		return false, err
+	}
+	return res.Container.State != nil && res.Container.State.Running, nil
+}
+
+// UseRunningDevcontainer points c at the devcontainer described by p
+// that's already up as containerName (e.g., one left running with
+// --detach, or by another brig instance), so commands can be run in
+// it.
+//
+// containerUser and remoteUser are filled in if p doesn't set them,
+// as StartDevcontainerContainer would have.
+func (c *Client) UseRunningDevcontainer(ctx context.Context, p *writ.DevcontainerParser, containerName string) error {
+	res, err := c.mobyClient.ContainerInspect(ctx, containerName, mobyclient.ContainerInspectOptions{})
+	if err != nil {
+		slog.Error("encountered an error while trying to inspect the devcontainer", "name", containerName, "error", err)
+		return err
+	}
+	c.ContainerID = res.Container.ID
+	imageTag := res.Container.Image
+	if res.Container.Config != nil && len(res.Container.Config.Image) > 0 {
+		imageTag = res.Container.Config.Image
+	}
+	if err = c.setContainerAndRemoteUser(ctx, p, imageTag); err != nil {
+		return err
+	}
+	if p.EnvProbeNeeded {
+		if err = c.resolveContainerEnv(ctx, p); err != nil {
+			return err
+		}
+	}
+	c.configureShell(p)
+	return nil
+}
+
+// StopContainer signals the container designated by containerID to
+// terminate.
+func (c *Client) StopContainer(ctx context.Context, containerID string) error {
+	if _, err := c.mobyClient.ContainerStop(ctx, containerID, mobyclient.ContainerStopOptions{}); err != nil {
+		slog.Error("encountered an error while trying to stop a container", "error", err, "container-id", containerID)
+		return err
+	}
+	return nil
+}
+
+// CommitContainer saves the current state of the container designated
+// by containerID as an image tagged ref, and returns the new image's
+// ID.
+//
+// The image keeps the container's configuration, including the labels
+// it inherited from its own image, e.g., ImageMetadataLabel, so it can
+// stand in for the image the container was created from.
+func (c *Client) CommitContainer(ctx context.Context, containerID string, ref string) (string, error) {
+	slog.Debug("committing container", "container-id", containerID, "ref", ref)
+	res, err := c.mobyClient.ContainerCommit(ctx, containerID, mobyclient.ContainerCommitOptions{Reference: ref})
+	if err != nil {
+		slog.Error("encountered an error while trying to commit a container", "error", err, "container-id", containerID)
+		return "", fmt.Errorf("%w: %w", ErrContainerCommit, err)
+	}
+	return res.ID, nil
+}
+
+// StopDevcontainer signals the devcontainer to terminate and then
+// subsequently removed.
+//
+// There is normally no reason to call this directly: this is intended
+// to assist with cleanup when errors are encountered.
+func (c *Client) StopDevcontainer(ctx context.Context) error {
+	return c.StopContainer(ctx, c.ContainerID)
+}
+
+// keepAliveEntrypoint replaces the devcontainer's entrypoint and
+// command when overrideCommand is set, so it stays up until it's
+// stopped, regardless of what the image would otherwise run.
+var keepAliveEntrypoint = []string{"/bin/sh", "-c", `echo Container started
+trap "exit 0" 15
+while sleep 1 & wait $!; do :; done`, "-"}
+
+// shellCommand returns the command that starts the remote user's
+// shell, as listed in /etc/passwd, falling back to /bin/sh; with login,
+// it's started as a login shell.
+func shellCommand(login bool) []string {
+	var loginFlag string
+	if login {
+		loginFlag = " -l"
+	}
+	return []string{"/bin/sh", "-c", `shell="$(awk -F: -v user="$(id -un)" '$1 == user { print $7 }' /etc/passwd 2>/dev/null)"
+exec "${shell:-/bin/sh}"` + loginFlag}
+}
+
+// configureShell sets up the shell attached to the host terminal to
+// run as p's remoteUser, with its remoteEnv, in its workspaceFolder.
+//
+// It's a login shell unless p's userEnvProbe says the remote user's
+// environment comes from a non-login one, so the shell sees the same
+// environment the lifecycle commands were meant to.
+func (c *Client) configureShell(p *writ.DevcontainerParser) {
+	c.shellOpts = ExecOptions{User: *p.Config.RemoteUser, Env: &p.Config.RemoteEnv}
+	if p.Config.WorkspaceFolder != nil {
+		c.shellOpts.WorkingDir = *p.Config.WorkspaceFolder
+	}
+	c.shellLogin = true
+	if p.Config.UserEnvProbe != nil {
+		switch *p.Config.UserEnvProbe {
+		case writ.UserEnvProbeInteractiveShell, writ.UserEnvProbeUserEnvProbeNone:
+			c.shellLogin = false
+		}
+	}
+}
+
+// AttachHostTerminalToDevcontainer starts an interactive shell in the
+// devcontainer as its remoteUser, routes input from the terminal into
+// the shell's pseudo-TTY, and redirects the pseudo-TTY's output to the
+// host terminal.
+//
+// This allows usage of the container in a terminal as one would,
+// e.g., a regular shell. The shell gets the devcontainer's remoteEnv,
+// and the container's own command (see overrideCommand) is left
+// alone.
+//
+// Cancelling ctx severs the connection to the container, which
+// returns the host terminal to its previous state. So does typing
+// c.DetachKeys, in which case ErrDetached is returned, and the shell
+// is left running.
+func (c *Client) AttachHostTerminalToDevcontainer(ctx context.Context) (err error) {
+	defer c.CloseLifecycle()
+
+	slog.Debug("attempting to attach host terminal to container", "container", c.ContainerID)
+	if len(c.ContainerID) == 0 {
+		return fmt.Errorf("attempted to attach host terminal without a running devcontainer")
+	}
+
+	if c.isAttached {
+		slog.Debug("attempt to attach host terminal when it's already attached; no-op")
+		return nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("stdin is not a terminal")
+	}
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return fmt.Errorf("stdout is not a terminal")
+	}
+
+	c.isAttached = true
+	// The shell takes over the host terminal from here
+	c.stopCommandOutput()
+
+	w, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		slog.Error("encountered an error trying to get the terminal's dimensions", "error", err)
+		return err
+	}
+	_, endSpan := c.startSpan(ctx, "attach")
+	err = c.startShell(ctx, uint(h), uint(w)) // #nosec G115
+	endSpan(err)
+	if err != nil {
+		return err
+	}
+	slog.Debug("setting up hooks to handle terminal resizing")
+	c.listenForTerminalResize(ctx)
+
+	slog.Debug("setting host terminal to raw mode")
+	restoreTerm, err := c.switchTerminalToRaw()
+	if err != nil {
+		return err
+	}
+	defer restoreTerm()
+
+	// Closing the connection unblocks the copy from the container's
+	// output below, allowing the deferred terminal restoration to run
+	stopDetach := context.AfterFunc(ctx, func() {
+		slog.Debug("context cancelled; detaching from container", "id", c.ContainerID)
+		c.closeShellConn()
+	})
+	defer stopDetach()
+
+	slog.Debug("setting up terminal input/output")
+	outputDone := make(chan error, 1)
+	go func() {
+		outputDone <- c.pumpShellOutput(ctx, os.Stdout)
+	}()
+	go c.pumpShellInput(terminalInput())
+
+	if err = c.fireLifecycleEvent(ctx, LifecyclePostAttach); err != nil {
+		return err
+	}
+
+	if err = <-outputDone; err != nil {
+		return err
+	}
+	slog.Debug("detached from container", "id", c.ContainerID)
+
+	return ctx.Err()
+}
+
+// CloseLifecycle closes DevcontainerLifecycleChan, signalling to the
+// lifecycle handler that no more events are coming.
+//
+// It's called once the host terminal is detached from the
+// devcontainer; in headless mode, where the terminal is never
+// attached, it has to be called by whatever takes its place. Calling
+// it more than once is safe.
+func (c *Client) CloseLifecycle() {
+	c.lifecycleDone.Do(func() {
+		close(c.DevcontainerLifecycleChan)
+	})
+}
+
+// startShell runs shellCommand in the devcontainer with a pseudo-TTY
+// of the given dimensions, and connects to it.
+func (c *Client) startShell(ctx context.Context, h uint, w uint) error {
+	execCreateOpts := mobyclient.ExecCreateOptions{
+		User:         c.shellOpts.User,
+		TTY:          true,
+		ConsoleSize:  mobyclient.ConsoleSize{Height: h, Width: w},
+		AttachStdin:  true,
+		AttachStderr: true,
+		AttachStdout: true,
+		Cmd:          shellCommand(c.shellLogin),
+		WorkingDir:   c.shellOpts.WorkingDir,
+	}
+	if len(c.AttachShell) > 0 {
+		execCreateOpts.Cmd = []string{c.AttachShell}
+		if c.shellLogin {
+			execCreateOpts.Cmd = append(execCreateOpts.Cmd, "-l")
+		}
+	}
+	if c.shellOpts.Env != nil {
+		execCreateOpts.Env = mergeEnv(nil, *c.shellOpts.Env)
+	}
+	slog.Debug("starting an interactive shell", "container", c.ContainerID, "opts", execCreateOpts)
+	execCreateRes, err := c.mobyClient.ExecCreate(ctx, c.ContainerID, execCreateOpts)
+	if err != nil {
+		slog.Error("encountered error while preparing the interactive shell", "error", err)
+		return err
+	}
+	execAttachRes, err := c.mobyClient.ExecAttach(ctx, execCreateRes.ID, mobyclient.ExecAttachOptions{
+		TTY:         true,
+		ConsoleSize: mobyclient.ConsoleSize{Height: h, Width: w},
+	})
+	if err != nil {
+		slog.Error("encountered error while attaching to the interactive shell", "error", err)
+		return err
+	}
+	c.shellMu.Lock()
+	defer c.shellMu.Unlock()
+	c.shellExecID = execCreateRes.ID
+	c.shellHeight, c.shellWidth = h, w
+	c.attachResp = &execAttachRes.HijackedResponse
+	return nil
+}
+
+// resizeShell sets the pseudo-TTY height and width of the shell
+// attached to the host terminal to the passed in values.
+func (c *Client) resizeShell(ctx context.Context, h uint, w uint) (err error) {
+	c.shellMu.Lock()
+	execID := c.shellExecID
+	c.shellHeight, c.shellWidth = h, w
+	c.shellMu.Unlock()
+	_, err = c.mobyClient.ExecResize(ctx, execID, mobyclient.ExecResizeOptions{
+		Height: h,
+		Width:  w,
+	})
+	return err
+}
+
+// ResizeContainer sets the container's internal pseudo-TTY height and
+// width to the passed in values.
+func (c *Client) ResizeContainer(ctx context.Context, h uint, w uint) (err error) {
+	_, err = c.mobyClient.ContainerResize(ctx, c.ContainerID, mobyclient.ContainerResizeOptions{
+		Height: h,
+		Width:  w,
+	})
+	return err
+}
+
+// buildContainerConfig initializes and returns a Moby
+// container.Config struct for later use with containers.
+func (c *Client) buildContainerConfig(p *writ.DevcontainerParser, tag string) *container.Config {
+	slog.Debug("building the container configuration")
+	containerEnvs := mergeEnv(mergeEnv(nil, c.proxyEnv()), p.Config.ContainerEnv)
+
+	containerCfg := container.Config{
+		Env:          containerEnvs,
+		ExposedPorts: make(network.PortSet),
+		Image:        tag,
+		Labels:       c.ResourceLabels(p),
+		OpenStdin:    true,
+		Tty:          true,
+		WorkingDir:   *p.Config.WorkspaceFolder,
+	}
+
+	if p.Config.ContainerUser != nil {
+		containerCfg.User = *p.Config.ContainerUser
+	}
+
+	return &containerCfg
+}
+
+// mergeEnv returns env, a list of KEY=value pairs, with the variables
+// in overrides added to it, replacing any with the same names.
+func mergeEnv(env []string, overrides writ.EnvVarMap) []string {
+	merged := slices.DeleteFunc(slices.Clone(env), func(envVar string) bool {
+		key, _, _ := strings.Cut(envVar, "=")
+		_, ok := overrides[key]
+		return ok
+	})
+	for _, key := range slices.Sorted(maps.Keys(overrides)) {
+		merged = append(merged, fmt.Sprintf("%s=%s", key, overrides[key]))
+	}
+	return merged
+}
+
+// buildHostConfig initializes and returns a Moby container.HostConfig
+// struct for later use with containers.
+func (c *Client) buildHostConfig(p *writ.DevcontainerParser) *container.HostConfig {
+	securityOpts := p.SecurityOptions()
+	hostCfg := container.HostConfig{
+		AutoRemove:   true,
+		CapAdd:       securityOpts.CapAdd,
+		Init:         p.Config.Init,
+		PortBindings: make(network.PortMap),
+		Privileged:   securityOpts.Privileged,
+		SecurityOpt:  securityOpts.SecurityOpt,
+	}
+	addMount(&hostCfg, c.buildWorkspaceMount(p))
+
+	return &hostCfg
+}
+
+// buildWorkspaceMount returns the mount that makes the workspace
+// available inside the devcontainer.
+//
+// If devcontainer.json specifies workspaceMount, it is used as-is;
+// otherwise, the workspace on the host is bind-mounted as the
+// workspace folder.
+// Either way, a bind mount that doesn't specify its own SELinux
+// relabeling is relabeled as per WorkspaceRelabel.
+func (c *Client) buildWorkspaceMount(p *writ.DevcontainerParser) writ.MobyMount {
+	workspaceMount := writ.MobyMount{Mount: mount.Mount{
+		Type:   mount.TypeBind,
+		Source: p.WorkspaceFolder(),
+		Target: *p.Config.WorkspaceFolder,
+	}}
+	if p.Config.WorkspaceMount != nil {
+		workspaceMount = *p.Config.WorkspaceMount
+	}
+	if workspaceMount.Type == mount.TypeBind && workspaceMount.Relabel == writ.RelabelNone {
+		workspaceMount.Relabel = c.workspaceRelabel()
+	}
+	return workspaceMount
+}
+
+// bindMounts sets up bind and/or volume mounts; bind mounts are
+// only relabeled for SELinux if they ask for it.
+//
+// Requires hostCfg to its respective struct.
+func (c *Client) bindMounts(p *writ.DevcontainerParser, hostCfg *container.HostConfig) {
+	for _, mountEntry := range p.Config.Mounts {
+		addMount(hostCfg, *mountEntry)
+	}
+}
+
+// fireLifecycleEvent broadcasts event on DevcontainerLifecycleChan
+// and blocks until the lifecycle handler responds.
+//
+// Returns ErrLifecycleHandler if the handler reports a failure, or
+// ctx's error if it's cancelled before the exchange completes (e.g.,
+// because the handler has already exited).
+func (c *Client) fireLifecycleEvent(ctx context.Context, event LifecycleEvents) error {
+	select {
+	case c.DevcontainerLifecycleChan <- event:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case ok := <-c.DevcontainerLifecycleResp:
+		if !ok {
+			return ErrLifecycleHandler
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// setContainerAndRemoteUser tries to determine what value the
+// containerUser and remoteUser fields should have based on a target
+// image, provided they're not already set.
+//
+// If the image can't be inspected and it's built from a Containerfile,
+// the user is taken from the Containerfile instead.
+func (c *Client) setContainerAndRemoteUser(ctx context.Context, p *writ.DevcontainerParser, imageTag string) (err error) {
+	if p.Config.ContainerUser == nil {
+		slog.Info("containerUser not set; attempting to figure it out using image metadata")
+		var imageUser string
+		if imageUser, err = c.imageUser(ctx, imageTag); err != nil && p.Config.DockerFile != nil {
+			slog.Debug("could not inspect image; falling back to its Containerfile", "image", imageTag, "error", err)
+			imageUser, err = c.containerfileUser(ctx, p)
+		}
+		if err == nil {
+			if len(imageUser) == 0 {
+				imageUser = "root"
+			}
+			p.Config.ContainerUser = &imageUser
+		}
+	} else {
+		slog.Debug("containerUser already set; skipping image metadata inspection", "user", *p.Config.ContainerUser)
+	}
+
+	if err == nil && p.Config.RemoteUser == nil {
+		slog.Info("remoteUser not set; setting to be the same as containerUser", "user", *p.Config.ContainerUser)
+		p.Config.RemoteUser = p.Config.ContainerUser
+	}
+
+	return err
+}
+
+// imageUser returns the user the image tagged imageTag runs as; the
+// result is empty if the image doesn't set one.
+func (c *Client) imageUser(ctx context.Context, imageTag string) (string, error) {
+	imageCfg, err := c.InspectImage(ctx, imageTag)
+	if err != nil {
+		return "", err
+	}
+	return imageCfg.User, nil
+}
+
+// containerfileUser returns the user the image built from the
+// Containerfile referenced by p runs as, deferring to the image the
+// Containerfile is based on if it doesn't set one.
+func (c *Client) containerfileUser(ctx context.Context, p *writ.DevcontainerParser) (string, error) {
+	info, err := ReadDevcontainerContainerfile(p)
+	if err != nil {
+		return "", err
+	}
+	if len(info.User) > 0 {
+		return info.User, nil
+	}
+	return c.imageUser(ctx, info.BaseImage)
+}
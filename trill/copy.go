@@ -0,0 +1,123 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+
+	"github.com/moby/go-archive"
+	mobyclient "github.com/moby/moby/client"
+)
+
+// CopyToContainer copies srcPath on the host into the container
+// designated by containerID as dstPath, the way `docker cp` does:
+// if dstPath is an existing directory, srcPath is copied into it;
+// otherwise, it's copied as dstPath. A srcPath ending in /. has its
+// contents copied rather than the directory itself.
+//
+// dstPath's parent has to exist in the container already.
+func (c *Client) CopyToContainer(ctx context.Context, containerID string, srcPath string, dstPath string) error {
+	slog.Debug("copying into container", "container-id", containerID, "path", srcPath, "destination", dstPath)
+	srcInfo, err := archive.CopyInfoSourcePath(srcPath, false)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrContainerCopy, err)
+	}
+
+	// If the destination can't be looked at, assume it doesn't exist
+	// yet; the engine refuses the copy if that's wrong
+	dstInfo := archive.CopyInfo{Path: dstPath}
+	if stat, err := c.statContainerPath(ctx, containerID, dstPath); err == nil {
+		dstInfo.Path = stat.path
+		dstInfo.Exists = true
+		dstInfo.IsDir = stat.isDir
+	}
+
+	srcArchive, err := archive.TarResource(srcInfo)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrContainerCopy, err)
+	}
+	defer srcArchive.Close()
+	dstDir, content, err := archive.PrepareArchiveCopy(srcArchive, srcInfo, dstInfo)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrContainerCopy, err)
+	}
+	defer content.Close()
+
+	if _, err = c.mobyClient.CopyToContainer(ctx, containerID, mobyclient.CopyToContainerOptions{
+		DestinationPath: dstDir,
+		Content:         content,
+	}); err != nil {
+		slog.Error("encountered an error while copying into a container", "container-id", containerID, "error", err)
+		return fmt.Errorf("%w: %w", ErrContainerCopy, err)
+	}
+	return nil
+}
+
+// CopyFromContainer copies srcPath in the container designated by
+// containerID to dstPath on the host, the way `docker cp` does; see
+// CopyToContainer.
+func (c *Client) CopyFromContainer(ctx context.Context, containerID string, srcPath string, dstPath string) error {
+	slog.Debug("copying out of container", "container-id", containerID, "path", srcPath, "destination", dstPath)
+	res, err := c.mobyClient.CopyFromContainer(ctx, containerID, mobyclient.CopyFromContainerOptions{SourcePath: srcPath})
+	if err != nil {
+		slog.Error("encountered an error while copying out of a container", "container-id", containerID, "error", err)
+		return fmt.Errorf("%w: %w", ErrContainerCopy, err)
+	}
+	defer res.Content.Close()
+
+	srcInfo := archive.CopyInfo{
+		Path:   srcPath,
+		Exists: true,
+		IsDir:  res.Stat.Mode.IsDir(),
+	}
+	if err = archive.CopyTo(res.Content, srcInfo, dstPath); err != nil {
+		return fmt.Errorf("%w: %w", ErrContainerCopy, err)
+	}
+	return nil
+}
+
+// containerPathStat is what statContainerPath found at a path.
+type containerPathStat struct {
+	path  string // The path, with the symlink resolved if it was one
+	isDir bool
+}
+
+// statContainerPath looks at filePath in the container designated by
+// containerID, following it once if it's a symlink.
+func (c *Client) statContainerPath(ctx context.Context, containerID string, filePath string) (containerPathStat, error) {
+	res, err := c.mobyClient.ContainerStatPath(ctx, containerID, mobyclient.ContainerStatPathOptions{Path: filePath})
+	if err != nil {
+		return containerPathStat{}, err
+	}
+	if res.Stat.Mode&os.ModeSymlink == 0 {
+		return containerPathStat{path: filePath, isDir: res.Stat.Mode.IsDir()}, nil
+	}
+
+	linkTarget := res.Stat.LinkTarget
+	if !path.IsAbs(linkTarget) {
+		linkTarget = path.Join(path.Dir(filePath), linkTarget)
+	}
+	if res, err = c.mobyClient.ContainerStatPath(ctx, containerID, mobyclient.ContainerStatPathOptions{Path: linkTarget}); err != nil {
+		return containerPathStat{}, err
+	}
+	return containerPathStat{path: linkTarget, isDir: res.Stat.Mode.IsDir()}, nil
+}
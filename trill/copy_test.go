@@ -0,0 +1,71 @@
+package trill
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/moby/moby/api/types/container"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCopyToAndFromContainer checks that files and directories are
+// copied into and out of containers following `docker cp`'s rules.
+func TestCopyToAndFromContainer(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("alpine", nil)
+	_, err := engine.ContainerCreate(context.Background(), mobyclient.ContainerCreateOptions{
+		Name:   "devcontainer",
+		Config: &container.Config{Image: "alpine"},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, engine.AddFile("devcontainer", "/home/vscode/.profile", []byte("# profile\n")))
+	c := newFakeClient(t, engine)
+
+	hostDir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(hostDir, "token"), []byte("secret"), 0o600))
+	assert.Nil(t, os.MkdirAll(filepath.Join(hostDir, "certs"), 0o755))
+	assert.Nil(t, os.WriteFile(filepath.Join(hostDir, "certs", "ca.pem"), []byte("ca"), 0o644))
+
+	// Into an existing directory, it keeps its name
+	assert.Nil(t, c.CopyToContainer(context.Background(), "devcontainer", filepath.Join(hostDir, "token"), "/home/vscode"))
+	contents, ok := engine.File("devcontainer", "/home/vscode/token")
+	assert.True(t, ok)
+	assert.Equal(t, "secret", string(contents))
+
+	// Otherwise, it's renamed
+	assert.Nil(t, c.CopyToContainer(context.Background(), "devcontainer", filepath.Join(hostDir, "token"), "/home/vscode/.token"))
+	_, ok = engine.File("devcontainer", "/home/vscode/.token")
+	assert.True(t, ok)
+
+	// Directories ending in /. have their contents copied
+	assert.Nil(t, c.CopyToContainer(context.Background(), "devcontainer", filepath.Join(hostDir, "certs")+"/.", "/home/vscode"))
+	_, ok = engine.File("devcontainer", "/home/vscode/ca.pem")
+	assert.True(t, ok)
+	assert.Nil(t, c.CopyToContainer(context.Background(), "devcontainer", filepath.Join(hostDir, "certs"), "/home/vscode"))
+	_, ok = engine.File("devcontainer", "/home/vscode/certs/ca.pem")
+	assert.True(t, ok)
+
+	outDir := t.TempDir()
+	assert.Nil(t, c.CopyFromContainer(context.Background(), "devcontainer", "/home/vscode/.profile", outDir))
+	contents, err = os.ReadFile(filepath.Join(outDir, ".profile"))
+	assert.Nil(t, err)
+	assert.Equal(t, "# profile\n", string(contents))
+	assert.Nil(t, c.CopyFromContainer(context.Background(), "devcontainer", "/home/vscode/certs", filepath.Join(outDir, "copied")))
+	contents, err = os.ReadFile(filepath.Join(outDir, "copied", "ca.pem"))
+	assert.Nil(t, err)
+	assert.Equal(t, "ca", string(contents))
+
+	err = c.CopyFromContainer(context.Background(), "devcontainer", "/nonexistent", outDir)
+	assert.ErrorIs(t, err, ErrContainerCopy)
+	err = c.CopyToContainer(context.Background(), "missing", filepath.Join(hostDir, "token"), "/tmp")
+	assert.ErrorIs(t, err, ErrContainerCopy)
+}
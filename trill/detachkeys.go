@@ -0,0 +1,112 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultDetachKeys is the key sequence that detaches the host
+// terminal from the devcontainer unless Client.DetachKeys says
+// otherwise; it's the same one `docker attach` uses.
+const DefaultDetachKeys = "ctrl-p,ctrl-q"
+
+// defaultDetachKeys is DefaultDetachKeys, parsed.
+var defaultDetachKeys = []byte{0x10, 0x11}
+
+// ErrDetached is returned by AttachHostTerminalToDevcontainer when the
+// host terminal is detached with Client.DetachKeys, which leaves the
+// devcontainer (and the shell in it) running.
+var ErrDetached = errors.New("detached from the devcontainer")
+
+// ctrlKeys maps the non-letter keys that can be combined with Ctrl to
+// the control characters they produce.
+var ctrlKeys = map[string]byte{
+	"@":  0,
+	"[":  27,
+	"\\": 28,
+	"]":  29,
+	"^":  30,
+	"_":  31,
+}
+
+// ParseDetachKeys turns a comma-separated key sequence in the format
+// `docker attach --detach-keys` takes (e.g., "ctrl-p,ctrl-q") into the
+// bytes typing it produces.
+//
+// Each key is either a single character, or "ctrl-" followed by a
+// letter or one of @, [, \, ], ^, or _.
+func ParseDetachKeys(s string) ([]byte, error) {
+	var keys []byte
+	for key := range strings.SplitSeq(s, ",") {
+		ctrlKey, isCtrl := strings.CutPrefix(strings.ToLower(key), "ctrl-")
+		switch {
+		case isCtrl && len(ctrlKey) == 1 && ctrlKey[0] >= 'a' && ctrlKey[0] <= 'z':
+			keys = append(keys, ctrlKey[0]-'a'+1)
+		case isCtrl:
+			code, ok := ctrlKeys[ctrlKey]
+			if !ok {
+				return nil, fmt.Errorf("unsupported detach key: %q", key)
+			}
+			keys = append(keys, code)
+		case len(key) == 1:
+			keys = append(keys, key[0])
+		default:
+			return nil, fmt.Errorf("unsupported detach key: %q", key)
+		}
+	}
+	return keys, nil
+}
+
+// detachKeyFilter picks a detach key sequence out of terminal input,
+// holding back keys that might be the start of it until it's clear
+// whether they are.
+type detachKeyFilter struct {
+	keys    []byte
+	matched int // How many of keys the input has ended with so far
+}
+
+// filter returns the part of input that should be passed on, and
+// whether the detach key sequence has been typed. Nothing after the
+// sequence is passed on.
+func (f *detachKeyFilter) filter(input []byte) (passed []byte, detach bool) {
+	if len(f.keys) == 0 {
+		return input, false
+	}
+	passed = make([]byte, 0, len(input))
+	for _, b := range input {
+		if b != f.keys[f.matched] && f.matched > 0 {
+			// Not the sequence after all; let the keys held back
+			// through
+			passed = append(passed, f.keys[:f.matched]...)
+			f.matched = 0
+		}
+		if b != f.keys[f.matched] {
+			passed = append(passed, b)
+			continue
+		}
+		f.matched++
+		if f.matched == len(f.keys) {
+			f.matched = 0
+			return passed, true
+		}
+	}
+	return passed, false
+}
@@ -0,0 +1,53 @@
+package trill
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseDetachKeys checks that key sequences in the format `docker
+// attach --detach-keys` takes are turned into the bytes they produce.
+func TestParseDetachKeys(t *testing.T) {
+	keys, err := ParseDetachKeys(DefaultDetachKeys)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x10, 0x11}, keys)
+	assert.Equal(t, defaultDetachKeys, keys)
+
+	keys, err = ParseDetachKeys("Ctrl-A,ctrl-@,ctrl-\\,x")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x01, 0x00, 0x1c, 'x'}, keys)
+
+	for _, bad := range []string{"", "ctrl-", "ctrl-1", "alt-x", "xy", "ctrl-p,"} {
+		_, err = ParseDetachKeys(bad)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+// TestDetachKeyFilter checks that the detach key sequence is picked
+// out of input split across reads, and that keys held back because
+// they might've been part of it are let through once they aren't.
+func TestDetachKeyFilter(t *testing.T) {
+	filter := detachKeyFilter{keys: defaultDetachKeys}
+
+	passed, detach := filter.filter([]byte("ls\r\x10"))
+	assert.False(t, detach)
+	assert.Equal(t, []byte("ls\r"), passed)
+	// Ctrl-P followed by something else is passed on as-is
+	passed, detach = filter.filter([]byte("\x10\x10x"))
+	assert.False(t, detach)
+	assert.Equal(t, []byte("\x10\x10\x10x"), passed)
+
+	passed, detach = filter.filter([]byte("echo\x10"))
+	assert.False(t, detach)
+	assert.Equal(t, []byte("echo"), passed)
+	passed, detach = filter.filter([]byte("\x11ignored"))
+	assert.True(t, detach)
+	assert.Empty(t, passed)
+
+	// Without detach keys, everything's passed on
+	filter = detachKeyFilter{}
+	passed, detach = filter.filter([]byte("\x10\x11"))
+	assert.False(t, detach)
+	assert.Equal(t, []byte("\x10\x11"), passed)
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import mobyclient "github.com/moby/moby/client"
+
+// dialOpts returns the options the Moby client for socketAddr is
+// created with on top of its address; there aren't any outside of
+// Windows.
+func dialOpts(socketAddr string) []mobyclient.Opt {
+	return nil
+}
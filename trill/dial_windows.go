@@ -0,0 +1,53 @@
+//go:build windows
+
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+	mobyclient "github.com/moby/moby/client"
+)
+
+// NamedPipeDialTimeout is how long connecting to the engine's named
+// pipe is given before it's given up on, e.g., while every instance of
+// the pipe is busy, or the engine's still starting up.
+const NamedPipeDialTimeout = 10 * time.Second
+
+// dialOpts returns the options the Moby client for socketAddr is
+// created with on top of its address.
+//
+// Connections to named pipes are bounded by NamedPipeDialTimeout;
+// otherwise, requests without deadlines of their own (e.g., attaching
+// to a shell) could wait on a busy pipe indefinitely.
+func dialOpts(socketAddr string) []mobyclient.Opt {
+	pipeAddr, ok := strings.CutPrefix(socketAddr, "npipe://")
+	if !ok {
+		return nil
+	}
+	return []mobyclient.Opt{mobyclient.WithDialContext(func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, NamedPipeDialTimeout)
+		defer cancel()
+		return winio.DialPipeContext(ctx, pipeAddr)
+	})}
+}
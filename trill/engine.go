@@ -0,0 +1,76 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"io"
+
+	mobyclient "github.com/moby/moby/client"
+)
+
+// EngineAPI is the subset of the Moby client's methods that Client
+// relies on.
+//
+// *mobyclient.Client satisfies it; it exists so that the container
+// and Composer orchestration can be exercised against a fake engine
+// in tests, without a live Podman/Docker daemon.
+type EngineAPI interface {
+	Close() error
+	Ping(ctx context.Context, options mobyclient.PingOptions) (mobyclient.PingResult, error)
+
+	ContainerAttach(ctx context.Context, containerID string, options mobyclient.ContainerAttachOptions) (mobyclient.ContainerAttachResult, error)
+	ContainerCommit(ctx context.Context, containerID string, options mobyclient.ContainerCommitOptions) (mobyclient.ContainerCommitResult, error)
+	ContainerCreate(ctx context.Context, options mobyclient.ContainerCreateOptions) (mobyclient.ContainerCreateResult, error)
+	ContainerInspect(ctx context.Context, containerID string, options mobyclient.ContainerInspectOptions) (mobyclient.ContainerInspectResult, error)
+	ContainerList(ctx context.Context, options mobyclient.ContainerListOptions) (mobyclient.ContainerListResult, error)
+	ContainerLogs(ctx context.Context, containerID string, options mobyclient.ContainerLogsOptions) (mobyclient.ContainerLogsResult, error)
+	ContainerRemove(ctx context.Context, containerID string, options mobyclient.ContainerRemoveOptions) (mobyclient.ContainerRemoveResult, error)
+	ContainerResize(ctx context.Context, containerID string, options mobyclient.ContainerResizeOptions) (mobyclient.ContainerResizeResult, error)
+	ContainerStart(ctx context.Context, containerID string, options mobyclient.ContainerStartOptions) (mobyclient.ContainerStartResult, error)
+	ContainerStatPath(ctx context.Context, containerID string, options mobyclient.ContainerStatPathOptions) (mobyclient.ContainerStatPathResult, error)
+	ContainerStop(ctx context.Context, containerID string, options mobyclient.ContainerStopOptions) (mobyclient.ContainerStopResult, error)
+	ContainerWait(ctx context.Context, containerID string, options mobyclient.ContainerWaitOptions) mobyclient.ContainerWaitResult
+	CopyFromContainer(ctx context.Context, containerID string, options mobyclient.CopyFromContainerOptions) (mobyclient.CopyFromContainerResult, error)
+	CopyToContainer(ctx context.Context, containerID string, options mobyclient.CopyToContainerOptions) (mobyclient.CopyToContainerResult, error)
+
+	Events(ctx context.Context, options mobyclient.EventsListOptions) mobyclient.EventsResult
+
+	ExecAttach(ctx context.Context, execID string, options mobyclient.ExecAttachOptions) (mobyclient.ExecAttachResult, error)
+	ExecCreate(ctx context.Context, containerID string, options mobyclient.ExecCreateOptions) (mobyclient.ExecCreateResult, error)
+	ExecInspect(ctx context.Context, execID string, options mobyclient.ExecInspectOptions) (mobyclient.ExecInspectResult, error)
+	ExecResize(ctx context.Context, execID string, options mobyclient.ExecResizeOptions) (mobyclient.ExecResizeResult, error)
+
+	ImageBuild(ctx context.Context, buildContext io.Reader, options mobyclient.ImageBuildOptions) (mobyclient.ImageBuildResult, error)
+	ImageInspect(ctx context.Context, imageID string, inspectOpts ...mobyclient.ImageInspectOption) (mobyclient.ImageInspectResult, error)
+	ImagePull(ctx context.Context, refStr string, options mobyclient.ImagePullOptions) (mobyclient.ImagePullResponse, error)
+	ImagePush(ctx context.Context, image string, options mobyclient.ImagePushOptions) (mobyclient.ImagePushResponse, error)
+	ImageTag(ctx context.Context, options mobyclient.ImageTagOptions) (mobyclient.ImageTagResult, error)
+
+	NetworkConnect(ctx context.Context, networkID string, options mobyclient.NetworkConnectOptions) (mobyclient.NetworkConnectResult, error)
+	NetworkCreate(ctx context.Context, name string, options mobyclient.NetworkCreateOptions) (mobyclient.NetworkCreateResult, error)
+	NetworkInspect(ctx context.Context, networkID string, options mobyclient.NetworkInspectOptions) (mobyclient.NetworkInspectResult, error)
+	NetworkRemove(ctx context.Context, networkID string, options mobyclient.NetworkRemoveOptions) (mobyclient.NetworkRemoveResult, error)
+
+	VolumeCreate(ctx context.Context, options mobyclient.VolumeCreateOptions) (mobyclient.VolumeCreateResult, error)
+	VolumeInspect(ctx context.Context, volumeID string, options mobyclient.VolumeInspectOptions) (mobyclient.VolumeInspectResult, error)
+	VolumeRemove(ctx context.Context, volumeID string, options mobyclient.VolumeRemoveOptions) (mobyclient.VolumeRemoveResult, error)
+}
+
+// Ensure the Moby client stays a valid EngineAPI across upgrades
+var _ EngineAPI = (*mobyclient.Client)(nil)
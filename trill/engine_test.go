@@ -0,0 +1,498 @@
+package trill
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/network"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ EngineAPI = (*testutil.FakeEngine)(nil)
+
+// newFakeClient returns a Client that talks to engine, with timings
+// shortened so Composer dependencies resolve quickly.
+func newFakeClient(t *testing.T, engine *testutil.FakeEngine) *Client {
+	c, err := NewClient(ClientOptions{
+		SocketAddr: "fake://engine",
+		Platform:   Platform{Architecture: "amd64", OS: "linux"},
+		Engine:     engine,
+	})
+	assert.Nil(t, err)
+	c.DependencyPollInterval = time.Millisecond
+	c.DependencySettleTime = 0
+	c.Headless = true
+	return c
+}
+
+// answerLifecycleEvents acknowledges every lifecycle event c fires
+// until ctx is done, returning the events it saw.
+func answerLifecycleEvents(ctx context.Context, c *Client) <-chan []LifecycleEvents {
+	seen := make(chan []LifecycleEvents, 1)
+	go func() {
+		var events []LifecycleEvents
+		defer func() { seen <- events }()
+		for {
+			select {
+			case event, ok := <-c.DevcontainerLifecycleChan:
+				if !ok {
+					return
+				}
+				events = append(events, event)
+				c.DevcontainerLifecycleResp <- true
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return seen
+}
+
+// TestNewClientWithEngine checks that a Client given an engine uses
+// it instead of connecting to SocketAddr.
+func TestNewClientWithEngine(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	c := newFakeClient(t, engine)
+	assert.Nil(t, c.Ping(context.Background()))
+
+	engine.FailOn("Ping", errors.New("connection refused"))
+	assert.ErrorIs(t, c.Ping(context.Background()), ErrEngineUnreachable)
+
+	assert.Nil(t, c.Close())
+	assert.Len(t, engine.CallsTo("Close"), 1)
+}
+
+// TestStreamExecInContainer checks that a command's output is
+// demultiplexed into the right writers and that a non-zero exit code
+// is reported as an ExecExitError.
+func TestStreamExecInContainer(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("docker.io/library/alpine:3", nil)
+	engine.ExecHandler = func(containerID string, cmd []string) testutil.ExecResult {
+		if strings.Join(cmd, " ") == "/bin/sh -c exit 3" {
+			return testutil.ExecResult{Stderr: "failing\n", ExitCode: 3}
+		}
+		return testutil.ExecResult{Stdout: "out\n", Stderr: "err\n"}
+	}
+	c := newFakeClient(t, engine)
+	ctx := context.Background()
+
+	createRes, err := engine.ContainerCreate(ctx, mobyclient.ContainerCreateOptions{Name: "exec-target"})
+	assert.Nil(t, err)
+	_, err = engine.ContainerStart(ctx, createRes.ID, mobyclient.ContainerStartOptions{})
+	assert.Nil(t, err)
+
+	var stdout, stderr bytes.Buffer
+	err = c.StreamExecInContainer(ctx, createRes.ID, ExecOptions{
+		User:   "vscode",
+		Env:    &writ.EnvVarMap{"FOO": "bar"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}, "echo", "hello")
+	assert.Nil(t, err)
+	assert.Equal(t, "out\n", stdout.String())
+	assert.Equal(t, "err\n", stderr.String())
+
+	execCreates := engine.CallsTo("ExecCreate")
+	assert.Len(t, execCreates, 1)
+	execOpts := execCreates[0].Options.(mobyclient.ExecCreateOptions)
+	assert.Equal(t, "vscode", execOpts.User)
+	assert.Equal(t, []string{"FOO=bar"}, execOpts.Env)
+	assert.Equal(t, []string{"echo", "hello"}, execOpts.Cmd)
+
+	cmdStdout, cmdStderr, err := c.ExecInContainer(ctx, createRes.ID, ExecOptions{RunInShell: true}, "exit 3")
+	var exitErr *ExecExitError
+	assert.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, exitErr.ExitCode)
+	assert.Empty(t, cmdStdout.String())
+	assert.Equal(t, "failing\n", cmdStderr.String())
+}
+
+// TestEnsureVolume checks that a volume is only created if it doesn't
+// exist yet.
+func TestEnsureVolume(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	engine.AddVolume("existing")
+	c := newFakeClient(t, engine)
+
+	created, err := c.EnsureVolume(context.Background(), "existing", nil)
+	assert.Nil(t, err)
+	assert.False(t, created)
+
+	created, err = c.EnsureVolume(context.Background(), "fresh", map[string]string{DevcontainerIDLabel: "abc123"})
+	assert.Nil(t, err)
+	assert.True(t, created)
+	assert.True(t, engine.HasVolume("fresh"))
+	if volumeCreates := engine.CallsTo("VolumeCreate"); assert.Len(t, volumeCreates, 1) {
+		opts := volumeCreates[0].Options.(mobyclient.VolumeCreateOptions)
+		assert.Equal(t, "abc123", opts.Labels[DevcontainerIDLabel])
+	}
+}
+
+// TestCommitContainer checks that a container is committed under the
+// given reference, and that failures are reported as
+// ErrContainerCommit.
+func TestCommitContainer(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("alpine", nil)
+	_, err := engine.ContainerCreate(context.Background(), mobyclient.ContainerCreateOptions{
+		Name:   "devcontainer",
+		Config: &container.Config{Image: "alpine", Labels: map[string]string{ImageMetadataLabel: `[{"remoteUser":"vscode"}]`}},
+	})
+	assert.Nil(t, err)
+	c := newFakeClient(t, engine)
+
+	imageID, err := c.CommitContainer(context.Background(), "devcontainer", "snapshot:latest")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, imageID)
+	metadata, err := c.InspectImageMetadata(context.Background(), "snapshot:latest")
+	assert.Nil(t, err)
+	assert.Equal(t, ImageMetadata{{"remoteUser": "vscode"}}, metadata)
+
+	_, err = c.CommitContainer(context.Background(), "missing", "snapshot:latest")
+	assert.ErrorIs(t, err, ErrContainerCommit)
+}
+
+// TestDeployComposerProject checks that a Composer project's
+// networks, images, and containers are brought up in dependency
+// order, and that tearing it down removes everything brig created.
+func TestDeployComposerProject(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Chdir(filepath.Join("testdata", "compose", "deploy"))
+	p, err := writ.NewDevcontainerParser("devcontainer.json")
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+
+	engine := testutil.NewFakeEngine()
+	c := newFakeClient(t, engine)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	seen := answerLifecycleEvents(ctx, c)
+
+	err = c.DeployComposerProject(ctx, p, ComposeOptions{ProjectName: "deploy", SuppressOutput: true})
+	assert.Nil(t, err)
+	c.CloseLifecycle()
+	assert.Equal(t, []LifecycleEvents{
+		LifecycleInitialize,
+		LifecycleFeatureInstall,
+		LifecycleOnCreate,
+		LifecycleUpdate,
+		LifecyclePostCreate,
+		LifecyclePostStart,
+	}, <-seen)
+
+	// The default network is always created, even if unused
+	assert.Len(t, engine.CallsTo("NetworkCreate"), 3)
+	assert.Len(t, engine.CallsTo("ImagePull"), 2)
+
+	// db has to be up before app is created
+	creates := engine.CallsTo("ContainerCreate")
+	assert.Len(t, creates, 2)
+	assert.Equal(t, "deploy--db", creates[0].Target)
+	assert.Equal(t, "deploy--app", creates[1].Target)
+
+	app, ok := engine.Container("deploy--app")
+	assert.True(t, ok)
+	assert.Equal(t, app.ID, c.ContainerID)
+	assert.True(t, app.State.Running)
+	assert.Equal(t, "/workspace", app.Config.WorkingDir)
+	// overrideCommand defaults to false for Composer projects
+	assert.Empty(t, app.Config.Entrypoint)
+	// Services keep their own tty setting regardless
+	assert.True(t, app.Config.Tty)
+	// remoteEnv is resolved against the running container's
+	// environment
+	assert.Equal(t, "/usr/local/bin:/usr/bin:/workspace/bin", p.Config.RemoteEnv["PATH"])
+	// Every service is labeled with the devcontainer's ID, and as
+	// belonging to the project
+	for _, name := range []string{"deploy--app", "deploy--db"} {
+		ctr, _ := engine.Container(name)
+		assert.Equal(t, *p.DevcontainerID, ctr.Config.Labels[DevcontainerIDLabel], name)
+		assert.Equal(t, *p.Config.Context, ctr.Config.Labels[writ.LocalFolderLabel], name)
+		assert.Len(t, ctr.Config.Labels[WorkspaceHashLabel], 64, name)
+		assert.Equal(t, "deploy", ctr.Config.Labels[ComposeProjectLabel], name)
+		assert.Equal(t, unknownVersion, ctr.Config.Labels[VersionLabel], name)
+	}
+	// So are the networks
+	for _, call := range engine.CallsTo("NetworkCreate") {
+		opts := call.Options.(mobyclient.NetworkCreateOptions)
+		assert.Equal(t, *p.DevcontainerID, opts.Labels[DevcontainerIDLabel], call.Target)
+		assert.Equal(t, "deploy", opts.Labels[ComposeProjectLabel], call.Target)
+	}
+	// appPort and forwardPorts are published on the primary service,
+	// unless the latter name another service; the other services keep
+	// their own ports
+	assert.Equal(t, network.PortMap{
+		network.MustParsePort("853/udp"):  {{HostIP: netip.MustParseAddr("127.0.0.1"), HostPort: "8853"}},
+		network.MustParsePort("3000/tcp"): {{HostIP: netip.MustParseAddr("127.0.0.1"), HostPort: "3000"}},
+		network.MustParsePort("5000/tcp"): {{HostIP: netip.MustParseAddr("127.0.0.1"), HostPort: "8081"}},
+	}, app.HostConfig.PortBindings)
+	assert.Contains(t, app.Config.ExposedPorts, network.MustParsePort("853/udp"))
+	db, _ := engine.Container("deploy--db")
+	assert.Equal(t, network.PortMap{
+		network.MustParsePort("5432/tcp"): {
+			{HostIP: netip.MustParseAddr("127.0.0.1"), HostPort: "5433"},
+			{HostIP: netip.MustParseAddr("127.0.0.1"), HostPort: "5432"},
+		},
+		network.MustParsePort("53/udp"): {{HostIP: netip.MustParseAddr("127.0.0.1"), HostPort: ""}},
+	}, db.HostConfig.PortBindings)
+	// app is on two networks; the second one is connected after
+	// creation
+	assert.Len(t, app.NetworkSettings.Networks, 2)
+	assert.Len(t, engine.CallsTo("NetworkConnect"), 1)
+
+	assert.Nil(t, c.TeardownComposerProject(context.Background()))
+	assert.Empty(t, engine.Containers())
+	for _, call := range engine.CallsTo("NetworkCreate") {
+		assert.False(t, engine.HasNetwork(call.Target))
+	}
+}
+
+// TestStartDevcontainerContainerOverrideCommand checks that the
+// devcontainer's command is replaced with a keep-alive loop only when
+// overrideCommand is set.
+func TestStartDevcontainerContainerOverrideCommand(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Chdir(filepath.Join("testdata", "image"))
+	for _, override := range []bool{true, false} {
+		p, err := writ.NewDevcontainerParser("devcontainer.json")
+		assert.Nil(t, err)
+		assert.Nil(t, p.Validate())
+		assert.Nil(t, p.Parse())
+		// Defaults to true for image-based devcontainers
+		assert.True(t, *p.Config.OverrideCommand)
+		*p.Config.OverrideCommand = override
+
+		engine := testutil.NewFakeEngine()
+		engine.AddImage("docker.io/library/alpine:3", nil)
+		c := newFakeClient(t, engine)
+		ctx, cancel := context.WithCancel(context.Background())
+		seen := answerLifecycleEvents(ctx, c)
+
+		assert.Nil(t, c.StartDevcontainerContainer(ctx, p, "docker.io/library/alpine:3", "override"))
+		c.CloseLifecycle()
+		<-seen
+		cancel()
+
+		ctr, ok := engine.Container("override")
+		assert.True(t, ok)
+		assert.True(t, ctr.State.Running)
+		// The image's own command runs without a TTY
+		assert.Equal(t, override, ctr.Config.Tty)
+		if override {
+			assert.Equal(t, keepAliveEntrypoint, []string(ctr.Config.Entrypoint))
+		} else {
+			assert.Empty(t, ctr.Config.Entrypoint)
+		}
+		assert.Empty(t, ctr.Config.Cmd)
+
+		// The terminal is attached to a separate shell either way
+		assert.Nil(t, c.startShell(context.Background(), 24, 80))
+		assert.Nil(t, c.resizeShell(context.Background(), 48, 160))
+		execs := engine.CallsTo("ExecCreate")
+		if assert.NotEmpty(t, execs) {
+			opts := execs[len(execs)-1].Options.(mobyclient.ExecCreateOptions)
+			assert.True(t, opts.TTY)
+			assert.Equal(t, shellCommand(true), opts.Cmd)
+			assert.Equal(t, *p.Config.WorkspaceFolder, opts.WorkingDir)
+			assert.Equal(t, *p.Config.RemoteUser, opts.User)
+		}
+		assert.Nil(t, c.Close())
+	}
+}
+
+// TestDeployComposerProjectRollback checks that a Composer project
+// that fails to come up partway through is rolled back, unless
+// KeepOnFailure is set.
+func TestDeployComposerProjectRollback(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Chdir(filepath.Join("testdata", "compose", "deploy"))
+
+	for _, keepOnFailure := range []bool{false, true} {
+		p, err := writ.NewDevcontainerParser("devcontainer.json")
+		assert.Nil(t, err)
+		assert.Nil(t, p.Validate())
+		assert.Nil(t, p.Parse())
+
+		engine := testutil.NewFakeEngine()
+		engine.FailOn("ContainerStart", errors.New("port is already allocated"))
+		c := newFakeClient(t, engine)
+		c.KeepOnFailure = keepOnFailure
+
+		err = c.DeployComposerProject(context.Background(), p, ComposeOptions{ProjectName: "deploy", SuppressOutput: true})
+		assert.ErrorIs(t, err, ErrContainerStart)
+
+		if keepOnFailure {
+			assert.Len(t, engine.Containers(), 1)
+			assert.Empty(t, engine.CallsTo("NetworkRemove"))
+		} else {
+			assert.Empty(t, engine.Containers())
+			assert.Len(t, engine.CallsTo("NetworkRemove"), 3)
+		}
+	}
+}
+
+// TestDeployComposerProjectWithoutFeatureImageBuilder checks that a
+// Composer project whose devcontainer has Features fails to come up
+// with ErrImageBuild, rather than panicking, if the client has no
+// FeatureImageBuilder.
+func TestDeployComposerProjectWithoutFeatureImageBuilder(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Chdir(filepath.Join("testdata", "compose", "deploy"))
+	p, err := writ.NewDevcontainerParser("devcontainer.json")
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+	p.Config.Features = writ.FeatureMap{"ghcr.io/devcontainers/features/go:1": {}}
+
+	engine := testutil.NewFakeEngine()
+	c := newFakeClient(t, engine)
+	assert.Nil(t, c.FeatureImageBuilder)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	answerLifecycleEvents(ctx, c)
+
+	err = c.DeployComposerProject(ctx, p, ComposeOptions{ProjectName: "deploy", SuppressOutput: true})
+	assert.ErrorIs(t, err, ErrImageBuild)
+}
+
+// TestDeployComposerProjectLeftoverNetworks checks that networks left
+// over from an earlier run in the same workspace are reused (or
+// recreated, if asked to), and that those another workspace created
+// under the same names are left alone.
+func TestDeployComposerProjectLeftoverNetworks(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Chdir(filepath.Join("testdata", "compose", "deploy"))
+
+	for _, recreate := range []bool{false, true} {
+		p, err := writ.NewDevcontainerParser("devcontainer.json")
+		assert.Nil(t, err)
+		assert.Nil(t, p.Validate())
+		assert.Nil(t, p.Parse())
+
+		engine := testutil.NewFakeEngine()
+		c := newFakeClient(t, engine)
+		ctx, cancel := context.WithCancel(context.Background())
+		seen := answerLifecycleEvents(ctx, c)
+
+		ours := c.ResourceLabels(p)
+		_, err = engine.NetworkCreate(ctx, "deploy_backend", mobyclient.NetworkCreateOptions{Labels: ours})
+		assert.Nil(t, err)
+		_, err = engine.NetworkCreate(ctx, "deploy_frontend", mobyclient.NetworkCreateOptions{Labels: map[string]string{WorkspaceHashLabel: "0123456789abcdef"}})
+		assert.Nil(t, err)
+
+		err = c.DeployComposerProject(ctx, p, ComposeOptions{ProjectName: "deploy", RecreateNetworks: recreate, SuppressOutput: true})
+		assert.Nil(t, err)
+		c.CloseLifecycle()
+		<-seen
+		cancel()
+
+		var created []string
+		for _, call := range engine.CallsTo("NetworkCreate")[2:] {
+			created = append(created, call.Target)
+		}
+		scopedFrontend := scopedResourceName("deploy_frontend", ours)
+		assert.NotEqual(t, "deploy_frontend", scopedFrontend)
+		assert.Contains(t, created, scopedFrontend)
+		assert.NotContains(t, created, "deploy_frontend")
+		if recreate {
+			assert.Contains(t, created, "deploy_backend")
+			assert.Len(t, engine.CallsTo("NetworkRemove"), 1)
+		} else {
+			assert.NotContains(t, created, "deploy_backend")
+			assert.Empty(t, engine.CallsTo("NetworkRemove"))
+		}
+
+		app, ok := engine.Container("deploy--app")
+		assert.True(t, ok)
+		assert.Contains(t, app.NetworkSettings.Networks, "deploy_backend")
+		assert.Contains(t, app.NetworkSettings.Networks, scopedFrontend)
+
+		// The leftover network is torn down with the project; the
+		// other workspace's isn't
+		assert.Nil(t, c.TeardownComposerProject(context.Background()))
+		assert.False(t, engine.HasNetwork("deploy_backend"))
+		assert.False(t, engine.HasNetwork(scopedFrontend))
+		assert.True(t, engine.HasNetwork("deploy_frontend"))
+	}
+}
+
+// TestUseRunningDevcontainerShell checks that the shell attached to a
+// devcontainer that's already up runs as its remoteUser, in its
+// workspaceFolder, and only as a login shell if its userEnvProbe calls
+// for one.
+func TestUseRunningDevcontainerShell(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Chdir(filepath.Join("testdata", "image"))
+	p, err := writ.NewDevcontainerParser("devcontainer.json")
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+	probe := writ.UserEnvProbeUserEnvProbeNone
+	p.Config.UserEnvProbe = &probe
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("docker.io/library/alpine:3", nil)
+	_, err = engine.ContainerCreate(context.Background(), mobyclient.ContainerCreateOptions{
+		Name:   "running",
+		Config: &container.Config{Image: "docker.io/library/alpine:3"},
+	})
+	assert.Nil(t, err)
+	_, err = engine.ContainerStart(context.Background(), "running", mobyclient.ContainerStartOptions{})
+	assert.Nil(t, err)
+
+	c := newFakeClient(t, engine)
+	assert.Nil(t, c.UseRunningDevcontainer(context.Background(), p, "running"))
+	assert.Nil(t, c.startShell(context.Background(), 24, 80))
+	if execs := engine.CallsTo("ExecCreate"); assert.Len(t, execs, 1) {
+		opts := execs[0].Options.(mobyclient.ExecCreateOptions)
+		assert.Equal(t, shellCommand(false), opts.Cmd)
+		assert.Equal(t, *p.Config.RemoteUser, opts.User)
+		assert.Equal(t, *p.Config.WorkspaceFolder, opts.WorkingDir)
+	}
+
+	// A shell of the user's choosing gets the same treatment
+	c.AttachShell = "/bin/zsh"
+	assert.Nil(t, c.startShell(context.Background(), 24, 80))
+	if execs := engine.CallsTo("ExecCreate"); assert.Len(t, execs, 2) {
+		assert.Equal(t, []string{"/bin/zsh"}, execs[1].Options.(mobyclient.ExecCreateOptions).Cmd)
+	}
+	assert.Nil(t, c.Close())
+}
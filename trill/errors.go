@@ -0,0 +1,98 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// Errors returned by Client wrap one of these, so callers can tell
+// classes of failures apart with errors.Is; the underlying error is
+// wrapped as well.
+var (
+	// ErrEngineUnreachable is returned when the Podman/Docker engine
+	// can't be reached through the configured socket
+	ErrEngineUnreachable = errors.New("unable to reach the Podman/Docker engine")
+	// ErrImageBuild is returned when building an image fails
+	ErrImageBuild = errors.New("image build failed")
+	// ErrUnsupportedBuildOption is returned when build.options has
+	// flags that can't be passed on to the engine
+	ErrUnsupportedBuildOption = errors.New("unsupported build option")
+	// ErrImagePull is returned when pulling an image fails
+	ErrImagePull = errors.New("image pull failed")
+	// ErrImagePush is returned when pushing an image fails
+	ErrImagePush = errors.New("image push failed")
+	// ErrContainerStart is returned when a container can't be created
+	// or started
+	ErrContainerStart = errors.New("unable to start container")
+	// ErrPortInUse is returned when a host port a container is meant
+	// to publish is already bound by something else
+	ErrPortInUse = errors.New("host port is already in use")
+	// ErrDevcontainerUnhealthy is returned when the devcontainer's
+	// healthcheck fails, or doesn't pass in time
+	ErrDevcontainerUnhealthy = errors.New("devcontainer isn't healthy")
+	// ErrContainerCommit is returned when a container can't be saved
+	// as an image
+	ErrContainerCommit = errors.New("unable to commit container")
+	// ErrContainerCopy is returned when files can't be copied into or
+	// out of a container
+	ErrContainerCopy = errors.New("unable to copy files")
+	// ErrContainerAttach is returned when a container's output can't
+	// be attached to
+	ErrContainerAttach = errors.New("unable to attach to container output")
+	// ErrDependencyCycle is returned when the services of a Composer
+	// project depend on each other in a cycle
+	ErrDependencyCycle = errors.New("Compose services depend on each other in a cycle")
+	// ErrLifecycleHandler is a generic error thrown when the lifecycle
+	// handler encounters an error
+	ErrLifecycleHandler = errors.New("lifecycle handler encountered an error")
+)
+
+// ExecExitError is returned when a command ran in a container exits
+// with a non-zero code.
+type ExecExitError struct {
+	ExitCode int
+}
+
+func (e *ExecExitError) Error() string {
+	return fmt.Sprintf("command returned non-zero exit code: %d", e.ExitCode)
+}
+
+// PanicError is what a panic in a goroutine is turned into by
+// RecoverPanic, so it makes its way back to the caller like any other
+// error instead of crashing the process.
+type PanicError struct {
+	Value any    // What was passed to panic
+	Stack []byte // The stack of the goroutine that panicked
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// RecoverPanic is deferred at the top of goroutines (e.g., the ones
+// run through an errgroup, which doesn't recover panics); should the
+// goroutine panic, *err is set to a *PanicError carrying the panic
+// and the stack it was raised from.
+func RecoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = &PanicError{Value: r, Stack: debug.Stack()}
+	}
+}
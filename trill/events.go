@@ -0,0 +1,115 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moby/moby/api/types/events"
+	mobyclient "github.com/moby/moby/client"
+)
+
+// ContainerEvent is a change in the state of a container, as reported
+// by the engine.
+type ContainerEvent struct {
+	ContainerID   string
+	ContainerName string
+	Action        events.Action // What happened, e.g., events.ActionDie; health_status events carry the status, e.g., events.ActionHealthStatusHealthy
+	ExitCode      int           // The exit code the container died with, for events.ActionDie
+	Time          time.Time
+}
+
+// Died reports whether e is the container exiting.
+func (e ContainerEvent) Died() bool {
+	return e.Action == events.ActionDie
+}
+
+// String describes e for the user, e.g., "mycontainer: die (exit code
+// 137)".
+func (e ContainerEvent) String() string {
+	if e.Died() {
+		return fmt.Sprintf("%s: %s (exit code %d)", e.ContainerName, e.Action, e.ExitCode)
+	}
+	return fmt.Sprintf("%s: %s", e.ContainerName, e.Action)
+}
+
+// ContainerEvents subscribes to the engine's events for the containers
+// designated by containerNames (names or IDs), or for every container
+// if none are given.
+//
+// Events are sent on the first channel as they happen, until ctx is
+// cancelled or the stream fails, after which it's closed. The error
+// the stream ended with, which is ctx's when it's cancelled, is then
+// sent on the second channel.
+//
+// Exec events are left out; they're noise as far as a container's
+// state is concerned.
+func (c *Client) ContainerEvents(ctx context.Context, containerNames ...string) (<-chan ContainerEvent, <-chan error) {
+	filters := make(mobyclient.Filters).Add("type", string(events.ContainerEventType))
+	if len(containerNames) > 0 {
+		filters.Add("container", containerNames...)
+	}
+	res := c.mobyClient.Events(ctx, mobyclient.EventsListOptions{Filters: filters})
+
+	containerEvents := make(chan ContainerEvent)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(containerEvents)
+		for {
+			select {
+			case msg := <-res.Messages:
+				if strings.HasPrefix(string(msg.Action), "exec_") {
+					continue
+				}
+				select {
+				case containerEvents <- newContainerEvent(msg):
+				case <-ctx.Done():
+					errs <- ctx.Err()
+					return
+				}
+			case err := <-res.Err:
+				errs <- err
+				return
+			}
+		}
+	}()
+	return containerEvents, errs
+}
+
+// newContainerEvent converts an event message from the engine into a
+// ContainerEvent.
+func newContainerEvent(msg events.Message) ContainerEvent {
+	event := ContainerEvent{
+		ContainerID:   msg.Actor.ID,
+		ContainerName: msg.Actor.Attributes["name"],
+		Action:        msg.Action,
+		Time:          time.Unix(0, msg.TimeNano),
+	}
+	if msg.TimeNano == 0 {
+		event.Time = time.Unix(msg.Time, 0)
+	}
+	if exitCode, err := strconv.Atoi(msg.Actor.Attributes["exitCode"]); err == nil {
+		event.ExitCode = exitCode
+	}
+	return event
+}
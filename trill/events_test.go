@@ -0,0 +1,62 @@
+package trill
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/events"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestContainerEvents checks that only the events of the containers
+// subscribed to are sent, without exec events, and that cancelling
+// the subscription ends it.
+func TestContainerEvents(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("alpine", nil)
+	for _, name := range []string{"watched", "other"} {
+		_, err := engine.ContainerCreate(context.Background(), mobyclient.ContainerCreateOptions{
+			Name:   name,
+			Config: &container.Config{Image: "alpine"},
+		})
+		assert.Nil(t, err)
+	}
+	c := newFakeClient(t, engine)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	containerEvents, errs := c.ContainerEvents(ctx, "watched")
+	assert.Eventually(t, func() bool { return len(engine.CallsTo("Events")) > 0 }, 5*time.Second, time.Millisecond)
+
+	_, err := engine.ContainerStart(context.Background(), "other", mobyclient.ContainerStartOptions{})
+	assert.Nil(t, err)
+	engine.EmitEvent(events.Message{
+		Type:   events.ContainerEventType,
+		Action: events.ActionExecStart + ": /bin/sh",
+		Actor:  events.Actor{Attributes: map[string]string{"name": "watched"}},
+	})
+	engine.EmitEvent(events.Message{
+		Type:   events.ContainerEventType,
+		Action: events.ActionDie,
+		Actor:  events.Actor{ID: "abc123", Attributes: map[string]string{"name": "watched", "exitCode": "137"}},
+	})
+
+	event := <-containerEvents
+	assert.Equal(t, "abc123", event.ContainerID)
+	assert.True(t, event.Died())
+	assert.Equal(t, 137, event.ExitCode)
+	assert.Equal(t, "watched: die (exit code 137)", event.String())
+
+	cancel()
+	_, open := <-containerEvents
+	assert.False(t, open)
+	assert.ErrorIs(t, <-errs, context.Canceled)
+}
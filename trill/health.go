@@ -0,0 +1,161 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+	mobyclient "github.com/moby/moby/client"
+)
+
+// DefaultHealthTimeout is how long the devcontainer is given to turn
+// healthy, unless its healthcheck could reasonably take longer.
+const DefaultHealthTimeout time.Duration = 2 * time.Minute
+
+// Defaults the engine uses for the settings a healthcheck leaves
+// unset.
+const (
+	defaultHealthcheckInterval time.Duration = 30 * time.Second
+	defaultHealthcheckTimeout  time.Duration = 30 * time.Second
+	defaultHealthcheckRetries  int           = 3
+)
+
+// healthcheckDuration returns how long it could reasonably take for a
+// healthcheck with the given settings to flag a container as healthy;
+// zero values stand for the engine's defaults.
+func healthcheckDuration(interval time.Duration, timeout time.Duration, startPeriod time.Duration, retries int) time.Duration {
+	if interval <= 0 {
+		interval = defaultHealthcheckInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultHealthcheckTimeout
+	}
+	if retries <= 0 {
+		retries = defaultHealthcheckRetries
+	}
+	return startPeriod + time.Duration(retries+1)*(interval+timeout)
+}
+
+// hasHealthcheck reports whether hc is a healthcheck that's run, as
+// opposed to an absent one or one disabled with NONE.
+func hasHealthcheck(hc *container.HealthConfig) bool {
+	return hc != nil && len(hc.Test) > 0 && hc.Test[0] != "NONE"
+}
+
+// waitForHealthy waits for the container designated by containerID,
+// named containerName, to be flagged as healthy, if it has a
+// healthcheck (e.g., a HEALTHCHECK in its image), printing out its
+// progress as it goes.
+//
+// The container is given c.HealthTimeout, or as long as its
+// healthcheck could reasonably take, whichever's longer; if
+// c.HealthTimeout is negative, it's waited on for as long as it takes.
+// The wait is cut short if the container is flagged as unhealthy or
+// stops running. Cancelling ctx aborts it.
+func (c *Client) waitForHealthy(ctx context.Context, containerID string, containerName string) error {
+	inspectRes, err := c.mobyClient.ContainerInspect(ctx, containerID, mobyclient.ContainerInspectOptions{})
+	if err != nil {
+		return err
+	}
+	ctr := inspectRes.Container
+	if ctr.Config == nil || !hasHealthcheck(ctr.Config.Healthcheck) {
+		slog.Debug("devcontainer has no healthcheck; not waiting on it", "container", containerName)
+		return nil
+	}
+
+	hc := ctr.Config.Healthcheck
+	timeout := c.HealthTimeout
+	if timeout >= 0 {
+		timeout = max(timeout, healthcheckDuration(hc.Interval, hc.Timeout, hc.StartPeriod, hc.Retries))
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	printf := NewPrefixedPrintf("HEALTH", containerName)
+	_, _ = printf("waiting for the devcontainer to be healthy...\n")
+	pollInterval := c.DependencyPollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultDependencyPollInterval
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	started := time.Now()
+	failingStreak := 0
+	for {
+		state := ctr.State
+		switch {
+		case state == nil || !state.Running:
+			return fmt.Errorf("%w: %s stopped running while waiting for it to be healthy", ErrDevcontainerUnhealthy, containerName)
+		case state.Health == nil:
+			// Not reported yet
+		case state.Health.Status == container.Healthy:
+			_, _ = printf("healthy after %s\n", time.Since(started).Round(time.Second))
+			return nil
+		case state.Health.Status == container.Unhealthy:
+			// The engine only flags a container as unhealthy after
+			// its healthcheck has exhausted its retries
+			return fmt.Errorf("%w: %s is unhealthy%s", ErrDevcontainerUnhealthy, containerName, lastHealthcheckOutput(state.Health))
+		case state.Health.FailingStreak > failingStreak:
+			_, _ = printf("healthcheck failed (%d in a row)%s\n", state.Health.FailingStreak, lastHealthcheckOutput(state.Health))
+		}
+		if state != nil && state.Health != nil {
+			failingStreak = state.Health.FailingStreak
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("%w: timed out after %s waiting for %s to be healthy", ErrDevcontainerUnhealthy, timeout, containerName)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		inspectRes, err = c.mobyClient.ContainerInspect(ctx, containerID, mobyclient.ContainerInspectOptions{})
+		if err != nil {
+			if ctx.Err() != nil {
+				// Let the next iteration report the timeout
+				continue
+			}
+			return err
+		}
+		ctr = inspectRes.Container
+	}
+}
+
+// lastHealthcheckOutput returns the output of the latest healthcheck
+// run in health, trimmed and prefixed with a colon, or an empty string
+// if there's none.
+func lastHealthcheckOutput(health *container.Health) string {
+	if len(health.Log) == 0 || health.Log[len(health.Log)-1] == nil {
+		return ""
+	}
+	output := strings.TrimSpace(health.Log[len(health.Log)-1].Output)
+	if len(output) == 0 {
+		return ""
+	}
+	return ": " + output
+}
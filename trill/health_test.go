@@ -0,0 +1,82 @@
+package trill
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	"github.com/moby/moby/api/types/container"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStartDevcontainerContainerHealth checks that postCreateCommand
+// isn't run until a devcontainer whose image has a healthcheck is
+// healthy, and that bringing it up fails if it turns out unhealthy or
+// doesn't turn healthy in time.
+func TestStartDevcontainerContainerHealth(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Chdir(filepath.Join("testdata", "image"))
+	for _, health := range []container.HealthStatus{container.Healthy, container.Unhealthy, container.Starting} {
+		p, err := writ.NewDevcontainerParser("devcontainer.json")
+		assert.Nil(t, err)
+		assert.Nil(t, p.Validate())
+		assert.Nil(t, p.Parse())
+
+		engine := testutil.NewFakeEngine()
+		imageCfg := &dockerspec.DockerOCIImageConfig{}
+		imageCfg.Healthcheck = &container.HealthConfig{
+			Test:     []string{"CMD", "true"},
+			Interval: time.Millisecond,
+			Timeout:  time.Millisecond,
+			Retries:  1,
+		}
+		engine.AddImage("docker.io/library/alpine:3", imageCfg)
+		c := newFakeClient(t, engine)
+		c.HealthTimeout = 10 * time.Millisecond
+		ctx, cancel := context.WithCancel(context.Background())
+		seen := answerLifecycleEvents(ctx, c)
+
+		// Stand in for the engine running the healthcheck
+		go func() {
+			for ctx.Err() == nil {
+				if ctr, ok := engine.Container("health"); ok && ctr.State.Health != nil {
+					if health != container.Starting {
+						_ = engine.SetContainerState("health", container.State{
+							Status:  container.StateRunning,
+							Running: true,
+							Health: &container.Health{
+								Status: health,
+								Log:    []*container.HealthcheckResult{{Output: "checked\n"}},
+							},
+						})
+					}
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+
+		err = c.StartDevcontainerContainer(ctx, p, "docker.io/library/alpine:3", "health")
+		c.CloseLifecycle()
+		events := <-seen
+		cancel()
+
+		if health == container.Healthy {
+			assert.Nil(t, err)
+			assert.Contains(t, events, LifecyclePostCreate)
+		} else {
+			assert.ErrorIs(t, err, ErrDevcontainerUnhealthy, health)
+			assert.NotContains(t, events, LifecyclePostCreate, health)
+			assert.Contains(t, events, LifecycleOnCreate, health)
+		}
+		assert.Nil(t, c.Close())
+	}
+}
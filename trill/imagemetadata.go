@@ -0,0 +1,179 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/nlsantos/brig/writ"
+)
+
+// ImageMetadataLabel is the label holding the devcontainer
+// configuration an image was built with, as defined by the
+// devcontainer spec; tools that build images from a devcontainer.json
+// (e.g., the devcontainer CLI) read and write it, so images built by
+// one can be used by the others.
+const ImageMetadataLabel string = "devcontainer.metadata"
+
+// ImageMetadata is the contents of the devcontainer.metadata label:
+// a list of configuration snippets, in the order they were applied
+// (i.e., those of the base image first, then those of each Feature,
+// then the devcontainer.json's).
+type ImageMetadata []map[string]any
+
+// imageMetadataProperties are the devcontainer.json properties that
+// go into an image's metadata; everything else only matters when the
+// image is built.
+var imageMetadataProperties = []string{
+	"capAdd",
+	"containerEnv",
+	"containerUser",
+	"customizations",
+	"entrypoint",
+	"forwardPorts",
+	"hostRequirements",
+	"init",
+	"mounts",
+	"onCreateCommand",
+	"otherPortsAttributes",
+	"overrideCommand",
+	"portsAttributes",
+	"postAttachCommand",
+	"postCreateCommand",
+	"postStartCommand",
+	"privileged",
+	"remoteEnv",
+	"remoteUser",
+	"securityOpt",
+	"shutdownAction",
+	"updateContentCommand",
+	"updateRemoteUserUID",
+	"userEnvProbe",
+	"waitFor",
+}
+
+// ParseImageMetadata parses the value of an ImageMetadataLabel; a
+// single configuration snippet is accepted in place of a list.
+func ParseImageMetadata(label string) (ImageMetadata, error) {
+	if len(label) == 0 {
+		return nil, nil
+	}
+	var metadata ImageMetadata
+	if err := json.Unmarshal([]byte(label), &metadata); err == nil {
+		return metadata, nil
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(label), &entry); err != nil {
+		return nil, fmt.Errorf("invalid %s label: %w", ImageMetadataLabel, err)
+	}
+	return ImageMetadata{entry}, nil
+}
+
+// Label returns m serialized as the value of an ImageMetadataLabel.
+func (m ImageMetadata) Label() (string, error) {
+	label, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(label), nil
+}
+
+// DevcontainerMetadata returns the snippet of cfg that goes into the
+// metadata of images built for it.
+func DevcontainerMetadata(cfg *writ.DevcontainerConfig) (map[string]any, error) {
+	return metadataEntry(cfg, cfg.Mounts)
+}
+
+// FeatureMetadata returns the snippet of the configuration of the
+// Feature referenced as ref that goes into the metadata of images it's
+// installed in.
+func FeatureMetadata(ref string, cfg *writ.DevcontainerFeatureConfig) (map[string]any, error) {
+	entry, err := metadataEntry(cfg, cfg.Mounts)
+	if err != nil {
+		return nil, err
+	}
+	entry["id"] = ref
+	return entry, nil
+}
+
+// metadataEntry round-trips cfg through JSON to pick out the
+// properties in imageMetadataProperties.
+//
+// Mounts are written out in the form used in devcontainer.json rather
+// than the engine's, which is what other tools expect.
+func metadataEntry(cfg any, mounts []*writ.MobyMount) (map[string]any, error) {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var props map[string]any
+	if err = json.Unmarshal(raw, &props); err != nil {
+		return nil, err
+	}
+
+	entry := make(map[string]any)
+	for key, val := range props {
+		if slices.Contains(imageMetadataProperties, key) {
+			entry[key] = val
+		}
+	}
+	if len(mounts) > 0 {
+		specMounts := make([]map[string]any, 0, len(mounts))
+		for _, m := range mounts {
+			specMount := map[string]any{"type": m.Type, "target": m.Target}
+			if len(m.Source) > 0 {
+				specMount["source"] = m.Source
+			}
+			specMounts = append(specMounts, specMount)
+		}
+		entry["mounts"] = specMounts
+	}
+	return entry, nil
+}
+
+// containerfileMetadata returns the metadata of the image the
+// Containerfile referenced by p is based on, pulling the image if it
+// isn't available locally.
+func (c *Client) containerfileMetadata(ctx context.Context, p *writ.DevcontainerParser, opts ImageOptions) (ImageMetadata, error) {
+	info, err := ReadDevcontainerContainerfile(p)
+	if err != nil {
+		return nil, err
+	}
+	if info.BaseImage == "scratch" {
+		return nil, nil
+	}
+	if !c.IsImageTagAvailable(ctx, info.BaseImage) {
+		if err = c.PullContainerImage(ctx, info.BaseImage, ImageOptions{SuppressOutput: opts.SuppressOutput}); err != nil {
+			return nil, err
+		}
+	}
+	return c.InspectImageMetadata(ctx, info.BaseImage)
+}
+
+// InspectImageMetadata returns the metadata recorded in the image
+// tagged imageTag, if any.
+func (c *Client) InspectImageMetadata(ctx context.Context, imageTag string) (ImageMetadata, error) {
+	imageCfg, err := c.InspectImage(ctx, imageTag)
+	if err != nil {
+		return nil, err
+	}
+	return ParseImageMetadata(imageCfg.Labels[ImageMetadataLabel])
+}
@@ -0,0 +1,109 @@
+package trill
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	imagespec "github.com/moby/docker-image-spec/specs-go/v1"
+	"github.com/moby/moby/api/types/mount"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/writ"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseImageMetadata checks that both a list of snippets and a
+// lone snippet are accepted as the value of the metadata label.
+func TestParseImageMetadata(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	metadata, err := ParseImageMetadata(`[{"remoteUser":"vscode"},{"id":"ghcr.io/devcontainers/features/node:1","init":true}]`)
+	assert.Nil(t, err)
+	assert.Equal(t, ImageMetadata{
+		{"remoteUser": "vscode"},
+		{"id": "ghcr.io/devcontainers/features/node:1", "init": true},
+	}, metadata)
+
+	metadata, err = ParseImageMetadata(`{"remoteUser":"vscode"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, ImageMetadata{{"remoteUser": "vscode"}}, metadata)
+
+	metadata, err = ParseImageMetadata("")
+	assert.Nil(t, err)
+	assert.Nil(t, metadata)
+
+	_, err = ParseImageMetadata("remoteUser=vscode")
+	assert.NotNil(t, err)
+}
+
+// TestDevcontainerMetadata checks that only the properties that
+// matter to a running container are picked out, and that mounts are
+// written out in the form devcontainer.json uses.
+func TestDevcontainerMetadata(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	name, remoteUser, postCreate := "example", "vscode", "make setup"
+	cfg := &writ.DevcontainerConfig{
+		Name:              &name,
+		RemoteUser:        &remoteUser,
+		ContainerEnv:      writ.EnvVarMap{"FOO": "bar"},
+		PostCreateCommand: &writ.LifecycleCommand{CommandBase: writ.CommandBase{String: &postCreate}},
+		Mounts:            []*writ.MobyMount{{Mount: mount.Mount{Type: mount.TypeVolume, Source: "cache", Target: "/cache"}}},
+		RunArgs:           []string{"--rm"},
+	}
+	entry, err := DevcontainerMetadata(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{
+		"remoteUser":        "vscode",
+		"containerEnv":      map[string]any{"FOO": "bar"},
+		"postCreateCommand": "make setup",
+		"mounts":            []map[string]any{{"type": mount.TypeVolume, "source": "cache", "target": "/cache"}},
+	}, entry)
+
+	init := true
+	entry, err = FeatureMetadata("ghcr.io/devcontainers/features/docker-in-docker:2", &writ.DevcontainerFeatureConfig{
+		ID:      "docker-in-docker",
+		Version: "2.12.0",
+		Init:    &init,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{"id": "ghcr.io/devcontainers/features/docker-in-docker:2", "init": true}, entry)
+}
+
+// TestBuildDevcontainerImageMetadata checks that an image built from
+// a Containerfile is labeled with the metadata of its base image,
+// followed by the metadata it's given.
+func TestBuildDevcontainerImageMetadata(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("mcr.microsoft.com/devcontainers/base:ubuntu", &imagespec.DockerOCIImageConfig{ImageConfig: ocispec.ImageConfig{
+		Labels: map[string]string{ImageMetadataLabel: `[{"remoteUser":"vscode"}]`},
+	}})
+	c := newFakeClient(t, engine)
+	ctx := context.Background()
+
+	ctxDir := t.TempDir()
+	dockerFile := "Containerfile"
+	assert.Nil(t, os.WriteFile(filepath.Join(ctxDir, dockerFile), []byte("FROM mcr.microsoft.com/devcontainers/base:ubuntu\n"), 0o644))
+	p := &writ.DevcontainerParser{}
+	p.Config.Context = &ctxDir
+	p.Config.DockerFile = &dockerFile
+
+	metadata := ImageMetadata{{"containerEnv": map[string]any{"FOO": "bar"}}}
+	assert.Nil(t, c.BuildDevcontainerImage(ctx, p, "brig-test", ImageOptions{SuppressOutput: true}, metadata))
+	builds := engine.CallsTo("ImageBuild")
+	assert.Len(t, builds, 1)
+	buildOpts, ok := builds[0].Options.(mobyclient.ImageBuildOptions)
+	assert.True(t, ok)
+	assert.JSONEq(t, `[{"remoteUser":"vscode"},{"containerEnv":{"FOO":"bar"}}]`, buildOpts.Labels[ImageMetadataLabel])
+	assert.NotEmpty(t, buildOpts.Labels[BuildHashLabel])
+}
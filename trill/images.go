@@ -0,0 +1,861 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	imagespec "github.com/moby/docker-image-spec/specs-go/v1"
+	"github.com/moby/go-archive"
+	"github.com/moby/go-archive/compression"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+	"github.com/nlsantos/brig/writ"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/term"
+)
+
+// InjectedContainerfileName is the name Containerfiles that lie
+// outside their build context are added to the context archive as.
+const InjectedContainerfileName = ".brig.Containerfile"
+
+// PullPolicy determines whether images are pulled.
+type PullPolicy string
+
+// Supported values for PullPolicy
+const (
+	PullPolicyDefault PullPolicy = ""        // Pull as the caller sees fit
+	PullPolicyAlways  PullPolicy = "always"  // Pull images even if they exist locally, so they're up to date
+	PullPolicyMissing PullPolicy = "missing" // Only pull images that don't exist locally
+	PullPolicyNever   PullPolicy = "never"   // Never pull images; those that don't exist locally are an error
+)
+
+// ParsePullPolicy converts s into a PullPolicy, failing if it isn't
+// one of the supported values. The values Compose accepts for
+// pull_policy are accepted as well: if_not_present is the same as
+// missing, and build, daily, weekly, and every_* only pull images that
+// don't exist locally.
+func ParsePullPolicy(s string) (PullPolicy, error) {
+	switch {
+	case s == string(PullPolicyDefault), s == string(PullPolicyAlways), s == string(PullPolicyMissing), s == string(PullPolicyNever):
+		return PullPolicy(s), nil
+	case s == composetypes.PullPolicyIfNotPresent, s == composetypes.PullPolicyBuild, s == "daily", s == "weekly", strings.HasPrefix(s, "every_"):
+		return PullPolicyMissing, nil
+	default:
+		return "", fmt.Errorf("unsupported pull policy: %s", s)
+	}
+}
+
+// ImageOptions holds the settings common to building and pulling
+// images.
+type ImageOptions struct {
+	PullPolicy      PullPolicy // Whether the image is pulled; if unset, it's determined by SkipIfAvailable and c.PullPolicy
+	SkipIfAvailable bool       // If true, the image is left as-is if it already exists locally
+	SuppressOutput  bool       // If true, build/pull progress isn't printed out
+
+	concurrent bool // Set if other image jobs may be printing out their progress at the same time
+}
+
+// BuildImageOptions holds the settings for building an image.
+type BuildImageOptions struct {
+	ImageOptions
+	ContextPath    string // Path to the build context
+	DockerfilePath string // Path to the Containerfile, relative to ContextPath
+	ImageTag       string // Tag to apply to the built image
+	// If true, the images the Containerfile is based on are pulled
+	// even if they exist locally; ignored if BuildOptions is set
+	PullParent bool
+	// Values for the Containerfile's ARG instructions; ignored if
+	// BuildOptions is set
+	BuildArgs map[string]*string
+	// The stage of a multi-stage Containerfile to build, if not the
+	// last one; ignored if BuildOptions is set
+	Target string
+	// Images to use as build cache, in addition to c.CacheFrom; either
+	// image references or registry cache specifications (i.e.,
+	// type=registry,ref=IMAGE)
+	CacheFrom []string
+	// Labels to apply to the built image, in addition to the ones it
+	// inherits from its base image; ignored if BuildOptions is set
+	Labels map[string]string
+	// Labels identifying what the image was built for (see
+	// ResourceLabels); unlike Labels, they're applied even if
+	// BuildOptions is set, and don't count as build inputs, so they
+	// don't trigger rebuilds
+	ResourceLabels map[string]string
+	// `docker build` command-line flags (e.g., --add-host) to
+	// translate into the equivalent options; see
+	// applyBuildCLIOptions. Ignored if BuildOptions is set
+	CLIOptions []string
+	// Options passed as-is to the engine; if nil, they're derived
+	// from the other fields
+	BuildOptions *mobyclient.ImageBuildOptions
+}
+
+// BuildContainerImage builds the OCI image to be used by the
+// devcontainer.
+//
+// Builds are skipped if the image tagged opts.ImageTag was built from
+// the same inputs, unless c.ForceRebuild is set.
+//
+// TODO: Add a flag to toggle deletion of the context tarball after
+// the creation of the OCI image
+func (c *Client) BuildContainerImage(ctx context.Context, opts BuildImageOptions) (err error) {
+	contextPath, dockerfilePath, imageTag := opts.ContextPath, opts.DockerfilePath, opts.ImageTag
+	buildOpts, suppressOutput := opts.BuildOptions, opts.SuppressOutput
+	switch c.BuildProgress {
+	case BuildProgressQuiet:
+		suppressOutput = true
+	case BuildProgressPlain, BuildProgressTTY:
+		suppressOutput = false
+	}
+	ctx, endSpan := c.startSpan(ctx, "build image "+imageTag)
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%w for %s: %w", ErrImageBuild, imageTag, err)
+		}
+		endSpan(err)
+	}()
+
+	imageCfg, err := c.InspectImage(ctx, imageTag)
+	imageTagAvailable := err == nil && imageCfg != nil
+	if opts.SkipIfAvailable && imageTagAvailable {
+		slog.Info("image tag available locally; skipping building image as instructed", "image", imageTag)
+		return nil
+	}
+
+	excludes, err := buildContextExcludesList(contextPath, dockerfilePath, c.NestedIgnoreFiles)
+	if err != nil {
+		return err
+	}
+
+	if buildOpts == nil {
+		buildOpts = &mobyclient.ImageBuildOptions{
+			// Cloned, as CLIOptions can add to it
+			BuildArgs:  maps.Clone(opts.BuildArgs),
+			CacheFrom:  opts.CacheFrom,
+			Dockerfile: dockerfilePath,
+			Labels:     opts.Labels,
+			Platforms: []ocispec.Platform{{
+				Architecture: c.Platform.Architecture,
+				OS:           c.Platform.OS,
+			}},
+			PullParent:     opts.PullParent,
+			Remove:         true,
+			SuppressOutput: suppressOutput,
+			Tags:           []string{imageTag},
+			Target:         opts.Target,
+		}
+		if err = applyBuildCLIOptions(opts.CLIOptions, buildOpts); err != nil {
+			return err
+		}
+	}
+	buildOpts.SuppressOutput = suppressOutput
+	// Containerfiles outside the build context (e.g., ones
+	// synthesized from dockerfile_inline) are added to the context
+	// archive under a fixed name, so where they're kept doesn't
+	// count as a build input
+	injectedContainerfile := ""
+	if containerfileOutsideContext(contextPath, dockerfilePath) {
+		injectedContainerfile = dockerfilePath
+		if !filepath.IsAbs(injectedContainerfile) {
+			injectedContainerfile = filepath.Join(contextPath, injectedContainerfile)
+		}
+		buildOpts.Dockerfile = InjectedContainerfileName
+	}
+
+	buildHash, err := hashBuildInputs(contextPath, dockerfilePath, excludes, buildOpts)
+	if err != nil {
+		slog.Error("encountered an error while hashing build inputs", "error", err)
+		return err
+	}
+	if imageTagAvailable && !c.ForceRebuild && imageCfg.Labels[BuildHashLabel] == buildHash {
+		slog.Info("build inputs unchanged since the image was last built; skipping build", "image", imageTag, "hash", buildHash)
+		return nil
+	}
+	// Copy the labels so the caller's map (e.g., from a Compose
+	// config) isn't modified
+	labels := mergeLabels(opts.ResourceLabels, buildOpts.Labels)
+	labels[BuildHashLabel] = buildHash
+	buildOpts.Labels = labels
+	// Set after hashing, so changing credentials doesn't trigger a
+	// rebuild
+	if buildOpts.AuthConfigs == nil {
+		buildOpts.AuthConfigs = c.RegistryCredentials.forBuild()
+	}
+	// Likewise with the build cache, which shouldn't affect the
+	// resulting image
+	buildOpts.CacheFrom = c.pullCacheImages(ctx, slices.Concat(buildOpts.CacheFrom, c.CacheFrom))
+	if c.ExportBuildCache {
+		buildArgs := maps.Clone(buildOpts.BuildArgs)
+		if buildArgs == nil {
+			buildArgs = make(map[string]*string)
+		}
+		inlineCache := "1"
+		buildArgs["BUILDKIT_INLINE_CACHE"] = &inlineCache
+		buildOpts.BuildArgs = buildArgs
+	}
+	// As are the proxy settings, which are among the build arguments
+	// the engine predefines; ones set explicitly are left alone
+	if proxyEnv := c.Proxy.Env(proxyDirectHosts...); len(proxyEnv) > 0 {
+		buildArgs := maps.Clone(buildOpts.BuildArgs)
+		if buildArgs == nil {
+			buildArgs = make(map[string]*string)
+		}
+		for name, val := range proxyEnv {
+			if _, ok := buildArgs[name]; !ok {
+				buildArgs[name] = &val
+			}
+		}
+		buildOpts.BuildArgs = buildArgs
+	}
+
+	slog.Debug("building container image", "tag", imageTag, "hash", buildHash)
+	fmt.Printf("Building image and tagging it as %s...\n", imageTag)
+
+	// While it's possible to have the REST API build an OCI image
+	// without having an intermediary tarball, I like having it around
+	// so it's easier to debug issues pertaining to the context
+	// tarball.
+	_, endArchiveSpan := c.startSpan(ctx, "archive build context")
+	contextArchivePath, err := buildContextArchive(contextPath, excludes, []string{}, injectedContainerfile)
+	endArchiveSpan(err)
+	if err != nil {
+		return err
+	}
+	contextArchive, err := os.Open(contextArchivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			return
+		}
+
+		// contextArchive is closed automatically by the ImageBuild
+		// API call
+		if err = os.Remove(contextArchive.Name()); err != nil {
+			slog.Error("failed cleaning up context archive", "path", contextArchive.Name(), "error", err)
+			return
+		}
+	}()
+	buildOpts.Context = contextArchive
+
+	buildResp, err := c.mobyClient.ImageBuild(ctx, contextArchive, *buildOpts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			return
+		}
+
+		if err = buildResp.Body.Close(); err != nil {
+			slog.Error("could not close build response", "error", err)
+		}
+	}()
+
+	if suppressOutput {
+		fmt.Printf("Building image using %s...\n", buildOpts.Dockerfile)
+	}
+
+	renderer, closeRenderer := c.newBuildRenderer(imageTag, suppressOutput)
+	defer closeRenderer()
+	decoder := json.NewDecoder(buildResp.Body)
+	var buildErr string
+	for {
+		var msg struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+
+		if err = decoder.Decode(&msg); err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			slog.Error("error decoding JSON", "context", err)
+			return err
+		}
+
+		if msg.Stream != "" && !suppressOutput {
+			renderer.Stream(msg.Stream)
+		}
+		if msg.Error != "" {
+			renderer.Error(msg.Error)
+			buildErr = msg.Error
+		}
+	}
+	if len(buildErr) > 0 {
+		// The engine reports a failed build in the response stream
+		// rather than through the API call itself
+		return errors.New(buildErr)
+	}
+
+	return err
+}
+
+// BuildDevcontainerImage builds an OCI image based on options in a
+// devcontainer.json.
+//
+// If metadata is non-nil, it's appended to the metadata the image
+// inherits from the image its Containerfile is based on, and recorded
+// in the image; see ImageMetadataLabel.
+//
+// This is a very thin wrapper over BuildContainerImage.
+func (c *Client) BuildDevcontainerImage(ctx context.Context, p *writ.DevcontainerParser, imageTag string, opts ImageOptions, metadata ImageMetadata) error {
+	var labels map[string]string
+	// Skip looking up the base image if the build is going to be
+	// skipped anyway
+	if metadata != nil && !(opts.SkipIfAvailable && c.IsImageTagAvailable(ctx, imageTag)) {
+		inherited, err := c.containerfileMetadata(ctx, p, opts)
+		if err != nil {
+			return fmt.Errorf("%w for %s: %w", ErrImageBuild, imageTag, err)
+		}
+		label, err := append(inherited, metadata...).Label()
+		if err != nil {
+			return fmt.Errorf("%w for %s: %w", ErrImageBuild, imageTag, err)
+		}
+		labels = map[string]string{ImageMetadataLabel: label}
+	}
+	var cacheFrom, cliOptions []string
+	if p.Config.Build != nil {
+		if p.Config.Build.CacheFrom != nil {
+			if p.Config.Build.CacheFrom.String != nil {
+				cacheFrom = append(cacheFrom, *p.Config.Build.CacheFrom.String)
+			}
+			cacheFrom = append(cacheFrom, p.Config.Build.CacheFrom.StringArray...)
+		}
+		cliOptions = p.Config.Build.Options
+	}
+	buildArgs, target := devcontainerBuildArgs(p)
+	return c.BuildContainerImage(ctx, BuildImageOptions{
+		ImageOptions:   opts,
+		ContextPath:    *p.Config.Context,
+		DockerfilePath: *p.Config.DockerFile,
+		ImageTag:       imageTag,
+		PullParent:     c.PullPolicy == PullPolicyAlways,
+		BuildArgs:      buildArgs,
+		Target:         target,
+		CacheFrom:      cacheFrom,
+		CLIOptions:     cliOptions,
+		Labels:         labels,
+		ResourceLabels: c.ResourceLabels(p),
+	})
+}
+
+// pullCacheImages pulls the images in cacheFrom so builds can use them
+// as cache, and returns the references of the ones that were pulled.
+//
+// Besides image references, cacheFrom may hold registry cache
+// specifications (i.e., type=registry,ref=IMAGE), as accepted by
+// docker build's --cache-from. Other kinds of cache aren't supported
+// by the engine's API, and are skipped, as are images that can't be
+// pulled (e.g., the first time a cache is used, when there's nothing
+// to pull yet).
+func (c *Client) pullCacheImages(ctx context.Context, cacheFrom []string) (cacheImages []string) {
+	for _, spec := range cacheFrom {
+		imageRef, ok := CacheImageRef(spec)
+		if !ok {
+			slog.Warn("unsupported build cache; skipping", "cache", spec)
+			continue
+		}
+		if slices.Contains(cacheImages, imageRef) {
+			continue
+		}
+		if err := c.PullContainerImage(ctx, imageRef, ImageOptions{SuppressOutput: true}); err != nil {
+			slog.Warn("could not pull build cache image; skipping", "image", imageRef, "error", err)
+			continue
+		}
+		cacheImages = append(cacheImages, imageRef)
+	}
+	return cacheImages
+}
+
+// CacheImageRef returns the image referenced by a --cache-from-style
+// specification: either an image reference, or a comma-separated list
+// of key=value pairs where type is registry and ref is the image.
+func CacheImageRef(spec string) (string, bool) {
+	if !strings.Contains(spec, "=") {
+		return spec, len(spec) > 0
+	}
+	var cacheType, imageRef string
+	for field := range strings.SplitSeq(spec, ",") {
+		key, val, _ := strings.Cut(field, "=")
+		switch strings.TrimSpace(key) {
+		case "type":
+			cacheType = strings.TrimSpace(val)
+		case "ref":
+			imageRef = strings.TrimSpace(val)
+		}
+	}
+	return imageRef, cacheType == "registry" && len(imageRef) > 0
+}
+
+// InspectImageID returns the ID the container runtime assigned to
+// the image tagged imageTag.
+func (c *Client) InspectImageID(ctx context.Context, imageTag string) (string, error) {
+	inspectResp, err := c.mobyClient.ImageInspect(ctx, imageTag)
+	if err != nil {
+		return "", err
+	}
+	return inspectResp.ID, nil
+}
+
+// InspectImage is a very thin wrapper around the ImageInspect API
+// call.
+func (c *Client) InspectImage(ctx context.Context, imageTag string) (imageCfg *imagespec.DockerOCIImageConfig, err error) {
+	inspectResp, err := c.mobyClient.ImageInspect(ctx, imageTag)
+	if err != nil {
+		return nil, err
+	}
+	return inspectResp.Config, nil
+}
+
+// IsImageTagAvailable returns whether or not the container runtime
+// already has an image tagged imageTag.
+func (c *Client) IsImageTagAvailable(ctx context.Context, imageTag string) bool {
+	imageCfg, err := c.InspectImage(ctx, imageTag)
+	return err == nil && imageCfg != nil
+}
+
+// PushContainerImage tags the image tagged imageTag as ref, then
+// pushes it to ref's registry.
+//
+// If c.RegistryCredentials has an entry for the registry, it's used to
+// authenticate the push.
+func (c *Client) PushContainerImage(ctx context.Context, imageTag string, ref string, opts ImageOptions) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%w for %s: %w", ErrImagePush, ref, err)
+		}
+	}()
+
+	if ref != imageTag {
+		if _, err = c.mobyClient.ImageTag(ctx, mobyclient.ImageTagOptions{Source: imageTag, Target: ref}); err != nil {
+			return err
+		}
+	}
+
+	slog.Debug("pushing image tag to remote registry", "tag", ref)
+	fmt.Printf("Pushing %s to remote registry...\n", ref)
+	pushResp, err := c.mobyClient.ImagePush(ctx, ref, mobyclient.ImagePushOptions{
+		RegistryAuth: c.RegistryCredentials.forImage(ref),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := pushResp.Close(); err != nil {
+			slog.Error("could not close push response", "error", err)
+		}
+	}()
+
+	if opts.SuppressOutput {
+		return pushResp.Wait(ctx)
+	}
+	stdoutFd := os.Stdout.Fd()
+	isTerm, streamWriter, closeStreamWriter := c.imageJobOutput(opts, "PUSH", ref)
+	defer closeStreamWriter()
+	if err = jsonmessage.DisplayJSONMessagesStream(pushResp, streamWriter, stdoutFd, isTerm, nil); err != nil {
+		slog.Error("error encountered while pushing image", "tag", ref, "error", err)
+	}
+	return err
+}
+
+// imageJobOutput returns where the progress of a pull/push (action)
+// of the image ref is written to, and whether it's drawn as it would
+// be on a terminal. The returned function has to be called once the
+// job is over.
+//
+// Progress bars are only drawn if stdout is a terminal, and no other
+// image jobs are running alongside it (see ImageOptions.concurrent);
+// otherwise, it's written through c.output a whole line at a time.
+func (c *Client) imageJobOutput(opts ImageOptions, action string, ref string) (bool, io.Writer, func()) {
+	if !opts.concurrent && term.IsTerminal(int(os.Stdout.Fd())) {
+		return true, NewPrefixedStreamWriter(os.Stdout, action, ref), func() {}
+	}
+	w := c.output.newWriter(outputPrefix(action, ref))
+	return false, w, func() {
+		if err := w.Close(); err != nil {
+			slog.Error("could not write out the rest of the progress", "action", action, "ref", ref, "error", err)
+		}
+	}
+}
+
+// pullPolicy returns the policy a pull with opts follows: the one set
+// in opts, if any; otherwise, c.PullPolicy, unless opts.SkipIfAvailable
+// calls for a less eager one. Without either, images are always
+// pulled.
+func (c *Client) pullPolicy(opts ImageOptions) PullPolicy {
+	switch {
+	case opts.PullPolicy != PullPolicyDefault:
+		return opts.PullPolicy
+	case c.PullPolicy == PullPolicyNever:
+		return PullPolicyNever
+	case opts.SkipIfAvailable:
+		return PullPolicyMissing
+	case c.PullPolicy != PullPolicyDefault:
+		return c.PullPolicy
+	default:
+		return PullPolicyAlways
+	}
+}
+
+// PullContainerImage pulls the OCI image from a remtoe registry so it
+// can be used in the creation of a devcontainer, as the pull policy
+// allows; see pullPolicy.
+//
+// If c.RegistryCredentials has an entry for the image's registry,
+// it's used to authenticate the pull.
+func (c *Client) PullContainerImage(ctx context.Context, imageTag string, opts ImageOptions) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%w for %s: %w", ErrImagePull, imageTag, err)
+		}
+	}()
+
+	imageTagAvailable := c.IsImageTagAvailable(ctx, imageTag)
+	switch c.pullPolicy(opts) {
+	case PullPolicyNever:
+		if !imageTagAvailable {
+			return fmt.Errorf("image not available locally, and the pull policy is %s", PullPolicyNever)
+		}
+		slog.Info("image tag available locally; not pulling image as per pull policy", "image", imageTag)
+		return nil
+
+	case PullPolicyMissing:
+		if imageTagAvailable {
+			slog.Info("image tag available locally; skipping pulling image as instructed", "image", imageTag)
+			return nil
+		}
+	}
+
+	_, endSpan := c.startSpan(ctx, "pull image "+imageTag)
+	defer func() { endSpan(err) }()
+	slog.Debug("pulling image tag from remote registry", "tag", imageTag)
+	fmt.Printf("Pulling %s from remote registry...\n", imageTag)
+	pullResp, err := c.mobyClient.ImagePull(ctx, imageTag, mobyclient.ImagePullOptions{
+		Platforms: []ocispec.Platform{{
+			Architecture: c.Platform.Architecture,
+			OS:           c.Platform.OS,
+		}},
+		RegistryAuth: c.RegistryCredentials.forImage(imageTag),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			return
+		}
+
+		if err := pullResp.Close(); err != nil {
+			slog.Error("could not close pull response", "error", err)
+		}
+	}()
+
+	if opts.SuppressOutput {
+		if err := pullResp.Wait(ctx); err != nil {
+			return err
+		}
+	} else {
+		stdoutFd := os.Stdout.Fd()
+		isTerm, streamWriter, closeStreamWriter := c.imageJobOutput(opts, "PULL", imageTag)
+		defer closeStreamWriter()
+		if err := jsonmessage.DisplayJSONMessagesStream(pullResp, streamWriter, stdoutFd, isTerm, nil); err != nil {
+			slog.Error("error encountered while pulling image", "tag", imageTag, "error", err)
+			return err
+		}
+	}
+
+	return err
+}
+
+// ignoreFileNames is the list of file names that are checked for
+// exclusion patterns when creating the context tarball, in order of
+// precedence.
+var ignoreFileNames = []string{".containerignore", ".dockerignore"}
+
+// buildContextExcludesList builds a list of files to be excluded in
+// the creation of the context tarball.
+//
+// Requires ctxDir, the path of the context directory to search
+// .containerignore/.dockerignore in, and dockerfilePath, the path to
+// the Containerfile used for the build (relative to ctxDir, unless
+// absolute).
+//
+// Mirroring the behavior of the engines, an ignore file named after
+// the Containerfile (e.g., Containerfile.containerignore or
+// Dockerfile.dockerignore) in the same directory as the Containerfile
+// takes precedence over the one at the root of the context directory.
+//
+// If walkNested is true, the context directory is also walked for
+// ignore files in its subdirectories; the patterns they contain are
+// treated as relative to the directory they reside in. Directories
+// excluded by patterns found higher up the tree are not descended
+// into.
+func buildContextExcludesList(ctxDir string, dockerfilePath string, walkNested bool) ([]string, error) {
+	slog.Debug("checking for .containerignore/.dockerignore in context directory")
+	var ignoreFile string
+	if len(dockerfilePath) > 0 {
+		if !filepath.IsAbs(dockerfilePath) {
+			dockerfilePath = filepath.Join(ctxDir, dockerfilePath)
+		}
+		var dockerfileIgnoreNames []string
+		for _, ignoreFileName := range ignoreFileNames {
+			dockerfileIgnoreNames = append(dockerfileIgnoreNames, filepath.Base(dockerfilePath)+ignoreFileName)
+		}
+		ignoreFile = findIgnoreFile(filepath.Dir(dockerfilePath), dockerfileIgnoreNames)
+	}
+	if len(ignoreFile) == 0 {
+		ignoreFile = findIgnoreFile(ctxDir, ignoreFileNames)
+	}
+
+	var excludes []string
+	if len(ignoreFile) > 0 {
+		patterns, err := readIgnoreFile(ignoreFile)
+		if err != nil {
+			return nil, err
+		}
+		excludes = append(excludes, patterns...)
+	}
+
+	if walkNested {
+		nestedExcludes, err := buildNestedExcludesList(ctxDir, excludes)
+		if err != nil {
+			return nil, err
+		}
+		excludes = append(excludes, nestedExcludes...)
+	}
+
+	slog.Debug(fmt.Sprintf("applying %d exclusion patterns", len(excludes)))
+	return excludes, nil
+}
+
+// buildNestedExcludesList walks ctxDir looking for ignore files in
+// its subdirectories, and returns the patterns they contain rebased
+// so they're relative to ctxDir.
+//
+// excludes is the list of patterns already in effect; directories
+// matching it are skipped, as are any ignore files they contain.
+func buildNestedExcludesList(ctxDir string, excludes []string) ([]string, error) {
+	pm, err := patternmatcher.New(excludes)
+	if err != nil {
+		return nil, err
+	}
+
+	var nestedExcludes []string
+	err = filepath.WalkDir(ctxDir, func(dirPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || dirPath == ctxDir {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(ctxDir, dirPath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if excluded, err := pm.MatchesOrParentMatches(relPath); err != nil {
+			return err
+		} else if excluded && !pm.Exclusions() {
+			// Only skip the directory outright if there are no
+			// exclusion patterns (i.e., !-prefixed patterns) that
+			// could reinclude files underneath it
+			return filepath.SkipDir
+		}
+
+		ignoreFile := findIgnoreFile(dirPath, ignoreFileNames)
+		if len(ignoreFile) == 0 {
+			return nil
+		}
+		slog.Debug("found nested ignore file", "path", ignoreFile)
+		patterns, err := readIgnoreFile(ignoreFile)
+		if err != nil {
+			return err
+		}
+		for _, pattern := range patterns {
+			nestedExcludes = append(nestedExcludes, rebaseIgnorePattern(relPath, pattern))
+		}
+		return nil
+	})
+
+	return nestedExcludes, err
+}
+
+// findIgnoreFile returns the path to the first file in names that
+// exists inside dir, or an empty string if none of them do.
+func findIgnoreFile(dir string, names []string) string {
+	for _, name := range names {
+		ignoreFile := filepath.Join(dir, name)
+		if info, err := os.Stat(ignoreFile); err == nil && !info.IsDir() {
+			return ignoreFile
+		}
+	}
+	return ""
+}
+
+// readIgnoreFile returns the list of patterns contained in the ignore
+// file at ignoreFile.
+func readIgnoreFile(ignoreFile string) (excludes []string, err error) {
+	f, err := os.Open(ignoreFile)
+	if err != nil {
+		slog.Error(fmt.Sprintf("error opening %s; %v", ignoreFile, err))
+		return nil, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			slog.Error("could not close ignore file handle", "error", err)
+		}
+	}()
+
+	if excludes, err = ignorefile.ReadAll(f); err != nil {
+		slog.Error(fmt.Sprintf("error parsing %s; %v", ignoreFile, err))
+		return nil, err
+	}
+	return excludes, nil
+}
+
+// rebaseIgnorePattern prefixes pattern with dir, taking care to keep
+// exclusion (i.e., !-prefixed) patterns as such.
+func rebaseIgnorePattern(dir string, pattern string) string {
+	if strings.HasPrefix(pattern, "!") {
+		return "!" + path.Join(dir, strings.TrimPrefix(pattern, "!"))
+	}
+	return path.Join(dir, pattern)
+}
+
+// buildContextArchive gathers the context directory into a tarball.
+//
+// Creates a tarball rooted at ctxDir and returns the path to the
+// created file if successful. If any errors are encountered, returns
+// an empty string and the error.
+//
+// If injectedContainerfile is non-empty, the file it points to is
+// added to the tarball as InjectedContainerfileName, replacing any
+// file of that name in ctxDir.
+//
+// The created file is guaranteed to be unique in the system at the
+// time of creation.
+//
+// While it's possible to build an OCI image without an intermediary
+// file, having it makes it easier to debug issues related to the
+// context tarball.
+func buildContextArchive(ctxDir string, excludes []string, includeFiles []string, injectedContainerfile string) (string, error) {
+	tempFile, err := os.CreateTemp("", fmt.Sprintf(".ctx-%s-*.tar.gz", filepath.Base(ctxDir)))
+	if err != nil {
+		return "", err
+	}
+	slog.Debug(fmt.Sprintf("building a context archive for the container as %s", tempFile.Name()))
+	defer func() {
+		if err := tempFile.Close(); err != nil {
+			slog.Error("could not close tempfile", "error", err)
+		}
+	}()
+
+	tarOpts := &archive.TarOptions{
+		// Assign ownership of files to root so we don't run into
+		// namespace mapping issues when using Podman.
+		//
+		// TODO: Switch this over to the value of remoteUser if
+		// specified in the devcontainer config.
+		ChownOpts: &archive.ChownOpts{
+			UID: 0,
+			GID: 0,
+		},
+		Compression:      compression.None,
+		ExcludePatterns:  excludes,
+		IncludeFiles:     includeFiles,
+		IncludeSourceDir: false,
+		NoLchown:         true,
+	}
+
+	ctxReader, err := archive.TarWithOptions(ctxDir, tarOpts)
+	if err != nil {
+		return "", err
+	}
+	if len(injectedContainerfile) > 0 {
+		contents, err := os.ReadFile(injectedContainerfile)
+		if err != nil {
+			_ = ctxReader.Close()
+			return "", err
+		}
+		ctxReader = archive.ReplaceFileTarWrapper(ctxReader, map[string]archive.TarModifierFunc{
+			InjectedContainerfileName: func(_ string, _ *tar.Header, _ io.Reader) (*tar.Header, []byte, error) {
+				return &tar.Header{
+					Typeflag: tar.TypeReg,
+					Mode:     0o644,
+					ModTime:  time.Now(),
+				}, contents, nil
+			},
+		})
+	}
+	defer ctxReader.Close()
+
+	gzipWriter, err := compression.CompressStream(tempFile, compression.Gzip)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(gzipWriter, ctxReader)
+	if err = errors.Join(err, gzipWriter.Close()); err == nil {
+		return tempFile.Name(), err
+	}
+	return "", err
+}
+
+// containerfileOutsideContext reports whether the Containerfile at
+// dockerfilePath (relative to ctxDir, unless it's absolute) lies
+// outside ctxDir, in which case the engine can't read it from the
+// context archive unless it's added to it.
+func containerfileOutsideContext(ctxDir string, dockerfilePath string) bool {
+	if len(dockerfilePath) == 0 {
+		return false
+	}
+	if !filepath.IsAbs(dockerfilePath) {
+		dockerfilePath = filepath.Join(ctxDir, dockerfilePath)
+	}
+	absCtxDir, err := filepath.Abs(ctxDir)
+	if err != nil {
+		return false
+	}
+	absDockerfilePath, err := filepath.Abs(dockerfilePath)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absCtxDir, absDockerfilePath)
+	return err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,339 @@
+package trill
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBuildContextExcludesList checks that the ignore file at the
+// root of the context directory is used by default.
+func TestBuildContextExcludesList(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	excludes, err := buildContextExcludesList(filepath.Join("testdata", "ignore"), "Dockerfile", false)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"*.log", "skipped"}, excludes)
+}
+
+// TestBuildContextExcludesListDockerfileSpecific checks that an
+// ignore file named after the Containerfile takes precedence over
+// the one at the root of the context directory.
+func TestBuildContextExcludesListDockerfileSpecific(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	excludes, err := buildContextExcludesList(filepath.Join("testdata", "ignore"), "Containerfile", false)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"secrets"}, excludes)
+}
+
+// TestBuildContextExcludesListNested checks that ignore files in
+// subdirectories are honored when requested, and that their patterns
+// are rebased onto the directory they reside in.
+func TestBuildContextExcludesListNested(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	excludes, err := buildContextExcludesList(filepath.Join("testdata", "ignore"), "Dockerfile", true)
+	assert.Nil(t, err)
+	// skipped/.dockerignore shouldn't be read as its directory is
+	// already excluded
+	assert.EqualValues(t, []string{"*.log", "skipped", "sub/build", "!sub/build/keep"}, excludes)
+}
+
+// TestCacheImageRef checks that image references and registry cache
+// specifications are accepted, and other kinds of cache aren't.
+func TestCacheImageRef(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for spec, expected := range map[string]string{
+		"ghcr.io/example/app:cache":                  "ghcr.io/example/app:cache",
+		"type=registry,ref=ghcr.io/example/app:dev":  "ghcr.io/example/app:dev",
+		"ref=ghcr.io/example/app:dev, type=registry": "ghcr.io/example/app:dev",
+	} {
+		ref, ok := CacheImageRef(spec)
+		assert.True(t, ok, spec)
+		assert.Equal(t, expected, ref, spec)
+	}
+	for _, spec := range []string{"", "type=local,src=/tmp/cache", "type=gha", "type=registry"} {
+		_, ok := CacheImageRef(spec)
+		assert.False(t, ok, spec)
+	}
+}
+
+// TestBuildContainerImageCache checks that cache images are pulled
+// before building, that the ones that can't be used are left out, and
+// that cache metadata is embedded in the image when it's to be
+// exported.
+func TestBuildContainerImageCache(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	engine.FailOn("ImagePull:ghcr.io/example/app:missing", errors.New("manifest unknown"))
+	c := newFakeClient(t, engine)
+	c.CacheFrom = []string{"ghcr.io/example/app:cache", "type=gha"}
+	c.ExportBuildCache = true
+	ctx := context.Background()
+
+	ctxDir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(ctxDir, "Containerfile"), []byte("FROM scratch\n"), 0o644))
+	assert.Nil(t, c.BuildContainerImage(ctx, BuildImageOptions{
+		ImageOptions:   ImageOptions{SuppressOutput: true},
+		ContextPath:    ctxDir,
+		DockerfilePath: "Containerfile",
+		ImageTag:       "brig-test",
+		CacheFrom:      []string{"type=registry,ref=ghcr.io/example/app:main", "ghcr.io/example/app:missing", "ghcr.io/example/app:cache"},
+	}))
+
+	builds := engine.CallsTo("ImageBuild")
+	assert.Len(t, builds, 1)
+	buildOpts, ok := builds[0].Options.(mobyclient.ImageBuildOptions)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"ghcr.io/example/app:main", "ghcr.io/example/app:cache"}, buildOpts.CacheFrom)
+	assert.Equal(t, "1", *buildOpts.BuildArgs["BUILDKIT_INLINE_CACHE"])
+	assert.True(t, engine.HasImage("ghcr.io/example/app:main"))
+}
+
+// TestBuildDevcontainerImageOptions checks that build.options make it
+// into the build, and that the build is refused if any of them can't.
+func TestBuildDevcontainerImageOptions(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	c := newFakeClient(t, engine)
+	ctx := context.Background()
+
+	ctxDir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(ctxDir, "Containerfile"), []byte("FROM scratch\n"), 0o644))
+	containerfile := "Containerfile"
+	p := &writ.DevcontainerParser{}
+	p.Config.Context = &ctxDir
+	p.Config.DockerFile = &containerfile
+	p.Config.Build = &writ.BuildOptions{Options: []string{"--add-host=db:10.0.0.2", "--network", "host"}}
+	assert.Nil(t, c.BuildDevcontainerImage(ctx, p, "brig-test", ImageOptions{SuppressOutput: true}, nil))
+
+	builds := engine.CallsTo("ImageBuild")
+	assert.Len(t, builds, 1)
+	buildOpts, ok := builds[0].Options.(mobyclient.ImageBuildOptions)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"db:10.0.0.2"}, buildOpts.ExtraHosts)
+	assert.Equal(t, "host", buildOpts.NetworkMode)
+
+	p.Config.Build.Options = append(p.Config.Build.Options, "--ssh=default")
+	err := c.BuildDevcontainerImage(ctx, p, "brig-test", ImageOptions{SuppressOutput: true}, nil)
+	assert.ErrorIs(t, err, ErrImageBuild)
+	assert.ErrorIs(t, err, ErrUnsupportedBuildOption)
+	assert.Len(t, engine.CallsTo("ImageBuild"), 1)
+}
+
+// TestBuildDevcontainerImageArgs checks that build.args and
+// build.target make it into the build, and that build.options can
+// override the former.
+func TestBuildDevcontainerImageArgs(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	c := newFakeClient(t, engine)
+	ctx := context.Background()
+
+	ctxDir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(ctxDir, "Containerfile"), []byte("FROM scratch AS dev\nFROM scratch\n"), 0o644))
+	containerfile := "Containerfile"
+	target := "dev"
+	p := &writ.DevcontainerParser{}
+	p.Config.Context = &ctxDir
+	p.Config.DockerFile = &containerfile
+	p.Config.Build = &writ.BuildOptions{
+		Args:    map[string]string{"GO_VERSION": "1.25", "MODE": "debug"},
+		Target:  &target,
+		Options: []string{"--build-arg", "MODE=release"},
+	}
+	assert.Nil(t, c.BuildDevcontainerImage(ctx, p, "brig-test", ImageOptions{SuppressOutput: true}, nil))
+
+	builds := engine.CallsTo("ImageBuild")
+	assert.Len(t, builds, 1)
+	buildOpts, ok := builds[0].Options.(mobyclient.ImageBuildOptions)
+	assert.True(t, ok)
+	if assert.Len(t, buildOpts.BuildArgs, 2) {
+		assert.Equal(t, "1.25", *buildOpts.BuildArgs["GO_VERSION"])
+		assert.Equal(t, "release", *buildOpts.BuildArgs["MODE"])
+	}
+	assert.Equal(t, "dev", buildOpts.Target)
+	// The override didn't leak into the configuration
+	assert.Equal(t, "debug", p.Config.Build.Args["MODE"])
+
+	// Changing an argument counts as a change in the build's inputs
+	p.Config.Build.Args["GO_VERSION"] = "1.26"
+	assert.Nil(t, c.BuildDevcontainerImage(ctx, p, "brig-test", ImageOptions{SuppressOutput: true}, nil))
+	assert.Len(t, engine.CallsTo("ImageBuild"), 2)
+}
+
+// TestBuildContainerImageResourceLabels checks that built images are
+// labeled as brig's, and that a change in those labels alone doesn't
+// trigger a rebuild.
+func TestBuildContainerImageResourceLabels(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	c := newFakeClient(t, engine)
+	c.Version = "1.0.0"
+	ctx := context.Background()
+
+	ctxDir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(ctxDir, "Containerfile"), []byte("FROM scratch\n"), 0o644))
+	buildOpts := BuildImageOptions{
+		ImageOptions:   ImageOptions{SuppressOutput: true},
+		ContextPath:    ctxDir,
+		DockerfilePath: "Containerfile",
+		ImageTag:       "brig-test",
+		ResourceLabels: c.ResourceLabels(nil),
+	}
+	assert.Nil(t, c.BuildContainerImage(ctx, buildOpts))
+	imageCfg, err := c.InspectImage(ctx, "brig-test")
+	assert.Nil(t, err)
+	assert.Equal(t, "1.0.0", imageCfg.Labels[VersionLabel])
+	assert.NotEmpty(t, imageCfg.Labels[BuildHashLabel])
+
+	c.Version = "1.0.1"
+	buildOpts.ResourceLabels = c.ResourceLabels(nil)
+	assert.Nil(t, c.BuildContainerImage(ctx, buildOpts))
+	assert.Len(t, engine.CallsTo("ImageBuild"), 1)
+}
+
+// TestPushContainerImage checks that an image is tagged with the
+// reference it's pushed to.
+func TestPushContainerImage(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("brig-test", nil)
+	c := newFakeClient(t, engine)
+	ctx := context.Background()
+
+	assert.Nil(t, c.PushContainerImage(ctx, "brig-test", "ghcr.io/example/app:cache", ImageOptions{SuppressOutput: true}))
+	assert.True(t, engine.HasImage("ghcr.io/example/app:cache"))
+	pushes := engine.CallsTo("ImagePush")
+	assert.Len(t, pushes, 1)
+	assert.Equal(t, "ghcr.io/example/app:cache", pushes[0].Target)
+
+	assert.ErrorIs(t, c.PushContainerImage(ctx, "missing", "ghcr.io/example/app:cache", ImageOptions{SuppressOutput: true}), ErrImagePush)
+}
+
+// TestPullPolicy checks that images are only pulled as the pull policy
+// allows, and that the policy set for a pull takes precedence over
+// the client's.
+func TestPullPolicy(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for value, expected := range map[string]PullPolicy{
+		"":               PullPolicyDefault,
+		"always":         PullPolicyAlways,
+		"missing":        PullPolicyMissing,
+		"if_not_present": PullPolicyMissing,
+		"every_12h":      PullPolicyMissing,
+		"never":          PullPolicyNever,
+	} {
+		policy, err := ParsePullPolicy(value)
+		assert.Nil(t, err, value)
+		assert.Equal(t, expected, policy, value)
+	}
+	_, err := ParsePullPolicy("sometimes")
+	assert.NotNil(t, err)
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("alpine:3", nil)
+	c := newFakeClient(t, engine)
+	ctx := context.Background()
+	quiet := ImageOptions{SuppressOutput: true}
+	pulls := func() int { return len(engine.CallsTo("ImagePull")) }
+
+	assert.Nil(t, c.PullContainerImage(ctx, "alpine:3", quiet))
+	assert.Equal(t, 1, pulls())
+
+	c.PullPolicy = PullPolicyMissing
+	assert.Nil(t, c.PullContainerImage(ctx, "alpine:3", quiet))
+	assert.Equal(t, 1, pulls())
+	assert.Nil(t, c.PullContainerImage(ctx, "alpine:3", ImageOptions{PullPolicy: PullPolicyAlways, SuppressOutput: true}))
+	assert.Equal(t, 2, pulls())
+
+	c.PullPolicy = PullPolicyNever
+	assert.Nil(t, c.PullContainerImage(ctx, "alpine:3", ImageOptions{SkipIfAvailable: true, SuppressOutput: true}))
+	assert.ErrorIs(t, c.PullContainerImage(ctx, "debian:13", ImageOptions{SkipIfAvailable: true, SuppressOutput: true}), ErrImagePull)
+	assert.Equal(t, 2, pulls())
+	assert.False(t, engine.HasImage("debian:13"))
+}
+
+// TestContainerfileOutsideContext checks that only Containerfiles
+// outside the build context are reported as such.
+func TestContainerfileOutsideContext(t *testing.T) {
+	ctxDir := filepath.Join("testdata", "ignore")
+	assert.False(t, containerfileOutsideContext(ctxDir, ""))
+	assert.False(t, containerfileOutsideContext(ctxDir, "Containerfile"))
+	assert.False(t, containerfileOutsideContext(ctxDir, filepath.Join("sub", "..", "Containerfile")))
+	assert.False(t, containerfileOutsideContext(ctxDir, "..Containerfile"))
+	assert.True(t, containerfileOutsideContext(ctxDir, filepath.Join("..", "Containerfile")))
+	assert.True(t, containerfileOutsideContext(ctxDir, filepath.Join(t.TempDir(), "Containerfile")))
+}
+
+// TestBuildContextArchiveInjectedContainerfile checks that a
+// Containerfile from outside the build context is added to the
+// archive, and that the context's own Containerfile is left alone.
+func TestBuildContextArchiveInjectedContainerfile(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctxDir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(ctxDir, "Containerfile"), []byte("FROM project\n"), 0o644))
+	injected := filepath.Join(t.TempDir(), "app.Containerfile")
+	assert.Nil(t, os.WriteFile(injected, []byte("FROM inline\n"), 0o644))
+
+	archivePath, err := buildContextArchive(ctxDir, nil, []string{}, injected)
+	assert.Nil(t, err)
+	defer os.Remove(archivePath)
+
+	f, err := os.Open(archivePath)
+	assert.Nil(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.Nil(t, err)
+	contents := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.Nil(t, err)
+		data, err := io.ReadAll(tr)
+		assert.Nil(t, err)
+		contents[hdr.Name] = string(data)
+	}
+	assert.Equal(t, map[string]string{
+		"Containerfile":           "FROM project\n",
+		InjectedContainerfileName: "FROM inline\n",
+	}, contents)
+
+	projectContainerfile, err := os.ReadFile(filepath.Join(ctxDir, "Containerfile"))
+	assert.Nil(t, err)
+	assert.Equal(t, "FROM project\n", string(projectContainerfile))
+}
@@ -0,0 +1,147 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"maps"
+
+	"github.com/nlsantos/brig/writ"
+)
+
+// Labels applied to the containers, images, networks, and volumes
+// trill creates, so they can be found (e.g., with `docker ps --filter
+// label=io.github.nlsantos.brig.version`) and correlated across runs.
+//
+// Images pulled as-is aren't labeled, as they're not created by
+// trill.
+const (
+	// DevcontainerIDLabel holds the ID of the devcontainer the
+	// resource was created for (i.e., ${devcontainerId})
+	DevcontainerIDLabel string = "io.github.nlsantos.brig.devcontainer-id"
+	// VersionLabel holds the version of the program that created
+	// the resource; every resource trill creates has it
+	VersionLabel string = "io.github.nlsantos.brig.version"
+	// WorkspaceHashLabel holds the SHA-256 hash of the absolute path
+	// to the workspace the devcontainer was created for, which is
+	// easier to filter on than the path itself
+	WorkspaceHashLabel string = "io.github.nlsantos.brig.workspace-hash"
+	// ComposeProjectLabel holds the name of the Compose project the
+	// resource belongs to, if any
+	ComposeProjectLabel string = "io.github.nlsantos.brig.compose-project"
+	// ComposeServiceLabel holds the name of the Compose service a
+	// container was created for
+	ComposeServiceLabel string = "io.github.nlsantos.brig.compose-service"
+	// ComposeReplicaLabel holds the number of the replica of its
+	// Compose service a container is, counting from 1
+	ComposeReplicaLabel string = "io.github.nlsantos.brig.compose-replica"
+)
+
+// scopedNameHashLength is how many characters of the workspace's hash
+// are appended to the names of resources scoped to a workspace.
+const scopedNameHashLength int = 8
+
+// unknownVersion is the value of VersionLabel when c.Version isn't
+// set.
+const unknownVersion string = "unknown"
+
+// ResourceLabels returns the labels applied to the resources created
+// for the devcontainer described by p, which may be nil if the
+// resource isn't tied to a devcontainer.
+//
+// Besides the labels brig defines, these include the labels the spec
+// derives the devcontainer's ID from (writ.LocalFolderLabel and
+// writ.ConfigFileLabel), which other tools look for.
+func (c *Client) ResourceLabels(p *writ.DevcontainerParser) map[string]string {
+	labels := make(map[string]string)
+	if p != nil {
+		idLabels, err := p.IDLabels()
+		if err != nil {
+			slog.Warn("unable to determine the labels identifying the devcontainer", "error", err)
+		}
+		maps.Copy(labels, idLabels)
+		if localFolder, ok := labels[writ.LocalFolderLabel]; ok {
+			hash := sha256.Sum256([]byte(localFolder))
+			labels[WorkspaceHashLabel] = hex.EncodeToString(hash[:])
+		}
+		if p.DevcontainerID != nil {
+			labels[DevcontainerIDLabel] = *p.DevcontainerID
+		}
+	}
+	if c.composerProject != nil {
+		labels[ComposeProjectLabel] = c.composerProject.Name
+	}
+	labels[VersionLabel] = c.Version
+	if len(c.Version) == 0 {
+		labels[VersionLabel] = unknownVersion
+	}
+	return labels
+}
+
+// resourceOwner classifies a network or volume that already exists by
+// the workspace it was created for.
+type resourceOwner int
+
+const (
+	ownerUnknown   resourceOwner = iota // Not labeled with a workspace, e.g., created by hand
+	ownerWorkspace                      // Created for the workspace in question
+	ownerOther                          // Created for another workspace
+)
+
+// ownerOf tells whether a resource labeled with existing was created
+// for the workspace the labels in ours identify (see ResourceLabels).
+//
+// Resources created before WorkspaceHashLabel was introduced are told
+// apart by writ.LocalFolderLabel instead.
+func ownerOf(existing map[string]string, ours map[string]string) resourceOwner {
+	for _, key := range []string{WorkspaceHashLabel, writ.LocalFolderLabel} {
+		if val, ok := existing[key]; ok {
+			if val == ours[key] {
+				return ownerWorkspace
+			}
+			return ownerOther
+		}
+	}
+	return ownerUnknown
+}
+
+// scopedResourceName returns name suffixed with part of the hash of
+// the workspace the labels in ours identify, so resources of projects
+// with the same name in different workspaces don't collide; name is
+// returned as-is if the workspace's hash isn't known.
+func scopedResourceName(name string, ours map[string]string) string {
+	hash := ours[WorkspaceHashLabel]
+	if len(hash) < scopedNameHashLength {
+		return name
+	}
+	return fmt.Sprintf("%s-%s", name, hash[:scopedNameHashLength])
+}
+
+// mergeLabels returns a copy of base with the labels in overrides
+// added to it, replacing any with the same keys.
+func mergeLabels(base map[string]string, overrides map[string]string) map[string]string {
+	merged := maps.Clone(base)
+	if merged == nil {
+		merged = make(map[string]string, len(overrides))
+	}
+	maps.Copy(merged, overrides)
+	return merged
+}
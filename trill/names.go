@@ -0,0 +1,80 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// MaxContainerNameLength is the longest a name returned by
+// ContainerName gets, so that it's still usable as a hostname on the
+// networks the container is attached to.
+const MaxContainerNameLength int = 63
+
+// ContainerNameIDLength is how many characters of a devcontainer's ID
+// ContainerName appends to the names it returns.
+const ContainerNameIDLength int = 8
+
+// containerNameSeparator joins the parts of a name returned by
+// ContainerName.
+const containerNameSeparator string = "--"
+
+// invalidContainerNamePattern matches the characters neither Podman
+// nor Docker allow in container names.
+var invalidContainerNamePattern = regexp.MustCompile("[^a-zA-Z0-9_.-]")
+
+// ContainerName returns a name for a container made up of parts
+// (e.g., a Compose project's name and one of its services'), joined
+// by "--", that both Podman and Docker accept: characters they don't
+// allow are replaced with underscores, and names that don't start
+// with a letter or a digit are prefixed with one.
+//
+// If devcontainerID (i.e., ${devcontainerId}) isn't empty, its first
+// ContainerNameIDLength characters are appended, so that containers
+// for devcontainers whose names would otherwise be the same (e.g.,
+// clones of a repository on the same branch) don't collide, while
+// the name stays the same across runs.
+//
+// Names longer than MaxContainerNameLength are shortened, with part
+// of the hash of the full name standing in for what's cut off so they
+// stay distinct; the part from devcontainerID is kept.
+func ContainerName(devcontainerID string, parts ...string) string {
+	name := invalidContainerNamePattern.ReplaceAllString(strings.Join(parts, containerNameSeparator), "_")
+	if len(name) == 0 || !isAlphanumeric(name[0]) {
+		name = "c" + name
+	}
+
+	var suffix string
+	if len(devcontainerID) > 0 {
+		suffix = containerNameSeparator + invalidContainerNamePattern.ReplaceAllString(devcontainerID[:min(len(devcontainerID), ContainerNameIDLength)], "_")
+	}
+	if len(name)+len(suffix) > MaxContainerNameLength {
+		hash := sha256.Sum256([]byte(name))
+		hashPart := "-" + hex.EncodeToString(hash[:])[:8]
+		name = name[:MaxContainerNameLength-len(suffix)-len(hashPart)] + hashPart
+	}
+	return name + suffix
+}
+
+// isAlphanumeric reports whether b is an ASCII letter or digit.
+func isAlphanumeric(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+}
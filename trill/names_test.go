@@ -0,0 +1,32 @@
+package trill
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestContainerName checks that generated names only use characters
+// the engines allow, fit within MaxContainerNameLength, and keep the
+// part that tells devcontainers apart.
+func TestContainerName(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	id := "0ajdqqn2f4h8vj6kc1x3r5t7m9p0b2d4f6h8j0l2n4p6r8t0v2"
+	assert.Equal(t, "proj--db", ContainerName("", "proj", "db"))
+	assert.Equal(t, "proj--db--2", ContainerName("", "proj", "db", "2"))
+	assert.Equal(t, "repo--feature_x--0ajdqqn2", ContainerName(id, "repo--feature/x"))
+	assert.Equal(t, "c_hidden", ContainerName("", "_hidden"))
+	assert.NotEqual(t, ContainerName(id, "repo"), ContainerName("1bkerro3"+id[8:], "repo"))
+
+	long := ContainerName(id, strings.Repeat("a", 100))
+	assert.Len(t, long, MaxContainerNameLength)
+	assert.True(t, strings.HasSuffix(long, "--0ajdqqn2"))
+	// Names that only differ past where they're cut stay distinct
+	assert.NotEqual(t, long, ContainerName(id, strings.Repeat("a", 99)+"b"))
+	assert.Len(t, ContainerName("", strings.Repeat("a", 100), "db"), MaxContainerNameLength)
+}
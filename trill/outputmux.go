@@ -0,0 +1,131 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// liveRegion is output that's redrawn in place below everything else
+// written through an outputMux, e.g., the running step of a build
+// shown with BuildProgressTTY.
+//
+// Its methods are called with the outputMux's lock held.
+type liveRegion interface {
+	clear() // Erases the region from the terminal
+	draw()  // Draws the region again
+}
+
+// outputMux serializes the output of jobs that run concurrently (e.g.,
+// the image pulls/builds of a Composer project's services), so that
+// it's written out a whole line at a time and lines from different
+// jobs don't run into each other.
+//
+// The zero value writes to color.Output.
+type outputMux struct {
+	w    io.Writer  // Where output is written to; color.Output, if nil
+	mu   sync.Mutex // Held while anything is written to w
+	live liveRegion // Kept below the lines written, if set
+}
+
+// writer returns where m writes output to.
+func (m *outputMux) writer() io.Writer {
+	if m.w != nil {
+		return m.w
+	}
+	return color.Output
+}
+
+// write writes data, which is made up of whole lines, out in one go,
+// keeping the live region, if any, below it.
+func (m *outputMux) write(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.live != nil {
+		m.live.clear()
+		defer m.live.draw()
+	}
+	_, err := m.writer().Write(data)
+	return err
+}
+
+// setLive sets the region that's kept below the lines written through
+// m; a nil r unsets it.
+//
+// m.mu must be held.
+func (m *outputMux) setLive(r liveRegion) {
+	m.live = r
+}
+
+// printf returns a function that can be used in place of fmt.Printf,
+// printing out prefix, then the rest of its arguments, through m;
+// every invocation is expected to print out whole lines.
+func (m *outputMux) printf(prefix string) func(format string, a ...any) (int, error) {
+	return func(format string, a ...any) (int, error) {
+		text := prefix + fmt.Sprintf(format, a...)
+		return len(text), m.write([]byte(text))
+	}
+}
+
+// newWriter returns a writer that buffers what's written to it until
+// it makes up whole lines, which are then written through m, each
+// prefixed with prefix. Whatever's left once the writer is closed is
+// written out as a line of its own.
+func (m *outputMux) newWriter(prefix string) *outputMuxWriter {
+	return &outputMuxWriter{mux: m, prefix: []byte(prefix)}
+}
+
+// outputMuxWriter is a writer returned by outputMux.newWriter.
+type outputMuxWriter struct {
+	mux     *outputMux
+	prefix  []byte
+	pending []byte // The incomplete line written so far
+}
+
+// Write implements the io.Writer interface for outputMuxWriter.
+func (w *outputMuxWriter) Write(data []byte) (int, error) {
+	w.pending = append(w.pending, data...)
+	end := bytes.LastIndexByte(w.pending, '\n')
+	if end < 0 {
+		return len(data), nil
+	}
+
+	var buf bytes.Buffer
+	for line := range bytes.Lines(w.pending[:end+1]) {
+		buf.Write(w.prefix)
+		buf.Write(line)
+	}
+	w.pending = append(w.pending[:0], w.pending[end+1:]...)
+	return len(data), w.mux.write(buf.Bytes())
+}
+
+// Close implements the io.Closer interface for outputMuxWriter,
+// writing out the incomplete line being held on to, if any.
+func (w *outputMuxWriter) Close() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	line := append(append(w.prefix[:len(w.prefix):len(w.prefix)], w.pending...), '\n')
+	w.pending = nil
+	return w.mux.write(line)
+}
@@ -0,0 +1,98 @@
+package trill
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOutputMuxWriter checks that lines written concurrently come out
+// whole, prefixed with the writer they were written to, even when
+// they're written a few bytes at a time.
+func TestOutputMuxWriter(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var out bytes.Buffer
+	m := &outputMux{w: &out}
+	var wg sync.WaitGroup
+	for job := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := m.newWriter(fmt.Sprintf("job%d | ", job))
+			for i := range 50 {
+				line := fmt.Sprintf("line %d of job %d\n", i, job)
+				for chunk := range chunks(line, 3) {
+					_, err := w.Write([]byte(chunk))
+					assert.Nil(t, err)
+				}
+			}
+			_, err := w.Write([]byte("unterminated"))
+			assert.Nil(t, err)
+			assert.Nil(t, w.Close())
+		}()
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Len(t, lines, 4*51)
+	for _, line := range lines {
+		var prefixJob, job, i int
+		if strings.HasSuffix(line, "unterminated") {
+			_, err := fmt.Sscanf(line, "job%d | unterminated", &prefixJob)
+			assert.Nil(t, err, line)
+			continue
+		}
+		_, err := fmt.Sscanf(line, "job%d | line %d of job %d", &prefixJob, &i, &job)
+		assert.Nil(t, err, line)
+		assert.Equal(t, prefixJob, job, line)
+	}
+}
+
+// chunks splits s into chunks of up to size bytes.
+func chunks(s string, size int) func(yield func(string) bool) {
+	return func(yield func(string) bool) {
+		for len(s) > 0 {
+			n := min(size, len(s))
+			if !yield(s[:n]) {
+				return
+			}
+			s = s[n:]
+		}
+	}
+}
+
+// TestOutputMuxLiveRegion checks that lines written while a build is
+// drawn with BuildProgressTTY are printed above its running step,
+// which is then drawn again.
+func TestOutputMuxLiveRegion(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var out bytes.Buffer
+	m := &outputMux{w: &out}
+	now, _ := fakeBuildClock()
+	r := &ttyBuildRenderer{out: m, width: 40, now: now, stop: make(chan struct{}), stopped: make(chan struct{})}
+	close(r.stopped)
+	m.setLive(r)
+
+	r.Stream("Step 1/1 : RUN make\nbuilding\n")
+	out.Reset()
+	_, err := m.printf("PULL alpine ")("Downloading\r\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "\x1b[2F\x1b[JPULL alpine Downloading\r\n=> 1/1: RUN make 0.0s\r\n   building\r\n", out.String())
+
+	r.Close()
+	assert.Nil(t, m.live)
+	out.Reset()
+	_, err = m.printf("PULL alpine ")("Done\r\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "PULL alpine Done\r\n", out.String())
+}
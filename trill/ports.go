@@ -0,0 +1,466 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+	"github.com/docker/go-connections/nat"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/network"
+	"github.com/nlsantos/brig/writ"
+)
+
+// portBinding is a single container port published on the host,
+// regardless of whether it came from appPort, forwardPorts, or a
+// Composer service's ports.
+type portBinding struct {
+	ContainerPort network.Port // Includes the protocol, e.g., 853/udp
+	HostIP        string       // If empty, the port is bound to forwardAddress()
+	HostPort      string       // If empty, the engine picks a port; may be a range
+}
+
+// maxPortConflictAttempts is how many ports after one that's in use
+// are tried before giving up on finding a free one.
+const maxPortConflictAttempts = 100
+
+// PortConflictResolver is a function that Client can use to decide
+// which port to publish a container port on when the host port it's
+// meant to be published on is already in use.
+//
+// It is passed the container port, the host port that's in use, and
+// the first free port after it; its return value is published on in
+// the original port's stead. Returning an error aborts creating the
+// container.
+//
+// If no PortConflictResolver is set, the free port is used.
+type PortConflictResolver func(containerPort network.Port, hostPort uint16, nextFree uint16) (uint16, error)
+
+// hostPortKey identifies a port bound on a host address.
+type hostPortKey struct {
+	addrPort netip.AddrPort
+	proto    network.IPProtocol
+}
+
+// parseAppPort converts an appPort entry into the bindings it
+// describes.
+//
+// Entries follow Docker's -p syntax (e.g., "8000", "8000:8010",
+// "127.0.0.1:53:53/udp", or "9000-9002:9000-9002"). A bare port is
+// published on the same port on the host, as the spec requires.
+func parseAppPort(spec string) ([]portBinding, error) {
+	mappings, err := nat.ParsePortSpec(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid appPort %q: %w", spec, err)
+	}
+
+	bindings := make([]portBinding, 0, len(mappings))
+	for _, mapping := range mappings {
+		containerPort, err := network.ParsePort(string(mapping.Port))
+		if err != nil {
+			return nil, fmt.Errorf("invalid appPort %q: %w", spec, err)
+		}
+		hostPort := mapping.Binding.HostPort
+		if len(hostPort) == 0 && !strings.Contains(spec, ":") {
+			hostPort = strconv.Itoa(int(containerPort.Num()))
+		}
+		bindings = append(bindings, portBinding{
+			ContainerPort: containerPort,
+			HostIP:        mapping.Binding.HostIP,
+			HostPort:      hostPort,
+		})
+	}
+	return bindings, nil
+}
+
+// forwardPortLocalHost is the host forwardPorts entries use to refer
+// to the devcontainer itself.
+const forwardPortLocalHost = "localhost"
+
+// parseForwardPort converts a forwardPorts entry into the binding it
+// describes, along with the host the port is forwarded from.
+//
+// Entries are either a port number, which is published on the same
+// port on the host, or of the form "host:port". host is the name of
+// the Composer service the port is forwarded from, or "localhost" for
+// the devcontainer itself; if it's a port number instead, the entry
+// maps that port on the host to port in the devcontainer (e.g.,
+// "8080:3000").
+//
+// The returned host is empty if the entry refers to the devcontainer.
+func parseForwardPort(spec string) (string, portBinding, error) {
+	host, port, found := strings.Cut(spec, ":")
+	if !found {
+		host, port = "", spec
+	}
+	containerPort, err := network.ParsePort(port)
+	if err != nil {
+		return "", portBinding{}, fmt.Errorf("invalid forwardPorts entry %q: %w", spec, err)
+	}
+	binding := portBinding{
+		ContainerPort: containerPort,
+		HostPort:      strconv.Itoa(int(containerPort.Num())),
+	}
+
+	if host == forwardPortLocalHost {
+		host = ""
+	} else if hostPort, err := strconv.ParseUint(host, 10, 16); err == nil {
+		if hostPort == 0 {
+			return "", portBinding{}, fmt.Errorf("invalid forwardPorts entry %q: host port cannot be 0", spec)
+		}
+		host, binding.HostPort = "", strconv.FormatUint(hostPort, 10)
+	}
+	return host, binding, nil
+}
+
+// servicePortBindings converts the ports of a Composer service into
+// the bindings they describe.
+func servicePortBindings(serviceCfg *composetypes.ServiceConfig) ([]portBinding, error) {
+	bindings := make([]portBinding, 0, len(serviceCfg.Ports))
+	for _, portCfg := range serviceCfg.Ports {
+		protocol := portCfg.Protocol
+		if len(protocol) == 0 {
+			protocol = string(network.TCP)
+		}
+		containerPort, err := network.ParsePort(fmt.Sprintf("%d/%s", portCfg.Target, strings.ToLower(protocol)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port for service %s: %w", serviceCfg.Name, err)
+		}
+		bindings = append(bindings, portBinding{
+			ContainerPort: containerPort,
+			HostIP:        portCfg.HostIP,
+			HostPort:      portCfg.Published,
+		})
+	}
+	return bindings, nil
+}
+
+// publishPorts exposes each binding's container port and binds it on
+// the host.
+//
+// Host ports in the privileged range are passed through
+// PrivilegedPortElevator, if one is set. Bindings that are already
+// present in hostCfg are skipped, so the same port can be listed in
+// both appPort and forwardPorts. Host ports that turn out to be in use
+// are swapped out for free ones; see claimHostPort.
+//
+// Requires containerCfg and hostCfg to be pointers to their
+// respective structs.
+func (c *Client) publishPorts(bindings []portBinding, containerCfg *container.Config, hostCfg *container.HostConfig) error {
+	if len(bindings) < 1 {
+		return nil
+	}
+	if containerCfg.ExposedPorts == nil {
+		containerCfg.ExposedPorts = make(network.PortSet)
+	}
+	if hostCfg.PortBindings == nil {
+		hostCfg.PortBindings = make(network.PortMap)
+	}
+
+	for _, binding := range bindings {
+		hostIP := c.forwardAddress()
+		if len(binding.HostIP) > 0 {
+			addr, err := netip.ParseAddr(strings.Trim(binding.HostIP, "[]"))
+			if err != nil {
+				return fmt.Errorf("invalid host IP for port %s: %w", binding.ContainerPort, err)
+			}
+			hostIP = addr
+		}
+
+		hostPort, err := c.elevateHostPort(binding.HostPort)
+		if err != nil {
+			return err
+		}
+
+		containerCfg.ExposedPorts[binding.ContainerPort] = struct{}{}
+		proto := binding.ContainerPort.Proto()
+		if slices.ContainsFunc(hostCfg.PortBindings[binding.ContainerPort], func(existing network.PortBinding) bool {
+			return existing.HostIP == hostIP && c.requestedHostPort(proto, hostIP, existing.HostPort) == hostPort
+		}) {
+			continue
+		}
+		if hostPort, err = c.claimHostPort(binding.ContainerPort, hostIP, hostPort); err != nil {
+			return err
+		}
+		hostCfg.PortBindings[binding.ContainerPort] = append(hostCfg.PortBindings[binding.ContainerPort], network.PortBinding{
+			HostIP:   hostIP,
+			HostPort: hostPort,
+		})
+	}
+
+	return nil
+}
+
+// elevateHostPort returns hostPort, passed through
+// PrivilegedPortElevator if it's a single port in the privileged
+// range.
+//
+// Empty host ports and port ranges are returned unchanged.
+func (c *Client) elevateHostPort(hostPort string) (string, error) {
+	if c.PrivilegedPortElevator == nil || len(hostPort) == 0 || strings.Contains(hostPort, "-") {
+		return hostPort, nil
+	}
+	portNum, err := strconv.ParseUint(hostPort, 10, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid host port %q: %w", hostPort, err)
+	}
+	if portNum == 0 || portNum >= 1024 {
+		return hostPort, nil
+	}
+	elevatedPort := c.PrivilegedPortElevator(uint16(portNum))
+	slog.Debug("converted a privileged host port to an unprivileged one", "old-port", portNum, "new-port", elevatedPort)
+	return strconv.Itoa(int(elevatedPort)), nil
+}
+
+// claimHostPort checks that hostPort is free on hostIP, and returns
+// the port containerPort should be published on.
+//
+// If hostPort is already in use, either by something on the host or
+// by a binding claimed earlier on, the next free port after it is
+// picked instead, unless PortConflictResolver says otherwise. Ports are
+// only checked if the engine runs on this host; ranges and ports left
+// for the engine to pick are returned unchanged.
+func (c *Client) claimHostPort(containerPort network.Port, hostIP netip.Addr, hostPort string) (string, error) {
+	portNum, err := strconv.ParseUint(hostPort, 10, 16)
+	if err != nil || portNum == 0 || !c.probesHostPorts() {
+		return hostPort, nil
+	}
+	proto := containerPort.Proto()
+	requested := uint16(portNum)
+
+	c.hostPortsMu.Lock()
+	defer c.hostPortsMu.Unlock()
+	if c.hostPortClaims == nil {
+		c.hostPortClaims = make(map[hostPortKey]string)
+	}
+	if !c.hostPortTaken(proto, hostIP, requested) {
+		c.hostPortClaims[hostPortKey{netip.AddrPortFrom(hostIP, requested), proto}] = hostPort
+		return hostPort, nil
+	}
+
+	nextFree, ok := c.nextFreeHostPort(proto, hostIP, requested)
+	if !ok {
+		return "", fmt.Errorf("%w: %s/%s, and none of the %d ports after it are free", ErrPortInUse, netip.AddrPortFrom(hostIP, requested), proto, maxPortConflictAttempts)
+	}
+	chosen := nextFree
+	if c.PortConflictResolver != nil {
+		if chosen, err = c.PortConflictResolver(containerPort, requested, nextFree); err != nil {
+			return "", fmt.Errorf("%w: %s/%s: %w", ErrPortInUse, netip.AddrPortFrom(hostIP, requested), proto, err)
+		}
+	}
+	if chosen != requested {
+		slog.Warn("host port is already in use; publishing on another one", "container-port", containerPort, "requested", requested, "host-port", chosen)
+	}
+	c.hostPortClaims[hostPortKey{netip.AddrPortFrom(hostIP, chosen), proto}] = hostPort
+	return strconv.Itoa(int(chosen)), nil
+}
+
+// hostPortTaken reports whether port is bound on hostIP, or has been
+// claimed by a binding already.
+//
+// Requires hostPortsMu to be held.
+func (c *Client) hostPortTaken(proto network.IPProtocol, hostIP netip.Addr, port uint16) bool {
+	if _, ok := c.hostPortClaims[hostPortKey{netip.AddrPortFrom(hostIP, port), proto}]; ok {
+		return true
+	}
+	return hostPortInUse(proto, hostIP, port)
+}
+
+// nextFreeHostPort returns the first port after port that isn't taken
+// on hostIP, giving up after maxPortConflictAttempts.
+//
+// Requires hostPortsMu to be held.
+func (c *Client) nextFreeHostPort(proto network.IPProtocol, hostIP netip.Addr, port uint16) (uint16, bool) {
+	for attempt := 0; attempt < maxPortConflictAttempts && port < math.MaxUint16; attempt++ {
+		port++
+		if !c.hostPortTaken(proto, hostIP, port) {
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+// requestedHostPort returns the host port that was asked for when
+// hostPort was claimed on hostIP, which differs from hostPort if it
+// was in use; see claimHostPort.
+func (c *Client) requestedHostPort(proto network.IPProtocol, hostIP netip.Addr, hostPort string) string {
+	portNum, err := strconv.ParseUint(hostPort, 10, 16)
+	if err != nil {
+		return hostPort
+	}
+	c.hostPortsMu.Lock()
+	defer c.hostPortsMu.Unlock()
+	if requested, ok := c.hostPortClaims[hostPortKey{netip.AddrPortFrom(hostIP, uint16(portNum)), proto}]; ok {
+		return requested
+	}
+	return hostPort
+}
+
+// probesHostPorts reports whether host ports can be checked for
+// conflicts before they're bound, which is only the case if the
+// engine publishes them on this host.
+//
+// That holds for local sockets and named pipes, including those of
+// engines running in a VM (e.g., Podman machines or Docker Desktop),
+// which forward published ports to the host.
+func (c *Client) probesHostPorts() bool {
+	socketURL, err := url.Parse(c.SocketAddr)
+	if err != nil {
+		return false
+	}
+	switch socketURL.Scheme {
+	case "unix", "npipe":
+		return true
+	case "tcp", "http", "https":
+		host := socketURL.Hostname()
+		if host == "localhost" {
+			return true
+		}
+		addr, err := netip.ParseAddr(host)
+		return err == nil && addr.IsLoopback()
+	default:
+		return false
+	}
+}
+
+// hostPortInUse reports whether port is already bound on hostIP, by
+// trying to bind it.
+//
+// Failures other than the port being in use (e.g., it's privileged,
+// or hostIP isn't an address of this host) are left for the engine
+// to report.
+func hostPortInUse(proto network.IPProtocol, hostIP netip.Addr, port uint16) bool {
+	addr := netip.AddrPortFrom(hostIP, port).String()
+	var listener io.Closer
+	var err error
+	switch proto {
+	case network.TCP:
+		listener, err = net.Listen("tcp", addr)
+	case network.UDP:
+		listener, err = net.ListenPacket("udp", addr)
+	default:
+		return false
+	}
+	if err != nil {
+		return isAddrInUse(err)
+	}
+	_ = listener.Close()
+	return false
+}
+
+// wrapPortConflict returns err wrapped with ErrPortInUse if the engine
+// failed to publish a port because it's already bound; otherwise, err
+// is returned as-is.
+func wrapPortConflict(err error) error {
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "address already in use") || strings.Contains(msg, "port is already allocated") {
+		return fmt.Errorf("%w: %w", ErrPortInUse, err)
+	}
+	return err
+}
+
+// bindAppPorts publishes the ports in appPort on the host machine.
+//
+// Requires containerCfg and hostCfg to be pointers to their
+// respective structs.
+func (c *Client) bindAppPorts(p *writ.DevcontainerParser, containerCfg *container.Config, hostCfg *container.HostConfig) error {
+	if p.Config.AppPort == nil {
+		return nil
+	}
+
+	var bindings []portBinding
+	for _, spec := range *p.Config.AppPort {
+		specBindings, err := parseAppPort(spec)
+		if err != nil {
+			return err
+		}
+		bindings = append(bindings, specBindings...)
+	}
+	return c.publishPorts(bindings, containerCfg, hostCfg)
+}
+
+// bindForwardPorts publishes the forwardPorts entries that are
+// forwarded from service on the host machine.
+//
+// service is the name of the Composer service the container is
+// created for, and is empty when not using Compose. Entries that
+// don't name a host are forwarded from the devcontainer.
+//
+// Requires containerCfg and hostCfg to be pointers to their
+// respective structs.
+func (c *Client) bindForwardPorts(p *writ.DevcontainerParser, service string, containerCfg *container.Config, hostCfg *container.HostConfig) error {
+	isDevcontainer := p.Config.Service == nil || *p.Config.Service == service
+	bindings := make([]portBinding, 0, len(p.Config.ForwardPorts))
+	for _, spec := range p.Config.ForwardPorts {
+		host, binding, err := parseForwardPort(spec)
+		if err != nil {
+			return err
+		}
+		if host == service || (len(host) == 0 && isDevcontainer) {
+			bindings = append(bindings, binding)
+			continue
+		}
+		if isDevcontainer && !c.hasComposerService(host) {
+			// Only checked once, when the devcontainer is created
+			slog.Warn("not forwarding port from a host that isn't a Composer service", "port", spec, "host", host)
+		}
+	}
+	return c.publishPorts(bindings, containerCfg, hostCfg)
+}
+
+// hasComposerService reports whether the Composer project being
+// deployed, if any, has a service named name.
+func (c *Client) hasComposerService(name string) bool {
+	if c.composerProject == nil {
+		return false
+	}
+	_, err := c.composerProject.GetService(name)
+	return err == nil
+}
+
+// bindServicePorts publishes the ports of a Composer service on the
+// host machine.
+//
+// Requires containerCfg and hostCfg to be pointers to their
+// respective structs.
+func (c *Client) bindServicePorts(serviceCfg *composetypes.ServiceConfig, containerCfg *container.Config, hostCfg *container.HostConfig) error {
+	bindings, err := servicePortBindings(serviceCfg)
+	if err != nil {
+		return err
+	}
+	return c.publishPorts(bindings, containerCfg, hostCfg)
+}
+
+// forwardAddress returns the host address ports are bound to by
+// default; see ForwardAddress.
+func (c *Client) forwardAddress() netip.Addr {
+	if c.ForwardAddress.IsValid() {
+		return c.ForwardAddress
+	}
+	return netip.MustParseAddr("127.0.0.1")
+}
@@ -0,0 +1,262 @@
+package trill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"testing"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/network"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseAppPort checks that appPort entries are parsed with their
+// protocol, host IP, and host port intact.
+func TestParseAppPort(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for spec, expected := range map[string][]portBinding{
+		"8000":                {{ContainerPort: network.MustParsePort("8000/tcp"), HostPort: "8000"}},
+		"853/udp":             {{ContainerPort: network.MustParsePort("853/udp"), HostPort: "853"}},
+		"8000:8010":           {{ContainerPort: network.MustParsePort("8010/tcp"), HostPort: "8000"}},
+		"127.0.0.1:53:53/udp": {{ContainerPort: network.MustParsePort("53/udp"), HostIP: "127.0.0.1", HostPort: "53"}},
+		"[::1]:8080:80":       {{ContainerPort: network.MustParsePort("80/tcp"), HostIP: "::1", HostPort: "8080"}},
+		"0.0.0.0::80":         {{ContainerPort: network.MustParsePort("80/tcp"), HostIP: "0.0.0.0"}},
+		"9000-9001:9100-9101": {
+			{ContainerPort: network.MustParsePort("9100/tcp"), HostPort: "9000"},
+			{ContainerPort: network.MustParsePort("9101/tcp"), HostPort: "9001"},
+		},
+	} {
+		bindings, err := parseAppPort(spec)
+		assert.Nil(t, err, spec)
+		assert.Equal(t, expected, bindings, spec)
+	}
+
+	for _, spec := range []string{"", "80/sctpx", "nope:80", "80:90-91"} {
+		_, err := parseAppPort(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+// TestParseForwardPort checks that forwardPorts entries are published
+// on the same port on the host unless they remap it, and that the
+// host they're forwarded from is picked out.
+func TestParseForwardPort(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for spec, expected := range map[string]struct {
+		host    string
+		binding portBinding
+	}{
+		"3000":           {"", portBinding{ContainerPort: network.MustParsePort("3000/tcp"), HostPort: "3000"}},
+		"localhost:3000": {"", portBinding{ContainerPort: network.MustParsePort("3000/tcp"), HostPort: "3000"}},
+		"8080:3000":      {"", portBinding{ContainerPort: network.MustParsePort("3000/tcp"), HostPort: "8080"}},
+		"db:5432":        {"db", portBinding{ContainerPort: network.MustParsePort("5432/tcp"), HostPort: "5432"}},
+		"db-replica:80":  {"db-replica", portBinding{ContainerPort: network.MustParsePort("80/tcp"), HostPort: "80"}},
+	} {
+		host, binding, err := parseForwardPort(spec)
+		assert.Nil(t, err, spec)
+		assert.Equal(t, expected.host, host, spec)
+		assert.Equal(t, expected.binding, binding, spec)
+	}
+
+	for _, spec := range []string{"not-a-port", "db:", "0:3000", "db:70000"} {
+		_, _, err := parseForwardPort(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+// TestServicePortBindings checks that a Composer service's ports are
+// keyed by their target port, and keep their protocol and host IP.
+func TestServicePortBindings(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	bindings, err := servicePortBindings(&composetypes.ServiceConfig{
+		Name: "db",
+		Ports: []composetypes.ServicePortConfig{
+			{Target: 5432, Published: "5433", HostIP: "127.0.0.1"},
+			{Target: 53, Published: "5353", Protocol: "UDP"},
+			{Target: 8080},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []portBinding{
+		{ContainerPort: network.MustParsePort("5432/tcp"), HostIP: "127.0.0.1", HostPort: "5433"},
+		{ContainerPort: network.MustParsePort("53/udp"), HostPort: "5353"},
+		{ContainerPort: network.MustParsePort("8080/tcp")},
+	}, bindings)
+}
+
+// TestPublishPorts checks that bindings are exposed and bound on the
+// forward address unless they specify a host IP, that privileged
+// host ports are elevated, and that duplicate bindings are dropped.
+func TestPublishPorts(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	bindings := []portBinding{
+		{ContainerPort: network.MustParsePort("53/udp"), HostPort: "53"},
+		{ContainerPort: network.MustParsePort("80/tcp"), HostIP: "::1", HostPort: "8080"},
+		{ContainerPort: network.MustParsePort("80/tcp"), HostIP: "::1", HostPort: "8080"},
+		{ContainerPort: network.MustParsePort("9000/tcp"), HostPort: "9000-9010"},
+	}
+
+	// Without an elevator, privileged ports are bound as-is
+	c := &Client{}
+	containerCfg := &container.Config{}
+	hostCfg := &container.HostConfig{}
+	assert.Nil(t, c.publishPorts(bindings, containerCfg, hostCfg))
+	assert.Equal(t, network.PortSet{
+		network.MustParsePort("53/udp"):   {},
+		network.MustParsePort("80/tcp"):   {},
+		network.MustParsePort("9000/tcp"): {},
+	}, containerCfg.ExposedPorts)
+	assert.Equal(t, network.PortMap{
+		network.MustParsePort("53/udp"):   {{HostIP: netip.MustParseAddr("127.0.0.1"), HostPort: "53"}},
+		network.MustParsePort("80/tcp"):   {{HostIP: netip.MustParseAddr("::1"), HostPort: "8080"}},
+		network.MustParsePort("9000/tcp"): {{HostIP: netip.MustParseAddr("127.0.0.1"), HostPort: "9000-9010"}},
+	}, hostCfg.PortBindings)
+
+	// Only the host side of privileged ports is elevated
+	c = &Client{
+		ForwardAddress:         netip.MustParseAddr("0.0.0.0"),
+		PrivilegedPortElevator: func(port uint16) uint16 { return port + 8000 },
+	}
+	containerCfg = &container.Config{}
+	hostCfg = &container.HostConfig{}
+	assert.Nil(t, c.publishPorts(bindings[:1], containerCfg, hostCfg))
+	assert.Equal(t, network.PortMap{
+		network.MustParsePort("53/udp"): {{HostIP: netip.MustParseAddr("0.0.0.0"), HostPort: "8053"}},
+	}, hostCfg.PortBindings)
+
+	err := c.publishPorts([]portBinding{{ContainerPort: network.MustParsePort("80/tcp"), HostIP: "localhost"}}, containerCfg, hostCfg)
+	assert.NotNil(t, err)
+}
+
+// TestBindForwardPorts checks that forwardPorts entries naming another
+// host are left off the devcontainer when not using Compose.
+func TestBindForwardPorts(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p := &writ.DevcontainerParser{}
+	p.Config.ForwardPorts = writ.ForwardPorts{"3000", "db:5432", "8080:80"}
+	c := &Client{}
+	containerCfg := &container.Config{}
+	hostCfg := &container.HostConfig{}
+	assert.Nil(t, c.bindForwardPorts(p, "", containerCfg, hostCfg))
+	assert.Equal(t, network.PortMap{
+		network.MustParsePort("3000/tcp"): {{HostIP: netip.MustParseAddr("127.0.0.1"), HostPort: "3000"}},
+		network.MustParsePort("80/tcp"):   {{HostIP: netip.MustParseAddr("127.0.0.1"), HostPort: "8080"}},
+	}, hostCfg.PortBindings)
+}
+
+// TestClaimHostPort checks that host ports that are in use, or were
+// claimed by an earlier binding, are swapped out for free ones, and
+// that the port that was asked for is reported alongside the one the
+// container ended up published on.
+func TestClaimHostPort(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	busyPort := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	// Remote engines publish ports elsewhere, so they aren't checked
+	c := &Client{SocketAddr: "ssh://user@remote"}
+	hostPort, err := c.claimHostPort(network.MustParsePort("3000/tcp"), netip.MustParseAddr("127.0.0.1"), busyPort)
+	assert.Nil(t, err)
+	assert.Equal(t, busyPort, hostPort)
+
+	var resolved []uint16
+	c = &Client{
+		SocketAddr: "unix:///run/podman/podman.sock",
+		PortConflictResolver: func(containerPort network.Port, hostPort uint16, nextFree uint16) (uint16, error) {
+			resolved = append(resolved, hostPort)
+			return nextFree, nil
+		},
+	}
+	hostCfg := &container.HostConfig{}
+	bindings := []portBinding{
+		{ContainerPort: network.MustParsePort("3000/tcp"), HostPort: busyPort},
+		{ContainerPort: network.MustParsePort("3000/tcp"), HostPort: busyPort},
+		{ContainerPort: network.MustParsePort("3001/udp"), HostPort: busyPort},
+	}
+	assert.Nil(t, c.publishPorts(bindings, &container.Config{}, hostCfg))
+	tcpBindings := hostCfg.PortBindings[network.MustParsePort("3000/tcp")]
+	assert.Len(t, tcpBindings, 1)
+	assert.NotEqual(t, busyPort, tcpBindings[0].HostPort)
+	assert.Equal(t, busyPort, c.requestedHostPort(network.TCP, tcpBindings[0].HostIP, tcpBindings[0].HostPort))
+	// The port is only in use for TCP
+	assert.Equal(t, busyPort, hostCfg.PortBindings[network.MustParsePort("3001/udp")][0].HostPort)
+	assert.Len(t, resolved, 1)
+
+	// Ports claimed earlier on are taken, too
+	hostPort, err = c.claimHostPort(network.MustParsePort("3001/udp"), netip.MustParseAddr("127.0.0.1"), busyPort)
+	assert.Nil(t, err)
+	assert.NotEqual(t, busyPort, hostPort)
+
+	c.PortConflictResolver = func(network.Port, uint16, uint16) (uint16, error) {
+		return 0, errors.New("declined")
+	}
+	_, err = c.claimHostPort(network.MustParsePort("3000/tcp"), netip.MustParseAddr("127.0.0.1"), busyPort)
+	assert.ErrorIs(t, err, ErrPortInUse)
+}
+
+// TestStartDevcontainerContainerPortConflict checks that the ports a
+// devcontainer ends up published on are reported along with the ones
+// that were asked for, and that the engine failing to bind a port is
+// reported as such.
+func TestStartDevcontainerContainerPortConflict(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	busyPort := listener.Addr().(*net.TCPAddr).Port
+
+	t.Chdir(t.TempDir())
+	assert.Nil(t, os.WriteFile("devcontainer.json", fmt.Appendf(nil, `{"image": "docker.io/library/alpine:3", "appPort": [%d]}`, busyPort), 0o644))
+	p, err := writ.NewDevcontainerParser("devcontainer.json")
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("docker.io/library/alpine:3", &dockerspec.DockerOCIImageConfig{})
+	c := newFakeClient(t, engine)
+	c.SocketAddr = "unix:///run/podman/podman.sock"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	seen := answerLifecycleEvents(ctx, c)
+	assert.Nil(t, c.StartDevcontainerContainer(ctx, p, "docker.io/library/alpine:3", "conflict"))
+	c.CloseLifecycle()
+	<-seen
+
+	ports, err := c.InspectPublishedPorts(ctx, c.ContainerID)
+	assert.Nil(t, err)
+	assert.Len(t, ports, 1)
+	assert.Equal(t, strconv.Itoa(busyPort), ports[0].RequestedHostPort)
+	assert.NotEqual(t, strconv.Itoa(busyPort), ports[0].HostPort)
+
+	assert.ErrorIs(t, wrapPortConflict(errors.New("Bind for 0.0.0.0:8080 failed: port is already allocated")), ErrPortInUse)
+	assert.ErrorIs(t, wrapPortConflict(errors.New("rootlessport listen tcp 127.0.0.1:8080: bind: address already in use")), ErrPortInUse)
+	assert.NotErrorIs(t, wrapPortConflict(errors.New("no such image")), ErrPortInUse)
+}
@@ -30,11 +30,7 @@ import (
 // standardized prefix before the rest of its arguments.
 func NewPrefixedPrintfError(action string) func(format string, a ...any) (n int, err error) {
 	return func(format string, a ...any) (n int, err error) {
-		cAction := color.New(color.FgGreen).SprintFunc()
-		cError := color.New(color.BgHiRed, color.FgBlack, color.Bold).SprintFunc()
-		params := []any{cAction(" " + action + " "), cError(" ERROR ")}
-		params = append(params, a...)
-		return fmt.Fprintf(color.Output, "%s %s "+format, params...)
+		return fmt.Fprintf(color.Output, "%s"+format, append([]any{outputErrorPrefix(action)}, a...)...)
 	}
 }
 
@@ -43,14 +39,26 @@ func NewPrefixedPrintfError(action string) func(format string, a ...any) (n int,
 // before the rest of its arguments.
 func NewPrefixedPrintf(action string, context string) func(format string, a ...any) (n int, err error) {
 	return func(format string, a ...any) (n int, err error) {
-		cAction := color.New(color.BgHiGreen, color.FgBlack).SprintFunc()
-		cContext := color.New(color.FgHiWhite).SprintFunc()
-		params := []any{cAction(" " + action + " "), cContext(context)}
-		params = append(params, a...)
-		return fmt.Fprintf(color.Output, "%s %s "+format, params...)
+		return fmt.Fprintf(color.Output, "%s"+format, append([]any{outputPrefix(action, context)}, a...)...)
 	}
 }
 
+// outputPrefix returns the standardized prefix for output related to
+// action, done on context.
+func outputPrefix(action string, context string) string {
+	cAction := color.New(color.BgHiGreen, color.FgBlack).SprintFunc()
+	cContext := color.New(color.FgHiWhite).SprintFunc()
+	return fmt.Sprintf("%s %s ", cAction(" "+action+" "), cContext(context))
+}
+
+// outputErrorPrefix returns the standardized prefix for errors related to
+// action.
+func outputErrorPrefix(action string) string {
+	cAction := color.New(color.FgGreen).SprintFunc()
+	cError := color.New(color.BgHiRed, color.FgBlack, color.Bold).SprintFunc()
+	return fmt.Sprintf("%s %s ", cAction(" "+action+" "), cError(" ERROR "))
+}
+
 // StreamWriter is a thin custom wrapper for outputting streaming
 // messages with a prefix at the beginning of each line.
 type StreamWriter struct {
@@ -62,10 +70,7 @@ type StreamWriter struct {
 // NewPrefixedStreamWriter returns a standardized prefixed writer for
 // streams.
 func NewPrefixedStreamWriter(w io.Writer, action string, context string) *StreamWriter {
-	cAction := color.New(color.BgHiGreen, color.FgBlack).SprintFunc()
-	cContext := color.New(color.FgHiWhite).SprintFunc()
-	prefix := fmt.Sprintf("%s %s ", cAction(" "+action+" "), cContext(context))
-	return NewStreamWriter(w, prefix)
+	return NewStreamWriter(w, outputPrefix(action, context))
 }
 
 // NewStreamWriter returns a wrapper for an io.Writer
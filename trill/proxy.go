@@ -0,0 +1,164 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/docker/go-connections/sockets"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/writ"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyConfig holds the proxy settings that are propagated into image
+// builds and containers, and used to reach the engine over TCP.
+type ProxyConfig struct {
+	HTTPProxy  string // Proxy for plain HTTP requests
+	HTTPSProxy string // Proxy for HTTPS requests
+	NoProxy    string // Comma-separated hosts, domains (e.g., .corp.example), and CIDRs that are reached directly
+}
+
+// proxyDirectHosts are always added to NO_PROXY in containers, so
+// that they can reach themselves without going through the proxy.
+var proxyDirectHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// ProxyFromEnvironment returns the proxy settings in the environment
+// (i.e., HTTP_PROXY, HTTPS_PROXY, and NO_PROXY, or their lowercase
+// counterparts).
+func ProxyFromEnvironment() ProxyConfig {
+	envCfg := httpproxy.FromEnvironment()
+	return ProxyConfig{
+		HTTPProxy:  envCfg.HTTPProxy,
+		HTTPSProxy: envCfg.HTTPSProxy,
+		NoProxy:    envCfg.NoProxy,
+	}
+}
+
+// IsSet reports whether either proxy is set; NoProxy alone has
+// nothing to apply to.
+func (p ProxyConfig) IsSet() bool {
+	return len(p.HTTPProxy) > 0 || len(p.HTTPSProxy) > 0
+}
+
+// Env returns the environment variables that describe p, in both the
+// uppercase and lowercase forms tools look for, with noProxy added to
+// NO_PROXY.
+func (p ProxyConfig) Env(noProxy ...string) map[string]string {
+	env := make(map[string]string)
+	if !p.IsSet() {
+		return env
+	}
+	set := func(name, val string) {
+		if len(val) > 0 {
+			env[name] = val
+			env[strings.ToLower(name)] = val
+		}
+	}
+	set("HTTP_PROXY", p.HTTPProxy)
+	set("HTTPS_PROXY", p.HTTPSProxy)
+
+	hosts := slices.DeleteFunc(strings.Split(p.NoProxy, ","), func(host string) bool {
+		return len(strings.TrimSpace(host)) == 0
+	})
+	for _, host := range noProxy {
+		if !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	set("NO_PROXY", strings.Join(hosts, ","))
+	return env
+}
+
+// transportProxy returns the function an HTTP transport uses to pick
+// the proxy for each request as per p, honoring NoProxy.
+func (p ProxyConfig) transportProxy() func(*http.Request) (*url.URL, error) {
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  p.HTTPProxy,
+		HTTPSProxy: p.HTTPSProxy,
+		NoProxy:    p.NoProxy,
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+}
+
+// proxyClientOpts returns the options that have the Moby client reach
+// the engine at socketAddr through p.
+//
+// Only engines reached over TCP are affected; as with the Docker CLI,
+// a proxy is never used for local sockets and named pipes.
+func proxyClientOpts(socketAddr string, p ProxyConfig) ([]mobyclient.Opt, error) {
+	hostURL, err := mobyclient.ParseHostURL(socketAddr)
+	if err != nil || !p.IsSet() || hostURL.Scheme == "unix" || hostURL.Scheme == "npipe" {
+		return nil, nil
+	}
+	transport := &http.Transport{}
+	if err := sockets.ConfigureTransport(transport, hostURL.Scheme, hostURL.Host); err != nil {
+		return nil, err
+	}
+	transport.Proxy = p.transportProxy()
+	return []mobyclient.Opt{mobyclient.WithHTTPClient(&http.Client{
+		Transport:     transport,
+		CheckRedirect: mobyclient.CheckRedirect,
+	})}, nil
+}
+
+// proxyEnv returns the proxy environment variables containers are
+// created with, if Proxy is set.
+//
+// The services of the Composer project being deployed, if any, are
+// added to NO_PROXY, so that they reach each other directly.
+func (c *Client) proxyEnv() writ.EnvVarMap {
+	if !c.Proxy.IsSet() {
+		return writ.EnvVarMap{}
+	}
+	noProxy := slices.Clone(proxyDirectHosts)
+	if c.composerProject != nil {
+		noProxy = append(noProxy, c.composerProject.ServiceNames()...)
+	}
+	return c.Proxy.Env(noProxy...)
+}
+
+// warnLoopbackProxy logs a warning for each proxy in p that's on a
+// loopback address of the host, as containers and image builds can't
+// reach those.
+func warnLoopbackProxy(p ProxyConfig) {
+	for _, proxy := range []string{p.HTTPProxy, p.HTTPSProxy} {
+		if len(proxy) == 0 {
+			continue
+		}
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || len(proxyURL.Host) == 0 {
+			// Proxies are allowed to leave out the scheme
+			proxyURL, err = url.Parse("http://" + proxy)
+			if err != nil {
+				continue
+			}
+		}
+		host := proxyURL.Hostname()
+		if addr, err := netip.ParseAddr(host); host == "localhost" || (err == nil && addr.IsLoopback()) {
+			slog.Warn("proxy is on a loopback address, which containers and image builds can't reach; point it at an address of the host they can", "proxy", proxyURL.Redacted())
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package trill
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestProxyFromEnvironment checks that proxy settings are picked up
+// from either the uppercase or lowercase variables.
+func TestProxyFromEnvironment(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, name := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy", "REQUEST_METHOD"} {
+		t.Setenv(name, "")
+		assert.Nil(t, os.Unsetenv(name))
+	}
+	assert.False(t, ProxyFromEnvironment().IsSet())
+
+	t.Setenv("HTTPS_PROXY", "http://proxy.corp.example:3128")
+	t.Setenv("no_proxy", ".corp.example")
+	assert.Equal(t, ProxyConfig{
+		HTTPSProxy: "http://proxy.corp.example:3128",
+		NoProxy:    ".corp.example",
+	}, ProxyFromEnvironment())
+}
+
+// TestProxyConfigEnv checks that both forms of each variable are set,
+// and that hosts are added to NO_PROXY without duplicates.
+func TestProxyConfigEnv(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.Empty(t, ProxyConfig{NoProxy: "localhost"}.Env("db"))
+
+	proxy := ProxyConfig{HTTPProxy: "http://proxy:3128", NoProxy: "localhost,.corp.example"}
+	assert.Equal(t, map[string]string{
+		"HTTP_PROXY": "http://proxy:3128",
+		"http_proxy": "http://proxy:3128",
+		"NO_PROXY":   "localhost,.corp.example,db",
+		"no_proxy":   "localhost,.corp.example,db",
+	}, proxy.Env("localhost", "db"))
+}
+
+// TestProxyTransport checks that the engine is only reached through
+// the proxy over TCP, and that NoProxy is honored per destination.
+func TestProxyTransport(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	proxy := ProxyConfig{HTTPProxy: "http://proxy:3128", NoProxy: "10.0.0.0/8,.internal"}
+	opts, err := proxyClientOpts("unix:///run/podman/podman.sock", proxy)
+	assert.Nil(t, err)
+	assert.Empty(t, opts)
+	opts, err = proxyClientOpts("tcp://engine.example:2375", ProxyConfig{})
+	assert.Nil(t, err)
+	assert.Empty(t, opts)
+	opts, err = proxyClientOpts("tcp://engine.example:2375", proxy)
+	assert.Nil(t, err)
+	assert.Len(t, opts, 1)
+
+	proxyFunc := proxy.transportProxy()
+	for target, expected := range map[string]string{
+		"http://engine.example:2375/_ping": "http://proxy:3128",
+		"http://10.1.2.3:2375/_ping":       "",
+		"http://engine.internal:2375/":     "",
+	} {
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		assert.Nil(t, err)
+		proxyURL, err := proxyFunc(req)
+		assert.Nil(t, err, target)
+		if len(expected) == 0 {
+			assert.Nil(t, proxyURL, target)
+		} else if assert.NotNil(t, proxyURL, target) {
+			assert.Equal(t, expected, proxyURL.String(), target)
+		}
+	}
+}
+
+// TestProxyContainerEnv checks that containers get the proxy settings,
+// with Composer services added to NO_PROXY, and that containerEnv
+// still wins.
+func TestProxyContainerEnv(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	contextPath := "/home/user/project"
+	workspaceFolder := "/workspace"
+	p := &writ.DevcontainerParser{}
+	p.Config.Context = &contextPath
+	p.Config.WorkspaceFolder = &workspaceFolder
+	p.Config.ContainerEnv = writ.EnvVarMap{"http_proxy": "http://other:8080"}
+
+	c := &Client{Proxy: ProxyConfig{HTTPProxy: "http://proxy:3128"}}
+	containerCfg := c.buildContainerConfig(p, "image")
+	assert.Equal(t, []string{
+		"HTTP_PROXY=http://proxy:3128",
+		"NO_PROXY=localhost,127.0.0.1,::1",
+		"no_proxy=localhost,127.0.0.1,::1",
+		"http_proxy=http://other:8080",
+	}, containerCfg.Env)
+
+	c.composerProject = &composetypes.Project{Services: composetypes.Services{{Name: "app"}, {Name: "db"}}}
+	assert.Equal(t, "localhost,127.0.0.1,::1,app,db", c.proxyEnv()["NO_PROXY"])
+
+	c.Proxy = ProxyConfig{}
+	assert.Equal(t, []string{"http_proxy=http://other:8080"}, c.buildContainerConfig(p, "image").Env)
+}
+
+// TestProxyBuildArgs checks that builds get the proxy settings as
+// build arguments, without overriding ones set explicitly, and that
+// changing them doesn't trigger a rebuild.
+func TestProxyBuildArgs(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	c := newFakeClient(t, engine)
+	c.Proxy = ProxyConfig{HTTPProxy: "http://proxy:3128", HTTPSProxy: "http://proxy:3129"}
+	ctx := context.Background()
+
+	ctxDir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(ctxDir, "Containerfile"), []byte("FROM scratch\n"), 0o644))
+	explicit := "http://build-proxy:3128"
+	buildOpts := BuildImageOptions{
+		ImageOptions:   ImageOptions{SuppressOutput: true},
+		ContextPath:    ctxDir,
+		DockerfilePath: "Containerfile",
+		ImageTag:       "brig-test",
+		BuildArgs:      map[string]*string{"https_proxy": &explicit},
+	}
+	assert.Nil(t, c.BuildContainerImage(ctx, buildOpts))
+
+	builds := engine.CallsTo("ImageBuild")
+	assert.Len(t, builds, 1)
+	engineOpts, ok := builds[0].Options.(mobyclient.ImageBuildOptions)
+	assert.True(t, ok)
+	assert.Equal(t, "http://proxy:3128", *engineOpts.BuildArgs["HTTP_PROXY"])
+	assert.Equal(t, "http://proxy:3129", *engineOpts.BuildArgs["HTTPS_PROXY"])
+	assert.Equal(t, explicit, *engineOpts.BuildArgs["https_proxy"])
+	assert.Equal(t, "localhost,127.0.0.1,::1", *engineOpts.BuildArgs["NO_PROXY"])
+
+	c.Proxy.HTTPProxy = "http://other-proxy:3128"
+	assert.Nil(t, c.BuildContainerImage(ctx, buildOpts))
+	assert.Len(t, engine.CallsTo("ImageBuild"), 1)
+}
@@ -0,0 +1,103 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/moby/moby/api/pkg/authconfig"
+	"github.com/moby/moby/api/types/registry"
+)
+
+// DockerHubRegistry is the registry images without a registry host
+// in their reference (e.g., debian:stable) are pulled from.
+const DockerHubRegistry = "docker.io"
+
+// dockerHubAliases are the other names Docker Hub's credentials are
+// commonly stored under.
+var dockerHubAliases = []string{"index.docker.io", "registry-1.docker.io", "https://index.docker.io/v1/"}
+
+// RegistryCredentials maps registry hosts (e.g., ghcr.io) to the
+// credentials to authenticate against them with.
+type RegistryCredentials map[string]registry.AuthConfig
+
+// forRegistry returns the credentials for the registry host, if any.
+//
+// Docker Hub's credentials can be stored under any of the names it
+// goes by.
+func (rc RegistryCredentials) forRegistry(host string) (registry.AuthConfig, bool) {
+	if authCfg, ok := rc[host]; ok {
+		return authCfg, true
+	}
+	if host == DockerHubRegistry {
+		for _, alias := range dockerHubAliases {
+			if authCfg, ok := rc[alias]; ok {
+				return authCfg, true
+			}
+		}
+	}
+	return registry.AuthConfig{}, false
+}
+
+// forImage returns the encoded credentials for the registry imageRef
+// would be pulled from, suitable for passing to the API; it returns
+// an empty string if there aren't any.
+func (rc RegistryCredentials) forImage(imageRef string) string {
+	if len(rc) == 0 {
+		return ""
+	}
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		slog.Debug("unable to determine registry for image; pulling anonymously", "image", imageRef, "error", err)
+		return ""
+	}
+	host := reference.Domain(named)
+	authCfg, ok := rc.forRegistry(host)
+	if !ok {
+		return ""
+	}
+	if len(authCfg.ServerAddress) == 0 {
+		authCfg.ServerAddress = host
+	}
+	encoded, err := authconfig.Encode(authCfg)
+	if err != nil {
+		slog.Error("unable to encode registry credentials; pulling anonymously", "registry", host, "error", err)
+		return ""
+	}
+	slog.Debug("using stored credentials for registry", "registry", host, "username", authCfg.Username)
+	return encoded
+}
+
+// forBuild returns the credentials in the form image builds expect,
+// keyed by registry host; it returns nil if there aren't any.
+func (rc RegistryCredentials) forBuild() map[string]registry.AuthConfig {
+	if len(rc) == 0 {
+		return nil
+	}
+	authConfigs := make(map[string]registry.AuthConfig, len(rc))
+	for host, authCfg := range rc {
+		host = strings.TrimSuffix(host, "/")
+		if len(authCfg.ServerAddress) == 0 {
+			authCfg.ServerAddress = host
+		}
+		authConfigs[host] = authCfg
+	}
+	return authConfigs
+}
@@ -0,0 +1,38 @@
+package trill
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/moby/moby/api/pkg/authconfig"
+	"github.com/moby/moby/api/types/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRegistryCredentialsForImage checks that credentials are picked
+// based on the registry an image would be pulled from.
+func TestRegistryCredentialsForImage(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	creds := RegistryCredentials{
+		"ghcr.io":         {Username: "octocat", Password: "hunter2"},
+		"index.docker.io": {Username: "whale", Password: "krill"},
+	}
+
+	authCfg, err := authconfig.Decode(creds.forImage("ghcr.io/nlsantos/brig:latest"))
+	assert.Nil(t, err)
+	assert.Equal(t, &registry.AuthConfig{Username: "octocat", Password: "hunter2", ServerAddress: "ghcr.io"}, authCfg)
+
+	// Docker Hub images don't name the registry
+	authCfg, err = authconfig.Decode(creds.forImage("debian:stable"))
+	assert.Nil(t, err)
+	assert.Equal(t, "whale", authCfg.Username)
+	assert.Equal(t, DockerHubRegistry, authCfg.ServerAddress)
+
+	assert.Empty(t, creds.forImage("quay.io/podman/stable"))
+	assert.Empty(t, RegistryCredentials(nil).forImage("ghcr.io/nlsantos/brig"))
+	assert.Nil(t, RegistryCredentials(nil).forBuild())
+	assert.Equal(t, "ghcr.io", creds.forBuild()["ghcr.io"].ServerAddress)
+}
@@ -0,0 +1,135 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/mount"
+	"github.com/nlsantos/brig/writ"
+)
+
+// WorkspaceRelabel determines whether the workspace bind mount is
+// relabeled for SELinux.
+type WorkspaceRelabel string
+
+// Supported values for WorkspaceRelabel
+const (
+	WorkspaceRelabelAuto    WorkspaceRelabel = ""        // Relabel as shared if SELinux is enforcing on this host and the engine runs on it
+	WorkspaceRelabelShared  WorkspaceRelabel = "shared"  // Relabel so that all containers can share the workspace
+	WorkspaceRelabelPrivate WorkspaceRelabel = "private" // Relabel so that only the devcontainer can access the workspace
+	WorkspaceRelabelOff     WorkspaceRelabel = "off"     // Leave the workspace's label alone
+)
+
+// selinuxEnforcePath is where the kernel reports whether SELinux is
+// enforcing; it doesn't exist if SELinux is disabled or unsupported.
+const selinuxEnforcePath = "/sys/fs/selinux/enforce"
+
+// ParseWorkspaceRelabel converts s into a WorkspaceRelabel, failing if
+// it isn't one of the supported values. "auto" is the same as an empty
+// s.
+func ParseWorkspaceRelabel(s string) (WorkspaceRelabel, error) {
+	switch WorkspaceRelabel(s) {
+	case "auto":
+		return WorkspaceRelabelAuto, nil
+	case WorkspaceRelabelAuto, WorkspaceRelabelShared, WorkspaceRelabelPrivate, WorkspaceRelabelOff:
+		return WorkspaceRelabel(s), nil
+	default:
+		return "", fmt.Errorf("unsupported workspace relabeling mode: %s", s)
+	}
+}
+
+// workspaceRelabel returns the SELinux relabeling flag to mount the
+// workspace with, as per WorkspaceRelabel.
+//
+// Automatic relabeling only kicks in if the engine runs on this host,
+// as that's the only time the host's SELinux policy applies to the
+// workspace.
+func (c *Client) workspaceRelabel() writ.Relabel {
+	switch c.WorkspaceRelabel {
+	case WorkspaceRelabelShared:
+		return writ.RelabelShared
+	case WorkspaceRelabelPrivate:
+		return writ.RelabelPrivate
+	case WorkspaceRelabelAuto:
+		if c.probesHostPorts() && selinuxEnforcing() {
+			slog.Debug("SELinux is enforcing; relabeling the workspace")
+			return writ.RelabelShared
+		}
+	}
+	return writ.RelabelNone
+}
+
+// selinuxEnforcing reports whether SELinux is in enforcing mode on
+// this host.
+func selinuxEnforcing() bool {
+	enforce, err := os.ReadFile(selinuxEnforcePath)
+	return err == nil && bytes.Equal(bytes.TrimSpace(enforce), []byte("1"))
+}
+
+// addMount adds m to hostCfg.
+//
+// Moby's mount API has no way of expressing SELinux relabeling, so,
+// like docker compose, bind mounts that need it are passed to the
+// engine as a bind string instead of as a structured mount.
+//
+// Requires hostCfg to be a pointer to its respective struct.
+func addMount(hostCfg *container.HostConfig, m writ.MobyMount) {
+	if m.Relabel == writ.RelabelNone || m.Type != mount.TypeBind {
+		hostCfg.Mounts = append(hostCfg.Mounts, m.Mount)
+		return
+	}
+	hostCfg.Binds = append(hostCfg.Binds, bindString(m))
+}
+
+// bindString converts m into the equivalent bind string (e.g.,
+// /src:/dst:ro,z), for use in HostConfig.Binds.
+//
+// Bind strings can't express every bind option; those that are
+// dropped are logged.
+func bindString(m writ.MobyMount) string {
+	var opts []string
+	if m.ReadOnly {
+		opts = append(opts, "ro")
+	}
+	if len(m.Relabel) > 0 {
+		opts = append(opts, string(m.Relabel))
+	}
+	if len(m.Consistency) > 0 && m.Consistency != mount.ConsistencyDefault {
+		opts = append(opts, string(m.Consistency))
+	}
+	if m.BindOptions != nil {
+		if len(m.BindOptions.Propagation) > 0 {
+			opts = append(opts, string(m.BindOptions.Propagation))
+		}
+		if m.BindOptions.NonRecursive || m.BindOptions.CreateMountpoint || m.BindOptions.ReadOnlyNonRecursive || m.BindOptions.ReadOnlyForceRecursive {
+			slog.Warn("bind options other than propagation can't be combined with SELinux relabeling; ignoring them", "target", m.Target)
+		}
+	}
+
+	bind := m.Source + ":" + m.Target
+	if len(opts) > 0 {
+		bind += ":" + strings.Join(opts, ",")
+	}
+	return bind
+}
@@ -0,0 +1,108 @@
+package trill
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/mount"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseWorkspaceRelabel checks that the supported workspace
+// relabeling modes are accepted and anything else is rejected.
+func TestParseWorkspaceRelabel(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for s, expected := range map[string]WorkspaceRelabel{
+		"":        WorkspaceRelabelAuto,
+		"auto":    WorkspaceRelabelAuto,
+		"shared":  WorkspaceRelabelShared,
+		"private": WorkspaceRelabelPrivate,
+		"off":     WorkspaceRelabelOff,
+	} {
+		mode, err := ParseWorkspaceRelabel(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, expected, mode, s)
+	}
+
+	for _, s := range []string{"z", "Z", "Shared", "on"} {
+		_, err := ParseWorkspaceRelabel(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+// TestWorkspaceMountRelabel checks that the workspace bind mount is
+// relabeled as per WorkspaceRelabel, and passed to the engine as a
+// bind string when it is.
+func TestWorkspaceMountRelabel(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	workspacePath := "/home/user/project"
+	workspaceFolder := "/workspace"
+	p := &writ.DevcontainerParser{LocalWorkspaceFolder: workspacePath}
+	p.Config.WorkspaceFolder = &workspaceFolder
+
+	// Not a local engine, so auto leaves it alone
+	c := &Client{SocketAddr: "ssh://user@remote"}
+	hostCfg := c.buildHostConfig(p)
+	assert.Equal(t, []mount.Mount{{Type: mount.TypeBind, Source: workspacePath, Target: workspaceFolder}}, hostCfg.Mounts)
+	assert.Empty(t, hostCfg.Binds)
+
+	c.WorkspaceRelabel = WorkspaceRelabelShared
+	hostCfg = c.buildHostConfig(p)
+	assert.Empty(t, hostCfg.Mounts)
+	assert.Equal(t, []string{"/home/user/project:/workspace:z"}, hostCfg.Binds)
+
+	c.WorkspaceRelabel = WorkspaceRelabelOff
+	hostCfg = c.buildHostConfig(p)
+	assert.Len(t, hostCfg.Mounts, 1)
+	assert.Empty(t, hostCfg.Binds)
+
+	// A workspaceMount's own relabeling wins
+	c.WorkspaceRelabel = WorkspaceRelabelShared
+	p.Config.WorkspaceMount = &writ.MobyMount{
+		Mount:   mount.Mount{Type: mount.TypeBind, Source: "/src", Target: "/code", ReadOnly: true},
+		Relabel: writ.RelabelPrivate,
+	}
+	hostCfg = c.buildHostConfig(p)
+	assert.Equal(t, []string{"/src:/code:ro,Z"}, hostCfg.Binds)
+
+	// Volumes are never relabeled
+	p.Config.WorkspaceMount = &writ.MobyMount{Mount: mount.Mount{Type: mount.TypeVolume, Source: "project-src", Target: "/code"}}
+	hostCfg = c.buildHostConfig(p)
+	assert.Equal(t, []mount.Mount{{Type: mount.TypeVolume, Source: "project-src", Target: "/code"}}, hostCfg.Mounts)
+	assert.Empty(t, hostCfg.Binds)
+}
+
+// TestBindMountsRelabel checks that mounts are only relabeled if they
+// ask for it, carrying their other options over into the bind string.
+func TestBindMountsRelabel(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p := &writ.DevcontainerParser{}
+	p.Config.Mounts = []*writ.MobyMount{
+		{Mount: mount.Mount{Type: mount.TypeBind, Source: "/plain", Target: "/plain"}},
+		{
+			Mount: mount.Mount{
+				Type:        mount.TypeBind,
+				Source:      "/data",
+				Target:      "/data",
+				Consistency: mount.ConsistencyCached,
+				BindOptions: &mount.BindOptions{Propagation: mount.PropagationRSlave},
+			},
+			Relabel: writ.RelabelShared,
+		},
+	}
+
+	c := &Client{WorkspaceRelabel: WorkspaceRelabelPrivate}
+	hostCfg := &container.HostConfig{}
+	c.bindMounts(p, hostCfg)
+	assert.Equal(t, []mount.Mount{{Type: mount.TypeBind, Source: "/plain", Target: "/plain"}}, hostCfg.Mounts)
+	assert.Equal(t, []string{"/data:/data:z,cached,rslave"}, hostCfg.Binds)
+}
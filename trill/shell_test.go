@@ -0,0 +1,52 @@
+package trill
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStartShellRemoteEnv checks that remoteEnv is handed to the
+// shell attached to the host terminal when it's started, rather than
+// baked into the container's environment.
+func TestStartShellRemoteEnv(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Chdir(filepath.Join("testdata", "image"))
+	p, err := writ.NewDevcontainerParser("devcontainer.json")
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+	p.Config.RemoteEnv = writ.EnvVarMap{"EDITOR": "vi"}
+
+	engine := testutil.NewFakeEngine()
+	engine.AddImage("docker.io/library/alpine:3", nil)
+	c := newFakeClient(t, engine)
+	ctx, cancel := context.WithCancel(context.Background())
+	seen := answerLifecycleEvents(ctx, c)
+
+	assert.Nil(t, c.StartDevcontainerContainer(ctx, p, "docker.io/library/alpine:3", "remote-env"))
+	c.CloseLifecycle()
+	<-seen
+	cancel()
+
+	ctr, ok := engine.Container("remote-env")
+	assert.True(t, ok)
+	assert.NotContains(t, ctr.Config.Env, "EDITOR=vi")
+
+	assert.Nil(t, c.startShell(context.Background(), 24, 80))
+	execs := engine.CallsTo("ExecCreate")
+	if assert.NotEmpty(t, execs) {
+		opts := execs[len(execs)-1].Options.(mobyclient.ExecCreateOptions)
+		assert.Contains(t, opts.Env, "EDITOR=vi")
+	}
+	assert.Nil(t, c.Close())
+}
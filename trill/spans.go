@@ -0,0 +1,50 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"sync"
+)
+
+// SpanRecorder is a function that Client calls when it starts on an
+// operation worth timing (e.g., building an image, or creating a
+// container), passing in a short description of it.
+//
+// The function it returns is called with the operation's error, if
+// any, once it's done. The context it returns is the one the
+// operation runs with, so the operations started as part of it can be
+// told apart from the rest.
+type SpanRecorder func(ctx context.Context, name string) (context.Context, func(err error))
+
+// startSpan has SpanRecorder record the start of the operation named
+// name, if it's set.
+//
+// The returned function ends the span; only the first call to it
+// counts, so it's safe to both call it as soon as the operation is
+// done and defer it to catch early returns.
+func (c *Client) startSpan(ctx context.Context, name string) (context.Context, func(err error)) {
+	if c.SpanRecorder == nil {
+		return ctx, func(error) {}
+	}
+	ctx, end := c.SpanRecorder(ctx, name)
+	var once sync.Once
+	return ctx, func(err error) {
+		once.Do(func() { end(err) })
+	}
+}
@@ -0,0 +1,52 @@
+package trill
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSpanRecorder checks that pulls are reported to SpanRecorder,
+// including whether they failed, and that each span is only ended
+// once.
+func TestSpanRecorder(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// Without a SpanRecorder, spans are no-ops
+	c := &Client{}
+	ctx := context.Background()
+	spanCtx, end := c.startSpan(ctx, "nothing")
+	assert.Equal(t, ctx, spanCtx)
+	end(nil)
+
+	type endedSpan struct {
+		name string
+		err  error
+	}
+	var started []string
+	var ended []endedSpan
+	engine := testutil.NewFakeEngine()
+	c = newFakeClient(t, engine)
+	c.SpanRecorder = func(ctx context.Context, name string) (context.Context, func(error)) {
+		started = append(started, name)
+		return ctx, func(err error) {
+			ended = append(ended, endedSpan{name, err})
+		}
+	}
+
+	assert.Nil(t, c.PullContainerImage(ctx, "alpine:3", ImageOptions{SuppressOutput: true}))
+	assert.Equal(t, []string{"pull image alpine:3"}, started)
+	assert.Equal(t, []endedSpan{{"pull image alpine:3", nil}}, ended)
+
+	_, end = c.startSpan(ctx, "twice")
+	end(errors.New("failed"))
+	end(nil)
+	assert.Len(t, ended, 2)
+	assert.EqualError(t, ended[1].err, "failed")
+}
@@ -20,6 +20,7 @@
 package trill
 
 import (
+	"context"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -28,15 +29,21 @@ import (
 	"golang.org/x/term"
 )
 
-// Hooks into terminal resize signals on *nix
-func (c *Client) listenForTerminalResize() {
+// Hooks into terminal resize signals on *nix until ctx is cancelled
+func (c *Client) listenForTerminalResize(ctx context.Context) {
 	resizeCh := make(chan os.Signal, 1)
 	signal.Notify(resizeCh, syscall.SIGWINCH)
 
 	go func() {
-		defer close(resizeCh)
+		defer signal.Stop(resizeCh)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-resizeCh:
+			}
 
-		for range resizeCh {
 			fd := int(os.Stdin.Fd())
 			if !term.IsTerminal(fd) {
 				slog.Debug("not a terminal", "fd", fd)
@@ -47,7 +54,7 @@ func (c *Client) listenForTerminalResize() {
 				slog.Error("could not get terminal's size", "error", err)
 				return
 			}
-			c.ResizeContainer(uint(h), uint(w)) // #nosec G115
+			c.resizeShell(ctx, uint(h), uint(w)) // #nosec G115
 		}
 	}()
 }
@@ -20,32 +20,44 @@
 package trill
 
 import (
+	"context"
 	"log/slog"
 	"os"
-	"time"
 
 	"golang.org/x/term"
 )
 
-// Hooks into terminal resize signals on Windows
-func (c *Client) listenForTerminalResize() {
+// Hooks into console window resize events on Windows until ctx is
+// cancelled; they're picked out of the console's input by
+// consoleInput.
+func (c *Client) listenForTerminalResize(ctx context.Context) {
 	go func() {
-		ticker := time.NewTicker(250 * time.Millisecond)
-		defer ticker.Stop()
-
 		fd := int(os.Stdout.Fd())
 		if !term.IsTerminal(fd) {
 			slog.Debug("not a terminal", "fd", fd)
 			return
 		}
+		lastW, lastH, _ := term.GetSize(fd)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-consoleResized:
+			}
 
-		for range ticker.C {
 			w, h, err := term.GetSize(fd)
 			if err != nil {
 				slog.Error("could not get terminal's size", "error", err)
 				return
 			}
-			c.ResizeContainer(uint(h), uint(w)) // #nosec G115
+			// Changes to the screen buffer are reported as well,
+			// which don't necessarily change the window's size
+			if w == lastW && h == lastH {
+				continue
+			}
+			lastW, lastH = w, h
+			c.resizeShell(ctx, uint(h), uint(w)) // #nosec G115
 		}
 	}()
 }
@@ -0,0 +1,264 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	composetypes "github.com/compose-spec/compose-go/types"
+	"github.com/heimdalr/dag"
+	"github.com/moby/moby/api/types/container"
+	mobyclient "github.com/moby/moby/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// LifecycleEvents is a list of event codes that are fired at several
+// points during a devcontainer's lifecycle
+type LifecycleEvents uint
+
+// During a devcontainer's lifecycle, several events are tracked
+const (
+	LifecycleInitialize LifecycleEvents = iota
+	LifecycleOnCreate
+	LifecycleUpdate
+	LifecyclePostCreate
+	LifecyclePostStart
+	LifecyclePostAttach
+	LifecycleFeatureInstall
+)
+
+// PrivilegedPortElevator is a function that Client can use to convert
+// privileged ports it encounters into non-privileged ports.
+//
+// It is passed the privileged port number and the return value is
+// used in the original port's stead.
+//
+// There is no check performed on the return value to see if it
+// actually produces a port number beyond the privileged port range.
+type PrivilegedPortElevator func(uint16) uint16
+
+// FeatureImageBuilder is a function that Client can use to build an
+// image with devcontainer features installed on top of baseImage,
+// tagging it imageTag.
+type FeatureImageBuilder func(ctx context.Context, ctxPath string, baseImage string, imageTag string) error
+
+// Client holds metadata for communicating with Podman/Docker.
+type Client struct {
+	AttachShell   string        // The shell attached to the host terminal; if empty, the remote user's shell, as listed in /etc/passwd, is used
+	BuildProgress BuildProgress // How the progress of image builds is shown
+	CacheFrom     []string      // Images used as build cache by every build, in addition to the ones each build names
+	CommandStderr io.Writer     // If non-nil, what the devcontainer's own command writes to stderr is copied here until the host terminal is attached; only used when overrideCommand is false
+	CommandStdout io.Writer     // Like CommandStderr, but for stdout
+	ContainerID   string        // The internal ID the API assigned to the created container
+	// Channel to broadcast the devcontainer's (in a Composer project,
+	// the container named in the service field) lifecycle events on
+	DevcontainerLifecycleChan     chan LifecycleEvents
+	DevcontainerLifecycleResp     chan bool
+	DependencyPollInterval        time.Duration // How often the devcontainer's health is checked while waiting for it to be healthy; service dependencies follow the engine's events instead
+	DependencySettleTime          time.Duration // How long a dependency's condition has to hold before it's considered satisfied
+	DependencyTimeout             time.Duration // How long to wait for a dependency's condition to be satisfied; 0 or negative waits indefinitely
+	DetachKeys                    []byte        // Typing these into the host terminal detaches it from the devcontainer, leaving it running; if empty, it's only detached once the shell exits
+	CloneWorkspaceInVolume        bool          // If true, the workspace is copied into a named volume instead of being bind-mounted
+	ExportBuildCache              bool          // If true, builds embed their cache metadata in the images they produce, so the images can be used as build cache elsewhere once pushed
+	FeatureImageBuilder           FeatureImageBuilder
+	ForceRebuild                  bool                   // If true, images are rebuilt even if their build inputs haven't changed
+	ForwardAddress                netip.Addr             // The host address appPort and forwardPorts are bound to, unless they specify one; 127.0.0.1 if invalid
+	Headless                      bool                   // If true, the devcontainer's TTY is never connected to, so the host terminal is left alone
+	HealthTimeout                 time.Duration          // How long to wait for the devcontainer to turn healthy before running postCreateCommand, if it has a healthcheck; extended to cover the healthcheck itself, and negative waits indefinitely
+	KeepOnFailure                 bool                   // If true, resources created by a Composer project deployment that fails partway through are left in place
+	KeepSynthesizedContainerfiles bool                   // If true, Containerfiles synthesized from dockerfile_inline are left in place once the images are built, for debugging
+	NestedIgnoreFiles             bool                   // If true, ignore files in subdirectories of a build context are also honored
+	Platform                      Platform               // Platform details for any containers created
+	PrivilegedPortElevator        PrivilegedPortElevator // If non-nil, will be called whenever a binding for a port number < 1024 is encountered; its return value will be used in place of the original port
+	PortConflictResolver          PortConflictResolver   // If non-nil, called whenever a host port that's to be bound is already in use; see claimHostPort
+	Proxy                         ProxyConfig            // Proxy settings propagated into image builds (as build arguments) and containers (as environment variables); see proxyEnv
+	PullPolicy                    PullPolicy             // Whether images are pulled, unless a pull calls for a specific policy
+	ReattachAttempts              int                    // How many times reconnecting to the shell attached to the host terminal is attempted when the connection drops; 0 disables it
+	ReattachDelay                 time.Duration          // How long to wait before the first attempt to reconnect; doubled after each one that fails
+	RegistryCredentials           RegistryCredentials    // Used to authenticate against registries when pulling images, including during builds
+	SocketAddr                    string                 // The socket/named pipe used to communicate with the server
+	SpanRecorder                  SpanRecorder           // If non-nil, called whenever an operation worth timing (e.g., an image build) starts
+	UsernsMode                    container.UsernsMode   // The user namespace mode the devcontainer is created with; if empty, it's picked based on remoteUser and updateRemoteUserUID. See ParseUsernsMode
+	WorkspaceRelabel              WorkspaceRelabel       // Whether the workspace bind mount is relabeled for SELinux, unless it specifies its own relabeling
+	Version                       string                 // The version of the program using trill; recorded in VersionLabel on the resources it creates
+
+	attachResp        *mobyclient.HijackedResponse // The connection to the shell attached to the host terminal
+	commandOutputMu   sync.Mutex                   // Guards commandOutputStop
+	commandOutputStop context.CancelFunc           // Stops copying the devcontainer's own output to CommandStdout and CommandStderr
+	isAttached        bool
+	shellExecID       string      // The exec instance of the shell attached to the host terminal
+	shellHeight       uint        // The height of the shell's pseudo-TTY, for when it's reattached
+	shellWidth        uint        // The width of the shell's pseudo-TTY, for when it's reattached
+	shellMu           sync.Mutex  // Guards attachResp, detaching, shellExecID, and the shell's size, which change when the shell is reattached
+	detaching         bool        // Set once DetachKeys have been typed
+	shellOpts         ExecOptions // The user, environment, and working directory the attached shell runs with
+	shellLogin        bool        // If true, the attached shell is started as a login shell
+	ttyBuildMu        sync.Mutex  // Held while a build's progress is drawn with BuildProgressTTY
+	output            outputMux   // Serializes the output of image pulls/builds, which can run concurrently
+	lifecycleDone     sync.Once
+	mobyClient        EngineAPI
+	composerProject   *composetypes.Project
+	servicesDAG       *dag.DAG
+
+	// What's known of the containers of a Composer project's services
+	// before it's deployed; see diffComposerServices
+	serviceConfigHashes map[string]string                   // Service name -> hash of its configuration
+	serviceContainers   map[string]existingServiceContainer // Container name -> the container by that name left over from an earlier run
+	serviceOutput       *composerOutput                     // Streams the output of the services' containers, if asked to
+
+	// Resources created while deploying a Composer project; these are
+	// what get removed on teardown
+	createdContainers map[string][]string // Service name -> IDs of its replicas' containers
+	createdNetworks   []string
+	fileObjectsDir    string // Holds files materialized from secrets and configs
+	containerfilesDir string // Holds Containerfiles synthesized from dockerfile_inline
+	resourcesMu       sync.Mutex
+
+	// The host ports claimed by the bindings of the containers created
+	// so far, mapped to the port that was asked for
+	hostPortClaims map[hostPortKey]string
+	hostPortsMu    sync.Mutex
+
+	// The volume the workspace was cloned into, if any, and the image
+	// used to access it; see CloneWorkspaceInVolume
+	workspaceVolume      string
+	workspaceVolumeImage string
+}
+
+// Platform contains data on the target state of any created
+// containers
+type Platform ocispec.Platform
+
+// ClientOptions holds the settings for creating a Client.
+type ClientOptions struct {
+	SocketAddr             string                 // The socket/named pipe used to communicate with the server
+	Platform               Platform               // Platform details for any containers created
+	FeatureImageBuilder    FeatureImageBuilder    // Used to build images with devcontainer features installed; optional
+	PrivilegedPortElevator PrivilegedPortElevator // Used to remap privileged ports; optional
+	PortConflictResolver   PortConflictResolver   // Used to pick another port when a host port is in use; optional
+	Proxy                  ProxyConfig            // Proxy settings to propagate into builds and containers, and to reach the engine over TCP with; optional
+	RegistryCredentials    RegistryCredentials    // Credentials for private registries; optional
+	SpanRecorder           SpanRecorder           // Used to time image builds, pulls, and the like; optional
+	Engine                 EngineAPI              // Used in place of a Moby client connected to SocketAddr, if non-nil; mostly useful for tests
+	Version                string                 // The version of the program using trill; optional
+}
+
+// NewClient returns a Client that's set to communicate with
+// Podman/Docker via opts.SocketAddr.
+//
+// No connection is made until the Client is first used; call Ping to
+// check that the engine is reachable.
+//
+// If opts.Engine is set, it's used as-is and opts.SocketAddr is only
+// used in messages.
+func NewClient(opts ClientOptions) (*Client, error) {
+	c := &Client{
+		DevcontainerLifecycleChan: make(chan LifecycleEvents),
+		DevcontainerLifecycleResp: make(chan bool, 1),
+		DependencyPollInterval:    DefaultDependencyPollInterval,
+		DependencySettleTime:      DefaultDependencySettleTime,
+		DependencyTimeout:         DefaultDependencyTimeout,
+		DetachKeys:                defaultDetachKeys,
+		HealthTimeout:             DefaultHealthTimeout,
+		ReattachAttempts:          DefaultReattachAttempts,
+		ReattachDelay:             DefaultReattachDelay,
+		FeatureImageBuilder:       opts.FeatureImageBuilder,
+		Platform:                  opts.Platform,
+		PrivilegedPortElevator:    opts.PrivilegedPortElevator,
+		PortConflictResolver:      opts.PortConflictResolver,
+		Proxy:                     opts.Proxy,
+		RegistryCredentials:       opts.RegistryCredentials,
+		SocketAddr:                opts.SocketAddr,
+		SpanRecorder:              opts.SpanRecorder,
+		Version:                   opts.Version,
+	}
+
+	if opts.Engine != nil {
+		c.mobyClient = opts.Engine
+		return c, nil
+	}
+
+	if c.Proxy.IsSet() {
+		warnLoopbackProxy(c.Proxy)
+	}
+	proxyOpts, err := proxyClientOpts(c.SocketAddr, c.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("%w at %s: %w", ErrEngineUnreachable, c.SocketAddr, err)
+	}
+	// The proxy's HTTP client replaces the one WithHost configured, so
+	// it has to come after it
+	mobyClient, err := mobyclient.New(slices.Concat([]mobyclient.Opt{mobyclient.WithHost(c.SocketAddr)}, proxyOpts, dialOpts(c.SocketAddr))...)
+	if err != nil {
+		slog.Error("could not create Moby client", "socket", c.SocketAddr, "error", err)
+		return nil, fmt.Errorf("%w at %s: %w", ErrEngineUnreachable, c.SocketAddr, err)
+	}
+	c.mobyClient = mobyClient
+
+	return c, nil
+}
+
+// Ping checks that the Podman/Docker engine is reachable through
+// SocketAddr.
+//
+// Returns an error wrapping ErrEngineUnreachable if it isn't.
+func (c *Client) Ping(ctx context.Context) error {
+	if _, err := c.mobyClient.Ping(ctx, mobyclient.PingOptions{}); err != nil {
+		slog.Error("unable to reach the engine", "socket", c.SocketAddr, "error", err)
+		return fmt.Errorf("%w at %s: %w", ErrEngineUnreachable, c.SocketAddr, err)
+	}
+	return nil
+}
+
+// EngineInfo describes the Podman/Docker engine a Client talks to.
+type EngineInfo struct {
+	APIVersion     string `json:"apiVersion"`
+	OSType         string `json:"osType"`
+	BuilderVersion string `json:"builderVersion,omitempty"`
+}
+
+// EngineInfo asks the engine about itself.
+func (c *Client) EngineInfo(ctx context.Context) (EngineInfo, error) {
+	pingRes, err := c.mobyClient.Ping(ctx, mobyclient.PingOptions{})
+	if err != nil {
+		return EngineInfo{}, fmt.Errorf("%w at %s: %w", ErrEngineUnreachable, c.SocketAddr, err)
+	}
+	return EngineInfo{
+		APIVersion:     pingRes.APIVersion,
+		OSType:         pingRes.OSType,
+		BuilderVersion: string(pingRes.BuilderVersion),
+	}, nil
+}
+
+// Close is a clean up function for trill.Client.
+//
+// This should be deferred.
+func (c *Client) Close() (err error) {
+	c.stopCommandOutput()
+	c.closeShellConn()
+	if err = c.mobyClient.Close(); err != nil {
+		slog.Error("could not close Moby client", "error", err)
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/nlsantos/brig/writ"
+)
+
+// usernsModes are the user namespace modes ParseUsernsMode accepts;
+// the ones that end in a colon take a value after it, and keep-id and
+// auto may be followed by options (e.g., keep-id:uid=1000,gid=1000).
+//
+// Apart from host, these are only understood by Podman.
+var usernsModes = []string{"auto", "container:", "host", "keep-id", "nomap", "ns:", "private"}
+
+// ParseUsernsMode converts s into the user namespace mode a
+// devcontainer is created with, failing if it isn't one of the
+// supported modes.
+//
+// An empty s leaves the mode for Client to pick; see
+// selectUsernsMode.
+func ParseUsernsMode(s string) (container.UsernsMode, error) {
+	if len(s) == 0 {
+		return "", nil
+	}
+	mode, opts, hasOpts := strings.Cut(s, ":")
+	for _, supported := range usernsModes {
+		if takesValue := strings.HasSuffix(supported, ":"); takesValue {
+			if mode+":" == supported && len(opts) > 0 {
+				return container.UsernsMode(s), nil
+			}
+			continue
+		}
+		if mode == supported && (!hasOpts || ((mode == "keep-id" || mode == "auto") && len(opts) > 0)) {
+			return container.UsernsMode(s), nil
+		}
+	}
+	return "", fmt.Errorf("unsupported user namespace mode: %s", s)
+}
+
+// selectUsernsMode sets the user namespace mode the devcontainer is
+// created with.
+//
+// UsernsMode is used if it's set. Otherwise, if updateRemoteUserUID is
+// in effect, the host user is mapped onto remoteUser (see
+// keepIDUsernsMode), so files it creates in the workspace are owned by
+// the host user; a mode set by a Composer service is left alone.
+//
+// Requires containerCfg and hostCfg to be pointers to their
+// respective structs, and remoteUser to have been determined (see
+// setContainerAndRemoteUser).
+func (c *Client) selectUsernsMode(ctx context.Context, p *writ.DevcontainerParser, containerCfg *container.Config, hostCfg *container.HostConfig) error {
+	switch {
+	case len(c.UsernsMode) > 0:
+		slog.Debug("using the user namespace mode set explicitly", "mode", c.UsernsMode)
+		hostCfg.UsernsMode = c.UsernsMode
+		return nil
+	case !*p.Config.UpdateRemoteUserUID:
+		return nil
+	case len(hostCfg.UsernsMode) > 0:
+		slog.Debug("leaving the service's user namespace mode alone", "mode", hostCfg.UsernsMode)
+		return nil
+	}
+
+	user := *p.Config.ContainerUser
+	if p.Config.RemoteUser != nil && len(*p.Config.RemoteUser) > 0 {
+		user = *p.Config.RemoteUser
+	}
+	mode, err := c.keepIDUsernsMode(ctx, user, containerCfg, hostCfg)
+	if err != nil {
+		return err
+	}
+	slog.Debug("mapping the host user onto the remote user", "user", user, "mode", mode)
+	hostCfg.UsernsMode = mode
+	return nil
+}
+
+// keepIDUsernsMode returns the keep-id user namespace mode that maps
+// the host user onto user in the devcontainer.
+//
+// user can be a name or a UID, optionally followed by a group name or
+// GID (e.g., vscode, 1000, or 1000:1000). Names are resolved in a
+// temporary container; a group name is only used if it can be
+// resolved as well.
+func (c *Client) keepIDUsernsMode(ctx context.Context, user string, containerCfg *container.Config, hostCfg *container.HostConfig) (container.UsernsMode, error) {
+	userName, groupName, _ := strings.Cut(user, ":")
+	if userName == "root" {
+		userName = "0"
+	}
+	uid, uidErr := strconv.ParseUint(userName, 10, 32)
+	gid, gidErr := strconv.ParseUint(groupName, 10, 32)
+	hasGID := gidErr == nil
+
+	if uidErr != nil || (len(groupName) > 0 && !hasGID) {
+		// Spin up a temporary container, grab the IDs, then spin the
+		// temp container down
+		dupContainerCfg := *containerCfg
+		dupContainerCfg.User = "root"
+		idArgs := [][]string{{fmt.Sprintf("id -u %s", userName)}}
+		if !hasGID {
+			// Without a group of its own, the user's primary group is
+			// used
+			group := fmt.Sprintf("id -g %s", userName)
+			if len(groupName) > 0 {
+				group = fmt.Sprintf("getent group %s | cut -d: -f3", groupName)
+			}
+			idArgs = append(idArgs, []string{group})
+		}
+		slog.Debug("resolving the user's IDs", "user", user)
+		cmdStdout, _, err := c.multiExecInTempContainer(ctx, &dupContainerCfg, hostCfg, nil, idArgs)
+		if err != nil {
+			slog.Error("encountered an error while trying to spin up a temporary container to resolve the user's IDs", "error", err)
+			return "", err
+		}
+		if uid, err = strconv.ParseUint(strings.TrimSpace(cmdStdout[0].String()), 10, 32); err != nil {
+			slog.Error("encountered an error while trying to resolve the user's ID", "user", user, "error", err)
+			return "", err
+		}
+		if len(cmdStdout) > 1 {
+			gid, gidErr = strconv.ParseUint(strings.TrimSpace(cmdStdout[1].String()), 10, 32)
+			hasGID = gidErr == nil
+		}
+	} else if len(groupName) == 0 && uid == 0 {
+		// root's group is root
+		hasGID = true
+	}
+
+	if hasGID {
+		return container.UsernsMode(fmt.Sprintf("keep-id:uid=%d,gid=%d", uid, gid)), nil
+	}
+	return container.UsernsMode(fmt.Sprintf("keep-id:uid=%d", uid)), nil
+}
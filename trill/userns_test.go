@@ -0,0 +1,102 @@
+package trill
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	"github.com/moby/moby/api/types/container"
+	"github.com/nlsantos/brig/internal/testutil"
+	"github.com/nlsantos/brig/writ"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseUsernsMode checks that only the user namespace modes the
+// engines understand are accepted.
+func TestParseUsernsMode(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, mode := range []string{"", "keep-id", "keep-id:uid=1000,gid=1000", "auto", "auto:size=65536", "host", "nomap", "private", "ns:/proc/1/ns/user", "container:db"} {
+		parsed, err := ParseUsernsMode(mode)
+		assert.Nil(t, err, mode)
+		assert.Equal(t, container.UsernsMode(mode), parsed)
+	}
+	for _, mode := range []string{"keepid", "host:1000", "keep-id:", "ns:", "container"} {
+		_, err := ParseUsernsMode(mode)
+		assert.NotNil(t, err, mode)
+	}
+}
+
+// TestSelectUsernsMode checks that the host user is mapped onto
+// remoteUser when updateRemoteUserUID is in effect, resolving names to
+// IDs as needed, and that explicit and Composer-provided modes win.
+func TestSelectUsernsMode(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	engine := testutil.NewFakeEngine()
+	engine.ExecHandler = func(containerID string, cmd []string) testutil.ExecResult {
+		script := cmd[len(cmd)-1]
+		switch {
+		case strings.HasPrefix(script, "id -u"):
+			return testutil.ExecResult{Stdout: "1000\n"}
+		case strings.HasPrefix(script, "id -g"):
+			return testutil.ExecResult{Stdout: "1001\n"}
+		case strings.HasPrefix(script, "getent group"):
+			return testutil.ExecResult{Stdout: "2000\n"}
+		}
+		return testutil.ExecResult{ExitCode: 127}
+	}
+	engine.AddImage("docker.io/library/alpine:3", &dockerspec.DockerOCIImageConfig{})
+	c := newFakeClient(t, engine)
+
+	for _, tc := range []struct {
+		containerUser string
+		remoteUser    string
+		expected      container.UsernsMode
+	}{
+		{"root", "", "keep-id:uid=0,gid=0"},
+		{"root", "vscode", "keep-id:uid=1000,gid=1001"},
+		{"root", "1000", "keep-id:uid=1000"},
+		{"1000:1000", "", "keep-id:uid=1000,gid=1000"},
+		{"vscode:docker", "", "keep-id:uid=1000,gid=2000"},
+	} {
+		p := &writ.DevcontainerParser{}
+		updateRemoteUserUID := true
+		p.Config.UpdateRemoteUserUID = &updateRemoteUserUID
+		p.Config.ContainerUser = &tc.containerUser
+		if len(tc.remoteUser) > 0 {
+			p.Config.RemoteUser = &tc.remoteUser
+		}
+		hostCfg := &container.HostConfig{}
+		assert.Nil(t, c.selectUsernsMode(context.Background(), p, &container.Config{Image: "docker.io/library/alpine:3"}, hostCfg), tc)
+		assert.Equal(t, tc.expected, hostCfg.UsernsMode, tc)
+	}
+
+	p := &writ.DevcontainerParser{}
+	updateRemoteUserUID := true
+	p.Config.UpdateRemoteUserUID = &updateRemoteUserUID
+	user := "vscode"
+	p.Config.ContainerUser = &user
+
+	// A Composer service's own mode is left alone
+	hostCfg := &container.HostConfig{UsernsMode: "host"}
+	assert.Nil(t, c.selectUsernsMode(context.Background(), p, &container.Config{}, hostCfg))
+	assert.Equal(t, container.UsernsMode("host"), hostCfg.UsernsMode)
+
+	// Unless one is set explicitly
+	c.UsernsMode = "auto"
+	assert.Nil(t, c.selectUsernsMode(context.Background(), p, &container.Config{}, hostCfg))
+	assert.Equal(t, container.UsernsMode("auto"), hostCfg.UsernsMode)
+
+	// Nothing is mapped without updateRemoteUserUID
+	c.UsernsMode = ""
+	updateRemoteUserUID = false
+	hostCfg = &container.HostConfig{}
+	assert.Nil(t, c.selectUsernsMode(context.Background(), p, &container.Config{}, hostCfg))
+	assert.Empty(t, hostCfg.UsernsMode)
+}
@@ -0,0 +1,246 @@
+/*
+   trill: a lightweight wrapper for Podman/Docker REST API calls
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package trill houses a thin wrapper for communicating with podman
+// and Docker via their REST API.
+package trill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/moby/go-archive"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/mount"
+	mobyclient "github.com/moby/moby/client"
+	"github.com/nlsantos/brig/writ"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// volumeHelperMountPoint is where a volume is mounted inside the
+// helper containers used to move files in and out of it.
+const volumeHelperMountPoint = "/brig-volume"
+
+// EnsureVolume creates a named volume with labels applied to it if it
+// doesn't exist yet.
+//
+// Returns true if the volume had to be created.
+func (c *Client) EnsureVolume(ctx context.Context, volumeName string, labels map[string]string) (created bool, err error) {
+	if _, err := c.mobyClient.VolumeInspect(ctx, volumeName, mobyclient.VolumeInspectOptions{}); err == nil {
+		slog.Debug("volume already exists; reusing", "volume", volumeName)
+		return false, nil
+	}
+
+	slog.Debug("creating volume", "volume", volumeName)
+	if _, err := c.mobyClient.VolumeCreate(ctx, mobyclient.VolumeCreateOptions{Name: volumeName, Labels: labels}); err != nil {
+		slog.Error("encountered an error creating a volume", "volume", volumeName, "error", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// CopyDirToVolume copies the contents of srcDir on the host into the
+// root of volumeName.
+//
+// The copy is performed through a helper container based on
+// imageTag; the container is never started, so the image doesn't need
+// anything in particular in it. Files are owned by the host user
+// inside the volume, so they line up with the devcontainer's user
+// when its UID is mapped to the host's (see updateRemoteUserUID).
+func (c *Client) CopyDirToVolume(ctx context.Context, srcDir string, volumeName string, imageTag string) error {
+	tarOpts := &archive.TarOptions{
+		IncludeSourceDir: false,
+		NoLchown:         true,
+	}
+	if uid, gid := os.Getuid(), os.Getgid(); uid >= 0 && gid >= 0 {
+		tarOpts.ChownOpts = &archive.ChownOpts{UID: uid, GID: gid}
+	}
+
+	return c.withVolumeHelper(ctx, volumeName, imageTag, func(containerID string) error {
+		content, err := archive.TarWithOptions(srcDir, tarOpts)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := content.Close(); err != nil {
+				slog.Error("could not close archive of directory", "path", srcDir, "error", err)
+			}
+		}()
+
+		slog.Debug("copying directory into volume", "path", srcDir, "volume", volumeName)
+		_, err = c.mobyClient.CopyToContainer(ctx, containerID, mobyclient.CopyToContainerOptions{
+			DestinationPath: volumeHelperMountPoint,
+			Content:         content,
+			CopyUIDGID:      true,
+		})
+		return err
+	})
+}
+
+// CopyDirToDevcontainer copies srcDir on the host into the
+// devcontainer as dstDir/name.
+//
+// dstDir has to exist in the container already; the copied files are
+// owned by root.
+func (c *Client) CopyDirToDevcontainer(ctx context.Context, srcDir string, dstDir string, name string) error {
+	srcBase := filepath.Base(srcDir)
+	content, err := archive.TarWithOptions(filepath.Dir(srcDir), &archive.TarOptions{
+		IncludeFiles: []string{srcBase},
+		NoLchown:     true,
+		RebaseNames:  map[string]string{srcBase: name},
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := content.Close(); err != nil {
+			slog.Error("could not close archive of directory", "path", srcDir, "error", err)
+		}
+	}()
+
+	slog.Debug("copying directory into devcontainer", "path", srcDir, "destination", dstDir, "name", name)
+	_, err = c.mobyClient.CopyToContainer(ctx, c.ContainerID, mobyclient.CopyToContainerOptions{
+		DestinationPath: dstDir,
+		Content:         content,
+	})
+	return err
+}
+
+// CopyVolumeToDir copies the contents of volumeName into dstDir on
+// the host, overwriting files that exist in both.
+//
+// Files that only exist in dstDir are left alone. As with
+// CopyDirToVolume, the copy is performed through a helper container
+// based on imageTag.
+func (c *Client) CopyVolumeToDir(ctx context.Context, volumeName string, dstDir string, imageTag string) error {
+	return c.withVolumeHelper(ctx, volumeName, imageTag, func(containerID string) error {
+		// The trailing /. copies the contents of the directory rather
+		// than the directory itself, like `docker cp`
+		srcPath := volumeHelperMountPoint + "/."
+		slog.Debug("copying volume contents into directory", "volume", volumeName, "path", dstDir)
+		res, err := c.mobyClient.CopyFromContainer(ctx, containerID, mobyclient.CopyFromContainerOptions{SourcePath: srcPath})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := res.Content.Close(); err != nil {
+				slog.Error("could not close archive of volume contents", "volume", volumeName, "error", err)
+			}
+		}()
+
+		srcInfo := archive.CopyInfo{
+			Path:   srcPath,
+			Exists: true,
+			IsDir:  res.Stat.Mode.IsDir(),
+		}
+		return archive.CopyTo(res.Content, srcInfo, dstDir)
+	})
+}
+
+// SyncWorkspaceVolume copies the contents of the volume the workspace
+// was cloned into back to dstDir; see CloneWorkspaceInVolume.
+//
+// It is a no-op if the workspace wasn't cloned into a volume.
+func (c *Client) SyncWorkspaceVolume(ctx context.Context, dstDir string) error {
+	if len(c.workspaceVolume) == 0 {
+		return nil
+	}
+	slog.Info("syncing workspace volume back to the host", "volume", c.workspaceVolume, "path", dstDir)
+	return c.CopyVolumeToDir(ctx, c.workspaceVolume, dstDir, c.workspaceVolumeImage)
+}
+
+// prepareWorkspaceVolume clones the workspace into a named volume and
+// points workspaceMount at it.
+//
+// The workspace is only copied into the volume when the
+// volume is first created; after that, the volume's contents take
+// precedence, so work done inside the devcontainer isn't clobbered
+// between runs. Use SyncWorkspaceVolume to bring changes back to the
+// host.
+func (c *Client) prepareWorkspaceVolume(ctx context.Context, p *writ.DevcontainerParser, imageTag string, containerName string) error {
+	if p.Config.WorkspaceMount != nil {
+		slog.Warn("devcontainer.json specifies workspaceMount; not cloning the workspace into a volume")
+		return nil
+	}
+
+	volumeName := fmt.Sprintf("%s--workspace", containerName)
+	created, err := c.EnsureVolume(ctx, volumeName, c.ResourceLabels(p))
+	if err != nil {
+		return err
+	}
+	if created {
+		slog.Info("cloning workspace into volume", "path", p.WorkspaceFolder(), "volume", volumeName)
+		if err := c.CopyDirToVolume(ctx, p.WorkspaceFolder(), volumeName, imageTag); err != nil {
+			slog.Error("encountered an error cloning the workspace into a volume", "volume", volumeName, "error", err)
+			// Don't leave a half-populated volume behind, or it
+			// would be reused as-is on the next run
+			if _, rmErr := c.mobyClient.VolumeRemove(context.WithoutCancel(ctx), volumeName, mobyclient.VolumeRemoveOptions{}); rmErr != nil {
+				err = errors.Join(err, rmErr)
+			}
+			return err
+		}
+	}
+
+	p.Config.WorkspaceMount = &writ.MobyMount{Mount: mount.Mount{
+		Type:   mount.TypeVolume,
+		Source: volumeName,
+		Target: *p.Config.WorkspaceFolder,
+	}}
+	c.workspaceVolume = volumeName
+	c.workspaceVolumeImage = imageTag
+	return nil
+}
+
+// withVolumeHelper creates a container based on imageTag with
+// volumeName mounted at volumeHelperMountPoint, passes its ID to fn,
+// then removes the container.
+//
+// The container is only ever created, not started; the engine is
+// still able to copy files in and out of its mounts.
+func (c *Client) withVolumeHelper(ctx context.Context, volumeName string, imageTag string, fn func(containerID string) error) (err error) {
+	createResp, err := c.mobyClient.ContainerCreate(ctx, mobyclient.ContainerCreateOptions{
+		Config: &container.Config{
+			// Never run, but some engines refuse to create a
+			// container for an image without a command
+			Cmd:    []string{"true"},
+			Image:  imageTag,
+			Labels: c.ResourceLabels(nil),
+		},
+		HostConfig: &container.HostConfig{
+			Mounts: []mount.Mount{{
+				Type:   mount.TypeVolume,
+				Source: volumeName,
+				Target: volumeHelperMountPoint,
+			}},
+		},
+		Platform: (*ocispec.Platform)(&c.Platform),
+	})
+	if err != nil {
+		slog.Error("encountered an error creating a volume helper container", "volume", volumeName, "error", err)
+		return err
+	}
+	defer func() {
+		if _, rmErr := c.mobyClient.ContainerRemove(context.WithoutCancel(ctx), createResp.ID, mobyclient.ContainerRemoveOptions{Force: true}); rmErr != nil {
+			slog.Error("encountered an error removing a volume helper container", "id", createResp.ID, "error", rmErr)
+			err = errors.Join(err, rmErr)
+		}
+	}()
+
+	return fn(createResp.ID)
+}
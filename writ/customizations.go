@@ -0,0 +1,97 @@
+/*
+   writ: a devcontainer.json parser
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package writ houses a validating parser for devcontainer.json files
+package writ
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// CustomizationsHandler acts on a tool's section of customizations,
+// e.g., customizations.vscode.
+type CustomizationsHandler interface {
+	// HandleCustomizations receives every contribution to the tool's
+	// section, in order of increasing precedence: those from Features
+	// first, then the one in devcontainer.json.
+	HandleCustomizations(p *DevcontainerParser, contributions []json.RawMessage) error
+}
+
+// CustomizationsHandlerFunc adapts a function into a
+// CustomizationsHandler.
+type CustomizationsHandlerFunc func(p *DevcontainerParser, contributions []json.RawMessage) error
+
+// HandleCustomizations calls f.
+func (f CustomizationsHandlerFunc) HandleCustomizations(p *DevcontainerParser, contributions []json.RawMessage) error {
+	return f(p, contributions)
+}
+
+// RegisterCustomizationsHandler has handler act on the customizations
+// under tool when ApplyCustomizations is called, replacing any
+// handler previously registered for it.
+func (p *DevcontainerParser) RegisterCustomizationsHandler(tool string, handler CustomizationsHandler) {
+	if p.customizationsHandlers == nil {
+		p.customizationsHandlers = make(map[string]CustomizationsHandler)
+	}
+	p.customizationsHandlers[tool] = handler
+}
+
+// Customizations returns every contribution to tool's section of
+// customizations, in order of increasing precedence; see
+// CustomizationsHandler.
+func (p *DevcontainerParser) Customizations(tool string) ([]json.RawMessage, error) {
+	var contributions []json.RawMessage
+	sources := append(slices.Clone(p.FeatureCustomizations), p.Config.Customizations)
+	for _, source := range sources {
+		section, ok := source[tool]
+		if !ok {
+			continue
+		}
+		contribution, err := json.Marshal(section)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read customizations.%s: %w", tool, err)
+		}
+		contributions = append(contributions, contribution)
+	}
+	return contributions, nil
+}
+
+// ApplyCustomizations runs the registered handlers against their
+// tools' customizations, in order of the tools' names. Tools without
+// customizations are skipped.
+//
+// Handlers are all run even if some of them fail; their errors are
+// returned together.
+func (p *DevcontainerParser) ApplyCustomizations() error {
+	var errs []error
+	for _, tool := range slices.Sorted(maps.Keys(p.customizationsHandlers)) {
+		contributions, err := p.Customizations(tool)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(contributions) == 0 {
+			continue
+		}
+		if err = p.customizationsHandlers[tool].HandleCustomizations(p, contributions); err != nil {
+			errs = append(errs, fmt.Errorf("unable to apply customizations.%s: %w", tool, err))
+		}
+	}
+	return errors.Join(errs...)
+}
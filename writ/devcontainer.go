@@ -55,7 +55,7 @@ type DevcontainerConfig struct {
 	WorkspaceFolder *string `json:"workspaceFolder,omitempty"`
 	// The --mount parameter for docker run. The default is to mount the project folder at
 	// /workspaces/$project.
-	WorkspaceMount *string `json:"workspaceMount,omitempty"`
+	WorkspaceMount *MobyMount `json:"workspaceMount,omitempty"`
 	// The name of the docker-compose file(s) used to start the services.
 	DockerComposeFile *DockerComposeFile `json:"dockerComposeFile,omitempty"`
 	// An array of services that should be started and stopped.
@@ -370,5 +370,21 @@ type LifecycleCommand struct {
 }
 
 // MobyMount is a thin wrapper around the Moby Mount struct to allow
-// writing an unmarshaller.
-type MobyMount mount.Mount
+// writing an unmarshaller, and to carry the options Moby's mount API
+// has no room for.
+type MobyMount struct {
+	mount.Mount
+	Relabel Relabel `json:"relabel,omitempty"` // How a bind mount is relabeled for SELinux, if at all
+}
+
+// Relabel is how a bind mount's source is relabeled for SELinux, so
+// that the container is allowed to access it.
+type Relabel string
+
+// Supported values for Relabel; they match the flags used in mount
+// strings
+const (
+	RelabelNone    Relabel = ""  // Leave the label alone
+	RelabelShared  Relabel = "z" // Label the source so that all containers can share it
+	RelabelPrivate Relabel = "Z" // Label the source so that only this container can access it
+)
@@ -0,0 +1,98 @@
+/*
+   writ: a devcontainer.json parser
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package writ houses a validating parser for devcontainer.json files
+package writ
+
+import (
+	"fmt"
+)
+
+// DevcontainerEditor makes changes to a devcontainer.json, keeping its
+// comments and formatting; see Editor.
+//
+// The file isn't validated; parse it again once it's been saved to
+// make sure the changes made sense.
+type DevcontainerEditor struct {
+	Editor
+}
+
+// NewDevcontainerEditor returns a DevcontainerEditor for the
+// devcontainer.json at configPath.
+func NewDevcontainerEditor(configPath string) (*DevcontainerEditor, error) {
+	editor, err := NewEditor(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return &DevcontainerEditor{Editor: *editor}, nil
+}
+
+// SetImage sets the image the devcontainer is based on.
+func (e *DevcontainerEditor) SetImage(image string) error {
+	return e.Set("/image", image)
+}
+
+// Features returns the options of the Features the devcontainer
+// references, keyed by how they're referenced.
+func (e *DevcontainerEditor) Features() (map[string]any, error) {
+	features := make(map[string]any)
+	if _, err := e.Get("/features", &features); err != nil {
+		return nil, err
+	}
+	return features, nil
+}
+
+// AddFeature adds a reference to the Feature featureRef, with its
+// options set to options, replacing its options if it's already
+// referenced.
+func (e *DevcontainerEditor) AddFeature(featureRef string, options map[string]any) error {
+	if options == nil {
+		options = make(map[string]any)
+	}
+	return e.Set(featurePointer(featureRef), options)
+}
+
+// RemoveFeature removes the reference to the Feature featureRef, as
+// it's written in the devcontainer.json.
+//
+// Returns false if there's no such reference.
+func (e *DevcontainerEditor) RemoveFeature(featureRef string) (bool, error) {
+	var options any
+	if found, err := e.Get(featurePointer(featureRef), &options); !found || err != nil {
+		return false, err
+	}
+	return true, e.Remove(featurePointer(featureRef))
+}
+
+// AddForwardPort adds port (e.g., 3000, or "db:5432") to the ports
+// forwarded from the devcontainer, unless it's already among them.
+func (e *DevcontainerEditor) AddForwardPort(port any) error {
+	var forwardPorts []any
+	if _, err := e.Get("/forwardPorts", &forwardPorts); err != nil {
+		return err
+	}
+	for _, forwardPort := range forwardPorts {
+		if fmt.Sprint(forwardPort) == fmt.Sprint(port) {
+			return nil
+		}
+	}
+	return e.Append("/forwardPorts", port)
+}
+
+// featurePointer returns the JSON Pointer to the options of the
+// Feature featureRef in a devcontainer.json.
+func featurePointer(featureRef string) string {
+	return jsonPointer([]string{"features", featureRef})
+}
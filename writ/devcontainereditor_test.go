@@ -0,0 +1,71 @@
+package writ
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDevcontainerEditor checks that Features and forwarded ports can
+// be added and removed, and the image changed, in a devcontainer.json
+// indented with tabs.
+func TestDevcontainerEditor(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	configPath := filepath.Join(t.TempDir(), "devcontainer.json")
+	assert.Nil(t, os.WriteFile(configPath, []byte(`{
+	"name": "editor",
+	"image": "mcr.microsoft.com/devcontainers/base:bookworm",
+	"features": {
+		// For committing
+		"ghcr.io/devcontainers/features/git:1": {},
+		"ghcr.io/devcontainers/features/node:1": {"version": "lts"}
+	},
+	"forwardPorts": [3000]
+}
+`), 0o644))
+
+	e, err := NewDevcontainerEditor(configPath)
+	assert.Nil(t, err)
+	assert.Nil(t, e.SetImage("mcr.microsoft.com/devcontainers/base:trixie"))
+	assert.Nil(t, e.AddFeature("ghcr.io/devcontainers/features/go:1", map[string]any{"version": "1.24"}))
+	assert.Nil(t, e.AddFeature("./local-feature", nil))
+	removed, err := e.RemoveFeature("ghcr.io/devcontainers/features/git:1")
+	assert.True(t, removed)
+	assert.Nil(t, err)
+	removed, err = e.RemoveFeature("ghcr.io/devcontainers/features/git:1")
+	assert.False(t, removed)
+	assert.Nil(t, err)
+	assert.Nil(t, e.AddForwardPort(3000))
+	assert.Nil(t, e.AddForwardPort("db:5432"))
+
+	features, err := e.Features()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{
+		"ghcr.io/devcontainers/features/node:1": map[string]any{"version": "lts"},
+		"ghcr.io/devcontainers/features/go:1":   map[string]any{"version": "1.24"},
+		"./local-feature":                       map[string]any{},
+	}, features)
+
+	assert.Nil(t, e.Save())
+	contents, err := os.ReadFile(configPath)
+	assert.Nil(t, err)
+	assert.Equal(t, `{
+	"name": "editor",
+	"image": "mcr.microsoft.com/devcontainers/base:trixie",
+	"features": {
+		"ghcr.io/devcontainers/features/node:1": {"version": "lts"},
+		"ghcr.io/devcontainers/features/go:1": {
+			"version": "1.24"
+		},
+		"./local-feature": {}
+	},
+	"forwardPorts": [3000, "db:5432"]
+}
+`, string(contents))
+}
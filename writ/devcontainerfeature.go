@@ -16,6 +16,12 @@
 // Package writ houses a validating parser for devcontainer.json files
 package writ
 
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
 // Initially generated using https://app.quicktype.io/ against
 // https://raw.githubusercontent.com/devcontainers/spec/1b2baddb5f1071ca0e8bcb7eb56dbc9d3e4a674f/schemas/devContainerFeature.schema.json
 
@@ -110,7 +116,8 @@ type FeatureOption struct {
 	// Default value if the user omits this option from their configuration.
 	Default *FeatureValue `json:"default"`
 	// Value as set by the parent devcontainer configuration, if any;
-	// references Default unless overridden via SetOption
+	// a copy of Default unless overridden via SetOption, and nil if
+	// there's neither
 	Value *FeatureValue
 	// A description of the option displayed to the user by a supporting tool.
 	Description *string `json:"description,omitempty"`
@@ -133,3 +140,39 @@ const (
 	FeatureOptionTypeBoolean FeatureOptionType = "boolean"
 	FeatureOptionTypeString  FeatureOptionType = "string"
 )
+
+// EnvValue returns the value of the option as it's passed to the
+// Feature's install script; empty if it's unset.
+func (o *FeatureOption) EnvValue() string {
+	switch {
+	case o.Value == nil:
+		return ""
+	case o.Value.Bool != nil:
+		return strconv.FormatBool(*o.Value.Bool)
+	case o.Value.String != nil:
+		return *o.Value.String
+	}
+	return ""
+}
+
+// convertValue returns a copy of value converted to the option's
+// type: booleans to "true" or "false" for string options, and those
+// strings to booleans for boolean options.
+func (o *FeatureOption) convertValue(value *FeatureValue) (*FeatureValue, error) {
+	if value == nil || (value.Bool == nil && value.String == nil) {
+		return nil, errors.New("no value given")
+	}
+	switch {
+	case o.Type == FeatureOptionTypeBoolean && value.Bool == nil:
+		if *value.String != "true" && *value.String != "false" {
+			return nil, fmt.Errorf("expected a boolean, got %q", *value.String)
+		}
+		parsed := *value.String == "true"
+		return &FeatureValue{Bool: &parsed}, nil
+	case o.Type == FeatureOptionTypeString && value.String == nil:
+		formatted := strconv.FormatBool(*value.Bool)
+		return &FeatureValue{String: &formatted}, nil
+	}
+	converted := *value
+	return &converted, nil
+}
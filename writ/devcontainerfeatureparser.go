@@ -22,6 +22,9 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
+	"strings"
 )
 
 // devcontainerJSONSchema is the contents of the JSON schema against
@@ -73,18 +76,71 @@ func (p *DevcontainerFeatureParser) Parse() error {
 	return nil
 }
 
+// SetOption sets the value of the option name. As devcontainer.json
+// files commonly set boolean options to "true" or "false", values are
+// converted to the option's type where that's unambiguous.
 func (p *DevcontainerFeatureParser) SetOption(name string, value *FeatureValue) error {
-	if option, ok := p.Config.Options[name]; !ok {
+	option, ok := p.Config.Options[name]
+	if !ok {
 		return fmt.Errorf("attempted to set the value of unknown option: %s", name)
-	} else {
-		option.Value = value
-		return nil
+	}
+	converted, err := option.convertValue(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for option %s: %w", name, err)
+	}
+	option.Value = converted
+	return nil
+}
+
+// CheckRequiredOptions returns an error naming every option of the
+// Feature that's without a value: options without a default are
+// required to be set in devcontainer.json.
+func (p *DevcontainerFeatureParser) CheckRequiredOptions() error {
+	var missing []string
+	for _, optName := range slices.Sorted(maps.Keys(p.Config.Options)) {
+		if p.Config.Options[optName].Value == nil {
+			missing = append(missing, optName)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("feature %s requires options that aren't set: %s", p.Config.ID, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// MergeContainerProperties folds the properties the Feature sets on
+// the container it's installed in (e.g., init and capAdd) into its
+// parent's configuration. Its customizations are passed on to the
+// parent as well; see FeatureCustomizations.
+//
+// The parent has to have been parsed beforehand.
+func (p *DevcontainerFeatureParser) MergeContainerProperties() {
+	if p.Config.Init != nil && *p.Config.Init {
+		slog.Debug("feature requires an init process", "feature", p.Config.ID)
+		p.Parent.Config.Init = p.Config.Init
+	}
+	if p.Config.Privileged != nil && *p.Config.Privileged {
+		slog.Debug("feature requires privileged mode", "feature", p.Config.ID)
+		p.Parent.Config.Privileged = p.Config.Privileged
+	}
+	p.Parent.Config.CapAdd = mergeCapabilities(p.Parent.Config.CapAdd, p.Config.CapAdd)
+	p.Parent.Config.SecurityOpt = mergeUnique(p.Parent.Config.SecurityOpt, p.Config.SecurityOpt)
+	if len(p.Config.Customizations) > 0 {
+		p.Parent.FeatureCustomizations = append(p.Parent.FeatureCustomizations, p.Config.Customizations)
 	}
 }
 
+// setDefaultValues sets the value of each option to its default, if
+// it has one; options without one are left unset. See
+// CheckRequiredOptions.
 func (p *DevcontainerFeatureParser) setDefaultValues() error {
 	for optName, option := range p.Config.Options {
-		p.SetOption(optName, option.Default)
+		if option.Default == nil || (option.Default.Bool == nil && option.Default.String == nil) {
+			continue
+		}
+		if err := p.SetOption(optName, option.Default); err != nil {
+			return fmt.Errorf("invalid default: %w", err)
+		}
 	}
 	return nil
 }
@@ -1,6 +1,8 @@
 package writ
 
 import (
+	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"path/filepath"
@@ -58,3 +60,128 @@ func TestParseDevcontainerFeature(t *testing.T) {
 
 	// We don't particularly care about the customizations field
 }
+
+// TestMergeFeatureContainerProperties checks that the container
+// properties set by a Feature are carried over to its parent.
+func TestMergeFeatureContainerProperties(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	parent, err := NewDevcontainerParser(filepath.Join("testdata", "parse", "devcontainer-feature", "_parent.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, parent.Validate())
+	assert.Nil(t, parent.Parse())
+	assert.False(t, *parent.Config.Init)
+
+	p, err := NewDevcontainerFeatureParser(filepath.Join("testdata", "parse", "devcontainer-feature", "container-properties.json"), parent)
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+
+	p.MergeContainerProperties()
+	assert.True(t, *parent.Config.Init)
+	assert.True(t, *parent.Config.Privileged)
+	assert.Equal(t, []string{"SYS_PTRACE"}, parent.Config.CapAdd)
+	assert.Equal(t, []string{"seccomp=unconfined"}, parent.Config.SecurityOpt)
+
+	// Merging the same Feature twice doesn't duplicate anything
+	p.MergeContainerProperties()
+	assert.Equal(t, []string{"SYS_PTRACE"}, parent.Config.CapAdd)
+}
+
+// TestApplyCustomizations checks that customizations handlers receive
+// their tool's customizations from both Features and devcontainer.json,
+// in order of increasing precedence.
+func TestApplyCustomizations(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	parent, err := NewDevcontainerParser(filepath.Join("testdata", "parse", "devcontainer", "customizations.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, parent.Validate())
+	assert.Nil(t, parent.Parse())
+
+	p, err := NewDevcontainerFeatureParser(filepath.Join("testdata", "parse", "devcontainer-feature", "customizations.json"), parent)
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+	p.MergeContainerProperties()
+
+	received := make(map[string][]string)
+	record := func(tool string) CustomizationsHandler {
+		return CustomizationsHandlerFunc(func(_ *DevcontainerParser, contributions []json.RawMessage) error {
+			for _, contribution := range contributions {
+				received[tool] = append(received[tool], string(contribution))
+			}
+			return nil
+		})
+	}
+	parent.RegisterCustomizationsHandler("brig", record("brig"))
+	parent.RegisterCustomizationsHandler("vscode", record("vscode"))
+	parent.RegisterCustomizationsHandler("jetbrains", record("jetbrains"))
+	assert.Nil(t, parent.ApplyCustomizations())
+
+	assert.Equal(t, []string{
+		`{"forwardAddress":"0.0.0.0","shell":"/bin/bash"}`,
+		`{"shell":"/bin/zsh"}`,
+	}, received["brig"])
+	assert.Equal(t, []string{`{"extensions":["golang.go"]}`}, received["vscode"])
+	assert.NotContains(t, received, "jetbrains")
+
+	// Handlers that fail don't keep the others from running
+	errFailed := errors.New("failed")
+	delete(received, "vscode")
+	parent.RegisterCustomizationsHandler("brig", CustomizationsHandlerFunc(func(*DevcontainerParser, []json.RawMessage) error {
+		return errFailed
+	}))
+	assert.ErrorIs(t, parent.ApplyCustomizations(), errFailed)
+	assert.Len(t, received["vscode"], 1)
+}
+
+// TestFeatureOptionValues checks that options take on their defaults,
+// that values are converted to the options' types, and that options
+// without a value are reported.
+func TestFeatureOptionValues(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p, err := NewDevcontainerFeatureParser(filepath.Join("testdata", "parse", "devcontainer-feature", "simple-devcontainer-feature.json"), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+	assert.Nil(t, p.CheckRequiredOptions())
+	assert.Equal(t, "true", p.Config.Options["ppa"].EnvValue())
+	assert.Equal(t, "os-provided", p.Config.Options["version"].EnvValue())
+
+	falseString := "false"
+	assert.Nil(t, p.SetOption("ppa", &FeatureValue{String: &falseString}))
+	assert.False(t, *p.Config.Options["ppa"].Value.Bool)
+	assert.True(t, *p.Config.Options["ppa"].Default.Bool)
+
+	maybe := "maybe"
+	assert.NotNil(t, p.SetOption("ppa", &FeatureValue{String: &maybe}))
+	assert.NotNil(t, p.SetOption("ppa", &FeatureValue{}))
+	assert.NotNil(t, p.SetOption("unknown", &FeatureValue{String: &maybe}))
+
+	trueBool := true
+	assert.Nil(t, p.SetOption("version", &FeatureValue{Bool: &trueBool}))
+	assert.Equal(t, "true", p.Config.Options["version"].EnvValue())
+
+	// The schema requires defaults, but not every Feature out there
+	// sticks to it
+	p = &DevcontainerFeatureParser{Config: DevcontainerFeatureConfig{
+		ID: "required",
+		Options: FeatureOptions{
+			"token":  {Type: FeatureOptionTypeString},
+			"secure": {Type: FeatureOptionTypeBoolean},
+		},
+	}}
+	assert.Nil(t, p.setDefaultValues())
+	assert.Equal(t, "", p.Config.Options["token"].EnvValue())
+	err = p.CheckRequiredOptions()
+	assert.ErrorContains(t, err, "secure, token")
+	token := "abc"
+	assert.Nil(t, p.SetOption("token", &FeatureValue{String: &token}))
+	assert.Nil(t, p.SetOption("secure", &FeatureValue{Bool: &trueBool}))
+	assert.Nil(t, p.CheckRequiredOptions())
+}
@@ -0,0 +1,94 @@
+/*
+   writ: a devcontainer.json parser
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package writ houses a validating parser for devcontainer.json files
+package writ
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"strings"
+)
+
+// Labels the devcontainer spec uses to tie a container to the
+// workspace and the devcontainer.json it was created for; together,
+// they're what ${devcontainerId} is derived from.
+const (
+	LocalFolderLabel = "devcontainer.local_folder"
+	ConfigFileLabel  = "devcontainer.config_file"
+)
+
+// devcontainerIDLength is how long a devcontainer ID is; IDs are
+// zero-padded to this length.
+const devcontainerIDLength = 52
+
+// IDLabels returns the labels that identify the devcontainer
+// described by the devcontainer.json p is for.
+func (p *DevcontainerParser) IDLabels() (map[string]string, error) {
+	configFile, err := filepath.Abs(p.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	// The workspace, not the context for builds; the two part ways
+	// when context is set
+	return map[string]string{
+		LocalFolderLabel: p.WorkspaceFolder(),
+		ConfigFileLabel:  configFile,
+	}, nil
+}
+
+// ComputeDevcontainerID derives a devcontainer's ID from the labels
+// identifying it (see IDLabels), the same way the reference
+// implementation does: the SHA-256 hash of the labels as a JSON
+// object with sorted keys, as a base-32 number.
+//
+// The ID stays the same across runs (and rebuilds) for as long as
+// the workspace and devcontainer.json don't move, so it's usable for
+// naming things that should outlive the container, e.g., volumes.
+func ComputeDevcontainerID(labels map[string]string) (string, error) {
+	var labelsJSON bytes.Buffer
+	encoder := json.NewEncoder(&labelsJSON)
+	// Match JSON.stringify(), which doesn't escape <, >, and &
+	encoder.SetEscapeHTML(false)
+	// Map keys are sorted by encoding/json
+	if err := encoder.Encode(labels); err != nil {
+		return "", fmt.Errorf("unable to compute devcontainer ID: %w", err)
+	}
+	hash := sha256.Sum256(bytes.TrimSuffix(labelsJSON.Bytes(), []byte("\n")))
+	id := new(big.Int).SetBytes(hash[:]).Text(32)
+	return strings.Repeat("0", max(0, devcontainerIDLength-len(id))) + id, nil
+}
+
+// computeDevcontainerID sets p.DevcontainerID, if it hasn't been set
+// yet.
+func (p *DevcontainerParser) computeDevcontainerID() error {
+	if p.DevcontainerID != nil {
+		return nil
+	}
+	labels, err := p.IDLabels()
+	if err != nil {
+		return err
+	}
+	id, err := ComputeDevcontainerID(labels)
+	if err != nil {
+		return err
+	}
+	p.DevcontainerID = &id
+	return nil
+}
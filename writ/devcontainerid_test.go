@@ -0,0 +1,94 @@
+package writ
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestComputeDevcontainerID checks that IDs match the ones the
+// reference implementation derives from the same labels.
+func TestComputeDevcontainerID(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	id, err := ComputeDevcontainerID(map[string]string{
+		LocalFolderLabel: "/home/user/project",
+		ConfigFileLabel:  "/home/user/project/.devcontainer/devcontainer.json",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "0ns9efvs2cg80a2avksvk7nqv06jrab7n2918j79h49700ucligl", id)
+}
+
+// TestParseDevcontainerID checks that parsing a devcontainer.json
+// sets a devcontainer ID that stays the same across runs.
+func TestParseDevcontainerID(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	parse := func(path string) *DevcontainerParser {
+		p, err := NewDevcontainerParser(path)
+		assert.Nil(t, err)
+		assert.Nil(t, p.Validate())
+		assert.Nil(t, p.Parse())
+		return p
+	}
+
+	configPath := filepath.Join("testdata", "parse", "devcontainer", "simple-devcontainer.json")
+	p := parse(configPath)
+	if !assert.NotNil(t, p.DevcontainerID) {
+		return
+	}
+	assert.Len(t, *p.DevcontainerID, devcontainerIDLength)
+
+	labels, err := p.IDLabels()
+	assert.Nil(t, err)
+	absConfigPath, _ := filepath.Abs(configPath)
+	assert.Equal(t, absConfigPath, labels[ConfigFileLabel])
+	assert.Equal(t, *p.Config.Context, labels[LocalFolderLabel])
+
+	assert.Equal(t, *p.DevcontainerID, *parse(configPath).DevcontainerID)
+	assert.NotEqual(t, *p.DevcontainerID, *parse(filepath.Join("testdata", "parse", "devcontainer", "lifecycle.json")).DevcontainerID)
+}
+
+// TestIDLabelsBuildContext checks that the labels identifying a
+// devcontainer, and ${localWorkspaceFolder}, refer to the workspace
+// rather than to the context for builds.
+func TestIDLabelsBuildContext(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	workspace := t.TempDir()
+	p, err := NewDevcontainerParser(filepath.Join("testdata", "parse", "devcontainer", "build-context.json"))
+	assert.Nil(t, err)
+	p.LocalWorkspaceFolder = workspace
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+	p.ProcessSubstitutions()
+
+	assert.Equal(t, filepath.Join(filepath.Dir(p.Filepath), ".."), *p.Config.Context)
+	labels, err := p.IDLabels()
+	assert.Nil(t, err)
+	assert.Equal(t, workspace, labels[LocalFolderLabel])
+	assert.Equal(t, workspace, p.Config.ContainerEnv["LOCAL_WORKSPACE_FOLDER"])
+	assert.Equal(t, filepath.Base(workspace), p.ExpandEnv("${localWorkspaceFolderBasename}"))
+}
+
+// TestWorkspaceFolder checks that the workspace falls back to the
+// working directory, and is always absolute.
+func TestWorkspaceFolder(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	p := &DevcontainerParser{}
+	assert.Equal(t, cwd, p.WorkspaceFolder())
+
+	p.LocalWorkspaceFolder = "testdata"
+	assert.Equal(t, filepath.Join(cwd, "testdata"), p.WorkspaceFolder())
+}
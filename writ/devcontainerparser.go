@@ -20,6 +20,7 @@ import (
 	_ "embed"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -27,6 +28,7 @@ import (
 	"strings"
 
 	"dario.cat/mergo"
+	"github.com/moby/moby/api/types/mount"
 	"mvdan.cc/sh/v3/shell"
 )
 
@@ -61,12 +63,19 @@ const DefWorkspacePath string = "/workspace"
 // intended devcontainer itself.
 type DevcontainerParser struct {
 	Config         DevcontainerConfig // The parsed contents of the target devcontainer.json
-	DevcontainerID *string            // The runtime-specific ID for the devcontainer; not available until after it's created
+	DevcontainerID *string            // The devcontainer's stable ID, derived from where the workspace and devcontainer.json are; see ComputeDevcontainerID
+
+	LocalWorkspaceFolder string // The workspace on the host (i.e., ${localWorkspaceFolder}); if empty, the working directory is used. Has to be set before Parse; see WorkspaceFolder
 
 	EnvProbeNeeded   bool              // Helper flag to keep track of whether or not a probe has been performed to populate the envVars* fields
 	EnvVarsContainer map[string]string // A map of environment variables available to the container's intended interactive user; used when interpolating containerEnv:* values
 	EnvVarsRemote    map[string]string // A map of environment variables available to tooling meant to interact with the devcontainer; used when interpolating remoteEnv:* values
 
+	FeatureCustomizations []map[string]any // The customizations of the devcontainer's Features, in the order they were merged in; see MergeContainerProperties
+
+	customizationsHandlers map[string]CustomizationsHandler // Keyed by tool name; see RegisterCustomizationsHandler
+	workspaceMountJSON     json.RawMessage                  // workspaceMount as written, held back until its variables can be expanded; see parseWorkspaceMount
+
 	Parser
 }
 
@@ -94,8 +103,11 @@ func NewDevcontainerParser(configPath string) (p *DevcontainerParser, err error)
 // Will refuse to parse unless the contents are determined to conform
 // to the official JSON Schema spec.
 //
-// Substitutions aren't performed on env vars ($FOO) at this stage, as
-// it's not necessary for a config file to be considered valid.
+// Variables defined by the spec that only refer to the host (e.g.,
+// ${localEnv:FOO} and ${localWorkspaceFolder}) are substituted at this
+// stage, but not ones referring to the container (e.g.,
+// ${containerEnv:PATH}), nor regular env vars in containerEnv and
+// mounts ($FOO); see ProcessSubstitutions and ResolveContainerEnv.
 //
 // TODO: Add support for other parts of the spec. (Ongoing)
 func (p *DevcontainerParser) Parse() error {
@@ -109,20 +121,28 @@ func (p *DevcontainerParser) Parse() error {
 	}
 
 	slog.Debug("attempting to unmarshal and parse devcontainer.json")
-	if err := json.Unmarshal(p.standardizedJSON, &p.Config); err != nil {
+	// workspaceMount is shadowed so it's kept as written; see
+	// parseWorkspaceMount
+	type devcontainerConfig DevcontainerConfig
+	config := struct {
+		*devcontainerConfig
+		WorkspaceMount json.RawMessage `json:"workspaceMount,omitempty"`
+	}{devcontainerConfig: (*devcontainerConfig)(&p.Config)}
+	if err := json.Unmarshal(p.standardizedJSON, &config); err != nil {
 		slog.Error("failed to unmarshal JSON", "path", p.Filepath, "error", err)
 		return err
 	}
-
-	if p.Config.RunArgs != nil {
-		slog.Warn("devcontainer.json uses runArgs, which is currently unsupported", "runArgs", p.Config.RunArgs)
-	}
+	p.workspaceMountJSON = config.WorkspaceMount
 
 	if err := p.normalizeValues(); err != nil {
 		slog.Error("encountered an error while attempting to normalize values", "error", err)
 		return err
 	}
 
+	if _, ignored := parseSecurityRunArgs(p.Config.RunArgs); len(ignored) > 0 {
+		slog.Warn("devcontainer.json uses runArgs other than --cap-add, --privileged, and --security-opt, which are currently unsupported", "runArgs", ignored)
+	}
+
 	slog.Debug("configuration parsed", "config", p.Config)
 	slog.Info("workspace folder", "path", *p.Config.WorkspaceFolder)
 
@@ -197,18 +217,18 @@ func (p *DevcontainerParser) ExpandEnv(v string) string {
 func (p *DevcontainerParser) expandEnv(v string) string {
 	switch {
 	case v == "containerWorkspaceFolder":
-		return DefWorkspacePath
+		return p.containerWorkspaceFolder()
 	case v == "containerWorkspaceFolderBasename":
-		return filepath.Base(DefWorkspacePath)
+		return filepath.Base(p.containerWorkspaceFolder())
 	case v == "devcontainerId":
 		if p.DevcontainerID != nil {
 			return *p.DevcontainerID
 		}
 		return ""
 	case v == "localWorkspaceFolder":
-		return *p.Config.Context
+		return p.WorkspaceFolder()
 	case v == "localWorkspaceFolderBasename":
-		return filepath.Base(*p.Config.Context)
+		return filepath.Base(p.WorkspaceFolder())
 	case strings.HasPrefix(v, "containerEnv__"):
 		envKey := strings.SplitN(v, "__", 2)
 		if val, ok := p.EnvVarsContainer[envKey[1]]; ok {
@@ -226,6 +246,58 @@ func (p *DevcontainerParser) expandEnv(v string) string {
 	}
 }
 
+// WorkspaceFolder returns the absolute path to the workspace on the
+// host, i.e., what ${localWorkspaceFolder} expands to:
+// LocalWorkspaceFolder, or the working directory if that's unset.
+//
+// It's the workspace that's mounted into the devcontainer, and it's
+// not necessarily the context for builds (see context).
+func (p *DevcontainerParser) WorkspaceFolder() string {
+	workspace := p.LocalWorkspaceFolder
+	if len(workspace) == 0 {
+		workspace, _ = os.Getwd()
+	}
+	if absWorkspace, err := filepath.Abs(workspace); err == nil {
+		return absWorkspace
+	}
+	return workspace
+}
+
+// containerWorkspaceFolder returns the path of the workspace folder
+// inside the container, falling back to DefWorkspacePath if it hasn't
+// been set yet.
+func (p *DevcontainerParser) containerWorkspaceFolder() string {
+	if p.Config.WorkspaceFolder != nil && len(*p.Config.WorkspaceFolder) > 0 {
+		return *p.Config.WorkspaceFolder
+	}
+	return DefWorkspacePath
+}
+
+// validateMount checks that the options set on m make sense for its
+// type, so the mistake is reported here instead of as an opaque error
+// from the engine when the container gets created.
+func validateMount(m *MobyMount) error {
+	if len(m.Target) == 0 {
+		return errors.New("mount has no target")
+	}
+
+	switch m.Type {
+	case mount.TypeTmpfs:
+		if len(m.Source) > 0 {
+			return fmt.Errorf("tmpfs mount at %s cannot have a source", m.Target)
+		}
+		if m.TmpfsOptions != nil && m.TmpfsOptions.SizeBytes < 0 {
+			return fmt.Errorf("tmpfs mount at %s has a negative size", m.Target)
+		}
+	case mount.TypeBind, mount.TypeVolume:
+		if m.TmpfsOptions != nil {
+			return fmt.Errorf("%s mount at %s cannot have tmpfs options", m.Type, m.Target)
+		}
+	}
+
+	return nil
+}
+
 // normalizeValues goes through a devcontainer.json's values and
 // massages them as needed.
 //
@@ -242,6 +314,32 @@ func (p *DevcontainerParser) normalizeValues() error {
 		*p.Config.Context = contextPath
 	}
 
+	if err := p.computeDevcontainerID(); err != nil {
+		slog.Error("unable to compute the devcontainer ID", "error", err)
+		return err
+	}
+
+	for _, mountEntry := range p.Config.Mounts {
+		if err := validateMount(mountEntry); err != nil {
+			slog.Error("invalid mount", "target", mountEntry.Target, "error", err)
+			return err
+		}
+	}
+
+	// Only the local variables are meaningful at this point, but
+	// that's all the spec allows in these fields anyway
+	if p.Config.WorkspaceFolder != nil {
+		*p.Config.WorkspaceFolder = p.ExpandEnv(*p.Config.WorkspaceFolder)
+	}
+	if err := p.parseWorkspaceMount(); err != nil {
+		slog.Error("invalid workspaceMount", "error", err)
+		return err
+	}
+	// Everything else only gets the variables from the spec
+	// substituted; references to the container's environment are
+	// left for ProcessSubstitutions
+	p.substituteVariables()
+
 	if p.Config.DockerFile != nil {
 		// Convert to a path usable for building images
 		buildablePath, err := filepath.Rel(*p.Config.Context, filepath.Join(filepath.Dir(p.Filepath), *p.Config.DockerFile))
@@ -299,6 +397,44 @@ func (p *DevcontainerParser) normalizeValues() error {
 	return nil
 }
 
+// parseWorkspaceMount parses workspaceMount, expanding the variables
+// in it first.
+//
+// In the string form, the variables are expanded before the string
+// is parsed, as they're liable to throw the parsing off otherwise
+// (e.g., the colon in ${localEnv:HOME} in the --volume format); in
+// the object form, source and target are expanded once it's been
+// parsed.
+func (p *DevcontainerParser) parseWorkspaceMount() error {
+	p.Config.WorkspaceMount = nil
+	if len(p.workspaceMountJSON) == 0 || string(p.workspaceMountJSON) == "null" {
+		return nil
+	}
+
+	slog.Debug("expanding variables", "section", "workspaceMount")
+	if p.workspaceMountJSON[0] == '{' {
+		var workspaceMount MobyMount
+		if err := json.Unmarshal(p.workspaceMountJSON, &workspaceMount); err != nil {
+			return err
+		}
+		workspaceMount.Source = p.ExpandEnv(workspaceMount.Source)
+		workspaceMount.Target = p.ExpandEnv(workspaceMount.Target)
+		p.Config.WorkspaceMount = &workspaceMount
+		return nil
+	}
+
+	var mountString string
+	if err := json.Unmarshal(p.workspaceMountJSON, &mountString); err != nil {
+		return err
+	}
+	workspaceMount, err := ParseMountString(p.ExpandEnv(mountString))
+	if err != nil {
+		return err
+	}
+	p.Config.WorkspaceMount = workspaceMount
+	return nil
+}
+
 // setDefaultValues assigns default values to certain fields.
 //
 // This function only deals with values that can be computed without
@@ -318,13 +454,14 @@ func (p *DevcontainerParser) setDefaultValues() error {
 	defUserEnvProbe := UserEnvProbeLoginInteractiveShell
 	defWorkspacePath := DefWorkspacePath
 
-	// Use the current working directory as context for builds if
-	// none is given
-	cwd, err := os.Getwd()
-	if err != nil {
-		return err
+	// The workspace is also the context for builds, unless context
+	// says otherwise
+	workspace := p.WorkspaceFolder()
+	if len(workspace) == 0 {
+		return errors.New("unable to determine the workspace folder")
 	}
-	p.Config.Context = &cwd
+	p.LocalWorkspaceFolder = workspace
+	p.Config.Context = &workspace
 
 	defPortAttributes := PortAttributes{
 		Label:            nil,
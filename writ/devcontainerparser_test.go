@@ -1,10 +1,12 @@
 package writ
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
 	"testing"
 
@@ -270,6 +272,156 @@ func TestParseDevcontainerMountStringList(t *testing.T) {
 	assert.Empty(t, p.Config.Mounts[5].Consistency)
 }
 
+// TestParseDevcontainerMountOptions parses a devcontainer.json that
+// declares mounts with type-specific options
+func TestParseDevcontainerMountOptions(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p, err := NewDevcontainerParser(filepath.Join("testdata", "parse", "devcontainer", "mounts-options.json"))
+	assert.Nil(t, err)
+	if err := p.Validate(); err != nil {
+		t.Fatal("devcontainer.json expected to be valid failed validation:", err)
+	}
+	if err := p.Parse(); err != nil {
+		t.Fatal("devcontainer.json expected to be valid failed parsing")
+	}
+
+	assert.Len(t, p.Config.Mounts, 3)
+
+	assert.EqualValues(t, "bind", p.Config.Mounts[0].Type)
+	assert.True(t, p.Config.Mounts[0].ReadOnly)
+	if assert.NotNil(t, p.Config.Mounts[0].BindOptions) {
+		assert.EqualValues(t, "rslave", p.Config.Mounts[0].BindOptions.Propagation)
+	}
+
+	assert.EqualValues(t, "volume", p.Config.Mounts[1].Type)
+	assert.False(t, p.Config.Mounts[1].ReadOnly)
+	if assert.NotNil(t, p.Config.Mounts[1].VolumeOptions) {
+		assert.True(t, p.Config.Mounts[1].VolumeOptions.NoCopy)
+		if assert.NotNil(t, p.Config.Mounts[1].VolumeOptions.DriverConfig) {
+			assert.Equal(t, "local", p.Config.Mounts[1].VolumeOptions.DriverConfig.Name)
+			assert.Equal(t, map[string]string{"type": "tmpfs", "device": "tmpfs"}, p.Config.Mounts[1].VolumeOptions.DriverConfig.Options)
+		}
+	}
+
+	assert.EqualValues(t, "tmpfs", p.Config.Mounts[2].Type)
+	assert.Empty(t, p.Config.Mounts[2].Source)
+	assert.EqualValues(t, "/scratch", p.Config.Mounts[2].Target)
+	if assert.NotNil(t, p.Config.Mounts[2].TmpfsOptions) {
+		assert.EqualValues(t, 64*1024*1024, p.Config.Mounts[2].TmpfsOptions.SizeBytes)
+		assert.Equal(t, os.FileMode(0o770), p.Config.Mounts[2].TmpfsOptions.Mode.Perm())
+	}
+
+	p, err = NewDevcontainerParser(filepath.Join("testdata", "parse", "devcontainer", "mounts-invalid-tmpfs.json"))
+	assert.Nil(t, err)
+	if err := p.Validate(); err != nil {
+		t.Fatal("devcontainer.json expected to be valid failed validation:", err)
+	}
+	assert.Error(t, p.Parse())
+}
+
+// TestParseDevcontainerWorkspaceMount parses a devcontainer.json
+// that declares workspaceFolder and workspaceMount using variables
+func TestParseDevcontainerWorkspaceMount(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p, err := NewDevcontainerParser(filepath.Join("testdata", "parse", "devcontainer", "workspace-mount.json"))
+	assert.Nil(t, err)
+	if err := p.Validate(); err != nil {
+		t.Fatal("devcontainer.json expected to be valid failed validation:", err)
+	}
+	if err := p.Parse(); err != nil {
+		t.Fatal("devcontainer.json expected to be valid failed parsing")
+	}
+
+	workspaceFolder := path.Join("/workspaces", filepath.Base(*p.Config.Context))
+	assert.Equal(t, workspaceFolder, *p.Config.WorkspaceFolder)
+	assert.NotNil(t, p.Config.WorkspaceMount)
+	assert.EqualValues(t, "bind", p.Config.WorkspaceMount.Type)
+	assert.Equal(t, *p.Config.Context, p.Config.WorkspaceMount.Source)
+	assert.Equal(t, workspaceFolder, p.Config.WorkspaceMount.Target)
+	assert.EqualValues(t, "cached", p.Config.WorkspaceMount.Consistency)
+}
+
+// TestParseDevcontainerWorkspaceMountVolumeFormat checks that the
+// variables in a workspaceMount in the --volume format are expanded
+// before it's parsed, so the colon in ${localEnv:...} isn't taken for
+// a separator.
+func TestParseDevcontainerWorkspaceMountVolumeFormat(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	source := t.TempDir()
+	t.Setenv("BRIG_TEST_WORKSPACE_SOURCE", source)
+
+	p, err := NewDevcontainerParser(filepath.Join("testdata", "parse", "devcontainer", "workspace-mount-volume.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+
+	assert.NotNil(t, p.Config.WorkspaceMount)
+	assert.EqualValues(t, "bind", p.Config.WorkspaceMount.Type)
+	assert.Equal(t, source, p.Config.WorkspaceMount.Source)
+	assert.Equal(t, *p.Config.WorkspaceFolder, p.Config.WorkspaceMount.Target)
+}
+
+// TestParseDevcontainerBuildArgs checks that the spec's variables are
+// substituted in build.args.
+func TestParseDevcontainerBuildArgs(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Setenv("BRIG_TEST_GO_VERSION", "1.25")
+
+	p, err := NewDevcontainerParser(filepath.Join("testdata", "parse", "devcontainer", "build-args.json"))
+	assert.Nil(t, err)
+	if err := p.Validate(); err != nil {
+		t.Fatal("devcontainer.json expected to be valid failed validation:", err)
+	}
+	if err := p.Parse(); err != nil {
+		t.Fatal("devcontainer.json expected to be valid failed parsing")
+	}
+
+	if assert.NotNil(t, p.Config.Build) {
+		assert.Equal(t, map[string]string{
+			"GO_VERSION": "1.25",
+			"WORKSPACE":  filepath.Base(*p.Config.Context),
+			"PLAIN":      "as-is",
+		}, p.Config.Build.Args)
+		assert.Equal(t, "dev", *p.Config.Build.Target)
+	}
+}
+
+// TestParseDevcontainerSecurityOptions parses a devcontainer.json
+// that declares security options both directly and in runArgs, and
+// checks that they're combined without duplicates
+func TestParseDevcontainerSecurityOptions(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p, err := NewDevcontainerParser(filepath.Join("testdata", "parse", "devcontainer", "security-options.json"))
+	assert.Nil(t, err)
+	if err := p.Validate(); err != nil {
+		t.Fatal("devcontainer.json expected to be valid failed validation:", err)
+	}
+	if err := p.Parse(); err != nil {
+		t.Fatal("devcontainer.json expected to be valid failed parsing")
+	}
+
+	assert.False(t, *p.Config.Privileged)
+	opts := p.SecurityOptions()
+	assert.Equal(t, []string{"SYS_PTRACE", "NET_ADMIN"}, opts.CapAdd)
+	assert.Equal(t, []string{"seccomp=unconfined", "label=disable"}, opts.SecurityOpt)
+	assert.True(t, opts.Privileged)
+
+	_, ignored := parseSecurityRunArgs(p.Config.RunArgs)
+	assert.Equal(t, []string{"--network=host"}, ignored)
+	_, ignored = parseSecurityRunArgs([]string{"--privileged=maybe", "--cap-add"})
+	assert.Equal(t, []string{"--privileged=maybe", "--cap-add"}, ignored)
+}
+
 // TestParserDevcontainerPortsAttributes parses a devcontainer.json
 // that declares forwardPorts *AND* portsAttributes and validates that
 // explicit port attributes are able to override default values
@@ -355,8 +507,8 @@ func TestParseDevcontainerVarExpansion(t *testing.T) {
 		// devcontainer spec vars
 		"CONTAINER_WORKSPACE_FOLDER":          DefWorkspacePath,
 		"CONTAINER_WORKSPACE_FOLDER_BASENAME": filepath.Base(DefWorkspacePath),
-		"LOCAL_WORKSPACE_FOLDER":              *p.Config.Context,
-		"LOCAL_WORKSPACE_FOLDER_BASENAME":     filepath.Base(*p.Config.Context),
+		"LOCAL_WORKSPACE_FOLDER":              p.LocalWorkspaceFolder,
+		"LOCAL_WORKSPACE_FOLDER_BASENAME":     filepath.Base(p.LocalWorkspaceFolder),
 		// Regular env vars
 		"BRIG_TEST_VAR_INDIRECT":    localEnvVars["BRIG_TEST_VAR"],
 		"BRIG_TEST_VAR_NONEXISTING": "",
@@ -396,6 +548,52 @@ func TestParseDevcontainerVarExpansion(t *testing.T) {
 	}
 }
 
+// TestParseDevcontainerVarSubstitution checks that the variables
+// defined by the spec are substituted in every field, and that
+// references to the container's environment wait for it to be
+// probed.
+func TestParseDevcontainerVarSubstitution(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Setenv("BRIG_TEST_NAME", "brig")
+	t.Setenv("BRIG_TEST_REGISTRY", "")
+
+	p, err := NewDevcontainerParser(filepath.Join("testdata", "parse", "devcontainer", "variable-substitution.json"))
+	assert.Nil(t, err)
+	if err := p.Validate(); err != nil {
+		t.Fatal("devcontainer.json expected to be valid failed validation")
+	}
+	if err := p.Parse(); err != nil {
+		t.Fatal("devcontainer.json expected to be valid failed parsing")
+	}
+
+	ctxBasename := filepath.Base(*p.Config.Context)
+	assert.Equal(t, fmt.Sprintf("brig in %s", ctxBasename), *p.Config.Name)
+	assert.Equal(t, "ghcr.io/brig/devcontainer:latest", *p.Config.Image)
+	assert.Equal(t, fmt.Sprintf("/workspaces/%s", ctxBasename), *p.Config.WorkspaceFolder)
+	assert.Equal(t, []string{"--label", fmt.Sprintf("workspace=%s", *p.Config.Context)}, p.Config.RunArgs)
+	assert.Equal(t, "brig", *p.Config.Features["features/with-options"]["string-opt"].String)
+	assert.Equal(t, fmt.Sprintf("echo /workspaces/%s $HOME ${HOME}", ctxBasename), *p.Config.PostCreateCommand.String)
+	assert.Equal(t, []string{"ls", ctxBasename}, p.Config.PostStartCommand.StringArray)
+	parallel := *p.Config.PostAttachCommand.ParallelCommands
+	assert.Equal(t, "echo brig", *parallel["greet"].String)
+	assert.Equal(t, []string{"ls", "fallback"}, parallel["list"].StringArray)
+
+	if assert.NotNil(t, p.DevcontainerID) {
+		assert.Equal(t, *p.DevcontainerID, p.Config.RemoteEnv["ID"])
+	}
+
+	// Left for later
+	assert.Equal(t, fmt.Sprintf("${containerEnv:PATH}:/workspaces/%s/bin", ctxBasename), p.Config.RemoteEnv["PATH"])
+	assert.Equal(t, "echo ${containerEnv:PATH}", *parallel["path"].String)
+
+	p.ResolveContainerEnv(map[string]string{"PATH": "/usr/bin"}, map[string]string{"PATH": "/usr/local/bin"})
+	assert.Equal(t, fmt.Sprintf("/usr/bin:/workspaces/%s/bin", ctxBasename), p.Config.RemoteEnv["PATH"])
+	parallel = *p.Config.PostAttachCommand.ParallelCommands
+	assert.Equal(t, "echo /usr/bin", *parallel["path"].String)
+}
+
 // TestValidateDevcontainer attempts validation of known valid and
 // invalid samples of devcontainer.json files.
 func TestValidateDevcontainer(t *testing.T) {
@@ -434,7 +632,101 @@ func TestValidateDevcontainer(t *testing.T) {
 				if err = p.Validate(); err == nil {
 					t.Fatal("known-invalid sample passed validation: ", path)
 				}
+				assert.ErrorIs(t, err, ErrSchemaValidation)
+				assert.NotEmpty(t, ValidationErrorMessages(err))
 			}
 		})
 	}
 }
+
+// TestMarshalDevcontainerConfig checks that a parsed configuration
+// encodes back into the forms devcontainer.json uses, and so can be
+// parsed again into the same configuration.
+func TestMarshalDevcontainerConfig(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, fixture := range []string{"lifecycle.json", "features.json"} {
+		p, err := NewDevcontainerParser(filepath.Join("testdata", "parse", "devcontainer", fixture))
+		assert.Nil(t, err)
+		assert.Nil(t, p.Validate())
+		assert.Nil(t, p.Parse())
+
+		encoded, err := json.Marshal(p.Config)
+		assert.Nil(t, err, fixture)
+		var decoded DevcontainerConfig
+		assert.Nil(t, json.Unmarshal(encoded, &decoded), fixture)
+		// Empty maps are left out when encoding
+		assert.Empty(t, p.Config.PortsAttributes)
+		decoded.PortsAttributes = p.Config.PortsAttributes
+		assert.Equal(t, p.Config, decoded, fixture)
+	}
+
+	p, err := NewDevcontainerParser(filepath.Join("testdata", "parse", "devcontainer", "lifecycle.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+	encoded, err := json.Marshal(p.Config)
+	assert.Nil(t, err)
+	assert.Contains(t, string(encoded), `"postAttachCommand":{"cmd1":"test","cmd2":["test"]}`)
+	assert.Contains(t, string(encoded), `"postStartCommand":["test"]`)
+}
+
+// TestUnmarshalCacheFrom checks that build.cacheFrom can be either a
+// single image or a list of them.
+func TestUnmarshalCacheFrom(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var build BuildOptions
+	assert.Nil(t, json.Unmarshal([]byte(`{"cacheFrom": "ghcr.io/example/app:cache"}`), &build))
+	assert.Equal(t, "ghcr.io/example/app:cache", *build.CacheFrom.String)
+	assert.Empty(t, build.CacheFrom.StringArray)
+
+	build = BuildOptions{}
+	assert.Nil(t, json.Unmarshal([]byte(`{"cacheFrom": ["ghcr.io/example/app:main", "ghcr.io/example/app:dev"]}`), &build))
+	assert.Nil(t, build.CacheFrom.String)
+	assert.Equal(t, []string{"ghcr.io/example/app:main", "ghcr.io/example/app:dev"}, build.CacheFrom.StringArray)
+
+	assert.NotNil(t, json.Unmarshal([]byte(`{"cacheFrom": 1}`), &build))
+}
+
+// TestApplyOverlay checks that a config overlay is merged over
+// devcontainer.json the way the spec merges configuration.
+func TestApplyOverlay(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p, err := NewDevcontainerParser(filepath.Join("testdata", "parse", "overlay", "devcontainer.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, p.ApplyOverlay(filepath.Join("testdata", "parse", "overlay", "overlay.jsonc")))
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+
+	assert.Equal(t, "golang:1.24", *p.Config.Image)
+	assert.Equal(t, []string{"SYS_PTRACE", "NET_RAW"}, p.Config.CapAdd)
+	assert.Equal(t, EnvVarMap{"CI": "true", "EDITOR": "nano"}, p.Config.ContainerEnv)
+	assert.Equal(t, ForwardPorts{"8080", "9090"}, p.Config.ForwardPorts)
+	assert.Equal(t, []string{"make", "test"}, p.Config.PostStartCommand.StringArray)
+	assert.Equal(t, []string{"--cap-add=NET_ADMIN", "--security-opt=seccomp=unconfined"}, p.Config.RunArgs)
+	assert.Nil(t, p.Config.RemoteUser)
+
+	assert.Len(t, p.Config.Mounts, 2)
+	assert.Equal(t, "/host-tmp", p.Config.Mounts[0].Target)
+	assert.Equal(t, "/cache", p.Config.Mounts[1].Target)
+	assert.Equal(t, "ci-cache", p.Config.Mounts[1].Source)
+
+	// The merged config is validated
+	p, err = NewDevcontainerParser(filepath.Join("testdata", "parse", "overlay", "devcontainer.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+	invalidOverlay := filepath.Join(t.TempDir(), "invalid.json")
+	assert.Nil(t, os.WriteFile(invalidOverlay, []byte(`{"forwardPorts": [true]}`), 0o644))
+	assert.Nil(t, p.ApplyOverlay(invalidOverlay))
+	assert.False(t, p.IsValidConfig)
+	assert.ErrorIs(t, p.Validate(), ErrSchemaValidation)
+
+	assert.NotNil(t, p.ApplyOverlay(filepath.Join(t.TempDir(), "missing.json")))
+	assert.Nil(t, os.WriteFile(invalidOverlay, []byte(`["not", "an", "object"]`), 0o644))
+	assert.NotNil(t, p.ApplyOverlay(invalidOverlay))
+}
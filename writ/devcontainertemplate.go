@@ -0,0 +1,52 @@
+/*
+   writ: a devcontainer.json parser
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package writ houses a validating parser for devcontainer.json files
+package writ
+
+// Development Container Template Metadata (devcontainer-template.json).
+// See https://containers.dev/implementors/templates/ for more
+// information.
+type DevcontainerTemplateConfig struct {
+	// Description of the Template. For the best appearance in an implementing tool, refrain
+	// from including markdown or HTML in the description.
+	Description *string `json:"description,omitempty"`
+	// URL to documentation for the Template.
+	DocumentationURL *string `json:"documentationURL,omitempty"`
+	// ID of the Template. The id should be unique in the context of the repository/published
+	// package where the Template exists and must match the name of the directory where the
+	// devcontainer-template.json resides.
+	ID string `json:"id"`
+	// List of strings relevant to a user that would search for this Template.
+	Keywords []string `json:"keywords,omitempty"`
+	// URL to the license for the Template.
+	LicenseURL *string `json:"licenseURL,omitempty"`
+	// Display name of the Template.
+	Name string `json:"name"`
+	// An array of files or directories, relative to the root of the Template, that a user may
+	// opt out of applying. A directory is named by a trailing '/*'.
+	OptionalPaths []string `json:"optionalPaths,omitempty"`
+	// Possible user-configurable options for this Template. The selected options are
+	// substituted into the Template's files wherever ${templateOption:<option>} appears.
+	//
+	// Template options take the same shape as Feature options.
+	Options FeatureOptions `json:"options,omitempty"`
+	// Languages and platforms supported by the Template.
+	Platforms []string `json:"platforms,omitempty"`
+	// Name of the publisher or maintainer of the Template.
+	Publisher *string `json:"publisher,omitempty"`
+	// The version of the Template. Follows the semantic versioning (semver) specification.
+	Version string `json:"version"`
+}
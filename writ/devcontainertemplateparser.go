@@ -0,0 +1,114 @@
+/*
+   writ: a devcontainer.json parser
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package writ houses a validating parser for devcontainer.json files
+package writ
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// devcontainerTemplateJSONSchema is the contents of the JSON schema
+// against which devcontainer-template.json files are validated.
+//
+//go:embed specs/devContainerTemplate.schema.json
+var devcontainerTemplateJSONSchema string
+
+// devcontainerTemplateJSONSchemaPath is the path used for the JSON
+// schema when being added manually as resource for the validator.
+const devcontainerTemplateJSONSchemaPath string = "devContainerTemplate.schema.json"
+
+// DevcontainerTemplateParser parses the devcontainer-template.json
+// at the root of a Template.
+type DevcontainerTemplateParser struct {
+	Config DevcontainerTemplateConfig
+
+	Parser
+}
+
+func NewDevcontainerTemplateParser(configPath string) (p *DevcontainerTemplateParser, err error) {
+	parser, err := NewParser(configPath)
+	if err != nil {
+		return nil, err
+	}
+	parser.jsonSchema = devcontainerTemplateJSONSchema
+	parser.jsonSchemaPath = devcontainerTemplateJSONSchemaPath
+	return &DevcontainerTemplateParser{
+		Parser: *parser,
+	}, nil
+}
+
+func (p *DevcontainerTemplateParser) Parse() error {
+	if !p.IsValidConfig {
+		return errors.New("devcontainer-template.json flagged invalid")
+	}
+
+	slog.Debug("attempting to unmarshal and parse devcontainer-template.json", "path", p.Filepath)
+	if err := json.Unmarshal(p.standardizedJSON, &p.Config); err != nil {
+		slog.Error("failed to unmarshal JSON", "path", p.Filepath, "error", err)
+		return err
+	}
+
+	for optName, option := range p.Config.Options {
+		p.SetOption(optName, option.Default)
+	}
+	slog.Debug("configuration parsed", "config", p.Config)
+	return nil
+}
+
+// SetOption sets the value of the option name.
+//
+// Unlike with Features, the value is checked against the option's
+// type and, for options with an enum, its allowed values, as it
+// usually comes straight from the user.
+func (p *DevcontainerTemplateParser) SetOption(name string, value *FeatureValue) error {
+	option, ok := p.Config.Options[name]
+	switch {
+	case !ok:
+		return fmt.Errorf("attempted to set the value of unknown option: %s", name)
+	case value == nil:
+		return fmt.Errorf("attempted to unset option: %s", name)
+	case option.Type == FeatureOptionTypeBoolean && value.Bool == nil:
+		return fmt.Errorf("option %s takes a boolean value", name)
+	case option.Type == FeatureOptionTypeString && value.String == nil:
+		return fmt.Errorf("option %s takes a string value", name)
+	case len(option.Enum) > 0 && !slices.Contains(option.Enum, *value.String):
+		return fmt.Errorf("option %s must be one of %v", name, option.Enum)
+	}
+	option.Value = value
+	return nil
+}
+
+// OptionValues returns the values of the Template's options as they
+// are to be substituted into its files, keyed by the options' names.
+func (p *DevcontainerTemplateParser) OptionValues() map[string]string {
+	values := make(map[string]string, len(p.Config.Options))
+	for optName, option := range p.Config.Options {
+		switch {
+		case option.Value == nil:
+			values[optName] = ""
+		case option.Value.Bool != nil:
+			values[optName] = fmt.Sprint(*option.Value.Bool)
+		case option.Value.String != nil:
+			values[optName] = *option.Value.String
+		}
+	}
+	return values
+}
@@ -0,0 +1,51 @@
+package writ
+
+import (
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseDevcontainerTemplate checks that a
+// devcontainer-template.json is parsed, with its options set to their
+// defaults, and that setting them checks their values.
+func TestParseDevcontainerTemplate(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p, err := NewDevcontainerTemplateParser(filepath.Join("testdata", "parse", "devcontainer-template", "simple-devcontainer-template.json"))
+	assert.Nil(t, err)
+	assert.NotNil(t, p.Parse())
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, p.Parse())
+
+	assert.Equal(t, "simple", p.Config.ID)
+	assert.Equal(t, "1.0.0", p.Config.Version)
+	assert.Equal(t, "Simple", p.Config.Name)
+	assert.Equal(t, []string{".github/*"}, p.Config.OptionalPaths)
+	assert.Equal(t, map[string]string{
+		"imageVariant": "3.22",
+		"installTools": "false",
+		"shell":        "ash",
+	}, p.OptionValues())
+
+	variant := "edge"
+	assert.Nil(t, p.SetOption("imageVariant", &FeatureValue{String: &variant}))
+	assert.Equal(t, "edge", p.OptionValues()["imageVariant"])
+
+	// Enums are enforced; proposals aren't
+	shell := "zsh"
+	assert.NotNil(t, p.SetOption("shell", &FeatureValue{String: &shell}))
+	assert.Equal(t, "ash", p.OptionValues()["shell"])
+
+	// So are types
+	assert.NotNil(t, p.SetOption("installTools", &FeatureValue{String: &variant}))
+	assert.NotNil(t, p.SetOption("doesNotExist", &FeatureValue{String: &variant}))
+
+	p, err = NewDevcontainerTemplateParser(filepath.Join("testdata", "parse", "devcontainer-template", "invalid-devcontainer-template.json"))
+	assert.Nil(t, err)
+	assert.NotNil(t, p.Validate())
+}
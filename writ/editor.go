@@ -0,0 +1,379 @@
+/*
+   writ: a devcontainer.json parser
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package writ houses a validating parser for devcontainer.json files
+package writ
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tailscale/hujson"
+)
+
+// ErrEditPath is wrapped by the errors Editor's methods return when
+// the JSON Pointer they're given leads somewhere a value can't be set,
+// e.g., into a string, or to an index in an array other than its end.
+var ErrEditPath = errors.New("no value can be set at path")
+
+// Editor makes changes to a JSON (or JSONC) file, e.g., a
+// devcontainer.json, then writes it back, keeping its comments and
+// formatting: only what's changed is touched, and values that are
+// added are laid out (e.g., indented) like the ones around them.
+//
+// Values are referred to by JSON Pointer (RFC 6901), e.g.,
+// "/features/ghcr.io~1devcontainers~1features~1git:1".
+type Editor struct {
+	Filepath string // Path to the target JSON file
+
+	indent string       // What one level of indentation is in the file, e.g., a tab
+	root   hujson.Value // The contents of the file, as edited so far
+}
+
+// NewEditor returns an Editor for the JSON (or JSONC) file at path.
+func NewEditor(path string) (*Editor, error) {
+	slog.Debug("reading JSON config for editing", "path", path)
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	root, err := hujson.Parse(contents)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	if _, ok := root.Value.(*hujson.Object); !ok {
+		return nil, fmt.Errorf("%s doesn't contain a JSON object", path)
+	}
+	return &Editor{Filepath: path, indent: detectIndent(contents), root: root}, nil
+}
+
+// Get decodes the value at pointer into v, as encoding/json would.
+//
+// Returns false if there's no such value.
+func (e *Editor) Get(pointer string, v any) (bool, error) {
+	target := e.root.Find(pointer)
+	if target == nil {
+		return false, nil
+	}
+	value := target.Clone()
+	value.Standardize()
+	return true, json.Unmarshal(value.Pack(), v)
+}
+
+// Set sets the value at pointer to value, encoded as encoding/json
+// would, replacing whatever's there.
+//
+// Objects that lead to it are added as needed; a pointer that ends in
+// "-" appends value to the array it refers to (see Append).
+func (e *Editor) Set(pointer string, value any) error {
+	newValue, err := newEditorValue(value)
+	if err != nil {
+		return err
+	}
+	if target := e.root.Find(pointer); target != nil {
+		parentPointer, _ := splitPointer(pointer)
+		multiline := true
+		if parent := e.root.Find(parentPointer); parent != nil && len(pointer) > 0 {
+			multiline = bytes.ContainsRune(parent.Pack(), '\n')
+		}
+		target.Value = newValue.Value
+		e.layout(target, pointerDepth(pointer), multiline)
+		return nil
+	}
+	if len(pointer) == 0 {
+		return fmt.Errorf("%w: %q", ErrEditPath, pointer)
+	}
+
+	parentPointer, name := splitPointer(pointer)
+	parent := e.root.Find(parentPointer)
+	if parent == nil {
+		if err = e.Set(parentPointer, map[string]any{}); err != nil {
+			return err
+		}
+		parent = e.root.Find(parentPointer)
+	}
+	if err = e.insert(parent, pointerDepth(parentPointer), name, newValue); err != nil {
+		return fmt.Errorf("%w: %q", err, pointer)
+	}
+	return nil
+}
+
+// Append appends value to the array at pointer, which is added if
+// there isn't one.
+func (e *Editor) Append(pointer string, value any) error {
+	if e.root.Find(pointer) == nil {
+		if err := e.Set(pointer, []any{}); err != nil {
+			return err
+		}
+	}
+	return e.Set(pointer+"/-", value)
+}
+
+// Remove removes the value at pointer, along with the comments before
+// it; removing a value that isn't there does nothing.
+func (e *Editor) Remove(pointer string) error {
+	if len(pointer) == 0 {
+		return fmt.Errorf("%w: %q", ErrEditPath, pointer)
+	}
+	if e.root.Find(pointer) == nil {
+		return nil
+	}
+	patch, err := json.Marshal([]map[string]string{{"op": "remove", "path": pointer}})
+	if err != nil {
+		return err
+	}
+	return e.root.Patch(patch)
+}
+
+// Bytes returns the contents of the file, as edited so far.
+func (e *Editor) Bytes() []byte {
+	return e.root.Pack()
+}
+
+// Save atomically replaces the file with its contents as edited so
+// far, keeping its permissions.
+func (e *Editor) Save() error {
+	slog.Debug("writing edited JSON config", "path", e.Filepath)
+	info, err := os.Stat(e.Filepath)
+	if err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(e.Filepath), filepath.Base(e.Filepath)+".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(e.Bytes())
+	if err == nil {
+		err = tempFile.Chmod(info.Mode().Perm())
+	}
+	if err = errors.Join(err, tempFile.Close()); err == nil {
+		err = os.Rename(tempFile.Name(), e.Filepath)
+	}
+	if err != nil {
+		return errors.Join(err, os.Remove(tempFile.Name()))
+	}
+	return nil
+}
+
+// insert adds value to parent, the object or array at depth, as the
+// member called name (or at the end, if parent is an array and name
+// is "-"), lined up with the values already in it.
+func (e *Editor) insert(parent *hujson.Value, depth int, name string, value hujson.Value) error {
+	switch comp := parent.Value.(type) {
+	case *hujson.Object:
+		trailingComma := len(comp.Members) > 0 && comp.Members[len(comp.Members)-1].Value.AfterExtra != nil
+		siblings := make([]hujson.Extra, len(comp.Members))
+		separator := hujson.Extra(" ")
+		for i, member := range comp.Members {
+			siblings[i] = member.Name.BeforeExtra
+		}
+		if len(comp.Members) > 0 && isWhitespace(comp.Members[0].Value.BeforeExtra) {
+			separator = bytes.Clone(comp.Members[0].Value.BeforeExtra)
+		}
+		before, multiline := e.siblingIndent(siblings, &comp.AfterExtra, depth, true)
+		member := hujson.ObjectMember{Name: hujson.Value{BeforeExtra: before, Value: hujson.String(name)}, Value: value}
+		member.Value.BeforeExtra = separator
+		comp.Members = append(comp.Members, member)
+		if trailingComma {
+			comp.Members[len(comp.Members)-1].Value.AfterExtra = hujson.Extra{}
+		}
+		e.layout(&comp.Members[len(comp.Members)-1].Value, depth+1, multiline)
+
+	case *hujson.Array:
+		if name != "-" {
+			return ErrEditPath
+		}
+		trailingComma := len(comp.Elements) > 0 && comp.Elements[len(comp.Elements)-1].AfterExtra != nil
+		siblings := make([]hujson.Extra, len(comp.Elements))
+		for i, element := range comp.Elements {
+			siblings[i] = element.BeforeExtra
+		}
+		_, scalar := value.Value.(hujson.Literal)
+		before, multiline := e.siblingIndent(siblings, &comp.AfterExtra, depth, !scalar)
+		value.BeforeExtra = before
+		comp.Elements = append(comp.Elements, value)
+		if trailingComma {
+			comp.Elements[len(comp.Elements)-1].AfterExtra = hujson.Extra{}
+		}
+		e.layout(&comp.Elements[len(comp.Elements)-1], depth+1, multiline)
+
+	default:
+		return ErrEditPath
+	}
+	return nil
+}
+
+// siblingIndent returns what goes before a value added to the end of
+// an object or array at depth, given what goes before the ones in it
+// (siblings) and before its closing bracket (afterExtra), along with
+// whether it's laid out over multiple lines.
+//
+// If it's empty, it's opened up over multiple lines if openUp is
+// true, as objects (and arrays of them) usually are in
+// devcontainer.json. A line comment at the end of the line its last
+// value is on stays on that line.
+func (e *Editor) siblingIndent(siblings []hujson.Extra, afterExtra *hujson.Extra, depth int, openUp bool) (hujson.Extra, bool) {
+	multiline := bytes.ContainsRune(*afterExtra, '\n')
+	for _, before := range siblings {
+		multiline = multiline || bytes.ContainsRune(before, '\n')
+	}
+	if len(siblings) == 0 && openUp && !multiline && isWhitespace(*afterExtra) {
+		*afterExtra = hujson.Extra("\n" + strings.Repeat(e.indent, depth))
+		multiline = true
+	}
+
+	var lineComment hujson.Extra
+	if idx := bytes.IndexByte(*afterExtra, '\n'); idx >= 0 && bytes.HasPrefix(bytes.TrimSpace((*afterExtra)[:idx]), []byte("//")) {
+		lineComment = bytes.Clone((*afterExtra)[:idx])
+		*afterExtra = (*afterExtra)[idx:]
+	}
+	if !multiline {
+		if len(siblings) == 0 {
+			return nil, false
+		}
+		if last := siblings[len(siblings)-1]; len(siblings) > 1 && isWhitespace(last) {
+			return bytes.Clone(last), false
+		}
+		return hujson.Extra(" "), false
+	}
+	if len(siblings) > 0 {
+		last := siblings[len(siblings)-1]
+		if idx := bytes.LastIndexByte(last, '\n'); idx >= 0 && isWhitespace(last[idx:]) {
+			return append(lineComment, last[idx:]...), true
+		}
+	}
+	return append(lineComment, "\n"+strings.Repeat(e.indent, depth+1)...), true
+}
+
+// layout lays out value, which was just added at depth, over multiple
+// lines if the object or array it was added to is, indenting what's
+// in it; arrays made up of only strings, numbers, booleans, and nulls
+// are kept on one line either way.
+func (e *Editor) layout(value *hujson.Value, depth int, multiline bool) {
+	switch comp := value.Value.(type) {
+	case *hujson.Object:
+		if len(comp.Members) == 0 {
+			return
+		}
+		for i := range comp.Members {
+			member := &comp.Members[i]
+			switch {
+			case multiline:
+				member.Name.BeforeExtra = hujson.Extra("\n" + strings.Repeat(e.indent, depth+1))
+			case i > 0:
+				member.Name.BeforeExtra = hujson.Extra(" ")
+			}
+			member.Value.BeforeExtra = hujson.Extra(" ")
+			e.layout(&member.Value, depth+1, multiline)
+		}
+		if multiline {
+			comp.AfterExtra = hujson.Extra("\n" + strings.Repeat(e.indent, depth))
+		}
+
+	case *hujson.Array:
+		scalars := true
+		for _, element := range comp.Elements {
+			if _, ok := element.Value.(hujson.Literal); !ok {
+				scalars = false
+			}
+		}
+		multiline = multiline && !scalars && len(comp.Elements) > 0
+		for i := range comp.Elements {
+			element := &comp.Elements[i]
+			switch {
+			case multiline:
+				element.BeforeExtra = hujson.Extra("\n" + strings.Repeat(e.indent, depth+1))
+			case i > 0:
+				element.BeforeExtra = hujson.Extra(" ")
+			}
+			e.layout(element, depth+1, multiline)
+		}
+		if multiline {
+			comp.AfterExtra = hujson.Extra("\n" + strings.Repeat(e.indent, depth))
+		}
+	}
+}
+
+// newEditorValue returns value, encoded as encoding/json would (but
+// without escaping HTML), as a value that can be added to the file.
+func newEditorValue(value any) (hujson.Value, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return hujson.Value{}, err
+	}
+	newValue, err := hujson.Parse(bytes.TrimSpace(buf.Bytes()))
+	if err != nil {
+		return hujson.Value{}, err
+	}
+	return newValue, nil
+}
+
+// detectIndent returns what one level of indentation is in contents:
+// a tab, if the first indented line is indented with one, otherwise
+// the fewest spaces any line is indented with. Defaults to a tab.
+func detectIndent(contents []byte) string {
+	spaces := 0
+	for line := range bytes.Lines(contents) {
+		trimmed := bytes.TrimSpace(line)
+		// Skips blank lines, and the insides of block comments
+		if len(trimmed) == 0 || trimmed[0] == '*' {
+			continue
+		}
+		switch line[0] {
+		case '\t':
+			if spaces == 0 {
+				return "\t"
+			}
+		case ' ':
+			if leading := len(line) - len(bytes.TrimLeft(line, " ")); spaces == 0 || leading < spaces {
+				spaces = leading
+			}
+		}
+	}
+	if spaces == 0 {
+		return "\t"
+	}
+	return strings.Repeat(" ", spaces)
+}
+
+// splitPointer splits pointer into the JSON Pointer to what it refers
+// to is in and the (unescaped) name it has in it.
+func splitPointer(pointer string) (string, string) {
+	idx := strings.LastIndexByte(pointer, '/')
+	if idx < 0 {
+		return "", pointer
+	}
+	return pointer[:idx], strings.NewReplacer("~1", "/", "~0", "~").Replace(pointer[idx+1:])
+}
+
+// pointerDepth returns how deep what pointer refers to is nested,
+// with the root being at 0.
+func pointerDepth(pointer string) int {
+	return strings.Count(pointer, "/")
+}
+
+// isWhitespace reports whether extra is made up of only whitespace,
+// i.e., no comments.
+func isWhitespace(extra hujson.Extra) bool {
+	return len(bytes.TrimSpace(extra)) == 0
+}
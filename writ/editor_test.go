@@ -0,0 +1,99 @@
+package writ
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEditor checks that values can be set, appended, and removed
+// with the comments and formatting in the rest of the file left as
+// they were, and that values added are laid out like their siblings.
+func TestEditor(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	configPath := filepath.Join(t.TempDir(), "devcontainer.json")
+	assert.Nil(t, os.WriteFile(configPath, []byte(`// Top-level comment
+{
+  // The base image
+  "image": "alpine", // pinned later
+  "runArgs": ["--init"],
+  "containerEnv": {
+    "A": "1", // keep me
+  },
+  /* block */ "remoteUser": "root"
+}
+`), 0o600))
+
+	e, err := NewEditor(configPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "  ", e.indent)
+
+	assert.Nil(t, e.Set("/image", "debian"))
+	assert.Nil(t, e.Append("/runArgs", "--privileged"))
+	assert.Nil(t, e.Set("/containerEnv/B", "2"))
+	assert.Nil(t, e.Set("/customizations/vscode/extensions", []string{"golang.go"}))
+	assert.Nil(t, e.Append("/mounts", map[string]string{"source": "cache", "target": "/cache", "type": "volume"}))
+	assert.Nil(t, e.Remove("/remoteUser"))
+	assert.Nil(t, e.Remove("/doesNotExist"))
+	assert.ErrorIs(t, e.Set("/image/tag", "latest"), ErrEditPath)
+	assert.Nil(t, e.Set("/runArgs/0", "--rm"))
+	assert.ErrorIs(t, e.Set("/runArgs/5", "--rm"), ErrEditPath)
+
+	var env map[string]string
+	found, err := e.Get("/containerEnv", &env)
+	assert.True(t, found)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"A": "1", "B": "2"}, env)
+	found, err = e.Get("/remoteUser", &env)
+	assert.False(t, found)
+	assert.Nil(t, err)
+
+	assert.Nil(t, e.Save())
+	contents, err := os.ReadFile(configPath)
+	assert.Nil(t, err)
+	assert.Equal(t, `// Top-level comment
+{
+  // The base image
+  "image": "debian", // pinned later
+  "runArgs": ["--rm", "--privileged"],
+  "containerEnv": {
+    "A": "1", // keep me
+    "B": "2",
+  },
+  "customizations": {
+    "vscode": {
+      "extensions": ["golang.go"]
+    }
+  },
+  "mounts": [
+    {
+      "source": "cache",
+      "target": "/cache",
+      "type": "volume"
+    }
+  ]
+}
+`, string(contents))
+	info, err := os.Stat(configPath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	p, err := NewDevcontainerParser(configPath)
+	assert.Nil(t, err)
+	assert.Nil(t, p.Validate())
+}
+
+// TestDetectIndent checks that the indentation a file uses is picked
+// up, whether it's tabs or spaces.
+func TestDetectIndent(t *testing.T) {
+	assert.Equal(t, "\t", detectIndent([]byte("{\n\t\"a\": {\n\t\t\"b\": 1\n\t}\n}\n")))
+	assert.Equal(t, "    ", detectIndent([]byte("{\n    \"a\": {\n        \"b\": 1\n    }\n}\n")))
+	assert.Equal(t, "  ", detectIndent([]byte("/*\n * Comment\n */\n{\n  \"a\": 1\n}\n")))
+	assert.Equal(t, "\t", detectIndent([]byte(`{"a": 1}`)))
+}
@@ -0,0 +1,53 @@
+/*
+   writ: a devcontainer.json parser
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package writ houses a validating parser for devcontainer.json files
+package writ
+
+import (
+	"encoding/json"
+)
+
+// MarshalJSON for the CacheFrom type
+func (c CacheFrom) MarshalJSON() ([]byte, error) {
+	if c.String != nil {
+		return json.Marshal(*c.String)
+	}
+	return json.Marshal(c.StringArray)
+}
+
+// MarshalJSON for the CommandBase type
+func (c CommandBase) MarshalJSON() ([]byte, error) {
+	if c.String != nil {
+		return json.Marshal(*c.String)
+	}
+	return json.Marshal(c.StringArray)
+}
+
+// MarshalJSON for the FeatureValue type
+func (f FeatureValue) MarshalJSON() ([]byte, error) {
+	if f.Bool != nil {
+		return json.Marshal(*f.Bool)
+	}
+	return json.Marshal(f.String)
+}
+
+// MarshalJSON for the LifecycleCommand type
+func (l LifecycleCommand) MarshalJSON() ([]byte, error) {
+	if l.ParallelCommands != nil {
+		return json.Marshal(*l.ParallelCommands)
+	}
+	return l.CommandBase.MarshalJSON()
+}
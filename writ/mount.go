@@ -0,0 +1,254 @@
+/*
+   writ: a devcontainer.json parser
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package writ houses a validating parser for devcontainer.json files
+package writ
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	dockermounts "github.com/docker/docker/volume/mounts"
+	units "github.com/docker/go-units"
+	"github.com/moby/moby/api/types/mount"
+)
+
+// ErrInvalidMountString is wrapped by the errors ParseMountString
+// returns.
+var ErrInvalidMountString = errors.New("invalid mount string")
+
+// ParseMountString parses mountString, which may either be in the
+// comma-separated format accepted by `docker run --mount` or in the
+// shorter format accepted by `docker run --volume`, into a MobyMount.
+//
+// A string with a key=value pair in it is taken to be in the former
+// format; anything else, in the latter.
+func ParseMountString(mountString string) (*MobyMount, error) {
+	if strings.Contains(mountString, "=") {
+		return parseMountSpec(mountString)
+	}
+
+	dockerParser := dockermounts.NewParser()
+	mountPt, err := dockerParser.ParseMountRaw(mountString, "")
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidMountString, mountString, err)
+	}
+	// The Docker parser returns its own Mount type, so round-trip it
+	// through JSON to convert it
+	specJSON, err := json.Marshal(mountPt.Spec)
+	if err != nil {
+		return nil, err
+	}
+	var parsedMount MobyMount
+	if err := json.Unmarshal(specJSON, &parsedMount); err != nil {
+		return nil, err
+	}
+	// The relabeling flag only makes it into the mode
+	for _, mode := range strings.Split(mountPt.Mode, ",") {
+		if mode == string(RelabelShared) || mode == string(RelabelPrivate) {
+			parsedMount.Relabel = Relabel(mode)
+		}
+	}
+	if err := parsedMount.validateRelabel(); err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidMountString, mountString, err)
+	}
+	return &parsedMount, nil
+}
+
+// validateRelabel checks that m's SELinux relabeling flag, if any, is
+// one of the supported ones, and that m is a bind mount; the engine
+// labels volumes itself.
+func (m *MobyMount) validateRelabel() error {
+	switch m.Relabel {
+	case RelabelNone:
+		return nil
+	case RelabelShared, RelabelPrivate:
+	default:
+		return fmt.Errorf("unknown SELinux relabeling flag %q", m.Relabel)
+	}
+	if m.Type != mount.TypeBind {
+		return fmt.Errorf("SELinux relabeling cannot be used with a %s mount", m.Type)
+	}
+	return nil
+}
+
+// parseMountSpec parses a mount string in the comma-separated format
+// accepted by `docker run --mount`.
+//
+// Fields are either key=value pairs or bare flags (e.g., readonly);
+// a field can be quoted if its value has a comma in it.
+func parseMountSpec(mountString string) (*MobyMount, error) {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w %q: %s", ErrInvalidMountString, mountString, fmt.Sprintf(format, args...))
+	}
+
+	csvReader := csv.NewReader(strings.NewReader(mountString))
+	fields, err := csvReader.Read()
+	if err != nil {
+		return nil, invalid("%s", err)
+	}
+
+	m := &MobyMount{Mount: mount.Mount{Type: mount.TypeVolume}}
+	bindOpts := func() *mount.BindOptions {
+		if m.BindOptions == nil {
+			m.BindOptions = &mount.BindOptions{}
+		}
+		return m.BindOptions
+	}
+	volumeOpts := func() *mount.VolumeOptions {
+		if m.VolumeOptions == nil {
+			m.VolumeOptions = &mount.VolumeOptions{}
+		}
+		return m.VolumeOptions
+	}
+	tmpfsOpts := func() *mount.TmpfsOptions {
+		if m.TmpfsOptions == nil {
+			m.TmpfsOptions = &mount.TmpfsOptions{}
+		}
+		return m.TmpfsOptions
+	}
+
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if len(field) == 0 {
+			return nil, invalid("empty field")
+		}
+
+		key, val, hasVal := strings.Cut(field, "=")
+		// The SELinux relabeling flags are case-sensitive; everything
+		// else isn't
+		if key != "z" && key != "Z" {
+			key = strings.ToLower(key)
+		}
+
+		if !hasVal {
+			switch key {
+			case "readonly", "ro":
+				m.ReadOnly = true
+			case "volume-nocopy":
+				volumeOpts().NoCopy = true
+			case "bind-nonrecursive":
+				bindOpts().NonRecursive = true
+			case "z", "Z":
+				m.Relabel = Relabel(key)
+			default:
+				return nil, invalid("%q is not a recognized flag, or is missing a value", key)
+			}
+			continue
+		}
+
+		switch key {
+		case "type":
+			m.Type = mount.Type(strings.ToLower(val))
+			switch m.Type {
+			case mount.TypeBind, mount.TypeVolume, mount.TypeTmpfs, mount.TypeNamedPipe, mount.TypeCluster, mount.TypeImage:
+			default:
+				return nil, invalid("unknown mount type %q", val)
+			}
+		case "source", "src":
+			m.Source = val
+		case "target", "destination", "dst":
+			m.Target = val
+		case "readonly", "ro":
+			if m.ReadOnly, err = strconv.ParseBool(val); err != nil {
+				return nil, invalid("value of %s must be a boolean, not %q", key, val)
+			}
+		case "consistency":
+			m.Consistency = mount.Consistency(strings.ToLower(val))
+		case "relabel":
+			// Podman's spelling of the z and Z flags
+			switch strings.ToLower(val) {
+			case "shared":
+				m.Relabel = RelabelShared
+			case "private":
+				m.Relabel = RelabelPrivate
+			default:
+				return nil, invalid("value of relabel must be shared or private, not %q", val)
+			}
+		case "bind-propagation":
+			bindOpts().Propagation = mount.Propagation(strings.ToLower(val))
+		case "bind-nonrecursive":
+			if bindOpts().NonRecursive, err = strconv.ParseBool(val); err != nil {
+				return nil, invalid("value of %s must be a boolean, not %q", key, val)
+			}
+		case "volume-nocopy":
+			if volumeOpts().NoCopy, err = strconv.ParseBool(val); err != nil {
+				return nil, invalid("value of %s must be a boolean, not %q", key, val)
+			}
+		case "volume-subpath":
+			volumeOpts().Subpath = val
+		case "volume-label":
+			labelKey, labelVal, _ := strings.Cut(val, "=")
+			if volumeOpts().Labels == nil {
+				m.VolumeOptions.Labels = make(map[string]string)
+			}
+			m.VolumeOptions.Labels[labelKey] = labelVal
+		case "volume-driver":
+			if volumeOpts().DriverConfig == nil {
+				m.VolumeOptions.DriverConfig = &mount.Driver{}
+			}
+			m.VolumeOptions.DriverConfig.Name = val
+		case "volume-opt":
+			optKey, optVal, ok := strings.Cut(val, "=")
+			if !ok {
+				return nil, invalid("value of volume-opt must be a key=value pair, not %q", val)
+			}
+			if volumeOpts().DriverConfig == nil {
+				m.VolumeOptions.DriverConfig = &mount.Driver{}
+			}
+			if m.VolumeOptions.DriverConfig.Options == nil {
+				m.VolumeOptions.DriverConfig.Options = make(map[string]string)
+			}
+			m.VolumeOptions.DriverConfig.Options[optKey] = optVal
+		case "tmpfs-size":
+			sizeBytes, err := units.RAMInBytes(val)
+			if err != nil {
+				return nil, invalid("value of tmpfs-size must be a size, not %q", val)
+			}
+			tmpfsOpts().SizeBytes = sizeBytes
+		case "tmpfs-mode":
+			mode, err := strconv.ParseUint(val, 8, 32)
+			if err != nil {
+				return nil, invalid("value of tmpfs-mode must be an octal number, not %q", val)
+			}
+			tmpfsOpts().Mode = os.FileMode(mode)
+		default:
+			return nil, invalid("unknown key %q", key)
+		}
+	}
+
+	if len(m.Target) == 0 {
+		return nil, invalid("target is required")
+	}
+	if m.BindOptions != nil && m.Type != mount.TypeBind {
+		return nil, invalid("bind options cannot be used with a %s mount", m.Type)
+	}
+	if err := m.validateRelabel(); err != nil {
+		return nil, invalid("%s", err)
+	}
+	if m.VolumeOptions != nil && m.Type != mount.TypeVolume {
+		return nil, invalid("volume options cannot be used with a %s mount", m.Type)
+	}
+	if m.TmpfsOptions != nil && m.Type != mount.TypeTmpfs {
+		return nil, invalid("tmpfs options cannot be used with a %s mount", m.Type)
+	}
+
+	return m, nil
+}
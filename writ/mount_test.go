@@ -0,0 +1,92 @@
+package writ
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/moby/moby/api/types/mount"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseMountString checks that both mount string formats are
+// parsed, including bare flags and quoted values.
+func TestParseMountString(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	m, err := ParseMountString("type=bind,src=/host,dst=/container,ro,bind-propagation=rshared")
+	assert.Nil(t, err)
+	assert.EqualValues(t, mount.TypeBind, m.Type)
+	assert.Equal(t, "/host", m.Source)
+	assert.Equal(t, "/container", m.Target)
+	assert.True(t, m.ReadOnly)
+	if assert.NotNil(t, m.BindOptions) {
+		assert.EqualValues(t, mount.PropagationRShared, m.BindOptions.Propagation)
+	}
+
+	// Commas can appear in values as long as the field is quoted
+	m, err = ParseMountString(`type=bind,"source=/path/with,comma",target=/workspace,Z`)
+	assert.Nil(t, err)
+	assert.Equal(t, "/path/with,comma", m.Source)
+	assert.Equal(t, "/workspace", m.Target)
+	assert.Equal(t, RelabelPrivate, m.Relabel)
+
+	m, err = ParseMountString("type=bind,src=/host,dst=/container,relabel=shared")
+	assert.Nil(t, err)
+	assert.Equal(t, RelabelShared, m.Relabel)
+
+	// Keys are case-insensitive and the type defaults to volume
+	m, err = ParseMountString("Source=cache,Target=/cache,Volume-NoCopy")
+	assert.Nil(t, err)
+	assert.EqualValues(t, mount.TypeVolume, m.Type)
+	assert.Equal(t, "cache", m.Source)
+	if assert.NotNil(t, m.VolumeOptions) {
+		assert.True(t, m.VolumeOptions.NoCopy)
+	}
+
+	m, err = ParseMountString("/host:/container:ro")
+	assert.Nil(t, err)
+	assert.EqualValues(t, mount.TypeBind, m.Type)
+	assert.Equal(t, "/host", m.Source)
+	assert.Equal(t, "/container", m.Target)
+	assert.True(t, m.ReadOnly)
+	assert.Equal(t, RelabelNone, m.Relabel)
+
+	m, err = ParseMountString("/host:/container:ro,z")
+	assert.Nil(t, err)
+	assert.True(t, m.ReadOnly)
+	assert.Equal(t, RelabelShared, m.Relabel)
+}
+
+// TestParseMountStringMalformed checks that malformed mount strings
+// are rejected with an error instead of a panic.
+func TestParseMountStringMalformed(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, mountString := range []string{
+		"type=bind,,target=/empty-field",
+		"type=bind,source=/src,target",
+		"type=bind,source=/src,target=/dst,bogus=1",
+		"type=bind,source=/src,target=/dst,bogus",
+		"type=weird,target=/dst",
+		"type=bind,source=/src",
+		"type=bind,source=/src,target=/dst,readonly=maybe",
+		"type=volume,target=/dst,volume-opt=novalue",
+		"type=tmpfs,target=/dst,tmpfs-size=lots",
+		"type=tmpfs,target=/dst,tmpfs-mode=999",
+		"type=volume,target=/dst,bind-propagation=rshared",
+		"type=bind,target=/dst,tmpfs-size=1m",
+		`type=bind,"source=/unterminated,target=/dst`,
+		"type=volume,source=cache,target=/dst,z",
+		"type=bind,source=/src,target=/dst,relabel=yes",
+		"cache:/dst:Z",
+		"=",
+		"",
+	} {
+		m, err := ParseMountString(mountString)
+		assert.Nil(t, m, mountString)
+		assert.ErrorIs(t, err, ErrInvalidMountString, mountString)
+	}
+}
@@ -0,0 +1,201 @@
+/*
+   writ: a devcontainer.json parser
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package writ houses a validating parser for devcontainer.json files
+package writ
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+
+	"github.com/tailscale/hujson"
+)
+
+// overlayUnionProperties are the properties whose lists are combined
+// with the ones they're overlaid on, without duplicates, rather than
+// replacing them.
+var overlayUnionProperties = []string{"capAdd", "forwardPorts", "securityOpt"}
+
+// ApplyOverlay deep-merges the JSON (or JSONC) config at overlayPath
+// over the target config, allowing parts of it to be changed (e.g., to
+// swap out the image, or add a mount) without editing it.
+//
+// Values are merged the way the spec merges configuration from
+// multiple sources:
+//
+//   - Objects (e.g., containerEnv and features) are merged key by key,
+//     recursively
+//   - capAdd, forwardPorts, and securityOpt are combined, without
+//     duplicates
+//   - runArgs are appended
+//   - mounts are combined, with the overlay's winning over ones with
+//     the same target
+//   - Everything else, including lists that make up a command, is
+//     replaced
+//
+// A null in the overlay removes the property it's set on.
+//
+// Has to be called before Validate, which then validates the merged
+// config. Relative paths in the overlay are resolved against the
+// target config, as if they were written in it.
+func (p *Parser) ApplyOverlay(overlayPath string) error {
+	slog.Debug("applying config overlay", "path", p.Filepath, "overlay", overlayPath)
+	overlayInput, err := os.ReadFile(overlayPath)
+	if err != nil {
+		slog.Error("failed to read config overlay", "error", err, "path", overlayPath)
+		return err
+	}
+	if overlayInput, err = hujson.Standardize(overlayInput); err != nil {
+		slog.Error("failed to standardize config overlay contents", "error", err, "path", overlayPath)
+		return err
+	}
+
+	var base, overlay map[string]any
+	if err = json.Unmarshal(p.standardizedJSON, &base); err != nil {
+		return err
+	}
+	if err = json.Unmarshal(overlayInput, &overlay); err != nil {
+		return fmt.Errorf("config overlay must be a JSON object: %w", err)
+	}
+
+	merged, err := mergeOverlay(base, overlay)
+	if err != nil {
+		return err
+	}
+	if p.standardizedJSON, err = json.Marshal(merged); err != nil {
+		return err
+	}
+	p.IsValidConfig = false
+	return nil
+}
+
+// mergeOverlay returns the top-level properties of a config with
+// overlay merged over base; see ApplyOverlay.
+func mergeOverlay(base map[string]any, overlay map[string]any) (map[string]any, error) {
+	merged := maps.Clone(base)
+	if merged == nil {
+		merged = make(map[string]any, len(overlay))
+	}
+	for key, val := range overlay {
+		switch {
+		case val == nil:
+			delete(merged, key)
+		case slices.Contains(overlayUnionProperties, key):
+			merged[key] = unionValues(overlayList(merged[key]), overlayList(val))
+		case key == "runArgs":
+			merged[key] = append(overlayList(merged[key]), overlayList(val)...)
+		case key == "mounts":
+			mounts, err := mergeMounts(overlayList(merged[key]), overlayList(val))
+			if err != nil {
+				return nil, err
+			}
+			merged[key] = mounts
+		default:
+			merged[key] = mergeValues(merged[key], val)
+		}
+	}
+	return merged, nil
+}
+
+// mergeValues merges overlay over base if they're both objects;
+// otherwise, overlay replaces base.
+func mergeValues(base any, overlay any) any {
+	baseObj, baseIsObj := base.(map[string]any)
+	overlayObj, overlayIsObj := overlay.(map[string]any)
+	if !baseIsObj || !overlayIsObj {
+		return overlay
+	}
+	merged := maps.Clone(baseObj)
+	for key, val := range overlayObj {
+		if val == nil {
+			delete(merged, key)
+			continue
+		}
+		merged[key] = mergeValues(merged[key], val)
+	}
+	return merged
+}
+
+// unionValues returns the values in base, followed by the ones in
+// overlay that aren't in base.
+func unionValues(base []any, overlay []any) []any {
+	merged := slices.Clone(base)
+	for _, val := range overlay {
+		if !slices.ContainsFunc(merged, func(existing any) bool { return reflect.DeepEqual(existing, val) }) {
+			merged = append(merged, val)
+		}
+	}
+	return merged
+}
+
+// mergeMounts returns the mounts in base that don't share a target
+// with any in overlay, followed by the ones in overlay.
+func mergeMounts(base []any, overlay []any) ([]any, error) {
+	overlayTargets := make([]string, 0, len(overlay))
+	for _, mountEntry := range overlay {
+		target, err := mountTarget(mountEntry)
+		if err != nil {
+			return nil, err
+		}
+		overlayTargets = append(overlayTargets, target)
+	}
+
+	var merged []any
+	for _, mountEntry := range base {
+		target, err := mountTarget(mountEntry)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(overlayTargets, target) {
+			merged = append(merged, mountEntry)
+		}
+	}
+	return append(merged, overlay...), nil
+}
+
+// mountTarget returns the target of a mount, in either of the forms
+// devcontainer.json allows.
+func mountTarget(mountEntry any) (string, error) {
+	switch m := mountEntry.(type) {
+	case string:
+		parsedMount, err := ParseMountString(m)
+		if err != nil {
+			return "", err
+		}
+		return parsedMount.Target, nil
+	case map[string]any:
+		target, _ := m["target"].(string)
+		return target, nil
+	default:
+		return "", fmt.Errorf("unsupported mount: %#v", mountEntry)
+	}
+}
+
+// overlayList returns val as a list, wrapping it in one if it isn't.
+func overlayList(val any) []any {
+	switch v := val.(type) {
+	case nil:
+		return nil
+	case []any:
+		return v
+	default:
+		return []any{v}
+	}
+}
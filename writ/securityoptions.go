@@ -0,0 +1,125 @@
+/*
+   writ: a devcontainer.json parser
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package writ houses a validating parser for devcontainer.json files
+package writ
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SecurityOptions are the settings that govern what a devcontainer is
+// allowed to do.
+type SecurityOptions struct {
+	CapAdd      []string // Capabilities added to the container, e.g., SYS_PTRACE
+	Privileged  bool     // Whether the container runs in privileged mode
+	SecurityOpt []string // Security options, e.g., seccomp=unconfined
+}
+
+// SecurityOptions returns the devcontainer's effective security
+// options: the ones in devcontainer.json (including those merged in
+// from Features; see MergeContainerProperties) combined with any
+// given in runArgs.
+//
+// Duplicate capabilities and security options are dropped.
+func (p *DevcontainerParser) SecurityOptions() SecurityOptions {
+	opts := SecurityOptions{
+		CapAdd:      p.Config.CapAdd,
+		Privileged:  p.Config.Privileged != nil && *p.Config.Privileged,
+		SecurityOpt: p.Config.SecurityOpt,
+	}
+	runArgsOpts, _ := parseSecurityRunArgs(p.Config.RunArgs)
+	return opts.Merge(runArgsOpts)
+}
+
+// Merge returns the combination of o and other: the capabilities and
+// security options of both, without duplicates, and privileged mode
+// if either asks for it.
+func (o SecurityOptions) Merge(other SecurityOptions) SecurityOptions {
+	return SecurityOptions{
+		CapAdd:      mergeCapabilities(o.CapAdd, other.CapAdd),
+		Privileged:  o.Privileged || other.Privileged,
+		SecurityOpt: mergeUnique(o.SecurityOpt, other.SecurityOpt),
+	}
+}
+
+// parseSecurityRunArgs picks out the security-related flags (i.e.,
+// --cap-add, --privileged, and --security-opt) from runArgs.
+//
+// Returns the arguments it doesn't recognize as well.
+func parseSecurityRunArgs(runArgs []string) (opts SecurityOptions, ignored []string) {
+	for i := 0; i < len(runArgs); i++ {
+		flag, val, hasVal := strings.Cut(runArgs[i], "=")
+		switch flag {
+		case "--cap-add", "--security-opt":
+			if !hasVal {
+				if i+1 >= len(runArgs) {
+					ignored = append(ignored, runArgs[i])
+					continue
+				}
+				i++
+				val = runArgs[i]
+			}
+			if flag == "--cap-add" {
+				opts.CapAdd = append(opts.CapAdd, val)
+			} else {
+				opts.SecurityOpt = append(opts.SecurityOpt, val)
+			}
+		case "--privileged":
+			privileged := true
+			if hasVal {
+				var err error
+				if privileged, err = strconv.ParseBool(val); err != nil {
+					ignored = append(ignored, runArgs[i])
+					continue
+				}
+			}
+			opts.Privileged = privileged
+		default:
+			ignored = append(ignored, runArgs[i])
+		}
+	}
+	return opts, ignored
+}
+
+// mergeCapabilities returns the capabilities in base and extra
+// without duplicates, treating, e.g., sys_ptrace and CAP_SYS_PTRACE
+// as the same.
+func mergeCapabilities(base []string, extra []string) []string {
+	var merged []string
+	var seen []string
+	for _, capability := range slices.Concat(base, extra) {
+		normalized := strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+		if !slices.Contains(seen, normalized) {
+			seen = append(seen, normalized)
+			merged = append(merged, capability)
+		}
+	}
+	return merged
+}
+
+// mergeUnique returns the items in base and extra, in order, without
+// duplicates.
+func mergeUnique(base []string, extra []string) []string {
+	var merged []string
+	for _, item := range slices.Concat(base, extra) {
+		if !slices.Contains(merged, item) {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
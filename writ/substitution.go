@@ -0,0 +1,183 @@
+/*
+   writ: a devcontainer.json parser
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package writ houses a validating parser for devcontainer.json files
+package writ
+
+import (
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+)
+
+// specVariablePattern matches references to the variables defined by
+// the devcontainer spec, e.g., ${localEnv:HOME} or
+// ${localWorkspaceFolder}.
+//
+// Env var lookups can carry a default value after a second colon,
+// e.g., ${localEnv:EDITOR:vim}.
+var specVariablePattern = regexp.MustCompile(`\$\{(localWorkspaceFolder|localWorkspaceFolderBasename|containerWorkspaceFolder|containerWorkspaceFolderBasename|devcontainerId|(localEnv|env|containerEnv|remoteEnv):([A-Za-z_][A-Za-z0-9_]*)(?::([^}]*))?)\}`)
+
+// SubstituteVariables replaces references to the variables defined by
+// the devcontainer spec in v with their values.
+//
+// Unlike ExpandEnv, anything else that looks like a variable (e.g.,
+// $HOME) is left alone, as it's most likely meant for a shell inside
+// the container. References that can't be resolved yet (e.g.,
+// ${containerEnv:PATH} before the container's environment has been
+// probed) are left as-is, so a later pass can take care of them.
+func (p *DevcontainerParser) SubstituteVariables(v string) string {
+	return specVariablePattern.ReplaceAllStringFunc(v, func(ref string) string {
+		match := specVariablePattern.FindStringSubmatch(ref)
+		if val, ok := p.resolveSpecVariable(match[1], match[2], match[3], match[4]); ok {
+			return val
+		}
+		return ref
+	})
+}
+
+// resolveSpecVariable returns the value of the variable named name
+// (or, for env var lookups, varName in the scope named by scope),
+// falling back to def if it's an env var that isn't set.
+//
+// Returns false if the value isn't known yet.
+func (p *DevcontainerParser) resolveSpecVariable(name string, scope string, varName string, def string) (string, bool) {
+	var val string
+	var ok bool
+	switch scope {
+	case "localEnv", "env":
+		val, ok = os.LookupEnv(varName)
+	case "containerEnv":
+		if p.EnvProbeNeeded {
+			return "", false
+		}
+		val, ok = p.EnvVarsContainer[varName]
+	case "remoteEnv":
+		if p.EnvProbeNeeded {
+			return "", false
+		}
+		val, ok = p.EnvVarsRemote[varName]
+	default:
+		switch name {
+		case "devcontainerId":
+			if p.DevcontainerID == nil {
+				return "", false
+			}
+			return *p.DevcontainerID, true
+		case "localWorkspaceFolder":
+			return p.WorkspaceFolder(), true
+		case "localWorkspaceFolderBasename":
+			return filepath.Base(p.WorkspaceFolder()), true
+		case "containerWorkspaceFolder":
+			return p.containerWorkspaceFolder(), true
+		case "containerWorkspaceFolderBasename":
+			return filepath.Base(p.containerWorkspaceFolder()), true
+		}
+	}
+	if !ok || len(val) == 0 {
+		return def, true
+	}
+	return val, true
+}
+
+// shellExpandedFields are the fields of DevcontainerConfig that go
+// through ExpandEnv instead, which supports shell parameter expansion
+// on top of the variables defined by the spec.
+var shellExpandedFields = []string{"ContainerEnv", "Mounts"}
+
+// ResolveContainerEnv records the environment of the running
+// devcontainer and substitutes references to it (e.g.,
+// ${containerEnv:PATH} and ${remoteEnv:HOME}) in remoteEnv, the
+// lifecycle commands, and the rest of the configuration.
+//
+// containerEnv and mounts are left alone, as they've been applied to
+// the container by the time its environment is known; see
+// ProcessSubstitutions.
+//
+// Values in containerEnv and remoteEnv take precedence over ones
+// gathered by an earlier probe (see EnvProbeNeeded).
+func (p *DevcontainerParser) ResolveContainerEnv(containerEnv map[string]string, remoteEnv map[string]string) {
+	if p.EnvVarsContainer == nil {
+		p.EnvVarsContainer = make(map[string]string, len(containerEnv))
+	}
+	maps.Copy(p.EnvVarsContainer, containerEnv)
+	if p.EnvVarsRemote == nil {
+		p.EnvVarsRemote = make(map[string]string, len(remoteEnv))
+	}
+	maps.Copy(p.EnvVarsRemote, remoteEnv)
+	p.EnvProbeNeeded = false
+	p.substituteVariables()
+}
+
+// substituteVariables runs SubstituteVariables on every string in the
+// parsed configuration, save for those in shellExpandedFields.
+func (p *DevcontainerParser) substituteVariables() {
+	slog.Debug("substituting devcontainer spec variables")
+	cfg := reflect.ValueOf(&p.Config).Elem()
+	for i := range cfg.NumField() {
+		if slices.Contains(shellExpandedFields, cfg.Type().Field(i).Name) {
+			continue
+		}
+		substituteStrings(cfg.Field(i), p.SubstituteVariables)
+	}
+}
+
+// substituteStrings replaces every string reachable from v with the
+// result of passing it to substitute.
+//
+// v has to be settable; values held in maps and interfaces aren't, so
+// they're copied, substituted, and put back.
+func substituteStrings(v reflect.Value, substitute func(string) string) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			substituteStrings(v.Elem(), substitute)
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		substituteStrings(elem, substitute)
+		v.Set(elem)
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				substituteStrings(v.Field(i), substitute)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			substituteStrings(v.Index(i), substitute)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			substituteStrings(elem, substitute)
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(substitute(v.String()))
+		}
+	}
+}
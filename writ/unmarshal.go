@@ -19,9 +19,6 @@ package writ
 import (
 	"encoding/json"
 	"fmt"
-
-	dockeropts "github.com/docker/cli/opts"
-	dockermounts "github.com/docker/docker/volume/mounts"
 )
 
 // UnmarshalJSON for the AppPort type
@@ -81,7 +78,7 @@ func (c *CacheFrom) UnmarshalJSON(data []byte) error {
 		c.StringArray = elements
 
 	case string:
-		*c.String = v
+		c.String = &v
 
 	default:
 		return fmt.Errorf("unsupported type: %#v for value %#v", v, raw)
@@ -246,7 +243,10 @@ func (l *LifecycleCommand) UnmarshalJSON(data []byte) error {
 func (m *MobyMount) UnmarshalJSON(data []byte) error {
 	type mobyMount MobyMount
 	if len(data) > 0 && data[0] == '{' {
-		return json.Unmarshal(data, (*mobyMount)(m))
+		if err := json.Unmarshal(data, (*mobyMount)(m)); err != nil {
+			return err
+		}
+		return m.validateRelabel()
 	}
 
 	var mountString string
@@ -254,23 +254,10 @@ func (m *MobyMount) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	// Try parsing as the CSV type
-	mountOpt := dockeropts.MountOpt{}
-	if err := mountOpt.Set(mountString); err == nil {
-		*m = (MobyMount)(mountOpt.Value()[0])
+	parsedMount, err := ParseMountString(mountString)
+	if err != nil {
 		return err
 	}
-
-	// Try parsing as the short version
-	dockerParser := dockermounts.NewParser()
-	mountPt, err := dockerParser.ParseMountRaw(mountString, "")
-	if err == nil {
-		specJSON, err := json.Marshal(mountPt.Spec)
-		if err != nil {
-			return err
-		}
-		return json.Unmarshal(specJSON, m)
-	}
-
-	return fmt.Errorf("unable to parse '%s' as a mount string", mountString)
+	*m = *parsedMount
+	return nil
 }
@@ -0,0 +1,482 @@
+/*
+   writ: a devcontainer.json parser
+   Copyright (C) 2025  Neil Santos
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+*/
+
+// Package writ houses a validating parser for devcontainer.json files
+package writ
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"github.com/tailscale/hujson"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// ValidationProblem is a way in which a config fails to conform to its
+// JSON Schema, tied back to where it is in the file.
+type ValidationProblem struct {
+	Pointer    string // JSON Pointer to the offending value, e.g., /forwardPorts/0
+	Message    string // What's wrong with it
+	Line       int    // The line it's on, counting from 1; 0 if it's not in the file (e.g., it came from an overlay)
+	Column     int    // The column it starts at on Line, in characters, counting from 1
+	Source     string // The contents of Line, without its line ending
+	Suggestion string // A likely fix, if one could be worked out
+}
+
+// ValidationProblems breaks an error returned by Validate down into
+// the problems with the config it's made up of, in the order they
+// appear in the file.
+//
+// Where the config could have conformed to one of several alternatives
+// in the schema (e.g., an image-based or a Compose-based
+// devcontainer), only the problems with the alternatives it comes
+// closest to are kept. Properties the schema doesn't know of are
+// reported where their names are, along with the closest known name,
+// if there's one that's close enough to be a likely misspelling.
+//
+// Returns nil if err didn't come from schema validation.
+func (p *Parser) ValidationProblems(err error) []ValidationProblem {
+	var valErr *jsonschema.ValidationError
+	if !errors.As(err, &valErr) {
+		return nil
+	}
+	var schema any
+	if err := json.Unmarshal([]byte(p.jsonSchema), &schema); err != nil {
+		schema = nil
+	}
+	// Positions are taken from the file as written; values that only
+	// exist in an overlay are pinned on the closest value that does
+	root, err := hujson.Parse(p.source)
+	if err != nil {
+		root = hujson.Value{}
+	}
+	printer := message.NewPrinter(language.English)
+
+	var problems []ValidationProblem
+	for _, leaf := range mergeTypeErrors(closestValidationErrors(valErr, schema)) {
+		for _, problem := range newValidationProblems(leaf, schema, printer) {
+			problem.locate(&root, p.source)
+			if !slices.ContainsFunc(problems, func(other ValidationProblem) bool {
+				return other.Pointer == problem.Pointer && other.Message == problem.Message
+			}) {
+				problems = append(problems, problem)
+			}
+		}
+	}
+	slices.SortStableFunc(problems, func(a, b ValidationProblem) int {
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return a.Column - b.Column
+	})
+	return problems
+}
+
+// closestValidationErrors returns the errors at the leaves of the tree
+// of errors rooted at valErr, leaving out those of the alternatives of
+// a oneOf or anyOf the instance is furthest from matching: those with
+// the most errors about the value the alternatives are for itself
+// (e.g., required properties that are missing), rather than about
+// something inside it. Of alternatives that are just as far off, the
+// ones with more of their required properties present, then the ones
+// that got further into the value, are kept.
+//
+// schema is the JSON Schema valErr came from, as decoded by
+// encoding/json.
+func closestValidationErrors(valErr *jsonschema.ValidationError, schema any) []*jsonschema.ValidationError {
+	if len(valErr.Causes) == 0 {
+		return []*jsonschema.ValidationError{valErr}
+	}
+	switch valErr.ErrorKind.(type) {
+	case *kind.OneOf, *kind.AnyOf:
+	default:
+		var leaves []*jsonschema.ValidationError
+		for _, cause := range valErr.Causes {
+			leaves = append(leaves, closestValidationErrors(cause, schema)...)
+		}
+		return leaves
+	}
+
+	var leaves []*jsonschema.ValidationError
+	var closest []int // Shallow errors (negated), required properties present, and deep errors
+	for _, cause := range valErr.Causes {
+		causeLeaves := closestValidationErrors(cause, schema)
+		var shallow, present, deep int
+		for _, leaf := range causeLeaves {
+			switch k := leaf.ErrorKind.(type) {
+			case *kind.AdditionalProperties:
+				shallow += len(k.Properties)
+			case *kind.Required:
+				shallow += len(k.Missing)
+				if node, ok := schemaNode(schema, leaf.SchemaURL).(map[string]any); ok {
+					if required, ok := node["required"].([]any); ok {
+						present += len(required) - len(k.Missing)
+					}
+				}
+			default:
+				if len(leaf.InstanceLocation) > len(valErr.InstanceLocation) {
+					deep++
+				} else {
+					shallow++
+				}
+			}
+		}
+		switch score := []int{-shallow, present, deep}; {
+		case closest == nil || slices.Compare(score, closest) > 0:
+			closest, leaves = score, causeLeaves
+		case slices.Equal(score, closest):
+			leaves = append(leaves, causeLeaves...)
+		}
+	}
+	return leaves
+}
+
+// mergeTypeErrors returns leaves with errors about the type of the
+// same value (e.g., from each of the alternatives of an anyOf) merged
+// into one, wanting any of the types they do.
+func mergeTypeErrors(leaves []*jsonschema.ValidationError) []*jsonschema.ValidationError {
+	var merged []*jsonschema.ValidationError
+	for _, leaf := range leaves {
+		typeErr, ok := leaf.ErrorKind.(*kind.Type)
+		if !ok {
+			merged = append(merged, leaf)
+			continue
+		}
+		idx := slices.IndexFunc(merged, func(other *jsonschema.ValidationError) bool {
+			_, ok := other.ErrorKind.(*kind.Type)
+			return ok && slices.Equal(other.InstanceLocation, leaf.InstanceLocation)
+		})
+		if idx < 0 {
+			merged = append(merged, &jsonschema.ValidationError{
+				SchemaURL:        leaf.SchemaURL,
+				InstanceLocation: leaf.InstanceLocation,
+				ErrorKind:        &kind.Type{Got: typeErr.Got, Want: slices.Clone(typeErr.Want)},
+			})
+			continue
+		}
+		mergedErr := merged[idx].ErrorKind.(*kind.Type)
+		for _, want := range typeErr.Want {
+			if !slices.Contains(mergedErr.Want, want) {
+				mergedErr.Want = append(mergedErr.Want, want)
+			}
+		}
+	}
+	return merged
+}
+
+// schemaNode returns the part of schema, a JSON Schema as decoded by
+// encoding/json, the fragment of schemaURL (e.g.,
+// "file:///schema.json#/definitions/image") points to; nil if there
+// isn't one.
+func schemaNode(schema any, schemaURL string) any {
+	_, fragment, found := strings.Cut(schemaURL, "#")
+	if !found {
+		return nil
+	}
+	node := schema
+	for _, token := range strings.Split(strings.TrimPrefix(fragment, "/"), "/") {
+		if len(token) == 0 {
+			continue
+		}
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch n := node.(type) {
+		case map[string]any:
+			node = n[token]
+		case []any:
+			idx, err := strconv.Atoi(token)
+			if err != nil || idx < 0 || idx >= len(n) {
+				return nil
+			}
+			node = n[idx]
+		default:
+			return nil
+		}
+	}
+	return node
+}
+
+// newValidationProblems returns the problems leaf, an error at a leaf
+// of the tree of errors returned by Validate, stands for, unlocated;
+// errors about properties the schema doesn't allow are split up per
+// property, leaving out the ones that are only disallowed because the
+// rest of their object didn't validate.
+func newValidationProblems(leaf *jsonschema.ValidationError, schema any, printer *message.Printer) []ValidationProblem {
+	unknownProperty := func(parent []string, name string) (ValidationProblem, bool) {
+		known := schemaPropertyNames(schema, parent)
+		if slices.Contains(known, name) {
+			return ValidationProblem{}, false
+		}
+		problem := ValidationProblem{
+			Pointer: jsonPointer(append(slices.Clone(parent), name)),
+			Message: fmt.Sprintf("unknown property %q", name),
+		}
+		if suggestion := closestString(name, known); len(suggestion) > 0 {
+			problem.Suggestion = fmt.Sprintf("did you mean %q?", suggestion)
+		}
+		return problem, true
+	}
+
+	location := leaf.InstanceLocation
+	switch k := leaf.ErrorKind.(type) {
+	case *kind.AdditionalProperties:
+		var problems []ValidationProblem
+		for _, name := range k.Properties {
+			if problem, ok := unknownProperty(location, name); ok {
+				problems = append(problems, problem)
+			}
+		}
+		return problems
+
+	case *kind.FalseSchema:
+		// unevaluatedProperties: false and additionalProperties: false
+		// are reported against the property itself
+		if len(location) > 0 && (strings.HasSuffix(leaf.SchemaURL, "/unevaluatedProperties") || strings.HasSuffix(leaf.SchemaURL, "/additionalProperties")) {
+			if problem, ok := unknownProperty(location[:len(location)-1], location[len(location)-1]); ok {
+				return []ValidationProblem{problem}
+			}
+			return nil
+		}
+
+	case *kind.Enum:
+		problem := ValidationProblem{Pointer: jsonPointer(location), Message: k.LocalizedString(printer)}
+		if got, ok := k.Got.(string); ok {
+			var want []string
+			for _, value := range k.Want {
+				if value, ok := value.(string); ok {
+					want = append(want, value)
+				}
+			}
+			if suggestion := closestString(got, want); len(suggestion) > 0 {
+				problem.Suggestion = fmt.Sprintf("did you mean %q?", suggestion)
+			}
+		}
+		return []ValidationProblem{problem}
+	}
+	return []ValidationProblem{{Pointer: jsonPointer(location), Message: leaf.ErrorKind.LocalizedString(printer)}}
+}
+
+// locate sets where problem is in source, the contents of the file,
+// parsed into root.
+//
+// Problems with property names (see newValidationProblems) point at
+// the name, rather than the value.
+func (problem *ValidationProblem) locate(root *hujson.Value, source []byte) {
+	if len(source) == 0 {
+		return
+	}
+	offset := -1
+	pointer := problem.Pointer
+	for offset < 0 {
+		if value := root.Find(pointer); value != nil {
+			offset = value.StartOffset
+			if pointer == problem.Pointer && strings.HasPrefix(problem.Message, "unknown property") {
+				offset = memberNameOffset(root, pointer, offset)
+			}
+			break
+		}
+		if len(pointer) == 0 {
+			return
+		}
+		pointer = pointer[:strings.LastIndexByte(pointer, '/')]
+	}
+
+	lineStart := strings.LastIndexByte(string(source[:offset]), '\n') + 1
+	lineEnd := len(source)
+	if idx := strings.IndexByte(string(source[offset:]), '\n'); idx >= 0 {
+		lineEnd = offset + idx
+	}
+	problem.Line = strings.Count(string(source[:offset]), "\n") + 1
+	problem.Column = utf8.RuneCount(source[lineStart:offset]) + 1
+	problem.Source = strings.TrimSuffix(string(source[lineStart:lineEnd]), "\r")
+}
+
+// memberNameOffset returns the offset of the name of the object member
+// pointer refers to, or fallback if it can't be found.
+func memberNameOffset(root *hujson.Value, pointer string, fallback int) int {
+	idx := strings.LastIndexByte(pointer, '/')
+	parent := root.Find(pointer[:idx])
+	if parent == nil {
+		return fallback
+	}
+	obj, ok := parent.Value.(*hujson.Object)
+	if !ok {
+		return fallback
+	}
+	name := strings.NewReplacer("~1", "/", "~0", "~").Replace(pointer[idx+1:])
+	for _, member := range obj.Members {
+		if literal, ok := member.Name.Value.(hujson.Literal); ok && literal.String() == name {
+			return member.Name.StartOffset
+		}
+	}
+	return fallback
+}
+
+// jsonPointer returns the JSON Pointer (RFC 6901) made up of tokens.
+func jsonPointer(tokens []string) string {
+	var b strings.Builder
+	escaper := strings.NewReplacer("~", "~0", "/", "~1")
+	for _, token := range tokens {
+		b.WriteString("/")
+		b.WriteString(escaper.Replace(token))
+	}
+	return b.String()
+}
+
+// schemaPropertyNames returns the names of the properties schema, a
+// JSON Schema as decoded by encoding/json, defines for objects found
+// at path, sorted.
+//
+// Only the parts of the schema that can apply to an object (e.g., the
+// alternatives of a oneOf, and local references) are followed; this
+// is meant for suggestions, and errs on the side of including names.
+func schemaPropertyNames(schema any, path []string) []string {
+	nodes := expandSchemaNodes(schema, []any{schema})
+	for _, token := range path {
+		var next []any
+		for _, node := range nodes {
+			obj, ok := node.(map[string]any)
+			if !ok {
+				continue
+			}
+			if props, ok := obj["properties"].(map[string]any); ok {
+				if sub, ok := props[token]; ok {
+					next = append(next, sub)
+					continue
+				}
+			}
+			if patterns, ok := obj["patternProperties"].(map[string]any); ok {
+				for pattern, sub := range patterns {
+					if re, err := regexp.Compile(pattern); err == nil && re.MatchString(token) {
+						next = append(next, sub)
+					}
+				}
+			}
+			if sub, ok := obj["additionalProperties"].(map[string]any); ok {
+				next = append(next, sub)
+			}
+			if _, err := strconv.Atoi(token); err == nil {
+				if sub, ok := obj["items"].(map[string]any); ok {
+					next = append(next, sub)
+				}
+			}
+		}
+		nodes = expandSchemaNodes(schema, next)
+	}
+
+	var names []string
+	for _, node := range nodes {
+		if obj, ok := node.(map[string]any); ok {
+			if props, ok := obj["properties"].(map[string]any); ok {
+				for name := range props {
+					if !slices.Contains(names, name) {
+						names = append(names, name)
+					}
+				}
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// expandSchemaNodes returns nodes, along with every subschema that
+// applies to the same value as one of them does, through allOf, anyOf,
+// oneOf, if/then/else, and local references ("#/...") within root.
+func expandSchemaNodes(root any, nodes []any) []any {
+	var expanded []any
+	var expand func(node any, depth int)
+	expand = func(node any, depth int) {
+		obj, ok := node.(map[string]any)
+		// Guards against references that loop back on themselves
+		if !ok || depth > 32 {
+			return
+		}
+		expanded = append(expanded, obj)
+		for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+			if subs, ok := obj[keyword].([]any); ok {
+				for _, sub := range subs {
+					expand(sub, depth+1)
+				}
+			}
+		}
+		for _, keyword := range []string{"then", "else"} {
+			if sub, ok := obj[keyword]; ok {
+				expand(sub, depth+1)
+			}
+		}
+		if ref, ok := obj["$ref"].(string); ok && strings.HasPrefix(ref, "#/") {
+			target := root
+			for _, token := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+				targetObj, ok := target.(map[string]any)
+				if !ok {
+					return
+				}
+				target = targetObj[strings.NewReplacer("~1", "/", "~0", "~").Replace(token)]
+			}
+			expand(target, depth+1)
+		}
+	}
+	for _, node := range nodes {
+		expand(node, 0)
+	}
+	return expanded
+}
+
+// closestString returns the candidate s is most likely a misspelling
+// of, going by their edit distance, ignoring case; an empty string if
+// none of them are close enough.
+func closestString(s string, candidates []string) string {
+	var closest string
+	closestDistance := max(1, utf8.RuneCountInString(s)/3) + 1
+	for _, candidate := range candidates {
+		if distance := editDistance(strings.ToLower(s), strings.ToLower(candidate)); distance < closestDistance {
+			closest, closestDistance = candidate, distance
+		}
+	}
+	return closest
+}
+
+// editDistance returns the Levenshtein distance between a and b: the
+// fewest insertions, deletions, and substitutions of characters it
+// takes to turn one into the other.
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
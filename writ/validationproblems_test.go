@@ -0,0 +1,45 @@
+package writ
+
+import (
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestValidationProblems checks that schema validation errors are
+// mapped back to where they are in the file, leaving out the noise
+// from alternatives the config doesn't come close to matching, with
+// likely fixes suggested for misspellings.
+func TestValidationProblems(t *testing.T) {
+	// Silence slog output for the duration of the run
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	p, err := NewDevcontainerParser(filepath.Join("testdata", "validate", "invalid-misspelled-property.json"))
+	assert.Nil(t, err)
+	err = p.Validate()
+	assert.ErrorIs(t, err, ErrSchemaValidation)
+
+	problems := p.ValidationProblems(err)
+	assert.Equal(t, []ValidationProblem{
+		{Pointer: "", Message: "missing property 'image'", Line: 1, Column: 1, Source: "{"},
+		{Pointer: "/imagee", Message: `unknown property "imagee"`, Line: 4, Column: 3, Source: `  "imagee": "mcr.microsoft.com/devcontainers/base:ubuntu",`, Suggestion: `did you mean "image"?`},
+		{Pointer: "/forwardPorts", Message: "got string, want array", Line: 5, Column: 19, Source: `  "forwardPorts": "3000",`},
+		{Pointer: "/runArgs/1", Message: "got number, want string", Line: 6, Column: 25, Source: `  "runArgs": ["--init", 1],`},
+		{Pointer: "/shutdownAction", Message: "value must be one of 'none', 'stopContainer'", Line: 7, Column: 21, Source: `  "shutdownAction": "stopContainr"`, Suggestion: `did you mean "stopContainer"?`},
+	}, problems)
+
+	assert.Nil(t, p.ValidationProblems(nil))
+}
+
+// TestEditDistance checks the distances suggestions are based on.
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("image", "image"))
+	assert.Equal(t, 1, editDistance("imagee", "image"))
+	assert.Equal(t, 2, editDistance("sevrice", "service"))
+	assert.Equal(t, 5, editDistance("", "image"))
+	assert.Equal(t, "service", closestString("Servce", []string{"image", "service", "runServices"}))
+	assert.Empty(t, closestString("volumes", []string{"image", "service"}))
+}
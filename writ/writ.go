@@ -18,11 +18,13 @@ package writ
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/santhosh-tekuri/jsonschema/v6"
@@ -38,6 +40,7 @@ type Parser struct {
 	defaultValues    map[string]any // Default values of various fields keyed by their name
 	jsonSchema       string         // Contents of the JSON schema to validate against
 	jsonSchemaPath   string         // Path used for the JSON schema when being added as a resource
+	source           []byte         // The contents of the target JSON file, as written
 	standardizedJSON []byte         // The raw contents of the target devcontainer.json, converted to standard JSON
 }
 
@@ -58,6 +61,10 @@ func NewParser(configPath string) (p *Parser, err error) {
 	return p, nil
 }
 
+// ErrSchemaValidation is wrapped by the error Validate returns when
+// a config doesn't conform to its JSON Schema.
+var ErrSchemaValidation = errors.New("config failed schema validation")
+
 // Validate runs the contents of the target devcontainer.json against
 // a snapshot of the devcontainer spec's official JSON Schema.
 //
@@ -91,13 +98,55 @@ func (p *Parser) Validate() error {
 
 	if err = sch.Validate(valInput); err != nil {
 		slog.Error("specified devcontainer.json failed schema validation", "path", p.Filepath)
-		return err
+		return fmt.Errorf("%w: %w", ErrSchemaValidation, err)
 	}
 
 	p.IsValidConfig = true
 	return nil
 }
 
+// ValidationErrorMessages flattens an error returned by Validate into
+// a list of human-readable messages, each prefixed with the location
+// in the document it refers to.
+//
+// Errors that didn't come from schema validation (e.g., the file
+// couldn't be read) are returned as a single message.
+func ValidationErrorMessages(err error) []string {
+	if err == nil {
+		return nil
+	}
+	var valErr *jsonschema.ValidationError
+	if !errors.As(err, &valErr) {
+		return []string{err.Error()}
+	}
+
+	var msgs []string
+	for _, unit := range valErr.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		location := unit.InstanceLocation
+		if len(location) == 0 {
+			location = "/"
+		}
+		msg := fmt.Sprintf("%s: %s", location, unit.Error.String())
+		if !slices.Contains(msgs, msg) {
+			msgs = append(msgs, msg)
+		}
+	}
+	if len(msgs) == 0 {
+		msgs = append(msgs, valErr.Error())
+	}
+	return msgs
+}
+
+// JSON returns the contents of the target JSON config as standard
+// JSON, i.e., with comments and trailing commas stripped, as it was
+// read (and overlaid; see ApplyOverlay).
+func (p *Parser) JSON() []byte {
+	return bytes.Clone(p.standardizedJSON)
+}
+
 // Convert the contents of the target JSON config, which could be
 // JSONC, into standard JSON suitable for validation and parsing.
 func (p *Parser) standardizeJSON() error {
@@ -119,6 +168,7 @@ func (p *Parser) standardizeJSON() error {
 		return err
 	}
 
+	p.source = bytes.Clone(fileInput)
 	if p.standardizedJSON, err = hujson.Standardize(fileInput); err != nil {
 		slog.Error("failed to standardize JSON config contents", "error", err, "path", p.Filepath)
 		return err